@@ -0,0 +1,193 @@
+package webmention
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// An AcceptRule decides whether mentions of targets matching its path pattern are accepted.
+	AcceptRule struct {
+		// Pattern is matched against the path of the target, * matches any
+		// number of characters (including slashes).
+		Pattern string
+		// Deny rejects all targets matching Pattern.
+		Deny bool
+		// MaxAge closes targets that are older than this, the date of a
+		// target is taken from its path (see AcceptRules.DatePattern).
+		// Targets without a date in their path are not affected.
+		MaxAge time.Duration
+		// Closes rejects mentions from this point in time on.
+		Closes time.Time
+
+		pattern *regexp.Regexp
+	}
+
+	// AcceptRules are evaluated in order, the first rule matching the path
+	// of a target decides whether it is accepted.
+	// If no rule matches, the target is accepted.
+	//
+	// Rules can be written down in a text file, one rule per line:
+	//
+	//	# drafts never accept mentions
+	//	deny /drafts/*
+	//	# close notes after a year, and the guestbook at the end of 2025
+	//	allow /notes/* max-age=1y
+	//	allow /guestbook closes=2025-12-31
+	//	allow *
+	//
+	// max-age accepts years (y), days (d), or anything time.ParseDuration does.
+	// closes is a date (closing at midnight UTC), or an RFC 3339 timestamp.
+	AcceptRules struct {
+		Rules []AcceptRule
+		// DatePattern extracts the date of a post from its path, it must
+		// have three submatches: year, month, and day (which may be empty).
+		// Defaults to DefaultDatePattern, matching paths like /2024/11/05/title.
+		DatePattern *regexp.Regexp
+	}
+)
+
+// DefaultDatePattern matches dates in paths like /2024/11/05/title or /2024/11/title.
+var DefaultDatePattern = regexp.MustCompile(`/(\d{4})/(\d{2})(?:/(\d{2}))?(?:/|$)`)
+
+// ParseAcceptRules parses rules in the format described at AcceptRules.
+func ParseAcceptRules(r io.Reader) (*AcceptRules, error) {
+	rules := &AcceptRules{DatePattern: DefaultDatePattern}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("accept rules: line %d: expected: allow|deny PATTERN [OPTION=VALUE...]", line)
+		}
+		var rule AcceptRule
+		switch fields[0] {
+		case "allow":
+		case "deny":
+			rule.Deny = true
+		default:
+			return nil, fmt.Errorf("accept rules: line %d: expected allow or deny, got: %s", line, fields[0])
+		}
+		rule.Pattern = fields[1]
+		for _, option := range fields[2:] {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "max-age":
+				age, err := parseAge(value)
+				if err != nil {
+					return nil, fmt.Errorf("accept rules: line %d: max-age: %w", line, err)
+				}
+				rule.MaxAge = age
+			case "closes":
+				closes, err := time.Parse(time.DateOnly, value)
+				if err != nil {
+					closes, err = time.Parse(time.RFC3339, value)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("accept rules: line %d: closes: expected date or RFC 3339 timestamp", line)
+				}
+				rule.Closes = closes
+			default:
+				return nil, fmt.Errorf("accept rules: line %d: unknown option: %s", line, key)
+			}
+		}
+		rules.Rules = append(rules.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("accept rules: %w", err)
+	}
+	return rules, nil
+}
+
+// parseAge parses durations with the additional units y (365 days) and d.
+func parseAge(value string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"y": 365 * 24 * time.Hour, "d": 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(value, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid age: %s", value)
+			}
+			return time.Duration(count) * unit, nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// Accepts reports whether mentions of target are accepted at the time now.
+func (rules *AcceptRules) Accepts(target URL, now time.Time) bool {
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		pattern := rule.pattern
+		if pattern == nil { // not compiled yet
+			pattern = compilePattern(rule.Pattern)
+		}
+		if !pattern.MatchString(target.Path) {
+			continue
+		}
+		if rule.Deny {
+			return false
+		}
+		if !rule.Closes.IsZero() && !now.Before(rule.Closes) {
+			return false
+		}
+		if rule.MaxAge > 0 {
+			if published, ok := rules.date(target); ok && now.Sub(published) > rule.MaxAge {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// Compile returns a TargetAcceptsFunc accepting mentions only if both base and the rules accept them.
+// base may be nil.
+func (rules *AcceptRules) Compile(base TargetAcceptsFunc) TargetAcceptsFunc {
+	for i := range rules.Rules {
+		rules.Rules[i].pattern = compilePattern(rules.Rules[i].Pattern)
+	}
+	return func(source, target URL) bool {
+		if base != nil && !base(source, target) {
+			return false
+		}
+		return rules.Accepts(target, time.Now())
+	}
+}
+
+// date extracts the date of a post from the path of target.
+func (rules *AcceptRules) date(target URL) (time.Time, bool) {
+	pattern := rules.DatePattern
+	if pattern == nil {
+		pattern = DefaultDatePattern
+	}
+	m := pattern.FindStringSubmatch(target.Path)
+	if len(m) < 3 {
+		return time.Time{}, false
+	}
+	day := "01"
+	if len(m) > 3 && m[3] != "" {
+		day = m[3]
+	}
+	date, err := time.Parse(time.DateOnly, m[1]+"-"+m[2]+"-"+day)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// compilePattern turns a pattern where * matches anything into an anchored regular expression.
+func compilePattern(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
@@ -0,0 +1,47 @@
+package webmention_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestAcceptRules(t *testing.T) {
+	rules := must(webmention.ParseAcceptRules(strings.NewReader(`
+# drafts never accept mentions
+deny /drafts/*
+allow /notes/* max-age=1y
+allow /guestbook closes=2025-12-31
+deny /private
+`)))
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, testCase := range []struct {
+		target   string
+		accepted bool
+	}{
+		{"https://example.com/drafts/secret", false},
+		{"https://example.com/notes/2025/01/15/recent", true},
+		{"https://example.com/notes/2023/01/15/old", false},
+		{"https://example.com/notes/2024/05/old-without-day", false},
+		{"https://example.com/notes/undated", true},
+		{"https://example.com/guestbook", true},
+		{"https://example.com/private", false},
+		{"https://example.com/about", true},
+	} {
+		if accepted := rules.Accepts(must(url.Parse(testCase.target)), now); accepted != testCase.accepted {
+			t.Errorf("%s: incorrectly accepted: %t, want: %t", testCase.target, accepted, testCase.accepted)
+		}
+	}
+	if rules.Accepts(must(url.Parse("https://example.com/guestbook")), now.AddDate(1, 0, 0)) {
+		t.Errorf("guestbook still accepts mentions after it closed")
+	}
+
+	for _, invalid := range []string{"allow", "permit /x", "allow /x max-age=forever", "deny /x closes=soon", "allow /x color=red"} {
+		if _, err := webmention.ParseAcceptRules(strings.NewReader(invalid)); err == nil {
+			t.Errorf("invalid rule accepted: %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package webmention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tomnomnom/linkheader"
+	"golang.org/x/net/html"
+)
+
+type (
+	// EndpointDiagnosis describes how a page advertises its webmention
+	// endpoint, and which mistakes keep senders from finding it.
+	EndpointDiagnosis struct {
+		// URL is the page, after following redirects.
+		URL URL
+		// Advertisements are all endpoints advertised by the page, those of
+		// the Link header first, then those of the html in document order.
+		Advertisements []Advertisement
+		// Endpoint is the endpoint DiscoverEndpoint uses, nil if none is advertised.
+		Endpoint URL
+		// Problems are human readable descriptions of the mistakes found.
+		Problems []string
+	}
+
+	// Advertisement is an endpoint advertised by a page.
+	Advertisement struct {
+		// Where the endpoint is advertised: "Link header", "<link>" or "<a>".
+		Where string
+		// Href is the endpoint as written.
+		Href string
+		// Endpoint is Href resolved against the page, nil if invalid.
+		Endpoint URL
+	}
+)
+
+// DiagnoseEndpoint reports how target advertises its endpoint, e.g., to
+// check the setup of your own site.
+// In contrast to DiscoverEndpoint, it does not stop at the first endpoint,
+// and also reports common mistakes, such as endpoints inside html comments,
+// or relative endpoints that senders resolve differently.
+// An error is only returned if the target could not be fetched, a target
+// without an endpoint is reported as problem.
+func (sender *Sender) DiagnoseEndpoint(target URL) (*EndpointDiagnosis, error) {
+	ctx, cancel := timeoutContext(sender.discoveryTimeout)
+	defer cancel()
+	diagnosis := &EndpointDiagnosis{URL: target}
+
+	head, err := sender.diagnosisRequest(ctx, http.MethodHead, target)
+	if err != nil {
+		return nil, err
+	}
+	head.Body.Close()
+	get, err := sender.diagnosisRequest(ctx, http.MethodGet, target)
+	if err != nil {
+		return nil, err
+	}
+	defer get.Body.Close()
+	if get.StatusCode < 200 || get.StatusCode >= 300 {
+		return nil, fmt.Errorf("endpoint diagnosis: get returned %s", get.Status)
+	}
+	if get.Request != nil && get.Request.URL != nil {
+		diagnosis.URL = get.Request.URL
+	}
+
+	headLinks, getLinks := relWebmentionHeaders(head.Header), relWebmentionHeaders(get.Header)
+	switch {
+	case head.StatusCode < 200 || head.StatusCode >= 300:
+		diagnosis.problem("a HEAD request returned %s, senders that check the Link header with HEAD may give up", head.Status)
+	case len(headLinks) == 0 && len(getLinks) > 0:
+		diagnosis.problem("the Link header is only sent for GET, not for HEAD requests, most senders check it with HEAD")
+	}
+	headerLinks := getLinks
+	if len(headerLinks) == 0 {
+		headerLinks = headLinks
+	}
+	for _, href := range headerLinks {
+		diagnosis.advertise(target, "Link header", href)
+	}
+
+	doc, err := html.Parse(get.Body)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint diagnosis: cannot parse html: %w", err)
+	}
+	var (
+		traverseHtml func(*html.Node)
+		base         string
+		firstElement string // "<link>" or "<a>", whichever appears first
+	)
+	traverseHtml = func(node *html.Node) {
+		switch node.Type {
+		case html.CommentNode:
+			if comment := strings.ToLower(node.Data); strings.Contains(comment, "rel=") && strings.Contains(comment, "webmention") {
+				diagnosis.problem("an endpoint is advertised inside an html comment, where senders ignore it")
+			}
+		case html.ElementNode:
+			if node.Data == "base" && base == "" {
+				base, _ = attr(node, "href")
+			}
+			if node.Data == "link" || node.Data == "a" {
+				if href, ok := relHref(node, "webmention"); ok {
+					where := "<" + node.Data + ">"
+					if firstElement == "" {
+						firstElement = where
+					}
+					diagnosis.advertise(target, where, href)
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			traverseHtml(child)
+		}
+	}
+	traverseHtml(doc)
+
+	// DiscoverEndpoint prefers the Link header (of the HEAD response), then <link>, then <a>
+	for _, where := range []string{"Link header", "<link>", "<a>"} {
+		if where == "Link header" && len(headLinks) == 0 {
+			continue
+		}
+		if adv, ok := diagnosis.first(where); ok {
+			diagnosis.Endpoint = adv.Endpoint
+			break
+		}
+	}
+	if link, ok := diagnosis.first("<link>"); ok && firstElement == "<a>" && len(headerLinks) == 0 {
+		if a, _ := diagnosis.first("<a>"); a.Href != link.Href {
+			diagnosis.problem("an <a> endpoint (%s) appears before the <link> endpoint (%s), senders disagree which one to use", a.Href, link.Href)
+		}
+	}
+
+	distinct := map[string]bool{}
+	for _, adv := range diagnosis.Advertisements {
+		if adv.Endpoint == nil {
+			continue
+		}
+		distinct[adv.Endpoint.String()] = true
+		ref, _ := url.Parse(adv.Href)
+		switch {
+		case adv.Href == "":
+			diagnosis.problem("the %s endpoint is empty, which makes the page itself the endpoint", adv.Where)
+		case !ref.IsAbs() && diagnosis.URL.String() != target.String():
+			diagnosis.problem("the relative %s endpoint %q of a redirected page is resolved to %s against the requested url, but to %s against the final url, use an absolute url",
+				adv.Where, adv.Href, adv.Endpoint, diagnosis.URL.ResolveReference(ref))
+		case !ref.IsAbs() && base != "" && adv.Where != "Link header":
+			diagnosis.problem("the page declares <base href=%q>, senders disagree whether the relative %s endpoint %q is resolved against it, use an absolute url", base, adv.Where, adv.Href)
+		}
+		if scheme := adv.Endpoint.Scheme; scheme != "http" && scheme != "https" {
+			diagnosis.problem("the %s endpoint %s is not an http(s) url", adv.Where, adv.Endpoint)
+		} else if target.Scheme == "https" && scheme == "http" {
+			diagnosis.problem("the %s endpoint %s is insecure, senders with downgrade protection refuse it", adv.Where, adv.Endpoint)
+		}
+	}
+	if len(distinct) > 1 {
+		diagnosis.problem("the page advertises %d different endpoints, only one of them is used", len(distinct))
+	}
+	if diagnosis.Endpoint == nil {
+		diagnosis.problem("no valid endpoint is advertised")
+	}
+	return diagnosis, nil
+}
+
+func (sender *Sender) diagnosisRequest(ctx context.Context, method string, target URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint diagnosis: %w", err)
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", sender.UserAgent)
+	resp, err := sender.HttpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint diagnosis: %w", err)
+	}
+	return resp, nil
+}
+
+// relWebmentionHeaders returns the urls of all webmention links in header.
+func relWebmentionHeaders(header http.Header) (hrefs []string) {
+	for _, l := range linkheader.ParseMultiple(header.Values("Link")) {
+		for _, rel := range strings.Fields(l.Rel) {
+			if strings.EqualFold(rel, "webmention") {
+				hrefs = append(hrefs, l.URL)
+				break
+			}
+		}
+	}
+	return hrefs
+}
+
+// advertise adds an endpoint to the diagnosis, resolved like DiscoverEndpoint does.
+func (diagnosis *EndpointDiagnosis) advertise(target URL, where, href string) {
+	adv := Advertisement{Where: where, Href: href}
+	if ref, err := url.Parse(href); err != nil {
+		diagnosis.problem("the %s endpoint %q is not a valid url: %s", where, href, err)
+	} else {
+		adv.Endpoint = target.ResolveReference(ref)
+	}
+	diagnosis.Advertisements = append(diagnosis.Advertisements, adv)
+}
+
+// first returns the first valid advertisement found at where.
+func (diagnosis *EndpointDiagnosis) first(where string) (Advertisement, bool) {
+	for _, adv := range diagnosis.Advertisements {
+		if adv.Where == where && adv.Endpoint != nil {
+			return adv, true
+		}
+	}
+	return Advertisement{}, false
+}
+
+func (diagnosis *EndpointDiagnosis) problem(format string, args ...any) {
+	diagnosis.Problems = append(diagnosis.Problems, fmt.Sprintf(format, args...))
+}
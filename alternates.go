@@ -0,0 +1,138 @@
+package webmention
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	mimelib "mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxAlternates limits how many alternate representations of a source are checked.
+const maxAlternates = 3
+
+// WithAlternates checks the alternate representations of an html source,
+// before declaring that it doesn't link to the target.
+// Some sites only link in their AMP version (rel=amphtml), their mobile
+// version (rel=alternate with a media query), or, if the source is the AMP
+// version, only in the canonical page (rel=canonical).
+// Only alternates on the same (registrable) domain as the source are
+// fetched, and at most three of them.
+func WithAlternates() ReceiverOption {
+	return func(r *Receiver) {
+		r.checkAlternates = true
+	}
+}
+
+// alternateLinks returns the alternate representations an html source links to.
+func alternateLinks(source URL, sourceData []byte) (alternates []URL) {
+	doc, err := html.Parse(bytes.NewReader(sourceData))
+	if err != nil {
+		return nil
+	}
+	var traverseHtml func(*html.Node)
+	traverseHtml = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "link" && isAlternate(node) {
+			if href, ok := attr(node, "href"); ok {
+				alternate, err := source.Parse(strings.TrimSpace(href))
+				if err == nil &&
+					(alternate.Scheme == "http" || alternate.Scheme == "https") &&
+					registrableDomain(alternate.Hostname()) == registrableDomain(source.Hostname()) &&
+					alternate.String() != source.String() &&
+					!slices.ContainsFunc(alternates, func(u URL) bool { return u.String() == alternate.String() }) {
+					alternates = append(alternates, alternate)
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			traverseHtml(child)
+		}
+	}
+	traverseHtml(doc)
+	return alternates[:min(len(alternates), maxAlternates)]
+}
+
+// isAlternate reports whether the <link> points to another representation of the page.
+func isAlternate(node *html.Node) bool {
+	rel, _ := attr(node, "rel")
+	for _, rel := range strings.Fields(strings.ToLower(rel)) {
+		switch rel {
+		case "amphtml", "canonical":
+			return true
+		case "alternate":
+			// only mobile versions, not translations or feeds
+			_, hasMedia := attr(node, "media")
+			return hasMedia
+		}
+	}
+	return false
+}
+
+func attr(node *html.Node, key string) (string, bool) {
+	for _, a := range node.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// searchAlternates searches the alternate representations of source for a
+// link to target, and returns the content of the first one linking to it.
+func (receiver *Receiver) searchAlternates(mention Mention, sourceData []byte) (alternateData []byte, found bool) {
+	for _, alternate := range alternateLinks(mention.Source, sourceData) {
+		log := slog.With("source", mention.Source.String(), "alternate", alternate.String())
+		data, status, err := receiver.verifyAlternate(alternate, mention)
+		if err != nil {
+			log.Info("alternate representation could not be checked", "error", err)
+			continue
+		}
+		if status == StatusLink {
+			log.Info("link found in alternate representation")
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// verifyAlternate fetches alternate, and checks it for a link to the target of mention.
+func (receiver *Receiver) verifyAlternate(alternate URL, mention Mention) (data []byte, status Status, err error) {
+	req, err := http.NewRequest(http.MethodGet, alternate.String(), nil)
+	if err != nil {
+		return nil, status, err
+	}
+	req.Header.Set("User-Agent", receiver.userAgent)
+	req.Header.Set("Accept", receiver.mediaHandler.String())
+	setTraceParent(req, mention)
+	resp, err := receiver.fetcher.Do(req)
+	if err != nil {
+		return nil, status, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, status, fmt.Errorf("get returned: %s", resp.Status)
+	}
+	mime, _, err := mimelib.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		mime = "text/html"
+	}
+	mediaHandler, ok := receiver.mediaHandler.Get(mime)
+	if !ok {
+		return nil, status, fmt.Errorf("no mime handler registered for: %s", mime)
+	}
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxSourceSize+1))
+	receiver.usage.add(mention.Target.Hostname(), Usage{FetchedBytes: int64(len(data))})
+	if err != nil {
+		return nil, status, err
+	}
+	if len(data) > maxSourceSize {
+		return nil, status, ErrSourceTooLarge
+	}
+	status, err = receiver.runMediaHandler(mime, mediaHandler, data, mention.Target)
+	return data, status, err
+}
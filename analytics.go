@@ -0,0 +1,256 @@
+package webmention
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type (
+	// AnalyticsPolicy decides how much an AnalyticsReport reveals.
+	AnalyticsPolicy struct {
+		// Threshold is the least number of mentions a source domain or a
+		// target must have to be listed by name, the others are only
+		// counted in aggregate (default DefaultAnalyticsThreshold).
+		Threshold int
+		// Epsilon adds Laplace noise of scale 1/Epsilon to every count, so
+		// that a report doesn't reveal whether any single mention was
+		// received, smaller is more private (0: no noise).
+		// Every count is noised independently, sharing a report with a
+		// lot of counts reveals more than Epsilon suggests.
+		Epsilon float64
+		// Secret derives the noise of a count from what is counted, and
+		// the window of the report, so that asking for the same report
+		// again yields the same noise, instead of fresh noise to average
+		// out. The count of a day gets the same noise in every report.
+		// WithAnalyticsPolicy generates a random secret, if none is set,
+		// set it when calling Report directly, the noise of an empty
+		// secret is predictable.
+		Secret []byte
+	}
+
+	// AnalyticsReport is an aggregate of the mentions received, meant for
+	// public stats pages.
+	// It lists no individual mentions, and names only the source domains
+	// and targets with at least AnalyticsPolicy.Threshold mentions.
+	AnalyticsReport struct {
+		Since *time.Time `json:"since,omitempty"`
+		Until *time.Time `json:"until,omitempty"`
+		// Threshold is the threshold of the policy the report was made with.
+		Threshold int                 `json:"threshold"`
+		Total     int                 `json:"total"`
+		Types     map[MentionType]int `json:"types"`
+		// Days are ordered by date, days without mentions are left out.
+		Days []DayCount `json:"days"`
+		// Domains are the registrable domains of the sources, ordered by count.
+		Domains []NamedCount `json:"domains"`
+		// OtherDomains counts the mentions from domains below the threshold.
+		OtherDomains int          `json:"other_domains"`
+		Targets      []NamedCount `json:"targets"`
+		OtherTargets int          `json:"other_targets"`
+	}
+
+	// DayCount counts the mentions received on a day (UTC).
+	DayCount struct {
+		// Day is formatted as 2006-01-02.
+		Day   string              `json:"day"`
+		Count int                 `json:"count"`
+		Types map[MentionType]int `json:"types"`
+	}
+
+	// NamedCount counts the mentions from a source domain, or of a target.
+	NamedCount struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+)
+
+// DefaultAnalyticsThreshold is the threshold of the zero AnalyticsPolicy.
+const DefaultAnalyticsThreshold = 5
+
+// WithAnalyticsPolicy sets the policy of the reports made by
+// Receiver.Analytics and served by RouteAnalytics.
+func WithAnalyticsPolicy(policy AnalyticsPolicy) ReceiverOption {
+	return func(r *Receiver) {
+		if len(policy.Secret) == 0 {
+			policy.Secret = make([]byte, 32)
+			rand.Read(policy.Secret)
+		}
+		r.analytics = policy
+	}
+}
+
+// Analytics reports on the stored mentions linking to their target, that
+// were received at or after since, and before until (zero: unbounded).
+// The window is widened to whole days (UTC), so that reports can only be
+// made for a few windows, and can't be told apart by a single mention.
+func (receiver *Receiver) Analytics(since, until time.Time) (AnalyticsReport, error) {
+	if receiver.storage == nil {
+		return AnalyticsReport{}, ErrNoStorage
+	}
+	if !since.IsZero() {
+		since = since.UTC().Truncate(24 * time.Hour)
+	}
+	if !until.IsZero() {
+		if day := until.UTC().Truncate(24 * time.Hour); day.Equal(until) {
+			until = day
+		} else {
+			until = day.Add(24 * time.Hour)
+		}
+	}
+	mentions, err := receiver.storage.Mentions(MentionFilter{Since: since, Until: until, Status: StatusLink})
+	if err != nil {
+		return AnalyticsReport{}, err
+	}
+	window := since.Format(time.DateOnly) + "/" + until.Format(time.DateOnly)
+	report := receiver.analytics.report(mentions, window)
+	if !since.IsZero() {
+		report.Since = &since
+	}
+	if !until.IsZero() {
+		report.Until = &until
+	}
+	return report, nil
+}
+
+// Report aggregates mentions, regardless of their status.
+func (policy AnalyticsPolicy) Report(mentions []Mention) AnalyticsReport {
+	return policy.report(mentions, "")
+}
+
+// report aggregates mentions, the noise of the counts depends on window,
+// except for that of the days.
+func (policy AnalyticsPolicy) report(mentions []Mention, window string) AnalyticsReport {
+	threshold := policy.Threshold
+	if threshold <= 0 {
+		threshold = DefaultAnalyticsThreshold
+	}
+	var (
+		types   = map[MentionType]int{}
+		days    = map[string]*DayCount{}
+		domains = map[string]int{}
+		targets = map[string]int{}
+	)
+	for _, mention := range mentions {
+		typ := mention.Type
+		if typ == "" {
+			typ = TypeMention
+		}
+		types[typ]++
+		day := mention.Received.UTC().Format(time.DateOnly)
+		if days[day] == nil {
+			days[day] = &DayCount{Day: day, Types: map[MentionType]int{}}
+		}
+		days[day].Count++
+		days[day].Types[typ]++
+		if mention.Source != nil {
+			domains[registrableDomain(mention.Source.Hostname())]++
+		}
+		if mention.Target != nil {
+			targets[mention.Target.String()]++
+		}
+	}
+
+	report := AnalyticsReport{
+		Threshold: threshold,
+		Total:     policy.noised(len(mentions), window, "total"),
+		Types:     policy.noisedTypes(types, window, "types"),
+		Days:      []DayCount{},
+	}
+	for _, day := range days {
+		if count := policy.noised(day.Count, "", "day", day.Day); count > 0 {
+			report.Days = append(report.Days, DayCount{Day: day.Day, Count: count, Types: policy.noisedTypes(day.Types, "", "day", day.Day)})
+		}
+	}
+	slices.SortFunc(report.Days, func(a, b DayCount) int {
+		return cmp.Compare(a.Day, b.Day)
+	})
+	report.Domains, report.OtherDomains = policy.named(domains, threshold, window, "domains")
+	report.Targets, report.OtherTargets = policy.named(targets, threshold, window, "targets")
+	return report
+}
+
+// named lists the names with at least threshold (noised) mentions, and
+// sums up the others.
+// The threshold applies after noise is added, so that whether a name is
+// listed doesn't depend on a single mention either.
+func (policy AnalyticsPolicy) named(counts map[string]int, threshold int, window, bucket string) (listed []NamedCount, other int) {
+	listed = []NamedCount{}
+	for name, count := range counts {
+		if noised := policy.noised(count, window, bucket, name); noised >= threshold {
+			listed = append(listed, NamedCount{Name: name, Count: noised})
+		} else {
+			other += count
+		}
+	}
+	slices.SortFunc(listed, func(a, b NamedCount) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Name, b.Name))
+	})
+	return listed, policy.noised(other, window, bucket, "other")
+}
+
+func (policy AnalyticsPolicy) noisedTypes(types map[MentionType]int, window string, bucket ...string) map[MentionType]int {
+	noised := map[MentionType]int{}
+	for typ, count := range types {
+		if count := policy.noised(count, window, slices.Concat(bucket, []string{string(typ)})...); count > 0 {
+			noised[typ] = count
+		}
+	}
+	return noised
+}
+
+// noised adds Laplace noise to count, it never returns a negative count.
+// The noise is derived from the secret, the window, and the bucket the
+// count is of, so that the same count always gets the same noise.
+func (policy AnalyticsPolicy) noised(count int, window string, bucket ...string) int {
+	if policy.Epsilon <= 0 {
+		return count
+	}
+	mac := hmac.New(sha256.New, policy.Secret)
+	mac.Write([]byte(window + "\n" + strings.Join(bucket, "\n")))
+	// uniform in (-0.5, 0.5), from the first 53 bits
+	u := (float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11)+0.5)/(1<<53) - 0.5
+	noise := -math.Copysign(math.Log(1-2*math.Abs(u)), u) / policy.Epsilon
+	return max(0, int(math.Round(float64(count)+noise)))
+}
+
+func (h *receiverHandler) analytics(w http.ResponseWriter, r *http.Request) {
+	var since, until time.Time
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		value := r.URL.Query().Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			http.Error(w, param.name+": expected RFC 3339 timestamp or date", http.StatusBadRequest)
+			return
+		}
+		*param.t = t
+	}
+	report, err := h.receiver.Analytics(since, until)
+	if err != nil {
+		if errors.Is(err, ErrNoStorage) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", widgetCacheControl)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	writeJSON(w, report)
+}
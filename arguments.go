@@ -0,0 +1,107 @@
+package webmention
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// DuplicateArguments decides how a receiver handles requests that name the
+// source or target more than once, or use array syntax (source[]=...).
+type DuplicateArguments int
+
+const (
+	// RejectDuplicateArguments rejects such requests with an
+	// ErrAmbiguousArgument (the default).
+	RejectDuplicateArguments DuplicateArguments = iota
+	// FirstArgument takes the first value, preferring plain source= over
+	// array syntax, for senders that are sloppy about their requests.
+	FirstArgument
+)
+
+// ErrAmbiguousArgument rejects a request with more than one value for
+// source or target, it is answered with http.StatusBadRequest.
+// It unwraps to an ErrBadRequest.
+type ErrAmbiguousArgument struct {
+	// Name is source or target.
+	Name string
+	// Count is the number of values given.
+	Count int
+	// ArraySyntax is set if (some of) the values were given as name[]=...
+	ArraySyntax bool
+}
+
+var (
+	// ErrAmbiguousArgument implements ErrorResponder
+	_ ErrorResponder = ErrAmbiguousArgument{}
+)
+
+// WithDuplicateArguments sets how requests naming the source or target more
+// than once are handled, by default they are rejected.
+func WithDuplicateArguments(policy DuplicateArguments) ReceiverOption {
+	return func(r *Receiver) {
+		r.duplicateArguments = policy
+	}
+}
+
+func (e ErrAmbiguousArgument) Error() string {
+	return e.Unwrap().Error()
+}
+
+func (e ErrAmbiguousArgument) Unwrap() error {
+	if e.ArraySyntax {
+		return BadRequest(fmt.Sprintf("array syntax is not supported for %[1]s, expected a single value: %[1]s=<url>", e.Name))
+	}
+	return BadRequest(fmt.Sprintf("%s given %d times, expected a single value", e.Name, e.Count))
+}
+
+func (e ErrAmbiguousArgument) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	return e.Unwrap().(ErrorResponder).RespondError(w, r)
+}
+
+// argument returns the single value of name in form.
+func (policy DuplicateArguments) argument(form url.Values, name string) (string, error) {
+	keys := []string{name}
+	arrayKeys := arraySyntaxKeys(form, name)
+	keys = append(keys, arrayKeys...)
+	if policy == FirstArgument {
+		for _, key := range keys {
+			if values := form[key]; len(values) > 0 {
+				return values[0], nil
+			}
+		}
+		return "", BadRequest("missing form value: " + name)
+	}
+	count := 0
+	for _, key := range keys {
+		count += len(form[key])
+	}
+	switch {
+	case count == 0:
+		return "", BadRequest("missing form value: " + name)
+	case len(arrayKeys) > 0:
+		return "", ErrAmbiguousArgument{Name: name, Count: count, ArraySyntax: true}
+	case count > 1:
+		return "", ErrAmbiguousArgument{Name: name, Count: count}
+	}
+	return form[name][0], nil
+}
+
+// arraySyntaxKeys returns the keys of form naming name in array syntax,
+// i.e., name[] or name[index], in order.
+func arraySyntaxKeys(form url.Values, name string) (keys []string) {
+	for key := range form {
+		if isArraySyntax(key, name) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func isArraySyntax(key, name string) bool {
+	index, ok := strings.CutPrefix(key, name+"[")
+	return ok && strings.HasSuffix(index, "]")
+}
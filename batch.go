@@ -0,0 +1,363 @@
+package webmention
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+type (
+	// A Batch sends mentions for a single source.
+	// Artifacts shared between the targets, such as the fetched and parsed
+	// source, and the discovered endpoints, are only computed once per batch.
+	// A batch should be short-lived (its artifacts are never refreshed) and
+	// is safe for concurrent use.
+	Batch struct {
+		Source URL
+		sender *Sender
+
+		sourceOnce sync.Once
+		document   *html.Node
+		links      map[string]bool
+		deleted    bool
+		sourceErr  error
+		// version is the sha256 of the fetched source, "gone" if it was deleted
+		version string
+
+		m          sync.Mutex
+		discovered map[string]discovery
+	}
+
+	discovery struct {
+		endpoint, canonical URL
+		err                 error
+	}
+)
+
+// WithPreflight makes the sender check that the source actually links to a
+// target before mentioning it, instead of leaving it to the receiver to find
+// out that it doesn't.
+// Deleted sources (410 Gone) are not checked.
+func WithPreflight() SenderOption {
+	return func(s *Sender) {
+		s.preflight = true
+	}
+}
+
+// NewBatch starts a batch of mentions from source.
+func (sender *Sender) NewBatch(source URL) *Batch {
+	return &Batch{
+		Source:     source,
+		sender:     sender,
+		discovered: map[string]discovery{},
+	}
+}
+
+// Document returns the parsed html of the source, fetched the first time it is needed.
+// If the source got deleted, nil and ErrSourceDeleted are returned.
+func (b *Batch) Document() (*html.Node, error) {
+	b.sourceOnce.Do(b.fetchSource)
+	if b.deleted {
+		return nil, ErrSourceDeleted
+	}
+	return b.document, b.sourceErr
+}
+
+// LinksTo reports whether the source links to target, the same way a
+// receiver would check (see HtmlHandler), except that relative links are
+// resolved against the source url first.
+func (b *Batch) LinksTo(target URL) (bool, error) {
+	if _, err := b.Document(); err != nil {
+		return false, err
+	}
+	return b.links[strings.ToLower(target.String())], nil
+}
+
+func (b *Batch) fetchSource() {
+	req, err := http.NewRequest(http.MethodGet, b.Source.String(), nil)
+	if err != nil {
+		b.sourceErr = fmt.Errorf("fetch source: %w", err)
+		return
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", b.sender.UserAgent)
+	resp, err := b.sender.HttpClient.Do(req)
+	if err != nil {
+		b.sourceErr = fmt.Errorf("fetch source: %w", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		b.deleted, b.version = true, "gone"
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		b.sourceErr = fmt.Errorf("fetch source: %w: get returned %s", reclassifiedError{ErrSourceNotFound, statusClass(resp.StatusCode)}, resp.Status)
+		return
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		b.sourceErr = fmt.Errorf("fetch source: %w", err)
+		return
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		b.sourceErr = fmt.Errorf("fetch source: cannot parse html: %w", err)
+		return
+	}
+	sum := sha256.Sum256(body)
+	b.document, b.version = doc, hex.EncodeToString(sum[:])
+	b.links = map[string]bool{}
+	// relative links are resolved against the (possibly redirected) source
+	// url, or the document's <base>, which must precede all links
+	base := resp.Request.URL
+	var traverseHtml func(*html.Node)
+	traverseHtml = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.Data {
+			case "base":
+				if href, err := base.Parse(findHref(node)); err == nil {
+					base = href
+				}
+			case "a", "img", "video":
+				if href := findHref(node); href != "" {
+					b.links[strings.ToLower(href)] = true
+					if resolved, err := base.Parse(href); err == nil {
+						b.links[strings.ToLower(resolved.String())] = true
+					}
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			traverseHtml(child)
+		}
+	}
+	traverseHtml(doc)
+}
+
+// sourceVersion identifies the version of the source mentions are sent
+// for, so that a changed or deleted source is not taken for a re-send of
+// the same mention (see WithResendWindow).
+// The source is only fetched for it, if there is a resend window, it is ""
+// if the version is unknown.
+func (b *Batch) sourceVersion() string {
+	if b.sender.resendWindow <= 0 {
+		return ""
+	}
+	b.sourceOnce.Do(b.fetchSource)
+	return b.version
+}
+
+// discover looks up the endpoint and canonical url of target, at most once per batch.
+func (b *Batch) discover(target URL) discovery {
+	key := target.String()
+	b.m.Lock()
+	d, ok := b.discovered[key]
+	b.m.Unlock()
+	if ok {
+		return d
+	}
+	d.endpoint, d.canonical, d.err = b.sender.Discover(target)
+	b.m.Lock()
+	b.discovered[key] = d
+	b.m.Unlock()
+	return d
+}
+
+// preflight checks that the source links to target, if enabled.
+func (b *Batch) preflight(target URL) error {
+	if !b.sender.preflight {
+		return nil
+	}
+	return b.verify(target)
+}
+
+// verify checks that the source links to target.
+func (b *Batch) verify(target URL) error {
+	links, err := b.LinksTo(target)
+	if errors.Is(err, ErrSourceDeleted) {
+		return nil // the receiver is going to be told about the deletion
+	}
+	if err != nil {
+		return fmt.Errorf("preflight: %w", err)
+	}
+	if !links {
+		return fmt.Errorf("preflight: %w: %s", ErrSourceDoesNotLinkToTarget, target)
+	}
+	return nil
+}
+
+// Mention notifies target that it is being linked to by the batch's source.
+func (b *Batch) Mention(target URL) error {
+	if err := b.preflight(target); err != nil {
+		return fmt.Errorf("mention: %w", err)
+	}
+	d := b.discover(target)
+	if d.err != nil {
+		return fmt.Errorf("mention: %w", d.err)
+	}
+	if err := b.sender.send(b.Source, target, d.endpoint, b.sourceVersion()); err != nil && !errors.Is(err, errDeferred) {
+		return err
+	}
+	return nil
+}
+
+// MentionMany calls Mention for each of the targets, continuing on errors.
+func (b *Batch) MentionMany(targets []URL) (err error) {
+	for _, target := range targets {
+		err = errors.Join(err, b.Mention(target))
+	}
+	return err
+}
+
+// Update works like Sender.Update, for the batch's source.
+// Only current targets are subject to the preflight check, past targets are
+// expected to possibly not be linked anymore.
+func (b *Batch) Update(pastTargets, currentTargets []URL) error {
+	_, err := b.UpdateResults(pastTargets, currentTargets)
+	return err
+}
+
+// UpdateResults works like Update, but additionally reports for every
+// target whether it was added, removed, or kept, and whether it was told
+// about it.
+// The returned error includes the errors of all results.
+func (b *Batch) UpdateResults(pastTargets, currentTargets []URL) (results DeliveryResults, err error) {
+	return b.update(pastTargets, currentTargets, false)
+}
+
+// PreviewResults works like UpdateResults, but doesn't post any mentions,
+// it reports what would be sent where instead: endpoints are discovered,
+// and the source is checked to link to the current targets (regardless of
+// WithPreflight), but nothing is sent or persisted.
+// Sent reports whether a mention would be posted, send windows, quotas and
+// the resend window are not taken into account.
+func (b *Batch) PreviewResults(pastTargets, currentTargets []URL) (results DeliveryResults, err error) {
+	return b.update(pastTargets, currentTargets, true)
+}
+
+func (b *Batch) update(pastTargets, currentTargets []URL, dryRun bool) (results DeliveryResults, err error) {
+	sender := b.sender
+	if sender.persister != nil {
+		// without the persisted targets, at least the given ones are informed
+		persisted, err := sender.persister.Targets(b.Source)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("update: persister: %s", err), "source", b.Source.String())
+		}
+		pastTargets = append(persisted, pastTargets...)
+	}
+	pastTargets = sender.externalTargets(b.Source, pastTargets)
+	currentTargets = sender.externalTargets(b.Source, currentTargets)
+
+	type pending struct {
+		target URL
+		discovery
+		linked bool // is a current target, subject to preflight
+		past   bool // was a target of the previous version
+	}
+	var (
+		seen, seenCurrent = map[string]int{}, map[string]bool{}
+		ordered           []pending
+		current           []URL
+	)
+	changed, hash := b.contentChanged()
+	for i, target := range slices.Concat(pastTargets, currentTargets) {
+		d := b.discover(target)
+		canonical := d.canonical
+		if canonical == nil {
+			canonical = target // discovery failed, remember the target as is
+		}
+		key := pageKey(canonical)
+		isCurrent := i >= len(pastTargets)
+		if isCurrent && !seenCurrent[key] {
+			seenCurrent[key] = true
+			current = append(current, canonical)
+		}
+		if idx, ok := seen[key]; ok {
+			if isCurrent && !ordered[idx].linked {
+				// still linked to, possibly under another url
+				ordered[idx].target, ordered[idx].linked = target, true
+			}
+			continue
+		}
+		seen[key] = len(ordered)
+		ordered = append(ordered, pending{target, d, isCurrent, !isCurrent})
+	}
+
+	for _, p := range ordered {
+		result := DeliveryResult{Target: p.target, Canonical: p.canonical, Endpoint: p.endpoint, Change: ChangeRemoved, DryRun: dryRun}
+		if p.linked && p.past {
+			result.Change = ChangeKept
+		} else if p.linked {
+			result.Change = ChangeAdded
+		}
+		if changed || !p.past || !p.linked { // otherwise, there is nothing new to tell this target
+			if dryRun {
+				result.Sent, result.Err = b.dryRun(p.target, p.discovery, p.linked)
+			} else {
+				result.Sent, result.Err = b.deliver(p.target, p.discovery, p.linked)
+			}
+			if errors.Is(result.Err, errDeferred) {
+				result.Deferred, result.Err = true, nil
+			}
+			err = errors.Join(err, result.Err)
+		}
+		results = append(results, result)
+	}
+
+	if dryRun {
+		return results, err
+	}
+	if sender.persister != nil {
+		if perr := sender.persister.SetTargets(b.Source, current); perr != nil {
+			err = errors.Join(err, fmt.Errorf("update: %w", perr))
+		}
+	}
+	// only record the new content once every target has been told about it
+	if persister, ok := sender.persister.(ContentPersister); ok && hash != "" && err == nil {
+		if perr := persister.SetContentHash(b.Source, hash); perr != nil {
+			err = fmt.Errorf("update: %w", perr)
+		}
+	}
+	return results, err
+}
+
+// deliver mentions a single target of an update, linked targets are subject to preflight.
+func (b *Batch) deliver(target URL, d discovery, linked bool) (sent bool, err error) {
+	if d.err != nil {
+		return false, fmt.Errorf("mention: %w", d.err)
+	}
+	if linked {
+		if err := b.preflight(target); err != nil {
+			return false, fmt.Errorf("mention: %w", err)
+		}
+	}
+	if err := b.sender.send(b.Source, target, d.endpoint, b.sourceVersion()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// dryRun checks whether a single target of an update would be mentioned,
+// linked targets are always verified.
+func (b *Batch) dryRun(target URL, d discovery, linked bool) (sent bool, err error) {
+	if d.err != nil {
+		return false, fmt.Errorf("mention: %w", d.err)
+	}
+	if linked {
+		if err := b.verify(target); err != nil {
+			return false, fmt.Errorf("mention: %w", err)
+		}
+	}
+	return true, nil
+}
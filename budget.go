@@ -0,0 +1,171 @@
+package webmention
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+type (
+	// DeliveryBudget limits what the mentions to a single destination
+	// domain (the registrable domain of the endpoint) may consume, so that
+	// one slow or flapping endpoint doesn't starve the deliveries to all
+	// other ones.
+	DeliveryBudget struct {
+		// MaxInFlight is how many mentions are posted to the domain at
+		// once (0: no limit), further mentions wait for their turn, as
+		// long as the delivery timeout allows (see WithDeliveryTimeout).
+		MaxInFlight int
+		// MaxDailyRetries is how many failed mentions to the domain are
+		// put in the outbox to be retried, per day (0: none).
+		// Retries require an outbox (see WithSendWindows), and are posted
+		// by ProcessOutbox, with exponential backoff (see RetryBackoff).
+		MaxDailyRetries int
+		// MaxAttempts is how often a mention is retried at most, before it
+		// is given up on (0: DefaultMaxAttempts).
+		MaxAttempts int
+	}
+
+	// domainBudget is what is left of the budget of a domain.
+	domainBudget struct {
+		inFlight chan struct{} // nil if not limited
+		day      string        // the day retries are counted for, 2006-01-02
+		retries  int
+	}
+
+	// endpointStatusError is returned if an endpoint answers with an error status.
+	endpointStatusError struct {
+		status string
+		code   int
+	}
+)
+
+const (
+	// DefaultMaxAttempts is how often a mention is retried at most, if the
+	// budget doesn't say otherwise, spread over about a day.
+	DefaultMaxAttempts = 8
+	// retryBaseDelay is the delay before the first retry, doubled for every further one.
+	retryBaseDelay = 5 * time.Minute
+	// retryMaxDelay caps the delay between two retries.
+	retryMaxDelay = 24 * time.Hour
+)
+
+// RetryBackoff returns how long to wait before retrying a mention that
+// failed attempts times: 5 minutes, doubled for every further attempt, up
+// to a day.
+func RetryBackoff(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMaxDelay)
+}
+
+// WithDeliveryBudget limits the deliveries to every destination domain to budget.
+func WithDeliveryBudget(budget DeliveryBudget) SenderOption {
+	return func(s *Sender) {
+		s.budget = &budget
+		s.budgets = map[string]*domainBudget{}
+	}
+}
+
+// domainBudget returns the budget of the domain of endpoint, nil if no
+// budget is configured.
+func (sender *Sender) domainBudget(endpoint URL) *domainBudget {
+	if sender.budget == nil {
+		return nil
+	}
+	domain := registrableDomain(endpoint.Hostname())
+	sender.budgetsMu.Lock()
+	defer sender.budgetsMu.Unlock()
+	budget, ok := sender.budgets[domain]
+	if !ok {
+		budget = &domainBudget{}
+		if sender.budget.MaxInFlight > 0 {
+			budget.inFlight = make(chan struct{}, sender.budget.MaxInFlight)
+		}
+		sender.budgets[domain] = budget
+	}
+	return budget
+}
+
+// acquire waits until another mention may be posted to endpoint, the
+// returned func must be called once it has been posted.
+func (sender *Sender) acquire(ctx context.Context, endpoint URL) (release func(), err error) {
+	budget := sender.domainBudget(endpoint)
+	if budget == nil || budget.inFlight == nil {
+		return func() {}, nil
+	}
+	select {
+	case budget.inFlight <- struct{}{}:
+		return func() { <-budget.inFlight }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for other mentions to %s: %w", endpoint.Host, ctx.Err())
+	}
+}
+
+// spendRetry reports whether the domain of endpoint has retries left
+// today, and uses up one of them.
+func (sender *Sender) spendRetry(endpoint URL) bool {
+	budget := sender.domainBudget(endpoint)
+	if budget == nil {
+		return false
+	}
+	today := time.Now().Format(time.DateOnly)
+	sender.budgetsMu.Lock()
+	defer sender.budgetsMu.Unlock()
+	if budget.day != today {
+		budget.day, budget.retries = today, 0
+	}
+	if budget.retries >= sender.budget.MaxDailyRetries {
+		return false
+	}
+	budget.retries++
+	return true
+}
+
+// retryLater puts a delivery that failed with err in the outbox, to be
+// retried after a backoff, if the failure is temporary, the delivery has
+// attempts left, and the domain has retries left.
+func (sender *Sender) retryLater(delivery PendingDelivery, err error) bool {
+	if sender.outbox == nil || sender.budget == nil || !IsRetryable(err) {
+		return false
+	}
+	endpoint, perr := url.Parse(delivery.Endpoint)
+	if perr != nil {
+		return false
+	}
+	log := slog.With("source", delivery.Source, "target", delivery.Target, "attempts", delivery.Attempts+1, "error", err.Error())
+	maxAttempts := sender.budget.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+	if delivery.Attempts >= maxAttempts {
+		log.Warn("giving up on mention after too many attempts")
+		return false
+	}
+	if !sender.spendRetry(endpoint) {
+		log.Warn("retry budget of domain exhausted for today", "domain", registrableDomain(endpoint.Hostname()))
+		return false
+	}
+	delivery.Attempts++
+	delivery.Deferred = time.Now()
+	delivery.NextAttempt = delivery.Deferred.Add(RetryBackoff(delivery.Attempts))
+	if derr := sender.outbox.Defer(delivery); derr != nil {
+		log.Error(fmt.Sprintf("outbox: %s", derr))
+		return false
+	}
+	log.Info("mention deferred to be retried", "next_attempt", delivery.NextAttempt)
+	return true
+}
+
+func (e *endpointStatusError) Error() string {
+	return "post form returned: " + e.status
+}
+
+func (e *endpointStatusError) Is(target error) bool {
+	matches, _ := statusClass(e.code).is(target)
+	return matches
+}
@@ -0,0 +1,164 @@
+// Package canonical normalizes urls the way the webmention package compares
+// them, e.g., to recognize a target as one of your posts, or two links as
+// the same page.
+// Blog engines can use it to match incoming mentions to their posts with
+// the same rules as the library:
+//
+//	if canonical.Same(mention.Target, post.URL) {
+//		...
+//	}
+//
+// By default, the case of scheme and host, default ports, trailing slashes,
+// and the fragment are ignored. The options relax the rules further, they
+// are not used by the webmention package itself.
+package canonical
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+type (
+	// Option relaxes the rules of normalization.
+	Option func(*rules)
+
+	// TrailingSlash decides how a trailing slash of the path is treated.
+	TrailingSlash int
+
+	rules struct {
+		stripTracking bool
+		forceHTTPS    bool
+		trailingSlash TrailingSlash
+	}
+)
+
+const (
+	// StripTrailingSlash removes trailing slashes, /post/ and /post are the
+	// same page (the default).
+	StripTrailingSlash TrailingSlash = iota
+	// KeepTrailingSlash keeps trailing slashes, /post/ and /post are
+	// different pages.
+	KeepTrailingSlash
+	// AddTrailingSlash adds a trailing slash to paths that don't end with
+	// one, unless their last segment has a file extension, e.g., /feed.xml
+	// Like StripTrailingSlash, /post/ and /post are the same page, but keys
+	// look like the permalinks of engines ending them with a slash.
+	AddTrailingSlash
+)
+
+// trackingParams are query parameters removed by StripTracking, in
+// addition to the utm_* ones.
+var trackingParams = map[string]bool{
+	"fbclid": true,
+	"gclid":  true,
+	"mc_cid": true,
+	"mc_eid": true,
+}
+
+// StripTracking removes tracking parameters from the query: utm_source and
+// the other utm_* parameters, as well as fbclid, gclid, mc_cid and mc_eid.
+func StripTracking() Option {
+	return func(r *rules) {
+		r.stripTracking = true
+	}
+}
+
+// ForceHTTPS treats http urls as https, for sites that are only served over
+// https, but are sometimes linked to with http.
+func ForceHTTPS() Option {
+	return func(r *rules) {
+		r.forceHTTPS = true
+	}
+}
+
+// WithTrailingSlash sets how trailing slashes are treated (default StripTrailingSlash).
+func WithTrailingSlash(policy TrailingSlash) Option {
+	return func(r *rules) {
+		r.trailingSlash = policy
+	}
+}
+
+// Key returns the normalized form of u, two urls with the same key refer
+// to the same page.
+// The scheme and host are lowercased, default ports, the user info, and the
+// fragment are removed, the escaping of the path and query is kept as is.
+func Key(u *url.URL, opts ...Option) string {
+	scheme, host, path, query := normalize(u, opts)
+	key := scheme + "://" + host + path
+	if query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// Same reports whether a and b refer to the same page, see Key.
+func Same(a, b *url.URL, opts ...Option) bool {
+	return Key(a, opts...) == Key(b, opts...)
+}
+
+// Normalize returns a normalized copy of u, see Key.
+func Normalize(u *url.URL, opts ...Option) *url.URL {
+	scheme, host, path, query := normalize(u, opts)
+	normalized := &url.URL{Scheme: scheme, Host: host, Path: path, RawQuery: query}
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		normalized.Path, normalized.RawPath = unescaped, path
+	}
+	return normalized
+}
+
+func normalize(u *url.URL, opts []Option) (scheme, host, path, query string) {
+	var r rules
+	for _, opt := range opts {
+		opt(&r)
+	}
+	scheme, host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	if port := u.Port(); (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		host = strings.TrimSuffix(host, ":"+port) // keeps the brackets of ipv6 addresses
+	}
+	host = strings.TrimSuffix(host, ":") // empty port
+	if r.forceHTTPS && scheme == "http" {
+		scheme = "https"
+	}
+	path = r.trailingSlash.apply(u.EscapedPath())
+	query = u.RawQuery
+	if r.stripTracking {
+		query = stripTracking(query)
+	}
+	return scheme, host, path, query
+}
+
+func (policy TrailingSlash) apply(p string) string {
+	switch policy {
+	case KeepTrailingSlash:
+		if p == "" {
+			return "/"
+		}
+		return p
+	case AddTrailingSlash:
+		p = strings.TrimRight(p, "/")
+		if path.Ext(p) != "" {
+			return p
+		}
+		return p + "/"
+	}
+	return strings.TrimRight(p, "/")
+}
+
+// stripTracking removes the tracking parameters from query, keeping the
+// order and escaping of the others.
+func stripTracking(query string) string {
+	var kept []string
+	for _, param := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		name = strings.ToLower(name)
+		if param == "" || strings.HasPrefix(name, "utm_") || trackingParams[name] {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	return strings.Join(kept, "&")
+}
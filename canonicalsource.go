@@ -0,0 +1,97 @@
+package webmention
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/tomnomnom/linkheader"
+	"golang.org/x/net/html"
+)
+
+// WithCanonicalSources stores a mention under the canonical url of its
+// source, if the source declares one (rel=canonical, in its Link header or
+// html) other than the submitted url, e.g., if an AMP version or a mirror
+// of a post was submitted. A post submitted through several of its copies
+// is then stored once.
+// Since any page can claim any canonical url, the canonical page is fetched
+// as well, and only used if it links to the target itself.
+// The submitted url is kept in Mention.SubmittedSource.
+// Only sources that link to the target are rewritten, updates and deletions
+// are expected to be submitted for the canonical url.
+func WithCanonicalSources() ReceiverOption {
+	return func(r *Receiver) {
+		r.canonicalSources = true
+	}
+}
+
+// declaredCanonical returns the canonical url the fetched source declares,
+// in a Link header, or in a <link> element of html sources, nil if it
+// declares none.
+func declaredCanonical(resp *http.Response, base URL, mime string, sourceData []byte) URL {
+	if resp.Request != nil && resp.Request.URL != nil {
+		base = resp.Request.URL
+	}
+	for _, link := range linkheader.ParseMultiple(resp.Header.Values("Link")) {
+		for _, rel := range strings.Fields(link.Rel) {
+			if strings.EqualFold(rel, "canonical") {
+				return resolveHref(base, link.URL)
+			}
+		}
+	}
+	if mime != "text/html" {
+		return nil
+	}
+	doc, err := html.Parse(bytes.NewReader(sourceData))
+	if err != nil {
+		return nil
+	}
+	var canonical URL
+	var traverseHtml func(*html.Node)
+	traverseHtml = func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "link" {
+			if href, ok := relHref(node, "canonical"); ok {
+				canonical = resolveHref(base, href)
+				return
+			}
+		}
+		for child := node.FirstChild; child != nil && canonical == nil; child = child.NextSibling {
+			traverseHtml(child)
+		}
+	}
+	traverseHtml(doc)
+	return canonical
+}
+
+// resolveHref resolves href relative to base, nil if it is not a valid http(s) url.
+func resolveHref(base URL, href string) URL {
+	ref, err := base.Parse(strings.TrimSpace(href))
+	if err != nil || (ref.Scheme != "http" && ref.Scheme != "https") {
+		return nil
+	}
+	return ref
+}
+
+// useCanonicalSource replaces the source of mention with the canonical url
+// it declares, if the canonical page links to the target as well, and
+// returns the content of the canonical page, or else sourceData.
+func (receiver *Receiver) useCanonicalSource(mention *Mention, resp *http.Response, mime string, sourceData []byte) []byte {
+	canonical := declaredCanonical(resp, mention.Source, mime, sourceData)
+	if canonical == nil || samePage(canonical, mention.Source) || samePage(canonical, mention.Target) {
+		return sourceData
+	}
+	log := slog.With("source", mention.Source.String(), "canonical", canonical.String())
+	data, status, err := receiver.verifyAlternate(canonical, *mention)
+	if err != nil {
+		log.Info("canonical source could not be checked", "error", err)
+		return sourceData
+	}
+	if status != StatusLink {
+		log.Info("canonical source does not link to target, keeping the submitted source")
+		return sourceData
+	}
+	log.Info("storing mention under the canonical source")
+	mention.SubmittedSource, mention.Source = mention.Source, canonical
+	return data
+}
@@ -0,0 +1,298 @@
+// Package client talks to the HTTP API of a webmention receiver, as served
+// by webmention.NewReceiverHandler: the status of submissions, the stored
+// mentions, moderation, dead letters, and statistics.
+//
+//	c := client.New("https://example.com/wm", client.WithToken(os.Getenv("WEBMENTION_TOKEN")))
+//	for mention, err := range c.AllMentions(ctx, webmention.MentionQuery{Newest: true}) {
+//		...
+//	}
+//
+// The routes must be enabled on the handler (see webmention.Routes), and
+// the token is only needed if they are protected (see webmention.WithAdminAuth).
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+type (
+	// Client is a client of a receiver's HTTP API, it is safe for concurrent use.
+	Client struct {
+		baseURL    string
+		token      string
+		httpClient *http.Client
+	}
+
+	Option func(*Client)
+
+	// StatusError is returned if the receiver answers with an unexpected
+	// status code, Message is the body of the response.
+	StatusError struct {
+		Code    int
+		Message string
+	}
+)
+
+var (
+	// ErrNotFound matches a StatusError for http.StatusNotFound, e.g., for
+	// an unknown mention, or a dead letter that was already retried.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized matches a StatusError for http.StatusUnauthorized.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotImplemented matches a StatusError for
+	// http.StatusNotImplemented, e.g., if the receiver has no storage.
+	ErrNotImplemented = errors.New("not implemented")
+)
+
+// maxErrorMessage is how much of an error response is kept in StatusError.Message.
+const maxErrorMessage = 1024
+
+// statusNames are the short names of statuses used by the mentions route.
+var statusNames = map[webmention.Status]string{
+	webmention.StatusLink:        "link",
+	webmention.StatusNoLink:      "no-link",
+	webmention.StatusDeleted:     "deleted",
+	webmention.StatusUnavailable: "unavailable",
+}
+
+// New creates a client of the routes served under baseURL, the mount point
+// of the handler, e.g., https://example.com/wm
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithToken authenticates to the protected routes with a bearer token.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient sets the http client used to send the requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("client: %d %s", e.Code, http.StatusText(e.Code))
+	}
+	return fmt.Sprintf("client: %d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Code == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.Code == http.StatusUnauthorized
+	case ErrNotImplemented:
+		return e.Code == http.StatusNotImplemented
+	}
+	return false
+}
+
+// Status looks up the processing status of a submission by its id, the
+// last path segment of the Location header returned by the receiver.
+func (c *Client) Status(ctx context.Context, id string) (status webmention.MentionStatus, err error) {
+	err = c.get(ctx, "/status/"+url.PathEscape(id), nil, &status)
+	return status, err
+}
+
+// Rejection tells why the receiver rejected a mention of target by source.
+func (c *Client) Rejection(ctx context.Context, source, target string) (rejection webmention.Rejection, err error) {
+	err = c.get(ctx, "/rejection", url.Values{"source": {source}, "target": {target}}, &rejection)
+	return rejection, err
+}
+
+// Mentions returns a page of the stored mentions matching the query.
+// Continue with the next page by setting query.Cursor to page.Next, or use
+// AllMentions.
+func (c *Client) Mentions(ctx context.Context, query webmention.MentionQuery) (page webmention.MentionPage, err error) {
+	params, err := queryParams(query)
+	if err != nil {
+		return page, err
+	}
+	err = c.get(ctx, "/mentions", params, &page)
+	return page, err
+}
+
+// AllMentions iterates over all stored mentions matching the query, page
+// by page, starting at query.Cursor.
+// Iteration stops after the first error.
+func (c *Client) AllMentions(ctx context.Context, query webmention.MentionQuery) iter.Seq2[webmention.Mention, error] {
+	return func(yield func(webmention.Mention, error) bool) {
+		for {
+			page, err := c.Mentions(ctx, query)
+			if err != nil {
+				yield(webmention.Mention{}, err)
+				return
+			}
+			for _, mention := range page.Mentions {
+				if !yield(mention, nil) {
+					return
+				}
+			}
+			if page.Next == "" {
+				return
+			}
+			query.Cursor = page.Next
+		}
+	}
+}
+
+// Counts counts the stored mentions of target by type.
+func (c *Client) Counts(ctx context.Context, target string) (counts webmention.Counts, err error) {
+	err = c.get(ctx, "/counts", url.Values{"target": {target}}, &counts)
+	return counts, err
+}
+
+// Queue reports how full the receiver's queue is, and how many mentions
+// have been processed.
+func (c *Client) Queue(ctx context.Context) (stats webmention.QueueStats, err error) {
+	err = c.get(ctx, "/queue", nil, &stats)
+	return stats, err
+}
+
+// Usage returns the usage of every accept-domain in month, e.g., 2006-01
+// (see webmention.UsageMonth), or the current month if empty.
+func (c *Client) Usage(ctx context.Context, month string) (usage []webmention.Usage, err error) {
+	var params url.Values
+	if month != "" {
+		params = url.Values{"month": {month}}
+	}
+	err = c.get(ctx, "/usage", params, &usage)
+	return usage, err
+}
+
+// Pending lists the mentions held for moderation.
+func (c *Client) Pending(ctx context.Context) (pending []webmention.Mention, err error) {
+	err = c.get(ctx, "/moderation", nil, &pending)
+	return pending, err
+}
+
+// Approve releases a mention from moderation, it is passed on to the notifiers.
+func (c *Client) Approve(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/moderation/"+url.PathEscape(id)+"/approve", nil, nil)
+}
+
+// Reject releases a mention from moderation and discards it.
+func (c *Client) Reject(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/moderation/"+url.PathEscape(id)+"/reject", nil, nil)
+}
+
+// DeadLetters lists the mentions that failed processing.
+func (c *Client) DeadLetters(ctx context.Context) (letters []webmention.DeadLetter, err error) {
+	err = c.get(ctx, "/dead-letters", nil, &letters)
+	return letters, err
+}
+
+// RetryDeadLetter enqueues a mention that failed processing again.
+func (c *Client) RetryDeadLetter(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/dead-letters/"+url.PathEscape(id)+"/retry", nil, nil)
+}
+
+// DiscardDeadLetter removes a mention that failed processing.
+func (c *Client) DiscardDeadLetter(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/dead-letters/"+url.PathEscape(id), nil, nil)
+}
+
+// queryParams encodes query the way the mentions route expects it.
+func queryParams(query webmention.MentionQuery) (url.Values, error) {
+	params := url.Values{}
+	filter := query.Filter
+	if !filter.Since.IsZero() {
+		params.Set("since", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		params.Set("until", filter.Until.Format(time.RFC3339))
+	}
+	if filter.Target != "" {
+		params.Set("target", filter.Target)
+	}
+	if filter.Type != "" {
+		params.Set("type", string(filter.Type))
+	}
+	if filter.Status != "" {
+		name, ok := statusNames[filter.Status]
+		if !ok {
+			return nil, fmt.Errorf("client: unknown status: %s", filter.Status)
+		}
+		params.Set("status", name)
+	}
+	if filter.SourceDomain != "" {
+		params.Set("source_domain", filter.SourceDomain)
+	}
+	if query.Newest {
+		params.Set("order", "newest")
+	}
+	if query.ByWritten {
+		params.Set("sort", "written")
+	}
+	if query.Limit > 0 {
+		params.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Cursor != "" {
+		params.Set("cursor", query.Cursor)
+	}
+	return params, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, params url.Values, v any) error {
+	return c.do(ctx, http.MethodGet, path, params, v)
+}
+
+// do sends a request to the route at path, and decodes the JSON response into v, unless nil.
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, v any) error {
+	u := c.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return fmt.Errorf("client: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if v != nil {
+		req.Header.Set("Accept", "application/json")
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("client: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorMessage))
+		return &StatusError{Code: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("client: %s %s: %w", method, path, err)
+	}
+	return nil
+}
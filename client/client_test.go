@@ -0,0 +1,132 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/client"
+)
+
+func must[T any](t T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	held := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(func(source, target *url.URL) bool { return true }),
+		webmention.WithStorage(webmention.NewMemoryStorage()),
+		webmention.WithSpamScorer(webmention.SpamScorerFunc(func(mention webmention.Mention, content []byte) (float64, error) {
+			if strings.Contains(string(content), "viagra") {
+				return 1, nil
+			}
+			return 0, nil
+		}), 0.5),
+		webmention.WithModeration(&webmention.MemoryModerationQueue{}, webmention.NotifierFunc(func(mention webmention.Mention) {
+			held <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(ctx)
+
+	mux := http.NewServeMux()
+	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver,
+		webmention.WithMountPoint("/wm"),
+		webmention.WithRoutes(webmention.AllRoutes),
+		webmention.WithAdminAuth(func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer secret"
+		}),
+	))
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="http://%s/target">target</a>`, r.Host)
+	})
+	mux.HandleFunc("/spam", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `Cheap viagra! <a href="http://%s/target">target</a>`, r.Host)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	c := client.New(ts.URL+"/wm", client.WithToken("secret"))
+	submit := func(source string) (id string) {
+		resp := must(http.PostForm(ts.URL+"/wm/webmention", url.Values{
+			"source": {ts.URL + source},
+			"target": {ts.URL + "/target"},
+		}))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("mention not accepted, status: %d", resp.StatusCode)
+		}
+		return path.Base(resp.Header.Get("Location"))
+	}
+	awaitProcessed := func(id string) {
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			status, err := c.Status(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if status.State == webmention.StateProcessed {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("mention not processed in time: %+v", status)
+			}
+		}
+	}
+
+	for i := range 3 {
+		awaitProcessed(submit(fmt.Sprintf("/source?page=%d", i)))
+	}
+	var sources []string
+	for mention, err := range c.AllMentions(ctx, webmention.MentionQuery{Limit: 2, Newest: true}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, mention.Source.RawQuery)
+	}
+	if want := []string{"page=2", "page=1", "page=0"}; fmt.Sprint(sources) != fmt.Sprint(want) {
+		t.Errorf("incorrect mentions, got: %v, want: %v", sources, want)
+	}
+	if counts := must(c.Counts(ctx, ts.URL+"/target")); counts.Total != 3 {
+		t.Errorf("incorrect counts: %+v", counts)
+	}
+
+	submit("/spam")
+	mention := <-held
+	pending := must(c.Pending(ctx))
+	if len(pending) != 1 || pending[0].ID != mention.ID {
+		t.Fatalf("mention not pending: %+v", pending)
+	}
+	if err := c.Approve(ctx, mention.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Approve(ctx, mention.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("approved twice, got: %v", err)
+	}
+	page := must(c.Mentions(ctx, webmention.MentionQuery{Filter: webmention.MentionFilter{Status: webmention.StatusLink}}))
+	if len(page.Mentions) != 4 {
+		t.Errorf("approved mention not stored: %+v", page)
+	}
+
+	if _, err := client.New(ts.URL + "/wm").Queue(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("incorrect error without token, got: %v", err)
+	}
+	if _, err := c.Usage(ctx, ""); !errors.Is(err, client.ErrNotImplemented) {
+		t.Errorf("incorrect error without usage meter, got: %v", err)
+	}
+	if _, err := c.Status(ctx, "unknown"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("incorrect error for unknown mention, got: %v", err)
+	}
+}
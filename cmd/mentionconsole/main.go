@@ -0,0 +1,383 @@
+// Mentionconsole is a terminal UI for the operators of a mentionee (or any
+// receiver serving the HTTP API, see webmention.NewReceiverHandler), for
+// those who'd rather SSH into a server than open a web dashboard.
+// It browses the recent mentions, shows what a source says around its link
+// to the target, approves or rejects mentions held for moderation, and
+// watches for new mentions as they come in.
+//
+//	MENTIONEE_URL=https://example.com/wm WEBMENTION_TOKEN=secret mentionconsole
+//
+// MENTIONEE_URL is the mount point of the handler, WEBMENTION_TOKEN the
+// bearer token, if the routes are protected (see webmention.WithAdminAuth).
+// The mentions and moderation routes must be enabled.
+// WATCH_INTERVAL (e.g., 10s) sets how often new mentions are looked for
+// while watching (default 5s).
+//
+// Commands are typed at the prompt, followed by enter:
+//   - l: list the recent mentions, n: the next page
+//   - p: list the mentions pending moderation
+//   - v N: view mention N of the list, with the text of its source around the link
+//   - a N, r N: approve or reject pending mention N
+//   - w: watch new mentions, until enter is pressed
+//   - q: quit
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/client"
+	"golang.org/x/net/html"
+)
+
+// console is the state of the terminal UI.
+type console struct {
+	client *client.Client
+	out    io.Writer
+	lines  <-chan string
+	// list is the last list shown, mentions are referred to by their index in it
+	list []webmention.Mention
+	// pending is set if list are the mentions pending moderation
+	pending bool
+	// next is the cursor of the next page of recent mentions
+	next  string
+	watch time.Duration
+}
+
+const (
+	pageSize      = 20
+	maxExcerpt    = 600
+	clearScreen   = "\x1b[H\x1b[2J"
+	bold, reset   = "\x1b[1m", "\x1b[0m"
+	defaultWatch  = 5 * time.Second
+	fetchTimeout  = 10 * time.Second
+	maxSourceSize = 5 << 20
+)
+
+func main() {
+	baseURL := os.Getenv("MENTIONEE_URL")
+	if baseURL == "" {
+		fmt.Fprintln(os.Stderr, "mentionconsole: MENTIONEE_URL not set")
+		os.Exit(2)
+	}
+	c := &console{
+		client: client.New(baseURL, client.WithToken(os.Getenv("WEBMENTION_TOKEN"))),
+		out:    os.Stdout,
+		lines:  readLines(os.Stdin),
+		watch:  defaultWatch,
+	}
+	if interval := os.Getenv("WATCH_INTERVAL"); interval != "" {
+		watch, err := time.ParseDuration(interval)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "mentionconsole: WATCH_INTERVAL: %s\n", err)
+			os.Exit(2)
+		}
+		c.watch = watch
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c.run(ctx)
+}
+
+// readLines reads the lines of r in the background, so that commands can
+// wait for input and something else at once.
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return lines
+}
+
+func (c *console) run(ctx context.Context) {
+	c.recent(ctx, "")
+	for {
+		fmt.Fprint(c.out, "\n[l]ist [n]ext [p]ending [v]iew N [a]pprove N [r]eject N [w]atch [q]uit > ")
+		var line string
+		select {
+		case <-ctx.Done():
+			return
+		case l, ok := <-c.lines:
+			if !ok {
+				return
+			}
+			line = l
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch cmd {
+		case "":
+		case "l", "list":
+			c.recent(ctx, "")
+		case "n", "next":
+			if c.next == "" {
+				fmt.Fprintln(c.out, "no more mentions")
+				continue
+			}
+			c.recent(ctx, c.next)
+		case "p", "pending":
+			c.showPending(ctx)
+		case "v", "view":
+			if mention, ok := c.pick(arg); ok {
+				c.view(ctx, mention)
+			}
+		case "a", "approve", "r", "reject":
+			c.moderate(ctx, cmd[0] == 'a', arg)
+		case "w", "watch":
+			c.watchNew(ctx)
+		case "q", "quit":
+			return
+		default:
+			fmt.Fprintf(c.out, "unknown command: %s\n", cmd)
+		}
+	}
+}
+
+func (c *console) recent(ctx context.Context, cursor string) {
+	page, err := c.client.Mentions(ctx, webmention.MentionQuery{Newest: true, Limit: pageSize, Cursor: cursor})
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.list, c.pending, c.next = page.Mentions, false, page.Next
+	fmt.Fprint(c.out, clearScreen+bold+"Recent mentions"+reset+"\n\n")
+	c.printList()
+}
+
+func (c *console) showPending(ctx context.Context) {
+	pending, err := c.client.Pending(ctx)
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.list, c.pending, c.next = pending, true, ""
+	fmt.Fprint(c.out, clearScreen+bold+"Pending moderation"+reset+"\n\n")
+	c.printList()
+}
+
+func (c *console) printList() {
+	if len(c.list) == 0 {
+		fmt.Fprintln(c.out, "  (none)")
+	}
+	for i, mention := range c.list {
+		fmt.Fprintf(c.out, "%3d  %s\n", i+1, summary(mention))
+	}
+}
+
+// summary is a one line description of mention.
+func summary(mention webmention.Mention) string {
+	kind := string(mention.Type)
+	if kind == "" {
+		kind = string(mention.Status)
+	}
+	line := fmt.Sprintf("%s  %-8s %s -> %s", mention.Received.Local().Format("2006-01-02 15:04"), kind, mention.Source, mention.Target)
+	if mention.SpamScore > 0 {
+		line += fmt.Sprintf("  (spam %.2f)", mention.SpamScore)
+	}
+	return line
+}
+
+// pick returns the mention with the index arg in the last list.
+func (c *console) pick(arg string) (webmention.Mention, bool) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(c.list) {
+		fmt.Fprintf(c.out, "no mention %q in the list\n", arg)
+		return webmention.Mention{}, false
+	}
+	return c.list[n-1], true
+}
+
+func (c *console) view(ctx context.Context, mention webmention.Mention) {
+	fmt.Fprint(c.out, clearScreen+bold+"Mention "+mention.ID+reset+"\n\n")
+	fmt.Fprintf(c.out, "  source:   %s\n  target:   %s\n  status:   %s\n", mention.Source, mention.Target, mention.Status)
+	if mention.Type != "" {
+		fmt.Fprintf(c.out, "  type:     %s\n", mention.Type)
+	}
+	fmt.Fprintf(c.out, "  received: %s\n", mention.Received.Local().Format(time.RFC1123))
+	if mention.SpamScore > 0 {
+		fmt.Fprintf(c.out, "  spam:     %.2f\n", mention.SpamScore)
+	}
+	if mention.SignedBy != "" {
+		fmt.Fprintf(c.out, "  signed:   %s\n", mention.SignedBy)
+	}
+	for name, value := range mention.Extensions {
+		fmt.Fprintf(c.out, "  %s: %s\n", name, value)
+	}
+	if diff := mention.ContentDiff(); diff != "" {
+		fmt.Fprintf(c.out, "\n  edited:\n%s\n", wrap(diff, 76, "  "))
+	}
+	excerpt, err := fetchExcerpt(ctx, mention)
+	if err != nil {
+		fmt.Fprintf(c.out, "\n  source unavailable: %s\n", err)
+		return
+	}
+	fmt.Fprintf(c.out, "\n%s\n", wrap(excerpt, 76, "  "))
+}
+
+func (c *console) moderate(ctx context.Context, approve bool, arg string) {
+	if !c.pending {
+		fmt.Fprintln(c.out, "list the pending mentions first")
+		return
+	}
+	mention, ok := c.pick(arg)
+	if !ok {
+		return
+	}
+	var err error
+	if approve {
+		err = c.client.Approve(ctx, mention.ID)
+	} else {
+		err = c.client.Reject(ctx, mention.ID)
+	}
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.showPending(ctx)
+}
+
+// watchNew prints new mentions as they come in, until enter is pressed.
+func (c *console) watchNew(ctx context.Context) {
+	fmt.Fprint(c.out, clearScreen+bold+"Watching new mentions, press enter to stop"+reset+"\n\n")
+	since := time.Now()
+	seen := map[string]bool{}
+	ticker := time.NewTicker(c.watch)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.lines:
+			c.recent(ctx, "")
+			return
+		case <-ticker.C:
+		}
+		query := webmention.MentionQuery{Filter: webmention.MentionFilter{Since: since}}
+		for mention, err := range c.client.AllMentions(ctx, query) {
+			if err != nil {
+				c.fail(err)
+				break
+			}
+			if seen[mention.ID] {
+				continue
+			}
+			seen[mention.ID] = true
+			fmt.Fprintf(c.out, "     %s\n", summary(mention))
+		}
+	}
+}
+
+func (c *console) fail(err error) {
+	if errors.Is(err, client.ErrUnauthorized) {
+		err = fmt.Errorf("%w (is WEBMENTION_TOKEN set?)", err)
+	}
+	fmt.Fprintf(c.out, "error: %s\n", err)
+}
+
+// fetchExcerpt fetches the source of mention, and returns the text of the
+// paragraph (or list item, ...) linking to the target.
+func fetchExcerpt(ctx context.Context, mention webmention.Mention) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mention.Source.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	doc, err := html.Parse(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return "", err
+	}
+	link := findLink(doc, mention.Target.String())
+	if link == nil {
+		return "", fmt.Errorf("no link to the target")
+	}
+	block := link
+	for block.Parent != nil && !isBlock(block) {
+		block = block.Parent
+	}
+	excerpt := strings.Join(strings.Fields(text(block)), " ")
+	if runes := []rune(excerpt); len(runes) > maxExcerpt {
+		excerpt = string(runes[:maxExcerpt]) + "…"
+	}
+	return excerpt, nil
+}
+
+func findLink(n *html.Node, target string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == "a" {
+		for _, attr := range n.Attr {
+			if attr.Key == "href" && strings.TrimSuffix(attr.Val, "/") == strings.TrimSuffix(target, "/") {
+				return n
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if link := findLink(child, target); link != nil {
+			return link
+		}
+	}
+	return nil
+}
+
+func isBlock(n *html.Node) bool {
+	switch n.Data {
+	case "p", "li", "blockquote", "article", "section", "div", "td", "body":
+		return n.Type == html.ElementNode
+	}
+	return false
+}
+
+func text(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+		return ""
+	}
+	var sb strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		sb.WriteString(text(child))
+		sb.WriteString(" ")
+	}
+	return sb.String()
+}
+
+// wrap breaks s into lines of at most width characters, each prefixed by indent.
+func wrap(s string, width int, indent string) string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, indent+line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, indent+line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxReasonSize limits how much of an error response is logged as the rejection reason.
+const maxReasonSize = 256
+
+// accessRecorder remembers the status code, and the start of an error response body.
+type accessRecorder struct {
+	http.ResponseWriter
+	code   int
+	reason bytes.Buffer
+}
+
+func (r *accessRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *accessRecorder) Write(bs []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	if r.code >= 400 && r.reason.Len() < maxReasonSize {
+		r.reason.Write(bs[:min(len(bs), maxReasonSize-r.reason.Len())])
+	}
+	return r.ResponseWriter.Write(bs)
+}
+
+// accessLog logs every request to next, and warns about requests taking
+// longer than slow (if slow is positive).
+func accessLog(next http.Handler, slow time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		dur := time.Since(start)
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.EscapedPath(),
+			"status", rec.code,
+			"dur", dur,
+		}
+		// the form has been parsed by the receiver (if at all), don't read the body again
+		if host := formHost(r, "source"); host != "" {
+			attrs = append(attrs, "source_host", host)
+		}
+		if host := formHost(r, "target"); host != "" {
+			attrs = append(attrs, "target_host", host)
+		}
+		if rec.reason.Len() > 0 {
+			attrs = append(attrs, "reason", string(bytes.TrimSpace(rec.reason.Bytes())))
+		}
+
+		switch {
+		case slow > 0 && dur > slow:
+			slog.Warn("slow request", append(attrs, "threshold", slow)...)
+		case rec.code >= 500:
+			slog.Error("request failed", attrs...)
+		default:
+			slog.Info("request", attrs...)
+		}
+	})
+}
+
+// formHost returns the host of the url in the form field key.
+func formHost(r *http.Request, key string) string {
+	if r.PostForm == nil {
+		return ""
+	}
+	u, err := url.Parse(r.PostForm.Get(key))
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
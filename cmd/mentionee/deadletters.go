@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// deadLetters lists, retries or discards mentions that failed processing.
+//
+//	mentionee dead-letters [retry ID | discard ID]
+func deadLetters(args []string) (exitCode int) {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("erroneous configuration", "configError", err)
+		return ExitConfigError
+	}
+	if Config.DeadLetters == "" {
+		fmt.Fprintln(os.Stderr, "dead-letters: DEAD_LETTERS not configured")
+		return ExitConfigError
+	}
+	receiver := cfg.service.Receiver
+
+	switch {
+	case len(args) == 0:
+		letters, err := receiver.DeadLetters()
+		if err != nil {
+			slog.Error(fmt.Sprintf("dead-letters: %s", err))
+			return ExitFailure
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(letters); err != nil {
+			slog.Error(fmt.Sprintf("dead-letters: %s", err))
+			return ExitFailure
+		}
+		return ExitSuccess
+	case len(args) == 2 && args[0] == "retry":
+		if err := receiver.RetryDeadLetter(args[1]); err != nil {
+			slog.Error(fmt.Sprintf("dead-letters: retry: %s", err))
+			return ExitFailure
+		}
+		// process the mention right away (if it fails again, it ends up in the dead letters again)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+		defer cancel()
+		receiver.Shutdown(ctx)
+		if err := cfg.service.Flush(); err != nil {
+			slog.Error(fmt.Sprintf("dead-letters: sending aggregated report failed: %s", err))
+			return ExitFailure
+		}
+		return ExitSuccess
+	case len(args) == 2 && args[0] == "discard":
+		if err := receiver.DiscardDeadLetter(args[1]); err != nil {
+			slog.Error(fmt.Sprintf("dead-letters: discard: %s", err))
+			return ExitFailure
+		}
+		return ExitSuccess
+	default:
+		fmt.Fprintln(os.Stderr, "usage: mentionee dead-letters [retry ID | discard ID]")
+		return ExitConfigError
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+)
+
+// instanceRoot is where the directories of instances are, unless
+// INSTANCE_DIR says otherwise.
+const instanceRoot = "/var/lib/webmention"
+
+// instance is the name of the instance, as started by the template unit
+// mentionee@NAME.service, empty if mentionee isn't run as an instance.
+func instance() string {
+	return os.Getenv("MENTIONEE_INSTANCE")
+}
+
+// instanceDir is the directory relative paths of the instance are resolved
+// against, empty if mentionee isn't run as an instance.
+func instanceDir() string {
+	if dir := os.Getenv("INSTANCE_DIR"); dir != "" {
+		return dir
+	}
+	if name := instance(); name != "" {
+		return filepath.Join(instanceRoot, name)
+	}
+	return ""
+}
+
+// loadEnv loads the .env file: of an instance /etc/webmention/NAME.env, or
+// mentionee.env in its directory, otherwise $PWD/.env or
+// /etc/webmention/mentionee.env
+// Variables that are already set are not overridden.
+func loadEnv() {
+	if name := instance(); name != "" {
+		if err := godotenv.Load(filepath.Join("/etc/webmention", name+".env")); err != nil {
+			godotenv.Load(filepath.Join(instanceDir(), "mentionee.env"))
+		}
+		return
+	}
+	if err := godotenv.Load(); err != nil {
+		godotenv.Load("/etc/webmention/mentionee.env")
+	}
+}
+
+// instancePath resolves a relative path against the instance directory.
+func instancePath(path string) string {
+	dir := instanceDir()
+	if path == "" || dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// resolveInstancePaths makes the paths of the configuration relative to the
+// instance directory.
+func resolveInstancePaths() {
+	paths := []*string{
+		&Config.AcceptRules,
+		&Config.TargetSitemap,
+		&Config.MailQueue,
+		&Config.StorageFile,
+		&Config.ModerationQueue,
+		&Config.NotificationLog,
+		&Config.DeadLetters,
+		&Config.QueueFile,
+		&Config.UsageFile,
+		&Config.TenantsDir,
+		&Config.PluginsDir,
+		&Config.SourceSnapshots,
+		&Config.DataDir,
+	}
+	if Config.HttpCache != "memory" {
+		paths = append(paths, &Config.HttpCache)
+	}
+	for _, path := range paths {
+		*path = instancePath(*path)
+	}
+}
@@ -4,39 +4,171 @@
 //
 // This application can be run (for example) as a daemon.
 // A systemd service configuration is provided together with the source code.
+// The service is assembled by package service, which Go programs can use to
+// embed it, the configuration below is mapped to a service.Config.
 //
 // Configuration is read from a .env file (or just the OS env vars directly).
 // An .env file must be present in either the process working directory
 // `$PWD/.env`, or in `/etc/webmention/mentionee.env`.
 //
+// Several independent instances can be run from one installed binary with
+// the template unit mentionee@.service, e.g., mentionee@site1 reads its
+// configuration from `/etc/webmention/site1.env` (or mentionee.env in its
+// instance directory) instead.
+// The unit sets MENTIONEE_INSTANCE to the name of the instance.
+// Relative paths (STORAGE_FILE, MAIL_DKIM_PRIV, DEAD_LETTERS, ...) of an
+// instance are relative to its directory, `/var/lib/webmention/NAME`, or
+// INSTANCE_DIR if set.
+// Instances sharing Redis are told apart by INSTANCE_NAME, which defaults
+// to the hostname followed by @NAME.
+//
+// Secrets (REDIS_PASSWORD, ISSUE_TOKEN, MICROPUB_TOKEN, MAIL_PASS,
+// MAIL_OAUTH_CLIENT_SECRET, MAIL_OAUTH_REFRESH_TOKEN, MAIL_DKIM_PRIV,
+// MODERATION_SECRET, ANALYTICS_SECRET, and TENANT_OPERATOR_TOKEN)
+// don't have to be kept in plain env vars, if one is empty, it is read from
+// (see webmention.DefaultSecretSources):
+//   - the file named by NAME_FILE, e.g., MAIL_PASS_FILE=/run/secrets/mail_pass
+//   - the systemd credential NAME, e.g., LoadCredential=MAIL_PASS:/etc/webmention/mail_pass
+//   - the file of NAME=VALUE lines named by SECRETS_FILE, encrypted with age,
+//     if SECRETS_IDENTITY names the identity (key) file to decrypt it with
+//
 // Configurable values are:
 //   - SHUTDOWN_TIMEOUT=Seconds: How long to wait for a clean shutdown after SIGINT or SIGTERM (default 120)
-//   - ENDPOINT=URL Path: On which path to listen for Webmentions (default /api/webmention)
+//   - ENDPOINT=URL Path: On which path to listen for Webmentions, it is described by an OpenAPI document at ENDPOINT/openapi.json (default /api/webmention)
 //   - LISTEN_ADDR=Domain with Port: Bind listener to this domain:port (default :8080)
 //   - ACCEPT_DOMAIN=Domain: Accept mentions if they point to this domain (e.g., the domain of your blog, required, no default)
+//   - ACCEPT_RULES=Path: File with rules restricting which pages accept mentions, see webmention.AcceptRules (default empty, accept all pages on ACCEPT_DOMAIN)
+//   - TARGET_SITEMAP=Path: Only accept mentions of pages listed in this sitemap, pages annotated with <wm:closed>true</wm:closed> are closed for new mentions, see webmention.Sitemap (default empty)
 //   - NOTIFY_BY_MAIL=external, internal or no: Whether or not to enable notifications by mail (default no)
+//   - MAIL_BATCH=Policy: When to send collected mentions by mail, e.g., instant, interval=5m, or count=20,interval=12h,per-target (default interval=12h)
+//   - ACCESS_LOG=yes or no: Log every request, including the reason a mention was rejected (default yes)
+//   - SLOW_REQUEST=Milliseconds: Warn about requests taking longer than this (default 1000, 0 disables the warning)
+//   - MAIL_CC=E-Mail addresses: Comma separated addresses to send a copy to (default empty)
+//   - MAIL_BCC=E-Mail addresses: Comma separated addresses to send a blind copy to (default empty)
+//   - MAIL_TARGETS=Filters: Only inform some recipients about mentions of some targets, e.g., alice@example.com=/alice/,/guestbook;bob@example.com=/bob/ (default empty, everyone receives all mentions)
+//   - MAIL_JSON=yes or no: Attach the mentions as mentions.json to every mail, for scripts to process (default no)
+//   - REACTION_THRESHOLD=Number: Collapse this many or more likes, reposts, or bookmarks of the same post in a mail into a single line, e.g., 42 likes on /post/x, see listener.CollapseReactions (default 0, disabled)
+//   - MAIL_QUEUE=Path: Keep mails whose delivery failed temporarily (e.g., greylisting) in this file, to retry them after a restart (default empty, in memory)
+//   - MAIL_RETRY_PERIOD=Hours: How long to retry delivering a mail (default 72)
+//   - HARDENING=yes or no: Restrictive security headers and request size limits (default yes)
+//   - STORAGE_FILE=Path: Persist processed mentions to this file, together with the notifications still to be sent, which are sent after a crash or restart, see webmention.OutboxStorage (default empty, don't persist)
+//   - SUMMARY_REPORT=monthly, yearly or no: Additionally send a summary report by mail (default no, requires NOTIFY_BY_MAIL and STORAGE_FILE)
+//   - SPAM_FILTER=yes or no: Hold mentions that look like spam for moderation, instead of notifying (default no)
+//   - MODERATION_QUEUE=Path: Keep mentions held for moderation in this file (default empty, in memory, they are lost on restart)
+//   - MODERATION_SECRET=Secret: Also mail the mentions held for moderation, batched like the others, with links signed by this secret that approve or reject them, served under ENDPOINT (default empty, disabled, requires NOTIFY_BY_MAIL and SPAM_FILTER)
+//   - MODERATION_LINK_HOURS=Hours: How long the moderation links are valid (default 168)
+//   - DOMAIN_BLOCKLISTS=Zones: Comma separated DNSBL zones listing domains, e.g., dbl.spamhaus.org (default empty)
+//   - IP_BLOCKLISTS=Zones: Comma separated DNSBL zones listing IP addresses, e.g., zen.spamhaus.org (default empty)
+//   - MAX_REJECTIONS=Number: Reject sources whose domain was rejected this many times more often than accepted (default 0, disabled)
+//   - REDIS_ADDR=Host with Port: Share the mention queue and rate limiting with other instances through Redis 6.2+, the endpoints advertised by sources are recorded there too, for a mentioner sharing the Redis to reply without discovering them again (default empty, in memory)
+//   - REDIS_PASSWORD=Password: Password to authenticate to Redis (default empty)
+//   - INSTANCE_NAME=Name: Unique and stable name of this instance, used to recover unprocessed mentions after a crash (default hostname)
+//   - WIDGET_PATH=URL Path: Serve an embeddable widget showing the mentions of a page under this path, e.g., /widget (default empty, disabled, requires STORAGE_FILE)
+//   - ANALYTICS_PATH=URL Path: Serve aggregate stats of the mentions for a public stats page under this path, at ANALYTICS_PATH/analytics?since=&until=, no individual mentions are listed (default empty, disabled, requires STORAGE_FILE)
+//   - ANALYTICS_THRESHOLD=Number: Only name source domains and pages with at least this many mentions in the stats, the others are counted in aggregate (default 5)
+//   - ANALYTICS_EPSILON=Number: Add Laplace noise of scale 1/ANALYTICS_EPSILON to the counts in the stats, smaller is more private, e.g., 1.0 (default 0, no noise)
+//   - ANALYTICS_SECRET=Secret: Derive the noise from this secret, so that it stays the same across restarts (default empty, random on every start)
+//   - NOTIFICATION_LOG=Path: Remember which notifications were sent in this file, to not send them twice after a restart or replay (default empty, uses Redis if REDIS_ADDR is set)
+//   - FETCH_LOCAL_ADDR=IP address: Fetch sources from this local address (default empty, any)
+//   - FETCH_PROXY=URL: Fetch sources through this proxy, e.g., socks5://localhost:9050 for Tor, required to accept mentions from onion services (default empty, no proxy)
+//   - HTTP_CACHE=memory or Path: Cache fetched sources as allowed by their Cache-Control, ETag, and Last-Modified headers, in memory or in this directory, see webmention.HTTPCache (default empty, disabled)
+//   - RETRIES=Number: How often to retry mentions that failed processing, e.g., because the source was unreachable (default 3)
+//   - RETRY_DELAY=Seconds: Wait this long before the first retry, doubling for every further retry (default 60)
+//   - DEAD_LETTERS=Path: Keep mentions that failed even after retrying in this file (default empty, discard them)
+//   - QUEUE_FILE=Path: Keep received mentions in this file until they are processed, so that they survive a restart, see webmention.FileQueue (default empty, in memory, cannot be used with REDIS_ADDR)
+//   - ALTERNATES=yes or no: Also look for the link in the AMP, mobile, or canonical version of a source (default no)
+//   - CANONICAL_SOURCES=yes or no: Store mentions under the canonical url a source declares, e.g., for AMP versions or mirrors, if the canonical page links to the target as well (default no)
+//   - LINK_WITHIN=Selectors: Only count links of html sources inside these comma separated elements, classes, or ids, e.g., .h-entry (default empty, the whole page)
+//   - LINK_EXCLUDE=Selectors: Ignore links of html sources inside these comma separated elements, classes, or ids, e.g., nav,footer,aside (default empty)
+//   - EXEC_HOOK=Command: Run this command for every mention, with the mention as JSON on stdin, and WEBMENTION_SOURCE, WEBMENTION_TARGET, and WEBMENTION_STATUS set (default empty, disabled); arguments are separated by spaces, no shell is involved
+//   - EXEC_HOOK_TIMEOUT=Seconds: Kill the command if it runs longer (default 30)
+//   - EXEC_HOOK_CONCURRENCY=Number: Run at most this many commands at once (default 4)
+//   - EXEC_HOOK_ENV=Names: Comma separated environment variables passed on to the command, which otherwise only gets PATH and HOME (default empty)
+//   - DATA_DIR=Path: Keep the verified mentions of every target in a JSON file of its own in this directory, e.g., the data directory of a static site, posts/hello.json for /posts/hello/ (default empty, disabled)
+//   - DATA_GIT=yes or no: Commit every changed file, DATA_DIR must be inside a git repository (default no)
+//   - DATA_GIT_PUSH=yes or no: Push every commit to the default remote (default no)
+//   - ISSUE_REPO=owner/name: Comment on an issue of this GitHub or Gitea repository for every mention (default empty, disabled)
+//   - ISSUE_NUMBER=Number: The issue to comment on (required with ISSUE_REPO)
+//   - ISSUE_TOKEN=Token: Access token allowed to comment on the issue (required with ISSUE_REPO)
+//   - ISSUE_API=URL: Base url of the API, e.g., https://gitea.example.com/api/v1 for Gitea (default https://api.github.com)
+//   - MICROPUB_ENDPOINT=URL: Create a draft reply on your site through this Micropub endpoint for every notable mention (default empty, disabled)
+//   - MICROPUB_TOKEN=Token: Access token with the create scope (required with MICROPUB_ENDPOINT)
+//   - MICROPUB_TYPES=Types: Comma separated mention types that are notable, e.g., reply,repost (default reply)
+//   - RELAY_ENDPOINT=URL: Forward every processed mention to this webmention endpoint as well, e.g., https://webmention.io/example.com/webmention during a migration (default empty, disabled); relayed mentions are never relayed again
+//   - SOURCE_SNAPSHOTS=Path: Directory of recorded source responses, see webmention.SnapshotFetcher (default empty)
+//   - SOURCE_SNAPSHOTS_MODE=replay or record: Verify mentions against the recorded responses only, never fetching sources from the network, or record the responses of the sources fetched (default replay)
+//   - PLUGINS_DIR=Path: Start every executable in this directory as a plugin, providing filters, notifiers, or media handlers, see webmention.Plugin for the protocol (default empty, no plugins)
+//   - USAGE_FILE=Path: Account the mentions received, and the bytes fetched to verify them, per domain and month in this file, see webmention.UsageMeter; tenants report their usage at TENANTS_PATH/ID/usage (default empty, disabled)
+//   - QUOTA_RECEIVED=Number: Mentions a domain (of a tenant) accepts per month, further mentions are answered with 429 Too Many Requests (default 0, no limit, requires USAGE_FILE)
+//   - QUOTA_FETCHED=Megabytes: Size of the sources fetched per domain (of a tenant) and month, after which further mentions are answered with 429 Too Many Requests (default 0, no limit, requires USAGE_FILE)
+//   - TENANTS_DIR=Path: Additionally serve other site owners (tenants), who register through an API, see webmention.TenantHost; the tenants are kept in tenants.json, and the mentions of every tenant in a file of its own, in this directory (default empty, disabled)
+//   - TENANTS_PATH=URL Path: Under which path to serve the tenants (default /hosted)
+//   - TENANT_REGISTRATION=indieauth, open or closed: Who may register, anyone proving to own the domains with IndieAuth, anyone, or only the operator (default indieauth)
+//   - TENANT_QUOTA=Number: Mentions a tenant accepts per day, unless the operator configured otherwise (default 1000, 0 no limit)
+//   - TENANT_OPERATOR_TOKEN=Token: Bearer token of the operator, who may register and change any tenant (default empty, no operator)
+//   - PROBE_NOTIFIERS=yes or no: Check at startup that notifiers are configured correctly (mail server reachable, tokens valid, ...), failures are logged and reported by /readyz, but don't stop the server (default no)
+//   - SELF_MENTIONS=reject or mark: Reject mentions whose source is the target itself (also after redirects), or accept and mark them (default reject)
+//   - DUPLICATE_ARGUMENTS=reject or first: Reject requests giving the source or target more than once, or as source[]=..., or take the first value (default reject)
+//   - SOURCE_FETCH=head-get, get, or auto: Fetch sources with a HEAD request followed by a GET, with a single GET, or skip the HEAD request for hosts that refused it (default head-get)
+//   - WALL_DETECTION=yes or no: Retry mentions whose source looks like a login or consent wall, instead of treating it as not linking to the target, see webmention.WithWallDetection (default yes)
+//   - SELF_DESCRIPTION=yes or no: Answer GET requests to the endpoint with a description of it (purpose, parameters, policy, status route), instead of 405 Method Not Allowed (default no)
+//   - WELL_KNOWN=yes or no: Publish a policy at /.well-known/webmention, as an interop experiment (default no)
+//   - WELL_KNOWN_ENDPOINT=URL: Endpoint advertised in the policy (default ACCEPT_DOMAIN with ENDPOINT_URL)
+//   - WELL_KNOWN_RATE_LIMIT=Number: Mentions per minute that senders are asked to post at most (default 0, no limit)
 //
 // Options for external SMTP server:
 //   - MAIL_HOST=Domain: Domain of the outgoing mail server (no default, required)
 //   - MAIL_PORT=Port: Port of the outgoing mail server (no default, required)
 //   - MAIL_USER=Username: User to authenticate to the outgoing mail server (no default, required)
-//   - MAIL_PASS=Password: Password to authenticate to the outgoing mail server (no default, required)
+//   - MAIL_PASS=Password: Password to authenticate to the outgoing mail server (no default, required with MAIL_AUTH=password)
+//   - MAIL_AUTH=password or xoauth2: How to authenticate to the outgoing mail server (default password)
+//
+// Options for MAIL_AUTH=xoauth2:
+//   - MAIL_OAUTH_TOKEN_URL=URL: Token endpoint, e.g., https://oauth2.googleapis.com/token (required)
+//   - MAIL_OAUTH_CLIENT_ID=ID: OAuth2 client id (required)
+//   - MAIL_OAUTH_CLIENT_SECRET=Secret: OAuth2 client secret (default empty)
+//   - MAIL_OAUTH_REFRESH_TOKEN=Token: Refresh token, if not set, the client credentials grant is used (default empty)
+//   - MAIL_OAUTH_REFRESH_TOKEN_FILE=Path: Keep the refresh token in this file, required if the provider rotates refresh tokens; takes precedence over MAIL_OAUTH_REFRESH_TOKEN once it exists (default empty)
+//   - MAIL_OAUTH_SCOPES=Scopes: Space separated scopes to request, e.g., https://outlook.office365.com/.default (default empty)
 //   - MAIL_FROM=E-Mail address: Address used in the FROM header (default same as MAIL_USER)
-//   - MAIL_TO=E-Mail address: Address used in the TO header (default same as MAIL_FROM, or MAIL_USER if MAIL_FROM not set)
+//   - MAIL_TO=E-Mail addresses: Comma separated addresses used in the TO header (default same as MAIL_FROM, or MAIL_USER if MAIL_FROM not set)
 //
 // Options for internal SMPT server:
 //   - MAIL_FROM=E-Mail address: Send emails from this address (required)
-//   - MAIL_TO=E-Mail address: Send emails to this email address (required)
+//   - MAIL_TO=E-Mail addresses: Send emails to these comma separated addresses, all served by MAIL_TO_ADDR (required)
 //   - MAIL_FROM_ADDR=Domain: Domain from which to send mails (required)
 //   - MAIL_TO_ADDR=Domain: Domain of the receiving mail server (required)
-//   - MAIL_DKIM_PRIV=Path to private key: Path to private key used for dkim signing (default empty, don't sign)
+//   - MAIL_DKIM_PRIV=Path to private key: Path to private key used for dkim signing, or the PEM encoded key itself (default empty, don't sign)
 //   - MAIL_DKIM_SELECTOR=Selector: DKIM selector (default is "default")
 //   - MAIL_DKIM_HOST=Domain: Domain on which DKIM is configured
 //
 // For more information on how to setup the internal mail server, check the
 // documentation on ConfigMailInternal.
+// To check the mail setup, send a test mail, and verify the DNS records
+// needed for SPF, DKIM, and DMARC:
+//
+//	mentionee mail-selftest you@example.com
 //
 // Configuration is reloaded on SIGHUP.
+//
+// Stored mentions (requires STORAGE_FILE) can be re-sent to the configured
+// notifiers, e.g., after setting up a new one:
+//
+//	mentionee replay [-since 2006-01-02] [-until 2006-01-02] [-target URL]
+//
+// Mentions that failed processing (requires DEAD_LETTERS) can be listed,
+// retried, or discarded:
+//
+//	mentionee dead-letters [retry ID | discard ID]
+//
+// Mentions held for moderation (requires SPAM_FILTER and MODERATION_QUEUE)
+// can be listed, approved, or rejected:
+//
+//	mentionee moderation [approve ID | reject ID]
+//
+// The version (see webmention.Version) is printed with:
+//
+//	mentionee --version
 package main
 
 import (
@@ -47,20 +179,24 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/cvanloo/parsenv"
 	"github.com/emersion/go-msgauth/dkim"
-	"github.com/joho/godotenv"
 	"gopkg.in/gomail.v2"
 
 	webmention "github.com/cvanloo/gowebmention"
 	"github.com/cvanloo/gowebmention/listener"
+	"github.com/cvanloo/gowebmention/service"
 )
 
 func init() {
@@ -68,20 +204,109 @@ func init() {
 }
 
 var Config struct {
-	ShutdownTimeout int    `cfg:"default=120"`
-	EndpointUrl     string `cfg:"default=/api/webmention"`
-	ListenAddr      string `cfg:"default=:8080"`
-	AcceptDomain    string `cfg:"required"`
-	NotifyByMail    string `cfg:"default=no"`
+	ShutdownTimeout     int    `cfg:"default=120"`
+	EndpointUrl         string `cfg:"default=/api/webmention"`
+	ListenAddr          string `cfg:"default=:8080"`
+	AcceptDomain        string `cfg:"required"`
+	AcceptRules         string
+	TargetSitemap       string
+	NotifyByMail        string `cfg:"default=no"`
+	MailBatch           string `cfg:"default=interval=12h"`
+	MailQueue           string
+	MailJson            string `cfg:"default=no"`
+	ReactionThreshold   int    `cfg:"default=0"`
+	MailCc              string
+	MailBcc             string
+	MailTargets         string
+	MailRetryPeriod     int    `cfg:"default=72"`
+	Hardening           string `cfg:"default=yes"`
+	AccessLog           string `cfg:"default=yes"`
+	SlowRequest         int    `cfg:"default=1000"`
+	StorageFile         string
+	SummaryReport       string `cfg:"default=no"`
+	SpamFilter          string `cfg:"default=no"`
+	ModerationQueue     string
+	ModerationSecret    string
+	ModerationLinkHours int `cfg:"default=168"`
+	DomainBlocklists    string
+	IpBlocklists        string
+	MaxRejections       int `cfg:"default=0"`
+	RedisAddr           string
+	RedisPassword       string
+	InstanceName        string
+	WidgetPath          string
+	AnalyticsPath       string
+	AnalyticsThreshold  int     `cfg:"default=5"`
+	AnalyticsEpsilon    float64 `cfg:"default=0"`
+	AnalyticsSecret     string
+	NotificationLog     string
+	FetchLocalAddr      string
+	FetchProxy          string
+	HttpCache           string
+	Retries             int `cfg:"default=3"`
+	RetryDelay          int `cfg:"default=60"`
+	DeadLetters         string
+	QueueFile           string
+	Alternates          string `cfg:"default=no"`
+	CanonicalSources    string `cfg:"default=no"`
+	LinkWithin          string
+	LinkExclude         string
+	SelfMentions        string `cfg:"default=reject"`
+	DuplicateArguments  string `cfg:"default=reject"`
+	SourceFetch         string `cfg:"default=head-get"`
+	WallDetection       string `cfg:"default=yes"`
+	SelfDescription     string `cfg:"default=no"`
+	ProbeNotifiers      string `cfg:"default=no"`
+	UsageFile           string
+	QuotaReceived       int `cfg:"default=0"`
+	QuotaFetched        int `cfg:"default=0"`
+	TenantsDir          string
+	TenantsPath         string `cfg:"default=/hosted"`
+	TenantRegistration  string `cfg:"default=indieauth"`
+	TenantQuota         int    `cfg:"default=1000"`
+	TenantOperatorToken string
+	PluginsDir          string
+	SourceSnapshots     string
+	SourceSnapshotsMode string `cfg:"default=replay"`
+	ExecHook            string
+	ExecHookTimeout     int `cfg:"default=30"`
+	ExecHookConcurrency int `cfg:"default=4"`
+	ExecHookEnv         string
+	DataDir             string
+	DataGit             string `cfg:"default=no"`
+	DataGitPush         string `cfg:"default=no"`
+	IssueRepo           string
+	IssueNumber         int `cfg:"default=0"`
+	IssueToken          string
+	IssueApi            string `cfg:"default=https://api.github.com"`
+	MicropubEndpoint    string
+	MicropubToken       string
+	MicropubTypes       string `cfg:"default=reply"`
+	RelayEndpoint       string
+	WellKnown           string `cfg:"default=no"`
+	WellKnownEndpoint   string
+	WellKnownRateLimit  int `cfg:"default=0"`
 }
 
 var ConfigMailExternal struct {
 	MailHost string `cfg:"required"`
 	MailPort int    `cfg:"required"`
 	MailUser string `cfg:"required"`
-	MailPass string `cfg:"required"`
+	MailPass string
 	MailFrom string
 	MailTo   string
+	MailAuth string `cfg:"default=password"`
+}
+
+// With MAIL_AUTH=xoauth2, the external SMTP server is authenticated to with
+// an OAuth2 access token instead of a password (e.g., for Gmail or Office 365).
+var ConfigMailOAuth struct {
+	MailOauthTokenUrl         string `cfg:"required"`
+	MailOauthClientId         string `cfg:"required"`
+	MailOauthClientSecret     string
+	MailOauthRefreshToken     string
+	MailOauthRefreshTokenFile string
+	MailOauthScopes           string
 }
 
 // Mentionee can deliver emails directly to your inbox.
@@ -142,126 +367,440 @@ const (
 	ExitConfigError = -1
 )
 
-func loadConfig() (opts []webmention.ReceiverOption, listenAddr, endpoint string, shutdownTimeout time.Duration, agg *listener.ReportAggregator, err error) {
-	if err := godotenv.Load(); err != nil {
-		godotenv.Load("/etc/webmention/mentionee.env")
+// loadedConfig is the result of loading the configuration.
+type loadedConfig struct {
+	service         *service.Service
+	mail            *service.MailConfig
+	listenAddr      string
+	shutdownTimeout time.Duration
+}
+
+// secretFields are the configuration values that may be read from secret
+// sources, instead of plain env vars.
+var secretFields = map[string]*string{
+	"REDIS_PASSWORD":           &Config.RedisPassword,
+	"ISSUE_TOKEN":              &Config.IssueToken,
+	"MICROPUB_TOKEN":           &Config.MicropubToken,
+	"MAIL_PASS":                &ConfigMailExternal.MailPass,
+	"MAIL_OAUTH_CLIENT_SECRET": &ConfigMailOAuth.MailOauthClientSecret,
+	"MAIL_OAUTH_REFRESH_TOKEN": &ConfigMailOAuth.MailOauthRefreshToken,
+	"MAIL_DKIM_PRIV":           &ConfigMailInternal.MailDkimPriv,
+	"MODERATION_SECRET":        &Config.ModerationSecret,
+	"ANALYTICS_SECRET":         &Config.AnalyticsSecret,
+	"TENANT_OPERATOR_TOKEN":    &Config.TenantOperatorToken,
+}
+
+// loadSecrets reads the secretFields from the secret sources straight into
+// the configuration, without exporting them to the environment, where
+// they'd be inherited by the exec hook and plugins.
+// The mail configuration is only loaded later on, parsenv leaves the fields
+// of empty env vars alone.
+func loadSecrets() error {
+	secrets, err := webmention.LoadSecrets(webmention.DefaultSecretSources(), slices.Collect(maps.Keys(secretFields))...)
+	if err != nil {
+		return err
+	}
+	for name, value := range secrets {
+		*secretFields[name] = value
+	}
+	return nil
+}
+
+// loadConfig maps the environment to a service.Config, and assembles the service.
+func loadConfig() (cfg loadedConfig, err error) {
+	serviceConfig, err := loadServiceConfig()
+	if err != nil {
+		return cfg, err
 	}
+	cfg.listenAddr = Config.ListenAddr
+	cfg.shutdownTimeout = time.Duration(Config.ShutdownTimeout) * time.Second
+	cfg.mail = serviceConfig.Mail
+	cfg.service, err = service.New(serviceConfig, webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+		slog.Info("received webmention",
+			"source", mention.Source.String(),
+			"target", mention.Target.String(),
+			"status", mention.Status,
+		)
+	})))
+	return cfg, err
+}
+
+func loadServiceConfig() (cfg service.Config, err error) {
+	loadEnv()
 	if err := parsenv.Load(&Config); err != nil {
-		return opts, listenAddr, endpoint, shutdownTimeout, agg, err
+		return cfg, err
+	}
+	if err := loadSecrets(); err != nil {
+		return cfg, err
 	}
-	listenAddr = Config.ListenAddr
-	endpoint = Config.EndpointUrl
-	shutdownTimeout = time.Duration(Config.ShutdownTimeout) * time.Second
-	acceptDomain, err := url.Parse(Config.AcceptDomain)
+	resolveInstancePaths()
+	cfg = service.Config{
+		Endpoint:             Config.EndpointUrl,
+		AcceptRules:          Config.AcceptRules,
+		TargetSitemap:        Config.TargetSitemap,
+		Hardening:            Config.Hardening == "yes",
+		Alternates:           Config.Alternates == "yes",
+		CanonicalSources:     Config.CanonicalSources == "yes",
+		LinkWithin:           splitList(Config.LinkWithin),
+		LinkExclude:          splitList(Config.LinkExclude),
+		SourceFetch:          webmention.FetchStrategy(Config.SourceFetch),
+		DisableWallDetection: Config.WallDetection == "no",
+		SelfDescription:      Config.SelfDescription == "yes",
+		Retries:              Config.Retries,
+		RetryDelay:           time.Duration(Config.RetryDelay) * time.Second,
+		HTTPCache:            Config.HttpCache,
+		SourceSnapshots:      Config.SourceSnapshots,
+		StorageFile:          Config.StorageFile,
+		QueueFile:            Config.QueueFile,
+		DeadLetters:          Config.DeadLetters,
+		NotificationLog:      Config.NotificationLog,
+		SpamFilter:           Config.SpamFilter == "yes",
+		ModerationQueue:      Config.ModerationQueue,
+		DomainBlocklists:     splitList(Config.DomainBlocklists),
+		IPBlocklists:         splitList(Config.IpBlocklists),
+		MaxRejections:        Config.MaxRejections,
+		ExecHook:             strings.Fields(Config.ExecHook),
+		ExecHookTimeout:      time.Duration(Config.ExecHookTimeout) * time.Second,
+		ExecHookConcurrency:  Config.ExecHookConcurrency,
+		ExecHookEnv:          splitList(Config.ExecHookEnv),
+		DataDir:              Config.DataDir,
+		DataGit:              Config.DataGit == "yes",
+		DataGitPush:          Config.DataGitPush == "yes",
+		PluginsDir:           Config.PluginsDir,
+		UsageFile:            Config.UsageFile,
+		Quota: webmention.UsageQuota{
+			Received:     Config.QuotaReceived,
+			FetchedBytes: int64(Config.QuotaFetched) << 20,
+		},
+		WidgetPath:    Config.WidgetPath,
+		AnalyticsPath: Config.AnalyticsPath,
+		Analytics: webmention.AnalyticsPolicy{
+			Threshold: Config.AnalyticsThreshold,
+			Epsilon:   Config.AnalyticsEpsilon,
+			Secret:    []byte(Config.AnalyticsSecret),
+		},
+		ProbeNotifiers: Config.ProbeNotifiers == "yes",
+	}
+	cfg.AcceptDomain, err = url.Parse(Config.AcceptDomain)
 	if err != nil {
-		return opts, listenAddr, endpoint, shutdownTimeout, agg, err
+		return cfg, fmt.Errorf("ACCEPT_DOMAIN: %w", err)
+	}
+	if Config.WellKnown == "yes" {
+		cfg.WellKnown = &webmention.WellKnownPolicy{
+			Endpoint:  Config.WellKnownEndpoint,
+			RateLimit: Config.WellKnownRateLimit,
+		}
+	}
+	switch Config.SelfMentions {
+	case "reject":
+	case "mark":
+		cfg.SelfMentions = webmention.MarkSelfMentions
+	default:
+		return cfg, fmt.Errorf("SELF_MENTIONS: expected reject or mark, got: %s", Config.SelfMentions)
 	}
-	opts = append(opts, webmention.WithAcceptsFunc(func(source, target *url.URL) bool {
-		return target.Scheme == acceptDomain.Scheme && target.Host == acceptDomain.Host
-	}))
-	if Config.NotifyByMail == "external" {
+	switch Config.DuplicateArguments {
+	case "reject":
+	case "first":
+		cfg.DuplicateArguments = webmention.FirstArgument
+	default:
+		return cfg, fmt.Errorf("DUPLICATE_ARGUMENTS: expected reject or first, got: %s", Config.DuplicateArguments)
+	}
+	switch webmention.FetchStrategy(Config.SourceFetch) {
+	case webmention.FetchHeadThenGet, webmention.FetchGetOnly, webmention.FetchAuto:
+	default:
+		return cfg, fmt.Errorf("SOURCE_FETCH: expected head-get, get, or auto, got: %s", Config.SourceFetch)
+	}
+	if Config.RedisAddr != "" {
+		cfg.Redis = &service.RedisConfig{
+			Addr:     Config.RedisAddr,
+			Password: Config.RedisPassword,
+			Instance: Config.InstanceName,
+		}
+		if cfg.Redis.Instance == "" {
+			cfg.Redis.Instance, err = os.Hostname()
+			if err != nil {
+				return cfg, fmt.Errorf("INSTANCE_NAME not set: %w", err)
+			}
+			if name := os.Getenv("MENTIONEE_INSTANCE"); name != "" {
+				cfg.Redis.Instance += "@" + name
+			}
+		}
+	}
+	if Config.QueueFile != "" && Config.RedisAddr != "" {
+		return cfg, errors.New("QUEUE_FILE: cannot be used with REDIS_ADDR")
+	}
+	if Config.FetchLocalAddr != "" {
+		cfg.FetchLocalAddr, err = netip.ParseAddr(Config.FetchLocalAddr)
+		if err != nil {
+			return cfg, fmt.Errorf("FETCH_LOCAL_ADDR: %w", err)
+		}
+	}
+	if Config.SourceSnapshots != "" {
+		switch Config.SourceSnapshotsMode {
+		case "replay":
+		case "record":
+			cfg.RecordSnapshots = true
+		default:
+			return cfg, fmt.Errorf("SOURCE_SNAPSHOTS_MODE: expected replay or record, got: %s", Config.SourceSnapshotsMode)
+		}
+	}
+	if Config.FetchProxy != "" {
+		cfg.FetchProxy, err = url.Parse(Config.FetchProxy)
+		if err != nil {
+			return cfg, fmt.Errorf("FETCH_PROXY: %w", err)
+		}
+	}
+	if Config.NotifyByMail == "external" || Config.NotifyByMail == "internal" {
+		cfg.Mail, err = loadMailConfig()
+		if err != nil {
+			return cfg, err
+		}
+	}
+	if Config.IssueRepo != "" {
+		if Config.IssueNumber <= 0 || Config.IssueToken == "" {
+			return cfg, errors.New("ISSUE_REPO requires ISSUE_NUMBER and ISSUE_TOKEN to be configured")
+		}
+		cfg.Issue = &listener.IssueCommenter{
+			API:   Config.IssueApi,
+			Repo:  Config.IssueRepo,
+			Issue: Config.IssueNumber,
+			Token: Config.IssueToken,
+		}
+	}
+	if Config.MicropubEndpoint != "" {
+		if Config.MicropubToken == "" {
+			return cfg, errors.New("MICROPUB_ENDPOINT requires MICROPUB_TOKEN to be configured")
+		}
+		var types []webmention.MentionType
+		for _, kind := range splitList(Config.MicropubTypes) {
+			types = append(types, webmention.MentionType(kind))
+		}
+		cfg.Micropub = &listener.MicropubPoster{
+			Endpoint: Config.MicropubEndpoint,
+			Token:    Config.MicropubToken,
+			Notable:  listener.NotableTypes(types...),
+		}
+	}
+	if Config.RelayEndpoint != "" {
+		cfg.Relay, err = url.Parse(Config.RelayEndpoint)
+		if err != nil {
+			return cfg, fmt.Errorf("RELAY_ENDPOINT: %w", err)
+		}
+	}
+	if Config.SummaryReport != "no" {
+		if cfg.Mail == nil {
+			return cfg, errors.New("SUMMARY_REPORT requires NOTIFY_BY_MAIL to be configured")
+		}
+		if Config.StorageFile == "" {
+			return cfg, errors.New("SUMMARY_REPORT requires STORAGE_FILE to be configured")
+		}
+		cfg.Mail.SummaryReport = true
+		switch Config.SummaryReport {
+		case "monthly":
+			cfg.Mail.SummaryPeriod = listener.Monthly
+		case "yearly":
+			cfg.Mail.SummaryPeriod = listener.Yearly
+		default:
+			return cfg, fmt.Errorf("invalid SUMMARY_REPORT: %s", Config.SummaryReport)
+		}
+	}
+	if Config.ModerationSecret != "" {
+		if cfg.Mail == nil {
+			return cfg, errors.New("MODERATION_SECRET requires NOTIFY_BY_MAIL to be configured")
+		}
+		if Config.SpamFilter != "yes" {
+			return cfg, errors.New("MODERATION_SECRET requires SPAM_FILTER to be enabled")
+		}
+		cfg.Mail.ModerationSecret = Config.ModerationSecret
+		cfg.Mail.ModerationLinkExpiry = time.Duration(Config.ModerationLinkHours) * time.Hour
+	}
+	if Config.UsageFile == "" && (Config.QuotaReceived > 0 || Config.QuotaFetched > 0) {
+		return cfg, errors.New("QUOTA_RECEIVED and QUOTA_FETCHED require USAGE_FILE to be configured")
+	}
+	if Config.TenantsDir != "" {
+		cfg.Tenants = &service.TenantsConfig{
+			Dir:           Config.TenantsDir,
+			Path:          Config.TenantsPath,
+			Quota:         Config.TenantQuota,
+			OperatorToken: Config.TenantOperatorToken,
+		}
+		switch Config.TenantRegistration {
+		case "indieauth":
+			cfg.Tenants.Registration = webmention.RegisterIndieAuth
+		case "open":
+			cfg.Tenants.Registration = webmention.RegisterOpen
+		case "closed":
+			cfg.Tenants.Registration = webmention.RegisterClosed
+		default:
+			return cfg, fmt.Errorf("TENANT_REGISTRATION: expected indieauth, open or closed, got: %s", Config.TenantRegistration)
+		}
+	}
+	return cfg, nil
+}
+
+// splitList splits a comma separated list, dropping empty elements.
+func splitList(list string) (elems []string) {
+	for _, elem := range strings.Split(list, ",") {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			elems = append(elems, elem)
+		}
+	}
+	return elems
+}
+
+// loadMailConfig configures the mailer selected by NOTIFY_BY_MAIL.
+func loadMailConfig() (*service.MailConfig, error) {
+	policy, err := listener.ParseBatchPolicy(Config.MailBatch)
+	if err != nil {
+		return nil, fmt.Errorf("MAIL_BATCH: %w", err)
+	}
+	cfg := &service.MailConfig{
+		Batch:             policy,
+		Queue:             Config.MailQueue,
+		RetryPeriod:       time.Duration(Config.MailRetryPeriod) * time.Hour,
+		ReactionThreshold: Config.ReactionThreshold,
+	}
+	switch Config.NotifyByMail {
+	case "external":
 		if err := parsenv.Load(&ConfigMailExternal); err != nil {
-			return opts, listenAddr, endpoint, shutdownTimeout, agg, err
+			return nil, err
 		}
 		dialer := gomail.NewDialer(ConfigMailExternal.MailHost, ConfigMailExternal.MailPort, ConfigMailExternal.MailUser, ConfigMailExternal.MailPass)
+		switch ConfigMailExternal.MailAuth {
+		case "password":
+			if ConfigMailExternal.MailPass == "" {
+				return nil, errors.New("MAIL_PASS is required with MAIL_AUTH=password")
+			}
+		case "xoauth2":
+			if err := parsenv.Load(&ConfigMailOAuth); err != nil {
+				return nil, err
+			}
+			dialer.Auth = listener.XOAuth2{
+				User: ConfigMailExternal.MailUser,
+				Tokens: &listener.OAuth2Client{
+					TokenURL:         ConfigMailOAuth.MailOauthTokenUrl,
+					ClientID:         ConfigMailOAuth.MailOauthClientId,
+					ClientSecret:     ConfigMailOAuth.MailOauthClientSecret,
+					RefreshToken:     ConfigMailOAuth.MailOauthRefreshToken,
+					RefreshTokenFile: instancePath(ConfigMailOAuth.MailOauthRefreshTokenFile),
+					Scopes:           strings.Fields(ConfigMailOAuth.MailOauthScopes),
+				},
+			}
+		default:
+			return nil, fmt.Errorf("invalid MAIL_AUTH: %s", ConfigMailExternal.MailAuth)
+		}
 		from := ConfigMailExternal.MailUser
 		if ConfigMailExternal.MailFrom != "" {
 			from = ConfigMailExternal.MailFrom
 		}
-		to := from
+		to := []string{from}
 		if ConfigMailExternal.MailTo != "" {
-			to = ConfigMailExternal.MailTo
-		}
-		mailer := listener.ExternalMailer{
-			SubjectLine: listener.DefaultSubjectLine,
-			Body:        listener.DefaultBody,
-			From:        from,
-			To:          to,
-			Dialer:      dialer,
-		}
-		aggregator := &listener.ReportAggregator{
-			SendAfterTime:  12 * time.Hour,
-			SendAfterCount: -1,
-			Sender:         mailer,
-		}
-		opts = append(opts, webmention.WithNotifier(listener.Mailer{aggregator}))
-		agg = aggregator
-	} else if Config.NotifyByMail == "internal" {
+			to = splitList(ConfigMailExternal.MailTo)
+		}
+		recipients, err := loadRecipients(to)
+		if err != nil {
+			return nil, err
+		}
+		cfg.External = &listener.ExternalMailer{
+			From:       from,
+			Recipients: recipients,
+			Dialer:     dialer,
+			AttachJSON: Config.MailJson == "yes",
+		}
+		return cfg, nil
+	case "internal":
 		if err := parsenv.Load(&ConfigMailInternal); err != nil {
-			return opts, listenAddr, endpoint, shutdownTimeout, agg, err
+			return nil, err
 		}
-		if ConfigMailInternal.MailDkimPriv != "" {
-			if err := parsenv.Load(&ConfigMailDkim); err != nil {
-				return opts, listenAddr, endpoint, shutdownTimeout, agg, err
-			}
-			pkbs, err := os.ReadFile(ConfigMailInternal.MailDkimPriv)
-			if err != nil {
-				return opts, listenAddr, endpoint, shutdownTimeout, agg, err
-			}
-			block, _ := pem.Decode(pkbs)
-			if block == nil {
-				return opts, listenAddr, endpoint, shutdownTimeout, agg, errors.New("failed to decode PEM block containing private key")
-			}
-			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		recipients, err := loadRecipients(splitList(ConfigMailInternal.MailTo))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Internal = &listener.InternalMailer{
+			FromAddr:   ConfigMailInternal.MailFromAddr,
+			ToAddr:     ConfigMailInternal.MailToAddr,
+			From:       ConfigMailInternal.MailFrom,
+			Recipients: recipients,
+			AttachJSON: Config.MailJson == "yes",
+		}
+		if ConfigMailInternal.MailDkimPriv == "" {
+			return cfg, nil
+		}
+		if err := parsenv.Load(&ConfigMailDkim); err != nil {
+			return nil, err
+		}
+		pkbs := []byte(ConfigMailInternal.MailDkimPriv)
+		if !strings.HasPrefix(ConfigMailInternal.MailDkimPriv, "-----BEGIN") {
+			pkbs, err = os.ReadFile(instancePath(ConfigMailInternal.MailDkimPriv))
 			if err != nil {
-				return opts, listenAddr, endpoint, shutdownTimeout, agg, err
-			}
-			pk, ok := key.(*rsa.PrivateKey)
-			if !ok {
-				return opts, listenAddr, endpoint, shutdownTimeout, agg, fmt.Errorf("not an RSA private key: %T", key)
-			}
-			mailer := listener.InternalDKIMMailer{
-				InternalMailer: listener.InternalMailer{
-					SubjectLine: listener.DefaultSubjectLine,
-					Body:        listener.DefaultBody,
-					FromAddr:    ConfigMailInternal.MailFromAddr,
-					ToAddr:      ConfigMailInternal.MailToAddr,
-					From:        ConfigMailInternal.MailFrom,
-					To:          ConfigMailInternal.MailTo,
-				},
-				DkimSignOpts: &dkim.SignOptions{
-					Domain:   ConfigMailDkim.MailDkimHost,
-					Selector: ConfigMailDkim.MailDkimSelector,
-					Signer:   pk,
-				},
+				return nil, err
 			}
-			aggregator := &listener.ReportAggregator{
-				SendAfterTime:  12 * time.Hour,
-				SendAfterCount: -1,
-				Sender:         mailer,
-			}
-			opts = append(opts, webmention.WithNotifier(listener.Mailer{aggregator}))
-			agg = aggregator
-		} else {
-			mailer := listener.InternalMailer{
-				SubjectLine: listener.DefaultSubjectLine,
-				Body:        listener.DefaultBody,
-				FromAddr:    ConfigMailInternal.MailFromAddr,
-				ToAddr:      ConfigMailInternal.MailToAddr,
-				From:        ConfigMailInternal.MailFrom,
-				To:          ConfigMailInternal.MailTo,
-			}
-			aggregator := &listener.ReportAggregator{
-				SendAfterTime:  12 * time.Hour,
-				SendAfterCount: -1,
-				Sender:         mailer,
-			}
-			opts = append(opts, webmention.WithNotifier(listener.Mailer{aggregator}))
-			agg = aggregator
 		}
+		block, _ := pem.Decode(pkbs)
+		if block == nil {
+			return nil, errors.New("failed to decode PEM block containing private key")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pk, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("not an RSA private key: %T", key)
+		}
+		cfg.DKIM = &dkim.SignOptions{
+			Domain:   ConfigMailDkim.MailDkimHost,
+			Selector: ConfigMailDkim.MailDkimSelector,
+			Signer:   pk,
+		}
+		return cfg, nil
+	default:
+		return nil, fmt.Errorf("invalid NOTIFY_BY_MAIL: %s", Config.NotifyByMail)
 	}
-	return opts, listenAddr, endpoint, shutdownTimeout, agg, nil
 }
 
-type OptionsCollection []webmention.ReceiverOption
-
-func (c OptionsCollection) Configuration(r *webmention.Receiver) {
-	for _, f := range c {
-		f(r)
+// loadRecipients adds MAIL_CC, MAIL_BCC, and MAIL_TARGETS to the to addresses.
+func loadRecipients(to []string) (listener.Recipients, error) {
+	recipients := listener.Recipients{
+		To:  to,
+		Cc:  splitList(Config.MailCc),
+		Bcc: splitList(Config.MailBcc),
+	}
+	for _, filter := range strings.Split(Config.MailTargets, ";") {
+		if filter = strings.TrimSpace(filter); filter == "" {
+			continue
+		}
+		address, prefixes, ok := strings.Cut(filter, "=")
+		if !ok {
+			return recipients, fmt.Errorf("MAIL_TARGETS: expected address=prefix,...: %s", filter)
+		}
+		if recipients.Targets == nil {
+			recipients.Targets = map[string][]string{}
+		}
+		address = strings.TrimSpace(address)
+		recipients.Targets[address] = append(recipients.Targets[address], splitList(prefixes)...)
 	}
+	return recipients, nil
 }
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == "--version" || os.Args[1] == "-version") {
+		fmt.Println("mentionee", webmention.Build())
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mail-selftest" {
+		os.Exit(mailSelftest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dead-letters" {
+		os.Exit(deadLetters(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "moderation" {
+		os.Exit(moderation(os.Args[2:]))
+	}
+
 	reload := make(chan os.Signal, 1)
 	signal.Notify(reload, syscall.SIGHUP) // kill -HUP $(pidof mentionee)
 
@@ -270,7 +809,7 @@ func main() {
 
 appLoop:
 	for {
-		options, listenAddr, endpoint, shutdownTimeout, aggregator, err := loadConfig()
+		cfg, err := loadConfig()
 		if err != nil {
 			slog.Error("erroneous configuration, *** all services stopped ***: ", "configError", err)
 			slog.Error("...waiting for SIGHUP (reload config) or SIGTERM/INT (terminate)")
@@ -285,28 +824,20 @@ appLoop:
 			}
 		}
 
-		receiver := webmention.NewReceiver(
-			webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
-				slog.Info("received webmention",
-					"source", mention.Source.String(),
-					"target", mention.Target.String(),
-					"status", mention.Status,
-				)
-			})),
-			OptionsCollection(options).Configuration,
-		)
-
-		if aggregator != nil {
-			go aggregator.Start()
+		svc := cfg.service
+		if err := svc.Start(); err != nil {
+			slog.Error(fmt.Sprintf("failed to start: %s", err))
+			os.Exit(ExitFailure)
 		}
-		go receiver.ProcessMentions()
 
-		mux := &http.ServeMux{}
-		mux.Handle(endpoint, receiver)
+		handler := svc.Handler()
+		if Config.AccessLog == "yes" {
+			handler = accessLog(handler, time.Duration(Config.SlowRequest)*time.Millisecond)
+		}
 
 		server := http.Server{
-			Addr:    listenAddr,
-			Handler: mux,
+			Addr:    cfg.listenAddr,
+			Handler: handler,
 		}
 
 		go func() {
@@ -318,16 +849,13 @@ appLoop:
 		}()
 
 		doShutdown := func() {
-			shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), shutdownTimeout)
+			shutdownCtx, shutdownRelease := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
 			server.SetKeepAlivesEnabled(false)
 			defer shutdownRelease()
 			if err := server.Shutdown(shutdownCtx); err != nil {
 				slog.Error(fmt.Sprintf("http shutdown error: %s", err))
 			}
-			receiver.Shutdown(shutdownCtx)
-			if aggregator != nil {
-				aggregator.SendNow()
-			}
+			svc.Shutdown(shutdownCtx)
 		}
 
 		select {
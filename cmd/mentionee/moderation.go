@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// moderation lists, approves or rejects mentions held for moderation.
+//
+//	mentionee moderation [approve ID | reject ID]
+func moderation(args []string) (exitCode int) {
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("erroneous configuration", "configError", err)
+		return ExitConfigError
+	}
+	if Config.SpamFilter != "yes" || Config.ModerationQueue == "" {
+		fmt.Fprintln(os.Stderr, "moderation: SPAM_FILTER and MODERATION_QUEUE not configured")
+		return ExitConfigError
+	}
+	receiver := cfg.service.Receiver
+
+	switch {
+	case len(args) == 0:
+		pending, err := receiver.Pending()
+		if err != nil {
+			slog.Error(fmt.Sprintf("moderation: %s", err))
+			return ExitFailure
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(pending); err != nil {
+			slog.Error(fmt.Sprintf("moderation: %s", err))
+			return ExitFailure
+		}
+		return ExitSuccess
+	case len(args) == 2 && args[0] == "approve":
+		if err := receiver.Approve(args[1]); err != nil {
+			slog.Error(fmt.Sprintf("moderation: approve: %s", err))
+			return ExitFailure
+		}
+		if err := cfg.service.Flush(); err != nil {
+			slog.Error(fmt.Sprintf("moderation: sending aggregated report failed: %s", err))
+			return ExitFailure
+		}
+		return ExitSuccess
+	case len(args) == 2 && args[0] == "reject":
+		if err := receiver.Reject(args[1]); err != nil {
+			slog.Error(fmt.Sprintf("moderation: reject: %s", err))
+			return ExitFailure
+		}
+		return ExitSuccess
+	default:
+		fmt.Fprintln(os.Stderr, "usage: mentionee moderation [approve ID | reject ID]")
+		return ExitConfigError
+	}
+}
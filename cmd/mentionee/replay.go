@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// replay re-dispatches stored mentions through the configured notifiers.
+func replay(args []string) (exitCode int) {
+	var (
+		filter       webmention.MentionFilter
+		since, until string
+	)
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	flags.StringVar(&since, "since", "", "only replay mentions received on or after this date (2006-01-02)")
+	flags.StringVar(&until, "until", "", "only replay mentions received before this date (2006-01-02)")
+	flags.StringVar(&filter.Target, "target", "", "only replay mentions of this target url")
+	if err := flags.Parse(args); err != nil {
+		return ExitConfigError
+	}
+	if since != "" {
+		t, err := time.Parse(time.DateOnly, since)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -since: %s\n", err)
+			return ExitConfigError
+		}
+		filter.Since = t
+	}
+	if until != "" {
+		t, err := time.Parse(time.DateOnly, until)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -until: %s\n", err)
+			return ExitConfigError
+		}
+		filter.Until = t
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("erroneous configuration", "configError", err)
+		return ExitConfigError
+	}
+	receiver := cfg.service.Receiver
+	n, err := receiver.Replay(filter)
+	if err != nil {
+		slog.Error(fmt.Sprintf("replay failed: %s", err))
+		return ExitFailure
+	}
+	if err := cfg.service.Flush(); err != nil {
+		slog.Error(fmt.Sprintf("replay: sending aggregated report failed: %s", err))
+		return ExitFailure
+	}
+	slog.Info(fmt.Sprintf("replayed %d mentions", n))
+	return ExitSuccess
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/listener"
+)
+
+// mailSelftest sends a test email through the configured mailer, and checks
+// the DNS records needed for it to pass SPF, DKIM, and DMARC.
+//
+//	mentionee mail-selftest ADDRESS
+//
+// The DNS checks can only give hints, whether the mail actually passed is
+// told by the Authentication-Results header of the received mail.
+// Sending to the address of a mail checking service works as well.
+func mailSelftest(args []string) (exitCode int) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: mentionee mail-selftest ADDRESS")
+		return ExitConfigError
+	}
+	to, err := mail.ParseAddress(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mail-selftest: invalid address: %s\n", err)
+		return ExitConfigError
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("erroneous configuration", "configError", err)
+		return ExitConfigError
+	}
+	if cfg.mail == nil {
+		fmt.Fprintln(os.Stderr, "mail-selftest: NOTIFY_BY_MAIL not configured")
+		return ExitConfigError
+	}
+	sent := time.Now()
+	mailer, err := cfg.mail.Mailer(
+		func([]webmention.Mention) string { return "mentionee mail self-test" },
+		func([]webmention.Mention) string {
+			return fmt.Sprintf("This is a test mail sent by mentionee at %s.\nIf it didn't land in spam, notifications should arrive as well.\n", sent.Format(time.RFC1123Z))
+		},
+	)
+	if err != nil {
+		slog.Error("erroneous mail configuration", "configError", err)
+		return ExitConfigError
+	}
+	mailer, err = withRecipient(mailer, to.Address)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mail-selftest: %s\n", err)
+		return ExitFailure
+	}
+
+	exitCode = ExitSuccess
+	if err := mailer.Send(nil); err != nil {
+		fmt.Printf("FAIL  sending to %s: %s\n", to.Address, err)
+		exitCode = ExitFailure
+	} else {
+		fmt.Printf("ok    sent test mail to %s, check its Authentication-Results header\n", to.Address)
+	}
+	for _, hint := range mailHints(mailer) {
+		fmt.Println(hint)
+	}
+	return exitCode
+}
+
+// withRecipient returns a copy of sender delivering to address instead.
+func withRecipient(sender listener.Sender, address string) (listener.Sender, error) {
+	switch m := sender.(type) {
+	case listener.ExternalMailer:
+		m.Recipients = listener.Recipients{To: []string{address}}
+		return m, nil
+	case listener.InternalMailer:
+		m.Recipients, m.ToAddr = listener.Recipients{To: []string{address}}, mxAddr(address)
+		return m, nil
+	case listener.InternalDKIMMailer:
+		m.Recipients, m.ToAddr = listener.Recipients{To: []string{address}}, mxAddr(address)
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unsupported mailer: %T", sender)
+	}
+}
+
+// mxAddr returns the address of the preferred mail server of address' domain.
+func mxAddr(address string) string {
+	domain := address[strings.LastIndex(address, "@")+1:]
+	mxs, err := net.LookupMX(domain)
+	if err != nil || len(mxs) == 0 {
+		return net.JoinHostPort(domain, "25") // fall back to the A record, like mail servers do
+	}
+	return net.JoinHostPort(strings.TrimSuffix(mxs[0].Host, "."), "25")
+}
+
+// mailHints checks the DNS records of the sending domain.
+func mailHints(sender listener.Sender) (hints []string) {
+	var (
+		from           string
+		dkimHost, pkey string
+		external       bool
+	)
+	switch m := sender.(type) {
+	case listener.ExternalMailer:
+		from, external = m.From, true
+	case listener.InternalMailer:
+		from = m.From
+	case listener.InternalDKIMMailer:
+		from = m.From
+		dkimHost = m.DkimSignOpts.Selector + "._domainkey." + m.DkimSignOpts.Domain
+		if der, err := x509.MarshalPKIXPublicKey(m.DkimSignOpts.Signer.Public()); err == nil {
+			pkey = base64.StdEncoding.EncodeToString(der)
+		}
+	}
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return []string{fmt.Sprintf("FAIL  sender address %q: %s", from, err)}
+	}
+	domain := fromAddr.Address[strings.LastIndex(fromAddr.Address, "@")+1:]
+
+	if spf := lookupTXT(domain, "v=spf1"); spf == "" {
+		hints = append(hints, fmt.Sprintf("FAIL  no SPF record on %s", domain))
+	} else if external {
+		hints = append(hints, fmt.Sprintf("ok    SPF: %s (make sure it includes your mail provider)", spf))
+	} else {
+		hints = append(hints, fmt.Sprintf("ok    SPF: %s (make sure it includes this server, e.g., with mx or ip4:)", spf))
+	}
+
+	if dmarc := lookupTXT("_dmarc."+domain, "v=DMARC1"); dmarc == "" {
+		hints = append(hints, fmt.Sprintf("WARN  no DMARC record on _dmarc.%s", domain))
+	} else {
+		hints = append(hints, fmt.Sprintf("ok    DMARC: %s", dmarc))
+	}
+
+	switch {
+	case external:
+		hints = append(hints, "info  DKIM signing is up to your mail provider")
+	case dkimHost == "":
+		hints = append(hints, "WARN  mails are not DKIM signed (set MAIL_DKIM_PRIV), DMARC relies on SPF alone")
+	default:
+		record := lookupTXT(dkimHost, "v=DKIM1")
+		switch {
+		case record == "":
+			hints = append(hints, fmt.Sprintf("FAIL  no DKIM record on %s", dkimHost))
+		case pkey != "" && !strings.Contains(strings.ReplaceAll(record, " ", ""), "p="+pkey):
+			hints = append(hints, fmt.Sprintf("FAIL  DKIM record on %s does not match MAIL_DKIM_PRIV", dkimHost))
+		default:
+			hints = append(hints, fmt.Sprintf("ok    DKIM record on %s matches", dkimHost))
+		}
+		signing := dkimHost[strings.Index(dkimHost, "._domainkey.")+len("._domainkey."):]
+		if !aligned(signing, domain) {
+			hints = append(hints, fmt.Sprintf("FAIL  DKIM domain %s is not aligned with the sender domain %s (MAIL_DKIM_HOST)", signing, domain))
+		}
+	}
+	return hints
+}
+
+// lookupTXT returns the first TXT record of name starting with prefix.
+func lookupTXT(name, prefix string) string {
+	records, err := net.LookupTXT(name)
+	if err != nil {
+		return ""
+	}
+	for _, record := range records {
+		if strings.HasPrefix(record, prefix) {
+			return record
+		}
+	}
+	return ""
+}
+
+// aligned reports whether the domains are aligned in DMARC's relaxed mode,
+// i.e., share the same organizational domain.
+func aligned(a, b string) bool {
+	orgA, errA := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(a))
+	orgB, errB := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(b))
+	return errA == nil && errB == nil && orgA == orgB
+}
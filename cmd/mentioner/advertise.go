@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// checkAdvertise reports how a page advertises its webmention endpoint,
+// and which mistakes keep senders from finding it.
+// Exits with 1 if any problems were found.
+//
+//	mentioner check-advertise URL
+func checkAdvertise(args []string) (exitCode int) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "check-advertise: expected exactly one url")
+		return 2
+	}
+	page, err := url.Parse(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check-advertise: invalid url: %s\n", err)
+		return 2
+	}
+	diagnosis, err := sender.DiagnoseEndpoint(page)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check-advertise: %s\n", err)
+		return 1
+	}
+	fmt.Printf("page:      %s\n", diagnosis.URL)
+	for _, adv := range diagnosis.Advertisements {
+		resolved := "(invalid)"
+		if adv.Endpoint != nil {
+			resolved = adv.Endpoint.String()
+		}
+		fmt.Printf("  %-12s %q -> %s\n", adv.Where, adv.Href, resolved)
+	}
+	if diagnosis.Endpoint != nil {
+		fmt.Printf("endpoint:  %s\n", diagnosis.Endpoint)
+	}
+	if len(diagnosis.Problems) == 0 {
+		fmt.Println("no problems found")
+		return 0
+	}
+	fmt.Println("problems:")
+	for _, problem := range diagnosis.Problems {
+		fmt.Printf("  - %s\n", problem)
+	}
+	return 1
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// loadtestResult is the outcome of a single submission.
+type loadtestResult struct {
+	latency time.Duration
+	code    int // zero if the request failed
+}
+
+// loadtest submits mentions to an endpoint at a fixed rate, and reports how
+// fast, and how often successfully, it answered.
+// The sources are served by the load test itself, each linking to target,
+// so that the receiver can verify them.
+// Only run it against your own endpoint.
+//
+//	mentioner loadtest -endpoint URL -target URL [-rate N] [-duration D] [-listen ADDR] [-public URL]
+func loadtest(args []string) (exitCode int) {
+	var (
+		endpoint, target, listen, public string
+		rate                             int
+		duration, wait                   time.Duration
+	)
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	flags.StringVar(&endpoint, "endpoint", "", "webmention endpoint to test (required)")
+	flags.StringVar(&target, "target", "", "page the endpoint accepts mentions for (required)")
+	flags.IntVar(&rate, "rate", 10, "mentions per second")
+	flags.DurationVar(&duration, "duration", 10*time.Second, "how long to send mentions")
+	flags.DurationVar(&wait, "wait", 10*time.Second, "how long to wait for the receiver to fetch the sources afterwards")
+	flags.StringVar(&listen, "listen", "127.0.0.1:0", "address to serve the sources on")
+	flags.StringVar(&public, "public", "", "url under which the endpoint reaches the sources (default: http://LISTEN)")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if endpoint == "" || target == "" || rate <= 0 {
+		fmt.Fprintln(os.Stderr, "loadtest: -endpoint and -target are required, -rate must be positive")
+		return 2
+	}
+	if _, err := url.Parse(target); err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: invalid target: %s\n", err)
+		return 2
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %s\n", err)
+		return 1
+	}
+	defer l.Close()
+	if public == "" {
+		public = "http://" + l.Addr().String()
+	}
+	public = strings.TrimSuffix(public, "/")
+
+	var fetched atomic.Int64
+	sources := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fetched.Add(1)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<!DOCTYPE html><html><body><p>Load test source %s, mentioning <a href="%s">the target</a>.</p></body></html>`, r.URL.Path, target)
+	})}
+	go sources.Serve(l)
+	defer sources.Shutdown(context.Background())
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var (
+		m       sync.Mutex
+		results []loadtestResult
+		wg      sync.WaitGroup
+	)
+	run := strconv.FormatInt(time.Now().Unix(), 36) // sources are unique per run, so that none are rate limited as repeats
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+	deadline := time.After(duration)
+	fmt.Printf("sending %d mentions per second to %s for %s\n", rate, endpoint, duration)
+send:
+	for n := 0; ; n++ {
+		select {
+		case <-deadline:
+			break send
+		case <-ticker.C:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			source := fmt.Sprintf("%s/loadtest/%s/%d", public, run, n)
+			start := time.Now()
+			resp, err := client.PostForm(endpoint, url.Values{"source": {source}, "target": {target}})
+			result := loadtestResult{latency: time.Since(start)}
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				result.code = resp.StatusCode
+			}
+			m.Lock()
+			results = append(results, result)
+			m.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, result := range results {
+		if result.code >= 200 && result.code < 300 {
+			accepted++
+		}
+	}
+	// give the receiver some time to verify the accepted mentions
+	for deadline := time.Now().Add(wait); fetched.Load() < int64(accepted) && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err := reportLoadtest(os.Stdout, results, fetched.Load()); err != nil {
+		fmt.Fprintf(os.Stderr, "loadtest: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// reportLoadtest prints the latency percentiles and response codes of results.
+func reportLoadtest(w io.Writer, results []loadtestResult, fetched int64) error {
+	if len(results) == 0 {
+		return errors.New("no mentions sent")
+	}
+	latencies := make([]time.Duration, len(results))
+	codes := map[int]int{}
+	for i, result := range results {
+		latencies[i] = result.latency
+		codes[result.code]++
+	}
+	slices.Sort(latencies)
+	percentile := func(p int) time.Duration {
+		return latencies[(len(latencies)-1)*p/100]
+	}
+	fmt.Fprintf(w, "sent:     %d\n", len(results))
+	fmt.Fprintf(w, "latency:  p50 %s, p90 %s, p99 %s, max %s\n", percentile(50), percentile(90), percentile(99), latencies[len(latencies)-1])
+	for _, code := range slices.Sorted(maps.Keys(codes)) {
+		name := "failed"
+		if code != 0 {
+			name = strconv.Itoa(code) + " " + http.StatusText(code)
+		}
+		fmt.Fprintf(w, "  %-28s %d (%.1f%%)\n", name, codes[code], 100*float64(codes[code])/float64(len(results)))
+	}
+	fmt.Fprintf(w, "fetched:  %d sources were fetched by the receiver\n", fetched)
+	return nil
+}
@@ -6,28 +6,266 @@
 // A source, eg., a blogging engine can then contact this daemon through its socket.
 // This way, every time a new blog post is compiled with the blogging software,
 // the blogger can notify the daemon about any links mentioned in the post.
+//
+// If the REDIS_ADDR environment variable is set (host:port), discovered
+// Webmention endpoints are cached in Redis for a day, and shared with other
+// instances (authenticated with REDIS_PASSWORD, if set).
+// REDIS_PASSWORD can be read from a file (REDIS_PASSWORD_FILE), a systemd
+// credential, or an age-encrypted SECRETS_FILE instead, like the secrets of
+// mentionee (see webmention.DefaultSecretSources).
+// If Redis is unavailable, endpoints are discovered without the cache.
+// The targets of each source are remembered in Redis as well, so that
+// removed links are still informed, even if they are missing from
+// past_targets.
+// Every mention posted is recorded in Redis as well, with RESEND_WINDOW
+// (e.g., 24h) mentions that were already delivered within the window are
+// not sent again, e.g., when a site is rebuilt repeatedly.
+// So is the endpoint discovered for every target, with REDISCOVER_AFTER
+// (e.g., 168h) it is reused until it is older than that.
+// The endpoint command tells which endpoint a target is mentioned at, and
+// when it was discovered:
+//
+//	mentioner endpoint https://example.com/posts/hello
+//
+// If PREFLIGHT=yes is set, the source is checked to actually link to its
+// current targets, before they are mentioned.
+//
+// HTTP_CACHE=memory, or a directory, caches the pages fetched to discover
+// endpoints and to check sources, as far as their Cache-Control, ETag, and
+// Last-Modified headers allow (see webmention.HTTPCache).
+//
+// Outgoing connections can be bound to a local address with FETCH_LOCAL_ADDR,
+// and routed through a proxy with FETCH_PROXY (e.g., socks5://localhost:9050),
+// like those of mentionee.
+//
+// ENDPOINT_CREDENTIALS names a file with credentials for private or staging
+// endpoints (see webmention.EndpointCredential).
+//
+// DISCOVERY_TIMEOUT and DELIVERY_TIMEOUT (e.g., 10s) limit how long
+// discovering an endpoint, and posting the mention to it, may take.
+//
+// With NO_DOWNGRADE=yes, https targets advertising an http endpoint are not
+// mentioned.
+// With SAME_SITE_ENDPOINTS=yes, mentions are only delivered to endpoints on
+// the same registrable domain as their target, or on one of the domains in
+// the comma separated ALLOWED_ENDPOINTS (e.g., webmention.io).
+//
+// SEND_WINDOWS (e.g., 22:00-06:00,12:00-13:00, in local time) defers
+// posting mentions to these windows, the mentions are kept in the file
+// OUTBOX in the meantime (required), and posted by the daemon once a window
+// opens. Targets of deferred mentions are reported as deferred, not sent.
+//
+// Mentions answered with a server error (500 to 504) are retried
+// SERVER_ERROR_RETRIES times (default 2), after RETRY_BACKOFF (e.g., 1s,
+// doubled for every further retry).
+//
+// MAX_IN_FLIGHT_PER_DOMAIN limits how many mentions are posted to the
+// endpoints of a domain at once, MAX_DAILY_RETRIES_PER_DOMAIN how many
+// temporarily failed mentions to a domain are put in the OUTBOX (required)
+// per day, to be retried by the daemon (see webmention.DeliveryBudget), with
+// exponential backoff, at most MAX_RETRY_ATTEMPTS times (default 8).
+//
+// USAGE_FILE accounts the mentions sent per source domain and month in this
+// file (see webmention.UsageMeter), with MONTHLY_QUOTA_SENT, mentions beyond
+// the quota fail. A mentionee may share the file.
+//
+// Links of a source to its own host are not mentioned, with
+// INTERNAL_LINKS=skip-site links to other subdomains of its site are skipped
+// as well, with INTERNAL_LINKS=mention all of them are mentioned.
+// Targets given on the command line are always mentioned.
+//
+// With WELL_KNOWN=yes, the policy a site publishes at /.well-known/webmention
+// is respected (an interop experiment): its endpoint is used for pages that
+// don't declare one, and its rate limit is followed.
+//
+// With "dry_run":true in a request, endpoints are discovered and the
+// source is checked to link to its current targets, but nothing is sent or
+// remembered: the response tells what would be sent to which endpoint, to
+// preview the effect of publishing a post.
+// The preview command does the same from the command line:
+//
+//	mentioner preview https://example.com/posts/hello https://example.org/
+//
+// Requests with "progress":true stream the status of every mention as a
+// line of its own ({"progress":{...},"done":1,"total":3}), as soon as it is
+// done, followed by the response, which then only tells whether the request
+// failed. Use it for requests with many targets, the statuses are not
+// collected in memory.
+//
+// A request may name at most MAX_BATCH_TARGETS (default 10000) past and
+// current targets altogether, zero means no limit, larger requests fail and
+// have to be split. The sources of a request are updated
+// MAX_CONCURRENT_SOURCES (default 4) at a time, the mentions of the same
+// source one after another, in order.
+//
+// The loadtest command submits mentions to your own endpoint at a fixed
+// rate, serving the sources itself, and reports the latency percentiles and
+// response codes, e.g., to size the queue and number of workers of mentionee:
+//
+//	mentioner loadtest -endpoint https://example.com/api/webmention -target https://example.com/ -rate 50 -public http://203.0.113.7:8081 -listen :8081
+//
+// The check-advertise command reports how one of your pages advertises its
+// endpoint (Link header, <link> or <a>), which endpoint senders will use,
+// and common mistakes, such as an endpoint inside an html comment, or a
+// relative endpoint that senders resolve differently:
+//
+//	mentioner check-advertise https://example.com/
+//
+// The rocks command runs the update and delete tests of webmention.rocks,
+// which need a source that changes between the steps of a test: it serves
+// the source itself, on -listen, webmention.rocks must reach it at -public
+// (e.g., through a tunnel):
+//
+//	mentioner rocks -listen :8081 -public https://tunnel.example.com update delete
 package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/redis"
 )
 
-var sender webmention.WebMentionSender
+var sender *webmention.Sender
 
 func init() {
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
-	sender = webmention.NewSender()
+	var options []webmention.SenderOption
+	secrets, err := webmention.LoadSecrets(webmention.DefaultSecretSources(), "REDIS_PASSWORD")
+	if err != nil {
+		panic(err)
+	}
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		client := redis.NewClient(addr)
+		client.Password = secrets["REDIS_PASSWORD"]
+		store := redis.NewStore(client)
+		options = append(options,
+			webmention.WithDiscoveryCache(store, 24*time.Hour),
+			webmention.WithPersister(&webmention.KeyValuePersister{Store: store}),
+		)
+	}
+	if age := os.Getenv("REDISCOVER_AFTER"); age != "" {
+		options = append(options, webmention.WithRediscoveryAfter(must(time.ParseDuration(age))))
+	}
+	if window := os.Getenv("RESEND_WINDOW"); window != "" {
+		options = append(options, webmention.WithResendWindow(must(time.ParseDuration(window))))
+	}
+	if addr := os.Getenv("FETCH_LOCAL_ADDR"); addr != "" {
+		options = append(options, webmention.WithLocalAddr(must(netip.ParseAddr(addr))))
+	}
+	if proxyURL := os.Getenv("FETCH_PROXY"); proxyURL != "" {
+		options = append(options, webmention.WithProxy(must(url.Parse(proxyURL))))
+	}
+	switch dir := os.Getenv("HTTP_CACHE"); dir {
+	case "":
+	case "memory":
+		options = append(options, webmention.WithHTTPCache(webmention.NewHTTPCache(webmention.NewMemoryKeyValueStore())))
+	default:
+		options = append(options, webmention.WithHTTPCache(webmention.NewHTTPCache(must(webmention.NewFileKeyValueStore(dir)))))
+	}
+	if path := os.Getenv("ENDPOINT_CREDENTIALS"); path != "" {
+		f := must(os.Open(path))
+		credentials := must(webmention.ParseEndpointCredentials(f))
+		f.Close()
+		options = append(options, webmention.WithEndpointCredentials(credentials...))
+	}
+	if timeout := os.Getenv("DISCOVERY_TIMEOUT"); timeout != "" {
+		options = append(options, webmention.WithDiscoveryTimeout(must(time.ParseDuration(timeout))))
+	}
+	if timeout := os.Getenv("DELIVERY_TIMEOUT"); timeout != "" {
+		options = append(options, webmention.WithDeliveryTimeout(must(time.ParseDuration(timeout))))
+	}
+	if os.Getenv("NO_DOWNGRADE") == "yes" {
+		options = append(options, webmention.WithDowngradeProtection())
+	}
+	if os.Getenv("SAME_SITE_ENDPOINTS") == "yes" {
+		var allowed []string
+		if domains := os.Getenv("ALLOWED_ENDPOINTS"); domains != "" {
+			allowed = strings.Split(domains, ",")
+		}
+		options = append(options, webmention.WithSameSiteEndpoints(allowed...))
+	}
+	switch links := os.Getenv("INTERNAL_LINKS"); links {
+	case "", "skip-host":
+	case "skip-site":
+		options = append(options, webmention.WithInternalLinks(webmention.SkipSameSite))
+	case "mention":
+		options = append(options, webmention.WithInternalLinks(webmention.MentionInternal))
+	default:
+		panic(fmt.Sprintf("INTERNAL_LINKS: unknown policy: %s", links))
+	}
+	if os.Getenv("WELL_KNOWN") == "yes" {
+		options = append(options, webmention.WithWellKnownPolicy())
+	}
+	if n, backoff := os.Getenv("SERVER_ERROR_RETRIES"), os.Getenv("RETRY_BACKOFF"); n != "" || backoff != "" {
+		retries, delay := 2, time.Second
+		if n != "" {
+			retries = must(strconv.Atoi(n))
+		}
+		if backoff != "" {
+			delay = must(time.ParseDuration(backoff))
+		}
+		options = append(options, webmention.WithServerErrorRetries(retries, delay))
+	}
+	var budget webmention.DeliveryBudget
+	if n := os.Getenv("MAX_IN_FLIGHT_PER_DOMAIN"); n != "" {
+		budget.MaxInFlight = must(strconv.Atoi(n))
+	}
+	if n := os.Getenv("MAX_DAILY_RETRIES_PER_DOMAIN"); n != "" {
+		budget.MaxDailyRetries = must(strconv.Atoi(n))
+	}
+	if n := os.Getenv("MAX_RETRY_ATTEMPTS"); n != "" {
+		budget.MaxAttempts = must(strconv.Atoi(n))
+	}
+	if budget != (webmention.DeliveryBudget{}) {
+		options = append(options, webmention.WithDeliveryBudget(budget))
+	}
+	windows := os.Getenv("SEND_WINDOWS")
+	if windows != "" || budget.MaxDailyRetries > 0 {
+		outbox := os.Getenv("OUTBOX")
+		if outbox == "" {
+			panic("SEND_WINDOWS and MAX_DAILY_RETRIES_PER_DOMAIN require OUTBOX to be set")
+		}
+		var sendWindows []webmention.SendWindow
+		if windows != "" {
+			sendWindows = must(webmention.ParseSendWindows(windows))
+		}
+		options = append(options, webmention.WithSendWindows(webmention.NewJSONFileOutbox(outbox), sendWindows...))
+	}
+	if path := os.Getenv("USAGE_FILE"); path != "" {
+		meter := &webmention.UsageMeter{Store: webmention.NewJSONFileUsageStore(path)}
+		if n := os.Getenv("MONTHLY_QUOTA_SENT"); n != "" {
+			meter.Quota.Sent = must(strconv.Atoi(n))
+		}
+		options = append(options, webmention.WithSendUsageMeter(meter))
+	}
+	if os.Getenv("PREFLIGHT") == "yes" {
+		options = append(options, webmention.WithPreflight())
+	}
+	if n := os.Getenv("MAX_BATCH_TARGETS"); n != "" {
+		maxBatchTargets = must(strconv.Atoi(n))
+	}
+	if n := os.Getenv("MAX_CONCURRENT_SOURCES"); n != "" {
+		maxConcurrentSources = must(strconv.Atoi(n))
+	}
+	sender = webmention.NewSender(options...)
 }
 
 func must[T any](t T, err error) T {
@@ -55,6 +293,36 @@ func main() {
 		os.Exit(2)
 	}
 
+	if os.Args[1] == "--version" || os.Args[1] == "-version" {
+		fmt.Println("mentioner", webmention.Build())
+		return
+	}
+
+	if os.Args[1] == "loadtest" {
+		os.Exit(loadtest(os.Args[2:]))
+	}
+
+	if os.Args[1] == "check-advertise" {
+		os.Exit(checkAdvertise(os.Args[2:]))
+	}
+
+	if os.Args[1] == "rocks" {
+		os.Exit(rocks(os.Args[2:]))
+	}
+
+	if os.Args[1] == "endpoint" {
+		os.Exit(showEndpoint(os.Args[2:]))
+	}
+
+	preview := os.Args[1] == "preview"
+	if preview {
+		os.Args = slices.Delete(os.Args, 1, 2)
+		if len(os.Args) < 2 {
+			fmt.Println(usage())
+			os.Exit(2)
+		}
+	}
+
 	if os.Args[1] == "demonize" {
 		demon()
 	} else {
@@ -74,6 +342,22 @@ func main() {
 			}
 			targetURLs[i] = url
 		}
+		if preview {
+			results, err := sender.Preview(sourceURL, nil, targetURLs)
+			for _, result := range results {
+				switch {
+				case result.Err != nil:
+					fmt.Printf("%s: %v\n", result.Target, result.Err)
+				case result.Sent:
+					fmt.Printf("%s: would send to %s\n", result.Target, result.Endpoint)
+				}
+			}
+			fmt.Println(results)
+			if err != nil {
+				os.Exit(1)
+			}
+			return
+		}
 		if err := sender.MentionMany(sourceURL, targetURLs); err != nil {
 			fmt.Printf("%v\n", err)
 			os.Exit(1)
@@ -84,7 +368,68 @@ func main() {
 func usage() string {
 	app := os.Args[0]
 	return fmt.Sprintf(`%[1]s demonize                   -- Run as demon
-%[1]s source target [targets...] -- Send webmentions from source to target`, app)
+%[1]s source target [targets...] -- Send webmentions from source to target
+%[1]s preview source target [targets...]
+                                 -- Report what would be sent where, without sending it
+%[1]s loadtest -endpoint URL -target URL [-rate N] [-duration D]
+                                 -- Send mentions to your own endpoint, and report its latency
+%[1]s check-advertise URL        -- Report how a page advertises its endpoint, and any mistakes
+%[1]s rocks -public URL [update|delete...]
+                                 -- Run the update and delete tests of webmention.rocks
+%[1]s endpoint URL               -- Report the endpoint of a target, and when it was discovered
+%[1]s --version                  -- Print the version`, app)
+}
+
+// showEndpoint reports the endpoint recorded for each target, or discovers
+// it, if none is recorded.
+func showEndpoint(targets []string) (code int) {
+	if len(targets) == 0 {
+		fmt.Println(usage())
+		return 2
+	}
+	for _, target := range targets {
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return 1
+		}
+		record, ok, err := sender.KnownEndpoint(targetURL)
+		if err != nil {
+			fmt.Printf("%s: %v\n", target, err)
+			code = 1
+			continue
+		}
+		if !ok {
+			endpoint, _, err := sender.Discover(targetURL)
+			if err != nil {
+				fmt.Printf("%s: %v\n", target, err)
+				code = 1
+				continue
+			}
+			record = webmention.EndpointRecord{Endpoint: endpoint.String(), Discovered: time.Now()}
+		}
+		fmt.Printf("endpoint for %s: %s (discovered %s)\n", target, record.Endpoint, ago(time.Since(record.Discovered)))
+	}
+	return code
+}
+
+// ago describes how long ago something happened, e.g., "3 days ago".
+func ago(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	}
+	return plural(int(d/(24*time.Hour)), "day")
 }
 
 func demon() {
@@ -100,6 +445,10 @@ func demon() {
 		listener = l
 	}
 
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	defer stopOutbox()
+	go sender.ProcessOutbox(outboxCtx)
+
 	go func() {
 		for {
 			conn, err := listener.Accept()
@@ -111,7 +460,7 @@ func demon() {
 				}
 				return
 			}
-			go handle(conn) // @todo: max number of open connections?
+			go serveConn(conn) // @todo: max number of open connections?
 		}
 	}()
 
@@ -131,6 +480,12 @@ type (
 	}
 	MentionsMessage struct {
 		Mentions []Mention `json:"mentions"`
+		// DryRun previews the mentions, without sending them.
+		DryRun bool `json:"dry_run,omitempty"`
+		// Progress streams the status of every mention as soon as it is
+		// done, as a ProgressResponse, instead of collecting them in the
+		// MentionsResponse.
+		Progress bool `json:"progress,omitempty"`
 	}
 	Mention struct {
 		Source         URL   `json:"source"`
@@ -141,9 +496,31 @@ type (
 		Statuses []Status `json:"statuses"`
 		Error    string   `json:"error"`
 	}
+	// ProgressResponse is the status of a single mention, Done of Total
+	// mentions of the message are done.
+	ProgressResponse struct {
+		Progress Status `json:"progress"`
+		Done     int    `json:"done"`
+		Total    int    `json:"total"`
+	}
 	Status struct {
 		Source URL    `json:"source"`
 		Error  string `json:"error"`
+		// Summary is a human readable summary, e.g., "3 new mentions sent, 1 removal notified".
+		Summary string         `json:"summary"`
+		Targets []TargetStatus `json:"targets"`
+	}
+	TargetStatus struct {
+		Target URL `json:"target"`
+		// Change is one of added, removed, or kept.
+		Change webmention.Change `json:"change"`
+		// Endpoint is the endpoint the mention is (or would be) sent to.
+		Endpoint *URL `json:"endpoint,omitempty"`
+		// Sent reports whether the mention was sent, or would be, for a dry run.
+		Sent bool `json:"sent"`
+		// Deferred is set if the mention waits for the next send window.
+		Deferred bool   `json:"deferred,omitempty"`
+		Error    string `json:"error,omitempty"`
 	}
 )
 
@@ -159,7 +536,22 @@ func (u *URL) UnmarshalText(bs []byte) error {
 
 type MessageError error
 
-func handle(conn net.Conn) {
+const (
+	// maxMessageSize is the longest message (line) accepted on the socket.
+	maxMessageSize = 16 << 20
+	// defaultMaxBatchTargets and defaultMaxConcurrentSources are used unless
+	// configured with MAX_BATCH_TARGETS and MAX_CONCURRENT_SOURCES.
+	defaultMaxBatchTargets      = 10000
+	defaultMaxConcurrentSources = 4
+)
+
+var (
+	maxBatchTargets      = defaultMaxBatchTargets
+	maxConcurrentSources = defaultMaxConcurrentSources
+)
+
+// serveConn handles the messages of conn, and closes it.
+func serveConn(conn net.Conn) {
 	//conn.SetDeadline(time.Now().Add(20*time.Second)) // @todo: idle timeout?
 	defer func() {
 		err := conn.Close()
@@ -167,10 +559,16 @@ func handle(conn net.Conn) {
 			slog.Error("closing connection", "connection_error", err.Error(), "remote", conn.RemoteAddr())
 		}
 	}()
+	handle(conn)
+}
 
+// handle answers every message (line) read from conn, every frame written
+// to it is a line, see writeLine.
+func handle(conn io.ReadWriter) {
 	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, maxMessageSize)
 	for scanner.Scan() {
-		statuses, err := handleRequest(scanner.Bytes())
+		statuses, err := handleRequest(scanner.Bytes(), conn)
 
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
@@ -183,18 +581,22 @@ func handle(conn net.Conn) {
 			}
 		}
 
-		resp, err := json.Marshal(statuses)
-		if err != nil {
-			slog.Error("cannot marshal statuses response", "marshal_error", err.Error(), "statuses", statuses)
-			return // close connection, stop handler
-		}
-		if _, err := conn.Write(resp); err != nil {
+		if err := writeLine(conn, statuses); err != nil {
 			return // connection was probably closed, stop handler
 		}
 	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		// the rest of the message can't be told apart from the next one
+		writeLine(conn, MentionsResponse{Error: fmt.Sprintf("message too large: at most %d bytes per message", maxMessageSize)})
+	}
 }
 
-func handleRequest(message []byte) (resp MentionsResponse, err error) {
+// handleRequest updates the mentions of message, the mentions of up to
+// maxConcurrentSources sources at once.
+// With "progress":true, the status of every mention is written to w as
+// soon as it is done, and the response only tells whether the message
+// failed, instead of collecting the statuses of all mentions.
+func handleRequest(message []byte, w io.Writer) (resp MentionsResponse, err error) {
 	if len(message) == 0 {
 		return resp, MessageError(fmt.Errorf("boredom: you didn't give me anything to do"))
 	}
@@ -205,29 +607,119 @@ func handleRequest(message []byte) (resp MentionsResponse, err error) {
 	if len(mentions.Mentions) == 0 {
 		return resp, MessageError(fmt.Errorf("boredom: you didn't give me anything to do"))
 	}
-
-	var statuses MentionsResponse
+	targets := 0
 	for _, mention := range mentions.Mentions {
+		targets += len(mention.PastTargets) + len(mention.CurrentTargets)
+	}
+	if maxBatchTargets > 0 && targets > maxBatchTargets {
+		return resp, MessageError(fmt.Errorf("batch too large: %d targets, at most %d per message, split it into several messages", targets, maxBatchTargets))
+	}
 
-		// Holy 💩, the Go type system sucks, and it sucks hard!!!
-		pastTargets := make([]*url.URL, len(mention.PastTargets))
-		for i, target := range mention.PastTargets {
-			pastTargets[i] = target.URL
-		}
-		currentTargets := make([]*url.URL, len(mention.CurrentTargets))
-		for i, target := range mention.CurrentTargets {
-			currentTargets[i] = target.URL
+	// mentions for the same source share a batch, so that the source is only
+	// fetched once, and are updated in order
+	var (
+		sources  []string
+		bySource = map[string][]int{}
+	)
+	for i, mention := range mentions.Mentions {
+		source := mention.Source.String()
+		if _, ok := bySource[source]; !ok {
+			sources = append(sources, source)
 		}
+		bySource[source] = append(bySource[source], i)
+	}
+
+	var (
+		m        sync.Mutex
+		done     int
+		writeErr error
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, max(maxConcurrentSources, 1))
+	)
+	if !mentions.Progress {
+		resp.Statuses = make([]Status, len(mentions.Mentions))
+	}
+	for _, source := range sources {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			batch := sender.NewBatch(mentions.Mentions[bySource[source][0]].Source.URL)
+			for _, i := range bySource[source] {
+				status := updateMention(batch, mentions.Mentions[i], mentions.DryRun)
+				m.Lock()
+				done++
+				if mentions.Progress {
+					if writeErr == nil {
+						writeErr = writeLine(w, ProgressResponse{Progress: status, Done: done, Total: len(mentions.Mentions)})
+					}
+				} else {
+					resp.Statuses[i] = status
+				}
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if mentions.Progress {
+		resp.Statuses = []Status{}
+	}
+	return resp, writeErr
+}
+
+// updateMention updates the past and current targets of mention.
+func updateMention(batch *webmention.Batch, mention Mention, dryRun bool) Status {
+	// Holy 💩, the Go type system sucks, and it sucks hard!!!
+	pastTargets := make([]*url.URL, len(mention.PastTargets))
+	for i, target := range mention.PastTargets {
+		pastTargets[i] = target.URL
+	}
+	currentTargets := make([]*url.URL, len(mention.CurrentTargets))
+	for i, target := range mention.CurrentTargets {
+		currentTargets[i] = target.URL
+	}
 
-		err := sender.Update(mention.Source.URL, pastTargets, currentTargets)
-		status := Status{
-			Source: mention.Source,
+	update := batch.UpdateResults
+	if dryRun {
+		update = batch.PreviewResults
+	}
+	results, err := update(pastTargets, currentTargets)
+	status := Status{
+		Source:  mention.Source,
+		Summary: results.String(),
+		Targets: []TargetStatus{},
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	for _, result := range results {
+		target := TargetStatus{
+			Target:   URL{result.Target},
+			Change:   result.Change,
+			Sent:     result.Sent,
+			Deferred: result.Deferred,
 		}
-		if err != nil {
-			status.Error = err.Error()
+		if result.Endpoint != nil {
+			target.Endpoint = &URL{result.Endpoint}
+		}
+		if result.Err != nil {
+			target.Error = result.Err.Error()
 		}
-		statuses.Statuses = append(statuses.Statuses, status)
+		status.Targets = append(status.Targets, target)
 	}
+	return status
+}
 
-	return statuses, nil
+// writeLine writes v as a line of JSON, every frame sent to a client goes
+// through it, so that clients can read responses line by line.
+func writeLine(w io.Writer, v any) error {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(bs, '\n'))
+	return err
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleFrames(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<a href="http://`+r.Host+`/target">target</a>`)
+	})
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mention := `{"dry_run": true, "mentions": [{"source": "` + ts.URL + `/source", "current_targets": ["` + ts.URL + `/target"]}]`
+
+	for _, test := range []struct {
+		name  string
+		input string
+		// errors expected in the frames, one per frame
+		errors []string
+	}{
+		{"empty message", "\n", []string{"boredom"}},
+		{"invalid message", "{\n", []string{"invalid message"}},
+		{"several messages", "{\n\n", []string{"invalid message", "boredom"}},
+		{"message too large", strings.Repeat("x", maxMessageSize+1), []string{"message too large"}},
+		{"last message without newline", mention + "}", []string{""}},
+		{"progress", mention + `, "progress": true}` + "\n", []string{"", ""}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			handle(struct {
+				io.Reader
+				io.Writer
+			}{strings.NewReader(test.input), &output})
+			if !strings.HasSuffix(output.String(), "\n") {
+				t.Errorf("last frame not terminated by a newline: %q", output.String())
+			}
+			frames := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+			if len(frames) != len(test.errors) {
+				t.Fatalf("expected %d frames, got %d: %q", len(test.errors), len(frames), frames)
+			}
+			for i, frame := range frames {
+				var resp struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal([]byte(frame), &resp); err != nil {
+					t.Fatalf("frame %d: %s: %q", i, err, frame)
+				}
+				if !strings.Contains(resp.Error, test.errors[i]) || (test.errors[i] == "" && resp.Error != "") {
+					t.Errorf("frame %d: expected error %q, got %q", i, test.errors[i], resp.Error)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// rocks runs the update and delete tests of webmention.rocks, which need a
+// source that changes between the steps.
+// The source is served by the command itself, on a temporary page.
+//
+//	mentioner rocks -public URL [-listen ADDR] [-wait D] [update|delete...]
+func rocks(args []string) (exitCode int) {
+	var (
+		listen, public string
+		wait           time.Duration
+	)
+	flags := flag.NewFlagSet("rocks", flag.ContinueOnError)
+	flags.StringVar(&listen, "listen", ":8081", "address to serve the source on")
+	flags.StringVar(&public, "public", "", "url under which webmention.rocks reaches the source (required)")
+	flags.DurationVar(&wait, "wait", 10*time.Second, "how long to give webmention.rocks to fetch the source after each step")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	suites := map[string]webmention.ConformanceSuite{
+		"update": webmention.RocksUpdateSuite,
+		"delete": webmention.RocksDeleteSuite,
+	}
+	names := flags.Args()
+	if len(names) == 0 {
+		names = []string{"update", "delete"}
+	}
+	for _, name := range names {
+		if _, ok := suites[name]; !ok {
+			fmt.Fprintf(os.Stderr, "rocks: unknown suite: %s (expected update or delete)\n", name)
+			return 2
+		}
+	}
+	if public == "" {
+		fmt.Fprintln(os.Stderr, "rocks: -public is required, webmention.rocks must be able to fetch the source")
+		return 2
+	}
+	public = strings.TrimSuffix(public, "/")
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rocks: %s\n", err)
+		return 1
+	}
+	defer l.Close()
+	pages := http.NewServeMux()
+	server := &http.Server{Handler: pages}
+	go server.Serve(l)
+	defer server.Shutdown(context.Background())
+
+	run := strconv.FormatInt(time.Now().Unix(), 36) // the source is new for every run, so that previous runs don't interfere
+	for _, name := range names {
+		page := &webmention.ConformanceSource{}
+		path := fmt.Sprintf("/rocks/%s/%s", run, name)
+		pages.Handle(path, page)
+		source, err := url.Parse(public + path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rocks: invalid public url: %s\n", err)
+			return 2
+		}
+		suite := suites[name]
+		fmt.Printf("%s: source %s\n", suite.Name, source)
+		results, err := sender.RunConformance(suite, source, page, wait)
+		for i, stepResults := range results {
+			for _, result := range stepResults {
+				if result.Err != nil {
+					fmt.Printf("  step %d: %s: %v\n", i+1, result.Target, result.Err)
+				}
+			}
+			fmt.Printf("  step %d: %s\n", i+1, stepResults)
+		}
+		fmt.Printf("  the source was fetched %d times\n", page.Fetches())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rocks: %s\n", err)
+			return 1
+		}
+	}
+	fmt.Println("check the results on the test pages of webmention.rocks")
+	return 0
+}
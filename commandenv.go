@@ -0,0 +1,24 @@
+package webmention
+
+import (
+	"os"
+	"slices"
+)
+
+// commandEnv are the environment variables external commands always
+// inherit, everything else has to be passed explicitly.
+var commandEnv = []string{"PATH", "HOME"}
+
+// CommandEnv returns a minimal environment for an external command, so
+// that the secrets and other configuration in the environment of the
+// receiver don't leak to it: PATH, HOME, and the variables named by pass,
+// as far as they are set, followed by env (NAME=VALUE).
+func CommandEnv(pass []string, env ...string) []string {
+	var cmdEnv []string
+	for _, name := range slices.Concat(commandEnv, pass) {
+		if value, ok := os.LookupEnv(name); ok {
+			cmdEnv = append(cmdEnv, name+"="+value)
+		}
+	}
+	return append(cmdEnv, env...)
+}
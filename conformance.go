@@ -0,0 +1,165 @@
+package webmention
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type (
+	// ConformanceSuite tests how a sender informs the targets of a source
+	// that changes: the source is changed step by step, and after every step
+	// its past and current targets are updated (see Sender.RunConformance).
+	ConformanceSuite struct {
+		Name  string
+		Steps []ConformanceStep
+	}
+
+	// ConformanceStep is a version of the source of a ConformanceSuite.
+	ConformanceStep struct {
+		// Comment describes the step, it is shown on the source.
+		Comment string
+		// Links are the (absolute) targets the source links to.
+		Links []string
+		// Gone deletes the source, it is served with 410 Gone, and links to nothing.
+		Gone bool
+	}
+
+	// ConformanceSource serves the source of a ConformanceSuite, in the
+	// version of the step being run, until the first step it answers with
+	// 404 Not Found.
+	// It must be served at a url that the receivers of the targets can reach.
+	// The zero value is ready to use.
+	ConformanceSource struct {
+		m       sync.Mutex
+		name    string
+		step    *ConformanceStep
+		updated time.Time
+		fetches int
+	}
+)
+
+// http.Handler implements *ConformanceSource
+var _ http.Handler = (*ConformanceSource)(nil)
+
+var (
+	// RocksUpdateSuite is the update test of webmention.rocks: the source
+	// links to the test page (part a) and to its second part (b), and is
+	// then updated to only link to the first, part b should show that it
+	// is no longer linked to.
+	RocksUpdateSuite = ConformanceSuite{
+		Name: "webmention.rocks update test 1",
+		Steps: []ConformanceStep{
+			{
+				Comment: "This post links to both parts of the update test.",
+				Links:   []string{"https://webmention.rocks/update/1", "https://webmention.rocks/update/1/part/2"},
+			},
+			{
+				Comment: "This post has been updated to no longer link to part 2.",
+				Links:   []string{"https://webmention.rocks/update/1"},
+			},
+		},
+	}
+
+	// RocksDeleteSuite is the delete test of webmention.rocks: the source
+	// links to the test page, and is then deleted, the test page should
+	// remove the mention.
+	RocksDeleteSuite = ConformanceSuite{
+		Name: "webmention.rocks delete test 1",
+		Steps: []ConformanceStep{
+			{
+				Comment: "This post links to the delete test, and will be deleted.",
+				Links:   []string{"https://webmention.rocks/delete/1"},
+			},
+			{Gone: true},
+		},
+	}
+)
+
+// RunConformance runs the steps of suite with the source at source, served
+// by page.
+// After every step, the past targets (those of the previous step) and the
+// current targets of the source are updated, then the receivers are given
+// wait to fetch the source before it changes again, since many of them
+// process mentions asynchronously.
+// The results of every step are returned, until the first step that failed.
+func (sender *Sender) RunConformance(suite ConformanceSuite, source URL, page *ConformanceSource, wait time.Duration) (results []DeliveryResults, err error) {
+	var past []URL
+	for i, step := range suite.Steps {
+		var current []URL
+		if !step.Gone {
+			for _, link := range step.Links {
+				target, err := url.Parse(link)
+				if err != nil {
+					return results, fmt.Errorf("%s: step %d: %w", suite.Name, i+1, err)
+				}
+				current = append(current, target)
+			}
+		}
+		page.set(suite.Name, step)
+		stepResults, err := sender.UpdateResults(source, past, current)
+		results = append(results, stepResults)
+		if err != nil {
+			return results, fmt.Errorf("%s: step %d: %w", suite.Name, i+1, err)
+		}
+		time.Sleep(wait)
+		past = current
+	}
+	return results, nil
+}
+
+func (page *ConformanceSource) set(name string, step ConformanceStep) {
+	page.m.Lock()
+	defer page.m.Unlock()
+	page.name, page.step, page.updated = name, &step, time.Now()
+}
+
+// Fetches returns how often the source was fetched.
+func (page *ConformanceSource) Fetches() int {
+	page.m.Lock()
+	defer page.m.Unlock()
+	return page.fetches
+}
+
+func (page *ConformanceSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page.m.Lock()
+	page.fetches++
+	name, step, updated := page.name, page.step, page.updated
+	page.m.Unlock()
+	switch {
+	case step == nil:
+		http.NotFound(w, r)
+	case step.Gone:
+		http.Error(w, "This post has been deleted.", http.StatusGone)
+	default:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		conformanceTemplate.Execute(w, struct {
+			Name    string
+			Step    *ConformanceStep
+			Updated time.Time
+		}{name, step, updated})
+	}
+}
+
+var conformanceTemplate = template.Must(template.New("conformance").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>{{.Name}}</title></head>
+<body>
+<article class="h-entry">
+<h1 class="p-name">{{.Name}}</h1>
+<div class="e-content">
+<p>{{.Step.Comment}}</p>
+<ul>
+{{- range .Step.Links}}
+<li><a href="{{.}}">{{.}}</a></li>
+{{- end}}
+</ul>
+</div>
+<p>Updated <time class="dt-updated" datetime="{{.Updated.Format "2006-01-02T15:04:05Z07:00"}}">{{.Updated.Format "2006-01-02 15:04:05"}}</time>.</p>
+</article>
+</body>
+</html>
+`))
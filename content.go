@@ -0,0 +1,158 @@
+package webmention
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxContentLength is how many characters of a source's content are kept
+// with its mention.
+const maxContentLength = 2000
+
+// contentBlocks are the elements whose text is taken as the content of a
+// source without an e-content property.
+var contentBlocks = map[string]bool{
+	"p": true, "li": true, "blockquote": true, "article": true, "section": true, "div": true, "td": true, "body": true,
+}
+
+// extractContent returns the text of the source's content, to be kept with
+// the mention (see Mention.Content): the first e-content of html sources,
+// or else the paragraph (list item, ...) linking to target, the whole
+// text of other sources. Whitespace is collapsed, and the text is cut off
+// after maxContentLength characters.
+func extractContent(mime string, sourceData []byte, target URL) string {
+	if mime != "text/html" {
+		return truncateText(string(sourceData))
+	}
+	doc, err := html.Parse(bytes.NewReader(sourceData))
+	if err != nil {
+		return ""
+	}
+	block := findContent(doc)
+	if block == nil {
+		link := findLinkTo(doc, strings.ToLower(target.String()))
+		for block = link; block != nil && !(block.Type == html.ElementNode && contentBlocks[block.Data]); block = block.Parent {
+		}
+	}
+	if block == nil {
+		return ""
+	}
+	var text strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style"):
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+		if n.Type == html.ElementNode && contentBlocks[n.Data] {
+			text.WriteString(" ")
+		}
+	}
+	collect(block)
+	return truncateText(text.String())
+}
+
+// findContent returns the first element with the e-content class.
+func findContent(node *html.Node) *html.Node {
+	if node.Type == html.ElementNode {
+		for _, a := range node.Attr {
+			if a.Key == "class" && hasClass(a.Val, "e-content") {
+				return node
+			}
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if content := findContent(child); content != nil {
+			return content
+		}
+	}
+	return nil
+}
+
+// findLinkTo returns the first <a> or <link> to target (lowercased).
+func findLinkTo(node *html.Node, target string) *html.Node {
+	if node.Type == html.ElementNode && (node.Data == "a" || node.Data == "link") && strings.ToLower(findHref(node)) == target {
+		return node
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if link := findLinkTo(child, target); link != nil {
+			return link
+		}
+	}
+	return nil
+}
+
+func truncateText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > maxContentLength {
+		return string(runes[:maxContentLength]) + "…"
+	}
+	return text
+}
+
+// ContentDiff describes how the content of the source changed since the
+// previous version of the mention, word by word: removed words are marked
+// [-like this-], added ones {+like this+}. It is empty if the content
+// didn't change, or the previous version is unknown.
+func (mention Mention) ContentDiff() string {
+	if mention.PreviousContent == "" || mention.PreviousContent == mention.Content {
+		return ""
+	}
+	return diffWords(strings.Fields(mention.PreviousContent), strings.Fields(mention.Content))
+}
+
+// diffWords formats the difference of a and b, based on their longest
+// common subsequence.
+func diffWords(a, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var (
+		words          []string
+		removed, added []string
+		flush          = func() {
+			if len(removed) > 0 {
+				words = append(words, "[-"+strings.Join(removed, " ")+"-]")
+			}
+			if len(added) > 0 {
+				words = append(words, "{+"+strings.Join(added, " ")+"+}")
+			}
+			removed, added = nil, nil
+		}
+	)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			words = append(words, a[i])
+			i, j = i+1, j+1
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, a[i])
+			i++
+		default:
+			added = append(added, b[j])
+			j++
+		}
+	}
+	flush()
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,126 @@
+package webmention
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// EndpointCredential authenticates the mentions posted to the endpoints of
+// a host, e.g., of a private or staging site.
+//
+// Credentials can be written down in a text file, one host per line:
+//
+//	# host                 credentials...
+//	staging.example.com    bearer=TOKEN
+//	*.internal.example     basic=user:password
+//	webmention.example     header=X-Api-Key:secret header=X-Tenant:blog
+//	localhost              bearer=TOKEN insecure
+//
+// Credentials are only sent to https endpoints, unless insecure is given.
+type EndpointCredential struct {
+	// Host of the endpoint, *.example.com matches all subdomains of example.com.
+	Host string
+	// Username and Password are sent as basic auth, if Username is set.
+	Username, Password string
+	// Token is sent as bearer token, if set.
+	Token string
+	// Header is added to the request.
+	Header http.Header
+	// Insecure also sends the credentials to http endpoints.
+	Insecure bool
+}
+
+// WithEndpointCredentials authenticates mentions posted to endpoints on the
+// hosts of the credentials.
+// The first credential matching the host of an endpoint is used.
+func WithEndpointCredentials(credentials ...EndpointCredential) SenderOption {
+	return func(s *Sender) {
+		s.credentials = append(s.credentials, credentials...)
+	}
+}
+
+// ParseEndpointCredentials parses credentials in the format described at EndpointCredential.
+func ParseEndpointCredentials(r io.Reader) ([]EndpointCredential, error) {
+	var credentials []EndpointCredential
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("endpoint credentials: line %d: expected: HOST CREDENTIAL...", line)
+		}
+		credential := EndpointCredential{Host: strings.ToLower(fields[0])}
+		for _, option := range fields[1:] {
+			if option == "insecure" {
+				credential.Insecure = true
+				continue
+			}
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "bearer":
+				credential.Token = value
+			case "basic":
+				user, password, ok := strings.Cut(value, ":")
+				if !ok {
+					return nil, fmt.Errorf("endpoint credentials: line %d: expected basic=USER:PASSWORD", line)
+				}
+				credential.Username, credential.Password = user, password
+			case "header":
+				name, headerValue, ok := strings.Cut(value, ":")
+				if !ok {
+					return nil, fmt.Errorf("endpoint credentials: line %d: expected header=NAME:VALUE", line)
+				}
+				if credential.Header == nil {
+					credential.Header = http.Header{}
+				}
+				credential.Header.Add(name, headerValue)
+			default:
+				return nil, fmt.Errorf("endpoint credentials: line %d: unknown credential: %s", line, key)
+			}
+		}
+		credentials = append(credentials, credential)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("endpoint credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// Matches reports whether the credential is meant for endpoint.
+func (credential EndpointCredential) Matches(endpoint URL) bool {
+	host := strings.ToLower(endpoint.Hostname())
+	if domain, ok := strings.CutPrefix(credential.Host, "*."); ok {
+		return strings.HasSuffix(host, "."+domain)
+	}
+	return host == credential.Host
+}
+
+// authenticate adds the credentials for the endpoint of req, if any.
+func (sender *Sender) authenticate(req *http.Request) {
+	for _, credential := range sender.credentials {
+		if !credential.Matches(req.URL) {
+			continue
+		}
+		if req.URL.Scheme != "https" && !credential.Insecure {
+			slog.Warn("not sending credentials over an insecure connection", "endpoint", req.URL.String())
+			return
+		}
+		for name, values := range credential.Header {
+			req.Header[name] = values
+		}
+		if credential.Username != "" {
+			req.SetBasicAuth(credential.Username, credential.Password)
+		}
+		if credential.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+credential.Token)
+		}
+		return
+	}
+}
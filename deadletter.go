@@ -0,0 +1,297 @@
+package webmention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// A DeadLetter is a mention that could not be processed, not even after retrying.
+	DeadLetter struct {
+		Mention Mention   `json:"mention"`
+		Reason  string    `json:"reason"`
+		Failed  time.Time `json:"failed"`
+	}
+
+	// A DeadLetterStore keeps mentions that failed to be processed, so that
+	// they can be inspected, and retried once the cause is fixed.
+	DeadLetterStore interface {
+		// Add stores a dead letter.
+		Add(letter DeadLetter) error
+
+		// DeadLetters lists all stored dead letters.
+		DeadLetters() ([]DeadLetter, error)
+
+		// Remove removes the dead letter of the mention with the given id.
+		// ErrNoDeadLetter is returned if there is no such mention.
+		Remove(id string) (DeadLetter, error)
+	}
+
+	// MemoryDeadLetterStore keeps dead letters in memory.
+	MemoryDeadLetterStore struct {
+		m       sync.Mutex
+		letters []DeadLetter
+	}
+
+	// FileDeadLetterStore keeps dead letters in a JSON file, which is
+	// rewritten on every change.
+	// Since it is expected to be small, it is read anew every time, so that
+	// other processes (e.g., a command line tool) can make changes as well.
+	FileDeadLetterStore struct {
+		m    sync.Mutex
+		path string
+	}
+)
+
+var (
+	// *MemoryDeadLetterStore implements DeadLetterStore
+	_ DeadLetterStore = (*MemoryDeadLetterStore)(nil)
+	// *FileDeadLetterStore implements DeadLetterStore
+	_ DeadLetterStore = (*FileDeadLetterStore)(nil)
+)
+
+// maxRetryDelay caps the exponential backoff.
+const maxRetryDelay = 6 * time.Hour
+
+// WithRetries retries mentions that failed to be processed (other than being
+// rejected) up to maxRetries times, waiting delay before the first retry,
+// doubling it for every further retry.
+// Retries are scheduled in memory, mentions waiting for a retry during
+// Shutdown are moved to the dead letters right away.
+func WithRetries(maxRetries int, delay time.Duration) ReceiverOption {
+	return func(r *Receiver) {
+		r.maxRetries = maxRetries
+		r.retryDelay = delay
+	}
+}
+
+// WithDeadLetters keeps mentions that failed even after retrying in store.
+// Without a store, they are discarded.
+func WithDeadLetters(store DeadLetterStore) ReceiverOption {
+	return func(r *Receiver) {
+		r.deadLetters = store
+	}
+}
+
+// DeadLetters lists mentions that failed to be processed.
+func (receiver *Receiver) DeadLetters() ([]DeadLetter, error) {
+	if receiver.deadLetters == nil {
+		return nil, nil
+	}
+	return receiver.deadLetters.DeadLetters()
+}
+
+// RetryDeadLetter removes a mention from the dead letters, and queues it for processing again.
+// It gets the full number of retries again.
+func (receiver *Receiver) RetryDeadLetter(id string) error {
+	if receiver.deadLetters == nil {
+		return ErrNoDeadLetter
+	}
+	letter, err := receiver.deadLetters.Remove(id)
+	if err != nil {
+		return err
+	}
+	mention := letter.Mention
+	mention.Attempts = 0
+	if err := receiver.queue.Push(mention); err != nil {
+		// put it back, so it isn't lost
+		return errors.Join(fmt.Errorf("retry dead letter: %w", err), receiver.deadLetters.Add(letter))
+	}
+	receiver.setState(mention, StateQueued)
+	return nil
+}
+
+// DiscardDeadLetter removes a mention from the dead letters for good.
+func (receiver *Receiver) DiscardDeadLetter(id string) error {
+	if receiver.deadLetters == nil {
+		return ErrNoDeadLetter
+	}
+	_, err := receiver.deadLetters.Remove(id)
+	return err
+}
+
+// retry schedules mention to be processed again, or moves it to the dead
+// letters, if it ran out of retries.
+func (receiver *Receiver) retry(mention Mention, reason error) {
+	if mention.Attempts >= receiver.maxRetries {
+		receiver.deadLetter(mention, reason)
+		return
+	}
+	select {
+	case <-receiver.shutdown:
+		receiver.deadLetter(mention, fmt.Errorf("%w (not retried during shutdown)", reason))
+		return
+	default:
+	}
+	mention.Attempts++
+	delay := backoff(receiver.retryDelay, mention.Attempts)
+	eta := time.Now().Add(delay + receiver.throughput.estimate(1))
+	receiver.setStatus(mention, MentionStatus{State: StateRetrying, ETA: &eta})
+
+	receiver.retriesMu.Lock()
+	defer receiver.retriesMu.Unlock()
+	if receiver.retries == nil {
+		receiver.retries = map[string]retry{}
+	}
+	timer := time.AfterFunc(delay, func() {
+		receiver.retriesMu.Lock()
+		_, pending := receiver.retries[mention.ID]
+		delete(receiver.retries, mention.ID)
+		receiver.retriesMu.Unlock()
+		if !pending {
+			return // taken care of by Shutdown
+		}
+		if err := receiver.queue.Push(mention); err != nil {
+			receiver.deadLetter(mention, fmt.Errorf("%w (and requeueing failed: %w)", reason, err))
+			return
+		}
+	})
+	receiver.retries[mention.ID] = retry{timer, mention, reason}
+}
+
+// abortRetries moves all mentions still waiting for a retry to the dead letters.
+func (receiver *Receiver) abortRetries() {
+	receiver.retriesMu.Lock()
+	retries := receiver.retries
+	receiver.retries = nil
+	receiver.retriesMu.Unlock()
+	for _, r := range retries {
+		if r.timer.Stop() {
+			receiver.deadLetter(r.mention, fmt.Errorf("%w (retry aborted by shutdown)", r.reason))
+		}
+	}
+}
+
+func (receiver *Receiver) deadLetter(mention Mention, reason error) {
+	if receiver.deadLetters == nil {
+		receiver.setState(mention, StateFailed)
+		return
+	}
+	err := receiver.deadLetters.Add(DeadLetter{
+		Mention: mention,
+		Reason:  reason.Error(),
+		Failed:  time.Now(),
+	})
+	if err != nil {
+		receiver.report(fmt.Errorf("dead letter: %w", err), mention)
+		receiver.setState(mention, StateFailed)
+		return
+	}
+	receiver.setState(mention, StateDeadLettered)
+}
+
+// backoff returns delay doubled for every attempt after the first, with up to 10% jitter.
+func backoff(delay time.Duration, attempt int) time.Duration {
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+	return delay + rand.N(delay/10+1)
+}
+
+func (s *MemoryDeadLetterStore) Add(letter DeadLetter) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.letters = append(s.letters, letter)
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) DeadLetters() ([]DeadLetter, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return slices.Clone(s.letters), nil
+}
+
+func (s *MemoryDeadLetterStore) Remove(id string) (DeadLetter, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for i, letter := range s.letters {
+		if letter.Mention.ID == id {
+			s.letters = slices.Delete(s.letters, i, i+1)
+			return letter, nil
+		}
+	}
+	return DeadLetter{}, ErrNoDeadLetter
+}
+
+func NewFileDeadLetterStore(path string) *FileDeadLetterStore {
+	return &FileDeadLetterStore{path: path}
+}
+
+func (s *FileDeadLetterStore) Add(letter DeadLetter) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	letters, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.write(append(letters, letter))
+}
+
+func (s *FileDeadLetterStore) DeadLetters() ([]DeadLetter, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.read()
+}
+
+func (s *FileDeadLetterStore) Remove(id string) (DeadLetter, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	letters, err := s.read()
+	if err != nil {
+		return DeadLetter{}, err
+	}
+	for i, letter := range letters {
+		if letter.Mention.ID == id {
+			return letter, s.write(slices.Delete(letters, i, i+1))
+		}
+	}
+	return DeadLetter{}, ErrNoDeadLetter
+}
+
+func (s *FileDeadLetterStore) read() ([]DeadLetter, error) {
+	bs, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("dead letters: %w", err)
+	}
+	var letters []DeadLetter
+	if err := json.Unmarshal(bs, &letters); err != nil {
+		return nil, fmt.Errorf("dead letters: %s: %w", s.path, err)
+	}
+	return letters, nil
+}
+
+// write replaces the file atomically.
+func (s *FileDeadLetterStore) write(letters []DeadLetter) error {
+	bs, err := json.MarshalIndent(letters, "", "\t")
+	if err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("dead letters: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package webmention
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type (
+	// A DeliveryLog additionally records every mention posted to an
+	// endpoint, as an audit log of what was sent, and to skip identical
+	// re-sends (see WithResendWindow).
+	DeliveryLog interface {
+		Persister
+
+		// RecordDelivery records that a mention was posted.
+		RecordDelivery(delivery Delivery) error
+
+		// Deliveries returns the deliveries recorded from source to target,
+		// oldest first.
+		// A log may only keep the most recent deliveries.
+		Deliveries(source, target URL) ([]Delivery, error)
+	}
+
+	// Delivery is a mention posted to an endpoint.
+	Delivery struct {
+		Source   string    `json:"source"`
+		Target   string    `json:"target"`
+		Endpoint string    `json:"endpoint"`
+		Time     time.Time `json:"time"`
+		// Status is the http status code the endpoint answered with, zero
+		// if the request failed.
+		Status int `json:"status,omitempty"`
+		// PayloadHash is the hex encoded sha256 of the posted form, and the
+		// version of the source it was posted for.
+		PayloadHash string `json:"payload_hash"`
+		// TraceParent is the W3C Trace Context the mention was posted with.
+		TraceParent string `json:"traceparent,omitempty"`
+	}
+)
+
+// maxDeliveries is the number of deliveries a KeyValuePersister keeps per source and target.
+const maxDeliveries = 20
+
+var (
+	// *KeyValuePersister implements DeliveryLog
+	_ DeliveryLog = (*KeyValuePersister)(nil)
+	// *MemoryPersister implements DeliveryLog
+	_ DeliveryLog = (*MemoryPersister)(nil)
+)
+
+// WithResendWindow skips posting a mention, if the exact same payload was
+// successfully posted to the same endpoint within window, for the same
+// version of the source, e.g., to not mention every target again when a
+// site is rebuilt repeatedly.
+// The source is fetched to tell its versions apart, so that updates and
+// deletions are always sent. Deferred mentions (see WithSendWindows) are
+// never skipped.
+// Requires a Persister that implements DeliveryLog.
+func WithResendWindow(window time.Duration) SenderOption {
+	return func(s *Sender) {
+		s.resendWindow = window
+	}
+}
+
+// Succeeded reports whether the endpoint accepted the mention.
+func (delivery Delivery) Succeeded() bool {
+	return delivery.Status >= 200 && delivery.Status < 300
+}
+
+func payloadHash(body, version string) string {
+	sum := sha256.Sum256([]byte(body + "\n" + version))
+	return hex.EncodeToString(sum[:])
+}
+
+// recentlyDelivered reports whether the payload was already delivered to
+// endpoint within the resend window.
+// If the deliveries cannot be looked up, the mention is sent again.
+func (sender *Sender) recentlyDelivered(source, target, endpoint URL, hash string) bool {
+	log, ok := sender.persister.(DeliveryLog)
+	if !ok || sender.resendWindow <= 0 {
+		return false
+	}
+	deliveries, err := log.Deliveries(source, target)
+	if err != nil {
+		slog.Error(fmt.Sprintf("delivery log: %s", err), "source", source.String(), "target", target.String())
+		return false
+	}
+	for _, delivery := range deliveries {
+		if delivery.Succeeded() && delivery.Endpoint == endpoint.String() && delivery.PayloadHash == hash && time.Since(delivery.Time) < sender.resendWindow {
+			return true
+		}
+	}
+	return false
+}
+
+// recordDelivery adds a delivery to the log, if the persister keeps one.
+// Errors are only logged, the mention was sent either way.
+func (sender *Sender) recordDelivery(delivery Delivery) {
+	log, ok := sender.persister.(DeliveryLog)
+	if !ok {
+		return
+	}
+	if err := log.RecordDelivery(delivery); err != nil {
+		slog.Error(fmt.Sprintf("delivery log: %s", err), "source", delivery.Source, "target", delivery.Target)
+	}
+}
+
+func deliveriesKey(source, target string) string {
+	return "deliveries:" + source + " " + target
+}
+
+func (p *KeyValuePersister) Deliveries(source, target URL) ([]Delivery, error) {
+	value, ok, err := p.Store.Get(deliveriesKey(source.String(), target.String()))
+	if err != nil || !ok {
+		return nil, err
+	}
+	var deliveries []Delivery
+	if err := json.Unmarshal([]byte(value), &deliveries); err != nil {
+		return nil, fmt.Errorf("persister: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordDelivery keeps the last 20 deliveries per source and target.
+// Concurrent deliveries of the same source and target may overwrite each other's records.
+func (p *KeyValuePersister) RecordDelivery(delivery Delivery) error {
+	source, target := delivery.Source, delivery.Target
+	value, _, err := p.Store.Get(deliveriesKey(source, target))
+	if err != nil {
+		return fmt.Errorf("persister: %w", err)
+	}
+	var deliveries []Delivery
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &deliveries); err != nil {
+			return fmt.Errorf("persister: %w", err)
+		}
+	}
+	deliveries = append(deliveries, delivery)
+	if len(deliveries) > maxDeliveries {
+		deliveries = deliveries[len(deliveries)-maxDeliveries:]
+	}
+	bs, err := json.Marshal(deliveries)
+	if err != nil {
+		return fmt.Errorf("persister: %w", err)
+	}
+	return p.Store.Set(deliveriesKey(source, target), string(bs), 0)
+}
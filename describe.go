@@ -0,0 +1,184 @@
+package webmention
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type (
+	// EndpointDescription is how the endpoint describes itself to humans
+	// poking at its url (see WithSelfDescription).
+	EndpointDescription struct {
+		Description   string              `json:"description"`
+		Specification string              `json:"specification"`
+		Method        string              `json:"method"`
+		ContentType   string              `json:"content_type"`
+		Parameters    []EndpointParameter `json:"parameters"`
+		// Endpoint is the url of the endpoint, as requested.
+		Endpoint string         `json:"endpoint,omitempty"`
+		Policy   EndpointPolicy `json:"policy"`
+		// Status is where senders check on their submissions, {id} is the
+		// last path segment of the Location header of the response.
+		Status string `json:"status,omitempty"`
+		// OpenAPI is where the OpenAPI document of the handler is served.
+		OpenAPI string `json:"openapi,omitempty"`
+	}
+
+	EndpointParameter struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Required    bool   `json:"required"`
+	}
+
+	// EndpointPolicy is what the endpoint expects of submissions.
+	EndpointPolicy struct {
+		// SourceTypes are the media types of sources that are understood.
+		SourceTypes []string `json:"source_types"`
+		// MaxBodySize is the size limit of requests in bytes, 0 if unlimited.
+		MaxBodySize int64 `json:"max_body_size,omitempty"`
+		// Resubmission is how long to wait before submitting the same
+		// source and target again, e.g., 1m0s
+		Resubmission string `json:"resubmission"`
+		// DuplicateArguments tells whether naming the source or target
+		// more than once is rejected.
+		DuplicateArguments string `json:"duplicate_arguments"`
+		// Moderated is set if mentions are held for moderation.
+		Moderated bool `json:"moderated"`
+		// Signatures is set if signed requests are verified.
+		Signatures bool `json:"signatures"`
+	}
+
+	// endpointLinks are the routes a receiver served by a handler can point to.
+	endpointLinks struct {
+		// statusURL returns the status route of a submission, nil if not served
+		statusURL func(id string) string
+		openAPI   string
+	}
+)
+
+// webmentionSpec is the specification the endpoint implements.
+const webmentionSpec = "https://www.w3.org/TR/webmention/"
+
+// WithSelfDescription answers GET and HEAD requests to the endpoint with a
+// short description of it, instead of http.StatusMethodNotAllowed: what the
+// endpoint is for, the parameters it accepts, its policy, and where to check
+// on submissions. The description is served as JSON to clients asking for
+// it, as html otherwise.
+func WithSelfDescription() ReceiverOption {
+	return func(r *Receiver) {
+		r.selfDescription = true
+	}
+}
+
+// Describe returns the description served by the endpoint (see WithSelfDescription).
+func (receiver *Receiver) Describe() EndpointDescription {
+	return receiver.describe(endpointLinks{})
+}
+
+func (receiver *Receiver) describe(links endpointLinks) EndpointDescription {
+	duplicates := "rejected"
+	if receiver.duplicateArguments == FirstArgument {
+		duplicates = "the first one is used"
+	}
+	description := EndpointDescription{
+		Description:   "This is a Webmention endpoint. Let it know that a page of yours (source) links to a page of this site (target), it verifies the link and notifies the site.",
+		Specification: webmentionSpec,
+		Method:        http.MethodPost,
+		ContentType:   "application/x-www-form-urlencoded",
+		Parameters: []EndpointParameter{
+			{Name: "source", Description: "url of the page linking to target", Required: true},
+			{Name: "target", Description: "url of the page on this site being linked to", Required: true},
+		},
+		Policy: EndpointPolicy{
+			MaxBodySize:        receiver.maxBodySize,
+			Resubmission:       receiver.cacheTimeout.String(),
+			DuplicateArguments: duplicates,
+			Moderated:          receiver.moderation != nil,
+			Signatures:         len(receiver.trustedKeys) > 0,
+		},
+		OpenAPI: links.openAPI,
+	}
+	for _, handler := range receiver.mediaHandler {
+		description.Policy.SourceTypes = append(description.Policy.SourceTypes, handler.name)
+	}
+	if links.statusURL != nil {
+		description.Status = links.statusURL("{id}")
+	}
+	return description
+}
+
+var descriptionTemplate = template.Must(template.New("description").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><title>Webmention endpoint</title></head>
+<body>
+<h1>Webmention endpoint</h1>
+<p>{{.Description}} See the <a href="{{.Specification}}">Webmention specification</a>.</p>
+<h2>Sending a mention</h2>
+<p>Send a {{.Method}} request with a body of type <code>{{.ContentType}}</code>:</p>
+<dl>
+{{- range .Parameters}}
+<dt><code>{{.Name}}</code>{{if .Required}} (required){{end}}</dt><dd>{{.Description}}</dd>
+{{- end}}
+</dl>
+{{- if .Endpoint}}
+<pre>curl -i -d source=https://example.com/post -d target=https://example.org/page {{.Endpoint}}</pre>
+{{- end}}
+<h2>Policy</h2>
+<ul>
+<li>Sources of type {{range $i, $t := .Policy.SourceTypes}}{{if $i}}, {{end}}<code>{{$t}}</code>{{end}} are understood.</li>
+{{- if .Policy.MaxBodySize}}
+<li>Requests may be at most {{.Policy.MaxBodySize}} bytes.</li>
+{{- end}}
+<li>The same source and target can be submitted again after {{.Policy.Resubmission}}.</li>
+<li>Naming the source or target more than once: {{.Policy.DuplicateArguments}}.</li>
+{{- if .Policy.Moderated}}
+<li>Mentions are held for moderation.</li>
+{{- end}}
+{{- if .Policy.Signatures}}
+<li>Signed requests (HTTP Message Signatures) are verified.</li>
+{{- end}}
+</ul>
+{{- if or .Status .OpenAPI}}
+<h2>API</h2>
+<ul>
+{{- if .Status}}
+<li>Check on a submission at <code>{{.Status}}</code>, it is linked to by the Location header of the response.</li>
+{{- end}}
+{{- if .OpenAPI}}
+<li>The <a href="{{.OpenAPI}}">OpenAPI document</a> describes all routes.</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+// serveDescription answers a GET or HEAD request with the description of
+// the endpoint, as JSON if the client asks for it.
+func (receiver *Receiver) serveDescription(w http.ResponseWriter, r *http.Request, links endpointLinks) {
+	description := receiver.describe(links)
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	description.Endpoint = scheme + "://" + r.Host + r.URL.EscapedPath()
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Allow", "GET, HEAD, POST")
+	if prefersJSON(r) {
+		writeJSON(w, description)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := descriptionTemplate.Execute(w, description); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// prefersJSON reports whether the request asks for JSON rather than html.
+func prefersJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	json, html := strings.Index(accept, "json"), strings.Index(accept, "text/html")
+	return json >= 0 && (html < 0 || json < html)
+}
@@ -0,0 +1,120 @@
+package webmention
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+type (
+	// An EndpointLog additionally records the endpoint discovered for every
+	// target, so that it doesn't have to be discovered again while the
+	// record is recent (see WithRediscoveryAfter), and to tell which
+	// endpoint a target was mentioned at.
+	EndpointLog interface {
+		Persister
+
+		// RecordEndpoint replaces the record of the endpoint of record.Target.
+		RecordEndpoint(record EndpointRecord) error
+
+		// Endpoint returns the record of the endpoint of target, ok is
+		// false if there is none.
+		Endpoint(target URL) (record EndpointRecord, ok bool, err error)
+	}
+
+	// EndpointRecord is the outcome of discovering the endpoint of a target.
+	EndpointRecord struct {
+		Target    string `json:"target"`
+		Endpoint  string `json:"endpoint"`
+		Canonical string `json:"canonical,omitempty"`
+		// Discovered is when the endpoint was discovered.
+		Discovered time.Time `json:"discovered"`
+	}
+)
+
+var (
+	// *KeyValuePersister implements EndpointLog
+	_ EndpointLog = (*KeyValuePersister)(nil)
+	// *MemoryPersister implements EndpointLog
+	_ EndpointLog = (*MemoryPersister)(nil)
+)
+
+// WithRediscoveryAfter reuses the endpoint recorded for a target, instead of
+// discovering it again, until it is older than age.
+// Requires a Persister that implements EndpointLog, which records every
+// discovered endpoint either way.
+// Unlike the discovery cache (see WithDiscoveryCache), the records are kept
+// with the targets and deliveries of the persister.
+func WithRediscoveryAfter(age time.Duration) SenderOption {
+	return func(s *Sender) {
+		s.rediscoverAfter = age
+	}
+}
+
+// KnownEndpoint returns the endpoint recorded for target by the persister,
+// ok is false if there is none, or the persister doesn't implement EndpointLog.
+func (sender *Sender) KnownEndpoint(target URL) (record EndpointRecord, ok bool, err error) {
+	log, isLog := sender.persister.(EndpointLog)
+	if !isLog {
+		return record, false, nil
+	}
+	return log.Endpoint(target)
+}
+
+// recordedEndpoint returns the endpoint recorded for target, if it is
+// recent enough to skip discovery.
+func (sender *Sender) recordedEndpoint(target URL) (endpoint, canonical URL, ok bool) {
+	if sender.rediscoverAfter <= 0 {
+		return nil, nil, false
+	}
+	record, ok, err := sender.KnownEndpoint(target)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("endpoint log: %s", err), "target", target.String())
+		return nil, nil, false
+	}
+	if !ok || time.Since(record.Discovered) >= sender.rediscoverAfter {
+		return nil, nil, false
+	}
+	endpoint, canonical, err = parseCachedEndpoint(target, record.Endpoint+" "+record.Canonical)
+	if err != nil {
+		slog.Warn("endpoint log: ignoring invalid record", "target", target.String(), "record", record)
+		return nil, nil, false
+	}
+	return endpoint, canonical, true
+}
+
+// recordEndpoint adds a discovered endpoint to the log, if the persister
+// keeps one. Errors are only logged, the endpoint is discovered either way.
+func (sender *Sender) recordEndpoint(target, endpoint, canonical URL) {
+	log, ok := sender.persister.(EndpointLog)
+	if !ok {
+		return
+	}
+	record := EndpointRecord{Target: target.String(), Endpoint: endpoint.String(), Discovered: time.Now()}
+	if canonical != nil && canonical.String() != target.String() {
+		record.Canonical = canonical.String()
+	}
+	if err := log.RecordEndpoint(record); err != nil {
+		slog.Error(fmt.Sprintf("endpoint log: %s", err), "target", record.Target)
+	}
+}
+
+func (p *KeyValuePersister) Endpoint(target URL) (record EndpointRecord, ok bool, err error) {
+	value, ok, err := p.Store.Get("endpoint-record:" + target.String())
+	if err != nil || !ok {
+		return record, false, err
+	}
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return record, false, fmt.Errorf("persister: %w", err)
+	}
+	return record, true, nil
+}
+
+func (p *KeyValuePersister) RecordEndpoint(record EndpointRecord) error {
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("persister: %w", err)
+	}
+	return p.Store.Set("endpoint-record:"+record.Target, string(bs), 0)
+}
@@ -0,0 +1,180 @@
+package webmention_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// fakeBlog serves posts, and answers deleted posts with 410 Gone.
+type fakeBlog struct {
+	m       sync.Mutex
+	posts   map[string]string
+	deleted map[string]bool
+}
+
+func newFakeBlog() *fakeBlog {
+	return &fakeBlog{posts: map[string]string{}, deleted: map[string]bool{}}
+}
+
+func (blog *fakeBlog) publish(path, body string) {
+	blog.m.Lock()
+	defer blog.m.Unlock()
+	blog.posts[path] = body
+	delete(blog.deleted, path)
+}
+
+func (blog *fakeBlog) delete(path string) {
+	blog.m.Lock()
+	defer blog.m.Unlock()
+	blog.deleted[path] = true
+}
+
+func (blog *fakeBlog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	blog.m.Lock()
+	body, ok := blog.posts[r.URL.Path]
+	deleted := blog.deleted[r.URL.Path]
+	blog.m.Unlock()
+	switch {
+	case deleted:
+		http.Error(w, "gone", http.StatusGone)
+	case !ok:
+		http.NotFound(w, r)
+	default:
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<!doctype html><html><head><link rel="webmention" href="/wm/webmention"></head><body class="h-entry">`+body+`</body></html>`)
+	}
+}
+
+// mentionLog is a notifier remembering every mention it was informed about.
+type mentionLog struct {
+	m        sync.Mutex
+	mentions []webmention.Mention
+}
+
+func (l *mentionLog) Receive(mention webmention.Mention) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.mentions = append(l.mentions, mention)
+}
+
+// await waits for the n-th mention.
+func (l *mentionLog) await(t *testing.T, n int) webmention.Mention {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		l.m.Lock()
+		if len(l.mentions) >= n {
+			mention := l.mentions[n-1]
+			l.m.Unlock()
+			return mention
+		}
+		l.m.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatalf("notifier not informed about mention %d in time", n)
+		}
+	}
+}
+
+// TestEndToEnd runs a sender and a receiver against each other: Alice's
+// blog mentions a post on Bob's blog, which runs the receiver, then updates
+// the post to no longer link to Bob, and finally deletes it.
+func TestEndToEnd(t *testing.T) {
+	log := &mentionLog{}
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(func(source, target webmention.URL) bool {
+			return target.Hostname() == "localhost"
+		}),
+		webmention.WithStorage(storage),
+		webmention.WithNotifier(log),
+		webmention.WithCacheTimeout(time.Nanosecond), // the same mention is sent thrice
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+
+	bob := newFakeBlog()
+	bob.publish("/posts/hello", `<p>Hello, World!</p>`)
+	bobMux := http.NewServeMux()
+	bobMux.Handle("/wm/", webmention.NewReceiverHandler(receiver,
+		webmention.WithMountPoint("/wm"),
+		webmention.WithRoutes(webmention.RouteWebmention|webmention.RouteStatus|webmention.RouteMentions),
+	))
+	bobMux.Handle("/", bob)
+	bobServer := httptest.NewServer(bobMux)
+	defer bobServer.Close()
+	// served as localhost, so that the sender doesn't skip it as a link
+	// to the same host as alice's blog
+	bobURL := strings.Replace(bobServer.URL, "127.0.0.1", "localhost", 1)
+
+	alice := newFakeBlog()
+	aliceServer := httptest.NewServer(alice)
+	defer aliceServer.Close()
+
+	sender := webmention.NewSender()
+	source := must(url.Parse(aliceServer.URL + "/posts/reply"))
+	target := must(url.Parse(bobURL + "/posts/hello"))
+
+	// publish
+	alice.publish("/posts/reply", `<p>In reply to <a class="u-in-reply-to" href="`+target.String()+`">Bob</a>: hi!</p>`)
+	results, err := sender.UpdateResults(source, nil, []webmention.URL{target})
+	if err != nil {
+		t.Fatalf("publish: %s: %s", err, results)
+	}
+	mention := log.await(t, 1)
+	if mention.Status != webmention.StatusLink || mention.Source.String() != source.String() || mention.Target.String() != target.String() {
+		t.Fatalf("publish: incorrect mention: %+v", mention)
+	}
+	if mention.Type != webmention.TypeReply {
+		t.Errorf("publish: incorrect type, got: %q, want: %q", mention.Type, webmention.TypeReply)
+	}
+	stored := storedMentions(t, bobServer.URL)
+	if len(stored) != 1 || stored[0].Status != webmention.StatusLink {
+		t.Fatalf("publish: incorrect storage: %+v", stored)
+	}
+
+	// update, the link is removed
+	alice.publish("/posts/reply", `<p>Never mind.</p>`)
+	if results, err := sender.UpdateResults(source, []webmention.URL{target}, nil); err != nil {
+		t.Fatalf("update: %s: %s", err, results)
+	}
+	if mention := log.await(t, 2); mention.Status != webmention.StatusNoLink {
+		t.Errorf("update: incorrect status, got: %q, want: %q", mention.Status, webmention.StatusNoLink)
+	}
+	if stored := storedMentions(t, bobServer.URL); len(stored) != 1 || stored[0].Status != webmention.StatusNoLink {
+		t.Errorf("update: incorrect storage: %+v", stored)
+	}
+
+	// delete
+	alice.delete("/posts/reply")
+	if results, err := sender.UpdateResults(source, []webmention.URL{target}, nil); err != nil {
+		t.Fatalf("delete: %s: %s", err, results)
+	}
+	if mention := log.await(t, 3); mention.Status != webmention.StatusDeleted {
+		t.Errorf("delete: incorrect status, got: %q, want: %q", mention.Status, webmention.StatusDeleted)
+	}
+	if stored := storedMentions(t, bobServer.URL); len(stored) != 1 || stored[0].Status != webmention.StatusDeleted {
+		t.Errorf("delete: incorrect storage: %+v", stored)
+	}
+}
+
+// storedMentions lists the mentions stored by the receiver at base.
+func storedMentions(t *testing.T, base string) []webmention.Mention {
+	t.Helper()
+	resp := must(http.Get(base + "/wm/mentions"))
+	defer resp.Body.Close()
+	var page webmention.MentionPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	return page.Mentions
+}
@@ -0,0 +1,76 @@
+package webmention
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EndpointError is returned when a discovered endpoint is refused, e.g.,
+// because a malicious page advertised a javascript: or file: endpoint.
+// Check the reason with errors.Is(err, ErrEndpointScheme), ErrEndpointDowngrade,
+// or ErrEndpointForeign.
+type EndpointError struct {
+	Target, Endpoint URL
+	Err              error
+}
+
+func (e *EndpointError) Error() string {
+	return fmt.Sprintf("endpoint discovery: %s: refusing endpoint %s: %s", e.Target, e.Endpoint, e.Err)
+}
+
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+// WithDowngradeProtection refuses http endpoints advertised by https targets,
+// so that mentions of an https page are never sent in the clear.
+// Onion services are exempt, their traffic is encrypted by Tor.
+// Endpoints with schemes other than http and https are always refused.
+func WithDowngradeProtection() SenderOption {
+	return func(s *Sender) {
+		s.refuseDowngrade = true
+	}
+}
+
+// WithSameSiteEndpoints only delivers to endpoints on the same registrable
+// domain as the target (e.g., webmention.example.com for blog.example.com),
+// or on one of the allowed domains (or their subdomains).
+// This prevents a compromised page from silently redirecting mentions to a
+// third-party collector, but also refuses hosted services like webmention.io,
+// unless they are allowed explicitly.
+func WithSameSiteEndpoints(allowed ...string) SenderOption {
+	return func(s *Sender) {
+		s.sameSite = true
+		for _, domain := range allowed {
+			s.allowedEndpoints = append(s.allowedEndpoints, strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), ".")))
+		}
+	}
+}
+
+// sameSiteEndpoint reports whether endpoint may receive mentions of target.
+func (sender *Sender) sameSiteEndpoint(target, endpoint URL) bool {
+	host := strings.ToLower(endpoint.Hostname())
+	if registrableDomain(host) == registrableDomain(target.Hostname()) {
+		return true
+	}
+	for _, domain := range sender.allowedEndpoints {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEndpoint validates an endpoint discovered on target.
+func (sender *Sender) checkEndpoint(target, endpoint URL) error {
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return &EndpointError{Target: target, Endpoint: endpoint, Err: ErrEndpointScheme}
+	}
+	if sender.refuseDowngrade && target.Scheme == "https" && endpoint.Scheme == "http" && !IsOnion(endpoint) {
+		return &EndpointError{Target: target, Endpoint: endpoint, Err: ErrEndpointDowngrade}
+	}
+	if sender.sameSite && !sender.sameSiteEndpoint(target, endpoint) {
+		return &EndpointError{Target: target, Endpoint: endpoint, Err: ErrEndpointForeign}
+	}
+	return nil
+}
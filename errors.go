@@ -1,21 +1,192 @@
 package webmention
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 )
 
+// Errors of this package are classified along two axes, match the class
+// of an error with errors.Is:
+//
+//   - ErrTemporary or ErrPermanent: whether trying again later may succeed,
+//   - ErrOurs or ErrTheirs: whether the failure is on this side (the
+//     configuration, storage, capacity, or the caller of a method), or on
+//     the other side (the source, the target, its endpoint, or the sender
+//     of a request).
+//
+// Errors of other packages, e.g., network errors, are not classified, use
+// IsRetryable to decide whether to try again.
 var (
-	ErrNotImplemented            = errors.New("not implemented")
-	ErrNoEndpointFound           = errors.New("no webmention endpoint found")
-	ErrNoRelWebmention           = errors.New("no webmention relationship found")
-	ErrInvalidRelWebmention      = errors.New("target has invalid webmention url")
-	ErrSourceDeleted             = errors.New("source got deleted")
-	ErrSourceNotFound            = errors.New("source not found")
-	ErrSourceDoesNotLinkToTarget = errors.New("source does not link to target")
+	ErrTemporary = errors.New("temporary failure")
+	ErrPermanent = errors.New("permanent failure")
+	ErrOurs      = errors.New("failure on our side")
+	ErrTheirs    = errors.New("failure on their side")
 )
 
+// Errors of sending mentions.
+var (
+	ErrNoEndpointFound      = newError(permanentTheirs, "no webmention endpoint found")
+	ErrNoRelWebmention      = newError(permanentTheirs, "no webmention relationship found")
+	ErrInvalidRelWebmention = newError(permanentTheirs, "target has invalid webmention url")
+	ErrEndpointScheme       = newError(permanentTheirs, "endpoint scheme not supported (supported schemes are: http, https)")
+	ErrEndpointDowngrade    = newError(permanentTheirs, "endpoint would downgrade from https to http")
+	ErrEndpointForeign      = newError(permanentTheirs, "endpoint is not on the same site as the target")
+	ErrRateLimited          = newError(temporaryTheirs, "rate limit of target site exceeded")
+	// ErrOnionWithoutProxy is returned when connecting to an onion service
+	// without going through a proxy (Tor), which would leak the onion address to
+	// the DNS.
+	ErrOnionWithoutProxy = newError(permanentOurs, "onion services can only be reached through a proxy")
+)
+
+// Errors of verifying mentions, the source ones apply to sending as well
+// (see WithPreflight).
+var (
+	ErrSourceDeleted = newError(permanentTheirs, "source got deleted")
+	// ErrSourceNotFound is returned if the source answers with an error
+	// status, it is temporary if the status is (e.g., 503 Service Unavailable).
+	ErrSourceNotFound            = newError(permanentTheirs, "source not found")
+	ErrSourceInaccessible        = newError(temporaryTheirs, "source is behind a login or consent wall")
+	ErrSourceDoesNotLinkToTarget = newError(permanentTheirs, "source does not link to target")
+	ErrSourceTooLarge            = newError(permanentTheirs, "source too large")
+	ErrUnknownTarget             = newError(permanentTheirs, "target does not resolve to any known content")
+	ErrTargetClosed              = newError(permanentOurs, "target is closed for new mentions")
+	ErrRejected                  = newError(permanentTheirs, "mention rejected")
+	ErrQueueFull                 = newError(temporaryOurs, "queue full")
+	ErrQueueClosed               = newError(permanentOurs, "queue closed")
+	// ErrMonthlyQuotaExceeded is returned if a mention would exceed the
+	// monthly quota of its domain.
+	ErrMonthlyQuotaExceeded = newError(temporaryOurs, "monthly quota exceeded")
+	ErrQuotaExceeded        = newError(temporaryOurs, "daily quota of tenant exceeded")
+)
+
+// Errors of looking up and managing mentions.
+var (
+	ErrNotImplemented = newError(permanentOurs, "not implemented")
+	// ErrNoStorage is returned by operations that require a Storage, if none has been configured.
+	ErrNoStorage    = newError(permanentOurs, "no storage configured")
+	ErrNoUsageMeter = newError(permanentOurs, "no usage meter configured")
+	// ErrUnknownMention is returned when looking up the status of a mention that
+	// does not exist (anymore).
+	ErrUnknownMention = newError(permanentOurs, "unknown mention")
+	ErrNotPending     = newError(permanentOurs, "no such mention awaiting moderation")
+	// ErrInvalidModerationLink is returned for moderation links that were
+	// not signed with the secret, or have expired.
+	ErrInvalidModerationLink = newError(permanentTheirs, "invalid or expired moderation link")
+	// ErrNoDeadLetter is returned when removing a dead letter that doesn't exist.
+	ErrNoDeadLetter = newError(permanentOurs, "no such dead letter")
+	// ErrNoRejection is returned when looking up a rejection that was not recorded (or has expired).
+	ErrNoRejection = newError(permanentOurs, "no rejection recorded")
+	// ErrInvalidCursor is returned when querying with a cursor that wasn't
+	// returned by a previous query.
+	ErrInvalidCursor = newError(permanentOurs, "invalid cursor")
+	ErrUnknownTenant = newError(permanentOurs, "no such tenant")
+)
+
+type (
+	// errorClass is the class of an error, see ErrTemporary and ErrOurs.
+	errorClass struct {
+		temporary, ours bool
+	}
+
+	// classifiedError is an error of the taxonomy.
+	classifiedError struct {
+		msg   string
+		class errorClass
+	}
+
+	// reclassifiedError overrides the class of err, e.g., ErrSourceNotFound
+	// is temporary for a source answering 503 Service Unavailable.
+	// It doesn't unwrap, so that the class of err doesn't match as well,
+	// but it still matches everything err matches otherwise.
+	reclassifiedError struct {
+		err   error
+		class errorClass
+	}
+)
+
+var (
+	temporaryOurs   = errorClass{temporary: true, ours: true}
+	temporaryTheirs = errorClass{temporary: true}
+	permanentOurs   = errorClass{ours: true}
+	permanentTheirs = errorClass{}
+)
+
+func newError(class errorClass, msg string) error {
+	return &classifiedError{msg, class}
+}
+
+// is reports whether target is one of the classes, and whether class is it.
+func (class errorClass) is(target error) (matches, ok bool) {
+	switch target {
+	case ErrTemporary:
+		return class.temporary, true
+	case ErrPermanent:
+		return !class.temporary, true
+	case ErrOurs:
+		return class.ours, true
+	case ErrTheirs:
+		return !class.ours, true
+	}
+	return false, false
+}
+
+func (e *classifiedError) Error() string {
+	return e.msg
+}
+
+func (e *classifiedError) Is(target error) bool {
+	matches, _ := e.class.is(target)
+	return matches
+}
+
+func (e reclassifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e reclassifiedError) Is(target error) bool {
+	if matches, ok := e.class.is(target); ok {
+		return matches
+	}
+	return errors.Is(e.err, target)
+}
+
+func (e reclassifiedError) As(target any) bool {
+	return errors.As(e.err, target)
+}
+
+// statusClass classifies an error status answered by the other side.
+func statusClass(code int) errorClass {
+	if code == http.StatusTooManyRequests || code >= 500 {
+		return temporaryTheirs
+	}
+	return permanentTheirs
+}
+
+// IsRetryable reports whether an operation that failed with err may succeed
+// if it is tried again later: errors of the taxonomy that match
+// ErrTemporary, network errors, and timeouts.
+func IsRetryable(err error) bool {
+	var (
+		urlErr *url.Error
+		netErr net.Error
+	)
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, ErrTemporary):
+		return true
+	case errors.Is(err, ErrPermanent):
+		return false
+	case errors.As(err, &urlErr), errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return false
+}
+
 type (
 	ErrorResponder interface {
 		RespondError(w http.ResponseWriter, r *http.Request) bool
@@ -28,6 +199,9 @@ type (
 	}
 
 	ErrTooManyRequests struct{}
+
+	// ErrRequestTooLarge is returned for request bodies over the limit.
+	ErrRequestTooLarge struct{}
 )
 
 func MethodNotAllowed() error {
@@ -38,6 +212,11 @@ func (e ErrMethodNotAllowed) Error() string {
 	return "method not allowed"
 }
 
+func (e ErrMethodNotAllowed) Is(target error) bool {
+	matches, _ := permanentTheirs.is(target)
+	return matches
+}
+
 func (e ErrMethodNotAllowed) RespondError(w http.ResponseWriter, r *http.Request) bool {
 	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	return true
@@ -51,6 +230,11 @@ func (e ErrBadRequest) Error() string {
 	return fmt.Sprintf("bad request: %s", e.Message)
 }
 
+func (e ErrBadRequest) Is(target error) bool {
+	matches, _ := permanentTheirs.is(target)
+	return matches
+}
+
 func (e ErrBadRequest) RespondError(w http.ResponseWriter, r *http.Request) bool {
 	http.Error(w, e.Error(), http.StatusBadRequest)
 	return true
@@ -64,7 +248,30 @@ func (e ErrTooManyRequests) Error() string {
 	return "too many requests"
 }
 
+func (e ErrTooManyRequests) Is(target error) bool {
+	matches, _ := temporaryTheirs.is(target)
+	return matches
+}
+
 func (e ErrTooManyRequests) RespondError(w http.ResponseWriter, r *http.Request) bool {
 	http.Error(w, e.Error(), http.StatusTooManyRequests)
 	return true
 }
+
+func RequestTooLarge() error {
+	return ErrRequestTooLarge{}
+}
+
+func (e ErrRequestTooLarge) Error() string {
+	return "request body too large"
+}
+
+func (e ErrRequestTooLarge) Is(target error) bool {
+	matches, _ := permanentTheirs.is(target)
+	return matches
+}
+
+func (e ErrRequestTooLarge) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	http.Error(w, e.Error(), http.StatusRequestEntityTooLarge)
+	return true
+}
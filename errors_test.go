@@ -0,0 +1,66 @@
+package webmention_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestErrorTaxonomy(t *testing.T) {
+	for _, tc := range []struct {
+		err             error
+		temporary, ours bool
+	}{
+		{webmention.ErrNoEndpointFound, false, false},
+		{webmention.ErrRateLimited, true, false},
+		{webmention.ErrSourceInaccessible, true, false},
+		{webmention.ErrQueueFull, true, true},
+		{webmention.ErrNoStorage, false, true},
+		{fmt.Errorf("mention: %w", webmention.ErrSourceDoesNotLinkToTarget), false, false},
+		{webmention.BadRequest("missing target"), false, false},
+		{&webmention.EndpointError{Err: webmention.ErrEndpointScheme}, false, false},
+	} {
+		if errors.Is(tc.err, webmention.ErrTemporary) != tc.temporary || errors.Is(tc.err, webmention.ErrPermanent) == tc.temporary {
+			t.Errorf("%v: incorrectly classified as temporary or permanent", tc.err)
+		}
+		if errors.Is(tc.err, webmention.ErrOurs) != tc.ours || errors.Is(tc.err, webmention.ErrTheirs) == tc.ours {
+			t.Errorf("%v: incorrectly classified as ours or theirs", tc.err)
+		}
+		if webmention.IsRetryable(tc.err) != tc.temporary {
+			t.Errorf("%v: retryable: %t, want: %t", tc.err, !tc.temporary, tc.temporary)
+		}
+	}
+
+	if !webmention.IsRetryable(&url.Error{Op: "Post", URL: "https://example.com/", Err: errors.New("connection refused")}) {
+		t.Error("network error not retryable")
+	}
+	if webmention.IsRetryable(errors.New("unclassified")) || webmention.IsRetryable(nil) {
+		t.Error("unclassified error retryable")
+	}
+}
+
+func TestErrorTaxonomySourceStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+	sender := webmention.NewSender()
+
+	_, err := sender.NewBatch(must(url.Parse(ts.URL + "/unavailable"))).Document()
+	if !errors.Is(err, webmention.ErrSourceNotFound) || !webmention.IsRetryable(err) || errors.Is(err, webmention.ErrPermanent) {
+		t.Errorf("unavailable source not temporary: %v", err)
+	}
+	_, err = sender.NewBatch(must(url.Parse(ts.URL + "/missing"))).Document()
+	if !errors.Is(err, webmention.ErrSourceNotFound) || webmention.IsRetryable(err) {
+		t.Errorf("missing source not permanent: %v", err)
+	}
+}
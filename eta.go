@@ -0,0 +1,73 @@
+package webmention
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultProcessingTime is how long processing a mention is assumed to
+// take, until the receiver has processed one.
+const defaultProcessingTime = time.Second
+
+// throughput estimates when a queued mention will have been processed, from
+// the number of mentions ahead of it, and how fast the workers (the
+// goroutines running ProcessMentions) process them.
+type throughput struct {
+	m       sync.Mutex
+	workers int
+	// average is the moving average of the time it takes to process a mention
+	average time.Duration
+}
+
+func (t *throughput) started() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.workers++
+}
+
+func (t *throughput) stopped() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.workers--
+}
+
+// processed records that processing a mention took d.
+func (t *throughput) processed(d time.Duration) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.average == 0 {
+		t.average = d
+		return
+	}
+	t.average += (d - t.average) / 8
+}
+
+// estimate returns how long it takes until depth mentions (including the
+// one asked about) have been processed.
+func (t *throughput) estimate(depth int) time.Duration {
+	t.m.Lock()
+	defer t.m.Unlock()
+	average := t.average
+	if average == 0 {
+		average = defaultProcessingTime
+	}
+	workers := max(t.workers, 1)
+	rounds := (max(depth, 1) + workers - 1) / workers
+	return average * time.Duration(rounds)
+}
+
+// processingETA estimates when a mention pushed to the queue just now will
+// have been processed.
+// The depth of the queue is only known if it is a StatsQueue, otherwise the
+// mention is assumed to be the only one. A queue shared with other
+// receivers makes the estimate pessimistic, since their workers are not
+// accounted for.
+func (receiver *Receiver) processingETA() time.Time {
+	depth := 1
+	if queue, ok := receiver.queue.(StatsQueue); ok {
+		if stats, err := queue.Stats(); err == nil {
+			depth = stats.Depth
+		}
+	}
+	return time.Now().Add(receiver.throughput.estimate(depth))
+}
@@ -0,0 +1,8 @@
+package webmention
+
+// exported for the tests in package webmention_test
+var (
+	PageKey            = pageKey
+	SamePage           = samePage
+	VerificationResult = verificationResult
+)
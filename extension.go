@@ -0,0 +1,36 @@
+package webmention
+
+import (
+	"fmt"
+	"net/url"
+)
+
+const (
+	// maxExtensions is the number of extension parameters kept per mention.
+	maxExtensions = 16
+	// maxExtensionSize limits the length of an extension parameter's name and value.
+	maxExtensionSize = 2 << 10
+)
+
+// formExtensions returns the parameters of a submission other than source
+// and target (see Mention.Extensions).
+// Only the first value of a repeated parameter is kept.
+func formExtensions(form url.Values) (map[string]string, error) {
+	var extensions map[string]string
+	for name, values := range form {
+		if name == "source" || name == "target" || isArraySyntax(name, "source") || isArraySyntax(name, "target") || len(values) == 0 {
+			continue
+		}
+		if len(extensions) == maxExtensions {
+			return nil, BadRequest(fmt.Sprintf("too many parameters (at most %d extension parameters are supported)", maxExtensions))
+		}
+		if len(name) > maxExtensionSize || len(values[0]) > maxExtensionSize {
+			return nil, BadRequest(fmt.Sprintf("parameter too long (at most %d bytes are supported)", maxExtensionSize))
+		}
+		if extensions == nil {
+			extensions = map[string]string{}
+		}
+		extensions[name] = values[0]
+	}
+	return extensions, nil
+}
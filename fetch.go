@@ -0,0 +1,138 @@
+package webmention
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// SourceFetcher fetches the sources (and their alternates) of mentions
+	// for verification.
+	// *http.Client is a SourceFetcher, a SnapshotFetcher reads recorded
+	// responses instead, e.g., for integration tests, or to replay captured
+	// production traffic without touching the network.
+	SourceFetcher interface {
+		Do(req *http.Request) (*http.Response, error)
+	}
+
+	// SnapshotFetcher answers requests with the responses recorded in Dir,
+	// one file per url (see SnapshotFile), holding the raw http response,
+	// as written by RecordingFetcher, or curl --include.
+	// Urls without a recording are answered with 404 Not Found, the network
+	// is never used.
+	SnapshotFetcher struct {
+		Dir string
+	}
+
+	// RecordingFetcher passes requests on to Fetcher, and records the
+	// responses to GET requests in Dir, for a SnapshotFetcher to replay.
+	RecordingFetcher struct {
+		Fetcher SourceFetcher
+		Dir     string
+	}
+)
+
+var (
+	// *http.Client implements SourceFetcher
+	_ SourceFetcher = (*http.Client)(nil)
+	// SnapshotFetcher implements SourceFetcher
+	_ SourceFetcher = SnapshotFetcher{}
+	// RecordingFetcher implements SourceFetcher
+	_ SourceFetcher = RecordingFetcher{}
+)
+
+// WithSourceFetcher fetches sources with fetcher, instead of the receiver's
+// http client.
+// Options configuring the network, such as WithFetchProxy, have no effect on it.
+func WithSourceFetcher(fetcher SourceFetcher) ReceiverOption {
+	return func(r *Receiver) {
+		r.fetcher = fetcher
+	}
+}
+
+// WithSourceRecording records the responses of the sources fetched, in dir,
+// for a SnapshotFetcher to replay (see RecordingFetcher).
+func WithSourceRecording(dir string) ReceiverOption {
+	return func(r *Receiver) {
+		r.recordSources = dir
+	}
+}
+
+// SnapshotFile returns the path of the file the response of u is recorded in.
+func SnapshotFile(dir string, u URL) string {
+	clean := *u
+	clean.Fragment = ""
+	return filepath.Join(dir, url.QueryEscape(clean.String())+".http")
+}
+
+// Do answers HEAD requests with the headers recorded for GET.
+func (f SnapshotFetcher) Do(req *http.Request) (*http.Response, error) {
+	bs, err := os.ReadFile(SnapshotFile(f.Dir, req.URL))
+	if errors.Is(err, fs.ErrNotExist) {
+		return &http.Response{
+			Status:     "404 Not Found",
+			StatusCode: http.StatusNotFound,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       http.NoBody,
+			Request:    req,
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(bs)), req)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %s: %w", req.URL, err)
+	}
+	return resp, nil
+}
+
+func (f RecordingFetcher) Do(req *http.Request) (*http.Response, error) {
+	resp, err := f.Fetcher.Do(req)
+	if err != nil || req.Method != http.MethodGet {
+		return resp, err
+	}
+	// DumpResponse keeps the body readable
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("record: %w", err)
+	}
+	if err := writeSnapshot(SnapshotFile(f.Dir, req.URL), dump); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("record: %w", err)
+	}
+	return resp, nil
+}
+
+// writeSnapshot replaces file atomically.
+func writeSnapshot(file string, dump []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), strings.TrimSuffix(filepath.Base(file), ".http")+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(dump); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,203 @@
+package webmention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+type (
+	// FileQueue is an in-process queue that keeps its mentions in a JSON
+	// file, which is rewritten on every change, so that mentions survive a
+	// restart or crash.
+	// Popped mentions stay in the file until they are acknowledged, those
+	// that were being processed when the process exited are delivered again
+	// after a restart.
+	// The file must not be shared by several receivers, use a PostgresQueue
+	// (or redis.Queue) for that.
+	FileQueue struct {
+		m       sync.Mutex
+		path    string
+		size    int
+		entries []fileQueueEntry
+		closed  bool
+		// ready is signaled when a mention is pushed, done closed when the queue is
+		ready chan struct{}
+		done  chan struct{}
+	}
+
+	fileQueueEntry struct {
+		Mention  Mention   `json:"mention"`
+		Enqueued time.Time `json:"enqueued"`
+		// Popped is set while the mention is being processed.
+		Popped bool `json:"popped,omitempty"`
+	}
+)
+
+// *FileQueue implements AckQueue and StatsQueue
+var (
+	_ AckQueue   = (*FileQueue)(nil)
+	_ StatsQueue = (*FileQueue)(nil)
+)
+
+// NewFileQueue opens the queue kept in the file at path, which is created
+// once the first mention is pushed.
+// It holds at most size mentions (including those being processed), zero
+// means no limit.
+func NewFileQueue(path string, size int) (*FileQueue, error) {
+	q := &FileQueue{
+		path:  path,
+		size:  size,
+		ready: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("file queue: %w", err)
+	}
+	if len(bs) > 0 {
+		if err := json.Unmarshal(bs, &q.entries); err != nil {
+			return nil, fmt.Errorf("file queue: %s: %w", path, err)
+		}
+	}
+	// the process that popped them is gone
+	for i := range q.entries {
+		q.entries[i].Popped = false
+	}
+	return q, nil
+}
+
+func (q *FileQueue) Push(mention Mention) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.size > 0 && len(q.entries) >= q.size {
+		return ErrQueueFull
+	}
+	entries := append(slices.Clip(q.entries), fileQueueEntry{Mention: mention, Enqueued: time.Now()})
+	if err := q.write(entries); err != nil {
+		return err
+	}
+	q.entries = entries
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pop returns the oldest mention not being processed yet.
+// Once the queue is closed, the remaining mentions are still returned,
+// those not popped before the process exits are kept for the next start.
+func (q *FileQueue) Pop(ctx context.Context) (Mention, error) {
+	for {
+		mention, ok, err := q.tryPop()
+		if ok || err != nil {
+			return mention, err
+		}
+		select {
+		case <-ctx.Done():
+			return Mention{}, ctx.Err()
+		case <-q.ready:
+		case <-q.done:
+		}
+	}
+}
+
+func (q *FileQueue) tryPop() (Mention, bool, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	i := slices.IndexFunc(q.entries, func(entry fileQueueEntry) bool {
+		return !entry.Popped
+	})
+	if i < 0 {
+		if q.closed {
+			return Mention{}, false, ErrQueueClosed
+		}
+		return Mention{}, false, nil
+	}
+	entries := slices.Clone(q.entries)
+	entries[i].Popped = true
+	if err := q.write(entries); err != nil {
+		return Mention{}, false, err
+	}
+	q.entries = entries
+	// let another popper know, if there are more mentions waiting
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return entries[i].Mention, true, nil
+}
+
+// Ack removes the mention from the file.
+func (q *FileQueue) Ack(mention Mention) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	i := slices.IndexFunc(q.entries, func(entry fileQueueEntry) bool {
+		return entry.Popped && entry.Mention.ID == mention.ID
+	})
+	if i < 0 {
+		return nil
+	}
+	entries := slices.Delete(slices.Clone(q.entries), i, i+1)
+	if err := q.write(entries); err != nil {
+		return err
+	}
+	q.entries = entries
+	return nil
+}
+
+// Stats counts all mentions in the file, including those being processed.
+func (q *FileQueue) Stats() (QueueStats, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	stats := QueueStats{Depth: len(q.entries), Capacity: q.size}
+	if len(q.entries) > 0 {
+		stats.Oldest = q.entries[0].Enqueued
+	}
+	return stats, nil
+}
+
+func (q *FileQueue) Close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+	return nil
+}
+
+// write replaces the file atomically.
+func (q *FileQueue) write(entries []fileQueueEntry) error {
+	bs, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("file queue: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("file queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return fmt.Errorf("file queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("file queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("file queue: %w", err)
+	}
+	return nil
+}
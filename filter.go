@@ -0,0 +1,65 @@
+package webmention
+
+import (
+	"fmt"
+	"slices"
+)
+
+type (
+	// A Filter decides whether a mention is processed at all.
+	// Filters run asynchronously (in ProcessMentions), before the source is fetched.
+	// Returning a non-nil error rejects the mention, the error is passed on to Report.
+	// Use Reject to create errors that are recognizable with errors.Is(err, ErrRejected).
+	Filter interface {
+		Filter(mention Mention) error
+	}
+
+	// FilterFunc adapts a function to an object that implements the Filter interface.
+	FilterFunc func(mention Mention) error
+)
+
+func (f FilterFunc) Filter(mention Mention) error {
+	return f(mention)
+}
+
+// WithFilter appends filters to the filter chain.
+// Filters are run in the order they were added, the first rejection wins.
+func WithFilter(filters ...Filter) ReceiverOption {
+	return func(r *Receiver) {
+		r.filters = append(r.filters, filters...)
+	}
+}
+
+// AddFilter appends filters to the filter chain while the receiver is running.
+func (receiver *Receiver) AddFilter(filters ...Filter) {
+	receiver.listenersMu.Lock()
+	defer receiver.listenersMu.Unlock()
+	receiver.filters = slices.Concat(receiver.filters, filters)
+}
+
+// RemoveFilter removes a filter from the filter chain while the receiver is
+// running, and reports whether it was part of the chain.
+// Like with RemoveNotifier, filters are compared with ==, a FilterFunc
+// cannot be removed.
+func (receiver *Receiver) RemoveFilter(filter Filter) bool {
+	receiver.listenersMu.Lock()
+	defer receiver.listenersMu.Unlock()
+	filters := slices.DeleteFunc(slices.Clone(receiver.filters), func(f Filter) bool {
+		return equalComparable(f, filter)
+	})
+	removed := len(filters) < len(receiver.filters)
+	receiver.filters = filters
+	return removed
+}
+
+// currentFilters returns a snapshot of the filter chain.
+func (receiver *Receiver) currentFilters() []Filter {
+	receiver.listenersMu.RLock()
+	defer receiver.listenersMu.RUnlock()
+	return receiver.filters
+}
+
+// Reject returns an error that wraps ErrRejected, with reason attached.
+func Reject(reason string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrRejected, fmt.Sprintf(reason, args...))
+}
@@ -0,0 +1,627 @@
+package webmention
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Routes selects which routes are served by the handler returned from NewReceiverHandler.
+	Routes uint16
+
+	HandlerOption func(*receiverHandler)
+
+	receiverHandler struct {
+		receiver   *Receiver
+		mountPoint string
+		// webmentionPath overrides where RouteWebmention is served, if set
+		webmentionPath string
+		routes         Routes
+		// served are the enabled routes, in the order of handlerRoutes
+		served    []handlerRoute
+		authorize func(r *http.Request) bool
+		// moderationLinks verifies the links of RouteModerationLinks
+		moderationLinks *ModerationLinks
+		mux             *http.ServeMux
+	}
+
+	// handlerRoute is a route served by the handler, and its description in
+	// the OpenAPI document (see RouteOpenAPI).
+	handlerRoute struct {
+		route   Routes
+		method  string
+		path    string
+		admin   bool
+		serve   func(h *receiverHandler, w http.ResponseWriter, r *http.Request)
+		summary string
+		params  []routeParam
+		// code is the status of a successful response, its body is a JSON
+		// encoded value of the type of body, or of the media type, if set.
+		code      int
+		body      any
+		mediaType string
+		// errors are the status codes of the failures the route reports
+		errors []int
+	}
+
+	routeParam struct {
+		name, in    string
+		description string
+		required    bool
+		enum        []string
+	}
+)
+
+var moderationLinkParams = []routeParam{
+	{name: "id", in: "path", required: true},
+	{name: "action", in: "path", required: true, enum: []string{string(ActionApprove), string(ActionReject)}},
+	{name: "expires", in: "query", required: true},
+	{name: "signature", in: "query", required: true},
+}
+
+// handlerRoutes are all the routes, in the order they are documented.
+var handlerRoutes = []handlerRoute{
+	{
+		route: RouteWebmention, method: http.MethodPost, path: "/webmention", serve: (*receiverHandler).webmention,
+		summary: "Submit a webmention, it is verified asynchronously",
+		code:    http.StatusAccepted, mediaType: "text/plain", errors: []int{http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusTooManyRequests},
+	},
+	{
+		route: RouteStatus, method: http.MethodGet, path: "/status/{id}", serve: (*receiverHandler).status,
+		summary: "Check on the processing status of a submission, including its history",
+		params:  []routeParam{{name: "id", in: "path", required: true, description: "id of the submission, see the Location header of the webmention response"}},
+		code:    http.StatusOK, body: MentionStatus{}, errors: []int{http.StatusNotFound},
+	},
+	{
+		route: RouteStatus, method: http.MethodGet, path: "/rejection", serve: (*receiverHandler).rejection,
+		summary: "Find out why a submission was rejected",
+		params: []routeParam{
+			{name: "source", in: "query", required: true},
+			{name: "target", in: "query", required: true},
+		},
+		code: http.StatusOK, body: Rejection{}, errors: []int{http.StatusBadRequest, http.StatusNotFound},
+	},
+	{
+		route: RouteMentions, method: http.MethodGet, path: "/mentions", admin: true, serve: (*receiverHandler).mentions,
+		summary: "List stored mentions, one page at a time, the next page is linked to by the Link header",
+		params: []routeParam{
+			{name: "since", in: "query", description: "RFC 3339 timestamp or date"},
+			{name: "until", in: "query", description: "RFC 3339 timestamp or date, exclusive"},
+			{name: "target", in: "query"},
+			{name: "type", in: "query", enum: []string{string(TypeLike), string(TypeReply), string(TypeRepost), string(TypeBookmark), string(TypeMention)}},
+			{name: "status", in: "query", enum: []string{"link", "no-link", "deleted", "unavailable"}},
+			{name: "source_domain", in: "query", description: "domain of the source, including its subdomains"},
+			{name: "order", in: "query", enum: []string{"oldest", "newest"}},
+			{name: "sort", in: "query", enum: []string{"received", "written"}, description: "time to order by, when the mention was received, or its source was written (published, or else updated)"},
+			{name: "limit", in: "query", description: "positive number"},
+			{name: "cursor", in: "query", description: "next of the previous page"},
+		},
+		code: http.StatusOK, body: MentionPage{}, errors: []int{http.StatusBadRequest, http.StatusNotImplemented},
+	},
+	{
+		route: RouteMentions, method: http.MethodGet, path: "/counts", admin: true, serve: (*receiverHandler).counts,
+		summary: "Count the stored mentions of a target by type",
+		params:  []routeParam{{name: "target", in: "query", required: true}},
+		code:    http.StatusOK, body: Counts{}, errors: []int{http.StatusBadRequest, http.StatusNotImplemented},
+	},
+	{
+		route: RouteMetrics, method: http.MethodGet, path: "/metrics", admin: true, serve: (*receiverHandler).metrics,
+		summary: "Metrics in the Prometheus text format",
+		code:    http.StatusOK, mediaType: "text/plain",
+	},
+	{
+		route: RouteWidget, method: http.MethodGet, path: "/widget.json", serve: (*receiverHandler).widgetJSON,
+		summary: "The mentions of a target for embedding in a page, as JSONP if a callback is given",
+		params: []routeParam{
+			{name: "target", in: "query", required: true},
+			{name: "limit", in: "query", description: "positive number"},
+			{name: "callback", in: "query", description: "name of the JSONP callback"},
+		},
+		code: http.StatusOK, body: WidgetData{}, errors: []int{http.StatusBadRequest, http.StatusNotImplemented},
+	},
+	{
+		route: RouteWidget, method: http.MethodGet, path: "/widget.js", serve: (*receiverHandler).widgetScript,
+		summary: "The script rendering the widget",
+		code:    http.StatusOK, mediaType: "text/javascript",
+	},
+	{
+		route: RouteAnalytics, method: http.MethodGet, path: "/analytics", serve: (*receiverHandler).analytics,
+		summary: "Aggregate stats of the mentions, for public stats pages, see AnalyticsPolicy",
+		params: []routeParam{
+			{name: "since", in: "query", description: "RFC 3339 timestamp or date"},
+			{name: "until", in: "query", description: "RFC 3339 timestamp or date, exclusive"},
+		},
+		code: http.StatusOK, body: AnalyticsReport{}, errors: []int{http.StatusBadRequest, http.StatusNotImplemented},
+	},
+	{
+		route: RouteDeadLetters, method: http.MethodGet, path: "/dead-letters", admin: true, serve: (*receiverHandler).deadLetters,
+		summary: "List mentions that failed processing",
+		code:    http.StatusOK, body: []DeadLetter{},
+	},
+	{
+		route: RouteDeadLetters, method: http.MethodPost, path: "/dead-letters/{id}/retry", admin: true, serve: (*receiverHandler).retryDeadLetter,
+		summary: "Retry a mention that failed processing",
+		params:  []routeParam{{name: "id", in: "path", required: true}},
+		code:    http.StatusNoContent, errors: []int{http.StatusNotFound},
+	},
+	{
+		route: RouteDeadLetters, method: http.MethodDelete, path: "/dead-letters/{id}", admin: true, serve: (*receiverHandler).discardDeadLetter,
+		summary: "Discard a mention that failed processing",
+		params:  []routeParam{{name: "id", in: "path", required: true}},
+		code:    http.StatusNoContent, errors: []int{http.StatusNotFound},
+	},
+	{
+		route: RouteQueue, method: http.MethodGet, path: "/queue", admin: true, serve: (*receiverHandler).queue,
+		summary: "How full the queue is, and how many mentions have been processed",
+		code:    http.StatusOK, body: QueueStats{},
+	},
+	{
+		route: RouteUsage, method: http.MethodGet, path: "/usage", admin: true, serve: (*receiverHandler).usage,
+		summary: "The usage of every accept-domain in a month",
+		params:  []routeParam{{name: "month", in: "query", description: "e.g., 2006-01, default the current month"}},
+		code:    http.StatusOK, body: []Usage{}, errors: []int{http.StatusBadRequest, http.StatusNotImplemented},
+	},
+	{
+		route: RouteModeration, method: http.MethodGet, path: "/moderation", admin: true, serve: (*receiverHandler).pending,
+		summary: "List mentions held for moderation",
+		code:    http.StatusOK, body: []Mention{},
+	},
+	{
+		route: RouteModeration, method: http.MethodPost, path: "/moderation/{id}/approve", admin: true, serve: (*receiverHandler).approve,
+		summary: "Approve a mention held for moderation, it is passed on to the notifiers",
+		params:  []routeParam{{name: "id", in: "path", required: true}},
+		code:    http.StatusNoContent, errors: []int{http.StatusNotFound},
+	},
+	{
+		route: RouteModeration, method: http.MethodPost, path: "/moderation/{id}/reject", admin: true, serve: (*receiverHandler).rejectPending,
+		summary: "Reject a mention held for moderation",
+		params:  []routeParam{{name: "id", in: "path", required: true}},
+		code:    http.StatusNoContent, errors: []int{http.StatusNotFound},
+	},
+	{
+		route: RouteModerationLinks, method: http.MethodGet, path: "/moderate/{id}/{action}", serve: (*receiverHandler).confirmModeration,
+		summary: "Confirm following a signed moderation link, see ModerationLinks",
+		params:  moderationLinkParams,
+		code:    http.StatusOK, mediaType: "text/html", errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusNotImplemented},
+	},
+	{
+		route: RouteModerationLinks, method: http.MethodPost, path: "/moderate/{id}/{action}", serve: (*receiverHandler).moderateByLink,
+		summary: "Approve or reject a mention held for moderation with a signed link",
+		params:  moderationLinkParams,
+		code:    http.StatusOK, mediaType: "text/plain", errors: []int{http.StatusForbidden, http.StatusNotFound, http.StatusNotImplemented},
+	},
+	{
+		route: RouteReady, method: http.MethodGet, path: "/readyz", serve: (*receiverHandler).ready,
+		summary: "Whether all notifiers passed their last probe",
+		code:    http.StatusOK, mediaType: "text/plain", errors: []int{http.StatusServiceUnavailable},
+	},
+	{
+		route: RouteOpenAPI, method: http.MethodGet, path: "/openapi.json", serve: (*receiverHandler).openAPI,
+		summary: "This document",
+		code:    http.StatusOK, mediaType: "application/json",
+	},
+}
+
+const (
+	// RouteWebmention is the webmention endpoint itself: /webmention
+	RouteWebmention Routes = 1 << iota
+	// RouteStatus lets senders check on their submissions, including their
+	// history: /status/{id}, and find out why a submission was rejected:
+	// /rejection?source=&target=
+	RouteStatus
+	// RouteMentions lists stored mentions, and counts them by type, requires a Storage:
+	// /mentions?since=&until=&target=&type=&status=&source_domain=&order=&sort=&limit=&cursor=
+	// and /counts?target=
+	RouteMentions
+	// RouteMetrics exposes metrics in the Prometheus format: /metrics
+	RouteMetrics
+	// RouteWidget serves the mentions of a target for embedding in a page,
+	// requires a Storage: /widget.json?target=&limit=&callback= and the
+	// script rendering it: /widget.js
+	RouteWidget
+	// RouteDeadLetters lists mentions that failed processing: GET /dead-letters,
+	// retries them: POST /dead-letters/{id}/retry, or discards them: DELETE /dead-letters/{id}
+	RouteDeadLetters
+	// RouteQueue reports how full the queue is, and how many mentions have
+	// been processed (see Receiver.QueueStats): /queue
+	RouteQueue
+	// RouteReady reports whether all notifiers passed their last probe (see
+	// Receiver.ProbeNotifiers), for readiness checks: /readyz
+	// It is not protected by WithAdminAuth.
+	RouteReady
+	// RouteUsage reports the usage of every accept-domain in a month,
+	// requires a UsageMeter: /usage?month=2006-01 (default the current month)
+	RouteUsage
+	// RouteOpenAPI describes the enabled routes in an OpenAPI 3 document,
+	// e.g., to generate clients for them: /openapi.json
+	// It is not protected by WithAdminAuth.
+	RouteOpenAPI
+	// RouteModeration lists mentions held for moderation: GET /moderation,
+	// approves them: POST /moderation/{id}/approve, or rejects them: POST
+	// /moderation/{id}/reject
+	RouteModeration
+	// RouteAnalytics serves aggregate stats of the mentions, that are safe
+	// to share publicly (see AnalyticsPolicy), requires a Storage:
+	// /analytics?since=&until=
+	RouteAnalytics
+	// RouteModerationLinks approves or rejects mentions held for
+	// moderation with links signed by ModerationLinks, e.g., from a mail,
+	// it requires WithModerationLinks and is not protected by WithAdminAuth:
+	// GET /moderate/{id}/{action}?expires=&signature= asks for
+	// confirmation, which POSTs to the same url
+	RouteModerationLinks
+
+	// DefaultRoutes are the routes that are safe to expose publicly.
+	DefaultRoutes = RouteWebmention | RouteStatus
+	AllRoutes     = RouteWebmention | RouteStatus | RouteMentions | RouteMetrics | RouteWidget | RouteDeadLetters | RouteQueue | RouteReady | RouteUsage | RouteOpenAPI | RouteModeration | RouteAnalytics | RouteModerationLinks
+)
+
+// NewReceiverHandler returns a http.Handler serving the receiver and its
+// accompanying routes (see Routes), all under a single mount point.
+// This makes it easy to embed the receiver into an existing application:
+//
+//	// net/http
+//	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver, webmention.WithMountPoint("/wm")))
+//
+//	// chi
+//	r.Handle("/wm/*", webmention.NewReceiverHandler(receiver, webmention.WithMountPoint("/wm")))
+//
+//	// echo
+//	e.Any("/wm/*", echo.WrapHandler(webmention.NewReceiverHandler(receiver, webmention.WithMountPoint("/wm"))))
+//
+// Accepted mentions are answered with a Location header pointing to their
+// status route, if it is enabled, and a Retry-After header telling when
+// they are expected to have been processed (see MentionStatus.ETA).
+func NewReceiverHandler(receiver *Receiver, opts ...HandlerOption) http.Handler {
+	handler := &receiverHandler{
+		receiver: receiver,
+		routes:   DefaultRoutes,
+		mux:      http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(handler)
+	}
+	for _, route := range handlerRoutes {
+		if handler.routes&route.route == 0 {
+			continue
+		}
+		handler.served = append(handler.served, route)
+		pattern := route.method + " " + handler.path(route)
+		if route.route == RouteWebmention {
+			pattern = handler.path(route) // the receiver answers other methods itself
+		}
+		serve := func(w http.ResponseWriter, r *http.Request) {
+			route.serve(handler, w, r)
+		}
+		if route.admin {
+			serve = handler.admin(serve)
+		}
+		handler.mux.HandleFunc(pattern, serve)
+	}
+	return handler.mux
+}
+
+// WithMountPoint sets the path prefix under which the routes are served, e.g., /wm
+// The prefix is not stripped from requests, it must still be present when
+// they reach the handler.
+func WithMountPoint(prefix string) HandlerOption {
+	return func(h *receiverHandler) {
+		h.mountPoint = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+// WithWebmentionPath serves the webmention endpoint at path, instead of
+// under the mount point, e.g., to keep the url senders already know.
+func WithWebmentionPath(path string) HandlerOption {
+	return func(h *receiverHandler) {
+		h.webmentionPath = path
+	}
+}
+
+// WithRoutes enables exactly the given routes (default DefaultRoutes).
+func WithRoutes(routes Routes) HandlerOption {
+	return func(h *receiverHandler) {
+		h.routes = routes
+	}
+}
+
+// WithAdminAuth protects the mentions, metrics, dead letters, queue, usage, and moderation routes.
+// Requests for which authorize returns false are answered with http.StatusUnauthorized.
+// Without this option, these routes are accessible to anyone (if enabled).
+func WithAdminAuth(authorize func(r *http.Request) bool) HandlerOption {
+	return func(h *receiverHandler) {
+		h.authorize = authorize
+	}
+}
+
+// path returns where route is served.
+func (h *receiverHandler) path(route handlerRoute) string {
+	if route.route == RouteWebmention && h.webmentionPath != "" {
+		return h.webmentionPath
+	}
+	return h.mountPoint + route.path
+}
+
+func (h *receiverHandler) webmention(w http.ResponseWriter, r *http.Request) {
+	var links endpointLinks
+	if h.routes&RouteStatus != 0 {
+		links.statusURL = func(id string) string {
+			return h.mountPoint + "/status/" + id
+		}
+	}
+	if h.routes&RouteOpenAPI != 0 {
+		links.openAPI = h.mountPoint + "/openapi.json"
+	}
+	h.receiver.serve(w, r, links)
+}
+
+func (h *receiverHandler) status(w http.ResponseWriter, r *http.Request) {
+	status, err := h.receiver.MentionStatus(r.PathValue("id"))
+	if err != nil {
+		if errors.Is(err, ErrUnknownMention) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status)
+}
+
+// statusNames are the short names for statuses accepted by the mentions route.
+var statusNames = map[string]Status{
+	"link":        StatusLink,
+	"no-link":     StatusNoLink,
+	"deleted":     StatusDeleted,
+	"unavailable": StatusUnavailable,
+}
+
+// parseTimeParam parses a RFC 3339 timestamp or a date.
+func parseTimeParam(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.Parse(time.DateOnly, value)
+	}
+	return t, err
+}
+
+func (h *receiverHandler) mentions(w http.ResponseWriter, r *http.Request) {
+	var query MentionQuery
+	params := r.URL.Query()
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &query.Filter.Since}, {"until", &query.Filter.Until}} {
+		value := params.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := parseTimeParam(value)
+		if err != nil {
+			http.Error(w, param.name+": expected RFC 3339 timestamp or date", http.StatusBadRequest)
+			return
+		}
+		*param.t = t
+	}
+	query.Filter.Target = params.Get("target")
+	query.Filter.Type = MentionType(params.Get("type"))
+	query.Filter.SourceDomain = params.Get("source_domain")
+	if status := params.Get("status"); status != "" {
+		var ok bool
+		if query.Filter.Status, ok = statusNames[status]; !ok {
+			http.Error(w, "status: expected one of link, no-link, deleted, unavailable", http.StatusBadRequest)
+			return
+		}
+	}
+	switch params.Get("order") {
+	case "", "oldest":
+	case "newest":
+		query.Newest = true
+	default:
+		http.Error(w, "order: expected oldest or newest", http.StatusBadRequest)
+		return
+	}
+	switch params.Get("sort") {
+	case "", "received":
+	case "written":
+		query.ByWritten = true
+	default:
+		http.Error(w, "sort: expected received or written", http.StatusBadRequest)
+		return
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit: expected a positive number", http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+	query.Cursor = params.Get("cursor")
+
+	page, err := h.receiver.QueryMentions(query)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoStorage):
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+		case errors.Is(err, ErrInvalidCursor):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			slog.Error(err.Error(), "path", r.URL.EscapedPath())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if page.Next != "" {
+		next := *r.URL
+		params.Set("cursor", page.Next)
+		next.RawQuery = params.Encode()
+		w.Header().Set("Link", "<"+next.RequestURI()+`>; rel="next"`)
+	}
+	writeJSON(w, page)
+}
+
+func (h *receiverHandler) counts(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target: required", http.StatusBadRequest)
+		return
+	}
+	counts, err := h.receiver.Counts(target)
+	if err != nil {
+		if errors.Is(err, ErrNoStorage) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, counts)
+}
+
+func (h *receiverHandler) metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := h.receiver.WriteMetrics(w); err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+	}
+}
+
+func (h *receiverHandler) queue(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.receiver.QueueStats()
+	if err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+func (h *receiverHandler) usage(w http.ResponseWriter, r *http.Request) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		month = UsageMonth(time.Now())
+	} else if _, err := time.Parse("2006-01", month); err != nil {
+		http.Error(w, "month: expected a month, e.g., 2006-01", http.StatusBadRequest)
+		return
+	}
+	usage, err := h.receiver.Usage(month)
+	if err != nil {
+		if errors.Is(err, ErrNoUsageMeter) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, usage)
+}
+
+func (h *receiverHandler) deadLetters(w http.ResponseWriter, r *http.Request) {
+	letters, err := h.receiver.DeadLetters()
+	if err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if letters == nil {
+		letters = []DeadLetter{}
+	}
+	writeJSON(w, letters)
+}
+
+func (h *receiverHandler) retryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	h.deadLetterResult(w, r, h.receiver.RetryDeadLetter(r.PathValue("id")))
+}
+
+func (h *receiverHandler) discardDeadLetter(w http.ResponseWriter, r *http.Request) {
+	h.deadLetterResult(w, r, h.receiver.DiscardDeadLetter(r.PathValue("id")))
+}
+
+func (h *receiverHandler) deadLetterResult(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrNoDeadLetter):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *receiverHandler) pending(w http.ResponseWriter, r *http.Request) {
+	pending, err := h.receiver.Pending()
+	if err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if pending == nil {
+		pending = []Mention{}
+	}
+	writeJSON(w, pending)
+}
+
+func (h *receiverHandler) approve(w http.ResponseWriter, r *http.Request) {
+	h.moderationResult(w, r, h.receiver.Approve(r.PathValue("id")))
+}
+
+func (h *receiverHandler) rejectPending(w http.ResponseWriter, r *http.Request) {
+	h.moderationResult(w, r, h.receiver.Reject(r.PathValue("id")))
+}
+
+func (h *receiverHandler) moderationResult(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrNotPending):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *receiverHandler) rejection(w http.ResponseWriter, r *http.Request) {
+	source, target := r.URL.Query().Get("source"), r.URL.Query().Get("target")
+	if source == "" || target == "" {
+		http.Error(w, "source and target: required", http.StatusBadRequest)
+		return
+	}
+	rejection, err := h.receiver.Rejection(source, target)
+	if err != nil {
+		if errors.Is(err, ErrNoRejection) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, rejection)
+}
+
+func (h *receiverHandler) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.authorize != nil && !h.authorize(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error(err.Error())
+	}
+}
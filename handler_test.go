@@ -0,0 +1,606 @@
+package webmention_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestReceiverHandler(t *testing.T) {
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithStorage(storage),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver,
+		webmention.WithMountPoint("/wm"),
+		webmention.WithRoutes(webmention.AllRoutes),
+		webmention.WithAdminAuth(func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer secret"
+		}),
+	))
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<a href="http://`+r.Host+`/target">target</a>`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp := must(http.PostForm(ts.URL+"/wm/webmention", url.Values{
+		"source": {ts.URL + "/source"},
+		"target": {ts.URL + "/target"},
+	}))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+	}
+	location := resp.Header.Get("Location")
+	if !strings.HasPrefix(location, "/wm/status/") {
+		t.Fatalf("incorrect location: %q", location)
+	}
+
+	var status webmention.MentionStatus
+	for deadline := time.Now().Add(5 * time.Second); status.State != webmention.StateProcessed; {
+		if time.Now().After(deadline) {
+			t.Fatalf("mention not processed in time, last status: %+v", status)
+		}
+		resp := must(http.Get(ts.URL + location))
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Status != webmention.StatusLink {
+		t.Errorf("incorrect mention status, got: %s, want: %s", status.Status, webmention.StatusLink)
+	}
+
+	if resp := must(http.Get(ts.URL + "/wm/status/unknown")); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown mention: incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusNotFound)
+	}
+	if resp := must(http.Get(ts.URL + "/wm/mentions")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("mentions without authorization: incorrect status code, got: %d", resp.StatusCode)
+	}
+
+	admin := func(path string) string {
+		req := must(http.NewRequest(http.MethodGet, ts.URL+path, nil))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp := must(http.DefaultClient.Do(req))
+		defer resp.Body.Close()
+		return string(must(io.ReadAll(resp.Body)))
+	}
+	var page webmention.MentionPage
+	if err := json.Unmarshal([]byte(admin("/wm/mentions?target="+url.QueryEscape(ts.URL+"/target"))), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Mentions) != 1 || page.Mentions[0].Source.String() != ts.URL+"/source" {
+		t.Errorf("incorrect mentions: %v", page.Mentions)
+	}
+	metrics := admin("/wm/metrics")
+	for _, line := range []string{
+		`webmention_requests_total{code="202"} 1`,
+		`webmention_mentions_total{state="processed"} 1`,
+		`webmention_media_handler_calls_total{type="text/html",result="link"} 1`,
+		`webmention_media_handler_duration_seconds_count{type="text/html"} 1`,
+		`webmention_build_info{version="` + webmention.Version() + `"`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("metrics missing %q:\n%s", line, metrics)
+		}
+	}
+	stats := receiver.MediaHandlerStats()
+	if len(stats) != 1 || stats[0].MediaType != "text/html" || stats[0].Links != 1 || stats[0].Bytes == 0 {
+		t.Errorf("incorrect media handler stats: %+v", stats)
+	}
+	var queue webmention.QueueStats
+	if err := json.Unmarshal([]byte(admin("/wm/queue")), &queue); err != nil {
+		t.Fatal(err)
+	}
+	if queue.Depth != 0 || queue.Capacity == 0 || !queue.Oldest.IsZero() || queue.Processed[webmention.StateProcessed] != 1 {
+		t.Errorf("incorrect queue stats: %+v", queue)
+	}
+}
+
+func TestProcessingETA(t *testing.T) {
+	receiver := webmention.NewReceiver(webmention.WithAcceptsFunc(accepts))
+	defer receiver.Shutdown(context.Background())
+	mux := http.NewServeMux()
+	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver, webmention.WithMountPoint("/wm")))
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="http://%[1]s/target/1">1</a> <a href="http://%[1]s/target/2">2</a>`, r.Host)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	status := func(location string) (status webmention.MentionStatus) {
+		resp := must(http.Get(ts.URL + location))
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	// no worker is running yet, the mentions wait in the queue
+	var locations []string
+	for i, want := range []string{"1", "2"} {
+		resp := must(http.PostForm(ts.URL+"/wm/webmention", url.Values{
+			"source": {ts.URL + "/source"},
+			"target": {fmt.Sprintf("%s/target/%d", ts.URL, i+1)},
+		}))
+		body := string(must(io.ReadAll(resp.Body)))
+		resp.Body.Close()
+		if got := resp.Header.Get("Retry-After"); got != want {
+			t.Errorf("mention %d: incorrect Retry-After, got: %q, want: %q", i+1, got, want)
+		}
+		if !strings.Contains(body, "within about "+want+"s") {
+			t.Errorf("mention %d: body lacks the estimate: %q", i+1, body)
+		}
+		locations = append(locations, resp.Header.Get("Location"))
+	}
+	first, second := status(locations[0]), status(locations[1])
+	if first.State != webmention.StateQueued || first.ETA == nil || second.ETA == nil || !second.ETA.After(*first.ETA) {
+		t.Errorf("incorrect estimates: %+v, %+v", first, second)
+	}
+
+	go receiver.ProcessMentions()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("mention not processed in time")
+		}
+		if processed := status(locations[1]); processed.State == webmention.StateProcessed {
+			if processed.ETA != nil {
+				t.Errorf("processed mention has an estimate: %+v", processed)
+			}
+			break
+		}
+	}
+}
+
+func TestWidget(t *testing.T) {
+	storage := webmention.NewMemoryStorage()
+	target := "https://example.org/post"
+	for i, typ := range []webmention.MentionType{webmention.TypeLike, webmention.TypeReply} {
+		storage.Store(webmention.Mention{
+			Source:   must(url.Parse("https://example.com/" + string(typ))),
+			Target:   must(url.Parse(target)),
+			Status:   webmention.StatusLink,
+			Type:     typ,
+			Received: time.Now().Add(time.Duration(i) * time.Minute),
+		})
+	}
+	receiver := webmention.NewReceiver(webmention.WithStorage(storage))
+	ts := httptest.NewServer(webmention.NewReceiverHandler(receiver, webmention.WithRoutes(webmention.RouteWidget)))
+	defer ts.Close()
+
+	resp := must(http.Get(ts.URL + "/widget.json?target=" + url.QueryEscape(target)))
+	var data webmention.WidgetData
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if data.Counts.Total != 2 || len(data.Mentions) != 2 || data.Mentions[0].Type != webmention.TypeReply {
+		t.Errorf("incorrect widget data: %+v", data)
+	}
+	if resp.Header.Get("Access-Control-Allow-Origin") != "*" || resp.Header.Get("Cache-Control") == "" {
+		t.Errorf("widget data not embeddable or cacheable: %v", resp.Header)
+	}
+
+	resp = must(http.Get(ts.URL + "/widget.json?callback=show&target=" + url.QueryEscape(target)))
+	body := string(must(io.ReadAll(resp.Body)))
+	resp.Body.Close()
+	if !strings.HasPrefix(body, "/**/show({") || !strings.HasSuffix(body, ");") {
+		t.Errorf("incorrect jsonp response: %s", body)
+	}
+	if resp := must(http.Get(ts.URL + "/widget.json?callback=alert(1)//&target=x")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid callback accepted: %d", resp.StatusCode)
+	}
+	if resp := must(http.Get(ts.URL + "/widget.js")); !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/javascript") {
+		t.Errorf("incorrect script content type: %s", resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestAnalytics(t *testing.T) {
+	storage := webmention.NewMemoryStorage()
+	day := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := func(source, target string, typ webmention.MentionType, received time.Time) {
+		storage.Store(webmention.Mention{
+			Source:   must(url.Parse(source)),
+			Target:   must(url.Parse(target)),
+			Status:   webmention.StatusLink,
+			Type:     typ,
+			Received: received,
+		})
+	}
+	for i := range 3 {
+		store(fmt.Sprintf("https://blog.popular.example/%d", i), "https://example.org/popular", webmention.TypeLike, day)
+	}
+	store("https://commenter.example/reply", "https://example.org/quiet", webmention.TypeReply, day.AddDate(0, 0, 1))
+	store("https://old.example/post", "https://example.org/popular", "", day.AddDate(0, 0, -7))
+	storage.Store(webmention.Mention{
+		Source: must(url.Parse("https://gone.example/post")),
+		Target: must(url.Parse("https://example.org/quiet")),
+		Status: webmention.StatusDeleted,
+	})
+	receiver := webmention.NewReceiver(
+		webmention.WithStorage(storage),
+		webmention.WithAnalyticsPolicy(webmention.AnalyticsPolicy{Threshold: 3}),
+	)
+	ts := httptest.NewServer(webmention.NewReceiverHandler(receiver, webmention.WithRoutes(webmention.RouteAnalytics)))
+	defer ts.Close()
+
+	resp := must(http.Get(ts.URL + "/analytics?since=2024-05-01"))
+	body := must(io.ReadAll(resp.Body))
+	resp.Body.Close()
+	var report webmention.AnalyticsReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 4 || report.Types[webmention.TypeLike] != 3 || report.Types[webmention.TypeReply] != 1 {
+		t.Errorf("incorrect counts: %+v", report)
+	}
+	if len(report.Days) != 2 || report.Days[0].Day != "2024-05-01" || report.Days[0].Count != 3 || report.Days[1].Types[webmention.TypeReply] != 1 {
+		t.Errorf("incorrect days: %+v", report.Days)
+	}
+	if len(report.Domains) != 1 || report.Domains[0] != (webmention.NamedCount{Name: "popular.example", Count: 3}) || report.OtherDomains != 1 {
+		t.Errorf("incorrect domains: %+v, other: %d", report.Domains, report.OtherDomains)
+	}
+	if len(report.Targets) != 1 || report.Targets[0].Name != "https://example.org/popular" || report.OtherTargets != 1 {
+		t.Errorf("incorrect targets: %+v, other: %d", report.Targets, report.OtherTargets)
+	}
+	for _, private := range []string{"commenter.example", "example.org/quiet", "old.example", "gone.example"} {
+		if strings.Contains(string(body), private) {
+			t.Errorf("report reveals %s: %s", private, body)
+		}
+	}
+	if resp := must(http.Get(ts.URL + "/analytics?until=yesterday")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid until accepted: %d", resp.StatusCode)
+	}
+
+	noised := webmention.AnalyticsPolicy{Threshold: 3, Epsilon: 0.5}.Report(must(storage.Mentions(webmention.MentionFilter{})))
+	if noised.Total < 0 || noised.OtherDomains < 0 {
+		t.Errorf("negative noised counts: %+v", noised)
+	}
+
+	noisy := httptest.NewServer(webmention.NewReceiverHandler(webmention.NewReceiver(
+		webmention.WithStorage(storage),
+		webmention.WithAnalyticsPolicy(webmention.AnalyticsPolicy{Threshold: 3, Epsilon: 0.1}),
+	), webmention.WithRoutes(webmention.RouteAnalytics)))
+	defer noisy.Close()
+	get := func(query string) string {
+		resp := must(http.Get(noisy.URL + "/analytics?" + query))
+		defer resp.Body.Close()
+		return string(must(io.ReadAll(resp.Body)))
+	}
+	first := get("since=2024-05-01&until=2024-05-03")
+	for _, query := range []string{"since=2024-05-01&until=2024-05-03", "since=2024-05-01T13:00:00Z&until=2024-05-02T08:00:00Z"} {
+		if report := get(query); report != first {
+			t.Errorf("%s: different noise for the same window:\n%s\n%s", query, first, report)
+		}
+	}
+	var snapped webmention.AnalyticsReport
+	if err := json.Unmarshal([]byte(first), &snapped); err != nil {
+		t.Fatal(err)
+	}
+	if !snapped.Since.Equal(day.Truncate(24*time.Hour)) || !snapped.Until.Equal(day.Truncate(24*time.Hour).AddDate(0, 0, 2)) {
+		t.Errorf("window not snapped to days: %s - %s", snapped.Since, snapped.Until)
+	}
+}
+
+func TestModerationLinks(t *testing.T) {
+	queue := &webmention.MemoryModerationQueue{}
+	for _, id := range []string{"held-1", "held-2"} {
+		queue.Hold(webmention.Mention{
+			ID:     id,
+			Source: must(url.Parse("https://example.com/" + id)),
+			Target: must(url.Parse("https://example.org/post")),
+			Status: webmention.StatusLink,
+		})
+	}
+	notified := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithModeration(queue),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	links := webmention.ModerationLinks{BaseURL: ts.URL + "/wm", Secret: []byte("secret")}
+	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver,
+		webmention.WithMountPoint("/wm"),
+		webmention.WithRoutes(webmention.RouteModerationLinks),
+		webmention.WithModerationLinks(links),
+		webmention.WithAdminAuth(func(r *http.Request) bool { return false }),
+	))
+
+	approve := links.Link("held-1", webmention.ActionApprove)
+	resp := must(http.Get(approve))
+	body := string(must(io.ReadAll(resp.Body)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "https://example.com/held-1") || !strings.Contains(body, `method="post"`) {
+		t.Errorf("incorrect confirmation page, status: %d, body: %s", resp.StatusCode, body)
+	}
+	if pending := must(receiver.Pending()); len(pending) != 2 {
+		t.Fatalf("following a link moderated without confirmation: %v", pending)
+	}
+
+	forged := webmention.ModerationLinks{BaseURL: links.BaseURL, Secret: []byte("guessed")}
+	expired := webmention.ModerationLinks{BaseURL: links.BaseURL, Secret: links.Secret, Expiry: time.Nanosecond}
+	for name, link := range map[string]string{
+		"forged":       forged.Link("held-1", webmention.ActionApprove),
+		"other action": strings.Replace(approve, "/approve?", "/reject?", 1),
+		"other id":     strings.Replace(approve, "/held-1/", "/held-2/", 1),
+		"expired":      expired.Link("held-1", webmention.ActionApprove),
+	} {
+		if resp := must(http.Post(link, "", nil)); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: link accepted: %d", name, resp.StatusCode)
+		}
+	}
+
+	resp = must(http.Post(approve, "application/x-www-form-urlencoded", nil))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("approving failed: %d", resp.StatusCode)
+	}
+	select {
+	case mention := <-notified:
+		if mention.ID != "held-1" {
+			t.Errorf("incorrect mention approved: %s", mention.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if resp := must(http.Post(approve, "", nil)); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("approved twice: %d", resp.StatusCode)
+	}
+	if resp := must(http.Post(links.Link("held-2", webmention.ActionReject), "", nil)); resp.StatusCode != http.StatusOK {
+		t.Errorf("rejecting failed: %d", resp.StatusCode)
+	}
+	if pending := must(receiver.Pending()); len(pending) != 0 {
+		t.Errorf("mentions still pending: %v", pending)
+	}
+
+	unconfigured := httptest.NewServer(webmention.NewReceiverHandler(receiver, webmention.WithRoutes(webmention.RouteModerationLinks)))
+	defer unconfigured.Close()
+	if resp := must(http.Get(unconfigured.URL + "/moderate/held-1/approve")); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("unconfigured links served: %d", resp.StatusCode)
+	}
+}
+
+func TestRejections(t *testing.T) {
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithFilter(webmention.FilterFunc(func(mention webmention.Mention) error {
+			if strings.HasSuffix(mention.Source.Path, "/spam") {
+				return webmention.Reject("source looks like spam")
+			}
+			return nil
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver, webmention.WithMountPoint("/wm")))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	rejection := func(source, target string) (r webmention.Rejection, code int) {
+		resp := must(http.Get(ts.URL + "/wm/rejection?" + url.Values{"source": {source}, "target": {target}}.Encode()))
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r, resp.StatusCode
+	}
+
+	// rejected right away
+	closed := ts.URL + "/target/4"
+	resp := must(http.PostForm(ts.URL+"/wm/webmention", url.Values{"source": {"https://example.com/post"}, "target": {closed}}))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	if r, code := rejection("https://example.com/post", closed); code != http.StatusOK || r.Code != http.StatusBadRequest || !strings.Contains(r.Reason, "does not accept") {
+		t.Errorf("synchronous rejection not recorded: %d %+v", code, r)
+	}
+
+	// rejected while processing
+	resp = must(http.PostForm(ts.URL+"/wm/webmention", url.Values{"source": {"https://example.com/spam"}, "target": {ts.URL + "/target"}}))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+	}
+	location := resp.Header.Get("Location")
+	var status webmention.MentionStatus
+	for deadline := time.Now().Add(5 * time.Second); status.State != webmention.StateRejected; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("mention not rejected in time, last status: %+v", status)
+		}
+		resp := must(http.Get(ts.URL + location))
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if !strings.Contains(status.Reason, "spam") {
+		t.Errorf("rejection reason missing from status: %+v", status)
+	}
+	if r, code := rejection("https://example.com/spam", ts.URL+"/target"); code != http.StatusOK || r.ID != status.ID {
+		t.Errorf("asynchronous rejection not recorded: %d %+v", code, r)
+	}
+
+	if _, code := rejection("https://example.com/other", closed); code != http.StatusNotFound {
+		t.Errorf("unknown rejection: incorrect status code, got: %d, want: %d", code, http.StatusNotFound)
+	}
+}
+
+func TestProbeNotifiers(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed) // not failing, just picky
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	receiver := webmention.NewReceiver(
+		webmention.WithRelay(must(url.Parse(up.URL))),
+		webmention.WithNotifier(webmention.Named("backup", webmention.NewRelay(must(url.Parse(down.URL))))),
+	)
+	ts := httptest.NewServer(webmention.NewReceiverHandler(receiver, webmention.WithRoutes(webmention.RouteReady)))
+	defer ts.Close()
+
+	if resp := must(http.Get(ts.URL + "/readyz")); resp.StatusCode != http.StatusOK {
+		t.Errorf("not ready before probing: %d", resp.StatusCode)
+	}
+	failures := receiver.ProbeNotifiers(context.Background())
+	if len(failures) != 1 || failures["backup"] == nil {
+		t.Fatalf("incorrect probe failures: %v", failures)
+	}
+	if err := receiver.Ready(); err == nil || !strings.Contains(err.Error(), "backup") {
+		t.Errorf("failure not reported: %v", err)
+	}
+	resp := must(http.Get(ts.URL + "/readyz"))
+	body := string(must(io.ReadAll(resp.Body)))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "backup") || strings.Contains(body, up.URL) {
+		t.Errorf("incorrect readiness: %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	receiver := webmention.NewReceiver(webmention.WithAcceptsFunc(accepts))
+	mux := http.NewServeMux()
+	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver,
+		webmention.WithMountPoint("/wm"),
+		webmention.WithRoutes(webmention.AllRoutes),
+		webmention.WithAdminAuth(func(r *http.Request) bool { return false }),
+	))
+	mux.Handle("/public/", webmention.NewReceiverHandler(receiver,
+		webmention.WithMountPoint("/public"),
+		webmention.WithRoutes(webmention.DefaultRoutes|webmention.RouteOpenAPI),
+	))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var document struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			}
+		} `json:"components"`
+	}
+	get := func(path string) {
+		resp := must(http.Get(ts.URL + path))
+		defer resp.Body.Close()
+		if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get("/wm/openapi.json")
+	if !strings.HasPrefix(document.OpenAPI, "3.") {
+		t.Errorf("not an OpenAPI 3 document: %q", document.OpenAPI)
+	}
+	for path, operations := range document.Paths {
+		for method := range operations {
+			// every documented route is served, even if unauthorized, or with nonsense parameters
+			req := must(http.NewRequest(strings.ToUpper(method), ts.URL+strings.ReplaceAll(path, "{id}", "x"), nil))
+			resp := must(http.DefaultClient.Do(req))
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusNotFound && !strings.Contains(path, "{id}") || resp.StatusCode == http.StatusMethodNotAllowed {
+				t.Errorf("%s %s: documented, but not served: %d", method, path, resp.StatusCode)
+			}
+		}
+	}
+	for _, path := range []string{"/wm/webmention", "/wm/mentions", "/wm/dead-letters/{id}/retry", "/wm/usage", "/wm/openapi.json"} {
+		if _, ok := document.Paths[path]; !ok {
+			t.Errorf("%s not documented", path)
+		}
+	}
+	if _, ok := document.Components.Schemas["Mention"].Properties["source"]; !ok {
+		t.Errorf("schema of mentions not derived from their encoding: %+v", document.Components.Schemas["Mention"])
+	}
+
+	document.Paths = nil
+	get("/public/openapi.json")
+	if len(document.Paths) != 4 {
+		t.Errorf("documents other than the enabled routes: %v", slices.Collect(maps.Keys(document.Paths)))
+	}
+}
+
+func TestSelfDescription(t *testing.T) {
+	plain := webmention.NewReceiver(webmention.WithAcceptsFunc(accepts))
+	described := webmention.NewReceiver(webmention.WithAcceptsFunc(accepts), webmention.WithSelfDescription(), webmention.WithMaxBodySize(1<<10))
+	mux := http.NewServeMux()
+	mux.Handle("/plain", plain)
+	mux.Handle("/wm/", webmention.NewReceiverHandler(described,
+		webmention.WithMountPoint("/wm"),
+		webmention.WithRoutes(webmention.DefaultRoutes|webmention.RouteOpenAPI),
+	))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	if resp := must(http.Get(ts.URL + "/plain")); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("described without being asked to, status: %d", resp.StatusCode)
+	}
+
+	req := must(http.NewRequest(http.MethodGet, ts.URL+"/wm/webmention", nil))
+	req.Header.Set("Accept", "application/json")
+	resp := must(http.DefaultClient.Do(req))
+	var description webmention.EndpointDescription
+	if err := json.NewDecoder(resp.Body).Decode(&description); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if description.Status != "/wm/status/{id}" || description.OpenAPI != "/wm/openapi.json" || description.Endpoint != ts.URL+"/wm/webmention" {
+		t.Errorf("incorrect links: %+v", description)
+	}
+	if len(description.Parameters) != 2 || description.Policy.MaxBodySize != 1<<10 || !slices.Contains(description.Policy.SourceTypes, "text/html") {
+		t.Errorf("incorrect description: %+v", description)
+	}
+
+	resp = must(http.Get(ts.URL + "/wm/webmention"))
+	body := string(must(io.ReadAll(resp.Body)))
+	resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(body, "/wm/status/{id}") {
+		t.Errorf("incorrect html description: %s", body)
+	}
+	resp = must(http.Head(ts.URL + "/wm/webmention"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("incorrect status for head request: %d", resp.StatusCode)
+	}
+
+	resp = must(http.PostForm(ts.URL+"/wm/webmention", url.Values{"source": {"https://example.com/post"}, "target": {ts.URL + "/page"}}))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("mention not accepted, status: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,376 @@
+package webmention
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// HTTPCache caches the responses to outbound GET and HEAD requests
+	// (fetching sources, discovering endpoints), honoring their
+	// Cache-Control, Expires, ETag, and Last-Modified headers (RFC 9111), so
+	// that a site that mentions often isn't fetched over and over again.
+	// It is a shared cache: responses marked private, and requests with
+	// credentials, are never stored.
+	// Stale responses are revalidated with a conditional request, if they
+	// have an ETag or Last-Modified date.
+	// Responses served by the cache carry a Cache-Status header (RFC 9211).
+	// Share a cache between a sender and a receiver with WithHTTPCache and
+	// WithFetchCache.
+	HTTPCache struct {
+		entries KeyValueStore
+		// maxBodySize limits the size of stored responses, larger ones are passed through
+		maxBodySize int64
+	}
+
+	// cachedResponse is a response as stored by the HTTPCache.
+	cachedResponse struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header"`
+		Body   []byte      `json:"body,omitempty"`
+		// Stored is when the response was received, or last revalidated.
+		Stored time.Time `json:"stored"`
+		// Vary are the values of the request headers named by the Vary header.
+		Vary map[string]string `json:"vary,omitempty"`
+	}
+
+	cacheTransport struct {
+		cache *HTTPCache
+		next  http.RoundTripper
+	}
+
+	cacheControl map[string]string
+)
+
+const (
+	// defaultMaxCachedBody is the size limit of responses stored by an HTTPCache.
+	defaultMaxCachedBody = 1 << 20
+	// staleRetention is how long stale responses with validators are kept
+	// to be revalidated.
+	staleRetention = 24 * time.Hour
+	// cacheStatusName identifies the cache in the Cache-Status header.
+	cacheStatusName = "gowebmention"
+)
+
+// cacheableStatus are the status codes whose responses may be stored (RFC 9110, section 15.1).
+var cacheableStatus = map[int]bool{
+	http.StatusOK: true, http.StatusNonAuthoritativeInfo: true, http.StatusNoContent: true,
+	http.StatusMultipleChoices: true, http.StatusMovedPermanently: true, http.StatusPermanentRedirect: true,
+	http.StatusNotFound: true, http.StatusMethodNotAllowed: true, http.StatusGone: true,
+	http.StatusRequestURITooLong: true, http.StatusNotImplemented: true,
+}
+
+// NewHTTPCache creates a cache keeping its responses in store, e.g., a
+// MemoryKeyValueStore, or a FileKeyValueStore to keep them on disk.
+func NewHTTPCache(store KeyValueStore) *HTTPCache {
+	return &HTTPCache{entries: store, maxBodySize: defaultMaxCachedBody}
+}
+
+// WithHTTPCache caches the responses to the requests of the sender (see HTTPCache).
+func WithHTTPCache(cache *HTTPCache) SenderOption {
+	return func(s *Sender) {
+		s.httpCache = cache
+	}
+}
+
+// WithFetchCache caches the responses to the requests made to fetch
+// sources (see HTTPCache).
+func WithFetchCache(cache *HTTPCache) ReceiverOption {
+	return func(r *Receiver) {
+		r.httpCache = cache
+	}
+}
+
+// Client returns a copy of base whose requests go through the cache.
+func (cache *HTTPCache) Client(base *http.Client) *http.Client {
+	client := *base
+	next := base.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	client.Transport = cacheTransport{cache, next}
+	return &client
+}
+
+func (t cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		resp, err := t.next.RoundTrip(req)
+		if err == nil && resp.StatusCode < 400 {
+			t.cache.invalidate(req) // the request may have changed the resource
+		}
+		return resp, err
+	}
+	requestCC := parseCacheControl(req.Header)
+	if _, noStore := requestCC["no-store"]; noStore || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+	stored, ok := t.cache.lookup(req)
+	_, noCache := requestCC["no-cache"]
+	if ok && !noCache && stored.fresh(time.Now()) {
+		return stored.response(req, "hit"), nil
+	}
+	forward := req
+	if ok && stored.hasValidators() && !isConditional(req) {
+		forward = req.Clone(req.Context())
+		if etag := stored.Header.Get("ETag"); etag != "" {
+			forward.Header.Set("If-None-Match", etag)
+		}
+		if modified := stored.Header.Get("Last-Modified"); modified != "" {
+			forward.Header.Set("If-Modified-Since", modified)
+		}
+	}
+	resp, err := t.next.RoundTrip(forward)
+	if err != nil {
+		return nil, err
+	}
+	if forward != req && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		stored.revalidated(resp)
+		t.cache.store(req, stored)
+		return stored.response(req, "fwd=stale; fwd-status=304"), nil
+	}
+	return t.cache.keep(req, resp)
+}
+
+func cacheKey(method string, req *http.Request) string {
+	return "http-cache:" + method + " " + req.URL.String()
+}
+
+// lookup returns the response stored for req, a HEAD request may be
+// answered with the response to a GET request.
+func (cache *HTTPCache) lookup(req *http.Request) (stored cachedResponse, ok bool) {
+	methods := []string{req.Method}
+	if req.Method == http.MethodHead {
+		methods = append(methods, http.MethodGet)
+	}
+	for _, method := range methods {
+		value, ok, err := cache.entries.Get(cacheKey(method, req))
+		if err != nil {
+			slog.Warn("http cache: " + err.Error())
+			return stored, false
+		}
+		if !ok || value == "" {
+			continue
+		}
+		stored = cachedResponse{}
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			slog.Warn("http cache: ignoring invalid entry", "key", cacheKey(method, req))
+			continue
+		}
+		if stored.matches(req) {
+			return stored, true
+		}
+	}
+	return stored, false
+}
+
+// keep stores resp, if it may be stored, and returns it to be used in its place.
+func (cache *HTTPCache) keep(req *http.Request, resp *http.Response) (*http.Response, error) {
+	stored := cachedResponse{Status: resp.StatusCode, Header: resp.Header.Clone(), Stored: time.Now()}
+	if !stored.storable() {
+		resp.Header.Set("Cache-Status", cacheStatusName+"; fwd=uri-miss")
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cache.maxBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > cache.maxBodySize {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		resp.Header.Set("Cache-Status", cacheStatusName+"; fwd=uri-miss")
+		return resp, nil
+	}
+	resp.Body.Close()
+	stored.Body = body
+	for _, name := range headerList(resp.Header, "Vary") {
+		if stored.Vary == nil {
+			stored.Vary = map[string]string{}
+		}
+		stored.Vary[http.CanonicalHeaderKey(name)] = req.Header.Get(name)
+	}
+	cache.store(req, stored)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.Header.Set("Cache-Status", cacheStatusName+"; fwd=uri-miss; stored")
+	return resp, nil
+}
+
+func (cache *HTTPCache) store(req *http.Request, stored cachedResponse) {
+	ttl := stored.lifetime() - stored.age(time.Now())
+	if stored.hasValidators() {
+		ttl = max(ttl, 0) + staleRetention
+	}
+	if ttl <= 0 {
+		return
+	}
+	bs, err := json.Marshal(stored)
+	if err != nil {
+		slog.Warn("http cache: " + err.Error())
+		return
+	}
+	if err := cache.entries.Set(cacheKey(req.Method, req), string(bs), ttl); err != nil {
+		slog.Warn("http cache: " + err.Error())
+	}
+}
+
+// invalidate removes the responses stored for the url of req.
+// A KeyValueStore cannot delete, the entries are replaced by ones that
+// expire right away.
+func (cache *HTTPCache) invalidate(req *http.Request) {
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		if err := cache.entries.Set(cacheKey(method, req), "", time.Nanosecond); err != nil {
+			slog.Warn("http cache: " + err.Error())
+		}
+	}
+}
+
+// storable reports whether a shared cache may store the response.
+func (stored cachedResponse) storable() bool {
+	if !cacheableStatus[stored.Status] {
+		return false
+	}
+	cc := parseCacheControl(stored.Header)
+	for _, directive := range []string{"no-store", "private"} {
+		if _, ok := cc[directive]; ok {
+			return false
+		}
+	}
+	for _, name := range headerList(stored.Header, "Vary") {
+		if name == "*" {
+			return false
+		}
+	}
+	return stored.lifetime() > 0 || stored.hasValidators()
+}
+
+func (stored cachedResponse) hasValidators() bool {
+	return stored.Header.Get("ETag") != "" || stored.Header.Get("Last-Modified") != ""
+}
+
+// lifetime is how long the response is fresh after it was generated.
+func (stored cachedResponse) lifetime() time.Duration {
+	cc := parseCacheControl(stored.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if value, ok := cc[directive]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	if expires := stored.Header.Get("Expires"); expires != "" {
+		expiry, err := http.ParseTime(expires)
+		if err != nil {
+			return 0 // invalid dates mean already expired
+		}
+		date, err := http.ParseTime(stored.Header.Get("Date"))
+		if err != nil {
+			date = stored.Stored
+		}
+		return expiry.Sub(date)
+	}
+	return 0
+}
+
+// age is how old the response is at now.
+func (stored cachedResponse) age(now time.Time) time.Duration {
+	age := now.Sub(stored.Stored)
+	if seconds, err := strconv.Atoi(stored.Header.Get("Age")); err == nil && seconds > 0 {
+		age += time.Duration(seconds) * time.Second
+	}
+	return age
+}
+
+func (stored cachedResponse) fresh(now time.Time) bool {
+	return stored.age(now) < stored.lifetime()
+}
+
+// matches reports whether the request headers named by Vary are the same
+// as those of the request the response was stored for.
+func (stored cachedResponse) matches(req *http.Request) bool {
+	for name, value := range stored.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// revalidated updates the stored response with the headers of a 304 Not
+// Modified response (RFC 9111, section 4.3.4).
+func (stored *cachedResponse) revalidated(resp *http.Response) {
+	for name, values := range resp.Header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		stored.Header[name] = values
+	}
+	stored.Header.Del("Age")
+	stored.Stored = time.Now()
+}
+
+// response turns the stored response into a response to req.
+func (stored cachedResponse) response(req *http.Request, status string) *http.Response {
+	header := stored.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(stored.age(time.Now())/time.Second)))
+	header.Set("Cache-Status", cacheStatusName+"; "+status)
+	resp := &http.Response{
+		Status:        strconv.Itoa(stored.Status) + " " + http.StatusText(stored.Status),
+		StatusCode:    stored.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(stored.Body)),
+		ContentLength: int64(len(stored.Body)),
+		Request:       req,
+	}
+	if req.Method == http.MethodHead {
+		resp.Body = http.NoBody
+	}
+	return resp
+}
+
+func isConditional(req *http.Request) bool {
+	return req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+// parseCacheControl parses the Cache-Control header into its directives,
+// lowercased, with their unquoted arguments.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, directive := range headerList(header, "Cache-Control") {
+		name, value, _ := strings.Cut(directive, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return cc
+}
+
+// headerList splits the comma separated values of the header name.
+func headerList(header http.Header, name string) (list []string) {
+	for _, value := range header.Values(name) {
+		for _, element := range strings.Split(value, ",") {
+			if element = strings.TrimSpace(element); element != "" {
+				list = append(list, element)
+			}
+		}
+	}
+	return list
+}
+
+// readCloser reads from Reader, and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
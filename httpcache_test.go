@@ -0,0 +1,75 @@
+package webmention_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestHTTPCache(t *testing.T) {
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fresh", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, "fresh")
+	})
+	mux.HandleFunc("/etag", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		io.WriteString(w, "tagged")
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		io.WriteString(w, "private")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	fileStore := must(webmention.NewFileKeyValueStore(t.TempDir()))
+	for name, store := range map[string]webmention.KeyValueStore{"memory": webmention.NewMemoryKeyValueStore(), "file": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			client := webmention.NewHTTPCache(store).Client(http.DefaultClient)
+			fetch := func(method, path, wantBody, wantStatus string, wantRequests int32) {
+				t.Helper()
+				requests.Store(0)
+				req := must(http.NewRequest(method, ts.URL+path, nil))
+				resp := must(client.Do(req))
+				defer resp.Body.Close()
+				body := must(io.ReadAll(resp.Body))
+				if string(body) != wantBody {
+					t.Errorf("%s %s: incorrect body: %q, want: %q", method, path, body, wantBody)
+				}
+				if status := resp.Header.Get("Cache-Status"); !strings.Contains(status, wantStatus) {
+					t.Errorf("%s %s: incorrect cache status: %q, want: %q", method, path, status, wantStatus)
+				}
+				if n := requests.Load(); n != wantRequests {
+					t.Errorf("%s %s: incorrect number of requests: %d, want: %d", method, path, n, wantRequests)
+				}
+			}
+
+			fetch(http.MethodGet, "/fresh", "fresh", "stored", 1)
+			fetch(http.MethodGet, "/fresh", "fresh", "hit", 0)
+			fetch(http.MethodHead, "/fresh", "", "hit", 0)
+			fetch(http.MethodPost, "/fresh", "fresh", "", 1)
+			fetch(http.MethodGet, "/fresh", "fresh", "stored", 1)
+
+			fetch(http.MethodGet, "/etag", "tagged", "stored", 1)
+			fetch(http.MethodGet, "/etag", "tagged", "fwd-status=304", 1)
+
+			fetch(http.MethodGet, "/private", "private", "uri-miss", 1)
+			fetch(http.MethodGet, "/private", "private", "uri-miss", 1)
+		})
+	}
+}
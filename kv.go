@@ -0,0 +1,193 @@
+package webmention
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// A KeyValueStore holds short-lived values.
+	// It is used to cache discovered endpoints, and to remember recently
+	// received mentions.
+	// Share a store between multiple receivers (e.g., the redis subpackage),
+	// to make them behave like a single one.
+	KeyValueStore interface {
+		// Get returns the value stored under key, ok is false if there is none.
+		Get(key string) (value string, ok bool, err error)
+
+		// Set stores value under key, for ttl (forever if ttl <= 0).
+		Set(key, value string, ttl time.Duration) error
+
+		// SetNX stores value under key, for ttl, unless the key already exists.
+		// It reports whether the value was stored.
+		SetNX(key, value string, ttl time.Duration) (bool, error)
+	}
+
+	// MemoryKeyValueStore is a KeyValueStore that is kept in memory.
+	// Expired values are removed lazily.
+	MemoryKeyValueStore struct {
+		m       sync.Mutex
+		entries map[string]kvEntry
+		writes  int
+	}
+
+	kvEntry struct {
+		value   string
+		expires time.Time
+	}
+
+	// FileKeyValueStore is a KeyValueStore that keeps every value in a file
+	// of its own in a directory, so that it survives restarts.
+	// It is safe for concurrent use within one process, and expired values
+	// are only removed when they are overwritten.
+	FileKeyValueStore struct {
+		dir string
+		m   sync.Mutex
+	}
+)
+
+// *MemoryKeyValueStore implements KeyValueStore
+var _ KeyValueStore = (*MemoryKeyValueStore)(nil)
+
+// *FileKeyValueStore implements KeyValueStore
+var _ KeyValueStore = (*FileKeyValueStore)(nil)
+
+// kvCleanupInterval is the number of writes after which expired entries are removed.
+const kvCleanupInterval = 1000
+
+func NewMemoryKeyValueStore() *MemoryKeyValueStore {
+	return &MemoryKeyValueStore{entries: map[string]kvEntry{}}
+}
+
+func (e kvEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+func (s *MemoryKeyValueStore) Get(key string) (string, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return "", false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (s *MemoryKeyValueStore) Set(key, value string, ttl time.Duration) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.set(key, value, ttl)
+	return nil
+}
+
+func (s *MemoryKeyValueStore) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if entry, ok := s.entries[key]; ok && !entry.expired(time.Now()) {
+		return false, nil
+	}
+	s.set(key, value, ttl)
+	return true, nil
+}
+
+func (s *MemoryKeyValueStore) set(key, value string, ttl time.Duration) {
+	now := time.Now()
+	entry := kvEntry{value: value}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	s.entries[key] = entry
+	s.writes++
+	if s.writes >= kvCleanupInterval {
+		s.writes = 0
+		for k, e := range s.entries {
+			if e.expired(now) {
+				delete(s.entries, k)
+			}
+		}
+	}
+}
+
+// NewFileKeyValueStore creates a store in dir, creating the directory if it
+// doesn't exist.
+func NewFileKeyValueStore(dir string) (*FileKeyValueStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("key value store: %w", err)
+	}
+	return &FileKeyValueStore{dir: dir}, nil
+}
+
+// path is the file the value of key is kept in, named after its hash.
+func (s *FileKeyValueStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *FileKeyValueStore) Get(key string) (string, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.get(key)
+}
+
+func (s *FileKeyValueStore) Set(key, value string, ttl time.Duration) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.set(key, value, ttl)
+}
+
+func (s *FileKeyValueStore) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok, err := s.get(key); err != nil || ok {
+		return false, err
+	}
+	return true, s.set(key, value, ttl)
+}
+
+// get reads the file of key, its first line is the expiry in unix
+// nanoseconds (0 if it never expires), the rest is the value.
+func (s *FileKeyValueStore) get(key string) (string, bool, error) {
+	bs, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("key value store: %w", err)
+	}
+	header, value, _ := strings.Cut(string(bs), "\n")
+	expires, err := strconv.ParseInt(header, 10, 64)
+	if err != nil {
+		return "", false, fmt.Errorf("key value store: corrupt entry: %s", s.path(key))
+	}
+	if expires != 0 && time.Now().UnixNano() >= expires {
+		return "", false, nil
+	}
+	return value, true, nil
+}
+
+// set writes to a temporary file first, so that a crash never leaves a
+// partially written value behind.
+func (s *FileKeyValueStore) set(key, value string, ttl time.Duration) error {
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
+	}
+	path := s.path(key)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(expires, 10)+"\n"+value), 0o600); err != nil {
+		return fmt.Errorf("key value store: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("key value store: %w", err)
+	}
+	return nil
+}
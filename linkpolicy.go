@@ -0,0 +1,92 @@
+package webmention
+
+import (
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// LinkPolicy decides which links of an html source count when verifying
+// that it links to the target, e.g., to ignore blogrolls and "related posts"
+// widgets linking to everything:
+//
+//	webmention.WithMediaHandler("text/html", 1.0, webmention.LinkPolicy{
+//		Within:  []string{".h-entry"},
+//		Exclude: []string{"nav", "footer", "aside"},
+//	}.Handler())
+//
+// Within and Exclude are lists of simple selectors: an element name (nav),
+// a class (.e-content), or an id (#comments).
+type LinkPolicy struct {
+	// Elements maps the elements that are links to the attribute holding
+	// their url, e.g., {"a": "href", "img": "src"}.
+	// Defaults to the elements checked by HtmlHandler.
+	Elements map[string]string
+	// Within only counts links inside an element matching one of the
+	// selectors, all links count if empty.
+	Within []string
+	// Exclude ignores links inside an element matching one of the selectors.
+	Exclude []string
+}
+
+// defaultLinkElements are the elements checked by HtmlHandler.
+var defaultLinkElements = map[string]string{"a": "href", "img": "href", "video": "href"}
+
+// Handler returns a MediaHandler for html, that follows the policy.
+func (policy LinkPolicy) Handler() MediaHandler {
+	elements := policy.Elements
+	if elements == nil {
+		elements = defaultLinkElements
+	}
+	return func(content io.Reader, target URL) (Status, error) {
+		doc, err := html.Parse(content)
+		if err != nil {
+			return "", err
+		}
+		var traverseHtml func(node *html.Node, inside bool) bool
+		traverseHtml = func(node *html.Node, inside bool) bool {
+			if node.Type == html.ElementNode {
+				if matchesAnySelector(node, policy.Exclude) {
+					return false
+				}
+				inside = inside || matchesAnySelector(node, policy.Within)
+				if key, ok := elements[node.Data]; ok && inside {
+					if link, _ := attr(node, key); strings.EqualFold(link, target.String()) {
+						return true
+					}
+				}
+			}
+			for child := node.FirstChild; child != nil; child = child.NextSibling {
+				if traverseHtml(child, inside) {
+					return true
+				}
+			}
+			return false
+		}
+		if !traverseHtml(doc, len(policy.Within) == 0) {
+			return StatusNoLink, nil
+		}
+		return StatusLink, nil
+	}
+}
+
+// matchesAnySelector reports whether node matches one of the simple selectors.
+func matchesAnySelector(node *html.Node, selectors []string) bool {
+	for _, selector := range selectors {
+		switch {
+		case strings.HasPrefix(selector, "."):
+			if class, _ := attr(node, "class"); slices.Contains(strings.Fields(class), selector[1:]) {
+				return true
+			}
+		case strings.HasPrefix(selector, "#"):
+			if id, ok := attr(node, "id"); ok && id == selector[1:] {
+				return true
+			}
+		case strings.EqualFold(node.Data, selector):
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package webmention_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestLinkPolicy(t *testing.T) {
+	target := must(url.Parse("https://example.com/post"))
+	page := func(body string) string {
+		return `<html><body><nav><a href="https://example.com/post">blogroll</a></nav>` + body + `<footer><a href="https://example.com/post">related</a></footer></body></html>`
+	}
+	policy := webmention.LinkPolicy{
+		Within:  []string{".h-entry"},
+		Exclude: []string{"footer", "#related"},
+	}
+	for _, tc := range []struct {
+		name   string
+		policy webmention.LinkPolicy
+		html   string
+		status webmention.Status
+	}{
+		{"default", webmention.LinkPolicy{}, page(""), webmention.StatusLink},
+		{"outside entry", policy, page(`<article class="h-entry"><p>Hello</p></article>`), webmention.StatusNoLink},
+		{"inside entry", policy, page(`<article class="post h-entry"><p><a href="https://example.com/post">this</a></p></article>`), webmention.StatusLink},
+		{"excluded inside entry", policy, page(`<article class="h-entry"><ul id="related"><li><a href="https://example.com/post">this</a></li></ul></article>`), webmention.StatusNoLink},
+		{"other elements", webmention.LinkPolicy{Elements: map[string]string{"img": "src"}}, `<img src="https://example.com/post"><a href="https://example.com/post">`, webmention.StatusLink},
+		{"other elements only", webmention.LinkPolicy{Elements: map[string]string{"img": "src"}}, `<a href="https://example.com/post">`, webmention.StatusNoLink},
+	} {
+		status, err := tc.policy.Handler()(strings.NewReader(tc.html), target)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if status != tc.status {
+			t.Errorf("%s: got: %s, want: %s", tc.name, status, tc.status)
+		}
+	}
+}
+
+func TestLinkPolicyReplacesHtmlHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<nav><a href="%s/target">blogroll</a></nav><article class="h-entry"></article>`, ts.URL)
+	})
+	notified := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithMediaHandler("text/html", 1.0, webmention.LinkPolicy{Within: []string{".h-entry"}}.Handler()),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	resp, err := http.PostForm(ts.URL+"/webmention", url.Values{"source": {ts.URL + "/source"}, "target": {ts.URL + "/target"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case mention := <-notified:
+		if mention.Status != webmention.StatusNoLink {
+			t.Errorf("link outside of the h-entry counted: %s", mention.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
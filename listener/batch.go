@@ -0,0 +1,188 @@
+package listener
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+type (
+	// BatchPolicy decides when a Batcher passes on the mentions it collected.
+	// The zero policy passes on every mention immediately.
+	BatchPolicy struct {
+		// MaxCount passes on a batch once it has this many mentions (0: no limit).
+		MaxCount int
+		// Interval passes on all batches periodically (0: only by count).
+		Interval time.Duration
+		// PerTarget collects a separate batch for every target, e.g., to
+		// send one digest per blog post.
+		PerTarget bool
+	}
+
+	// Batcher collects mentions and passes them on to a Sender in batches,
+	// according to its Policy.
+	// It is both a Notifier and a Sender, so it can be put in front of any
+	// Sender, e.g., Mailer{Sender: NewBatcher(...)}, or wrap notifiers with
+	// Notifiers.
+	// Batches that fail to be sent temporarily (see IsTemporary) are kept,
+	// and retried with the next batch, batches that fail permanently are
+	// dropped.
+	Batcher struct {
+		Policy  BatchPolicy
+		Sender  Sender
+		m       sync.Mutex
+		batches map[string][]webmention.Mention
+		stop    chan struct{}
+	}
+
+	// Notifiers adapts notifiers to a Sender, every notifier is informed
+	// about every mention of a batch.
+	Notifiers []webmention.Notifier
+
+	// SenderFunc adapts a function to an object that implements the Sender interface.
+	SenderFunc func([]webmention.Mention) error
+)
+
+var (
+	// *Batcher implements webmention.Notifier
+	_ webmention.Notifier = (*Batcher)(nil)
+	// *Batcher implements Sender
+	_ Sender = (*Batcher)(nil)
+)
+
+func NewBatcher(sender Sender, policy BatchPolicy) *Batcher {
+	return &Batcher{
+		Policy:  policy,
+		Sender:  sender,
+		batches: map[string][]webmention.Mention{},
+		stop:    make(chan struct{}),
+	}
+}
+
+// ParseBatchPolicy parses a comma separated policy description, for example:
+//
+//	instant                   pass on every mention immediately
+//	interval=5m               every five minutes
+//	count=20,interval=12h     every twelve hours, or once there are twenty mentions
+//	interval=1h,per-target    every hour, one batch per target
+func ParseBatchPolicy(spec string) (policy BatchPolicy, err error) {
+	for _, part := range strings.Split(spec, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "instant", "":
+		case "count":
+			policy.MaxCount, err = strconv.Atoi(value)
+			if err != nil || policy.MaxCount < 0 {
+				return policy, fmt.Errorf("batch policy: invalid count: %q", value)
+			}
+		case "interval":
+			policy.Interval, err = time.ParseDuration(value)
+			if err != nil || policy.Interval < 0 {
+				return policy, fmt.Errorf("batch policy: invalid interval: %q", value)
+			}
+		case "per-target":
+			policy.PerTarget = true
+		default:
+			return policy, fmt.Errorf("batch policy: unknown setting: %q", key)
+		}
+	}
+	return policy, nil
+}
+
+func (p BatchPolicy) instant() bool {
+	return p.Interval == 0 && p.MaxCount <= 1
+}
+
+func (b *Batcher) Receive(mention webmention.Mention) {
+	if err := b.Send([]webmention.Mention{mention}); err != nil {
+		slog.Error(fmt.Sprintf("batcher: %s", err), "mention", mention)
+	}
+}
+
+// Send adds mentions to their batch, and passes the batch on if it is full.
+func (b *Batcher) Send(mentions []webmention.Mention) (err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for _, mention := range mentions {
+		key := ""
+		if b.Policy.PerTarget {
+			key = mention.Target.String()
+		}
+		b.batches[key] = append(b.batches[key], mention)
+		if b.Policy.instant() || (b.Policy.MaxCount > 0 && len(b.batches[key]) >= b.Policy.MaxCount) {
+			err = errors.Join(err, b.flush(key))
+		}
+	}
+	return err
+}
+
+// Start passes on all batches every Policy.Interval, until Stop is called.
+// It does nothing if no interval is set.
+func (b *Batcher) Start() {
+	if b.Policy.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(b.Policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				slog.Error(fmt.Sprintf("batcher: %s", err))
+			}
+		}
+	}
+}
+
+// Stop stops Start, and passes on everything that is still collected.
+func (b *Batcher) Stop() error {
+	close(b.stop)
+	return b.Flush()
+}
+
+// Flush passes on all batches now.
+func (b *Batcher) Flush() (err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for key := range b.batches {
+		err = errors.Join(err, b.flush(key))
+	}
+	return err
+}
+
+func (b *Batcher) flush(key string) error {
+	batch := b.batches[key]
+	if len(batch) == 0 {
+		return nil
+	}
+	if err := b.Sender.Send(batch); err != nil {
+		if IsTemporary(err) {
+			return err
+		}
+		delete(b.batches, key)
+		return fmt.Errorf("dropping batch of %d mentions: %w", len(batch), err)
+	}
+	delete(b.batches, key)
+	return nil
+}
+
+func (n Notifiers) Send(mentions []webmention.Mention) error {
+	for _, mention := range mentions {
+		for _, notifier := range n {
+			notifier.Receive(mention)
+		}
+	}
+	return nil
+}
+
+func (f SenderFunc) Send(mentions []webmention.Mention) error {
+	return f(mentions)
+}
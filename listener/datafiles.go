@@ -0,0 +1,207 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// DataFiles keeps the verified mentions of every target in a JSON file of
+// its own, e.g., in the data directory of a static site generator, so that
+// the site picks them up the next time it is built.
+// Each file holds a JSON array of mentions, ordered by the time they were
+// received. Mentions whose source no longer links to the target, or got
+// deleted, are removed from the file again.
+//
+// A target's file is named after its path, without extension:
+// https://example.com/posts/hello/ is kept in posts/hello.json,
+// https://example.com/ in index.json.
+// The host of the target is ignored.
+//
+// DataFiles is both a Notifier, and a webmention.Storage.
+type DataFiles struct {
+	// Dir the files are written to, created if it doesn't exist.
+	Dir string
+	// Git commits every changed file, Dir must be inside a git repository.
+	Git bool
+	// Push pushes every commit to the default remote (requires Git).
+	Push bool
+	m    sync.Mutex
+}
+
+var (
+	// *DataFiles implements webmention.Notifier
+	_ webmention.Notifier = (*DataFiles)(nil)
+	// *DataFiles implements webmention.Storage
+	_ webmention.Storage = (*DataFiles)(nil)
+)
+
+func NewDataFiles(dir string) *DataFiles {
+	return &DataFiles{Dir: dir}
+}
+
+// File returns the path of the file holding the mentions of target.
+func (d *DataFiles) File(target webmention.URL) string {
+	name := path.Clean("/" + target.Path) // never leaves Dir
+	name = strings.TrimSuffix(name, path.Ext(name))
+	if name == "/" {
+		name = "/index"
+	}
+	return filepath.Join(d.Dir, filepath.FromSlash(name)+".json")
+}
+
+func (d *DataFiles) Receive(mention webmention.Mention) {
+	if err := d.Store(mention); err != nil {
+		slog.Error(fmt.Sprintf("data files: %s", err), "mention", mention)
+	}
+}
+
+// Store adds the mention to the file of its target, replacing any mention
+// from the same source, or removes it, if the source doesn't link to the
+// target anymore.
+func (d *DataFiles) Store(mention webmention.Mention) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	file := d.File(mention.Target)
+	mentions, err := readDataFile(file)
+	if err != nil {
+		return fmt.Errorf("data files: %w", err)
+	}
+	changed := len(mentions)
+	mentions = slices.DeleteFunc(mentions, func(m webmention.Mention) bool {
+		return m.Source.String() == mention.Source.String()
+	})
+	if mention.Status == webmention.StatusLink {
+		mentions = append(mentions, mention)
+		slices.SortStableFunc(mentions, func(a, b webmention.Mention) int {
+			return a.Received.Compare(b.Received)
+		})
+	} else if len(mentions) == changed {
+		return nil // nothing to remove
+	}
+	if err := writeDataFile(file, mentions); err != nil {
+		return fmt.Errorf("data files: %w", err)
+	}
+	if d.Git {
+		message := fmt.Sprintf("Webmention from %s to %s", mention.Source, mention.Target)
+		if mention.Status != webmention.StatusLink {
+			message = fmt.Sprintf("Remove webmention from %s to %s", mention.Source, mention.Target)
+		}
+		if err := d.commit(file, message); err != nil {
+			return fmt.Errorf("data files: %w", err)
+		}
+	}
+	return nil
+}
+
+// Mentions returns the mentions of all files matching the filter, ordered
+// by the time they were received.
+func (d *DataFiles) Mentions(filter webmention.MentionFilter) (mentions []webmention.Mention, err error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	err = filepath.WalkDir(d.Dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(file) != ".json" {
+			return err
+		}
+		all, err := readDataFile(file)
+		if err != nil {
+			return err
+		}
+		for _, mention := range all {
+			if filter.Matches(mention) {
+				mentions = append(mentions, mention)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("data files: %w", err)
+	}
+	slices.SortStableFunc(mentions, func(a, b webmention.Mention) int {
+		return a.Received.Compare(b.Received)
+	})
+	return mentions, nil
+}
+
+func readDataFile(file string) (mentions []webmention.Mention, err error) {
+	bs, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &mentions); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return mentions, nil
+}
+
+// writeDataFile replaces file atomically, an empty file is removed.
+func writeDataFile(file string, mentions []webmention.Mention) error {
+	if len(mentions) == 0 {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	bs, err := json.MarshalIndent(mentions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(bs, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// commit commits the changes of file, and pushes them, if enabled.
+func (d *DataFiles) commit(file, message string) error {
+	git := func(args ...string) error {
+		cmd := exec.Command("git", append([]string{"-C", d.Dir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	rel, err := filepath.Rel(d.Dir, file)
+	if err != nil {
+		return err
+	}
+	if err := git("add", "--all", "--", rel); err != nil {
+		return err
+	}
+	if err := git("commit", "--quiet", "--message", message, "--", rel); err != nil {
+		return err
+	}
+	if d.Push {
+		return git("push", "--quiet")
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// ExecHook runs an external command for every mention, to glue in custom
+// behavior without writing Go, e.g., rebuilding a static site.
+// As a Notifier, the command is run once per mention, which is passed as a
+// JSON object on stdin, and its source, target, and status in the
+// environment variables WEBMENTION_SOURCE, WEBMENTION_TARGET, and
+// WEBMENTION_STATUS.
+// As a Sender (e.g., behind a Batcher), the command is run once per batch,
+// which is passed as a JSON array on stdin.
+// The command is run directly, not by a shell, and doesn't inherit the
+// environment, apart from PATH, HOME, and PassEnv (see webmention.CommandEnv).
+type ExecHook struct {
+	// Command is the program to run, followed by its arguments.
+	Command []string
+	// Timeout kills the command if it runs longer (0: no timeout).
+	Timeout time.Duration
+	// PassEnv names further environment variables the command inherits.
+	PassEnv []string
+	// sem limits how many commands run at once, nil if unlimited.
+	sem chan struct{}
+}
+
+var (
+	// *ExecHook implements webmention.Notifier
+	_ webmention.Notifier = (*ExecHook)(nil)
+	// *ExecHook implements Sender
+	_ Sender = (*ExecHook)(nil)
+)
+
+// maxHookOutput is how much of the command's output is kept for the error message.
+const maxHookOutput = 1024
+
+// NewExecHook runs command with a timeout, at most concurrency at once
+// (at least one), further mentions wait for their turn.
+func NewExecHook(command []string, timeout time.Duration, concurrency int) *ExecHook {
+	return &ExecHook{
+		Command: command,
+		Timeout: timeout,
+		sem:     make(chan struct{}, max(concurrency, 1)),
+	}
+}
+
+func (hook *ExecHook) Receive(mention webmention.Mention) {
+	input, err := json.Marshal(mention)
+	if err == nil {
+		err = hook.run(input,
+			"WEBMENTION_SOURCE="+mention.Source.String(),
+			"WEBMENTION_TARGET="+mention.Target.String(),
+			"WEBMENTION_STATUS="+string(mention.Status),
+		)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("exec hook: %s", err), "mention", mention)
+	}
+}
+
+func (hook *ExecHook) Send(mentions []webmention.Mention) error {
+	input, err := json.Marshal(mentions)
+	if err != nil {
+		return fmt.Errorf("exec hook: %w", err)
+	}
+	if err := hook.run(input); err != nil {
+		return fmt.Errorf("exec hook: %w", err)
+	}
+	return nil
+}
+
+func (hook *ExecHook) run(input []byte, env ...string) error {
+	if len(hook.Command) == 0 {
+		return errors.New("no command configured")
+	}
+	if hook.sem != nil { // not limited, unless created by NewExecHook
+		hook.sem <- struct{}{}
+		defer func() { <-hook.sem }()
+	}
+
+	ctx := context.Background()
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = webmention.CommandEnv(hook.PassEnv, env...)
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	cmd.WaitDelay = time.Second // don't wait for children holding on to the output
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", hook.Command[0], ctx.Err())
+	}
+	if err != nil {
+		out := strings.TrimSpace(output.String())
+		if len(out) > maxHookOutput {
+			out = out[:maxHookOutput] + "..."
+		}
+		return fmt.Errorf("%s: %w: %s", hook.Command[0], err, out)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package listener_test
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/listener"
+)
+
+func TestExecHookEnv(t *testing.T) {
+	t.Setenv("MAIL_PASS", "secret")
+	t.Setenv("HOOK_SETTING", "passed")
+	out := filepath.Join(t.TempDir(), "env")
+	hook := listener.NewExecHook([]string{"sh", "-c", "env > " + out}, 0, 1)
+	hook.PassEnv = []string{"HOOK_SETTING"}
+	hook.Receive(webmention.Mention{
+		Source: &url.URL{Scheme: "https", Host: "source.example", Path: "/post"},
+		Target: &url.URL{Scheme: "https", Host: "target.example", Path: "/"},
+		Status: webmention.StatusLink,
+	})
+	bs, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	for _, expected := range []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOOK_SETTING=passed",
+		"WEBMENTION_SOURCE=https://source.example/post",
+		"WEBMENTION_TARGET=https://target.example/",
+		"WEBMENTION_STATUS=" + string(webmention.StatusLink),
+	} {
+		if !slices.Contains(env, expected) {
+			t.Errorf("expected %s in the environment of the hook, got %q", expected, env)
+		}
+	}
+	for _, variable := range env {
+		if strings.HasPrefix(variable, "MAIL_PASS=") {
+			t.Errorf("secret visible to the hook: %s", variable)
+		}
+	}
+}
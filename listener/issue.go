@@ -0,0 +1,157 @@
+package listener
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+type (
+	// IssueCommenter reports mentions as comments on an issue of a GitHub
+	// or Gitea repository, for sites managed entirely through their Git
+	// hosting.
+	// As a Notifier, every mention is a comment of its own, as a Sender
+	// (e.g., behind a Batcher), every batch is.
+	// To add the mentions to the repository itself, use DataFiles instead.
+	IssueCommenter struct {
+		// API is the base url of the API, https://api.github.com for
+		// GitHub, or https://gitea.example.com/api/v1 for Gitea.
+		API string
+		// Repo is the repository, as owner/name.
+		Repo string
+		// Issue is the number of the issue (or pull request) to comment on.
+		Issue int
+		// Token must be allowed to comment on issues of the repository.
+		Token string
+		// Body formats the comment (markdown), defaults to IssueCommentBody.
+		Body       func([]webmention.Mention) string
+		HttpClient *http.Client
+	}
+
+	// statusError is returned if an API answers with an error status.
+	statusError struct {
+		status  string
+		code    int
+		message string
+	}
+)
+
+var (
+	// *IssueCommenter implements webmention.FallibleNotifier
+	_ webmention.FallibleNotifier = (*IssueCommenter)(nil)
+	// *IssueCommenter implements webmention.NamedNotifier
+	_ webmention.NamedNotifier = (*IssueCommenter)(nil)
+	// *IssueCommenter implements Sender
+	_ Sender = (*IssueCommenter)(nil)
+)
+
+// IssueCommentBody lists the mentions as markdown.
+func IssueCommentBody(mentions []webmention.Mention) string {
+	var builder strings.Builder
+	if len(mentions) == 1 {
+		builder.WriteString("New webmention:\n\n")
+	} else {
+		builder.WriteString(fmt.Sprintf("%d new webmentions:\n\n", len(mentions)))
+	}
+	for _, mention := range mentions {
+		kind := string(mention.Type)
+		if kind == "" {
+			kind = string(webmention.TypeMention)
+		}
+		switch mention.Status {
+		case webmention.StatusLink:
+			builder.WriteString(fmt.Sprintf("- %s from <%s> to <%s>\n", kind, mention.Source, mention.Target))
+		case webmention.StatusDeleted:
+			builder.WriteString(fmt.Sprintf("- <%s> got deleted, it mentioned <%s>\n", mention.Source, mention.Target))
+		case webmention.StatusUnavailable:
+			builder.WriteString(fmt.Sprintf("- <%s> is unavailable (%s), it mentioned <%s>\n", mention.Source, mention.Reason, mention.Target))
+		default:
+			builder.WriteString(fmt.Sprintf("- <%s> no longer links to <%s>\n", mention.Source, mention.Target))
+		}
+	}
+	return builder.String()
+}
+
+// Name identifies the commenter in a webmention.NotificationLog.
+func (c *IssueCommenter) Name() string {
+	return fmt.Sprintf("issue:%s#%d", c.Repo, c.Issue)
+}
+
+func (c *IssueCommenter) Receive(mention webmention.Mention) {
+	if err := c.Notify(mention); err != nil {
+		slog.Error(err.Error(), "mention", mention)
+	}
+}
+
+// Notify comments on the issue, so that a webmention.NotificationLog only
+// records the mention once the comment has been created.
+func (c *IssueCommenter) Notify(mention webmention.Mention) error {
+	return c.Send([]webmention.Mention{mention})
+}
+
+// Send creates a single comment for all mentions.
+// Errors are temporary (see IsTemporary), if the API is unavailable or rate limited.
+func (c *IssueCommenter) Send(mentions []webmention.Mention) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+	format := c.Body
+	if format == nil {
+		format = IssueCommentBody
+	}
+	body, err := json.Marshal(map[string]string{"body": format(mentions)})
+	if err != nil {
+		return fmt.Errorf("issue comment: %w", err)
+	}
+	// GitHub and Gitea share this api
+	endpoint := fmt.Sprintf("%s/repos/%s/issues/%d/comments", strings.TrimSuffix(c.API, "/"), c.Repo, c.Issue)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("issue comment: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "token "+c.Token)
+	client := c.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("issue comment: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&apiErr)
+		return fmt.Errorf("issue comment: %w", &statusError{resp.Status, resp.StatusCode, apiErr.Message})
+	}
+	return nil
+}
+
+func (e *statusError) Error() string {
+	if e.message == "" {
+		return e.status
+	}
+	return e.status + ": " + e.message
+}
+
+// temporary reports whether the request may succeed later.
+func (e *statusError) temporary() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
+}
+
+// isTemporaryStatus reports whether err is a temporary statusError.
+func isTemporaryStatus(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.temporary()
+}
@@ -2,10 +2,15 @@ package listener
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"gopkg.in/gomail.v2"
+	"io"
 	"log/slog"
 	"net/smtp"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +27,9 @@ type (
 	Sender interface {
 		Send([]webmention.Mention) error
 	}
+	// ReportAggregator collects mentions, and passes them on in a single
+	// report after some time, or once there are enough of them.
+	// Batcher does the same, with more options.
 	ReportAggregator struct {
 		m              sync.Mutex
 		Todos          []webmention.Mention
@@ -30,11 +38,32 @@ type (
 		SendAfterCount int
 		Sender         Sender
 	}
+	// Recipients of a mail.
+	Recipients struct {
+		To, Cc, Bcc []string
+		// Targets limits recipients (by address) to mentions of targets
+		// starting with one of the prefixes, e.g., the posts of one author.
+		// Prefixes starting with a slash are matched against the path only.
+		// Recipients without an entry receive all mentions.
+		Targets map[string][]string
+	}
+
+	// addressedMail is a mail to a subset of the Recipients.
+	addressedMail struct {
+		to, cc, bcc []string
+		mentions    []webmention.Mention
+	}
+
+	// InternalMailer delivers mails directly to the server at ToAddr,
+	// which must accept mails for all recipients.
 	InternalMailer struct {
 		SubjectLine      func([]webmention.Mention) string
 		Body             func([]webmention.Mention) string
 		FromAddr, ToAddr string
-		From, To         string
+		From             string
+		Recipients
+		// AttachJSON attaches the mentions as mentions.json, for scripts to process.
+		AttachJSON bool
 	}
 	InternalDKIMMailer struct {
 		InternalMailer
@@ -43,8 +72,11 @@ type (
 	ExternalMailer struct {
 		SubjectLine func([]webmention.Mention) string
 		Body        func([]webmention.Mention) string
-		From, To    string
-		Dialer      *gomail.Dialer
+		From        string
+		Recipients
+		Dialer *gomail.Dialer
+		// AttachJSON attaches the mentions as mentions.json, for scripts to process.
+		AttachJSON bool
 	}
 )
 
@@ -55,21 +87,68 @@ func DefaultSubjectLine(mentions []webmention.Mention) string {
 func DefaultBody(mentions []webmention.Mention) string {
 	var builder strings.Builder
 	for _, mention := range mentions {
-		builder.WriteString(fmt.Sprintf("source: %s\ntarget: %s\nstatus: %s\n\n", mention.Source, mention.Target, mention.Status))
+		builder.WriteString(fmt.Sprintf("source: %s\ntarget: %s\nstatus: %s\n", mention.Source, mention.Target, mention.Status))
+		if mention.Reason != "" {
+			builder.WriteString(fmt.Sprintf("reason: %s\n", mention.Reason))
+		}
+		if mention.Change != "" {
+			builder.WriteString(fmt.Sprintf("change: %s\n", mention.Change))
+		}
+		if diff := mention.ContentDiff(); diff != "" {
+			builder.WriteString(fmt.Sprintf("edited: %s\n", diff))
+		}
+		builder.WriteString("\n")
 	}
 	return builder.String()
 }
 
+// ModerationSubjectLine is the subject line of mails about mentions held
+// for moderation.
+func ModerationSubjectLine(mentions []webmention.Mention) string {
+	return fmt.Sprintf("%d new mentions await moderation", len(mentions))
+}
+
+// ModerationLinksBody wraps body, every mention is followed by links that
+// approve or reject it, signed by links.
+// Use it for mailers notified of held mentions, see webmention.WithModeration.
+func ModerationLinksBody(links webmention.ModerationLinks, body func([]webmention.Mention) string) func([]webmention.Mention) string {
+	return func(mentions []webmention.Mention) string {
+		var builder strings.Builder
+		for _, mention := range mentions {
+			builder.WriteString(strings.TrimRight(body([]webmention.Mention{mention}), "\n") + "\n")
+			if mention.SpamScore > 0 {
+				builder.WriteString(fmt.Sprintf("spam score: %.2f\n", mention.SpamScore))
+			}
+			builder.WriteString(fmt.Sprintf("approve: %s\nreject: %s\n\n",
+				links.Link(mention.ID, webmention.ActionApprove),
+				links.Link(mention.ID, webmention.ActionReject),
+			))
+		}
+		return builder.String()
+	}
+}
+
 func NewMailer(sender Sender) Mailer {
 	return Mailer{Sender: sender}
 }
 
+// Name identifies the mailer in a webmention.NotificationLog.
+func (m Mailer) Name() string {
+	return "mail"
+}
+
 func (m Mailer) Receive(mention webmention.Mention) {
-	if err := m.Sender.Send([]webmention.Mention{mention}); err != nil {
+	if err := m.Notify(mention); err != nil {
 		slog.Error(fmt.Sprintf("notifybymail: failed to send email: %s", err), "mention", mention)
 	}
 }
 
+// Notify sends the mention, so that a webmention.NotificationLog only
+// records it once the email has been sent.
+func (m Mailer) Notify(mention webmention.Mention) error {
+	return m.Sender.Send([]webmention.Mention{mention})
+}
+
 func (m *ReportAggregator) Start() {
 	for range time.Tick(m.SendAfterTime) {
 		if m.m.TryLock() {
@@ -104,59 +183,98 @@ func (m *ReportAggregator) SendNow() error {
 	return nil
 }
 
-func (m InternalMailer) Send(mentions []webmention.Mention) error {
-	msg := gomail.NewMessage()
-	msg.SetHeader("From", m.From)
-	msg.SetHeader("To", m.To)
-	msg.SetHeader("Subject", m.SubjectLine(mentions))
-	msg.SetBody("text/plain", m.Body(mentions))
-	var clearMessage bytes.Buffer
-	if _, err := msg.WriteTo(&clearMessage); err != nil {
-		return err
-	}
-	c, err := smtp.Dial(m.ToAddr)
-	if err != nil {
-		return err
-	}
-	defer c.Close()
-	if err := c.Hello(m.FromAddr); err != nil {
-		return err
-	}
-	if err := c.Mail(m.From); err != nil {
-		return err
+// mails splits mentions into one mail for all unfiltered recipients, and
+// one mail for each filtered recipient (if any of their targets were mentioned).
+func (r Recipients) mails(mentions []webmention.Mention) (mails []addressedMail) {
+	all := addressedMail{mentions: mentions}
+	for _, field := range []struct {
+		addresses []string
+		all       *[]string
+		single    func(address string) addressedMail
+	}{
+		{r.To, &all.to, func(address string) addressedMail { return addressedMail{to: []string{address}} }},
+		{r.Cc, &all.cc, func(address string) addressedMail { return addressedMail{cc: []string{address}} }},
+		{r.Bcc, &all.bcc, func(address string) addressedMail { return addressedMail{bcc: []string{address}} }},
+	} {
+		for _, address := range field.addresses {
+			prefixes, filtered := r.Targets[address]
+			if !filtered {
+				*field.all = append(*field.all, address)
+				continue
+			}
+			mail := field.single(address)
+			for _, mention := range mentions {
+				if matchesTarget(mention.Target, prefixes) {
+					mail.mentions = append(mail.mentions, mention)
+				}
+			}
+			if len(mail.mentions) > 0 {
+				mails = append(mails, mail)
+			}
+		}
 	}
-	if err := c.Rcpt(m.To); err != nil {
-		return err
+	if len(all.to)+len(all.cc)+len(all.bcc) > 0 {
+		mails = append([]addressedMail{all}, mails...)
 	}
-	w, err := c.Data()
-	if err != nil {
-		return err
+	return mails
+}
+
+func matchesTarget(target *url.URL, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(prefix, "/") && strings.HasPrefix(target.Path, prefix) {
+			return true
+		}
+		if strings.HasPrefix(target.String(), prefix) {
+			return true
+		}
 	}
-	if _, err := w.Write(clearMessage.Bytes()); err != nil {
-		return err
+	return false
+}
+
+// recipients returns all envelope recipients of the mail.
+func (mail addressedMail) recipients() []string {
+	return slices.Concat(mail.to, mail.cc, mail.bcc)
+}
+
+// message builds the mail, Bcc recipients are only part of the envelope.
+func (mail addressedMail) message(from string, subjectLine, body func([]webmention.Mention) string, attachJSON bool) *gomail.Message {
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", from)
+	if len(mail.to) > 0 {
+		msg.SetHeader("To", mail.to...)
 	}
-	if err := w.Close(); err != nil {
-		return err
+	if len(mail.cc) > 0 {
+		msg.SetHeader("Cc", mail.cc...)
 	}
-	if err := c.Quit(); err != nil {
-		return err
+	msg.SetHeader("Subject", subjectLine(mail.mentions))
+	msg.SetBody("text/plain", body(mail.mentions))
+	if attachJSON {
+		msg.Attach("mentions.json",
+			gomail.SetHeader(map[string][]string{"Content-Type": {"application/json"}}),
+			gomail.SetCopyFunc(func(w io.Writer) error {
+				return json.NewEncoder(w).Encode(struct {
+					Mentions []webmention.Mention `json:"mentions"`
+				}{mail.mentions})
+			}),
+		)
 	}
-	return nil
+	return msg
 }
 
-func (m InternalDKIMMailer) Send(mentions []webmention.Mention) error {
-	msg := gomail.NewMessage()
-	msg.SetHeader("From", m.From)
-	msg.SetHeader("To", m.To)
-	msg.SetHeader("Subject", m.SubjectLine(mentions))
-	msg.SetBody("text/plain", m.Body(mentions))
-	var clearMessage, signedMessage bytes.Buffer
-	if _, err := msg.WriteTo(&clearMessage); err != nil {
-		return err
-	}
-	if err := dkim.Sign(&signedMessage, &clearMessage, m.DkimSignOpts); err != nil {
-		return err
+func (m InternalMailer) Send(mentions []webmention.Mention) (err error) {
+	for _, mail := range m.mails(mentions) {
+		var clearMessage bytes.Buffer
+		if _, werr := mail.message(m.From, m.SubjectLine, m.Body, m.AttachJSON).WriteTo(&clearMessage); werr != nil {
+			err = errors.Join(err, werr)
+			continue
+		}
+		err = errors.Join(err, m.deliver(clearMessage.Bytes(), mail.recipients()))
 	}
+	return err
+}
+
+// deliver sends message to the server at ToAddr.
+func (m InternalMailer) deliver(message []byte, recipients []string) error {
 	c, err := smtp.Dial(m.ToAddr)
 	if err != nil {
 		return err
@@ -168,14 +286,16 @@ func (m InternalDKIMMailer) Send(mentions []webmention.Mention) error {
 	if err := c.Mail(m.From); err != nil {
 		return err
 	}
-	if err := c.Rcpt(m.To); err != nil {
-		return err
+	for _, rcpt := range recipients {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
 	}
 	w, err := c.Data()
 	if err != nil {
 		return err
 	}
-	if _, err := w.Write(signedMessage.Bytes()); err != nil {
+	if _, err := w.Write(message); err != nil {
 		return err
 	}
 	if err := w.Close(); err != nil {
@@ -187,11 +307,29 @@ func (m InternalDKIMMailer) Send(mentions []webmention.Mention) error {
 	return nil
 }
 
-func (m ExternalMailer) Send(mentions []webmention.Mention) error {
-	msg := gomail.NewMessage()
-	msg.SetHeader("From", m.From)
-	msg.SetHeader("To", m.To)
-	msg.SetHeader("Subject", m.SubjectLine(mentions))
-	msg.SetBody("text/plain", m.Body(mentions))
-	return m.Dialer.DialAndSend(msg)
+func (m InternalDKIMMailer) Send(mentions []webmention.Mention) (err error) {
+	for _, mail := range m.mails(mentions) {
+		var clearMessage, signedMessage bytes.Buffer
+		if _, werr := mail.message(m.From, m.SubjectLine, m.Body, m.AttachJSON).WriteTo(&clearMessage); werr != nil {
+			err = errors.Join(err, werr)
+			continue
+		}
+		if serr := dkim.Sign(&signedMessage, &clearMessage, m.DkimSignOpts); serr != nil {
+			err = errors.Join(err, serr)
+			continue
+		}
+		err = errors.Join(err, m.deliver(signedMessage.Bytes(), mail.recipients()))
+	}
+	return err
+}
+
+func (m ExternalMailer) Send(mentions []webmention.Mention) (err error) {
+	for _, mail := range m.mails(mentions) {
+		msg := mail.message(m.From, m.SubjectLine, m.Body, m.AttachJSON)
+		if len(mail.bcc) > 0 {
+			msg.SetHeader("Bcc", mail.bcc...) // not written out by gomail, only used for the envelope
+		}
+		err = errors.Join(err, m.Dialer.DialAndSend(msg))
+	}
+	return err
 }
@@ -0,0 +1,204 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+type (
+	// MailQueue defers delivery of mails that failed temporarily (e.g.,
+	// greylisting, or the server being unreachable), and retries them with
+	// exponential backoff, until they are delivered or MaxAge has passed.
+	// Permanent failures (5xx) are returned right away.
+	// If Path is set, deferred mails survive restarts.
+	MailQueue struct {
+		Sender Sender
+		// Path of the file deferred mails are persisted to (empty: memory only).
+		Path string
+		// MaxAge is how long to keep retrying a mail (default 3 days).
+		MaxAge time.Duration
+		// RetryDelay is the wait before the first retry, doubled with every
+		// further retry, up to an hour (default 5 minutes).
+		RetryDelay time.Duration
+
+		m       sync.Mutex
+		pending []deferredMail
+		stop    chan struct{}
+	}
+
+	deferredMail struct {
+		Mentions  []webmention.Mention `json:"mentions"`
+		Queued    time.Time            `json:"queued"`
+		Attempts  int                  `json:"attempts"`
+		NextRetry time.Time            `json:"next_retry"`
+		LastError string               `json:"last_error"`
+	}
+)
+
+// *MailQueue implements Sender
+var _ Sender = (*MailQueue)(nil)
+
+const maxMailRetryDelay = time.Hour
+
+// NewMailQueue creates a queue in front of sender, loading mails deferred
+// by a previous run from path (if not empty).
+func NewMailQueue(sender Sender, path string) (*MailQueue, error) {
+	q := &MailQueue{
+		Sender:     sender,
+		Path:       path,
+		MaxAge:     3 * 24 * time.Hour,
+		RetryDelay: 5 * time.Minute,
+		stop:       make(chan struct{}),
+	}
+	if path == "" {
+		return q, nil
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return q, nil
+		}
+		return nil, fmt.Errorf("mail queue: %w", err)
+	}
+	if err := json.Unmarshal(bs, &q.pending); err != nil {
+		return nil, fmt.Errorf("mail queue: %s: %w", path, err)
+	}
+	return q, nil
+}
+
+// Send tries to send the mentions right away, if that fails temporarily,
+// they are queued and nil is returned.
+func (q *MailQueue) Send(mentions []webmention.Mention) error {
+	err := q.Sender.Send(mentions)
+	if err == nil || !IsTemporary(err) {
+		return err
+	}
+	slog.Warn(fmt.Sprintf("mail queue: delivery deferred: %s", err), "mentions", len(mentions))
+	q.m.Lock()
+	defer q.m.Unlock()
+	now := time.Now()
+	q.pending = append(q.pending, deferredMail{
+		Mentions:  mentions,
+		Queued:    now,
+		Attempts:  1,
+		NextRetry: now.Add(q.RetryDelay),
+		LastError: err.Error(),
+	})
+	return q.persist()
+}
+
+// Pending returns the number of deferred mails.
+func (q *MailQueue) Pending() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.pending)
+}
+
+// Start retries deferred mails once they are due, until Stop is called.
+func (q *MailQueue) Start() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+			if err := q.Retry(false); err != nil {
+				slog.Error(fmt.Sprintf("mail queue: %s", err))
+			}
+		}
+	}
+}
+
+// Stop stops Start, deferred mails stay queued (in Path).
+func (q *MailQueue) Stop() {
+	close(q.stop)
+}
+
+// Retry sends all deferred mails that are due (or all of them, if force is set).
+// Mails that fail permanently, or for longer than MaxAge, are dropped and
+// their errors returned.
+func (q *MailQueue) Retry(force bool) (err error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	now := time.Now()
+	remaining := q.pending[:0]
+	for _, mail := range q.pending {
+		if !force && now.Before(mail.NextRetry) {
+			remaining = append(remaining, mail)
+			continue
+		}
+		sendErr := q.Sender.Send(mail.Mentions)
+		switch {
+		case sendErr == nil:
+			slog.Info("mail queue: deferred mail delivered", "attempts", mail.Attempts+1)
+		case !IsTemporary(sendErr):
+			err = errors.Join(err, fmt.Errorf("dropping mail queued at %s: %w", mail.Queued.Format(time.RFC3339), sendErr))
+		case now.Sub(mail.Queued) >= q.MaxAge:
+			err = errors.Join(err, fmt.Errorf("dropping mail queued at %s, retried for too long: %w", mail.Queued.Format(time.RFC3339), sendErr))
+		default:
+			delay := q.RetryDelay << min(mail.Attempts, 16)
+			mail.Attempts++
+			mail.NextRetry = now.Add(min(delay, maxMailRetryDelay))
+			mail.LastError = sendErr.Error()
+			remaining = append(remaining, mail)
+		}
+	}
+	q.pending = remaining
+	return errors.Join(err, q.persist())
+}
+
+// persist replaces the queue file atomically.
+func (q *MailQueue) persist() error {
+	if q.Path == "" {
+		return nil
+	}
+	bs, err := json.Marshal(q.pending)
+	if err != nil {
+		return fmt.Errorf("mail queue: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.Path), filepath.Base(q.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("mail queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return fmt.Errorf("mail queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("mail queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.Path); err != nil {
+		return fmt.Errorf("mail queue: %w", err)
+	}
+	return nil
+}
+
+// IsTemporary reports whether sending a mail failed temporarily: the
+// server answered with a 4xx code, or couldn't be reached.
+// Errors of an api (see IssueCommenter) are temporary if it is rate limited
+// or unavailable.
+func IsTemporary(err error) bool {
+	if isTemporaryStatus(err) {
+		return true
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return protoErr.Code >= 400 && protoErr.Code < 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
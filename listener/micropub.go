@@ -0,0 +1,115 @@
+package listener
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// MicropubPoster creates a post on your own site, through its Micropub
+// endpoint, whenever a notable mention arrives, bridging received mentions
+// into the site's own content, e.g., as a draft reply to every reply.
+type MicropubPoster struct {
+	// Endpoint is the Micropub endpoint of your site.
+	Endpoint string
+	// Token is an access token with the create scope.
+	Token string
+	// Notable selects the mentions that get a post, defaults to replies
+	// (see NotableTypes).
+	Notable func(webmention.Mention) bool
+	// Properties of the post created for a mention, form encoded, defaults
+	// to MicropubDraftReply.
+	Properties func(webmention.Mention) url.Values
+	HttpClient *http.Client
+}
+
+var (
+	// *MicropubPoster implements webmention.FallibleNotifier
+	_ webmention.FallibleNotifier = (*MicropubPoster)(nil)
+	// *MicropubPoster implements webmention.NamedNotifier
+	_ webmention.NamedNotifier = (*MicropubPoster)(nil)
+)
+
+// NotableTypes selects verified mentions of the given types, except self-mentions.
+func NotableTypes(types ...webmention.MentionType) func(webmention.Mention) bool {
+	return func(mention webmention.Mention) bool {
+		kind := mention.Type
+		if kind == "" {
+			kind = webmention.TypeMention
+		}
+		return mention.Status == webmention.StatusLink && !mention.SelfMention && slices.Contains(types, kind)
+	}
+}
+
+// MicropubDraftReply creates a draft replying to the source of the mention,
+// to be completed (or deleted) by you.
+func MicropubDraftReply(mention webmention.Mention) url.Values {
+	return url.Values{
+		"h":           {"entry"},
+		"post-status": {"draft"},
+		"in-reply-to": {mention.Source.String()},
+		"content":     {fmt.Sprintf("Reply to %s, which mentioned %s.", mention.Source, mention.Target)},
+	}
+}
+
+// Name identifies the poster in a webmention.NotificationLog.
+func (p *MicropubPoster) Name() string {
+	return "micropub"
+}
+
+func (p *MicropubPoster) Receive(mention webmention.Mention) {
+	if err := p.Notify(mention); err != nil {
+		slog.Error(err.Error(), "mention", mention)
+	}
+}
+
+// Notify creates the post, if the mention is notable, so that a
+// webmention.NotificationLog only records the mention once it has been created.
+// Errors are temporary (see IsTemporary), if the endpoint is unavailable or rate limited.
+func (p *MicropubPoster) Notify(mention webmention.Mention) error {
+	notable := p.Notable
+	if notable == nil {
+		notable = NotableTypes(webmention.TypeReply)
+	}
+	if !notable(mention) {
+		return nil
+	}
+	properties := p.Properties
+	if properties == nil {
+		properties = MicropubDraftReply
+	}
+	req, err := http.NewRequest(http.MethodPost, p.Endpoint, strings.NewReader(properties(mention).Encode()))
+	if err != nil {
+		return fmt.Errorf("micropub: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	client := p.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("micropub: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusAccepted {
+		var micropubErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&micropubErr)
+		message := strings.TrimSpace(micropubErr.Error + " " + micropubErr.Description)
+		return fmt.Errorf("micropub: %w", &statusError{resp.Status, resp.StatusCode, message})
+	}
+	slog.Info("micropub post created", "location", resp.Header.Get("Location"), "source", mention.Source.String())
+	return nil
+}
@@ -0,0 +1,190 @@
+package listener
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// A TokenSource returns a valid OAuth2 access token.
+	TokenSource interface {
+		Token() (string, error)
+	}
+
+	// XOAuth2 authenticates to an SMTP server with an OAuth2 access token,
+	// as supported by Gmail and Office 365.
+	// Use it as the Auth of a gomail.Dialer.
+	// A fresh token is requested from Tokens for every connection.
+	XOAuth2 struct {
+		User   string
+		Tokens TokenSource
+	}
+
+	// OAuth2Client requests access tokens from an OAuth2 token endpoint,
+	// using a refresh token if configured, or else the client credentials grant.
+	// Tokens are cached until shortly before they expire.
+	//
+	// Some providers rotate the refresh token, invalidating the old one as
+	// soon as a new one is issued. Set RefreshTokenFile so that the rotated
+	// token survives a restart: if the file exists, its content is used
+	// instead of RefreshToken, and every rotated token is written to it.
+	OAuth2Client struct {
+		TokenURL         string
+		ClientID         string
+		ClientSecret     string
+		RefreshToken     string
+		RefreshTokenFile string
+		Scopes           []string
+		HttpClient       *http.Client
+
+		m       sync.Mutex
+		token   string
+		expires time.Time
+	}
+)
+
+var (
+	// XOAuth2 implements smtp.Auth
+	_ smtp.Auth = XOAuth2{}
+	// *OAuth2Client implements TokenSource
+	_ TokenSource = (*OAuth2Client)(nil)
+)
+
+func (a XOAuth2) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("xoauth2: refusing to send token over an unencrypted connection")
+	}
+	token, err := a.Tokens.Token()
+	if err != nil {
+		return "", nil, fmt.Errorf("xoauth2: %w", err)
+	}
+	return "XOAUTH2", []byte("user=" + a.User + "\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+func (a XOAuth2) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// the server sends a JSON error as challenge, and expects an empty
+		// response before failing the authentication
+		return []byte{}, nil
+	}
+	return nil, nil
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// Token returns the cached access token, or requests a new one.
+func (c *OAuth2Client) Token() (string, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.token != "" && time.Now().Before(c.expires) {
+		return c.token, nil
+	}
+	if err := c.loadRefreshToken(); err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"client_id":     {c.ClientID},
+		"client_secret": {c.ClientSecret},
+	}
+	if c.RefreshToken != "" {
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", c.RefreshToken)
+	} else {
+		form.Set("grant_type", "client_credentials")
+	}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	client := c.HttpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.PostForm(c.TokenURL, form)
+	if err != nil {
+		return "", fmt.Errorf("oauth2: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("oauth2: %w", err)
+	}
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error"`
+		Description  string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("oauth2: %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("oauth2: %s: %s %s", resp.Status, token.Error, token.Description)
+	}
+	c.token = token.AccessToken
+	c.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	if token.RefreshToken != "" && token.RefreshToken != c.RefreshToken {
+		c.RefreshToken = token.RefreshToken // the refresh token was rotated
+		if err := c.storeRefreshToken(); err != nil {
+			// the access token is still good, but the next restart will
+			// use a refresh token that is no longer valid
+			slog.Error(err.Error())
+		}
+	}
+	return c.token, nil
+}
+
+// loadRefreshToken reads the refresh token from RefreshTokenFile, if it exists.
+func (c *OAuth2Client) loadRefreshToken() error {
+	if c.RefreshTokenFile == "" {
+		return nil
+	}
+	bs, err := os.ReadFile(c.RefreshTokenFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("oauth2: %w", err)
+	}
+	if token := strings.TrimSpace(string(bs)); token != "" {
+		c.RefreshToken = token
+	}
+	return nil
+}
+
+// storeRefreshToken replaces RefreshTokenFile atomically.
+func (c *OAuth2Client) storeRefreshToken() error {
+	if c.RefreshTokenFile == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.RefreshTokenFile), filepath.Base(c.RefreshTokenFile)+".*")
+	if err != nil {
+		return fmt.Errorf("oauth2: storing refresh token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(c.RefreshToken); err != nil {
+		tmp.Close()
+		return fmt.Errorf("oauth2: storing refresh token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("oauth2: storing refresh token: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.RefreshTokenFile); err != nil {
+		return fmt.Errorf("oauth2: storing refresh token: %w", err)
+	}
+	return nil
+}
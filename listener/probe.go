@@ -0,0 +1,196 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// The probes only check what they can without side effects: no mail is
+// sent, no comment or post created.
+var (
+	// Mailer implements webmention.Prober
+	_ webmention.Prober = Mailer{}
+	// *ReportAggregator implements webmention.Prober
+	_ webmention.Prober = (*ReportAggregator)(nil)
+	// *Batcher implements webmention.Prober
+	_ webmention.Prober = (*Batcher)(nil)
+	// *MailQueue implements webmention.Prober
+	_ webmention.Prober = (*MailQueue)(nil)
+	// Notifiers implements webmention.Prober
+	_ webmention.Prober = Notifiers{}
+	// InternalMailer implements webmention.Prober
+	_ webmention.Prober = InternalMailer{}
+	// ExternalMailer implements webmention.Prober
+	_ webmention.Prober = ExternalMailer{}
+	// *ExecHook implements webmention.Prober
+	_ webmention.Prober = (*ExecHook)(nil)
+	// *DataFiles implements webmention.Prober
+	_ webmention.Prober = (*DataFiles)(nil)
+	// *IssueCommenter implements webmention.Prober
+	_ webmention.Prober = (*IssueCommenter)(nil)
+	// *MicropubPoster implements webmention.Prober
+	_ webmention.Prober = (*MicropubPoster)(nil)
+)
+
+// probe probes v, if it is a webmention.Prober.
+func probe(ctx context.Context, v any) error {
+	if prober, ok := v.(webmention.Prober); ok {
+		return prober.Probe(ctx)
+	}
+	return nil
+}
+
+func (m Mailer) Probe(ctx context.Context) error {
+	return probe(ctx, m.Sender)
+}
+
+func (m *ReportAggregator) Probe(ctx context.Context) error {
+	return probe(ctx, m.Sender)
+}
+
+func (b *Batcher) Probe(ctx context.Context) error {
+	return probe(ctx, b.Sender)
+}
+
+func (q *MailQueue) Probe(ctx context.Context) error {
+	return probe(ctx, q.Sender)
+}
+
+func (n Notifiers) Probe(ctx context.Context) (err error) {
+	for _, notifier := range n {
+		err = errors.Join(err, probe(ctx, notifier))
+	}
+	return err
+}
+
+// Probe connects to the server at ToAddr, and greets it.
+// InternalDKIMMailer is probed the same way.
+func (m InternalMailer) Probe(ctx context.Context) error {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", m.ToAddr)
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	host, _, _ := net.SplitHostPort(m.ToAddr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return fmt.Errorf("smtp: %s: %w", m.ToAddr, err)
+	}
+	defer c.Close()
+	if err := c.Hello(m.FromAddr); err != nil {
+		return fmt.Errorf("smtp: %s: %w", m.ToAddr, err)
+	}
+	return c.Quit()
+}
+
+// Probe connects to the server, and authenticates, if configured to.
+func (m ExternalMailer) Probe(ctx context.Context) error {
+	if m.Dialer == nil {
+		return errors.New("smtp: no dialer configured")
+	}
+	// gomail doesn't take a context
+	done := make(chan error, 1)
+	go func() {
+		closer, err := m.Dialer.Dial()
+		if err == nil {
+			err = closer.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("smtp: %s: %w", m.Dialer.Host, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("smtp: %s: %w", m.Dialer.Host, ctx.Err())
+	}
+}
+
+// Probe checks that the command can be found.
+func (hook *ExecHook) Probe(ctx context.Context) error {
+	if len(hook.Command) == 0 {
+		return errors.New("exec hook: no command configured")
+	}
+	if _, err := exec.LookPath(hook.Command[0]); err != nil {
+		return fmt.Errorf("exec hook: %w", err)
+	}
+	return nil
+}
+
+// Probe checks that Dir is writable, and inside a git repository, if Git is set.
+func (d *DataFiles) Probe(ctx context.Context) error {
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return fmt.Errorf("data files: %w", err)
+	}
+	tmp, err := os.CreateTemp(d.Dir, ".probe.*")
+	if err != nil {
+		return fmt.Errorf("data files: %w", err)
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	if d.Git {
+		cmd := exec.CommandContext(ctx, "git", "-C", filepath.Clean(d.Dir), "rev-parse", "--git-dir")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("data files: git: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// Probe fetches the issue, which checks the token, too.
+func (c *IssueCommenter) Probe(ctx context.Context) error {
+	endpoint := fmt.Sprintf("%s/repos/%s/issues/%d", strings.TrimSuffix(c.API, "/"), c.Repo, c.Issue)
+	if err := probeGet(ctx, c.HttpClient, endpoint, "token "+c.Token); err != nil {
+		return fmt.Errorf("issue comment: %w", err)
+	}
+	return nil
+}
+
+// Probe queries the configuration of the endpoint, which checks the token, too.
+func (p *MicropubPoster) Probe(ctx context.Context) error {
+	endpoint := p.Endpoint + "?q=config"
+	if strings.Contains(p.Endpoint, "?") {
+		endpoint = p.Endpoint + "&q=config"
+	}
+	if err := probeGet(ctx, p.HttpClient, endpoint, "Bearer "+p.Token); err != nil {
+		return fmt.Errorf("micropub: %w", err)
+	}
+	return nil
+}
+
+// probeGet requests endpoint, and expects 200.
+func probeGet(ctx context.Context, client *http.Client, endpoint, authorization string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", authorization)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &statusError{resp.Status, resp.StatusCode, ""}
+	}
+	return nil
+}
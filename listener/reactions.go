@@ -0,0 +1,115 @@
+package listener
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// ReactionGroup are reactions (likes, reposts, or bookmarks) of the same
+// type to the same target, that are reported as a single line, e.g., after
+// a post was boosted on Mastodon, and Bridgy sent a like for every one of
+// its likes.
+type ReactionGroup struct {
+	Type     webmention.MentionType
+	Target   *url.URL
+	Mentions []webmention.Mention
+}
+
+// reactionTypes are the types of mentions that are collapsed.
+var reactionTypes = map[webmention.MentionType]string{
+	webmention.TypeLike:     "like",
+	webmention.TypeRepost:   "repost",
+	webmention.TypeBookmark: "bookmark",
+}
+
+// CollapseReactions groups the reactions of the same type to the same
+// target, if there are at least threshold of them, the others are returned
+// as is, in their original order.
+// Replies and mentions are never collapsed, nor are links that got removed.
+// A threshold <= 0 disables collapsing.
+func CollapseReactions(mentions []webmention.Mention, threshold int) (single []webmention.Mention, groups []ReactionGroup) {
+	if threshold <= 0 {
+		return mentions, nil
+	}
+	type key struct {
+		kind   webmention.MentionType
+		target string
+	}
+	counts := map[key]int{}
+	for _, mention := range mentions {
+		if isReaction(mention) {
+			counts[key{mention.Type, mention.Target.String()}]++
+		}
+	}
+	index := map[key]int{}
+	for _, mention := range mentions {
+		k := key{mention.Type, mention.Target.String()}
+		if !isReaction(mention) || counts[k] < threshold {
+			single = append(single, mention)
+			continue
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, ReactionGroup{Type: mention.Type, Target: mention.Target})
+		}
+		groups[i].Mentions = append(groups[i].Mentions, mention)
+	}
+	return single, groups
+}
+
+func isReaction(mention webmention.Mention) bool {
+	_, ok := reactionTypes[mention.Type]
+	return ok && mention.Status == webmention.StatusLink
+}
+
+// String describes the group, e.g., 42 likes on /post/x
+func (g ReactionGroup) String() string {
+	noun := reactionTypes[g.Type]
+	if len(g.Mentions) != 1 {
+		noun += "s"
+	}
+	target := g.Target.String()
+	if g.Target.Path != "" {
+		target = g.Target.Path
+	}
+	return fmt.Sprintf("%d %s on %s", len(g.Mentions), noun, target)
+}
+
+// CollapsedSubjectLine wraps subjectLine, a batch that consists of a single
+// group of reactions is summarized by the group, e.g., 42 likes on /post/x
+func CollapsedSubjectLine(threshold int, subjectLine func([]webmention.Mention) string) func([]webmention.Mention) string {
+	return func(mentions []webmention.Mention) string {
+		single, groups := CollapseReactions(mentions, threshold)
+		if len(single) == 0 && len(groups) == 1 {
+			return groups[0].String()
+		}
+		return subjectLine(mentions)
+	}
+}
+
+// CollapsedBody wraps body, so that groups of at least threshold reactions
+// are reported as a line each, e.g., 42 likes on /post/x, followed by the
+// other mentions as formatted by body.
+// Reactions are only collapsed within a batch, use it for the Body of a
+// mailer or IssueCommenter behind a Batcher.
+func CollapsedBody(threshold int, body func([]webmention.Mention) string) func([]webmention.Mention) string {
+	return func(mentions []webmention.Mention) string {
+		single, groups := CollapseReactions(mentions, threshold)
+		if len(groups) == 0 {
+			return body(mentions)
+		}
+		var builder strings.Builder
+		for _, group := range groups {
+			builder.WriteString(group.String() + "\n")
+		}
+		if len(single) > 0 {
+			builder.WriteString("\n" + body(single))
+		}
+		return builder.String()
+	}
+}
@@ -0,0 +1,200 @@
+package listener
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+type (
+	// SummaryPeriod determines how often a Summarizer sends its report.
+	SummaryPeriod int
+
+	// Summarizer reports the mentions of a period (a calendar month or
+	// year): at the end of each period it loads the valid mentions received
+	// during the period from Storage, and hands them to Sender all at once.
+	// Since the report is built from Storage, restarting the process in the
+	// middle of a period doesn't lose any mentions.
+	// Configure the Sender (e.g., an ExternalMailer) with SummarySubjectLine
+	// and SummaryBody to get a statistical report instead of a plain list.
+	Summarizer struct {
+		Period   SummaryPeriod
+		Storage  webmention.Storage
+		Sender   Sender
+		stop     chan struct{}
+		stopOnce sync.Once
+	}
+
+	// Summary holds statistics about a set of mentions.
+	Summary struct {
+		From, To   time.Time
+		Total      int
+		TopTargets []Count
+		TopDomains []Count
+		ByType     []Count
+	}
+
+	// Count is the number of occurrences of Key.
+	Count struct {
+		Key   string
+		Count int
+	}
+)
+
+const (
+	Monthly SummaryPeriod = iota
+	Yearly
+)
+
+// summaryTopN limits the number of entries in the top lists of a summary.
+const summaryTopN = 10
+
+func NewSummarizer(period SummaryPeriod, storage webmention.Storage, sender Sender) *Summarizer {
+	return &Summarizer{
+		Period:  period,
+		Storage: storage,
+		Sender:  sender,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Begin returns the start of the period containing t.
+func (p SummaryPeriod) Begin(t time.Time) time.Time {
+	switch p {
+	case Yearly:
+		return time.Date(t.Year(), time.January, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// Next returns the start of the period following t.
+func (p SummaryPeriod) Next(t time.Time) time.Time {
+	switch p {
+	case Yearly:
+		return time.Date(t.Year()+1, time.January, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+func (p SummaryPeriod) String() string {
+	switch p {
+	case Yearly:
+		return "yearly"
+	default:
+		return "monthly"
+	}
+}
+
+// Start sends a report at the end of every period, until Stop is called.
+func (s *Summarizer) Start() {
+	for {
+		end := s.Period.Next(time.Now())
+		timer := time.NewTimer(time.Until(end))
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			if err := s.Send(s.Period.Begin(end.Add(-time.Nanosecond)), end); err != nil {
+				slog.Error(fmt.Sprintf("summarizer: failed to send %s report: %s", s.Period, err))
+			}
+		}
+	}
+}
+
+// Stop ends the loop started by Start.
+func (s *Summarizer) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Send sends a report of the valid mentions received at or after from, and before to.
+func (s *Summarizer) Send(from, to time.Time) error {
+	mentions, err := s.Storage.Mentions(webmention.MentionFilter{
+		Since:  from,
+		Until:  to,
+		Status: webmention.StatusLink,
+	})
+	if err != nil {
+		return fmt.Errorf("summarizer: %w", err)
+	}
+	if len(mentions) <= 0 {
+		return nil // not an error, just do nothing
+	}
+	return s.Sender.Send(mentions)
+}
+
+// Summarize computes statistics over mentions.
+func Summarize(mentions []webmention.Mention) Summary {
+	summary := Summary{Total: len(mentions)}
+	targets := map[string]int{}
+	domains := map[string]int{}
+	types := map[string]int{}
+	for _, mention := range mentions {
+		if summary.From.IsZero() || mention.Received.Before(summary.From) {
+			summary.From = mention.Received
+		}
+		if mention.Received.After(summary.To) {
+			summary.To = mention.Received
+		}
+		targets[mention.Target.String()]++
+		domains[mention.Source.Hostname()]++
+		typ := mention.Type
+		if typ == "" {
+			typ = webmention.TypeMention
+		}
+		types[string(typ)]++
+	}
+	summary.TopTargets = topCounts(targets, summaryTopN)
+	summary.TopDomains = topCounts(domains, summaryTopN)
+	summary.ByType = topCounts(types, -1)
+	return summary
+}
+
+// topCounts returns the n most frequent keys, or all of them if n < 0.
+func topCounts(counts map[string]int, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for k, c := range counts {
+		top = append(top, Count{Key: k, Count: c})
+	}
+	slices.SortFunc(top, func(a, b Count) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	if n >= 0 && len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+func SummarySubjectLine(mentions []webmention.Mention) string {
+	summary := Summarize(mentions)
+	return fmt.Sprintf("Webmention report %s to %s: %d mentions", summary.From.Format(time.DateOnly), summary.To.Format(time.DateOnly), summary.Total)
+}
+
+func SummaryBody(mentions []webmention.Mention) string {
+	summary := Summarize(mentions)
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%d mentions received between %s and %s.\n", summary.Total, summary.From.Format(time.DateOnly), summary.To.Format(time.DateOnly)))
+	writeCounts := func(title string, counts []Count) {
+		builder.WriteString(fmt.Sprintf("\n%s:\n", title))
+		for _, c := range counts {
+			builder.WriteString(fmt.Sprintf("  %5d  %s\n", c.Count, c.Key))
+		}
+	}
+	writeCounts("Most mentioned posts", summary.TopTargets)
+	writeCounts("Top referring domains", summary.TopDomains)
+	writeCounts("By type", summary.ByType)
+	return builder.String()
+}
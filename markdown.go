@@ -0,0 +1,189 @@
+package webmention
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var (
+	// mdRefDefinition matches a link reference definition: [label]: destination "title"
+	mdRefDefinition = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*(<[^>]*>|\S+)`)
+	// mdAutolink matches an autolink: <https://example.com>
+	mdAutolink = regexp.MustCompile(`<([A-Za-z][A-Za-z0-9+.-]{1,31}:[^<>\s]*)>`)
+	// mdBareLink matches an url in the text, which GitHub flavored markdown turns into a link
+	mdBareLink = regexp.MustCompile(`https?://[^\s<>]+`)
+	// mdFence matches the start (or end) of a fenced code block
+	mdFence = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+)
+
+// MarkdownHandler searches a markdown document for links to target.
+// Links are inline links and images ([text](url), ![alt](url)), reference
+// links ([text][label], with a [label]: url definition), autolinks (<url>),
+// and bare urls, as linked by GitHub flavored markdown.
+// Urls in code spans and fenced code blocks are not links, and neither are
+// urls that merely start with the target.
+func MarkdownHandler(content io.Reader, target URL) (status Status, err error) {
+	var (
+		links       []string
+		definitions = map[string]string{}
+		references  = map[string]bool{}
+		fence       string
+	)
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(nil, maxSourceSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := mdFence.FindStringSubmatch(line); m != nil {
+			switch {
+			case fence == "":
+				fence = m[1]
+			case m[1][0] == fence[0] && len(m[1]) >= len(fence) && strings.TrimSpace(line[len(m[0]):]) == "":
+				fence = ""
+			}
+			continue
+		}
+		if fence != "" {
+			continue
+		}
+		if m := mdRefDefinition.FindStringSubmatch(line); m != nil {
+			label := mdLabel(m[1])
+			if _, ok := definitions[label]; !ok { // the first definition wins
+				definitions[label] = strings.Trim(m[2], "<>")
+			}
+			continue
+		}
+		line = mdStripCodeSpans(line)
+		links = append(links, mdInlineLinks(line)...)
+		for _, m := range mdAutolink.FindAllStringSubmatch(line, -1) {
+			links = append(links, m[1])
+		}
+		for _, link := range mdBareLink.FindAllString(line, -1) {
+			links = append(links, mdTrimBareLink(link))
+		}
+		for _, label := range mdReferences(line) {
+			references[label] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return status, err
+	}
+	for label := range references {
+		if destination, ok := definitions[label]; ok {
+			links = append(links, destination)
+		}
+	}
+	for _, link := range links {
+		if strings.EqualFold(link, target.String()) {
+			return StatusLink, nil
+		}
+	}
+	return StatusNoLink, nil
+}
+
+// mdLabel normalizes a reference label, labels are matched case-insensitively
+// and with collapsed whitespace.
+func mdLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// mdStripCodeSpans removes `code spans` from line.
+func mdStripCodeSpans(line string) string {
+	var builder strings.Builder
+	for {
+		start := strings.IndexByte(line, '`')
+		if start < 0 {
+			builder.WriteString(line)
+			return builder.String()
+		}
+		builder.WriteString(line[:start])
+		ticks := len(line[start:]) - len(strings.TrimLeft(line[start:], "`"))
+		delimiter := line[start : start+ticks]
+		rest := line[start+ticks:]
+		end := strings.Index(rest, delimiter)
+		if end < 0 { // not a code span, just backticks
+			builder.WriteString(delimiter)
+			line = rest
+			continue
+		}
+		line = rest[end+ticks:]
+	}
+}
+
+// mdInlineLinks returns the destinations of all [text](destination) links in line.
+func mdInlineLinks(line string) (links []string) {
+	for {
+		i := strings.Index(line, "](")
+		if i < 0 {
+			return links
+		}
+		line = strings.TrimLeft(line[i+2:], " \t")
+		if strings.HasPrefix(line, "<") {
+			if end := strings.IndexByte(line, '>'); end >= 0 {
+				links = append(links, line[1:end])
+				line = line[end+1:]
+			}
+			continue
+		}
+		// destinations may contain balanced parentheses, e.g., wikipedia urls
+		depth, end := 0, len(line)
+	scan:
+		for j, r := range line {
+			switch {
+			case r == '(':
+				depth++
+			case r == ')' && depth == 0, r == ' ', r == '\t':
+				end = j
+				break scan
+			case r == ')':
+				depth--
+			}
+		}
+		if end > 0 {
+			links = append(links, line[:end])
+		}
+		line = line[end:]
+	}
+}
+
+// mdReferences returns the labels of all reference links in line, that is
+// [text][label], [label][], and [label].
+func mdReferences(line string) (labels []string) {
+	for {
+		start := strings.IndexByte(line, '[')
+		if start < 0 {
+			return labels
+		}
+		end := strings.IndexByte(line[start:], ']')
+		if end < 0 {
+			return labels
+		}
+		text := line[start+1 : start+end]
+		line = line[start+end+1:]
+		if strings.HasPrefix(line, "[") {
+			if close := strings.IndexByte(line, ']'); close >= 0 {
+				if label := line[1:close]; label != "" {
+					text = label
+				}
+				line = line[close+1:]
+			}
+		}
+		labels = append(labels, mdLabel(text))
+	}
+}
+
+// mdTrimBareLink removes trailing punctuation from a bare url, as well as an
+// unbalanced closing parenthesis, e.g., of (see https://example.com).
+func mdTrimBareLink(link string) string {
+	for {
+		trimmed := strings.TrimRight(link, ".,:;!?\"'*_~")
+		if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if trimmed == link {
+			return link
+		}
+		link = trimmed
+	}
+}
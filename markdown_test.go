@@ -0,0 +1,40 @@
+package webmention_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestMarkdownHandler(t *testing.T) {
+	target := must(url.Parse("https://example.com/posts/(draft)/1"))
+	for _, tc := range []struct {
+		name     string
+		markdown string
+		status   webmention.Status
+	}{
+		{"inline", "I liked [this post](https://example.com/posts/(draft)/1 \"title\").", webmention.StatusLink},
+		{"inline angle brackets", "See [here](<https://example.com/posts/(draft)/1>).", webmention.StatusLink},
+		{"image", "![screenshot](https://example.com/posts/(draft)/1)", webmention.StatusLink},
+		{"reference", "As [they said][post].\n\n[post]: https://example.com/posts/(draft)/1 \"The Post\"", webmention.StatusLink},
+		{"collapsed reference", "As [The Post][] says.\n\n[the  post]: <https://example.com/posts/(draft)/1>", webmention.StatusLink},
+		{"unused reference", "Nothing here.\n\n[post]: https://example.com/posts/(draft)/1", webmention.StatusNoLink},
+		{"autolink", "<https://example.com/posts/(draft)/1>", webmention.StatusLink},
+		{"bare", "Read it (at https://example.com/posts/(draft)/1).", webmention.StatusLink},
+		{"prefix only", "[other](https://example.com/posts/(draft)/10)", webmention.StatusNoLink},
+		{"code span", "Use `[link](https://example.com/posts/(draft)/1)` for links.", webmention.StatusNoLink},
+		{"code block", "```md\n[link](https://example.com/posts/(draft)/1)\n```\n", webmention.StatusNoLink},
+		{"after code block", "~~~\ncode\n~~~\n[link](https://example.com/posts/(draft)/1)", webmention.StatusLink},
+	} {
+		status, err := webmention.MarkdownHandler(strings.NewReader(tc.markdown), target)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		if status != tc.status {
+			t.Errorf("%s: got: %s, want: %s", tc.name, status, tc.status)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package webmention
+
+import (
+	"maps"
+	"path"
+	"strings"
+)
+
+// DefaultExtensionHints map the file extension of a source url to the media
+// type it most likely has.
+var DefaultExtensionHints = map[string]string{
+	".html":     "text/html",
+	".htm":      "text/html",
+	".shtml":    "text/html",
+	".txt":      "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+}
+
+// genericMediaTypes say nothing about the content, servers send them if they don't know better.
+var genericMediaTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/binary":       true,
+	"application/unknown":      true,
+}
+
+// WithExtensionHints configures which media type to assume for a source,
+// based on the extension of its url, if the source server sends a generic
+// (e.g., application/octet-stream) or no Content-Type at all.
+// Keys are extensions including the dot, e.g., ".html".
+// Per default, DefaultExtensionHints are used, pass nil to disable the heuristic.
+//
+// If content sniffing is enabled as well (see WithContentSniffing), and it
+// detects a different type than the extension suggests, the type whose
+// handler has the higher qweight (see WithMediaHandler) is used.
+func WithExtensionHints(hints map[string]string) ReceiverOption {
+	return func(r *Receiver) {
+		r.extensionHints = maps.Clone(hints)
+	}
+}
+
+// qweight returns the weight of the handler registered for mime, or -1 if there is none.
+func (mr mediaRegister) qweight(mime string) float64 {
+	for _, h := range mr {
+		if h.name == mime {
+			return h.qweight
+		}
+	}
+	return -1
+}
+
+// extensionHint returns the media type suggested by the extension of source,
+// if the type has a registered handler.
+func (receiver *Receiver) extensionHint(source URL) string {
+	mime, ok := receiver.extensionHints[strings.ToLower(path.Ext(source.Path))]
+	if !ok {
+		return ""
+	}
+	if _, ok := receiver.mediaHandler.Get(mime); !ok {
+		return ""
+	}
+	return mime
+}
+
+// preferredType returns whichever of the inferred types a has handler with
+// the higher qweight, on a tie a is preferred.
+func (mr mediaRegister) preferredType(a, b string) string {
+	if mr.qweight(b) > mr.qweight(a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,258 @@
+package webmention
+
+import (
+	"maps"
+	"slices"
+	"sync"
+)
+
+type (
+	// MemoryStorage is a Storage that is kept in memory, e.g., for tests,
+	// or for applications that persist mentions themselves (see Snapshot).
+	MemoryStorage struct {
+		m        sync.Mutex
+		mentions []Mention // latest version of every mention, in the order they were first stored
+		index    map[mentionCacheEntry]int
+	}
+
+	// MemoryPersister is a ContentPersister that is kept in memory.
+	MemoryPersister struct {
+		m          sync.Mutex
+		targets    map[string][]URL
+		hashes     map[string]string
+		deliveries map[mentionCacheEntry][]Delivery
+		endpoints  map[string]EndpointRecord
+	}
+
+	// PersisterSnapshot is a copy of everything recorded by a MemoryPersister.
+	PersisterSnapshot struct {
+		// Targets and Hashes by source url.
+		Targets map[string][]URL
+		Hashes  map[string]string
+		// Deliveries in the order they were recorded.
+		Deliveries []Delivery
+		// Endpoints by target url.
+		Endpoints map[string]EndpointRecord
+	}
+)
+
+var (
+	// *MemoryStorage implements CountingStorage
+	_ CountingStorage = (*MemoryStorage)(nil)
+	// *MemoryPersister implements ContentPersister
+	_ ContentPersister = (*MemoryPersister)(nil)
+)
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{index: map[mentionCacheEntry]int{}}
+}
+
+// cloneMention returns a copy of mention that doesn't share its urls or extensions.
+func cloneMention(mention Mention) Mention {
+	mention.Source = cloneURL(mention.Source)
+	mention.Target = cloneURL(mention.Target)
+	mention.SourceEndpoint = cloneURL(mention.SourceEndpoint)
+	mention.SubmittedSource = cloneURL(mention.SubmittedSource)
+	mention.Extensions = maps.Clone(mention.Extensions)
+	return mention
+}
+
+func cloneURL(u URL) URL {
+	if u == nil {
+		return nil
+	}
+	clone := *u
+	if u.User != nil {
+		user := *u.User
+		clone.User = &user
+	}
+	return &clone
+}
+
+func (s *MemoryStorage) Store(mention Mention) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	key := mentionCacheEntry{source: mention.Source.String(), target: mention.Target.String()}
+	if i, ok := s.index[key]; ok {
+		s.mentions[i] = cloneMention(mention)
+		return nil
+	}
+	s.index[key] = len(s.mentions)
+	s.mentions = append(s.mentions, cloneMention(mention))
+	return nil
+}
+
+func (s *MemoryStorage) Mention(source, target string) (Mention, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	i, ok := s.index[mentionCacheEntry{source: source, target: target}]
+	if !ok {
+		return Mention{}, false, nil
+	}
+	return cloneMention(s.mentions[i]), true, nil
+}
+
+func (s *MemoryStorage) Mentions(filter MentionFilter) ([]Mention, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	var mentions []Mention
+	for _, mention := range s.mentions {
+		if filter.Matches(mention) {
+			mentions = append(mentions, cloneMention(mention))
+		}
+	}
+	slices.SortStableFunc(mentions, func(a, b Mention) int {
+		return a.Received.Compare(b.Received)
+	})
+	return mentions, nil
+}
+
+func (s *MemoryStorage) Counts(target string) (Counts, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	var counts Counts
+	for _, mention := range s.mentions {
+		if mention.Target.String() == target {
+			counts.Add(mention)
+		}
+	}
+	return counts, nil
+}
+
+// Snapshot returns a copy of all stored mentions, ordered by the time they
+// were received.
+func (s *MemoryStorage) Snapshot() []Mention {
+	mentions, _ := s.Mentions(MentionFilter{})
+	return mentions
+}
+
+// Restore replaces all stored mentions with those of a snapshot.
+func (s *MemoryStorage) Restore(snapshot []Mention) {
+	restored := NewMemoryStorage()
+	for _, mention := range snapshot {
+		restored.Store(mention)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.mentions, s.index = restored.mentions, restored.index
+}
+
+func NewMemoryPersister() *MemoryPersister {
+	return &MemoryPersister{
+		targets:    map[string][]URL{},
+		hashes:     map[string]string{},
+		deliveries: map[mentionCacheEntry][]Delivery{},
+		endpoints:  map[string]EndpointRecord{},
+	}
+}
+
+func cloneURLs(urls []URL) []URL {
+	if urls == nil {
+		return nil
+	}
+	clones := make([]URL, len(urls))
+	for i, u := range urls {
+		clones[i] = cloneURL(u)
+	}
+	return clones
+}
+
+func (p *MemoryPersister) Targets(source URL) ([]URL, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return cloneURLs(p.targets[source.String()]), nil
+}
+
+func (p *MemoryPersister) SetTargets(source URL, targets []URL) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.targets[source.String()] = cloneURLs(targets)
+	return nil
+}
+
+func (p *MemoryPersister) ContentHash(source URL) (string, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.hashes[source.String()], nil
+}
+
+func (p *MemoryPersister) SetContentHash(source URL, hash string) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.hashes[source.String()] = hash
+	return nil
+}
+
+func (p *MemoryPersister) Deliveries(source, target URL) ([]Delivery, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return slices.Clone(p.deliveries[mentionCacheEntry{source: source.String(), target: target.String()}]), nil
+}
+
+// RecordDelivery keeps all deliveries, memory use grows with every mention sent.
+func (p *MemoryPersister) RecordDelivery(delivery Delivery) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	key := mentionCacheEntry{source: delivery.Source, target: delivery.Target}
+	p.deliveries[key] = append(p.deliveries[key], delivery)
+	return nil
+}
+
+func (p *MemoryPersister) Endpoint(target URL) (EndpointRecord, bool, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	record, ok := p.endpoints[target.String()]
+	return record, ok, nil
+}
+
+func (p *MemoryPersister) RecordEndpoint(record EndpointRecord) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.endpoints[record.Target] = record
+	return nil
+}
+
+// Snapshot returns a copy of everything recorded.
+func (p *MemoryPersister) Snapshot() PersisterSnapshot {
+	p.m.Lock()
+	defer p.m.Unlock()
+	snapshot := PersisterSnapshot{
+		Targets:   make(map[string][]URL, len(p.targets)),
+		Hashes:    maps.Clone(p.hashes),
+		Endpoints: maps.Clone(p.endpoints),
+	}
+	for source, targets := range p.targets {
+		snapshot.Targets[source] = cloneURLs(targets)
+	}
+	for _, deliveries := range p.deliveries {
+		snapshot.Deliveries = append(snapshot.Deliveries, deliveries...)
+	}
+	slices.SortStableFunc(snapshot.Deliveries, func(a, b Delivery) int {
+		return a.Time.Compare(b.Time)
+	})
+	return snapshot
+}
+
+// Restore replaces everything recorded with a snapshot.
+func (p *MemoryPersister) Restore(snapshot PersisterSnapshot) {
+	targets := make(map[string][]URL, len(snapshot.Targets))
+	for source, urls := range snapshot.Targets {
+		targets[source] = cloneURLs(urls)
+	}
+	hashes := maps.Clone(snapshot.Hashes)
+	if hashes == nil {
+		hashes = map[string]string{}
+	}
+	endpoints := maps.Clone(snapshot.Endpoints)
+	if endpoints == nil {
+		endpoints = map[string]EndpointRecord{}
+	}
+	deliveries := map[mentionCacheEntry][]Delivery{}
+	for _, delivery := range snapshot.Deliveries {
+		key := mentionCacheEntry{source: delivery.Source, target: delivery.Target}
+		deliveries[key] = append(deliveries[key], delivery)
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.targets, p.hashes, p.deliveries, p.endpoints = targets, hashes, deliveries, endpoints
+}
@@ -0,0 +1,220 @@
+package webmention
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// metrics counts requests and processed mentions.
+	metrics struct {
+		m        sync.Mutex
+		requests map[int]uint64
+		states   map[ProcessingState]uint64
+		handlers map[string]*MediaHandlerStats
+	}
+
+	// MediaHandlerStats summarize the work of the media handler of one media type.
+	MediaHandlerStats struct {
+		MediaType string
+		// Links, NoLinks, and Errors count the calls by their outcome.
+		Links, NoLinks, Errors uint64
+		// Duration is the time spent in the handler, over all calls.
+		Duration time.Duration
+		// Bytes is the size of all sources passed to the handler.
+		Bytes uint64
+	}
+)
+
+func (m *metrics) request(code int) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.requests == nil {
+		m.requests = map[int]uint64{}
+	}
+	m.requests[code]++
+}
+
+func (m *metrics) processed(state ProcessingState) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.states == nil {
+		m.states = map[ProcessingState]uint64{}
+	}
+	m.states[state]++
+}
+
+// handled records a call of the media handler of mediaType.
+func (m *metrics) handled(mediaType string, status Status, err error, d time.Duration, size int) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.handlers == nil {
+		m.handlers = map[string]*MediaHandlerStats{}
+	}
+	stats, ok := m.handlers[mediaType]
+	if !ok {
+		stats = &MediaHandlerStats{MediaType: mediaType}
+		m.handlers[mediaType] = stats
+	}
+	switch {
+	case err != nil:
+		stats.Errors++
+	case status == StatusLink:
+		stats.Links++
+	default:
+		stats.NoLinks++
+	}
+	stats.Duration += d
+	stats.Bytes += uint64(size)
+}
+
+// Calls returns how often the handler was called.
+func (stats MediaHandlerStats) Calls() uint64 {
+	return stats.Links + stats.NoLinks + stats.Errors
+}
+
+// runMediaHandler verifies sourceData with handler, and records how it went.
+func (receiver *Receiver) runMediaHandler(mediaType string, handler MediaHandler, sourceData []byte, target URL) (Status, error) {
+	start := time.Now()
+	status, err := handler(bytes.NewReader(sourceData), target)
+	receiver.metrics.handled(mediaType, status, err, time.Since(start), len(sourceData))
+	return status, err
+}
+
+// MediaHandlerStats returns the stats of every media handler that has been
+// used by this instance, ordered by media type, e.g., to find out whether
+// verifying html or some other type is the bottleneck.
+func (receiver *Receiver) MediaHandlerStats() []MediaHandlerStats {
+	m := &receiver.metrics
+	m.m.Lock()
+	defer m.m.Unlock()
+	stats := make([]MediaHandlerStats, 0, len(m.handlers))
+	for _, s := range m.handlers {
+		stats = append(stats, *s)
+	}
+	slices.SortFunc(stats, func(a, b MediaHandlerStats) int {
+		return strings.Compare(a.MediaType, b.MediaType)
+	})
+	return stats
+}
+
+// WriteMetrics writes the receiver's metrics in the Prometheus text exposition format.
+// Counters only reflect this instance, since it was started.
+func (receiver *Receiver) WriteMetrics(w io.Writer) error {
+	info := Build()
+	if _, err := fmt.Fprintf(w, "# HELP webmention_build_info Version of the webmention package.\n# TYPE webmention_build_info gauge\nwebmention_build_info{version=%s,commit=%s,date=%s} 1\n", strconv.Quote(info.Version), strconv.Quote(info.Commit), strconv.Quote(info.Date)); err != nil {
+		return err
+	}
+	if err := receiver.writeCounters(w); err != nil {
+		return err
+	}
+	if err := receiver.writeHandlerStats(w); err != nil {
+		return err
+	}
+	if receiver.deadLetters != nil {
+		letters, err := receiver.deadLetters.DeadLetters()
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "# HELP webmention_dead_letters Mentions currently in the dead letters.\n# TYPE webmention_dead_letters gauge\nwebmention_dead_letters %d\n", len(letters)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (receiver *Receiver) writeCounters(w io.Writer) error {
+	m := &receiver.metrics
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	codes := make([]int, 0, len(m.requests))
+	for code := range m.requests {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+	if _, err := io.WriteString(w, "# HELP webmention_requests_total Webmention requests by response status code.\n# TYPE webmention_requests_total counter\n"); err != nil {
+		return err
+	}
+	for _, code := range codes {
+		if _, err := fmt.Fprintf(w, "webmention_requests_total{code=\"%d\"} %d\n", code, m.requests[code]); err != nil {
+			return err
+		}
+	}
+
+	states := make([]ProcessingState, 0, len(m.states))
+	for state := range m.states {
+		states = append(states, state)
+	}
+	slices.Sort(states)
+	if _, err := io.WriteString(w, "# HELP webmention_mentions_total Mentions by processing state.\n# TYPE webmention_mentions_total counter\n"); err != nil {
+		return err
+	}
+	for _, state := range states {
+		if _, err := fmt.Fprintf(w, "webmention_mentions_total{state=%s} %d\n", strconv.Quote(string(state)), m.states[state]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (receiver *Receiver) writeHandlerStats(w io.Writer) error {
+	stats := receiver.MediaHandlerStats()
+	if _, err := io.WriteString(w, "# HELP webmention_media_handler_calls_total Media handler calls by media type and result.\n# TYPE webmention_media_handler_calls_total counter\n"); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		for _, result := range []struct {
+			name  string
+			count uint64
+		}{{"link", s.Links}, {"no_link", s.NoLinks}, {"error", s.Errors}} {
+			if _, err := fmt.Fprintf(w, "webmention_media_handler_calls_total{type=%s,result=%q} %d\n", strconv.Quote(s.MediaType), result.name, result.count); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := io.WriteString(w, "# HELP webmention_media_handler_duration_seconds Time spent in media handlers by media type.\n# TYPE webmention_media_handler_duration_seconds summary\n"); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		if _, err := fmt.Fprintf(w, "webmention_media_handler_duration_seconds_sum{type=%[1]s} %[2]g\nwebmention_media_handler_duration_seconds_count{type=%[1]s} %[3]d\n", strconv.Quote(s.MediaType), s.Duration.Seconds(), s.Calls()); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "# HELP webmention_media_handler_bytes_total Size of the sources passed to media handlers by media type.\n# TYPE webmention_media_handler_bytes_total counter\n"); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		if _, err := fmt.Fprintf(w, "webmention_media_handler_bytes_total{type=%s} %d\n", strconv.Quote(s.MediaType), s.Bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statusRecorder remembers the status code written to a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(bs []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.ResponseWriter.Write(bs)
+}
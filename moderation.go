@@ -0,0 +1,211 @@
+package webmention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+type (
+	// A ModerationQueue holds mentions that need to be approved manually
+	// before they are passed on to the notifiers.
+	ModerationQueue interface {
+		// Hold adds a mention to the queue.
+		Hold(mention Mention) error
+
+		// Pending lists all mentions awaiting moderation.
+		Pending() ([]Mention, error)
+
+		// Release removes the mention with the given id from the queue.
+		// ErrNotPending is returned if there is no such mention.
+		Release(id string) (Mention, error)
+	}
+
+	// MemoryModerationQueue is a ModerationQueue that keeps its mentions in memory.
+	// Pending mentions are lost when the process exits.
+	MemoryModerationQueue struct {
+		m        sync.Mutex
+		mentions []Mention
+	}
+
+	// FileModerationQueue is a ModerationQueue that keeps its mentions in a
+	// JSON file, which is rewritten on every change.
+	// Like the FileDeadLetterStore, it is read anew every time, so that
+	// another process (e.g., a command line tool) can approve mentions.
+	FileModerationQueue struct {
+		m    sync.Mutex
+		path string
+	}
+)
+
+var (
+	// *MemoryModerationQueue implements ModerationQueue
+	_ ModerationQueue = (*MemoryModerationQueue)(nil)
+	// *FileModerationQueue implements ModerationQueue
+	_ ModerationQueue = (*FileModerationQueue)(nil)
+)
+
+// WithModeration configures where mentions that failed the spam check are held.
+// The notifiers are informed of every held mention, so that a human can
+// have a look at it (and then call Receiver.Approve or Receiver.Reject).
+// If a SpamScorer is configured without a moderation queue, a MemoryModerationQueue is used.
+func WithModeration(queue ModerationQueue, notifiers ...Notifier) ReceiverOption {
+	return func(r *Receiver) {
+		r.moderation = queue
+		r.moderators = append(r.moderators, notifiers...)
+	}
+}
+
+// Approve releases a mention from moderation and dispatches it like any other valid mention.
+// It returns once all notifiers have been informed.
+func (receiver *Receiver) Approve(id string) error {
+	if receiver.moderation == nil {
+		return ErrNotPending
+	}
+	mention, err := receiver.moderation.Release(id)
+	if err != nil {
+		return err
+	}
+	notified, err := receiver.startDispatch(mention)
+	if err != nil {
+		return err
+	}
+	<-notified
+	return nil
+}
+
+// Reject releases a mention from moderation and discards it.
+func (receiver *Receiver) Reject(id string) error {
+	if receiver.moderation == nil {
+		return ErrNotPending
+	}
+	mention, err := receiver.moderation.Release(id)
+	if err != nil {
+		return err
+	}
+	receiver.reject(mention, "rejected by moderator")
+	return nil
+}
+
+// Pending lists all mentions awaiting moderation.
+func (receiver *Receiver) Pending() ([]Mention, error) {
+	if receiver.moderation == nil {
+		return nil, nil
+	}
+	return receiver.moderation.Pending()
+}
+
+func (receiver *Receiver) hold(mention Mention) error {
+	if err := receiver.moderation.Hold(mention); err != nil {
+		return err
+	}
+	receiver.setState(mention, StateHeld)
+	for _, moderator := range receiver.moderators {
+		go receiver.notify(moderator, mention)
+	}
+	return nil
+}
+
+func (q *MemoryModerationQueue) Hold(mention Mention) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.mentions = append(q.mentions, mention)
+	return nil
+}
+
+func (q *MemoryModerationQueue) Pending() ([]Mention, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return slices.Clone(q.mentions), nil
+}
+
+func (q *MemoryModerationQueue) Release(id string) (Mention, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	for i, mention := range q.mentions {
+		if mention.ID == id {
+			q.mentions = slices.Delete(q.mentions, i, i+1)
+			return mention, nil
+		}
+	}
+	return Mention{}, ErrNotPending
+}
+
+func NewFileModerationQueue(path string) *FileModerationQueue {
+	return &FileModerationQueue{path: path}
+}
+
+func (q *FileModerationQueue) Hold(mention Mention) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	mentions, err := q.read()
+	if err != nil {
+		return err
+	}
+	return q.write(append(mentions, mention))
+}
+
+func (q *FileModerationQueue) Pending() ([]Mention, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.read()
+}
+
+func (q *FileModerationQueue) Release(id string) (Mention, error) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	mentions, err := q.read()
+	if err != nil {
+		return Mention{}, err
+	}
+	for i, mention := range mentions {
+		if mention.ID == id {
+			return mention, q.write(slices.Delete(mentions, i, i+1))
+		}
+	}
+	return Mention{}, ErrNotPending
+}
+
+func (q *FileModerationQueue) read() ([]Mention, error) {
+	bs, err := os.ReadFile(q.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("moderation queue: %w", err)
+	}
+	var mentions []Mention
+	if err := json.Unmarshal(bs, &mentions); err != nil {
+		return nil, fmt.Errorf("moderation queue: %s: %w", q.path, err)
+	}
+	return mentions, nil
+}
+
+// write replaces the file atomically.
+func (q *FileModerationQueue) write(mentions []Mention) error {
+	bs, err := json.MarshalIndent(mentions, "", "\t")
+	if err != nil {
+		return fmt.Errorf("moderation queue: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("moderation queue: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return fmt.Errorf("moderation queue: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("moderation queue: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return fmt.Errorf("moderation queue: %w", err)
+	}
+	return nil
+}
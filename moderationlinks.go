@@ -0,0 +1,171 @@
+package webmention
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type (
+	// ModerationLinks signs links that approve or reject a mention held
+	// for moderation, so that they can be put into notification mails, and
+	// followed without logging in (see RouteModerationLinks).
+	// Anyone holding a link can use it, until it expires.
+	ModerationLinks struct {
+		// BaseURL is the mount point of the handler serving
+		// RouteModerationLinks, e.g., https://example.com/api/webmention
+		BaseURL string
+		// Secret signs the links, changing it invalidates all links handed out.
+		Secret []byte
+		// Expiry is how long a link is valid (default DefaultModerationLinkExpiry).
+		Expiry time.Duration
+	}
+
+	// ModerationAction is what a moderation link does.
+	ModerationAction string
+)
+
+const (
+	ActionApprove ModerationAction = "approve"
+	ActionReject  ModerationAction = "reject"
+)
+
+// DefaultModerationLinkExpiry is the expiry of the zero ModerationLinks.
+const DefaultModerationLinkExpiry = 7 * 24 * time.Hour
+
+// WithModerationLinks serves RouteModerationLinks, it must be given the
+// same links the notifiers sign with.
+func WithModerationLinks(links ModerationLinks) HandlerOption {
+	return func(h *receiverHandler) {
+		h.moderationLinks = &links
+	}
+}
+
+// Link returns a signed link that applies action to the pending mention id.
+// Following it shows the mention and asks for confirmation, so that mail
+// scanners that visit links don't approve or reject it by themselves.
+func (links ModerationLinks) Link(id string, action ModerationAction) string {
+	expiry := links.Expiry
+	if expiry <= 0 {
+		expiry = DefaultModerationLinkExpiry
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	params := url.Values{
+		"expires":   {expires},
+		"signature": {links.sign(id, action, expires)},
+	}
+	return links.BaseURL + "/moderate/" + url.PathEscape(id) + "/" + string(action) + "?" + params.Encode()
+}
+
+func (links ModerationLinks) sign(id string, action ModerationAction, expires string) string {
+	mac := hmac.New(sha256.New, links.Secret)
+	mac.Write([]byte(string(action) + "\n" + id + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns ErrInvalidModerationLink unless the link was signed by
+// links, and hasn't expired yet.
+func (links ModerationLinks) verify(id string, action ModerationAction, expires, signature string) error {
+	if len(links.Secret) == 0 || (action != ActionApprove && action != ActionReject) {
+		return ErrInvalidModerationLink
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidModerationLink
+	}
+	want, _ := base64.RawURLEncoding.DecodeString(links.sign(id, action, expires))
+	if !hmac.Equal(sig, want) {
+		return ErrInvalidModerationLink
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return ErrInvalidModerationLink
+	}
+	return nil
+}
+
+// verifyModerationLink answers the request and returns false, if it
+// doesn't carry a valid moderation link.
+func (h *receiverHandler) verifyModerationLink(w http.ResponseWriter, r *http.Request) (id string, action ModerationAction, ok bool) {
+	if h.moderationLinks == nil {
+		http.Error(w, "moderation links: not configured", http.StatusNotImplemented)
+		return "", "", false
+	}
+	id, action = r.PathValue("id"), ModerationAction(r.PathValue("action"))
+	params := r.URL.Query()
+	if err := h.moderationLinks.verify(id, action, params.Get("expires"), params.Get("signature")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return "", "", false
+	}
+	return id, action, true
+}
+
+func (h *receiverHandler) confirmModeration(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := h.verifyModerationLink(w, r)
+	if !ok {
+		return
+	}
+	pending, err := h.receiver.Pending()
+	if err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	for _, mention := range pending {
+		if mention.ID == id {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			moderationTemplate.Execute(w, struct {
+				Mention Mention
+				Action  ModerationAction
+				URL     string
+			}{mention, action, r.URL.RequestURI()})
+			return
+		}
+	}
+	http.Error(w, ErrNotPending.Error(), http.StatusNotFound)
+}
+
+func (h *receiverHandler) moderateByLink(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := h.verifyModerationLink(w, r)
+	if !ok {
+		return
+	}
+	moderate, done := h.receiver.Approve, "approved"
+	if action == ActionReject {
+		moderate, done = h.receiver.Reject, "rejected"
+	}
+	switch err := moderate(id); {
+	case err == nil:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("The mention has been " + done + ".\n"))
+	case errors.Is(err, ErrNotPending):
+		http.Error(w, err.Error()+", it may have been moderated already", http.StatusNotFound)
+	default:
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+	}
+}
+
+var moderationTemplate = template.Must(template.New("moderation").Parse(`<!doctype html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>{{.Action}} mention</title></head>
+<body>
+<p>Source: <a href="{{.Mention.Source}}" rel="nofollow noopener">{{.Mention.Source}}</a></p>
+<p>Target: <a href="{{.Mention.Target}}">{{.Mention.Target}}</a></p>
+{{- if .Mention.SpamScore}}
+<p>Spam score: {{printf "%.2f" .Mention.SpamScore}}</p>
+{{- end}}
+<form method="post" action="{{.URL}}">
+<button type="submit">{{.Action}}</button>
+</form>
+</body>
+</html>
+`))
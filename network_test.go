@@ -0,0 +1,173 @@
+package webmention_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// socksServer is a minimal SOCKS5 proxy (no auth, CONNECT only), that
+// connects every request to upstream and records the requested addresses.
+type socksServer struct {
+	listener  net.Listener
+	upstream  string
+	m         sync.Mutex
+	requested []string
+}
+
+func newSocksServer(t *testing.T, upstream string) *socksServer {
+	s := &socksServer{listener: must(net.Listen("tcp", "127.0.0.1:0")), upstream: upstream}
+	t.Cleanup(func() { s.listener.Close() })
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socksServer) URL() *url.URL {
+	return &url.URL{Scheme: "socks5", Host: s.listener.Addr().String()}
+}
+
+func (s *socksServer) Requested() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]string(nil), s.requested...)
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil { // auth methods
+		return
+	}
+	conn.Write([]byte{5, 0}) // no auth
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1: // ipv4
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 3: // domain name
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	s.m.Lock()
+	s.requested = append(s.requested, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	s.m.Unlock()
+
+	upstream, err := net.Dial("tcp", s.upstream)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestSenderProxy(t *testing.T) {
+	var posted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/target":
+			w.Header().Set("Link", "</webmention>; rel=webmention")
+		case "/webmention":
+			posted = append(posted, r.FormValue("target"))
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer ts.Close()
+	proxy := newSocksServer(t, ts.Listener.Addr().String())
+
+	sender := webmention.NewSender(webmention.WithProxy(proxy.URL()))
+	// the host name is unresolvable, only the proxy knows where to go
+	target := must(url.Parse("http://blog.invalid/target"))
+	if err := sender.Mention(must(url.Parse("https://example.com/source")), target); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || posted[0] != target.String() {
+		t.Errorf("mention not delivered through proxy: %v", posted)
+	}
+	for _, addr := range proxy.Requested() {
+		if addr != "blog.invalid:80" {
+			t.Errorf("proxy asked to connect to %s, want: blog.invalid:80", addr)
+		}
+	}
+
+	broken := webmention.NewSender(webmention.WithProxy(must(url.Parse("gopher://localhost:70"))))
+	if err := broken.Mention(must(url.Parse("https://example.com/source")), target); err == nil {
+		t.Error("unsupported proxy scheme did not fail")
+	}
+}
+
+func TestOnion(t *testing.T) {
+	const onion = "http://2gzyxa5ihm7nsggfxnu52rck2vv4rvmdlkiu3zzui5du4xyclen53wid.onion"
+	var posted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/target":
+			w.Header().Set("Link", "</webmention>; rel=webmention")
+		case "/webmention":
+			posted = append(posted, r.FormValue("target"))
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer ts.Close()
+	source := must(url.Parse("https://example.com/source"))
+	target := must(url.Parse(onion + "/target"))
+
+	if err := webmention.NewSender().Mention(source, target); !errors.Is(err, webmention.ErrOnionWithoutProxy) {
+		t.Errorf("onion target contacted without proxy: %v", err)
+	}
+
+	proxy := newSocksServer(t, ts.Listener.Addr().String())
+	if err := webmention.NewSender(webmention.WithProxy(proxy.URL())).Mention(source, target); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || posted[0] != target.String() {
+		t.Errorf("mention not delivered to onion service: %v", posted)
+	}
+
+	receiver := webmention.NewReceiver(webmention.WithAcceptsFunc(accepts))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/webmention", strings.NewReader(url.Values{
+		"source": {onion + "/source"},
+		"target": {"https://example.com/target"},
+	}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	receiver.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "onion") {
+		t.Errorf("receiver without proxy accepted onion source: %d: %s", rec.Code, rec.Body)
+	}
+}
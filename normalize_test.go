@@ -0,0 +1,185 @@
+package webmention_test
+
+import (
+	"html"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// genURL is a random url, exercising the parts that are subject to
+// normalization: the case of scheme and host, (default) ports, ipv6 hosts,
+// empty path segments, trailing slashes, escapes, queries, and fragments.
+type genURL string
+
+func (genURL) Generate(r *rand.Rand, size int) reflect.Value {
+	pick := func(choices ...string) string {
+		return choices[r.Intn(len(choices))]
+	}
+	u := pick("http", "https", "HTTP", "Https") + "://"
+	u += pick("example.com", "Example.COM", "blog.example.org", "localhost", "127.0.0.1", "[::1]", "[2001:DB8::1]", "xn--bcher-kva.example")
+	u += pick("", "", ":80", ":443", ":8080", ":")
+	for i := r.Intn(4); i > 0; i-- {
+		u += "/" + pick("", "post", "Post", "2024", "%7Euser", "a%2Fb", "caf%C3%A9", "hello-world")
+	}
+	u += pick("", "/", "//")
+	u += pick("", "", "?q=1", "?q=1&r=A", "?", "?x=%20")
+	u += pick("", "", "#top", "#", "#Section-2")
+	return reflect.ValueOf(genURL(u))
+}
+
+func (u genURL) parse(t *testing.T) webmention.URL {
+	t.Helper()
+	parsed, err := url.Parse(string(u))
+	if err != nil {
+		t.Fatalf("generated invalid url %q: %s", u, err)
+	}
+	return parsed
+}
+
+// flipCase randomly changes the case of the letters in s.
+func flipCase(r *rand.Rand, s string) string {
+	return strings.Map(func(c rune) rune {
+		if r.Intn(2) == 0 {
+			return unicode.ToUpper(c)
+		}
+		return unicode.ToLower(c)
+	}, s)
+}
+
+// flipOriginCase randomly changes the case of the scheme and host of u,
+// which doesn't change the page it refers to.
+func flipOriginCase(r *rand.Rand, u genURL) genURL {
+	scheme, rest, _ := strings.Cut(string(u), "://")
+	end := strings.IndexAny(rest, "/?#")
+	if end < 0 {
+		end = len(rest)
+	}
+	return genURL(flipCase(r, scheme) + "://" + flipCase(r, rest[:end]) + rest[end:])
+}
+
+func TestNormalizationIdempotent(t *testing.T) {
+	property := func(u genURL) bool {
+		key := webmention.PageKey(u.parse(t))
+		again := webmention.PageKey(genURL(key).parse(t))
+		if key != again {
+			t.Logf("%q: normalized to %q, then to %q", u, key, again)
+		}
+		return key == again
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSamePageSymmetric(t *testing.T) {
+	property := func(a, b genURL, seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		if r.Intn(2) == 0 {
+			b = flipOriginCase(r, a) // otherwise, equal pages are rare
+		}
+		ua, ub := a.parse(t), b.parse(t)
+		return webmention.SamePage(ua, ub) == webmention.SamePage(ub, ua)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSamePageIgnoresOriginCase(t *testing.T) {
+	property := func(u genURL, seed int64) bool {
+		variant := flipOriginCase(rand.New(rand.NewSource(seed)), u)
+		return webmention.SamePage(u.parse(t), variant.parse(t))
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// linksTo reports whether an html document linking to href mentions target.
+func linksTo(t *testing.T, href string, target webmention.URL) bool {
+	t.Helper()
+	status, err := webmention.HtmlHandler(strings.NewReader(`<p>See <a href="`+html.EscapeString(href)+`">this</a>.</p>`), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status == webmention.StatusLink
+}
+
+func TestLinkDetectionEqualURLs(t *testing.T) {
+	property := func(u genURL, seed int64) bool {
+		target := u.parse(t)
+		variant := flipCase(rand.New(rand.NewSource(seed)), target.String())
+		return linksTo(t, target.String(), target) && linksTo(t, variant, target)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+
+	plain := func(u genURL) bool {
+		target := u.parse(t)
+		status, err := webmention.PlainHandler(strings.NewReader("see "+target.String()+" for more"), target)
+		return err == nil && status == webmention.StatusLink
+	}
+	if err := quick.Check(plain, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLinkDetectionSymmetric(t *testing.T) {
+	property := func(a, b genURL, seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		if r.Intn(2) == 0 {
+			b = genURL(flipCase(r, string(a)))
+		}
+		ua, ub := a.parse(t), b.parse(t)
+		return linksTo(t, ua.String(), ub) == linksTo(t, ub.String(), ua)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTargetDiffingEqualURLs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/endpoint", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</endpoint>; rel=webmention")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	origin := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+	sender := webmention.NewSender()
+	source := must(url.Parse("https://example.com/post"))
+	property := func(u genURL, seed int64) bool {
+		// the path, query and fragment of u, on the test server
+		parsed := u.parse(t)
+		parsed.Scheme, parsed.Host = "", ""
+		past := genURL(origin + parsed.String())
+		if !strings.HasPrefix(parsed.String(), "/") {
+			past = genURL(origin + "/" + parsed.String())
+		}
+		current := flipOriginCase(rand.New(rand.NewSource(seed)), past)
+
+		results, err := sender.UpdateResults(source, []webmention.URL{past.parse(t)}, []webmention.URL{current.parse(t)})
+		if err != nil || len(results) != 1 || results[0].Change != webmention.ChangeKept {
+			t.Logf("%q -> %q: %v: %s", past, current, err, results)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 25}); err != nil {
+		t.Error(err)
+	}
+}
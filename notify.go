@@ -0,0 +1,203 @@
+package webmention
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// A NamedNotifier is a Notifier with a stable name.
+	// Only named notifiers are deduplicated by a NotificationLog.
+	NamedNotifier interface {
+		Notifier
+		Name() string
+	}
+
+	// A FallibleNotifier reports whether informing about a mention succeeded.
+	// Only successful notifications are recorded in a NotificationLog, failed
+	// ones are repeated when the mention is processed (or replayed) again.
+	// Notifiers that don't implement it are assumed to always succeed.
+	FallibleNotifier interface {
+		Notifier
+		Notify(mention Mention) error
+	}
+
+	// A NotificationLog remembers which notifier has been informed about which mention.
+	// With a persistent log, re-processing a mention after a crash, or
+	// replaying stored mentions, does not inform the same notifier twice.
+	NotificationLog interface {
+		// Notified reports whether the named notifier has already been
+		// informed about the mention with the given id.
+		Notified(mentionID, notifier string) (bool, error)
+		// MarkNotified records that the named notifier has been informed
+		// about the mention with the given id.
+		MarkNotified(mentionID, notifier string) error
+	}
+
+	// KeyValueNotificationLog keeps the notification log in a KeyValueStore,
+	// forgetting about mentions after TTL (zero to never forget).
+	KeyValueNotificationLog struct {
+		Store KeyValueStore
+		TTL   time.Duration
+	}
+
+	// FileNotificationLog keeps the notification log in a file, one record
+	// per line, which is read once into memory.
+	FileNotificationLog struct {
+		m        sync.Mutex
+		path     string
+		notified map[string]bool
+	}
+
+	namedNotifier struct {
+		Notifier
+		name string
+	}
+)
+
+var (
+	// *KeyValueNotificationLog implements NotificationLog
+	_ NotificationLog = (*KeyValueNotificationLog)(nil)
+	// *FileNotificationLog implements NotificationLog
+	_ NotificationLog = (*FileNotificationLog)(nil)
+	// namedNotifier implements FallibleNotifier
+	_ FallibleNotifier = namedNotifier{}
+)
+
+// WithNotificationLog deduplicates notifications of named notifiers (see
+// NamedNotifier, Named) using log.
+func WithNotificationLog(log NotificationLog) ReceiverOption {
+	return func(r *Receiver) {
+		r.notificationLog = log
+	}
+}
+
+// Named gives notifier a name, so that it can be deduplicated by a NotificationLog.
+// The name should not change between restarts.
+func Named(name string, notifier Notifier) NamedNotifier {
+	return namedNotifier{notifier, name}
+}
+
+func (n namedNotifier) Name() string {
+	return n.name
+}
+
+func (n namedNotifier) Notify(mention Mention) error {
+	if fallible, ok := n.Notifier.(FallibleNotifier); ok {
+		return fallible.Notify(mention)
+	}
+	n.Notifier.Receive(mention)
+	return nil
+}
+
+func (l *KeyValueNotificationLog) Notified(mentionID, notifier string) (bool, error) {
+	_, ok, err := l.Store.Get("notified:" + mentionID + " " + notifier)
+	return ok, err
+}
+
+func (l *KeyValueNotificationLog) MarkNotified(mentionID, notifier string) error {
+	return l.Store.Set("notified:"+mentionID+" "+notifier, time.Now().Format(time.RFC3339), l.TTL)
+}
+
+func NewFileNotificationLog(path string) *FileNotificationLog {
+	return &FileNotificationLog{path: path}
+}
+
+func (l *FileNotificationLog) Notified(mentionID, notifier string) (bool, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.notified == nil {
+		if err := l.load(); err != nil {
+			return false, err
+		}
+	}
+	return l.notified[mentionID+" "+notifier], nil
+}
+
+func (l *FileNotificationLog) MarkNotified(mentionID, notifier string) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.notified == nil {
+		if err := l.load(); err != nil {
+			return err
+		}
+	}
+	record := mentionID + " " + notifier
+	if l.notified[record] {
+		return nil
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("notification log: %w", err)
+	}
+	if _, err := f.WriteString(record + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("notification log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("notification log: %w", err)
+	}
+	l.notified[record] = true
+	return nil
+}
+
+func (l *FileNotificationLog) load() error {
+	notified := map[string]bool{}
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			l.notified = notified
+			return nil
+		}
+		return fmt.Errorf("notification log: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if record := strings.TrimSpace(scanner.Text()); record != "" {
+			notified[record] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("notification log: %w", err)
+	}
+	l.notified = notified
+	return nil
+}
+
+// notify informs notifier about mention, unless the notification log says it already was.
+// If the log fails, the notifier is informed anyway, a duplicate is better than a lost notification.
+// The notification is only recorded once the notifier succeeded (see FallibleNotifier).
+// It reports whether the notifier has been informed, now or before.
+func (receiver *Receiver) notify(notifier Notifier, mention Mention) (informed bool) {
+	named, logged := notifier.(NamedNotifier)
+	logged = logged && receiver.notificationLog != nil && mention.ID != ""
+	if logged {
+		notified, err := receiver.notificationLog.Notified(mention.ID, named.Name())
+		if err != nil {
+			receiver.report(err, mention)
+		} else if notified {
+			return true
+		}
+	}
+	if fallible, ok := notifier.(FallibleNotifier); ok {
+		if err := fallible.Notify(mention); err != nil {
+			receiver.report(fmt.Errorf("notify: %w", err), mention)
+			return false
+		}
+	} else {
+		notifier.Receive(mention)
+	}
+	if logged {
+		if err := receiver.notificationLog.MarkNotified(mention.ID, named.Name()); err != nil {
+			receiver.report(err, mention)
+		}
+	}
+	return true
+}
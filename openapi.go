@@ -0,0 +1,235 @@
+package webmention
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIVersion is the version of the OpenAPI specification the document follows.
+const openAPIVersion = "3.0.3"
+
+func (h *receiverHandler) openAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.openAPIDocument())
+}
+
+// openAPIDocument describes the enabled routes (see handlerRoutes), the
+// schemas of the responses are derived from the types they encode.
+func (h *receiverHandler) openAPIDocument() map[string]any {
+	schemas := schemaGenerator{schemas: map[string]any{}}
+	paths := map[string]map[string]any{}
+	for _, route := range h.served {
+		operation := map[string]any{
+			"operationId": operationID(route),
+			"summary":     route.summary,
+			"responses":   h.openAPIResponses(route, &schemas),
+		}
+		var params []any
+		for _, param := range route.params {
+			schema := map[string]any{"type": "string"}
+			if param.enum != nil {
+				schema["enum"] = param.enum
+			}
+			p := map[string]any{"name": param.name, "in": param.in, "required": param.required, "schema": schema}
+			if param.description != "" {
+				p["description"] = param.description
+			}
+			params = append(params, p)
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if route.route == RouteWebmention {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/x-www-form-urlencoded": map[string]any{
+						"schema": map[string]any{
+							"type":     "object",
+							"required": []string{"source", "target"},
+							"properties": map[string]any{
+								"source": map[string]any{"type": "string", "format": "uri"},
+								"target": map[string]any{"type": "string", "format": "uri"},
+							},
+							// extension parameters
+							"additionalProperties": map[string]any{"type": "string"},
+						},
+					},
+				},
+			}
+		}
+		if route.admin && h.authorize != nil {
+			operation["description"] = "Requires admin authorization."
+		}
+		path := h.path(route)
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.method)] = operation
+		if route.route == RouteWebmention && h.receiver.selfDescription {
+			paths[path]["get"] = map[string]any{
+				"operationId": "getWebmention",
+				"summary":     "Describe the endpoint, as html, or as JSON if asked for",
+				"responses": map[string]any{
+					"200": map[string]any{
+						"description": http.StatusText(http.StatusOK),
+						"content": map[string]any{
+							"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(EndpointDescription{}))},
+							"text/html":        map[string]any{},
+						},
+					},
+				},
+			}
+		}
+	}
+	document := map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":   "Webmention",
+			"version": Version(),
+		},
+		"paths": paths,
+	}
+	if len(schemas.schemas) > 0 {
+		document["components"] = map[string]any{"schemas": schemas.schemas}
+	}
+	return document
+}
+
+func (h *receiverHandler) openAPIResponses(route handlerRoute, schemas *schemaGenerator) map[string]any {
+	success := map[string]any{"description": http.StatusText(route.code)}
+	switch {
+	case route.body != nil:
+		success["content"] = map[string]any{
+			"application/json": map[string]any{"schema": schemas.schema(reflect.TypeOf(route.body))},
+		}
+	case route.mediaType != "":
+		success["content"] = map[string]any{route.mediaType: map[string]any{}}
+	}
+	if route.route == RouteWebmention && h.routes&RouteStatus != 0 {
+		success["headers"] = map[string]any{
+			"Location": map[string]any{
+				"description": "status of the submission",
+				"schema":      map[string]any{"type": "string"},
+			},
+		}
+	}
+	responses := map[string]any{strconv.Itoa(route.code): success}
+	errors := route.errors
+	if route.admin && h.authorize != nil {
+		errors = append(errors[:len(errors):len(errors)], http.StatusUnauthorized)
+	}
+	for _, code := range errors {
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content":     map[string]any{"text/plain": map[string]any{}},
+		}
+	}
+	return responses
+}
+
+// operationID names a route, e.g., postDeadLettersIdRetry for
+// POST /dead-letters/{id}/retry
+func operationID(route handlerRoute) string {
+	id := strings.ToLower(route.method)
+	for _, word := range strings.FieldsFunc(route.path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		id += strings.ToUpper(word[:1]) + word[1:]
+	}
+	return id
+}
+
+// schemaGenerator derives JSON schemas from Go types, the way they are
+// encoded by encoding/json.
+// Named structs are collected in schemas and referred to.
+type schemaGenerator struct {
+	schemas map[string]any
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	mentionType = reflect.TypeOf(Mention{})
+)
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case mentionType:
+		return g.ref("Mention", reflect.TypeOf(mentionJSON{})) // see Mention.MarshalJSON
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() != "" {
+			return g.ref(t.Name(), t)
+		}
+		return g.object(t)
+	}
+	return map[string]any{}
+}
+
+// ref refers to the schema of t, under name.
+func (g *schemaGenerator) ref(name string, t reflect.Type) map[string]any {
+	if _, ok := g.schemas[name]; !ok {
+		g.schemas[name] = nil // guards against recursion
+		g.schemas[name] = g.object(t)
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties, required := map[string]any{}, []string{}
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := range t.NumField() {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+				addFields(field.Type)
+				continue
+			}
+			if !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = g.schema(field.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,221 @@
+package webmention
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+type (
+	// An OutboxStorage stores a mention together with the notifications
+	// still to be sent about it (a transactional outbox), so that a crash
+	// between storing a mention and informing the notifiers does not lose
+	// notifications: those still in the outbox are sent once processing
+	// starts again (see ProcessMentions).
+	// Only named notifiers (see NamedNotifier) can be tracked in the outbox.
+	// A notifier that crashed after informing, but before its notification
+	// was marked as sent, informs again; notifiers that deduplicate by
+	// Mention.ID are informed exactly once.
+	OutboxStorage interface {
+		Storage
+		// StoreWithOutbox saves the mention like Store, and, in the same
+		// write, records that the named notifiers are to be informed about it.
+		StoreWithOutbox(mention Mention, notifiers []string) error
+		// Outbox returns the mentions with notifications not sent yet.
+		Outbox() ([]OutboxEntry, error)
+		// Sent removes the notifier from the outbox of the mention with the given id.
+		Sent(mentionID, notifier string) error
+	}
+
+	// OutboxEntry is a mention, and the names of the notifiers still to be informed about it.
+	OutboxEntry struct {
+		Mention   Mention
+		Notifiers []string
+	}
+
+	// outboxSent is a line of a JSONFileStorage recording that a
+	// notification has been sent.
+	outboxSent struct {
+		Sent     string `json:"sent"`
+		Notifier string `json:"notifier"`
+	}
+)
+
+// *JSONFileStorage implements OutboxStorage
+var _ OutboxStorage = (*JSONFileStorage)(nil)
+
+var (
+	// outboxPrefix starts the lines of mentions stored with an outbox.
+	outboxPrefix = []byte(`{"outbox":`)
+	// sentPrefix starts the lines recording sent notifications.
+	sentPrefix = []byte(`{"sent":`)
+)
+
+// StoreWithOutbox appends the mention to the file, the outbox is written on
+// the same line, so that either both or none are stored.
+func (s *JSONFileStorage) StoreWithOutbox(mention Mention, notifiers []string) error {
+	if len(notifiers) == 0 {
+		return s.Store(mention)
+	}
+	bs, err := json.Marshal(mention)
+	if err != nil {
+		return err
+	}
+	outbox, err := json.Marshal(notifiers)
+	if err != nil {
+		return err
+	}
+	line := slices.Concat(outboxPrefix, outbox, []byte(","), bs[1:])
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.appendLine(line); err != nil {
+		return err
+	}
+	if s.counts != nil {
+		s.count(mention)
+	}
+	return nil
+}
+
+// Outbox scans the whole file for mentions stored with an outbox, whose
+// notifications have not all been sent.
+func (s *JSONFileStorage) Outbox() ([]OutboxEntry, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	f, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		entries []OutboxEntry
+		index   = map[string]int{}
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		switch bs := scanner.Bytes(); {
+		case bytes.HasPrefix(bs, outboxPrefix):
+			var entry struct {
+				Outbox []string `json:"outbox"`
+			}
+			var mention Mention
+			if err := json.Unmarshal(bs, &entry); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", s.path, line, err)
+			}
+			if err := json.Unmarshal(bs, &mention); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", s.path, line, err)
+			}
+			index[mention.ID] = len(entries)
+			entries = append(entries, OutboxEntry{Mention: mention, Notifiers: entry.Outbox})
+		case bytes.HasPrefix(bs, sentPrefix):
+			var sent outboxSent
+			if err := json.Unmarshal(bs, &sent); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", s.path, line, err)
+			}
+			if i, ok := index[sent.Sent]; ok {
+				entries[i].Notifiers = slices.DeleteFunc(entries[i].Notifiers, func(name string) bool {
+					return name == sent.Notifier
+				})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(entry OutboxEntry) bool {
+		return len(entry.Notifiers) == 0
+	}), nil
+}
+
+// Sent appends a line recording the sent notification.
+func (s *JSONFileStorage) Sent(mentionID, notifier string) error {
+	bs, err := json.Marshal(outboxSent{Sent: mentionID, Notifier: notifier})
+	if err != nil {
+		return err
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.appendLine(bs)
+}
+
+// outboxNames returns the names of the notifiers that can be tracked in
+// the outbox, nil if the storage has no outbox.
+func (receiver *Receiver) outboxNames(notifiers []Notifier) (names []string) {
+	if _, ok := receiver.storage.(OutboxStorage); !ok {
+		return nil
+	}
+	for _, notifier := range notifiers {
+		if named, ok := notifier.(NamedNotifier); ok {
+			names = append(names, named.Name())
+		}
+	}
+	return names
+}
+
+// sent removes the notification from the outbox, after notifier informed
+// about mention.
+func (receiver *Receiver) sent(notifier Notifier, mention Mention) {
+	outbox, ok := receiver.storage.(OutboxStorage)
+	named, isNamed := notifier.(NamedNotifier)
+	if !ok || !isNamed {
+		return
+	}
+	if err := outbox.Sent(mention.ID, named.Name()); err != nil {
+		receiver.report(fmt.Errorf("outbox: %w", err), mention)
+	}
+}
+
+// deliverOutbox sends the notifications left in the outbox, e.g., by a
+// crash. Notifiers no longer registered are dropped from it.
+func (receiver *Receiver) deliverOutbox() {
+	outbox, ok := receiver.storage.(OutboxStorage)
+	if !ok {
+		return
+	}
+	entries, err := outbox.Outbox()
+	if err != nil {
+		slog.Error(fmt.Sprintf("outbox: %s", err))
+		return
+	}
+	if len(entries) > 0 {
+		slog.Info(fmt.Sprintf("sending %d mentions left in the outbox", len(entries)))
+	}
+	notifiers := map[string]Notifier{}
+	for _, notifier := range receiver.currentNotifiers() {
+		if named, ok := notifier.(NamedNotifier); ok {
+			notifiers[named.Name()] = notifier
+		}
+	}
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		for _, name := range entry.Notifiers {
+			notifier, ok := notifiers[name]
+			if !ok {
+				slog.Warn("outbox: notifier no longer registered", "notifier", name, "mention", entry.Mention.ID)
+				if err := outbox.Sent(entry.Mention.ID, name); err != nil {
+					receiver.report(fmt.Errorf("outbox: %w", err), entry.Mention)
+				}
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if receiver.notify(notifier, entry.Mention) {
+					receiver.sent(notifier, entry.Mention)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,70 @@
+package webmention
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type (
+	// A Persister remembers which targets a source mentioned the last time
+	// it was updated, so that Update can work out which targets need to be
+	// informed about a change.
+	// Targets are remembered in their canonical form.
+	Persister interface {
+		// Targets returns the targets recorded for source, or nil if there are none.
+		Targets(source URL) ([]URL, error)
+
+		// SetTargets replaces the targets recorded for source.
+		SetTargets(source URL, targets []URL) error
+	}
+
+	// KeyValuePersister is a Persister that keeps its records in a KeyValueStore.
+	// The records never expire, so the store should be a persistent one
+	// (e.g., from the redis subpackage).
+	KeyValuePersister struct {
+		Store KeyValueStore
+	}
+)
+
+// *KeyValuePersister implements Persister
+var _ Persister = (*KeyValuePersister)(nil)
+
+// WithPersister records the (canonical) targets of each source on Update.
+// Targets recorded last time are added to the pastTargets passed to Update.
+func WithPersister(persister Persister) SenderOption {
+	return func(s *Sender) {
+		s.persister = persister
+	}
+}
+
+func (p *KeyValuePersister) Targets(source URL) ([]URL, error) {
+	value, ok, err := p.Store.Get("targets:" + source.String())
+	if err != nil || !ok {
+		return nil, err
+	}
+	var raw []string
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("persister: %w", err)
+	}
+	targets := make([]URL, len(raw))
+	for i := range raw {
+		targets[i], err = url.Parse(raw[i])
+		if err != nil {
+			return nil, fmt.Errorf("persister: %w", err)
+		}
+	}
+	return targets, nil
+}
+
+func (p *KeyValuePersister) SetTargets(source URL, targets []URL) error {
+	raw := make([]string, len(targets))
+	for i := range targets {
+		raw[i] = targets[i].String()
+	}
+	bs, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("persister: %w", err)
+	}
+	return p.Store.Set("targets:"+source.String(), string(bs), 0)
+}
@@ -0,0 +1,323 @@
+package webmention
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Plugin is a filter, notifier, and/or media handler shipped as a
+	// separate program, so that it can be written in any language, and
+	// added without forking this repository.
+	//
+	// The program is started once, and kept running. It is spoken to with
+	// JSON over stdin and stdout, one object per line: a request
+	//
+	//	{"id": 1, "method": "filter", "params": {...}}
+	//
+	// is answered by a response with the same id, and either a result or an error:
+	//
+	//	{"id": 1, "result": {...}}
+	//	{"id": 1, "error": "something went wrong"}
+	//
+	// Requests are sent one at a time. Anything the program writes to
+	// stderr is logged. It should exit once stdin is closed. The methods are:
+	//   - describe, without params, is sent first, and answered with the
+	//     name of the plugin, and what it provides:
+	//     {"name": "gemini", "filter": false, "notifier": true, "media_types": ["text/gemini"]}
+	//   - filter, with a mention as params, is answered with
+	//     {"reject": "reason"} to reject the mention, or {} to accept it.
+	//   - notify, with a mention as params, is answered with {}.
+	//   - media, with {"media_type": "text/gemini", "content": "base64...", "target": "https://..."},
+	//     is answered with whether the content links to the target: {"link": true}
+	//
+	// Mentions are serialized the same way as by JSONFileStorage.
+	//
+	// Plugins run with the privileges of the receiver, but don't inherit its
+	// environment, apart from PATH and HOME (see CommandEnv), so that they
+	// don't get to see its secrets. WebAssembly modules are not supported,
+	// as they would require a WASM runtime dependency,
+	// to sandbox a plugin, and limit its resources, install a wrapper
+	// script instead, which starts the plugin through, e.g., bwrap, or
+	// systemd-run --user --scope -p MemoryMax=64M -p CPUQuota=10%.
+	Plugin struct {
+		// Path of the program.
+		Path string
+		// Timeout of a single request (0: 10 seconds).
+		Timeout time.Duration
+
+		description PluginDescription
+
+		m      sync.Mutex
+		cmd    *exec.Cmd
+		stdin  io.WriteCloser
+		stdout *bufio.Scanner
+		nextID int
+	}
+
+	// PluginDescription is what a plugin provides, as answered to describe.
+	PluginDescription struct {
+		Name       string   `json:"name"`
+		Filter     bool     `json:"filter"`
+		Notifier   bool     `json:"notifier"`
+		MediaTypes []string `json:"media_types"`
+	}
+
+	pluginRequest struct {
+		ID     int    `json:"id"`
+		Method string `json:"method"`
+		Params any    `json:"params,omitempty"`
+	}
+
+	pluginResponse struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+
+	pluginMediaParams struct {
+		MediaType string `json:"media_type"`
+		Content   []byte `json:"content"`
+		Target    string `json:"target"`
+	}
+)
+
+var (
+	// *Plugin implements Filter
+	_ Filter = (*Plugin)(nil)
+	// *Plugin implements FallibleNotifier
+	_ FallibleNotifier = (*Plugin)(nil)
+	// *Plugin implements NamedNotifier
+	_ NamedNotifier = (*Plugin)(nil)
+)
+
+// maxPluginMessage is the size of the largest response read from a plugin.
+const maxPluginMessage = 4 << 20
+
+// StartPlugin starts the program at path, and asks it what it provides.
+func StartPlugin(path string) (*Plugin, error) {
+	plugin := &Plugin{Path: path}
+	var description PluginDescription
+	if err := plugin.call("describe", nil, &description); err != nil {
+		plugin.Close()
+		return nil, err
+	}
+	if description.Name == "" {
+		description.Name = filepath.Base(path)
+	}
+	plugin.description = description
+	return plugin, nil
+}
+
+// LoadPlugins starts all executables in dir, in lexical order, those that
+// fail to start are skipped, and reported in the returned error.
+func LoadPlugins(dir string) (plugins []*Plugin, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("plugins: %w", err)
+	}
+	for _, entry := range entries {
+		info, ierr := entry.Info()
+		if ierr != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		plugin, perr := StartPlugin(filepath.Join(dir, entry.Name()))
+		if perr != nil {
+			err = errors.Join(err, perr)
+			continue
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, err
+}
+
+// WithPlugins registers the plugins as filters, notifiers, and media
+// handlers (with a qweight of 0.5), according to what they provide.
+func WithPlugins(plugins ...*Plugin) ReceiverOption {
+	return func(r *Receiver) {
+		for _, plugin := range plugins {
+			if plugin.description.Filter {
+				WithFilter(plugin)(r)
+			}
+			if plugin.description.Notifier {
+				WithNotifier(plugin)(r)
+			}
+			for _, mediaType := range plugin.description.MediaTypes {
+				WithMediaHandler(mediaType, 0.5, plugin.mediaHandler(mediaType))(r)
+			}
+		}
+	}
+}
+
+// Description returns what the plugin provides.
+func (plugin *Plugin) Description() PluginDescription {
+	return plugin.description
+}
+
+// Name identifies the plugin in a NotificationLog.
+func (plugin *Plugin) Name() string {
+	return "plugin:" + plugin.description.Name
+}
+
+func (plugin *Plugin) Filter(mention Mention) error {
+	var result struct {
+		Reject string `json:"reject"`
+	}
+	if err := plugin.call("filter", mention, &result); err != nil {
+		return err
+	}
+	if result.Reject != "" {
+		return Reject("%s", result.Reject)
+	}
+	return nil
+}
+
+func (plugin *Plugin) Receive(mention Mention) {
+	if err := plugin.Notify(mention); err != nil {
+		slog.Error(err.Error(), "mention", mention)
+	}
+}
+
+func (plugin *Plugin) Notify(mention Mention) error {
+	return plugin.call("notify", mention, nil)
+}
+
+func (plugin *Plugin) mediaHandler(mediaType string) MediaHandler {
+	return func(sourceData io.Reader, target URL) (Status, error) {
+		content, err := io.ReadAll(sourceData)
+		if err != nil {
+			return StatusNoLink, err
+		}
+		var result struct {
+			Link bool `json:"link"`
+		}
+		params := pluginMediaParams{MediaType: mediaType, Content: content, Target: target.String()}
+		if err := plugin.call("media", params, &result); err != nil {
+			return StatusNoLink, err
+		}
+		if result.Link {
+			return StatusLink, nil
+		}
+		return StatusNoLink, nil
+	}
+}
+
+// Close stops the program.
+func (plugin *Plugin) Close() error {
+	plugin.m.Lock()
+	defer plugin.m.Unlock()
+	plugin.stop()
+	return nil
+}
+
+// call sends a request, and decodes the result of the response into result
+// (if not nil). The program is (re)started, if it isn't running.
+func (plugin *Plugin) call(method string, params, result any) error {
+	plugin.m.Lock()
+	defer plugin.m.Unlock()
+	if plugin.cmd == nil {
+		if err := plugin.start(); err != nil {
+			return fmt.Errorf("plugin %s: %w", plugin.Path, err)
+		}
+	}
+	plugin.nextID++
+	request, err := json.Marshal(pluginRequest{ID: plugin.nextID, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("plugin %s: %s: %w", plugin.Path, method, err)
+	}
+
+	responses := make(chan error, 1)
+	var response pluginResponse
+	stdin, stdout, id := plugin.stdin, plugin.stdout, plugin.nextID
+	go func() {
+		if _, err := stdin.Write(append(request, '\n')); err != nil {
+			responses <- err
+			return
+		}
+		for stdout.Scan() {
+			if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+				responses <- fmt.Errorf("malformed response: %w", err)
+				return
+			}
+			if response.ID == id {
+				responses <- nil
+				return
+			}
+		}
+		responses <- cmp.Or(stdout.Err(), io.ErrUnexpectedEOF)
+	}()
+	timeout := plugin.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	select {
+	case err = <-responses:
+	case <-time.After(timeout):
+		err = fmt.Errorf("no response within %s", timeout)
+	}
+	if err != nil {
+		plugin.stop() // restarted with the next call
+		return fmt.Errorf("plugin %s: %s: %w", plugin.Path, method, err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("plugin %s: %s: %s", plugin.Path, method, response.Error)
+	}
+	if result != nil && len(response.Result) > 0 {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("plugin %s: %s: malformed result: %w", plugin.Path, method, err)
+		}
+	}
+	return nil
+}
+
+func (plugin *Plugin) start() error {
+	cmd := exec.Command(plugin.Path)
+	cmd.Env = CommandEnv(nil)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			slog.Info(scanner.Text(), "plugin", plugin.Path)
+		}
+	}()
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, maxPluginMessage)
+	plugin.cmd, plugin.stdin, plugin.stdout = cmd, stdin, scanner
+	return nil
+}
+
+// stop kills the program, if it is running.
+func (plugin *Plugin) stop() {
+	if plugin.cmd == nil {
+		return
+	}
+	plugin.stdin.Close()
+	plugin.cmd.Process.Kill()
+	go plugin.cmd.Wait() // the output is still being read
+	plugin.cmd, plugin.stdin, plugin.stdout = nil, nil, nil
+}
@@ -0,0 +1,129 @@
+package webmention_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// TestPluginProcess is the plugin started by TestPlugins.
+func TestPluginProcess(t *testing.T) {
+	if os.Getenv("WEBMENTION_TEST_PLUGIN") != "1" {
+		return
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var request struct {
+			ID     int             `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		json.Unmarshal(scanner.Bytes(), &request)
+		var result any = struct{}{}
+		switch request.Method {
+		case "describe":
+			name := "test"
+			if os.Getenv("MAIL_PASS") != "" {
+				name = "leaked-secret" // the environment is not inherited
+			}
+			result = webmention.PluginDescription{Name: name, Filter: true, Notifier: true, MediaTypes: []string{"text/x-test"}}
+		case "filter":
+			var mention webmention.Mention
+			json.Unmarshal(request.Params, &mention)
+			if strings.Contains(mention.Source.Path, "spam") {
+				result = map[string]string{"reject": "spam"}
+			}
+		case "notify":
+			var mention webmention.Mention
+			json.Unmarshal(request.Params, &mention)
+			fmt.Fprintln(os.Stderr, "notified:", mention.Source) // logged by the receiver
+		case "media":
+			var params struct {
+				Content []byte `json:"content"`
+				Target  string `json:"target"`
+			}
+			json.Unmarshal(request.Params, &params)
+			result = map[string]bool{"link": strings.Contains(string(params.Content), "=> "+params.Target)}
+		}
+		bs, _ := json.Marshal(map[string]any{"id": request.ID, "result": result})
+		fmt.Printf("%s\n", bs)
+	}
+	os.Exit(0)
+}
+
+func TestPlugins(t *testing.T) {
+	dir := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nWEBMENTION_TEST_PLUGIN=1 exec %q -test.run='^TestPluginProcess$'\n", os.Args[0])
+	if err := os.WriteFile(filepath.Join(dir, "test-plugin"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("MAIL_PASS", "secret")
+	plugins, err := webmention.LoadPlugins(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 1 || plugins[0].Name() != "plugin:test" {
+		t.Fatalf("incorrect plugins loaded: %v", plugins)
+	}
+	defer plugins[0].Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/x-test")
+		fmt.Fprintf(w, "# capsule\n=> https://example.org/target\n")
+	}))
+	defer ts.Close()
+
+	processed := make(chan error, 1)
+	recorder := &mentionRecorder{received: make(chan webmention.Mention, 1)}
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithPlugins(plugins...),
+		webmention.WithNotifier(recorder),
+		webmention.WithReporter(func(err error, mention webmention.Mention) {
+			processed <- err
+		}),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	endpoint := httptest.NewServer(receiver)
+	defer endpoint.Close()
+
+	submit := func(source string) error {
+		resp := must(http.DefaultClient.PostForm(endpoint.URL, map[string][]string{
+			"source": {source},
+			"target": {"https://example.org/target"},
+		}))
+		resp.Body.Close()
+		select {
+		case err := <-processed:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		return nil
+	}
+	if err := submit(ts.URL + "/capsule"); err != nil {
+		t.Fatal(err)
+	}
+	if mention := recorder.next(t); mention.Status != webmention.StatusLink {
+		t.Errorf("link not found by plugin media handler: %+v", mention)
+	}
+	if err := submit(ts.URL + "/spam"); !errors.Is(err, webmention.ErrRejected) {
+		t.Errorf("mention not rejected by plugin filter: %v", err)
+	}
+}
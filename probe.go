@@ -0,0 +1,122 @@
+package webmention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// A Prober checks whether it is configured correctly, e.g., whether a mail
+// server accepts its credentials, so that mistakes surface at startup,
+// instead of with the first mention.
+// Notifiers implementing it are probed by Receiver.ProbeNotifiers.
+type Prober interface {
+	Probe(ctx context.Context) error
+}
+
+var (
+	// namedNotifier implements Prober
+	_ Prober = namedNotifier{}
+	// *Relay implements Prober
+	_ Prober = (*Relay)(nil)
+)
+
+// ProbeNotifiers probes all notifiers that implement Prober, concurrently.
+// Failures are logged, and returned by the name of the notifier (its Name,
+// if it is a NamedNotifier, otherwise its type).
+// Until the next probe, the failures are also reported by Ready.
+func (receiver *Receiver) ProbeNotifiers(ctx context.Context) map[string]error {
+	var (
+		m        sync.Mutex
+		wg       sync.WaitGroup
+		failures = map[string]error{}
+	)
+	for _, notifier := range receiver.currentNotifiers() {
+		prober, ok := notifier.(Prober)
+		if !ok {
+			continue
+		}
+		name := fmt.Sprintf("%T", notifier)
+		if named, ok := notifier.(NamedNotifier); ok {
+			name = named.Name()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := prober.Probe(ctx); err != nil {
+				slog.Error(fmt.Sprintf("notifier probe failed: %s", err), "notifier", name)
+				m.Lock()
+				failures[name] = err
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	receiver.probeMu.Lock()
+	receiver.probeFailures = failures
+	receiver.probeMu.Unlock()
+	return maps.Clone(failures)
+}
+
+// Ready reports the notifiers that failed the last probe (see
+// ProbeNotifiers), nil if all of them passed, or none were probed.
+func (receiver *Receiver) Ready() error {
+	receiver.probeMu.Lock()
+	defer receiver.probeMu.Unlock()
+	var err error
+	for _, name := range slices.Sorted(maps.Keys(receiver.probeFailures)) {
+		err = errors.Join(err, fmt.Errorf("%s: %w", name, receiver.probeFailures[name]))
+	}
+	return err
+}
+
+// failingNotifiers returns the names of the notifiers that failed the last probe.
+func (receiver *Receiver) failingNotifiers() []string {
+	receiver.probeMu.Lock()
+	defer receiver.probeMu.Unlock()
+	return slices.Sorted(maps.Keys(receiver.probeFailures))
+}
+
+func (n namedNotifier) Probe(ctx context.Context) error {
+	if prober, ok := n.Notifier.(Prober); ok {
+		return prober.Probe(ctx)
+	}
+	return nil
+}
+
+// Probe checks that the endpoint is reachable, and not failing.
+func (relay *Relay) Probe(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, relay.Endpoint.String(), nil)
+	if err != nil {
+		return fmt.Errorf("relay: %w", err)
+	}
+	req.Header.Set("User-Agent", relay.UserAgent)
+	resp, err := relay.HttpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("relay: %w", err)
+	}
+	resp.Body.Close()
+	// the endpoint may well refuse HEAD requests, but it shouldn't fail
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("relay: %s returned %s", relay.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// ready answers 200, if all notifiers passed the last probe, and 503
+// listing the names of the failing ones otherwise.
+// Details are only logged, the route is meant to be publicly reachable.
+func (h *receiverHandler) ready(w http.ResponseWriter, r *http.Request) {
+	if failing := h.receiver.failingNotifiers(); len(failing) > 0 {
+		http.Error(w, "failing notifiers: "+strings.Join(failing, ", "), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,110 @@
+package webmention
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// dateLayouts are the layouts of dt-published and dt-updated values
+// understood, the microformats2 value class pattern allows a space instead
+// of the T, and omitting the seconds.
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	time.DateOnly,
+}
+
+// SourceDates extracts when the source was published and last updated,
+// from the first dt-published and dt-updated properties of html content.
+// If it has no dt-updated, the Last-Modified header is used instead.
+// Dates that are unknown are zero.
+func SourceDates(content []byte, header http.Header) (published, updated time.Time) {
+	if doc, err := html.Parse(bytes.NewReader(content)); err == nil {
+		published = findDate(doc, "dt-published")
+		updated = findDate(doc, "dt-updated")
+	}
+	if updated.IsZero() {
+		if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+			updated = modified
+		}
+	}
+	return published, updated
+}
+
+// findDate returns the date of the first element with class, zero if
+// there is none, or it can't be parsed.
+func findDate(node *html.Node, class string) time.Time {
+	if node.Type == html.ElementNode {
+		for _, a := range node.Attr {
+			if a.Key == "class" && hasClass(a.Val, class) {
+				return parseDate(dateValue(node))
+			}
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if t := findDate(child, class); !t.IsZero() {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dateValue is the value of a dt-* property: the datetime attribute of
+// <time>, <ins>, and <del>, the title of <abbr>, the value of <data> and
+// <input>, or the text of any other element.
+func dateValue(node *html.Node) string {
+	attr := map[string]string{
+		"time": "datetime", "ins": "datetime", "del": "datetime",
+		"abbr": "title", "data": "value", "input": "value",
+	}[node.Data]
+	for _, a := range node.Attr {
+		if attr != "" && a.Key == attr {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	var text strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			text.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			collect(child)
+		}
+	}
+	collect(node)
+	return strings.TrimSpace(text.String())
+}
+
+// parseDate parses a date in one of the dateLayouts, dates without a time
+// zone are taken to be UTC.
+func parseDate(value string) time.Time {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Written is when the source of mention was written: when it was
+// published, or else last updated, or else when the mention was received.
+func (mention Mention) Written() time.Time {
+	switch {
+	case !mention.Published.IsZero():
+		return mention.Published
+	case !mention.Updated.IsZero():
+		return mention.Updated
+	}
+	return mention.Received
+}
@@ -0,0 +1,138 @@
+package webmention
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// MentionQuery selects a page of stored mentions.
+	MentionQuery struct {
+		Filter MentionFilter
+		// Newest returns the newest mentions first.
+		Newest bool
+		// ByWritten orders mentions by the time their source was written
+		// (see Mention.Written), instead of the time they were received.
+		ByWritten bool
+		// Limit is the maximum number of mentions per page (default and maximum: MaxPageSize).
+		Limit int
+		// Cursor continues where a previous page left off (MentionPage.Next).
+		Cursor string
+	}
+
+	// MentionPage is a page of mentions.
+	MentionPage struct {
+		Mentions []Mention `json:"mentions"`
+		// Next is the cursor for the next page, empty if this is the last page.
+		Next string `json:"next,omitempty"`
+	}
+
+	// pagePosition is a position in the order of mentions, it is stable
+	// across inserts, since it doesn't depend on the index of a mention.
+	pagePosition struct {
+		received time.Time
+		key      string
+	}
+)
+
+// MaxPageSize is the largest number of mentions returned in a single page.
+const MaxPageSize = 500
+
+// QueryMentions returns a page of the stored mentions matching the query.
+// Mentions are ordered by the time they were received, or their source was
+// written (ties are broken by source and target).
+func (receiver *Receiver) QueryMentions(query MentionQuery) (MentionPage, error) {
+	if receiver.storage == nil {
+		return MentionPage{}, ErrNoStorage
+	}
+	limit := query.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	var after *pagePosition
+	if query.Cursor != "" {
+		pos, err := parseCursor(query.Cursor)
+		if err != nil {
+			return MentionPage{}, err
+		}
+		after = &pos
+	}
+
+	mentions, err := receiver.storage.Mentions(query.Filter)
+	if err != nil {
+		return MentionPage{}, err
+	}
+	compare := func(a, b pagePosition) int {
+		return cmp.Or(a.received.Compare(b.received), strings.Compare(a.key, b.key))
+	}
+	if query.Newest {
+		compare = func(a, b pagePosition) int {
+			return cmp.Or(b.received.Compare(a.received), strings.Compare(b.key, a.key))
+		}
+	}
+	position := positionOf
+	if query.ByWritten {
+		position = writtenPositionOf
+	}
+	slices.SortFunc(mentions, func(a, b Mention) int {
+		return compare(position(a), position(b))
+	})
+	if after != nil {
+		start, _ := slices.BinarySearchFunc(mentions, *after, func(m Mention, pos pagePosition) int {
+			if compare(position(m), pos) <= 0 {
+				return -1
+			}
+			return 1
+		})
+		mentions = mentions[start:]
+	}
+
+	page := MentionPage{Mentions: mentions}
+	if len(mentions) > limit {
+		page.Mentions = mentions[:limit]
+		page.Next = position(page.Mentions[limit-1]).cursor()
+	}
+	if page.Mentions == nil {
+		page.Mentions = []Mention{}
+	}
+	return page, nil
+}
+
+func positionOf(mention Mention) pagePosition {
+	return pagePosition{
+		received: mention.Received,
+		key:      mention.Source.String() + " " + mention.Target.String(),
+	}
+}
+
+// writtenPositionOf is the position of mention when ordered by ByWritten.
+func writtenPositionOf(mention Mention) pagePosition {
+	pos := positionOf(mention)
+	pos.received = mention.Written()
+	return pos
+}
+
+func (pos pagePosition) cursor() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(pos.received.UnixNano(), 10) + " " + pos.key))
+}
+
+func parseCursor(cursor string) (pagePosition, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pagePosition{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	nanos, key, ok := strings.Cut(string(bs), " ")
+	if !ok {
+		return pagePosition{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return pagePosition{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return pagePosition{received: time.Unix(0, n), key: key}, nil
+}
@@ -0,0 +1,138 @@
+package webmention_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestQueryMentions(t *testing.T) {
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	receiver := webmention.NewReceiver(webmention.WithStorage(storage))
+	day := time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC)
+	store := func(i int, host string) {
+		storage.Store(webmention.Mention{
+			Source:   must(url.Parse(fmt.Sprintf("https://%s/%d", host, i))),
+			Target:   must(url.Parse("https://example.org/post")),
+			Status:   webmention.StatusLink,
+			Received: day.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	for i := range 5 {
+		store(i, "a.example")
+	}
+
+	sources := func(page webmention.MentionPage) (s []string) {
+		for _, m := range page.Mentions {
+			s = append(s, m.Source.Path)
+		}
+		return s
+	}
+
+	first := must(receiver.QueryMentions(webmention.MentionQuery{Limit: 2}))
+	if fmt.Sprint(sources(first)) != "[/0 /1]" || first.Next == "" {
+		t.Fatalf("incorrect first page: %v, next: %q", sources(first), first.Next)
+	}
+	store(10, "b.example") // inserts must not shift the following pages
+	second := must(receiver.QueryMentions(webmention.MentionQuery{Limit: 2, Cursor: first.Next}))
+	if fmt.Sprint(sources(second)) != "[/2 /3]" {
+		t.Errorf("incorrect second page: %v", sources(second))
+	}
+	third := must(receiver.QueryMentions(webmention.MentionQuery{Limit: 2, Cursor: second.Next}))
+	if fmt.Sprint(sources(third)) != "[/4 /10]" || third.Next != "" {
+		t.Errorf("incorrect last page: %v, next: %q", sources(third), third.Next)
+	}
+
+	newest := must(receiver.QueryMentions(webmention.MentionQuery{Newest: true, Limit: 2}))
+	if fmt.Sprint(sources(newest)) != "[/10 /4]" {
+		t.Errorf("incorrect newest first page: %v", sources(newest))
+	}
+	filtered := must(receiver.QueryMentions(webmention.MentionQuery{Filter: webmention.MentionFilter{SourceDomain: "example", Type: webmention.TypeMention}}))
+	if len(filtered.Mentions) != 6 {
+		t.Errorf("incorrect number of mentions on domain: %d", len(filtered.Mentions))
+	}
+	if _, err := receiver.QueryMentions(webmention.MentionQuery{Cursor: "garbage"}); err == nil {
+		t.Error("invalid cursor accepted")
+	}
+}
+
+func TestQueryByWritten(t *testing.T) {
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	receiver := webmention.NewReceiver(webmention.WithStorage(storage))
+	day := time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC)
+	for i, written := range []struct{ published, updated time.Time }{
+		{published: day.Add(-48 * time.Hour)},
+		{},
+		{updated: day.Add(-24 * time.Hour)},
+	} {
+		storage.Store(webmention.Mention{
+			Source:    must(url.Parse(fmt.Sprintf("https://a.example/%d", i))),
+			Target:    must(url.Parse("https://example.org/post")),
+			Status:    webmention.StatusLink,
+			Received:  day.Add(time.Duration(i) * time.Hour),
+			Published: written.published,
+			Updated:   written.updated,
+		})
+	}
+	var sources []string
+	first := must(receiver.QueryMentions(webmention.MentionQuery{ByWritten: true, Limit: 2}))
+	second := must(receiver.QueryMentions(webmention.MentionQuery{ByWritten: true, Limit: 2, Cursor: first.Next}))
+	for _, m := range append(first.Mentions, second.Mentions...) {
+		sources = append(sources, m.Source.Path)
+	}
+	if fmt.Sprint(sources) != "[/0 /2 /1]" {
+		t.Errorf("incorrect order by written: %v", sources)
+	}
+	stored := must(storage.Mentions(webmention.MentionFilter{}))
+	if !stored[0].Published.Equal(day.Add(-48*time.Hour)) || !stored[0].Updated.IsZero() {
+		t.Errorf("dates not stored: %+v", stored[0])
+	}
+}
+
+func TestSourceDates(t *testing.T) {
+	modified := http.Header{"Last-Modified": {"Tue, 05 Nov 2024 10:00:00 GMT"}}
+	for _, testCase := range []struct {
+		name               string
+		content            string
+		header             http.Header
+		published, updated string
+	}{
+		{
+			name:      "time elements",
+			content:   `<article class="h-entry"><time class="dt-published" datetime="2024-11-01T08:30:00+01:00">Nov 1</time><time class="dt-updated" datetime="2024-11-02">Nov 2</time></article>`,
+			header:    modified,
+			published: "2024-11-01T08:30:00+01:00",
+			updated:   "2024-11-02T00:00:00Z",
+		},
+		{
+			name:      "text and last modified",
+			content:   `<div class="h-entry"><span class="p-name">Hi</span><span class="dt-published"> 2024-11-01 08:30 </span></div>`,
+			header:    modified,
+			published: "2024-11-01T08:30:00Z",
+			updated:   "2024-11-05T10:00:00Z",
+		},
+		{
+			name:    "unknown",
+			content: `<p>no dates</p><time class="dt-published">yesterday</time>`,
+			header:  http.Header{},
+		},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			published, updated := webmention.SourceDates([]byte(testCase.content), testCase.header)
+			format := func(t time.Time) string {
+				if t.IsZero() {
+					return ""
+				}
+				return t.Format(time.RFC3339)
+			}
+			if format(published) != testCase.published || format(updated) != testCase.updated {
+				t.Errorf("incorrect dates, got: %q, %q, want: %q, %q", format(published), format(updated), testCase.published, testCase.updated)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package webmention
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+type (
+	// A Queue holds mentions between being received by the http handler and
+	// being processed by ProcessMentions.
+	Queue interface {
+		// Push adds a mention to the queue.
+		// If the queue cannot take any more mentions, ErrQueueFull must be returned.
+		// After the queue has been closed, ErrQueueClosed must be returned.
+		Push(mention Mention) error
+
+		// Pop removes the oldest mention from the queue.
+		// It blocks until a mention is available, ctx is done, or the queue has been closed.
+		// Once the queue is closed, Pop returns ErrQueueClosed, after
+		// returning all remaining mentions, if the queue is in-process.
+		Pop(ctx context.Context) (Mention, error)
+
+		// Close stops the queue from accepting new mentions.
+		Close() error
+	}
+
+	// An AckQueue redelivers mentions that have been popped, but not
+	// acknowledged after some time, e.g., because the process that was
+	// working on them crashed.
+	// The receiver acknowledges every mention after processing it.
+	AckQueue interface {
+		Queue
+		Ack(mention Mention) error
+	}
+
+	// A StatsQueue can tell how many mentions are waiting in it.
+	StatsQueue interface {
+		Queue
+		Stats() (QueueStats, error)
+	}
+
+	// QueueStats describe the mentions waiting in a queue, see Receiver.QueueStats.
+	QueueStats struct {
+		// Depth is the number of mentions in the queue.
+		Depth int
+		// Capacity is the number of mentions the queue can hold, zero if unlimited.
+		Capacity int
+		// Oldest is the time the longest waiting mention was enqueued, zero
+		// if the queue is empty.
+		Oldest time.Time
+		// Processed counts the mentions that reached each processing state,
+		// since this receiver was started.
+		// It is only set by Receiver.QueueStats.
+		Processed map[ProcessingState]uint64
+	}
+
+	// ChannelQueue is the default queue, backed by a buffered channel.
+	// Mentions are lost if the process exits before processing them.
+	ChannelQueue struct {
+		m      sync.RWMutex
+		ch     chan Mention
+		closed bool
+		// enqueued are the times the mentions in ch were pushed, oldest first
+		enqueuedMu sync.Mutex
+		enqueued   []time.Time
+	}
+
+	// PostgresQueue is a queue shared by multiple receivers through a
+	// PostgreSQL table.
+	// Popped mentions are leased to the popping receiver for LeaseTime, if
+	// they are not acknowledged in time, another receiver will pick them up.
+	// Make sure to import a postgres driver (e.g., github.com/lib/pq) and
+	// call Migrate before using the queue.
+	PostgresQueue struct {
+		DB           *sql.DB
+		Table        string
+		LeaseTime    time.Duration
+		PollInterval time.Duration
+		// MaxSize limits the number of mentions in the table (waiting or
+		// leased), zero means no limit.
+		// Concurrent pushes may exceed it slightly.
+		MaxSize int
+		m       sync.RWMutex
+		closed  bool
+	}
+)
+
+// *ChannelQueue implements StatsQueue, *PostgresQueue implements AckQueue and StatsQueue
+var (
+	_ StatsQueue = (*ChannelQueue)(nil)
+	_ AckQueue   = (*PostgresQueue)(nil)
+	_ StatsQueue = (*PostgresQueue)(nil)
+)
+
+// QueueStats reports how full the receiver's queue is, and how many mentions
+// have been processed so far, e.g., for alerting before senders start
+// getting http.StatusTooManyRequests.
+// Depth, Capacity, and Oldest are only known if the queue is a StatsQueue.
+func (receiver *Receiver) QueueStats() (QueueStats, error) {
+	var stats QueueStats
+	if queue, ok := receiver.queue.(StatsQueue); ok {
+		var err error
+		stats, err = queue.Stats()
+		if err != nil {
+			return stats, fmt.Errorf("queue stats: %w", err)
+		}
+	}
+	m := &receiver.metrics
+	m.m.Lock()
+	defer m.m.Unlock()
+	stats.Processed = maps.Clone(m.states)
+	if stats.Processed == nil {
+		stats.Processed = map[ProcessingState]uint64{}
+	}
+	return stats, nil
+}
+
+func NewChannelQueue(size int) *ChannelQueue {
+	return &ChannelQueue{ch: make(chan Mention, size)}
+}
+
+func (q *ChannelQueue) Push(mention Mention) error {
+	q.m.RLock()
+	defer q.m.RUnlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	q.enqueuedMu.Lock()
+	defer q.enqueuedMu.Unlock()
+	select {
+	case q.ch <- mention:
+		q.enqueued = append(q.enqueued, time.Now())
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *ChannelQueue) Pop(ctx context.Context) (Mention, error) {
+	select {
+	case <-ctx.Done():
+		return Mention{}, ctx.Err()
+	case mention, ok := <-q.ch:
+		if !ok {
+			return Mention{}, ErrQueueClosed
+		}
+		q.enqueuedMu.Lock()
+		q.enqueued = q.enqueued[1:]
+		q.enqueuedMu.Unlock()
+		return mention, nil
+	}
+}
+
+func (q *ChannelQueue) Stats() (QueueStats, error) {
+	q.enqueuedMu.Lock()
+	defer q.enqueuedMu.Unlock()
+	stats := QueueStats{
+		Depth:    len(q.enqueued),
+		Capacity: cap(q.ch),
+	}
+	if len(q.enqueued) > 0 {
+		stats.Oldest = q.enqueued[0]
+	}
+	return stats, nil
+}
+
+func (q *ChannelQueue) Close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	return nil
+}
+
+func NewPostgresQueue(db *sql.DB) *PostgresQueue {
+	return &PostgresQueue{
+		DB:           db,
+		Table:        "webmention_queue",
+		LeaseTime:    5 * time.Minute,
+		PollInterval: time.Second,
+	}
+}
+
+// Migrate creates the queue table if it doesn't exist yet.
+func (q *PostgresQueue) Migrate(ctx context.Context) error {
+	_, err := q.DB.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id TEXT PRIMARY KEY,
+		mention JSONB NOT NULL,
+		enqueued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		locked_until TIMESTAMPTZ
+	)`, q.Table))
+	return err
+}
+
+func (q *PostgresQueue) isClosed() bool {
+	q.m.RLock()
+	defer q.m.RUnlock()
+	return q.closed
+}
+
+func (q *PostgresQueue) Push(mention Mention) error {
+	if q.isClosed() {
+		return ErrQueueClosed
+	}
+	bs, err := json.Marshal(mention)
+	if err != nil {
+		return err
+	}
+	if q.MaxSize <= 0 {
+		_, err = q.DB.Exec(fmt.Sprintf(`INSERT INTO %s (id, mention) VALUES ($1, $2)`, q.Table), mention.ID, bs)
+		return err
+	}
+	res, err := q.DB.Exec(fmt.Sprintf(`INSERT INTO %[1]s (id, mention)
+		SELECT $1, $2 WHERE (SELECT count(*) FROM %[1]s) < $3`, q.Table), mention.ID, bs, q.MaxSize)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrQueueFull
+	}
+	return nil
+}
+
+// Pop polls the table until a mention is available.
+// Once the queue is closed, Pop returns ErrQueueClosed immediately, leaving
+// any remaining mentions to the other receivers.
+func (q *PostgresQueue) Pop(ctx context.Context) (Mention, error) {
+	for {
+		if q.isClosed() {
+			return Mention{}, ErrQueueClosed
+		}
+		mention, err := q.tryPop(ctx)
+		if err == nil {
+			return mention, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return Mention{}, err
+		}
+		select {
+		case <-ctx.Done():
+			return Mention{}, ctx.Err()
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+func (q *PostgresQueue) tryPop(ctx context.Context) (mention Mention, err error) {
+	var bs []byte
+	err = q.DB.QueryRowContext(ctx, fmt.Sprintf(`UPDATE %[1]s SET locked_until = now() + make_interval(secs => $1)
+		WHERE id = (
+			SELECT id FROM %[1]s
+			WHERE locked_until IS NULL OR locked_until < now()
+			ORDER BY enqueued_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING mention`, q.Table), q.LeaseTime.Seconds()).Scan(&bs)
+	if err != nil {
+		return mention, err
+	}
+	return mention, json.Unmarshal(bs, &mention)
+}
+
+func (q *PostgresQueue) Ack(mention Mention) error {
+	_, err := q.DB.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, q.Table), mention.ID)
+	return err
+}
+
+// Stats counts all mentions in the table, including those leased to a receiver.
+func (q *PostgresQueue) Stats() (QueueStats, error) {
+	stats := QueueStats{Capacity: q.MaxSize}
+	var oldest sql.NullTime
+	err := q.DB.QueryRow(fmt.Sprintf(`SELECT count(*), min(enqueued_at) FROM %s`, q.Table)).Scan(&stats.Depth, &oldest)
+	if err != nil {
+		return stats, err
+	}
+	stats.Oldest = oldest.Time
+	return stats, nil
+}
+
+// Close stops this instance from pushing or popping mentions.
+// The database connection is not closed.
+func (q *PostgresQueue) Close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.closed = true
+	return nil
+}
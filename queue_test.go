@@ -0,0 +1,220 @@
+package webmention_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+type (
+	// fakeDB is a database/sql driver that records the statements it is
+	// given, and answers them with canned results, so that the
+	// PostgresQueue can be tested without a database.
+	fakeDB struct {
+		m          sync.Mutex
+		statements []fakeStatement
+		// affected is the number of rows every Exec reports
+		affected int64
+		// rows are returned by Query, one column each, in order
+		rows [][]byte
+	}
+
+	fakeStatement struct {
+		query string
+		args  []driver.NamedValue
+	}
+
+	fakeConn struct {
+		db *fakeDB
+	}
+
+	fakeRows struct {
+		row  []byte
+		done bool
+	}
+)
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return nil }
+
+func (db *fakeDB) last() fakeStatement {
+	db.m.Lock()
+	defer db.m.Unlock()
+	return db.statements[len(db.statements)-1]
+}
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.m.Lock()
+	defer c.db.m.Unlock()
+	c.db.statements = append(c.db.statements, fakeStatement{query, args})
+	return driver.RowsAffected(c.db.affected), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.m.Lock()
+	defer c.db.m.Unlock()
+	c.db.statements = append(c.db.statements, fakeStatement{query, args})
+	rows := &fakeRows{done: len(c.db.rows) == 0}
+	if !rows.done {
+		rows.row, c.db.rows = c.db.rows[0], c.db.rows[1:]
+	}
+	return rows, nil
+}
+
+func (r *fakeRows) Columns() []string { return []string{"mention"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.row
+	return nil
+}
+
+func TestChannelQueueStats(t *testing.T) {
+	queue := webmention.NewChannelQueue(2)
+	if stats := must(queue.Stats()); stats.Depth != 0 || stats.Capacity != 2 || !stats.Oldest.IsZero() {
+		t.Errorf("incorrect stats of empty queue: %+v", stats)
+	}
+	before := time.Now()
+	for _, id := range []string{"1", "2"} {
+		if err := queue.Push(webmention.Mention{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := must(queue.Stats())
+	if stats.Depth != 2 || stats.Oldest.Before(before) {
+		t.Errorf("incorrect stats of full queue: %+v", stats)
+	}
+	oldest := stats.Oldest
+	must(queue.Pop(context.Background()))
+	if stats := must(queue.Stats()); stats.Depth != 1 || stats.Oldest.Before(oldest) {
+		t.Errorf("incorrect stats after pop: %+v", stats)
+	}
+}
+
+func TestFileQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	queue := must(webmention.NewFileQueue(path, 3))
+	for _, id := range []string{"1", "2", "3"} {
+		if err := queue.Push(webmention.Mention{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queue.Push(webmention.Mention{ID: "4"}); !errors.Is(err, webmention.ErrQueueFull) {
+		t.Errorf("push to full queue, got: %v, want: %v", err, webmention.ErrQueueFull)
+	}
+	if mention := must(queue.Pop(context.Background())); mention.ID != "1" {
+		t.Errorf("incorrect mention popped, got: %s, want: 1", mention.ID)
+	}
+	if err := queue.Ack(webmention.Mention{ID: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if mention := must(queue.Pop(context.Background())); mention.ID != "2" {
+		t.Errorf("incorrect mention popped, got: %s, want: 2", mention.ID)
+	}
+	if stats := must(queue.Stats()); stats.Depth != 2 || stats.Capacity != 3 {
+		t.Errorf("incorrect stats: %+v", stats)
+	}
+
+	// 2 was not acknowledged before the "crash", it is delivered again
+	reopened := must(webmention.NewFileQueue(path, 3))
+	for _, want := range []string{"2", "3"} {
+		mention := must(reopened.Pop(context.Background()))
+		if mention.ID != want {
+			t.Errorf("incorrect mention popped after reopening, got: %s, want: %s", mention.ID, want)
+		}
+		if err := reopened.Ack(mention); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := reopened.Pop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("pop from empty queue, got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	popped := make(chan error)
+	go func() {
+		_, err := reopened.Pop(context.Background())
+		popped <- err
+	}()
+	reopened.Close()
+	if err := <-popped; !errors.Is(err, webmention.ErrQueueClosed) {
+		t.Errorf("pop from closed queue, got: %v, want: %v", err, webmention.ErrQueueClosed)
+	}
+	if err := reopened.Push(webmention.Mention{ID: "5"}); !errors.Is(err, webmention.ErrQueueClosed) {
+		t.Errorf("push to closed queue, got: %v, want: %v", err, webmention.ErrQueueClosed)
+	}
+}
+
+func TestPostgresQueue(t *testing.T) {
+	fake := &fakeDB{affected: 1}
+	queue := webmention.NewPostgresQueue(sql.OpenDB(fake))
+	queue.PollInterval = time.Millisecond
+	mention := webmention.Mention{
+		ID:     "1",
+		Source: must(url.Parse("https://example.com/post")),
+		Target: must(url.Parse("https://example.org/post")),
+	}
+
+	if err := queue.Push(mention); err != nil {
+		t.Fatal(err)
+	}
+	if stmt := fake.last(); !strings.HasPrefix(stmt.query, "INSERT INTO webmention_queue") || len(stmt.args) != 2 {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+
+	queue.MaxSize = 2
+	if err := queue.Push(mention); err != nil {
+		t.Fatal(err)
+	}
+	if stmt := fake.last(); !strings.Contains(stmt.query, "count(*)") || len(stmt.args) != 3 || stmt.args[2].Value != int64(2) {
+		t.Errorf("size limit not part of the statement: %+v", stmt)
+	}
+	fake.affected = 0 // the table is full, nothing was inserted
+	if err := queue.Push(mention); !errors.Is(err, webmention.ErrQueueFull) {
+		t.Errorf("push to full queue, got: %v, want: %v", err, webmention.ErrQueueFull)
+	}
+
+	fake.rows = [][]byte{must(json.Marshal(mention))}
+	popped, err := queue.Pop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if popped.ID != mention.ID || popped.Source.String() != mention.Source.String() {
+		t.Errorf("incorrect mention popped, got: %+v, want: %+v", popped, mention)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := queue.Pop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("pop from empty queue, got: %v, want: %v", err, context.DeadlineExceeded)
+	}
+	if err := queue.Ack(popped); err != nil {
+		t.Fatal(err)
+	}
+	if stmt := fake.last(); !strings.HasPrefix(stmt.query, "DELETE FROM webmention_queue") || stmt.args[0].Value != "1" {
+		t.Errorf("unexpected statement: %+v", stmt)
+	}
+
+	queue.Close()
+	if err := queue.Push(mention); !errors.Is(err, webmention.ErrQueueClosed) {
+		t.Errorf("push to closed queue, got: %v, want: %v", err, webmention.ErrQueueClosed)
+	}
+}
@@ -1,33 +1,105 @@
 package webmention
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"golang.org/x/net/html"
 	"io"
 	"log/slog"
+	"maps"
 	mimelib "mime"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"reflect"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type (
 	// Receiver is a http.Handler that takes care of processing webmentions.
 	Receiver struct {
-		enqueue       chan<- Mention
-		dequeue       <-chan Mention
-		notifiers     []Notifier
-		httpClient    *http.Client
-		shutdown      chan struct{}
-		targetAccepts TargetAcceptsFunc
-		mediaHandler  mediaRegister
-		userAgent     string
-		mentionCache  map[mentionCacheEntry]time.Time
-		cacheTimeout  time.Duration
+		queue            Queue
+		notifiers        []Notifier
+		httpClient       *http.Client
+		fetcher          SourceFetcher
+		recordSources    string
+		shutdown         chan struct{}
+		targetAccepts    TargetAcceptsFunc
+		closedTargets    ClosedFunc
+		targetCheck      TargetCheckFunc
+		selfMentions     SelfMentions
+		targetResolver   TargetResolver
+		mediaHandler     mediaRegister
+		userAgent        string
+		mentionCache     KeyValueStore
+		cacheTimeout     time.Duration
+		sniffContent     bool
+		extensionHints   map[string]string
+		checkAlternates  bool
+		canonicalSources bool
+		analytics        AnalyticsPolicy
+		headers          http.Header
+		maxBodySize      int64
+		terseErrors      bool
+		sanitizeErrors   bool
+		storage          Storage
+		spamScorer       SpamScorer
+		spamThreshold    float64
+		moderation       ModerationQueue
+		moderators       []Notifier
+		filters          []Filter
+		trustedKeys      map[string]TrustedKey
+		trustedProxies   []netip.Prefix
+		metrics          metrics
+		throughput       throughput
+		dial             dialConfig
+		notificationLog  NotificationLog
+		maxRetries       int
+		retryDelay       time.Duration
+		deadLetters      DeadLetterStore
+		retriesMu        sync.Mutex
+		retries          map[string]retry
+		historyMu        sync.Mutex
+		// listenersMu guards notifiers and filters, which are replaced, never
+		// modified in place, so that a snapshot can be used without holding the lock
+		listenersMu sync.RWMutex
+		reporter    func(err error, mention Mention)
+		// probeFailures are the notifiers that failed the last probe, by name
+		probeMu       sync.Mutex
+		probeFailures map[string]error
+		// usage accounts the mentions and fetched bytes per target domain, nil if disabled
+		usage *UsageMeter
+		// sourceFetch is how sources are fetched, see WithSourceFetch
+		sourceFetch FetchStrategy
+		// ignoreWalls disables the detection of login and consent walls
+		ignoreWalls bool
+		// duplicateArguments decides what to do about requests naming the source or target more than once
+		duplicateArguments DuplicateArguments
+		// selfDescription answers GET and HEAD requests to the endpoint with a description of it
+		selfDescription bool
+		// httpCache caches the responses to source fetches, nil if disabled
+		httpCache *HTTPCache
+		// sourceEndpoints records the endpoints advertised by sources, nil if disabled
+		sourceEndpoints EndpointLog
+		// storeMu makes comparing a mention to the stored one and replacing it atomic
+		storeMu sync.Mutex
+		// outboxOnce sends the notifications left in the outbox on the first call of ProcessMentions
+		outboxOnce sync.Once
+	}
+
+	// retry is a mention waiting to be processed again.
+	retry struct {
+		timer   *time.Timer
+		mention Mention
+		reason  error
 	}
 
 	mentionCacheEntry struct {
@@ -49,8 +121,82 @@ type (
 	MediaHandler   func(sourceData io.Reader, target URL) (Status, error)
 	ReceiverOption func(*Receiver)
 	Mention        struct {
+		// ID uniquely identifies a submission.
+		// A new ID is assigned every time a mention is (re-)sent to the endpoint.
+		ID string
+
 		Source, Target URL
 		Status         Status
+		// Reason tells why the source is unavailable, e.g., "451 Unavailable
+		// For Legal Reasons", only set for StatusUnavailable.
+		Reason string
+
+		// TargetID is the identifier the TargetResolver mapped the target to.
+		// Empty if no resolver is configured.
+		TargetID string
+
+		// Received is the time the mention was submitted to the endpoint.
+		Received time.Time
+
+		// SpamScore as determined by the SpamScorer, zero if no scorer is configured.
+		SpamScore float64
+
+		// SignedBy is the id of the trusted key the submission was signed
+		// with, empty if it was not signed (by a key trusted for the source).
+		// Signed mentions are verified too, but skip the spam and reputation checks.
+		SignedBy string
+
+		// Type of the mention (like, reply, ...), only set if the source links to target.
+		Type MentionType
+
+		// Attempts is the number of times processing the mention has been retried.
+		Attempts int
+
+		// SelfMention is set if the source is the target itself, and self
+		// mentions are marked instead of rejected (see WithSelfMentions).
+		SelfMention bool
+
+		// TraceParent is the W3C Trace Context the mention was submitted
+		// with, empty if it had none (see TraceParentHeader).
+		TraceParent string
+
+		// Fetch is how the source was fetched to verify the mention (see
+		// WithSourceFetch), empty if it wasn't fetched.
+		Fetch FetchStrategy
+
+		// Extensions are the form parameters of the submission other than
+		// source and target, e.g., vouch, for filters and notifiers
+		// implementing Webmention extensions.
+		Extensions map[string]string
+
+		// SubmittedSource is the source as it was submitted, if the mention
+		// is stored under the canonical url of the source instead (see
+		// WithCanonicalSources), nil otherwise.
+		SubmittedSource URL
+
+		// SourceEndpoint is the Webmention endpoint advertised by the
+		// source, nil if it advertises none, or the source doesn't link to
+		// the target. It saves discovering the endpoint again to reply to
+		// the source, or to propagate the mention (Salmention).
+		SourceEndpoint URL
+
+		// Change tells whether the mention is new, an update of a stored
+		// mention, or removes it, empty if the receiver has no storage
+		// (see WithStorage).
+		Change MentionChange
+
+		// Published and Updated are when the source was published and last
+		// updated, as declared by its dt-published and dt-updated (or
+		// Last-Modified header), zero if unknown (see SourceDates).
+		Published, Updated time.Time
+
+		// Content is the text of the source's content (its e-content, or
+		// else the paragraph linking to the target), shortened to 2000
+		// characters, only set if the source links to the target.
+		Content string
+		// PreviousContent is the content of the stored version of the
+		// mention, if it was updated and its content changed, see ContentDiff.
+		PreviousContent string
 	}
 	Status            string
 	TargetAcceptsFunc func(source, target URL) bool
@@ -61,6 +207,7 @@ type (
 	//   - StatusLink: the source (still, or newly) links to target
 	//   - StatusNoLink: the source does not (anymore) link to target
 	//   - StatusDeleted: the source got deleted
+	//   - StatusUnavailable: the source is unavailable for good, see Mention.Reason
 	Notifier interface {
 		Receive(mention Mention)
 	}
@@ -95,38 +242,73 @@ func (mr mediaRegister) String() string {
 
 const (
 	defaultRequestQueueSize = 100
+	maxSourceSize           = 8 << 20
 )
 
 const (
 	StatusLink    Status = "source links to target"
 	StatusNoLink         = "source does not link to target"
 	StatusDeleted        = "source itself got deleted"
+	// StatusInaccessible is the status of a mention whose source is behind
+	// a login or consent wall (see WithWallDetection), it is retried, and
+	// notifiers are not informed.
+	StatusInaccessible = "source is not accessible"
+	// StatusUnavailable is the status of a mention whose source answered
+	// with a permanent error status, such as 451 Unavailable For Legal
+	// Reasons, Mention.Reason tells which. It is not retried, and notifiers
+	// are informed, like for a deleted source.
+	StatusUnavailable = "source is unavailable"
 )
 
 // Report may be reassigned to handle 'unhandled' errors related to mention.
+// It is called for every processed mention (err is nil on success), unless
+// the receiver has its own reporter (see WithReporter).
+// Reassign it before any receiver is started, it is not synchronized.
 var Report = func(err error, mention Mention) {
 }
 
+// WithReporter handles the errors of this receiver with report, instead of
+// the package-level Report.
+// Like Report, it is called for every processed mention.
+func WithReporter(report func(err error, mention Mention)) ReceiverOption {
+	return func(r *Receiver) {
+		r.reporter = report
+	}
+}
+
+// report passes err on to the receiver's reporter, or Report if there is none.
+func (receiver *Receiver) report(err error, mention Mention) {
+	if receiver.reporter != nil {
+		receiver.reporter(err, mention)
+		return
+	}
+	Report(err, mention)
+}
+
 func (f NotifierFunc) Receive(mention Mention) {
 	f(mention)
 }
 
 func NewReceiver(opts ...ReceiverOption) *Receiver {
-	queue := make(chan Mention, defaultRequestQueueSize)
 	receiver := &Receiver{
 		httpClient: http.DefaultClient,
-		enqueue:    queue,
-		dequeue:    queue,
+		queue:      NewChannelQueue(defaultRequestQueueSize),
 		shutdown:   make(chan struct{}),
 		targetAccepts: func(URL, URL) bool {
 			return false
 		},
-		userAgent:    "Webmention (github.com/cvanloo/gowebmention)",
-		mentionCache: map[mentionCacheEntry]time.Time{},
-		cacheTimeout: 3 * time.Hour,
+		userAgent:      defaultUserAgent(),
+		mentionCache:   NewMemoryKeyValueStore(),
+		cacheTimeout:   3 * time.Hour,
+		sniffContent:   true,
+		extensionHints: maps.Clone(DefaultExtensionHints),
+		headers: http.Header{
+			"X-Content-Type-Options": {"nosniff"},
+		},
 	}
 	receiver.mediaHandler = mediaRegister{
 		{name: "text/html", qweight: 1.0, handler: HtmlHandler},
+		{name: "text/markdown", qweight: 0.5, handler: MarkdownHandler},
 		{name: "text/plain", qweight: 0.1, handler: PlainHandler},
 	}
 	for _, opt := range opts {
@@ -134,6 +316,19 @@ func NewReceiver(opts ...ReceiverOption) *Receiver {
 			opt(receiver)
 		}
 	}
+	receiver.httpClient = receiver.dial.client(receiver.httpClient)
+	if receiver.httpCache != nil {
+		receiver.httpClient = receiver.httpCache.Client(receiver.httpClient)
+	}
+	if receiver.fetcher == nil {
+		receiver.fetcher = receiver.httpClient
+	}
+	if receiver.recordSources != "" {
+		receiver.fetcher = RecordingFetcher{Fetcher: receiver.fetcher, Dir: receiver.recordSources}
+	}
+	if receiver.spamScorer != nil && receiver.moderation == nil {
+		receiver.moderation = &MemoryModerationQueue{}
+	}
 	return receiver
 }
 
@@ -152,12 +347,73 @@ func WithCacheTimeout(d time.Duration) ReceiverOption {
 	}
 }
 
+// WithContentSniffing configures whether the content type of a source should
+// be sniffed (see http.DetectContentType) if the source server sends no, or an
+// unsupported Content-Type.
+// Sniffing is enabled by default, strict deployments may want to disable it
+// (together with WithExtensionHints).
+func WithContentSniffing(enabled bool) ReceiverOption {
+	return func(r *Receiver) {
+		r.sniffContent = enabled
+	}
+}
+
+// WithMentionCache configures where the receiver remembers recently received
+// mentions (see WithCacheTimeout).
+// Receivers sharing a queue should also share their mention cache.
+func WithMentionCache(cache KeyValueStore) ReceiverOption {
+	return func(r *Receiver) {
+		r.mentionCache = cache
+	}
+}
+
 func WithNotifier(notifiers ...Notifier) ReceiverOption {
 	return func(r *Receiver) {
 		r.notifiers = append(r.notifiers, notifiers...)
 	}
 }
 
+// AddNotifier registers notifiers while the receiver is running.
+// They are informed about all mentions processed from now on.
+func (receiver *Receiver) AddNotifier(notifiers ...Notifier) {
+	receiver.listenersMu.Lock()
+	defer receiver.listenersMu.Unlock()
+	receiver.notifiers = slices.Concat(receiver.notifiers, notifiers)
+}
+
+// RemoveNotifier unregisters a notifier while the receiver is running, and
+// reports whether it was registered.
+// Notifiers are compared with ==, so only notifiers of a comparable type can
+// be removed, e.g., pointers, but not a NotifierFunc.
+// Mentions already being dispatched may still reach the notifier.
+func (receiver *Receiver) RemoveNotifier(notifier Notifier) bool {
+	receiver.listenersMu.Lock()
+	defer receiver.listenersMu.Unlock()
+	notifiers := slices.DeleteFunc(slices.Clone(receiver.notifiers), func(n Notifier) bool {
+		return equalComparable(n, notifier)
+	})
+	removed := len(notifiers) < len(receiver.notifiers)
+	receiver.notifiers = notifiers
+	return removed
+}
+
+// currentNotifiers returns a snapshot of the registered notifiers.
+func (receiver *Receiver) currentNotifiers() []Notifier {
+	receiver.listenersMu.RLock()
+	defer receiver.listenersMu.RUnlock()
+	return receiver.notifiers
+}
+
+// equalComparable reports whether a == b, without panicking if they are of
+// an incomparable type.
+func equalComparable(a, b any) bool {
+	typ := reflect.TypeOf(a)
+	if typ != reflect.TypeOf(b) || typ == nil || !typ.Comparable() {
+		return false
+	}
+	return a == b
+}
+
 func WithAcceptsFunc(accepts TargetAcceptsFunc) ReceiverOption {
 	return func(r *Receiver) {
 		r.targetAccepts = accepts
@@ -167,20 +423,17 @@ func WithAcceptsFunc(accepts TargetAcceptsFunc) ReceiverOption {
 // Register a handler for a certain media type.
 // If multiple handlers for the same type are registered, only the last handler will be considered.
 // The default handlers are:
-//   - text/plain;q=1.0: PlainHandler
-//   - text/html;q=0.1:  HtmlHandler
+//   - text/html;q=1.0:     HtmlHandler
+//   - text/markdown;q=0.5: MarkdownHandler
+//   - text/plain;q=0.1:    PlainHandler
 //
 // To remove any of the default handlers, pass a nil handler.
 func WithMediaHandler(mime string, qweight float64, handler MediaHandler) ReceiverOption {
 	return func(r *Receiver) {
-		if handler == nil {
-			for i, h := range r.mediaHandler {
-				if h.name == mime {
-					r.mediaHandler = slices.Delete(r.mediaHandler, i, i+1)
-					break
-				}
-			}
-		} else {
+		r.mediaHandler = slices.DeleteFunc(r.mediaHandler, func(h mediaHandler) bool {
+			return h.name == mime
+		})
+		if handler != nil {
 			r.mediaHandler = append(r.mediaHandler, mediaHandler{
 				name:    mime,
 				qweight: qweight,
@@ -195,14 +448,54 @@ func WithMediaHandler(mime string, qweight float64, handler MediaHandler) Receiv
 // queue is full.
 func WithQueueSize(size int) ReceiverOption {
 	return func(r *Receiver) {
-		queue := make(chan Mention, size)
-		r.enqueue = queue
-		r.dequeue = queue
+		r.queue = NewChannelQueue(size)
+	}
+}
+
+// WithQueue replaces the default in-process request queue.
+// Use a shared queue (e.g., PostgresQueue) to run multiple receivers that
+// process mentions on behalf of each other, or a FileQueue to not lose
+// mentions on restart.
+func WithQueue(queue Queue) ReceiverOption {
+	return func(r *Receiver) {
+		r.queue = queue
 	}
 }
 
 func (receiver *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if err := receiver.handle(w, r); err != nil {
+	receiver.serve(w, r, endpointLinks{})
+}
+
+// serve handles a webmention request.
+// If links.statusURL is non-nil, it is used to point the sender to where it
+// can check on the processing status of its submission.
+func (receiver *Receiver) serve(w http.ResponseWriter, r *http.Request, links endpointLinks) {
+	recorder := &statusRecorder{ResponseWriter: w}
+	defer func() {
+		receiver.metrics.request(recorder.code)
+	}()
+	w = recorder
+	setHeaders(w, receiver.headers)
+	if receiver.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, receiver.maxBodySize)
+	}
+	if receiver.selfDescription && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		receiver.serveDescription(w, r, links)
+		return
+	}
+	if err := receiver.handle(w, r, links.statusURL); err != nil {
+		var badRequest ErrBadRequest
+		if errors.As(err, &badRequest) {
+			receiver.rejectRequest(r, badRequest)
+			if receiver.terseErrors {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			if receiver.sanitizeErrors {
+				http.Error(w, "bad request: "+sanitizeMessage(badRequest.Message), http.StatusBadRequest)
+				return
+			}
+		}
 		if err, ok := err.(ErrorResponder); ok {
 			if err.RespondError(w, r) {
 				return
@@ -213,40 +506,47 @@ func (receiver *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (receiver *Receiver) handle(w http.ResponseWriter, r *http.Request) error {
+func (receiver *Receiver) handle(w http.ResponseWriter, r *http.Request, statusURL func(id string) string) error {
 	if r.Method != http.MethodPost {
 		return MethodNotAllowed()
 	}
 
-	if err := r.ParseForm(); err != nil {
-		return BadRequest(err.Error())
+	keyID, err := receiver.verifySignature(w, r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return RequestTooLarge()
+		}
+		return err
 	}
 
-	source, hasSource := r.PostForm["source"]
-	if !hasSource {
-		return BadRequest("missing form value: source")
-	}
-	target, hasTarget := r.PostForm["target"]
-	if !hasTarget {
-		return BadRequest("missing form value: target")
+	if err := r.ParseForm(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return RequestTooLarge()
+		}
+		return BadRequest("malformed form data") // don't echo the parser error, it may contain user input
 	}
 
-	if len(source) != 1 {
-		return BadRequest("malformed source argument")
+	source, err := receiver.duplicateArguments.argument(r.PostForm, "source")
+	if err != nil {
+		return err
 	}
-	if len(target) != 1 {
-		return BadRequest("malformed target argument")
+	target, err := receiver.duplicateArguments.argument(r.PostForm, "target")
+	if err != nil {
+		return err
 	}
 
-	if source[0] == target[0] {
-		return BadRequest("target must be different from source")
+	extensions, err := formExtensions(r.PostForm)
+	if err != nil {
+		return err
 	}
 
-	sourceURL, err := url.Parse(source[0])
+	sourceURL, err := url.Parse(source)
 	if err != nil {
 		return BadRequest("source url is malformed")
 	}
-	targetURL, err := url.Parse(target[0])
+	targetURL, err := url.Parse(target)
 	if err != nil {
 		return BadRequest("target url is malformed")
 	}
@@ -258,25 +558,77 @@ func (receiver *Receiver) handle(w http.ResponseWriter, r *http.Request) error {
 		return BadRequest("target url scheme not supported (supported schemes are: http, https)")
 	}
 
+	selfMention := samePage(sourceURL, targetURL)
+	if selfMention && receiver.selfMentions != MarkSelfMentions {
+		return BadRequest("target must be different from source")
+	}
+
+	if IsOnion(sourceURL) && receiver.dial.proxy == nil {
+		return BadRequest("onion sources are not supported by this receiver")
+	}
+
 	if !receiver.targetAccepts(sourceURL, targetURL) {
 		return BadRequest("target does not accept webmentions from this source")
 	}
 
-	if t, ok := receiver.mentionCache[mentionCacheEntry{source: sourceURL.String(), target: targetURL.String()}]; ok {
-		if time.Now().Sub(t) < receiver.cacheTimeout {
-			return TooManyRequests()
+	if err := receiver.checkTarget(targetURL); err != nil {
+		return err
+	}
+
+	if receiver.usage != nil {
+		if err := receiver.usage.allowReceive(targetURL.Hostname()); err != nil {
+			return err
 		}
 	}
-	receiver.mentionCache[mentionCacheEntry{source: sourceURL.String(), target: targetURL.String()}] = time.Now()
 
-	select {
-	case receiver.enqueue <- Mention{sourceURL, targetURL, StatusNoLink}:
-	default:
+	targetID, err := receiver.resolveTarget(sourceURL, targetURL)
+	if err != nil {
+		return err
+	}
+
+	if keyID != "" && !receiver.signedFor(keyID, sourceURL) {
+		slog.Info("key not trusted for source", "key_id", keyID, "source", sourceURL)
+		keyID = ""
+	}
+
+	isNew, err := receiver.mentionCache.SetNX("mention:"+sourceURL.String()+" "+targetURL.String(), time.Now().Format(time.RFC3339), receiver.cacheTimeout)
+	if err != nil {
+		return fmt.Errorf("mention cache: %w", err)
+	}
+	if !isNew {
 		return TooManyRequests()
 	}
 
+	mention := Mention{
+		ID:          newMentionID(),
+		Source:      sourceURL,
+		Target:      targetURL,
+		Status:      StatusNoLink,
+		TargetID:    targetID,
+		Received:    time.Now(),
+		SignedBy:    keyID,
+		Extensions:  extensions,
+		SelfMention: selfMention,
+		TraceParent: requestTraceParent(r),
+	}
+	if err := receiver.queue.Push(mention); err != nil {
+		if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrQueueClosed) {
+			return TooManyRequests()
+		}
+		return fmt.Errorf("enqueue mention: %w", err)
+	}
+	eta := receiver.processingETA()
+	receiver.setStatus(mention, MentionStatus{State: StateQueued, ETA: &eta})
+	receiver.usage.add(targetURL.Hostname(), Usage{Received: 1})
+
+	wait := max(time.Until(eta).Round(time.Second), time.Second)
+	if statusURL != nil {
+		w.Header().Set("Location", statusURL(mention.ID))
+		// when to check on the status
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)))
+	}
 	w.WriteHeader(http.StatusAccepted)
-	if _, err := w.Write([]byte("Thank you! Your Mention has been queued for processing.")); err != nil {
+	if _, err := fmt.Fprintf(w, "Thank you! Your Mention has been queued for processing, it should be processed within about %s.", wait); err != nil {
 		return err
 	}
 	return nil
@@ -285,38 +637,80 @@ func (receiver *Receiver) handle(w http.ResponseWriter, r *http.Request) error {
 // ProcessMentions does not return until stopped by calling Shutdown.
 // It is intended to run this function in its own goroutine.
 // You may start multiple goroutines all running this function.
+// The first call sends the notifications left in the outbox of the storage
+// first, see OutboxStorage.
 func (receiver *Receiver) ProcessMentions() {
-	// process queue until a shutdown is issued
-	for {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
 		select {
 		case <-receiver.shutdown:
-			return
-		case mention, ok := <-receiver.dequeue:
-			if !ok {
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	receiver.outboxOnce.Do(receiver.deliverOutbox)
+	receiver.throughput.started()
+	defer receiver.throughput.stopped()
+	// process queue until a shutdown is issued
+	for {
+		mention, err := receiver.queue.Pop(ctx)
+		if err != nil {
+			if errors.Is(err, ErrQueueClosed) || ctx.Err() != nil {
 				return
 			}
-			Report(receiver.processMention(mention), mention)
+			slog.Error(fmt.Sprintf("dequeue mention: %s", err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second): // don't spin if the queue is broken
+			}
+			continue
 		}
+		receiver.process(mention)
 	}
 }
 
 // Shutdown causes the webmention service to stop accepting any new mentions.
 // Mentions currently waiting in the request queue will still be processed, until ctx expires.
-// The http server must be stopped first, ServeHTTP will panic otherwise.
+// The http server should be stopped first, otherwise requests arriving in the
+// meantime are answered with http.StatusTooManyRequests.
 func (receiver *Receiver) Shutdown(ctx context.Context) {
 	// Finish processing queue until it is emptied or the shutdown context has expired.
 	// Whichever happens first.
 	close(receiver.shutdown)
-	close(receiver.enqueue)
+	receiver.abortRetries()
+	if err := receiver.queue.Close(); err != nil {
+		slog.Error(fmt.Sprintf("close queue: %s", err))
+	}
 	for {
-		select {
-		case <-ctx.Done():
+		mention, err := receiver.queue.Pop(ctx)
+		if err != nil {
 			return
-		case mention, ok := <-receiver.dequeue:
-			if !ok {
-				return
-			}
-			Report(receiver.processMention(mention), mention)
+		}
+		receiver.process(mention)
+	}
+}
+
+func (receiver *Receiver) process(mention Mention) {
+	receiver.setState(mention, StateVerifying)
+	start := time.Now()
+	err := receiver.processMention(mention)
+	receiver.throughput.processed(time.Since(start))
+	if errors.Is(err, ErrSourceInaccessible) {
+		mention.Status = StatusInaccessible // retried, in case the wall is temporary
+	} else if err != nil {
+		mention.Status = "" // of a previous attempt
+	}
+	if errors.Is(err, ErrRejected) {
+		receiver.reject(mention, err.Error())
+	} else if err != nil {
+		receiver.retry(mention, err)
+	}
+	receiver.report(err, mention)
+	if queue, ok := receiver.queue.(AckQueue); ok {
+		if err := queue.Ack(mention); err != nil {
+			receiver.report(fmt.Errorf("acknowledge mention: %w", err), mention)
 		}
 	}
 }
@@ -328,10 +722,21 @@ func (receiver *Receiver) processMention(mention Mention) error {
 			"mention", mention,
 		),
 	)
+	if mention.TraceParent != "" {
+		log = log.With("trace_id", TraceID(mention.TraceParent))
+	}
+
+	for _, filter := range receiver.currentFilters() {
+		if err := filter.Filter(mention); err != nil {
+			log.Info("mention rejected by filter", "reason", err.Error())
+			return err
+		}
+	}
 
 	mime := "text/plain"
+	mention.Fetch = receiver.fetchStrategy(mention.Source)
 
-	{
+	if mention.Fetch == FetchHeadThenGet {
 		req, err := http.NewRequest(http.MethodHead, mention.Source.String(), nil)
 		if err != nil {
 			log.Error(err.Error())
@@ -339,68 +744,259 @@ func (receiver *Receiver) processMention(mention Mention) error {
 		}
 		req.Header.Set("User-Agent", receiver.userAgent)
 		req.Header.Set("Accept", receiver.mediaHandler.String())
-		resp, err := receiver.httpClient.Do(req)
+		setTraceParent(req, mention)
+		resp, err := receiver.fetcher.Do(req)
 		if err != nil {
 			log.Error(err.Error())
 			return err
 		}
-		if resp.StatusCode == 410 {
-			mention.Status = StatusDeleted
-			// Processing should be idempotent
-			for _, notifier := range receiver.notifiers {
-				go notifier.Receive(mention)
+		resp.Body.Close()
+		if receiver.refusesHead(mention.Source, resp) {
+			log.Info("source refused HEAD request, fetching it with GET only", "status", resp.StatusCode)
+			mention.Fetch = FetchGetOnly
+		} else {
+			if resp.StatusCode == 410 {
+				mention.Status = StatusDeleted
+				return receiver.dispatch(mention)
+			}
+			if unavailableStatus(resp.StatusCode) {
+				mention.Status, mention.Reason = StatusUnavailable, unavailableReason(resp)
+				log.Info("source is unavailable", "reason", mention.Reason)
+				return receiver.dispatch(mention)
+			}
+			if resp.StatusCode < 200 || resp.StatusCode > 300 {
+				err = sourceStatusError(resp)
+				log.Error(err.Error())
+				return err
+			}
+			mime, err = receiver.sourceMediaType(resp, mention.Source)
+			if err != nil {
+				log.Error(err.Error(), "media_types", resp.Header.Get("Content-Type"))
+				return err
+			}
+		}
+	}
+
+	{
+		var (
+			mediaHandler MediaHandler
+			hasHandler   bool
+			hinted       string
+		)
+		findHandler := func() error {
+			mediaHandler, hasHandler = receiver.mediaHandler.Get(mime)
+			if !hasHandler && genericMediaTypes[mime] {
+				hinted = receiver.extensionHint(mention.Source)
+			}
+			if !hasHandler && hinted == "" && !receiver.sniffContent {
+				log.Error("no mime handler registered", "mime", mime)
+				return fmt.Errorf("no mime handler registered for: %s", mime)
 			}
 			return nil
 		}
-		if resp.StatusCode < 200 || resp.StatusCode > 300 {
-			err = ErrSourceNotFound
+		if mention.Fetch == FetchHeadThenGet {
+			if err := findHandler(); err != nil {
+				return err
+			}
+		}
+
+		req, err := http.NewRequest(http.MethodGet, mention.Source.String(), nil)
+		if err != nil {
 			log.Error(err.Error())
 			return err
 		}
-		contentHeader := resp.Header.Get("Content-Type")
-		mediaType, _, err := mimelib.ParseMediaType(contentHeader)
+		req.Header.Set("User-Agent", receiver.userAgent)
+		if hasHandler {
+			req.Header.Set("Accept", mime)
+		} else {
+			req.Header.Set("Accept", receiver.mediaHandler.String())
+		}
+		setTraceParent(req, mention)
+		resp, err := receiver.fetcher.Do(req)
 		if err != nil {
-			log.Error(err.Error(), "media_types", resp.Header.Get("Content-Type"))
+			log.Error(err.Error())
 			return err
 		}
-		mime = mediaType
-	}
+		defer resp.Body.Close()
+		if mention.Fetch == FetchGetOnly {
+			if resp.StatusCode == 410 {
+				mention.Status = StatusDeleted
+				return receiver.dispatch(mention)
+			}
+			if unavailableStatus(resp.StatusCode) {
+				mention.Status, mention.Reason = StatusUnavailable, unavailableReason(resp)
+				log.Info("source is unavailable", "reason", mention.Reason)
+				return receiver.dispatch(mention)
+			}
+			if resp.StatusCode < 200 || resp.StatusCode > 300 {
+				err = sourceStatusError(resp)
+				log.Error(err.Error())
+				return err
+			}
+			mime, err = receiver.sourceMediaType(resp, mention.Source)
+			if err != nil {
+				log.Error(err.Error(), "media_types", resp.Header.Get("Content-Type"))
+				return err
+			}
+			if err := findHandler(); err != nil {
+				return err
+			}
+		}
+		if resp.Request != nil && resp.Request.URL != nil {
+			if err := receiver.checkRedirectedSelfMention(&mention, resp.Request.URL); err != nil {
+				log.Info("self-mention rejected", "reason", err.Error())
+				return err
+			}
+		}
 
-	{
-		mediaHandler, hasHandler := receiver.mediaHandler.Get(mime)
+		var content io.Reader = resp.Body
+		handlerType := mime
 		if !hasHandler {
-			log.Error("no mime handler registered", "mime", mime)
-			return fmt.Errorf("no mime handler registered for: %s", mime)
+			inferred := hinted
+			if receiver.sniffContent {
+				sniffed, peeked, err := sniffContentType(resp.Body)
+				if err != nil {
+					log.Error(err.Error())
+					return err
+				}
+				content = io.MultiReader(bytes.NewReader(peeked), resp.Body)
+				if inferred == "" {
+					inferred = sniffed
+				} else {
+					inferred = receiver.mediaHandler.preferredType(inferred, sniffed)
+				}
+			}
+			mediaHandler, hasHandler = receiver.mediaHandler.Get(inferred)
+			if !hasHandler {
+				log.Error("no mime handler registered", "mime", mime, "inferred_mime", inferred)
+				return fmt.Errorf("no mime handler registered for: %s (inferred: %s)", mime, inferred)
+			}
+			log.Info("using inferred content type", "mime", mime, "inferred_mime", inferred, "extension_hint", hinted)
+			handlerType = inferred
 		}
 
-		req, err := http.NewRequest(http.MethodGet, mention.Source.String(), nil)
+		sourceData, err := io.ReadAll(io.LimitReader(content, maxSourceSize+1))
+		receiver.usage.add(mention.Target.Hostname(), Usage{FetchedBytes: int64(len(sourceData))})
 		if err != nil {
 			log.Error(err.Error())
 			return err
 		}
-		req.Header.Set("User-Agent", receiver.userAgent)
-		req.Header.Set("Accept", mime)
-		resp, err := receiver.httpClient.Do(req)
-		if err != nil {
-			log.Error(err.Error())
-			return err
+		if len(sourceData) > maxSourceSize {
+			log.Error(ErrSourceTooLarge.Error())
+			return ErrSourceTooLarge
 		}
 
-		handlerStatus, err := mediaHandler(resp.Body, mention.Target)
+		handlerStatus, err := receiver.runMediaHandler(handlerType, mediaHandler, sourceData, mention.Target)
 		if err != nil {
 			log.Error(err.Error())
 			return err
 		}
 		mention.Status = handlerStatus
+		if mention.Status == StatusNoLink && receiver.checkAlternates {
+			if alternateData, found := receiver.searchAlternates(mention, sourceData); found {
+				mention.Status = StatusLink
+				sourceData = alternateData
+			}
+		}
+		if mention.Status == StatusNoLink && !receiver.ignoreWalls {
+			var final URL
+			if resp.Request != nil {
+				final = resp.Request.URL
+			}
+			if reason := detectWall(mention.Source, final, sourceData); reason != "" {
+				log.Info("source looks inaccessible", "reason", reason)
+				return inaccessibleError{reason}
+			}
+		}
+		if mention.Status == StatusLink && receiver.canonicalSources {
+			sourceData = receiver.useCanonicalSource(&mention, resp, handlerType, sourceData)
+		}
+		if mention.Status == StatusLink {
+			mention.Type = ClassifyMention(sourceData, mention.Target)
+			mention.SourceEndpoint = advertisedEndpoint(resp, mention.Source, handlerType, sourceData)
+			mention.Published, mention.Updated = SourceDates(sourceData, resp.Header)
+			mention.Content = extractContent(handlerType, sourceData, mention.Target)
+			receiver.recordSourceEndpoint(mention)
+		}
+
+		if mention.Status == StatusLink && receiver.spamScorer != nil && mention.SignedBy == "" {
+			score, err := receiver.spamScorer.Score(mention, sourceData)
+			if err != nil {
+				log.Error(err.Error())
+				return err
+			}
+			mention.SpamScore = score
+			if score >= receiver.spamThreshold {
+				log.Info("mention held for moderation", "spam_score", score)
+				return receiver.hold(mention)
+			}
+		}
 	}
 
+	return receiver.dispatch(mention)
+}
+
+// dispatch stores the processed mention and informs all notifiers about it.
+func (receiver *Receiver) dispatch(mention Mention) error {
+	_, err := receiver.startDispatch(mention)
+	return err
+}
+
+// startDispatch is dispatch, the returned channel is closed once all
+// notifiers have been informed.
+func (receiver *Receiver) startDispatch(mention Mention) (<-chan struct{}, error) {
+	notifiers := receiver.currentNotifiers()
+	if receiver.storage != nil {
+		if err := receiver.store(&mention, receiver.outboxNames(notifiers)); err != nil {
+			return nil, fmt.Errorf("store mention: %w", err)
+		}
+	}
+	receiver.setState(mention, StateProcessed)
 	// Processing should be idempotent
-	slog.Info(fmt.Sprintf("sending to %d notifiers", len(receiver.notifiers)))
-	for _, notifier := range receiver.notifiers {
-		go notifier.Receive(mention)
+	slog.Info(fmt.Sprintf("sending to %d notifiers", len(notifiers)))
+	var wg sync.WaitGroup
+	for _, notifier := range notifiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if receiver.notify(notifier, mention) {
+				receiver.sent(notifier, mention)
+			}
+		}()
 	}
+	notified := make(chan struct{})
+	go func() {
+		wg.Wait()
+		receiver.record(mention, StateNotified)
+		close(notified)
+	}()
+	return notified, nil
+}
 
-	return nil
+// sniffContentType detects the media type of content from its first 512 bytes.
+// The bytes consumed while sniffing are returned, so that they can be put in
+// front of the remaining content again.
+func sniffContentType(content io.Reader) (mime string, peeked []byte, err error) {
+	peeked = make([]byte, 512)
+	n, err := io.ReadFull(content, peeked)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", nil, fmt.Errorf("sniff content type: %w", err)
+	}
+	peeked = peeked[:n]
+	mime, _, err = mimelib.ParseMediaType(http.DetectContentType(peeked))
+	if err != nil {
+		return "", nil, fmt.Errorf("sniff content type: %w", err)
+	}
+	return mime, peeked, nil
+}
+
+// newMentionID returns a random id for a new submission.
+func newMentionID() string {
+	bs := make([]byte, 16)
+	if _, err := rand.Read(bs); err != nil {
+		panic(err) // crypto/rand never fails
+	}
+	return hex.EncodeToString(bs)
 }
 
 func PlainHandler(content io.Reader, target URL) (status Status, err error) {
@@ -2,6 +2,7 @@ package webmention_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	webmention "github.com/cvanloo/gowebmention"
@@ -10,8 +11,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -126,28 +131,39 @@ var TestCases = []TestCase{
 		//ExpectedMentionStatus
 		ExpectedError: webmention.ErrSourceNotFound,
 	},
+	{
+		Comment: "source sends wrong content type",
+		SourceHandler: func(ts **httptest.Server) func(w http.ResponseWriter, r *http.Request) {
+			return func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				body := fmt.Sprintf(`<!DOCTYPE html><html><body><p>Hello, <a href="%s">Target 6</a>!</p></body></html>`, (*ts).URL+"/target/6")
+				w.Write([]byte(body))
+			}
+		},
+		ExpectedHttpStatus:    202,
+		ExpectedMentionStatus: webmention.StatusLink,
+	},
 }
 
 func TestReceiveLocal(t *testing.T) {
 	var ts *httptest.Server
 
 	wg := sync.WaitGroup{}
-	wg.Add(len(TestCases)) // either Done() in Report or in NotifierFunc, or in error cases
-
-	webmention.Report = func(err error, mention webmention.Mention) {
-		if err != nil {
-			defer wg.Done()
-			testNumber := must(strconv.Atoi(string(mention.Source.Path[len("/source/"):])))
-			testCase := TestCases[testNumber-1]
-
-			if testCase.ExpectedError == nil || !errors.Is(err, testCase.ExpectedError) {
-				t.Errorf("incorrect error: got: %s, want: %s", err, testCase.ExpectedError)
-			}
-		}
-	}
+	wg.Add(len(TestCases)) // either Done() in the reporter or in NotifierFunc, or in error cases
 
 	receiver := webmention.NewReceiver(
 		webmention.WithAcceptsFunc(accepts),
+		webmention.WithReporter(func(err error, mention webmention.Mention) {
+			if err != nil {
+				defer wg.Done()
+				testNumber := must(strconv.Atoi(string(mention.Source.Path[len("/source/"):])))
+				testCase := TestCases[testNumber-1]
+
+				if testCase.ExpectedError == nil || !errors.Is(err, testCase.ExpectedError) {
+					t.Errorf("incorrect error: got: %s, want: %s", err, testCase.ExpectedError)
+				}
+			}
+		}),
 		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
 			defer wg.Done()
 			testNumber := must(strconv.Atoi(string(mention.Source.Path[len("/source/"):])))
@@ -159,6 +175,7 @@ func TestReceiveLocal(t *testing.T) {
 	)
 
 	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
 
 	mux := http.NewServeMux()
 	mux.Handle("/webmention", receiver)
@@ -192,3 +209,1518 @@ func TestReceiveLocal(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestHardening(t *testing.T) {
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithHardening(),
+	)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	resp, err := http.DefaultClient.PostForm(ts.URL, map[string][]string{
+		"source": {"https://example.com/" + strings.Repeat("a", 20<<10)},
+		"target": {"https://example.com/target"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+	for k, v := range webmention.HardenedHeaders() {
+		if got := resp.Header.Get(k); got != v[0] {
+			t.Errorf("incorrect header %s, got: %q, want: %q", k, got, v[0])
+		}
+	}
+	body := string(must(io.ReadAll(resp.Body)))
+	if strings.Contains(body, "aaaa") {
+		t.Errorf("response body echoes request: %s", body)
+	}
+}
+
+func TestModeration(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<p>Cheap viagra! Buy now! <a href="%s/target">here</a></p>`, ts.URL)
+	})
+
+	queuePath := filepath.Join(t.TempDir(), "moderation.json")
+	held := make(chan webmention.Mention, 1)
+	notified := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithSpamScorer(webmention.SpamScorerFunc(func(mention webmention.Mention, content []byte) (float64, error) {
+			if strings.Contains(string(content), "viagra") {
+				return 1, nil
+			}
+			return 0, nil
+		}), 0.5),
+		webmention.WithModeration(webmention.NewFileModerationQueue(queuePath), webmention.NotifierFunc(func(mention webmention.Mention) {
+			held <- mention
+		})),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	resp, err := http.DefaultClient.PostForm(ts.URL+"/webmention", map[string][]string{
+		"source": {ts.URL + "/source"},
+		"target": {ts.URL + "/target"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	mention := <-held
+	if mention.SpamScore != 1 {
+		t.Errorf("incorrect spam score, got: %f, want: %f", mention.SpamScore, 1.0)
+	}
+	pending := must(receiver.Pending())
+	if len(pending) != 1 || pending[0].ID != mention.ID {
+		t.Fatalf("mention not pending: %v", pending)
+	}
+	select {
+	case <-notified:
+		t.Fatal("held mention was dispatched to notifiers")
+	default:
+	}
+
+	// approve with another receiver, as if the process was restarted in between
+	approved := make(chan webmention.Mention, 1)
+	moderator := webmention.NewReceiver(
+		webmention.WithModeration(webmention.NewFileModerationQueue(queuePath)),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			approved <- mention
+		})),
+	)
+	if err := moderator.Approve(mention.ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-approved:
+		if got.ID != mention.ID || got.Source.String() != mention.Source.String() {
+			t.Errorf("incorrect mention dispatched, got: %+v, want: %+v", got, mention)
+		}
+	default:
+		t.Error("approve returned before notifying")
+	}
+	if err := moderator.Approve(mention.ID); !errors.Is(err, webmention.ErrNotPending) {
+		t.Errorf("incorrect error, got: %v, want: %v", err, webmention.ErrNotPending)
+	}
+	if pending := must(receiver.Pending()); len(pending) != 0 {
+		t.Errorf("approved mention still pending: %v", pending)
+	}
+}
+
+func TestHeuristicScorerRDAPFailure(t *testing.T) {
+	rdap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer rdap.Close()
+
+	scorer := webmention.NewHeuristicScorer()
+	scorer.RDAPBaseURL = rdap.URL + "/domain/"
+	mention := webmention.Mention{
+		Source: must(url.Parse("https://blog.example.com/post")),
+		Target: must(url.Parse("https://example.org/post")),
+	}
+	score, err := scorer.Score(mention, []byte(`<p>A perfectly normal post.</p>`))
+	if err != nil {
+		t.Fatalf("failed lookup not treated as neutral: %s", err)
+	}
+	if score != 0 {
+		t.Errorf("incorrect spam score, got: %f, want: 0", score)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	var available atomic.Bool
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+	})
+
+	errs := make(chan error, 3)
+	notified := make(chan webmention.Mention, 1)
+	deadLetters := &webmention.MemoryDeadLetterStore{}
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithReporter(func(err error, mention webmention.Mention) {
+			if err != nil {
+				errs <- err
+			}
+		}),
+		webmention.WithRetries(1, 10*time.Millisecond),
+		webmention.WithDeadLetters(deadLetters),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	resp, err := http.DefaultClient.PostForm(ts.URL+"/webmention", map[string][]string{
+		"source": {ts.URL + "/source"},
+		"target": {ts.URL + "/target"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+	}
+
+	// the first attempt, and one retry
+	for range 2 {
+		select {
+		case <-errs:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	letters := must(receiver.DeadLetters())
+	if len(letters) != 1 || letters[0].Mention.Attempts != 1 || letters[0].Reason == "" {
+		t.Fatalf("mention not dead-lettered: %+v", letters)
+	}
+	id := letters[0].Mention.ID
+	if status := must(receiver.MentionStatus(id)); status.State != webmention.StateDeadLettered {
+		t.Errorf("incorrect state, got: %s, want: %s", status.State, webmention.StateDeadLettered)
+	}
+
+	available.Store(true)
+	if err := receiver.RetryDeadLetter(id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mention := <-notified:
+		if mention.ID != id || mention.Status != webmention.StatusLink {
+			t.Errorf("incorrect mention dispatched: %+v", mention)
+		}
+	case err := <-errs:
+		t.Fatalf("retry failed: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	if letters := must(receiver.DeadLetters()); len(letters) != 0 {
+		t.Errorf("retried mention still dead-lettered: %+v", letters)
+	}
+	if err := receiver.RetryDeadLetter(id); !errors.Is(err, webmention.ErrNoDeadLetter) {
+		t.Errorf("incorrect error, got: %v, want: %v", err, webmention.ErrNoDeadLetter)
+	}
+}
+
+func TestExtensionHints(t *testing.T) {
+	for _, testCase := range []struct {
+		name     string
+		hints    map[string]string
+		expected error
+	}{
+		{name: "default hints", hints: webmention.DefaultExtensionHints},
+		{name: "disabled", hints: nil, expected: errors.New("no mime handler")},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			ts := httptest.NewServer(mux)
+			defer ts.Close()
+			mux.HandleFunc("/post.html", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				fmt.Fprintf(w, `<p>Hello <a href="%s/target">World</a></p>`, ts.URL)
+			})
+
+			errs := make(chan error, 1)
+			notified := make(chan webmention.Mention, 1)
+			receiver := webmention.NewReceiver(
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithReporter(func(err error, mention webmention.Mention) {
+					if err != nil {
+						errs <- err
+					}
+				}),
+				webmention.WithContentSniffing(false),
+				webmention.WithExtensionHints(testCase.hints),
+				webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+					notified <- mention
+				})),
+			)
+			go receiver.ProcessMentions()
+			defer receiver.Shutdown(context.Background())
+			mux.Handle("/webmention", receiver)
+
+			resp, err := http.DefaultClient.PostForm(ts.URL+"/webmention", map[string][]string{
+				"source": {ts.URL + "/post.html"},
+				"target": {ts.URL + "/target"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+			}
+			select {
+			case mention := <-notified:
+				if testCase.expected != nil {
+					t.Errorf("mention verified without hints: %+v", mention)
+				} else if mention.Status != webmention.StatusLink {
+					t.Errorf("incorrect status, got: %s, want: %s", mention.Status, webmention.StatusLink)
+				}
+			case err := <-errs:
+				if testCase.expected == nil || !strings.Contains(err.Error(), testCase.expected.Error()) {
+					t.Errorf("incorrect error, got: %s, want: %v", err, testCase.expected)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+		})
+	}
+}
+
+func TestStatusHistory(t *testing.T) {
+	var version atomic.Int32
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		switch version.Load() {
+		case 0, 1:
+			fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+		case 2, 4:
+			fmt.Fprint(w, `link removed`)
+		case 3:
+			w.WriteHeader(http.StatusUnavailableForLegalReasons)
+		default:
+			w.WriteHeader(http.StatusGone)
+		}
+	})
+
+	notified := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithCacheTimeout(time.Millisecond),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	var id string
+	for v := range int32(6) {
+		version.Store(v)
+		time.Sleep(5 * time.Millisecond) // let the cache timeout pass
+		resp, err := http.DefaultClient.PostForm(ts.URL+"/webmention", map[string][]string{
+			"source": {ts.URL + "/source"},
+			"target": {ts.URL + "/target"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+		}
+		select {
+		case mention := <-notified:
+			id = mention.ID
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	expected := []webmention.ProcessingState{
+		webmention.StateVerified,
+		webmention.StateReverified,
+		webmention.StateUpdated,
+		webmention.StateUnavailable,
+		webmention.StateReverified, // compared to the result before the source was unavailable
+		webmention.StateDeleted,
+	}
+	var status webmention.MentionStatus
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status = must(receiver.MentionStatus(id))
+		if last := status.History[len(status.History)-1]; last.ID == id && last.State == webmention.StateNotified {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last notification not recorded: %+v", status.History)
+		}
+	}
+	var results []webmention.ProcessingState
+	for i, transition := range status.History {
+		switch transition.State {
+		case webmention.StateVerified, webmention.StateReverified, webmention.StateUpdated, webmention.StateDeleted, webmention.StateUnavailable:
+			results = append(results, transition.State)
+			if next := status.History[i+1:]; !slices.ContainsFunc(next, func(n webmention.StatusTransition) bool {
+				return n.ID == transition.ID && n.State == webmention.StateNotified
+			}) {
+				t.Errorf("notification of %s not recorded", transition.ID)
+			}
+		}
+	}
+	if !slices.Equal(results, expected) {
+		t.Errorf("incorrect verification results, got: %v, want: %v", results, expected)
+	}
+	if status.History[0].State != webmention.StateQueued || status.History[1].State != webmention.StateVerifying {
+		t.Errorf("incorrect history start: %+v", status.History[:2])
+	}
+
+	verified := []webmention.StatusTransition{{State: webmention.StateVerified, Status: webmention.StatusLink}}
+	for status, expected := range map[webmention.Status]webmention.ProcessingState{
+		webmention.StatusLink:         webmention.StateReverified,
+		webmention.StatusNoLink:       webmention.StateUpdated,
+		webmention.StatusDeleted:      webmention.StateDeleted,
+		webmention.StatusUnavailable:  webmention.StateUnavailable,
+		webmention.StatusInaccessible: webmention.StateUnavailable,
+		"":                            webmention.StateUnavailable,
+	} {
+		if actual := webmention.VerificationResult(verified, status); actual != expected {
+			t.Errorf("%q: expected %s, got %s", status, expected, actual)
+		}
+	}
+}
+
+func TestClosedTargets(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	base := "http://" + ts.Listener.Addr().String()
+	sitemap := must(webmention.ParseSitemap(strings.NewReader(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:wm="https://github.com/cvanloo/gowebmention/sitemap">
+  <url><loc>%[1]s/open</loc></url>
+  <url><loc>%[1]s/archived</loc><wm:closed>true</wm:closed></url>
+</urlset>`, base))))
+
+	storage := webmention.NewMemoryStorage()
+	err := storage.Store(webmention.Mention{
+		Source:   must(url.Parse("https://example.com/old-friend")),
+		Target:   must(url.Parse(base + "/archived")),
+		Status:   webmention.StatusLink,
+		Received: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithTargetResolver(sitemap),
+		webmention.WithStorage(storage),
+	)
+	ts.Config.Handler = receiver
+	ts.Start()
+	defer ts.Close()
+
+	for _, testCase := range []struct {
+		source, target string
+		code           int
+	}{
+		{"https://example.com/new", "/open", http.StatusAccepted},
+		{"https://example.com/new", "/archived", http.StatusBadRequest},
+		{"https://example.com/old-friend", "/archived", http.StatusAccepted},
+		{"https://example.com/new", "/unlisted", http.StatusBadRequest},
+	} {
+		resp, err := http.DefaultClient.PostForm(ts.URL, map[string][]string{
+			"source": {testCase.source},
+			"target": {base + testCase.target},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := must(io.ReadAll(resp.Body))
+		resp.Body.Close()
+		if resp.StatusCode != testCase.code {
+			t.Errorf("%s -> %s: incorrect status code, got: %d, want: %d (%s)", testCase.source, testCase.target, resp.StatusCode, testCase.code, body)
+		}
+	}
+}
+
+func TestTargetResolver(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		ids = map[string]string{"/hello": "post-1"}
+	)
+	resolver := webmention.TargetResolverFunc(func(target *url.URL) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		id, ok := ids[target.Path]
+		if !ok {
+			return "", webmention.ErrUnknownTarget
+		}
+		return id, nil
+	})
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%[1]s/hello">hello</a> <a href="%[1]s/unknown">unknown</a>`, ts.URL)
+	})
+	storage := webmention.NewMemoryStorage()
+	recorder := &mentionRecorder{received: make(chan webmention.Mention, 1)}
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithTargetResolver(resolver),
+		webmention.WithStorage(storage),
+		webmention.WithNotifier(recorder),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	submit := func(target string) (int, string) {
+		resp := must(http.PostForm(ts.URL+"/webmention", url.Values{
+			"source": {ts.URL + "/source"},
+			"target": {ts.URL + target},
+		}))
+		defer resp.Body.Close()
+		return resp.StatusCode, string(must(io.ReadAll(resp.Body)))
+	}
+	if code, body := submit("/unknown"); code != http.StatusBadRequest || !strings.Contains(body, "does not exist") {
+		t.Errorf("mention of unknown target not rejected: %d: %s", code, body)
+	}
+	if code, body := submit("/hello"); code != http.StatusAccepted {
+		t.Fatalf("mention not accepted: %d: %s", code, body)
+	}
+	if mention := recorder.next(t); mention.TargetID != "post-1" {
+		t.Errorf("incorrect target id, got: %q, want: post-1", mention.TargetID)
+	}
+
+	// the post is renamed, the stored mentions are migrated onto its new id
+	mentions := storage.Snapshot()
+	err := webmention.MigrateTargetIDs(mentions, func(mention webmention.Mention) (string, error) {
+		if mention.TargetID == "post-1" {
+			return "hello-world", nil
+		}
+		return mention.TargetID, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mentions) != 1 || mentions[0].TargetID != "hello-world" {
+		t.Errorf("target id not migrated: %+v", mentions)
+	}
+
+	// the configuration changes, the mentions are resolved again
+	mu.Lock()
+	ids["/hello"] = "post-42"
+	mu.Unlock()
+	if err := webmention.MigrateTargetIDs(mentions, webmention.ResolveAgain(resolver)); err != nil {
+		t.Fatal(err)
+	}
+	if mentions[0].TargetID != "post-42" {
+		t.Errorf("target id not resolved again, got: %q, want: post-42", mentions[0].TargetID)
+	}
+	mu.Lock()
+	delete(ids, "/hello")
+	mu.Unlock()
+	if err := webmention.MigrateTargetIDs(mentions, webmention.ResolveAgain(resolver)); !errors.Is(err, webmention.ErrUnknownTarget) {
+		t.Errorf("target that no longer resolves not reported, got: %v", err)
+	}
+	if mentions[0].TargetID != "post-42" {
+		t.Errorf("target id changed by a failed migration: %q", mentions[0].TargetID)
+	}
+}
+
+func TestTargetCheck(t *testing.T) {
+	pages := map[string]webmention.TargetState{
+		"/published": {Exists: true},
+		"/draft":     {Exists: true, Draft: true},
+		"/old":       {Exists: true, MovedTo: must(url.Parse("https://example.org/new"))},
+	}
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithTargetCheck(func(target *url.URL) (webmention.TargetState, error) {
+			return pages[target.Path], nil
+		}),
+	)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	for _, testCase := range []struct {
+		target string
+		code   int
+		body   string
+	}{
+		{"/published", http.StatusAccepted, ""},
+		{"/draft", http.StatusBadRequest, "not published"},
+		{"/old", http.StatusBadRequest, "https://example.org/new"},
+		{"/missing", http.StatusBadRequest, "does not exist"},
+	} {
+		resp, err := http.DefaultClient.PostForm(ts.URL, map[string][]string{
+			"source": {"https://example.com/source"},
+			"target": {"https://example.org" + testCase.target},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := string(must(io.ReadAll(resp.Body)))
+		resp.Body.Close()
+		if resp.StatusCode != testCase.code || !strings.Contains(body, testCase.body) {
+			t.Errorf("%s: got: %d %q, want: %d %q", testCase.target, resp.StatusCode, body, testCase.code, testCase.body)
+		}
+	}
+}
+
+func TestAlternates(t *testing.T) {
+	for _, testCase := range []struct {
+		name       string
+		alternates bool
+		expected   webmention.Status
+	}{
+		{name: "disabled", alternates: false, expected: webmention.StatusNoLink},
+		{name: "enabled", alternates: true, expected: webmention.StatusLink},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			ts := httptest.NewServer(mux)
+			defer ts.Close()
+			mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><head>
+<link rel="alternate" hreflang="de" href="https://example.com/de/post">
+<link rel="amphtml" href="/post.amp">
+</head><body><p>Hello World</p></body></html>`)
+			})
+			mux.HandleFunc("/post.amp", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprintf(w, `<html amp><head><link rel="canonical" href="/post"></head><body><p>Hello <a href="%s/target">World</a></p></body></html>`, ts.URL)
+			})
+
+			notified := make(chan webmention.Mention, 1)
+			options := []webmention.ReceiverOption{
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+					notified <- mention
+				})),
+			}
+			if testCase.alternates {
+				options = append(options, webmention.WithAlternates())
+			}
+			receiver := webmention.NewReceiver(options...)
+			go receiver.ProcessMentions()
+			defer receiver.Shutdown(context.Background())
+			mux.Handle("/webmention", receiver)
+
+			resp, err := http.DefaultClient.PostForm(ts.URL+"/webmention", map[string][]string{
+				"source": {ts.URL + "/post"},
+				"target": {ts.URL + "/target"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+			}
+			select {
+			case mention := <-notified:
+				if mention.Status != testCase.expected {
+					t.Errorf("incorrect status, got: %s, want: %s", mention.Status, testCase.expected)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+		})
+	}
+}
+
+func TestCanonicalSources(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	page := func(head string, links bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			body := "<p>Hello World</p>"
+			if links {
+				body = fmt.Sprintf(`<p>Hello <a href="%s/target">World</a></p>`, ts.URL)
+			}
+			fmt.Fprintf(w, `<html><head>%s</head><body>%s</body></html>`, head, body)
+		}
+	}
+	mux.HandleFunc("/post", page("", true))
+	mux.HandleFunc("/post.amp", page(`<link rel="canonical" href="/post">`, true))
+	mux.HandleFunc("/mirror", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s/post>; rel="canonical"`, ts.URL))
+		page("", true)(w, r)
+	})
+	// claims a canonical that doesn't link to the target
+	mux.HandleFunc("/claim", page(`<link rel="canonical" href="/other">`, true))
+	mux.HandleFunc("/other", page("", false))
+
+	storage := webmention.NewMemoryStorage()
+	notified := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithCanonicalSources(),
+		webmention.WithStorage(storage),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	for _, testCase := range []struct {
+		source, stored string
+	}{
+		{"/post.amp", "/post"},
+		{"/mirror", "/post"},
+		{"/claim", "/claim"},
+	} {
+		resp := must(http.PostForm(ts.URL+"/webmention", url.Values{
+			"source": {ts.URL + testCase.source},
+			"target": {ts.URL + "/target"},
+		}))
+		resp.Body.Close()
+		select {
+		case mention := <-notified:
+			if mention.Source.String() != ts.URL+testCase.stored {
+				t.Errorf("%s: incorrect source, got: %s, want: %s", testCase.source, mention.Source, ts.URL+testCase.stored)
+			}
+			if testCase.stored != testCase.source && mention.SubmittedSource.String() != ts.URL+testCase.source {
+				t.Errorf("%s: incorrect submitted source: %s", testCase.source, mention.SubmittedSource)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+	stored := must(storage.Mentions(webmention.MentionFilter{Target: ts.URL + "/target"}))
+	if len(stored) != 2 {
+		t.Errorf("mirrors not deduplicated, stored: %+v", stored)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/post", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+	})
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	notified := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithStorage(storage),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	resp, err := http.DefaultClient.PostForm(ts.URL+"/webmention", map[string][]string{
+		"source": {ts.URL + "/post"},
+		"target": {ts.URL + "/target"},
+		"vouch":  {"https://friend.example/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+	}
+	select {
+	case mention := <-notified:
+		if vouch := mention.Extensions["vouch"]; vouch != "https://friend.example/" || len(mention.Extensions) != 1 {
+			t.Errorf("incorrect extensions: %v", mention.Extensions)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	stored := must(storage.Mentions(webmention.MentionFilter{}))
+	if len(stored) != 1 || stored[0].Extensions["vouch"] != "https://friend.example/" {
+		t.Errorf("extensions not stored: %+v", stored)
+	}
+
+	params := url.Values{"source": {ts.URL + "/other"}, "target": {ts.URL + "/target"}}
+	for i := range 20 {
+		params.Set(fmt.Sprintf("x%d", i), "y")
+	}
+	resp, err = http.DefaultClient.PostForm(ts.URL+"/webmention", params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("too many extensions accepted: %d", resp.StatusCode)
+	}
+}
+
+// mentionRecorder is a comparable notifier, so that it can be removed again.
+type mentionRecorder struct {
+	received chan webmention.Mention
+}
+
+func (r *mentionRecorder) Receive(mention webmention.Mention) {
+	r.received <- mention
+}
+
+func (r *mentionRecorder) next(t *testing.T) webmention.Mention {
+	t.Helper()
+	select {
+	case mention := <-r.received:
+		return mention
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	return webmention.Mention{}
+}
+
+func TestAddRemoveNotifier(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+	})
+
+	processed := make(chan error, 1)
+	notified := make(chan struct{}, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithReporter(func(err error, mention webmention.Mention) {
+			processed <- err
+		}),
+		webmention.WithNotifier(webmention.NotifierFunc(func(webmention.Mention) {
+			notified <- struct{}{}
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	sources := 0
+	mention := func() error {
+		sources++ // a new source every time, repeated mentions are refused within the cache timeout
+		resp, err := http.DefaultClient.PostForm(ts.URL+"/webmention", map[string][]string{
+			"source": {ts.URL + "/source/" + strconv.Itoa(sources)},
+			"target": {ts.URL + "/target"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+		}
+		select {
+		case err := <-processed:
+			if err == nil {
+				<-notified // all notifiers have been started
+			}
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		return nil
+	}
+
+	recorder := &mentionRecorder{received: make(chan webmention.Mention, 3)}
+	receiver.AddNotifier(recorder)
+	if err := mention(); err != nil {
+		t.Fatal(err)
+	}
+	recorder.next(t)
+	if !receiver.RemoveNotifier(recorder) {
+		t.Error("registered notifier not removed")
+	}
+	if receiver.RemoveNotifier(recorder) {
+		t.Error("removed notifier removed twice")
+	}
+	if receiver.RemoveNotifier(webmention.NotifierFunc(func(webmention.Mention) {})) {
+		t.Error("unregistered notifier func removed")
+	}
+	if err := mention(); err != nil {
+		t.Fatal(err)
+	}
+	// notifiers run concurrently, add the recorder again and check that the
+	// next mention it receives is the third, not the second
+	receiver.AddNotifier(recorder)
+	if err := mention(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := recorder.next(t).Source.String(), ts.URL+"/source/3"; got != want {
+		t.Errorf("removed notifier still informed, got: %s, want: %s", got, want)
+	}
+
+	receiver.AddFilter(webmention.FilterFunc(func(webmention.Mention) error {
+		return webmention.Reject("closed for maintenance")
+	}))
+	if err := mention(); !errors.Is(err, webmention.ErrRejected) {
+		t.Errorf("added filter not applied: %v", err)
+	}
+}
+
+func TestSelfMentions(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/target", http.StatusFound)
+	})
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%s/target">me</a>`, ts.URL)
+	})
+
+	for _, testCase := range []struct {
+		name   string
+		policy webmention.SelfMentions
+	}{
+		{"reject", webmention.RejectSelfMentions},
+		{"mark", webmention.MarkSelfMentions},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			processed := make(chan error, 1)
+			recorder := &mentionRecorder{received: make(chan webmention.Mention, 1)}
+			receiver := webmention.NewReceiver(
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithSelfMentions(testCase.policy),
+				webmention.WithNotifier(recorder),
+				webmention.WithReporter(func(err error, mention webmention.Mention) {
+					processed <- err
+				}),
+			)
+			go receiver.ProcessMentions()
+			defer receiver.Shutdown(context.Background())
+			endpoint := httptest.NewServer(receiver)
+			defer endpoint.Close()
+
+			// the same page, after normalization
+			resp := must(http.DefaultClient.PostForm(endpoint.URL, map[string][]string{
+				"source": {ts.URL + "/missing/"},
+				"target": {strings.ToUpper(ts.URL) + "/missing#comments"},
+			}))
+			resp.Body.Close()
+			if want := map[string]int{"reject": http.StatusBadRequest, "mark": http.StatusAccepted}[testCase.name]; resp.StatusCode != want {
+				t.Errorf("literal self-mention, got: %d, want: %d", resp.StatusCode, want)
+			}
+			if testCase.policy == webmention.MarkSelfMentions {
+				<-processed // the source doesn't exist, so it fails either way
+			}
+
+			// the source redirects to the target
+			resp = must(http.DefaultClient.PostForm(endpoint.URL, map[string][]string{
+				"source": {ts.URL + "/source"},
+				"target": {ts.URL + "/target"},
+			}))
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+			}
+			var err error
+			select {
+			case err = <-processed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+			if testCase.policy == webmention.RejectSelfMentions {
+				if !errors.Is(err, webmention.ErrRejected) {
+					t.Errorf("redirected self-mention not rejected: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mention := recorder.next(t); !mention.SelfMention {
+				t.Errorf("self-mention not marked: %+v", mention)
+			}
+		})
+	}
+}
+
+func TestRelay(t *testing.T) {
+	forms := make(chan url.Values, 2)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms <- r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer secondary.Close()
+
+	relay := webmention.NewRelay(must(url.Parse(secondary.URL)))
+	mention := webmention.Mention{
+		Source: must(url.Parse("https://example.com/source")),
+		Target: must(url.Parse("https://example.org/target")),
+		Status: webmention.StatusLink,
+	}
+	if err := relay.Notify(mention); err != nil {
+		t.Fatal(err)
+	}
+	form := <-forms
+	if form.Get("source") != mention.Source.String() || form.Get("target") != mention.Target.String() || form.Get(webmention.RelayedByParam) == "" {
+		t.Errorf("incorrect relayed form: %v", form)
+	}
+
+	mention.Extensions = map[string]string{webmention.RelayedByParam: form.Get(webmention.RelayedByParam)}
+	if err := relay.Notify(mention); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case form := <-forms:
+		t.Errorf("relayed mention relayed again: %v", form)
+	default:
+	}
+}
+
+func TestSourceSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<a href="https://example.org/target">target</a>`)
+	}))
+	source := ts.URL + "/source"
+
+	process := func(t *testing.T, opt webmention.ReceiverOption, source string) (webmention.Mention, error) {
+		t.Helper()
+		processed := make(chan error, 1)
+		recorder := &mentionRecorder{received: make(chan webmention.Mention, 1)}
+		receiver := webmention.NewReceiver(
+			webmention.WithAcceptsFunc(accepts),
+			webmention.WithNotifier(recorder),
+			webmention.WithReporter(func(err error, mention webmention.Mention) {
+				processed <- err
+			}),
+			opt,
+		)
+		go receiver.ProcessMentions()
+		defer receiver.Shutdown(context.Background())
+		endpoint := httptest.NewServer(receiver)
+		defer endpoint.Close()
+		resp := must(http.DefaultClient.PostForm(endpoint.URL, map[string][]string{
+			"source": {source},
+			"target": {"https://example.org/target"},
+		}))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("incorrect status code, got: %d, want: %d", resp.StatusCode, http.StatusAccepted)
+		}
+		select {
+		case err := <-processed:
+			if err != nil {
+				return webmention.Mention{}, err
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		return recorder.next(t), nil
+	}
+
+	mention, err := process(t, webmention.WithSourceRecording(dir), source)
+	if err != nil || mention.Status != webmention.StatusLink {
+		t.Fatalf("recording failed: %v, %+v", err, mention)
+	}
+	ts.Close()
+
+	replay := webmention.WithSourceFetcher(webmention.SnapshotFetcher{Dir: dir})
+	mention, err = process(t, replay, source)
+	if err != nil || mention.Status != webmention.StatusLink {
+		t.Errorf("replay failed: %v, %+v", err, mention)
+	}
+	if _, err := process(t, replay, ts.URL+"/unrecorded"); !errors.Is(err, webmention.ErrSourceNotFound) {
+		t.Errorf("unrecorded source, got: %v, want: %v", err, webmention.ErrSourceNotFound)
+	}
+}
+
+func TestTraceParent(t *testing.T) {
+	sourceTrace := make(chan string, 2)
+	recorder := &mentionRecorder{received: make(chan webmention.Mention, 1)}
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithNotifier(recorder),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.Handle("/webmention", receiver)
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		sourceTrace <- r.Header.Get(webmention.TraceParentHeader)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+	})
+
+	sender := webmention.NewSender()
+	if err := sender.Mention(must(url.Parse(ts.URL+"/source")), must(url.Parse(ts.URL+"/target"))); err != nil {
+		t.Fatal(err)
+	}
+	mention := recorder.next(t)
+	traceID := webmention.TraceID(mention.TraceParent)
+	if traceID == "" {
+		t.Fatalf("trace not recorded: %q", mention.TraceParent)
+	}
+	for range 2 { // HEAD and GET
+		traceparent := <-sourceTrace
+		if webmention.TraceID(traceparent) != traceID || traceparent == mention.TraceParent {
+			t.Errorf("trace not continued when fetching the source, got: %q, want a child of: %q", traceparent, mention.TraceParent)
+		}
+	}
+
+	for _, invalid := range []string{
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if webmention.TraceID(invalid) != "" {
+			t.Errorf("invalid traceparent accepted: %s", invalid)
+		}
+	}
+	if webmention.TraceID("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra") == "" {
+		t.Error("traceparent of a later version rejected")
+	}
+}
+
+func TestDuplicateArguments(t *testing.T) {
+	for _, testCase := range []struct {
+		name   string
+		policy webmention.DuplicateArguments
+		form   url.Values
+		code   int
+		body   string
+	}{
+		{"single", webmention.RejectDuplicateArguments, url.Values{"source": {"https://example.org/a"}, "target": {"http://localhost/"}}, http.StatusAccepted, ""},
+		{"duplicate", webmention.RejectDuplicateArguments, url.Values{"source": {"https://example.org/a", "https://example.org/b"}, "target": {"http://localhost/"}}, http.StatusBadRequest, "source given 2 times"},
+		{"array", webmention.RejectDuplicateArguments, url.Values{"source": {"https://example.org/a"}, "target[]": {"http://localhost/"}}, http.StatusBadRequest, "array syntax is not supported for target"},
+		{"first duplicate", webmention.FirstArgument, url.Values{"source": {"https://example.org/a", "https://example.org/b"}, "target": {"http://localhost/"}}, http.StatusAccepted, ""},
+		{"first array", webmention.FirstArgument, url.Values{"source[]": {"https://example.org/a"}, "target[0]": {"http://localhost/"}, "target[1]": {"http://localhost/other"}}, http.StatusAccepted, ""},
+		{"first missing", webmention.FirstArgument, url.Values{"source[]": {"https://example.org/a"}}, http.StatusBadRequest, "missing form value: target"},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			receiver := webmention.NewReceiver(
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithDuplicateArguments(testCase.policy),
+			)
+			endpoint := httptest.NewServer(receiver)
+			defer endpoint.Close()
+
+			resp := must(http.PostForm(endpoint.URL, testCase.form))
+			defer resp.Body.Close()
+			body := string(must(io.ReadAll(resp.Body)))
+			if resp.StatusCode != testCase.code || !strings.Contains(body, testCase.body) {
+				t.Errorf("got: %d %q, want: %d %q", resp.StatusCode, body, testCase.code, testCase.body)
+			}
+		})
+	}
+}
+
+func TestSourceFetch(t *testing.T) {
+	var heads atomic.Int32
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+			if strings.HasPrefix(r.URL.Path, "/source/no-head") {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+	})
+
+	for _, testCase := range []struct {
+		strategy webmention.FetchStrategy
+		sources  []string
+		heads    int32
+		want     []webmention.FetchStrategy
+	}{
+		{"", []string{"/source/1"}, 1, []webmention.FetchStrategy{webmention.FetchHeadThenGet}},
+		{webmention.FetchGetOnly, []string{"/source/1", "/source/2"}, 0, []webmention.FetchStrategy{webmention.FetchGetOnly, webmention.FetchGetOnly}},
+		// the host is remembered after it refused the HEAD request
+		{webmention.FetchAuto, []string{"/source/1", "/source/no-head/1", "/source/no-head/2"}, 2, []webmention.FetchStrategy{webmention.FetchHeadThenGet, webmention.FetchGetOnly, webmention.FetchGetOnly}},
+	} {
+		name := string(testCase.strategy)
+		if name == "" {
+			name = "default"
+		}
+		t.Run(name, func(t *testing.T) {
+			heads.Store(0)
+			recorder := &mentionRecorder{received: make(chan webmention.Mention, 1)}
+			receiver := webmention.NewReceiver(
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithNotifier(recorder),
+				webmention.WithSourceFetch(testCase.strategy),
+			)
+			go receiver.ProcessMentions()
+			defer receiver.Shutdown(context.Background())
+			endpoint := httptest.NewServer(receiver)
+			defer endpoint.Close()
+
+			for i, source := range testCase.sources {
+				resp := must(http.PostForm(endpoint.URL, url.Values{
+					"source": {ts.URL + source},
+					"target": {ts.URL + "/target"},
+				}))
+				resp.Body.Close()
+				mention := recorder.next(t)
+				if mention.Status != webmention.StatusLink || mention.Fetch != testCase.want[i] {
+					t.Errorf("%s: incorrect mention, got: %s fetched with %q, want: fetched with %q", source, mention.Status, mention.Fetch, testCase.want[i])
+				}
+			}
+			if n := heads.Load(); n != testCase.heads {
+				t.Errorf("incorrect number of HEAD requests, got: %d, want: %d", n, testCase.heads)
+			}
+		})
+	}
+}
+
+func TestWalls(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login?next=/redirect", http.StatusFound)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<form><input name="user"><input type="password" name="pass"></form>`)
+	})
+	mux.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<html><head><meta http-equiv="refresh" content="0; url=https://consent.example.com/?continue=post"></head></html>`)
+	})
+	mux.HandleFunc("/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	mux.HandleFunc("/cookies", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<p>We value your privacy.</p><button>Accept all cookies</button>`)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<p>We value your privacy.</p><button>Accept all cookies</button><nav>`+strings.Repeat(`<a href="/">home</a>`, 10)+`</nav><p>An article.</p>`)
+	})
+
+	for _, testCase := range []struct {
+		source string
+		want   webmention.Status
+	}{
+		{"/redirect", webmention.StatusInaccessible},
+		{"/refresh", webmention.StatusInaccessible},
+		{"/forbidden", webmention.StatusInaccessible},
+		{"/cookies", webmention.StatusInaccessible},
+		{"/article", webmention.StatusNoLink},
+	} {
+		t.Run(testCase.source, func(t *testing.T) {
+			type report struct {
+				err     error
+				mention webmention.Mention
+			}
+			reports := make(chan report, 1)
+			receiver := webmention.NewReceiver(
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithRetries(0, 0),
+				webmention.WithReporter(func(err error, mention webmention.Mention) {
+					reports <- report{err, mention}
+				}),
+			)
+			go receiver.ProcessMentions()
+			defer receiver.Shutdown(context.Background())
+			endpoint := httptest.NewServer(receiver)
+			defer endpoint.Close()
+
+			resp := must(http.PostForm(endpoint.URL, url.Values{
+				"source": {ts.URL + testCase.source},
+				"target": {ts.URL + "/target"},
+			}))
+			resp.Body.Close()
+			var got report
+			select {
+			case got = <-reports:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+			if testCase.want != webmention.StatusInaccessible {
+				if got.err != nil {
+					t.Fatalf("incorrect error, got: %v", got.err)
+				}
+				return
+			}
+			if !errors.Is(got.err, webmention.ErrSourceInaccessible) {
+				t.Fatalf("incorrect error, got: %v, want: %v", got.err, webmention.ErrSourceInaccessible)
+			}
+			status := must(receiver.MentionStatus(got.mention.ID))
+			if status.Status != webmention.StatusInaccessible || status.State != webmention.StateFailed {
+				t.Errorf("incorrect status: %+v", status)
+			}
+		})
+	}
+}
+
+func TestUnavailableSources(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/legal", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `<https://authority.example.com>; rel="blocked-by"`)
+		http.Error(w, "unavailable for legal reasons", http.StatusUnavailableForLegalReasons)
+	})
+	mux.HandleFunc("/bad", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad request", http.StatusBadRequest)
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r) // may not be published yet, retried
+	})
+
+	for _, testCase := range []struct {
+		source string
+		reason string
+		state  webmention.ProcessingState
+	}{
+		{"/legal", "451 Unavailable For Legal Reasons (blocked by https://authority.example.com)", webmention.StateProcessed},
+		{"/bad", "400 Bad Request", webmention.StateProcessed},
+		{"/missing", "", webmention.StateRetrying},
+	} {
+		t.Run(testCase.source, func(t *testing.T) {
+			type report struct {
+				err     error
+				mention webmention.Mention
+			}
+			reports := make(chan report, 1)
+			notified := make(chan webmention.Mention, 1)
+			receiver := webmention.NewReceiver(
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithRetries(1, time.Hour),
+				webmention.WithReporter(func(err error, mention webmention.Mention) {
+					reports <- report{err, mention}
+				}),
+				webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+					notified <- mention
+				})),
+			)
+			go receiver.ProcessMentions()
+			defer receiver.Shutdown(context.Background())
+			endpoint := httptest.NewServer(receiver)
+			defer endpoint.Close()
+
+			resp := must(http.PostForm(endpoint.URL, url.Values{
+				"source": {ts.URL + testCase.source},
+				"target": {ts.URL + "/target"},
+			}))
+			resp.Body.Close()
+			var got report
+			select {
+			case got = <-reports:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+			if testCase.reason == "" {
+				if !errors.Is(got.err, webmention.ErrSourceNotFound) {
+					t.Errorf("incorrect error, got: %v, want: %v", got.err, webmention.ErrSourceNotFound)
+				}
+			} else {
+				if got.err != nil {
+					t.Fatalf("incorrect error, got: %v", got.err)
+				}
+				mention := <-notified
+				if mention.Status != webmention.StatusUnavailable || mention.Reason != testCase.reason {
+					t.Errorf("incorrect status, got: %s (%s), want: %s (%s)", mention.Status, mention.Reason, webmention.StatusUnavailable, testCase.reason)
+				}
+				var stored webmention.Mention
+				if err := json.Unmarshal(must(json.Marshal(mention)), &stored); err != nil || stored.Reason != mention.Reason {
+					t.Errorf("reason not stored: %v, %q", err, stored.Reason)
+				}
+			}
+			if status := must(receiver.MentionStatus(got.mention.ID)); status.State != testCase.state {
+				t.Errorf("incorrect state, got: %s, want: %s", status.State, testCase.state)
+			}
+		})
+	}
+}
+
+func TestSourceEndpoint(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/header", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</wm/header>; rel="webmention"`)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<p><a href="%s/target">target</a></p>`, ts.URL)
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head><link rel="webmention" href="/wm/html"></head><body><a href="%s/target">target</a></body></html>`, ts.URL)
+	})
+	mux.HandleFunc("/none", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+	})
+
+	notified := make(chan webmention.Mention, 1)
+	persister := webmention.NewMemoryPersister()
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithSourceEndpointLog(persister),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	for source, want := range map[string]string{
+		"/header": ts.URL + "/wm/header",
+		"/html":   ts.URL + "/wm/html",
+		"/none":   "",
+	} {
+		resp := must(http.PostForm(ts.URL+"/webmention", url.Values{
+			"source": {ts.URL + source},
+			"target": {ts.URL + "/target"},
+		}))
+		resp.Body.Close()
+		var mention webmention.Mention
+		select {
+		case mention = <-notified:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		got := ""
+		if mention.SourceEndpoint != nil {
+			got = mention.SourceEndpoint.String()
+		}
+		if got != want {
+			t.Errorf("%s: incorrect source endpoint, got: %q, want: %q", source, got, want)
+		}
+		record, ok, err := persister.Endpoint(mention.Source)
+		if err != nil || ok != (want != "") || record.Endpoint != want {
+			t.Errorf("%s: incorrect endpoint record: %+v, %t, %v", source, record, ok, err)
+		}
+	}
+}
+
+func TestMentionChange(t *testing.T) {
+	var links atomic.Bool
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if links.Load() {
+			fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+		}
+	})
+
+	notified := make(chan webmention.Mention, 1)
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithStorage(storage),
+		webmention.WithCacheTimeout(time.Nanosecond),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	for i, step := range []struct {
+		links bool
+		want  webmention.MentionChange
+	}{
+		{links: false, want: webmention.MentionUnchanged},
+		{links: true, want: webmention.MentionNew},
+		{links: true, want: webmention.MentionUpdated},
+		{links: false, want: webmention.MentionRemoved},
+		{links: true, want: webmention.MentionNew},
+	} {
+		links.Store(step.links)
+		resp := must(http.PostForm(ts.URL+"/webmention", url.Values{
+			"source": {ts.URL + "/source"},
+			"target": {ts.URL + "/target"},
+		}))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("step %d: mention not accepted, status: %d", i, resp.StatusCode)
+		}
+		select {
+		case mention := <-notified:
+			if mention.Change != step.want {
+				t.Errorf("step %d: incorrect change, got: %q, want: %q", i, mention.Change, step.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("step %d: timeout", i)
+		}
+	}
+	stored, ok, err := storage.Mention(ts.URL+"/source", ts.URL+"/target")
+	if err != nil || !ok || stored.Change != webmention.MentionNew || stored.Status != webmention.StatusLink {
+		t.Errorf("incorrect stored mention: %+v, %t, %v", stored, ok, err)
+	}
+}
+
+func TestContentDiff(t *testing.T) {
+	var text atomic.Value
+	text.Store("I really like this post")
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<div class="h-entry"><p class="e-content">%s, <a href="%s/target">target</a></p></div>`, text.Load(), ts.URL)
+	})
+
+	notified := make(chan webmention.Mention, 1)
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithStorage(storage),
+		webmention.WithCacheTimeout(time.Nanosecond),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+	mux.Handle("/webmention", receiver)
+
+	for i, step := range []struct {
+		text, content, previous, diff string
+	}{
+		{text: "I really like this post", content: "I really like this post, target"},
+		{text: "I like this post a lot", content: "I like this post a lot, target", previous: "I really like this post, target", diff: "I [-really-] like this [-post,-] {+post a lot,+} target"},
+		{text: "I like this post a lot", content: "I like this post a lot, target"},
+	} {
+		text.Store(step.text)
+		resp := must(http.PostForm(ts.URL+"/webmention", url.Values{
+			"source": {ts.URL + "/source"},
+			"target": {ts.URL + "/target"},
+		}))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("step %d: mention not accepted, status: %d", i, resp.StatusCode)
+		}
+		select {
+		case mention := <-notified:
+			if mention.Content != step.content {
+				t.Errorf("step %d: incorrect content, got: %q, want: %q", i, mention.Content, step.content)
+			}
+			if mention.PreviousContent != step.previous {
+				t.Errorf("step %d: incorrect previous content, got: %q, want: %q", i, mention.PreviousContent, step.previous)
+			}
+			if diff := mention.ContentDiff(); diff != step.diff {
+				t.Errorf("step %d: incorrect diff, got: %q, want: %q", i, diff, step.diff)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("step %d: timeout", i)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package redis provides Redis backed implementations of the webmention
+// Queue and KeyValueStore interfaces, so that multiple receivers (and
+// senders) can share their state.
+//
+// It comes with a minimal client speaking RESP2, the Redis protocol, just
+// enough for the commands needed here.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+type (
+	// Client is a connection pool to a single Redis server.
+	Client struct {
+		Addr        string
+		Password    string
+		DB          int
+		DialTimeout time.Duration
+		// Timeout for a single (non-blocking) command.
+		Timeout time.Duration
+		pool    chan *conn
+	}
+
+	// Error is an error reply sent by the server.
+	Error string
+
+	conn struct {
+		c net.Conn
+		r *bufio.Reader
+	}
+)
+
+// ErrNil is returned for nil replies.
+var ErrNil = errors.New("redis: nil reply")
+
+// maxIdleConns is the number of connections kept open in the pool.
+const maxIdleConns = 8
+
+func NewClient(addr string) *Client {
+	return &Client{
+		Addr:        addr,
+		DialTimeout: 5 * time.Second,
+		Timeout:     5 * time.Second,
+		pool:        make(chan *conn, maxIdleConns),
+	}
+}
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Do sends a command to the server and returns its reply, which is one of:
+// string (simple and bulk strings), int64, []any (arrays), or nil.
+// Nil replies are returned as ErrNil.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	return c.do(ctx, 0, args...)
+}
+
+// do executes a command, allowing it to block for up to block longer than the usual timeout.
+func (c *Client) do(ctx context.Context, block time.Duration, args ...string) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(c.Timeout + block)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.c.SetDeadline(deadline)
+	reply, err := cn.roundTrip(args)
+	if err != nil {
+		var redisErr Error
+		if errors.As(err, &redisErr) || errors.Is(err, ErrNil) {
+			c.put(cn) // the connection is still fine
+		} else {
+			cn.c.Close()
+		}
+		return nil, err
+	}
+	c.put(cn)
+	return reply, nil
+}
+
+// Close closes all idle connections.
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.pool:
+			cn.c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+	dialer := net.Dialer{Timeout: c.DialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	cn := &conn{c: nc, r: bufio.NewReader(nc)}
+	nc.SetDeadline(time.Now().Add(c.Timeout))
+	if c.Password != "" {
+		if _, err := cn.roundTrip([]string{"AUTH", c.Password}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.DB != 0 {
+		if _, err := cn.roundTrip([]string{"SELECT", strconv.Itoa(c.DB)}); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.pool <- cn:
+	default:
+		cn.c.Close()
+	}
+}
+
+func (cn *conn) roundTrip(args []string) (any, error) {
+	buf := fmt.Appendf(nil, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := cn.c.Write(buf); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return cn.readReply()
+}
+
+func (cn *conn) readLine() (string, error) {
+	line, err := cn.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("redis: %w", err)
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("redis: malformed reply: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func (cn *conn) readReply() (any, error) {
+	line, err := cn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed integer: %w", err)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length: %w", err)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(cn.r, buf); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length: %w", err)
+		}
+		if n < 0 {
+			return nil, ErrNil
+		}
+		elems := make([]any, n)
+		for i := range elems {
+			elem, err := cn.readReply()
+			if err != nil && !errors.Is(err, ErrNil) {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return elems, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type: %q", line)
+	}
+}
@@ -0,0 +1,183 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// Queue is a webmention.AckQueue backed by a Redis list, shared by all
+// receivers using the same Name.
+//
+// Popped mentions are moved to a processing list specific to this Instance,
+// and removed from there once acknowledged.
+// If an instance crashes, the mentions it was working on remain in its
+// processing list, call Recover when restarting the instance (using the same
+// Instance name) to put them back into the queue.
+//
+// Requires Redis 6.2 or newer.
+type Queue struct {
+	Client   *Client
+	Name     string
+	Instance string
+	// MaxSize limits the number of waiting mentions, zero means no limit.
+	MaxSize int
+
+	m        sync.Mutex
+	closed   bool
+	inflight map[string]string // mention id -> raw list element
+}
+
+// *Queue implements webmention.AckQueue and webmention.StatsQueue
+var (
+	_ webmention.AckQueue   = (*Queue)(nil)
+	_ webmention.StatsQueue = (*Queue)(nil)
+)
+
+// blockTimeout is how long Pop blocks on the server before checking whether it should stop.
+const blockTimeout = time.Second
+
+func NewQueue(client *Client, instance string) *Queue {
+	return &Queue{
+		Client:   client,
+		Name:     "webmention:queue",
+		Instance: instance,
+		inflight: map[string]string{},
+	}
+}
+
+func (q *Queue) processing() string {
+	return q.Name + ":processing:" + q.Instance
+}
+
+func (q *Queue) isClosed() bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.closed
+}
+
+func (q *Queue) Push(mention webmention.Mention) error {
+	if q.isClosed() {
+		return webmention.ErrQueueClosed
+	}
+	ctx := context.Background()
+	if q.MaxSize > 0 {
+		reply, err := q.Client.Do(ctx, "LLEN", q.Name)
+		if err != nil {
+			return err
+		}
+		if n, _ := reply.(int64); n >= int64(q.MaxSize) {
+			return webmention.ErrQueueFull
+		}
+	}
+	bs, err := json.Marshal(mention)
+	if err != nil {
+		return err
+	}
+	_, err = q.Client.Do(ctx, "LPUSH", q.Name, string(bs))
+	return err
+}
+
+// Pop waits for a mention to become available.
+// Once the queue is closed, Pop returns webmention.ErrQueueClosed
+// immediately, leaving any remaining mentions to the other receivers.
+func (q *Queue) Pop(ctx context.Context) (webmention.Mention, error) {
+	for {
+		if q.isClosed() {
+			return webmention.Mention{}, webmention.ErrQueueClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return webmention.Mention{}, err
+		}
+		reply, err := q.Client.do(ctx, blockTimeout, "BLMOVE", q.Name, q.processing(), "RIGHT", "LEFT", fmt.Sprintf("%.3f", blockTimeout.Seconds()))
+		if err != nil {
+			if errors.Is(err, ErrNil) {
+				continue // timed out, nothing in the queue
+			}
+			return webmention.Mention{}, err
+		}
+		raw, _ := reply.(string)
+		var mention webmention.Mention
+		if err := json.Unmarshal([]byte(raw), &mention); err != nil {
+			// don't let a broken element block the queue forever
+			q.Client.Do(ctx, "LREM", q.processing(), "1", raw)
+			return webmention.Mention{}, fmt.Errorf("redis queue: %w", err)
+		}
+		q.m.Lock()
+		q.inflight[mention.ID] = raw
+		q.m.Unlock()
+		return mention, nil
+	}
+}
+
+func (q *Queue) Ack(mention webmention.Mention) error {
+	q.m.Lock()
+	raw, ok := q.inflight[mention.ID]
+	delete(q.inflight, mention.ID)
+	q.m.Unlock()
+	if !ok {
+		return fmt.Errorf("redis queue: ack of unknown mention: %s", mention.ID)
+	}
+	_, err := q.Client.Do(context.Background(), "LREM", q.processing(), "1", raw)
+	return err
+}
+
+// Recover puts mentions left over in this instance's processing list (from
+// before a crash) back into the queue, so that they are processed next.
+// The number of recovered mentions is returned.
+func (q *Queue) Recover(ctx context.Context) (n int, err error) {
+	for {
+		_, err := q.Client.Do(ctx, "LMOVE", q.processing(), q.Name, "RIGHT", "RIGHT")
+		if err != nil {
+			if errors.Is(err, ErrNil) {
+				return n, nil
+			}
+			return n, err
+		}
+		n++
+	}
+}
+
+// Stats counts the waiting mentions, not those being processed.
+// The oldest mention is the one that was received first.
+func (q *Queue) Stats() (webmention.QueueStats, error) {
+	stats := webmention.QueueStats{Capacity: q.MaxSize}
+	ctx := context.Background()
+	reply, err := q.Client.Do(ctx, "LLEN", q.Name)
+	if err != nil {
+		return stats, err
+	}
+	n, _ := reply.(int64)
+	stats.Depth = int(n)
+	if n == 0 {
+		return stats, nil
+	}
+	reply, err = q.Client.Do(ctx, "LINDEX", q.Name, "-1")
+	if err != nil {
+		if errors.Is(err, ErrNil) { // popped in the meantime
+			return stats, nil
+		}
+		return stats, err
+	}
+	raw, _ := reply.(string)
+	var oldest webmention.Mention
+	if err := json.Unmarshal([]byte(raw), &oldest); err != nil {
+		return stats, fmt.Errorf("redis queue: %w", err)
+	}
+	stats.Oldest = oldest.Received
+	return stats, nil
+}
+
+// Close stops this instance from pushing or popping mentions.
+// The client is not closed.
+func (q *Queue) Close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.closed = true
+	return nil
+}
@@ -0,0 +1,262 @@
+package redis_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/redis"
+)
+
+// fakeServer understands just enough of GET and SET to test the Store, and
+// of the list commands to test the Queue.
+// Blocking commands don't block, they time out right away.
+func fakeServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	var m sync.Mutex
+	data := map[string]string{}
+	lists := map[string][]string{}
+	// pop removes the element at the given end of a list
+	pop := func(key, end string) (string, bool) {
+		list := lists[key]
+		if len(list) == 0 {
+			return "", false
+		}
+		if strings.ToUpper(end) == "LEFT" {
+			lists[key] = list[1:]
+			return list[0], true
+		}
+		lists[key] = list[:len(list)-1]
+		return list[len(list)-1], true
+	}
+	push := func(key, end, v string) {
+		if strings.ToUpper(end) == "LEFT" {
+			lists[key] = append([]string{v}, lists[key]...)
+		} else {
+			lists[key] = append(lists[key], v)
+		}
+	}
+	bulk := func(c net.Conn, v string) {
+		fmt.Fprintf(c, "$%d\r\n%s\r\n", len(v), v)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					m.Lock()
+					switch strings.ToUpper(args[0]) {
+					case "GET":
+						if v, ok := data[args[1]]; ok {
+							fmt.Fprintf(c, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							io.WriteString(c, "$-1\r\n")
+						}
+					case "SET":
+						_, exists := data[args[1]]
+						nx := len(args) > 3 && strings.ToUpper(args[3]) == "NX"
+						if nx && exists {
+							io.WriteString(c, "$-1\r\n")
+						} else {
+							data[args[1]] = args[2]
+							io.WriteString(c, "+OK\r\n")
+						}
+					case "LPUSH":
+						for _, v := range args[2:] {
+							push(args[1], "LEFT", v)
+						}
+						fmt.Fprintf(c, ":%d\r\n", len(lists[args[1]]))
+					case "LLEN":
+						fmt.Fprintf(c, ":%d\r\n", len(lists[args[1]]))
+					case "LINDEX":
+						list := lists[args[1]]
+						i, _ := strconv.Atoi(args[2])
+						if i < 0 {
+							i += len(list)
+						}
+						if i >= 0 && i < len(list) {
+							bulk(c, list[i])
+						} else {
+							io.WriteString(c, "$-1\r\n")
+						}
+					case "LMOVE", "BLMOVE":
+						if v, ok := pop(args[1], args[3]); ok {
+							push(args[2], args[4], v)
+							bulk(c, v)
+						} else {
+							io.WriteString(c, "$-1\r\n")
+						}
+					case "LREM":
+						removed := 0
+						for i, v := range lists[args[1]] {
+							if v == args[3] {
+								lists[args[1]] = append(lists[args[1]][:i:i], lists[args[1]][i+1:]...)
+								removed = 1
+								break
+							}
+						}
+						fmt.Fprintf(c, ":%d\r\n", removed)
+					default:
+						io.WriteString(c, "-ERR unknown command\r\n")
+					}
+					m.Unlock()
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		l, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, l+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:l])
+	}
+	return args, nil
+}
+
+func TestStore(t *testing.T) {
+	store := redis.NewStore(redis.NewClient(fakeServer(t)))
+	if _, ok, err := store.Get("missing"); ok || err != nil {
+		t.Errorf("get missing key: ok=%v err=%v", ok, err)
+	}
+	if ok, err := store.SetNX("key", "first", time.Minute); !ok || err != nil {
+		t.Errorf("setnx new key: ok=%v err=%v", ok, err)
+	}
+	if ok, err := store.SetNX("key", "second", time.Minute); ok || err != nil {
+		t.Errorf("setnx existing key: ok=%v err=%v", ok, err)
+	}
+	if v, ok, err := store.Get("key"); !ok || err != nil || v != "first" {
+		t.Errorf("get key: v=%q ok=%v err=%v", v, ok, err)
+	}
+	if err := store.Set("key", "third", 0); err != nil {
+		t.Error(err)
+	}
+	if v, _, _ := store.Get("key"); v != "third" {
+		t.Errorf("get after set: got %q", v)
+	}
+	if _, err := store.Client.Do(context.Background(), "FLUSHALL"); err == nil {
+		t.Error("expected error reply")
+	}
+}
+
+func TestQueue(t *testing.T) {
+	addr := fakeServer(t)
+	mention := func(id string) webmention.Mention {
+		return webmention.Mention{
+			ID:     id,
+			Source: must(url.Parse("https://example.com/" + id)),
+			Target: must(url.Parse("https://example.org/post")),
+		}
+	}
+	pop := func(q *redis.Queue) webmention.Mention {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m, err := q.Pop(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	crashed := redis.NewQueue(redis.NewClient(addr), "a")
+	crashed.MaxSize = 2
+	received := time.Now().Add(-time.Minute).Truncate(time.Second)
+	for i, id := range []string{"1", "2"} {
+		m := mention(id)
+		m.Received = received.Add(time.Duration(i) * time.Second)
+		if err := crashed.Push(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := must(crashed.Stats())
+	if stats.Depth != 2 || stats.Capacity != 2 || !stats.Oldest.Equal(received) {
+		t.Errorf("incorrect queue stats: %+v", stats)
+	}
+	if err := crashed.Push(mention("3")); !errors.Is(err, webmention.ErrQueueFull) {
+		t.Errorf("push to full queue: %v", err)
+	}
+	if m := pop(crashed); m.ID != "1" {
+		t.Errorf("mentions not popped in order, got: %s, want: 1", m.ID)
+	}
+	// the instance crashes before acknowledging the mention
+
+	other := redis.NewQueue(redis.NewClient(addr), "b")
+	if m := pop(other); m.ID != "2" {
+		t.Errorf("incorrect mention popped, got: %s, want: 2", m.ID)
+	}
+	if err := other.Ack(mention("2")); err != nil {
+		t.Error(err)
+	}
+	if err := other.Ack(mention("2")); err == nil {
+		t.Error("mention acknowledged twice")
+	}
+	if n := must(other.Recover(context.Background())); n != 0 {
+		t.Errorf("acknowledged mention recovered: %d", n)
+	}
+
+	restarted := redis.NewQueue(redis.NewClient(addr), "a")
+	if n := must(restarted.Recover(context.Background())); n != 1 {
+		t.Errorf("incorrect number of recovered mentions, got: %d, want: 1", n)
+	}
+	m := pop(restarted)
+	if m.ID != "1" || m.Source.String() != "https://example.com/1" {
+		t.Errorf("mention not recovered: %+v", m)
+	}
+	if err := restarted.Ack(m); err != nil {
+		t.Error(err)
+	}
+
+	restarted.Close()
+	if err := restarted.Push(mention("4")); !errors.Is(err, webmention.ErrQueueClosed) {
+		t.Errorf("push to closed queue: %v", err)
+	}
+	if _, err := restarted.Pop(context.Background()); !errors.Is(err, webmention.ErrQueueClosed) {
+		t.Errorf("pop from closed queue: %v", err)
+	}
+}
+
+func must[T any](t T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
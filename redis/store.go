@@ -0,0 +1,60 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// Store is a webmention.KeyValueStore backed by Redis.
+// All keys are prefixed with Prefix.
+type Store struct {
+	Client *Client
+	Prefix string
+}
+
+// *Store implements webmention.KeyValueStore
+var _ webmention.KeyValueStore = (*Store)(nil)
+
+func NewStore(client *Client) *Store {
+	return &Store{Client: client, Prefix: "webmention:"}
+}
+
+func (s *Store) Get(key string) (string, bool, error) {
+	reply, err := s.Client.Do(context.Background(), "GET", s.Prefix+key)
+	if err != nil {
+		if errors.Is(err, ErrNil) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	value, _ := reply.(string)
+	return value, true, nil
+}
+
+func (s *Store) Set(key, value string, ttl time.Duration) error {
+	args := []string{"SET", s.Prefix + key, value}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := s.Client.Do(context.Background(), args...)
+	return err
+}
+
+func (s *Store) SetNX(key, value string, ttl time.Duration) (bool, error) {
+	args := []string{"SET", s.Prefix + key, value, "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := s.Client.Do(context.Background(), args...)
+	if err != nil {
+		if errors.Is(err, ErrNil) {
+			return false, nil // key exists already
+		}
+		return false, err
+	}
+	return true, nil
+}
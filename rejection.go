@@ -0,0 +1,85 @@
+package webmention
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// A Rejection records why a submission from Source to Target was rejected,
+// either right away (with Code http.StatusBadRequest), or during processing
+// (with the ID of the submission).
+type Rejection struct {
+	Source string    `json:"source"`
+	Target string    `json:"target"`
+	ID     string    `json:"id,omitempty"`
+	Code   int       `json:"code,omitempty"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// rejectionRetention is how long rejections can be looked up.
+const rejectionRetention = time.Hour
+
+// Rejection returns why the latest submission from source to target was
+// rejected, so that senders can find out without asking the operator.
+// Rejections are kept in the mention cache for an hour.
+func (receiver *Receiver) Rejection(source, target string) (Rejection, error) {
+	value, ok, err := receiver.mentionCache.Get("rejection:" + source + " " + target)
+	if err != nil {
+		return Rejection{}, fmt.Errorf("rejection: %w", err)
+	}
+	if !ok {
+		return Rejection{}, ErrNoRejection
+	}
+	var rejection Rejection
+	if err := json.Unmarshal([]byte(value), &rejection); err != nil {
+		return Rejection{}, fmt.Errorf("rejection: %w", err)
+	}
+	return rejection, nil
+}
+
+// recordRejection remembers the rejection, failing to do so is only logged.
+func (receiver *Receiver) recordRejection(rejection Rejection) {
+	rejection.Reason = sanitizeMessage(rejection.Reason)
+	rejection.Time = time.Now()
+	bs, err := json.Marshal(rejection)
+	if err != nil {
+		panic(err) // Rejection always marshals
+	}
+	if err := receiver.mentionCache.Set("rejection:"+rejection.Source+" "+rejection.Target, string(bs), rejectionRetention); err != nil {
+		slog.Error(fmt.Sprintf("record rejection: %s", err), "source", rejection.Source, "target", rejection.Target)
+	}
+}
+
+// rejectRequest records a synchronous rejection, if the request got as far
+// as naming a single source and target.
+func (receiver *Receiver) rejectRequest(r *http.Request, badRequest ErrBadRequest) {
+	source, err := receiver.duplicateArguments.argument(r.PostForm, "source")
+	if err != nil {
+		return
+	}
+	target, err := receiver.duplicateArguments.argument(r.PostForm, "target")
+	if err != nil {
+		return
+	}
+	receiver.recordRejection(Rejection{
+		Source: source,
+		Target: target,
+		Code:   http.StatusBadRequest,
+		Reason: badRequest.Message,
+	})
+}
+
+// reject marks a mention as rejected during processing.
+func (receiver *Receiver) reject(mention Mention, reason string) {
+	receiver.setStateReason(mention, StateRejected, sanitizeMessage(reason))
+	receiver.recordRejection(Rejection{
+		Source: mention.Source.String(),
+		Target: mention.Target.String(),
+		ID:     mention.ID,
+		Reason: reason,
+	})
+}
@@ -0,0 +1,88 @@
+package webmention
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RelayedByParam is the extension parameter a Relay marks forwarded mentions with.
+const RelayedByParam = "relayed-by"
+
+// Relay forwards processed mentions to another webmention endpoint, e.g.,
+// to webmention.io for redundancy during a migration.
+// Source and target are forwarded as-is, the other endpoint verifies the
+// mention itself. Mentions whose source no longer links to the target, or
+// got deleted, are forwarded too, so that the other endpoint learns about it.
+//
+// Forwarded mentions carry the extension parameter relayed-by, mentions that
+// were received with it are never forwarded again, so that two receivers
+// relaying to each other don't loop.
+type Relay struct {
+	Endpoint   URL
+	UserAgent  string
+	HttpClient *http.Client
+}
+
+var (
+	// *Relay implements FallibleNotifier
+	_ FallibleNotifier = (*Relay)(nil)
+	// *Relay implements NamedNotifier
+	_ NamedNotifier = (*Relay)(nil)
+)
+
+func NewRelay(endpoint URL) *Relay {
+	return &Relay{
+		Endpoint:   endpoint,
+		UserAgent:  defaultUserAgent(),
+		HttpClient: http.DefaultClient,
+	}
+}
+
+// WithRelay forwards all processed mentions to endpoint (see Relay).
+func WithRelay(endpoint URL) ReceiverOption {
+	return WithNotifier(NewRelay(endpoint))
+}
+
+// Name identifies the relay in a NotificationLog.
+func (relay *Relay) Name() string {
+	return "relay:" + relay.Endpoint.String()
+}
+
+func (relay *Relay) Receive(mention Mention) {
+	if err := relay.Notify(mention); err != nil {
+		slog.Error(err.Error(), "mention", mention)
+	}
+}
+
+// Notify forwards the mention, unless it was relayed to us.
+func (relay *Relay) Notify(mention Mention) error {
+	if mention.Extensions[RelayedByParam] != "" {
+		return nil
+	}
+	form := url.Values{
+		"source":       {mention.Source.String()},
+		"target":       {mention.Target.String()},
+		RelayedByParam: {relay.UserAgent},
+	}
+	req, err := http.NewRequest(http.MethodPost, relay.Endpoint.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("relay: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", relay.UserAgent)
+	setTraceParent(req, mention)
+	resp, err := relay.HttpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("relay: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("relay: %s returned %s", relay.Endpoint, resp.Status)
+	}
+	return nil
+}
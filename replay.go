@@ -0,0 +1,33 @@
+package webmention
+
+import "sync"
+
+// Replay re-dispatches stored mentions matching filter to the currently registered notifiers.
+// This is useful after adding a new notifier, or after fixing a broken one.
+// Unlike during regular processing, the notifiers are waited on, so that
+// Replay only returns once every notifier has received every mention.
+// If a NotificationLog is configured, named notifiers that have already
+// received a mention are skipped.
+// The number of replayed mentions is returned.
+func (receiver *Receiver) Replay(filter MentionFilter) (int, error) {
+	if receiver.storage == nil {
+		return 0, ErrNoStorage
+	}
+	mentions, err := receiver.storage.Mentions(filter)
+	if err != nil {
+		return 0, err
+	}
+	notifiers := receiver.currentNotifiers()
+	for _, mention := range mentions {
+		var wg sync.WaitGroup
+		for _, notifier := range notifiers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				receiver.notify(notifier, mention)
+			}()
+		}
+		wg.Wait()
+	}
+	return len(mentions), nil
+}
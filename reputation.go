@@ -0,0 +1,316 @@
+package webmention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+type (
+	// ReputationChecker rejects mentions from sources with a bad reputation.
+	// A source's reputation is determined by consulting DNS blocklists, and a
+	// local ReputationStore, which learns from previously rejected mentions.
+	//
+	// Register it with WithReputation, so that it is both consulted as a
+	// Filter, and informed about the outcome of every mention.
+	ReputationChecker struct {
+		// DomainBlocklists are DNSBL zones that list domain names (e.g., dbl.spamhaus.org).
+		DomainBlocklists []string
+		// IPBlocklists are DNSBL zones that list IP addresses (e.g., zen.spamhaus.org).
+		IPBlocklists []string
+		// A domain is rejected once it has been rejected MaxRejections times
+		// more often than it has been accepted.
+		// Zero disables the local reputation check.
+		MaxRejections int
+		// RejectionHalfLife is the time after which past rejections only
+		// count half, so that a domain can recover from a bad reputation.
+		// Zero means rejections never decay.
+		RejectionHalfLife time.Duration
+		Store             ReputationStore
+		Resolver          *net.Resolver
+		// Timeout for all DNS lookups of a single check.
+		Timeout time.Duration
+	}
+
+	// A ReputationStore counts accepted and rejected mentions per (registrable) domain.
+	ReputationStore interface {
+		Reputation(domain string) (Reputation, error)
+		RecordAccepted(domain string) error
+		RecordRejected(domain string) error
+	}
+
+	Reputation struct {
+		Domain             string
+		Accepted, Rejected int
+		LastRejected       time.Time
+	}
+
+	// MemoryReputationStore is a ReputationStore that is kept in memory.
+	MemoryReputationStore struct {
+		m       sync.Mutex
+		domains map[string]*Reputation
+	}
+)
+
+// *ReputationChecker implements Filter and Notifier
+var (
+	_ Filter   = (*ReputationChecker)(nil)
+	_ Notifier = (*ReputationChecker)(nil)
+)
+
+// *MemoryReputationStore implements ReputationStore
+var _ ReputationStore = (*MemoryReputationStore)(nil)
+
+var (
+	dnsblListing = netip.MustParsePrefix("127.0.0.0/8")
+	dnsblError   = netip.MustParsePrefix("127.255.255.0/24")
+)
+
+// WithReputation registers the checker as a filter, and lets it learn from
+// the outcome of processed mentions (mentions held for moderation count as
+// rejected, valid mentions as accepted).
+// Errors of the store are handled by the receiver's reporter.
+func WithReputation(checker *ReputationChecker) ReceiverOption {
+	return func(r *Receiver) {
+		bound := boundReputation{checker, r}
+		r.filters = append(r.filters, bound)
+		r.notifiers = append(r.notifiers, bound)
+		r.moderators = append(r.moderators, NotifierFunc(func(mention Mention) {
+			checker.recordRejected(mention, r.report)
+		}))
+	}
+}
+
+// boundReputation is a checker registered with a receiver, so that it
+// reports to the receiver's reporter, not the package-level Report.
+type boundReputation struct {
+	*ReputationChecker
+	receiver *Receiver
+}
+
+func (b boundReputation) Filter(mention Mention) error {
+	return b.filter(mention, b.receiver.report)
+}
+
+func (b boundReputation) Receive(mention Mention) {
+	b.receive(mention, b.receiver.report)
+}
+
+func NewReputationChecker(store ReputationStore, maxRejections int) *ReputationChecker {
+	return &ReputationChecker{
+		Store:             store,
+		MaxRejections:     maxRejections,
+		RejectionHalfLife: 30 * 24 * time.Hour,
+		Resolver:          net.DefaultResolver,
+		Timeout:           5 * time.Second,
+	}
+}
+
+func NewMemoryReputationStore() *MemoryReputationStore {
+	return &MemoryReputationStore{domains: map[string]*Reputation{}}
+}
+
+// Filter rejects the mention if its source is blocklisted, or has a bad local reputation.
+// Mentions signed by a trusted key are never rejected.
+// Failing DNS lookups are no signal, the mention is not rejected because of them.
+func (c *ReputationChecker) Filter(mention Mention) error {
+	return c.filter(mention, Report)
+}
+
+func (c *ReputationChecker) filter(mention Mention, report func(err error, mention Mention)) error {
+	if mention.SignedBy != "" {
+		return nil
+	}
+	host := mention.Source.Hostname()
+	domain := registrableDomain(host)
+	if c.Store != nil && c.MaxRejections > 0 {
+		rep, err := c.Store.Reputation(domain)
+		if err != nil {
+			return fmt.Errorf("reputation: %w", err)
+		}
+		if rejected := rep.decayedRejections(c.RejectionHalfLife); rejected-float64(rep.Accepted) >= float64(c.MaxRejections) {
+			return Reject("source domain %s has a bad reputation (%d rejected, %d accepted)", domain, rep.Rejected, rep.Accepted)
+		}
+	}
+
+	if IsOnion(mention.Source) {
+		return nil // onion addresses must not be looked up in the DNS
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	for _, zone := range c.DomainBlocklists {
+		if c.listed(ctx, domain+"."+zone) {
+			c.recordRejected(mention, report)
+			return Reject("source domain %s is listed in %s", domain, zone)
+		}
+	}
+	if len(c.IPBlocklists) > 0 {
+		// IPv6-only hosts, or hosts that don't resolve, can't be checked
+		ips, err := c.resolver().LookupIP(ctx, "ip4", host)
+		if err != nil {
+			slog.Info(fmt.Sprintf("reputation: %s", err), "source", mention.Source)
+		}
+		for _, ip := range ips {
+			for _, zone := range c.IPBlocklists {
+				if c.listed(ctx, reverseIP(ip)+"."+zone) {
+					c.recordRejected(mention, report)
+					return Reject("source address %s is listed in %s", ip, zone)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Name identifies the checker in a NotificationLog, so that it doesn't learn
+// from the same mention twice.
+func (c *ReputationChecker) Name() string {
+	return "reputation"
+}
+
+// Receive learns from the outcome of a processed mention.
+func (c *ReputationChecker) Receive(mention Mention) {
+	c.receive(mention, Report)
+}
+
+func (c *ReputationChecker) receive(mention Mention, report func(err error, mention Mention)) {
+	switch mention.Status {
+	case StatusLink:
+		if c.Store != nil {
+			if err := c.Store.RecordAccepted(registrableDomain(mention.Source.Hostname())); err != nil {
+				report(fmt.Errorf("reputation: %w", err), mention)
+			}
+		}
+	}
+}
+
+func (c *ReputationChecker) recordRejected(mention Mention, report func(err error, mention Mention)) {
+	if c.Store == nil {
+		return
+	}
+	if err := c.Store.RecordRejected(registrableDomain(mention.Source.Hostname())); err != nil {
+		report(fmt.Errorf("reputation: %w", err), mention)
+	}
+}
+
+func (c *ReputationChecker) resolver() *net.Resolver {
+	if c.Resolver == nil {
+		return net.DefaultResolver
+	}
+	return c.Resolver
+}
+
+// listed reports whether name resolves to a listing answer.
+// DNSBLs signal a listing with an address in 127.0.0.0/8, answers in
+// 127.255.255.0/24 are errors (e.g., the query was refused), and other
+// answers come from resolvers that make up records for unknown names.
+// A failed lookup counts as not listed.
+func (c *ReputationChecker) listed(ctx context.Context, name string) bool {
+	addrs, err := c.resolver().LookupHost(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			slog.Info(fmt.Sprintf("reputation: %s", err), "name", name)
+		}
+		return false
+	}
+	for _, addr := range addrs {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			continue
+		}
+		if dnsblListing.Contains(ip) && !dnsblError.Contains(ip) {
+			return true
+		}
+	}
+	if len(addrs) > 0 {
+		slog.Info("reputation: ignoring unexpected blocklist answer", "name", name, "answer", addrs)
+	}
+	return false
+}
+
+// decayedRejections returns the number of rejections, halved every halfLife
+// since the last rejection.
+func (rep Reputation) decayedRejections(halfLife time.Duration) float64 {
+	if halfLife <= 0 || rep.LastRejected.IsZero() {
+		return float64(rep.Rejected)
+	}
+	return float64(rep.Rejected) * math.Exp2(-float64(time.Since(rep.LastRejected))/float64(halfLife))
+}
+
+// reverseIP returns the octets of an IPv4 address in reverse order, as used by DNSBLs.
+func reverseIP(ip net.IP) string {
+	ip = ip.To4()
+	return fmt.Sprintf("%d.%d.%d.%d", ip[3], ip[2], ip[1], ip[0])
+}
+
+// registrableDomain returns the eTLD+1 of host, or host itself if there is none.
+func registrableDomain(host string) string {
+	host = strings.ToLower(host)
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return host
+	}
+	return domain
+}
+
+func (s *MemoryReputationStore) get(domain string) *Reputation {
+	rep, ok := s.domains[domain]
+	if !ok {
+		rep = &Reputation{Domain: domain}
+		s.domains[domain] = rep
+	}
+	return rep
+}
+
+func (s *MemoryReputationStore) Reputation(domain string) (Reputation, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if rep, ok := s.domains[domain]; ok {
+		return *rep, nil
+	}
+	return Reputation{Domain: domain}, nil
+}
+
+func (s *MemoryReputationStore) RecordAccepted(domain string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.get(domain).Accepted++
+	return nil
+}
+
+func (s *MemoryReputationStore) RecordRejected(domain string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	rep := s.get(domain)
+	rep.Rejected++
+	rep.LastRejected = time.Now()
+	return nil
+}
+
+// Snapshot returns the reputation of all known domains, worst first.
+func (s *MemoryReputationStore) Snapshot() []Reputation {
+	s.m.Lock()
+	defer s.m.Unlock()
+	reps := make([]Reputation, 0, len(s.domains))
+	for _, rep := range s.domains {
+		reps = append(reps, *rep)
+	}
+	slices.SortFunc(reps, func(a, b Reputation) int {
+		if c := (b.Rejected - b.Accepted) - (a.Rejected - a.Accepted); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	return reps
+}
@@ -0,0 +1,143 @@
+package webmention_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+// fixedReputationStore always reports the same reputation.
+type fixedReputationStore struct {
+	webmention.MemoryReputationStore
+	reputation webmention.Reputation
+}
+
+func (s *fixedReputationStore) Reputation(domain string) (webmention.Reputation, error) {
+	return s.reputation, nil
+}
+
+// failingReputationStore can't record anything.
+type failingReputationStore struct {
+	webmention.MemoryReputationStore
+}
+
+var errStoreDown = errors.New("store down")
+
+func (s *failingReputationStore) RecordAccepted(domain string) error {
+	return errStoreDown
+}
+
+func (s *failingReputationStore) RecordRejected(domain string) error {
+	return errStoreDown
+}
+
+func TestReputationReportsToReceiver(t *testing.T) {
+	var global atomic.Int32
+	defer func(report func(error, webmention.Mention)) { webmention.Report = report }(webmention.Report)
+	webmention.Report = func(err error, mention webmention.Mention) {
+		if errors.Is(err, errStoreDown) {
+			global.Add(1)
+		}
+	}
+
+	reported := make(chan error, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(func(source, target *url.URL) bool { return true }),
+		webmention.WithReputation(webmention.NewReputationChecker(&failingReputationStore{}, 1)),
+		webmention.WithReporter(func(err error, mention webmention.Mention) {
+			if errors.Is(err, errStoreDown) {
+				reported <- err
+			}
+		}),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+
+	var ts *httptest.Server
+	mux := http.NewServeMux()
+	mux.Handle("/webmention", receiver)
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="` + ts.URL + `/target">target</a>`))
+	})
+	ts = httptest.NewServer(mux)
+	defer ts.Close()
+	resp, err := http.PostForm(ts.URL+"/webmention", url.Values{
+		"source": {ts.URL + "/source"},
+		"target": {ts.URL + "/target"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case err := <-reported:
+		t.Logf("reported: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("store error not reported to the receiver's reporter")
+	}
+	if n := global.Load(); n > 0 {
+		t.Errorf("store error reported to the package-level Report %d times", n)
+	}
+}
+
+func TestReputationDecay(t *testing.T) {
+	mention := webmention.Mention{
+		Source: must(url.Parse("https://spam.example/post")),
+		Target: must(url.Parse("https://example.org/post")),
+	}
+	for _, tc := range []struct {
+		name         string
+		lastRejected time.Duration
+		rejected     bool
+	}{
+		{name: "recent rejections", lastRejected: time.Hour, rejected: true},
+		{name: "old rejections", lastRejected: 90 * 24 * time.Hour, rejected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fixedReputationStore{reputation: webmention.Reputation{
+				Domain:       "spam.example",
+				Rejected:     4,
+				LastRejected: time.Now().Add(-tc.lastRejected),
+			}}
+			checker := webmention.NewReputationChecker(store, 3)
+			err := checker.Filter(mention)
+			if rejected := errors.Is(err, webmention.ErrRejected); rejected != tc.rejected {
+				t.Errorf("rejected: %t, want: %t (%v)", rejected, tc.rejected, err)
+			}
+		})
+	}
+}
+
+func TestReputationLearning(t *testing.T) {
+	store := webmention.NewMemoryReputationStore()
+	checker := webmention.NewReputationChecker(store, 1)
+	mention := func(status webmention.Status) webmention.Mention {
+		return webmention.Mention{
+			Source: must(url.Parse("https://blog.example/post")),
+			Target: must(url.Parse("https://example.org/post")),
+			Status: status,
+		}
+	}
+	// a source that no longer links (e.g., an edited post) is not spam
+	checker.Receive(mention(webmention.StatusNoLink))
+	checker.Receive(mention(webmention.StatusDeleted))
+	if rep := must(store.Reputation("blog.example")); rep.Rejected != 0 {
+		t.Errorf("mention without link counted as rejected: %+v", rep)
+	}
+	checker.Receive(mention(webmention.StatusLink))
+	if rep := must(store.Reputation("blog.example")); rep.Accepted != 1 {
+		t.Errorf("valid mention not counted as accepted: %+v", rep)
+	}
+	if err := checker.Filter(mention(webmention.StatusNoLink)); err != nil {
+		t.Errorf("mention rejected: %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package webmention
+
+import (
+	"errors"
+	"fmt"
+)
+
+type (
+	// A TargetResolver maps a target url to an identifier internal to your
+	// application, e.g., the slug or database id of a blog post.
+	// The identifier is stored with the mention (see Mention.TargetID), so
+	// that integrations don't have to re-parse the url later on.
+	// If the target does not correspond to any known content, Resolve must
+	// return ErrUnknownTarget (or an error wrapping it), in which case the
+	// mention is rejected with http.StatusBadRequest.
+	// If the target is archived, or otherwise closed for comments, Resolve
+	// should return its id together with ErrTargetClosed (see WithClosedTargets).
+	// Any other error is treated as an internal error.
+	TargetResolver interface {
+		Resolve(target URL) (id string, err error)
+	}
+
+	// TargetResolverFunc adapts a function to an object that implements the TargetResolver interface.
+	TargetResolverFunc func(target URL) (id string, err error)
+
+	// ClosedFunc reports whether target (with the id it was resolved to, if
+	// a TargetResolver is configured) is closed for new mentions.
+	ClosedFunc func(target URL, targetID string) (bool, error)
+
+	// TargetState describes a target page of the application itself, as
+	// reported by a TargetCheckFunc.
+	TargetState struct {
+		// Exists is false if there is no page at the target url.
+		Exists bool
+		// Draft is true if the page exists, but is not published (yet).
+		Draft bool
+		// MovedTo is set if the page was moved, mentions should use this url instead.
+		MovedTo URL
+	}
+
+	// TargetCheckFunc looks up the state of a target served by the application.
+	// It is called synchronously for every request, so it should be fast,
+	// e.g., a lookup in a map or database, not an http request.
+	TargetCheckFunc func(target URL) (TargetState, error)
+
+	// A TargetMigration maps the (old) target id of a mention to a new one.
+	// Return the old id unchanged if the mention is unaffected.
+	TargetMigration func(mention Mention) (newID string, err error)
+)
+
+func (f TargetResolverFunc) Resolve(target URL) (string, error) {
+	return f(target)
+}
+
+// WithTargetResolver configures a resolver used to map targets to internal ids.
+// Mentions whose target cannot be resolved are rejected.
+func WithTargetResolver(resolver TargetResolver) ReceiverOption {
+	return func(r *Receiver) {
+		r.targetResolver = resolver
+	}
+}
+
+// WithClosedTargets rejects new mentions of targets for which closed returns true.
+// Mentions that were already received (requires a Storage) can still be
+// updated, or deleted, and remain stored.
+// Targets for which the TargetResolver returns ErrTargetClosed are treated the same.
+func WithClosedTargets(closed ClosedFunc) ReceiverOption {
+	return func(r *Receiver) {
+		r.closedTargets = closed
+	}
+}
+
+// WithTargetCheck checks every target with check before the mention is queued,
+// so that senders are told right away why their mention was rejected, e.g.,
+// because the target is a draft, or has moved, instead of the mention being
+// silently dropped after processing.
+func WithTargetCheck(check TargetCheckFunc) ReceiverOption {
+	return func(r *Receiver) {
+		r.targetCheck = check
+	}
+}
+
+// checkTarget rejects mentions of targets that don't exist, aren't published,
+// or have moved, if a TargetCheckFunc is configured.
+func (receiver *Receiver) checkTarget(target URL) error {
+	if receiver.targetCheck == nil {
+		return nil
+	}
+	state, err := receiver.targetCheck(target)
+	if err != nil {
+		return fmt.Errorf("check target: %w", err)
+	}
+	switch {
+	case !state.Exists:
+		return BadRequest("target does not exist")
+	case state.Draft:
+		return BadRequest("target is not published")
+	case state.MovedTo != nil:
+		return BadRequest(fmt.Sprintf("target has moved to %s, mention that url instead", state.MovedTo))
+	}
+	return nil
+}
+
+// resolveTarget maps target to its id, and rejects mentions of unknown or
+// closed targets, unless the mention is an update of an already stored one.
+func (receiver *Receiver) resolveTarget(source, target URL) (id string, err error) {
+	closed := false
+	if receiver.targetResolver != nil {
+		id, err = receiver.targetResolver.Resolve(target)
+		switch {
+		case errors.Is(err, ErrUnknownTarget):
+			return "", BadRequest("target does not exist")
+		case errors.Is(err, ErrTargetClosed):
+			closed = true
+		case err != nil:
+			return "", fmt.Errorf("resolve target: %w", err)
+		}
+	}
+	if !closed && receiver.closedTargets != nil {
+		closed, err = receiver.closedTargets(target, id)
+		if err != nil {
+			return "", fmt.Errorf("closed targets: %w", err)
+		}
+	}
+	if !closed {
+		return id, nil
+	}
+	if receiver.storage != nil {
+		stored, err := receiver.storage.Mentions(MentionFilter{Target: target.String()})
+		if err != nil {
+			return "", fmt.Errorf("closed targets: %w", err)
+		}
+		for _, mention := range stored {
+			if mention.Source.String() == source.String() {
+				return id, nil // updates to existing mentions are still accepted
+			}
+		}
+	}
+	return "", BadRequest("target is closed for new mentions")
+}
+
+// MigrateTargetIDs applies migrate to every mention, updating its TargetID in place.
+// Use this after renaming posts, to remap already received mentions onto the new ids.
+// Migration stops at the first error.
+func MigrateTargetIDs(mentions []Mention, migrate TargetMigration) error {
+	for i := range mentions {
+		id, err := migrate(mentions[i])
+		if err != nil {
+			return fmt.Errorf("migrate target id: %s: %w", mentions[i].Target, err)
+		}
+		mentions[i].TargetID = id
+	}
+	return nil
+}
+
+// ResolveAgain returns a TargetMigration that re-resolves the target url of
+// each mention using resolver.
+func ResolveAgain(resolver TargetResolver) TargetMigration {
+	return func(mention Mention) (string, error) {
+		return resolver.Resolve(mention.Target)
+	}
+}
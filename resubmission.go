@@ -0,0 +1,97 @@
+package webmention
+
+import "fmt"
+
+type (
+	// MentionChange is how a processed mention relates to the mention of the same
+	// source and target that was stored before (see Mention.Change).
+	// Per the specification, a mention sent again means the source was
+	// updated, and is verified again.
+	MentionChange string
+
+	// A LookupStorage looks up the stored mention of a source and target,
+	// without reading all mentions of the target.
+	LookupStorage interface {
+		Storage
+		// Mention returns the stored mention of target by source, ok is
+		// false if there is none.
+		Mention(source, target string) (mention Mention, ok bool, err error)
+	}
+)
+
+const (
+	// MentionNew is a source linking to the target for the first time, or
+	// again after it had removed the link.
+	MentionNew MentionChange = "new"
+	// MentionUpdated is a source that still links to the target, it was
+	// sent again because the source was updated.
+	MentionUpdated MentionChange = "updated"
+	// MentionRemoved is a source that linked to the target, but no longer
+	// does, or got deleted.
+	MentionRemoved MentionChange = "removed"
+	// MentionUnchanged is a source that didn't link to the target, and still doesn't.
+	MentionUnchanged MentionChange = "unchanged"
+)
+
+var (
+	// *JSONFileStorage implements LookupStorage
+	_ LookupStorage = (*JSONFileStorage)(nil)
+	// *MemoryStorage implements LookupStorage
+	_ LookupStorage = (*MemoryStorage)(nil)
+)
+
+// changeOf compares the current version of a mention with the previously
+// stored one, found is false if there is none.
+func changeOf(previous Mention, found bool, current Mention) MentionChange {
+	linked := found && previous.Status == StatusLink
+	switch {
+	case current.Status == StatusLink && linked:
+		return MentionUpdated
+	case current.Status == StatusLink:
+		return MentionNew
+	case linked:
+		return MentionRemoved
+	}
+	return MentionUnchanged
+}
+
+// storedMention returns the stored version of mention, looking it up among
+// the mentions of its target if the storage isn't a LookupStorage.
+func (receiver *Receiver) storedMention(mention Mention) (stored Mention, ok bool, err error) {
+	source, target := mention.Source.String(), mention.Target.String()
+	if lookup, isLookup := receiver.storage.(LookupStorage); isLookup {
+		return lookup.Mention(source, target)
+	}
+	mentions, err := receiver.storage.Mentions(MentionFilter{Target: target})
+	if err != nil {
+		return stored, false, err
+	}
+	for _, m := range mentions {
+		if m.Source.String() == source {
+			stored, ok = m, true
+		}
+	}
+	return stored, ok, nil
+}
+
+// store sets the Change of mention, and replaces the stored version with it.
+// Comparing and storing is atomic (within this receiver), so that mentions
+// sent again in quick succession are each compared to the one before.
+// The named notifiers in outbox are stored with it, see OutboxStorage.
+func (receiver *Receiver) store(mention *Mention, outbox []string) error {
+	receiver.storeMu.Lock()
+	defer receiver.storeMu.Unlock()
+	previous, found, err := receiver.storedMention(*mention)
+	if err != nil {
+		return fmt.Errorf("look up stored mention: %w", err)
+	}
+	mention.Change = changeOf(previous, found, *mention)
+	mention.PreviousContent = ""
+	if mention.Change == MentionUpdated && previous.Content != mention.Content {
+		mention.PreviousContent = previous.Content
+	}
+	if len(outbox) > 0 {
+		return receiver.storage.(OutboxStorage).StoreWithOutbox(*mention, outbox)
+	}
+	return receiver.storage.Store(*mention)
+}
@@ -0,0 +1,109 @@
+package webmention
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type (
+	// Change classifies a target of an update, by comparing the past and
+	// current targets of the source.
+	Change string
+
+	// DeliveryResult is the outcome of an update for a single target.
+	DeliveryResult struct {
+		// Target is the url as passed to the update, Canonical is the url it
+		// was mentioned as (nil if discovery failed).
+		Target, Canonical URL
+		// Endpoint is the webmention endpoint of the target (nil if discovery failed).
+		Endpoint URL
+		Change   Change
+		// Sent reports whether the mention was posted to the endpoint, or
+		// would have been, for a preview.
+		// Kept targets are skipped if the content didn't change (see WithUpdateDetection).
+		Sent bool
+		// Deferred reports whether the mention was put in the outbox, to be
+		// posted in the next send window (see WithSendWindows).
+		Deferred bool
+		// Err is why the target could not be mentioned.
+		Err error
+		// DryRun is set if the result is from a preview (see Sender.Preview),
+		// nothing has actually been sent.
+		DryRun bool
+	}
+
+	// DeliveryResults are the outcomes of an update, one per (canonical) target.
+	DeliveryResults []DeliveryResult
+)
+
+const (
+	ChangeAdded   Change = "added"   // linked to by the current version only
+	ChangeRemoved Change = "removed" // linked to by the past version only
+	ChangeKept    Change = "kept"    // linked to by both versions
+)
+
+// Sent returns how many mentions of targets with the given change have been sent.
+func (results DeliveryResults) Sent(change Change) (n int) {
+	for _, result := range results {
+		if result.Change == change && result.Sent {
+			n++
+		}
+	}
+	return n
+}
+
+// Deferred returns how many mentions have been deferred to the next send window.
+func (results DeliveryResults) Deferred() (n int) {
+	for _, result := range results {
+		if result.Deferred {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the results that could not be delivered.
+func (results DeliveryResults) Failed() (failed DeliveryResults) {
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// String summarizes the results, e.g., "3 new mentions sent, 1 removal notified",
+// or for a preview, "3 new mentions to send, 1 removal to notify".
+func (results DeliveryResults) String() string {
+	sent, notified, nothing := "sent", "notified", "nothing sent"
+	if slices.ContainsFunc(results, func(result DeliveryResult) bool { return result.DryRun }) {
+		sent, notified, nothing = "to send", "to notify", "nothing to send"
+	}
+	var parts []string
+	plural := func(n int, singular, plural string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, singular)
+		}
+		return fmt.Sprintf("%d %s", n, plural)
+	}
+	if n := results.Sent(ChangeAdded); n > 0 {
+		parts = append(parts, plural(n, "new mention "+sent, "new mentions "+sent))
+	}
+	if n := results.Sent(ChangeKept); n > 0 {
+		parts = append(parts, plural(n, "update "+sent, "updates "+sent))
+	}
+	if n := results.Sent(ChangeRemoved); n > 0 {
+		parts = append(parts, plural(n, "removal "+notified, "removals "+notified))
+	}
+	if n := results.Deferred(); n > 0 {
+		parts = append(parts, plural(n, "deferred", "deferred"))
+	}
+	if n := len(results.Failed()); n > 0 {
+		parts = append(parts, plural(n, "failed", "failed"))
+	}
+	if len(parts) == 0 {
+		return nothing
+	}
+	return strings.Join(parts, ", ")
+}
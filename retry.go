@@ -0,0 +1,73 @@
+package webmention
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultServerRetries and defaultRetryBackoff are used unless
+	// configured with WithServerErrorRetries.
+	defaultServerRetries = 2
+	defaultRetryBackoff  = time.Second
+	// maxRetryAfter is the longest Retry-After that is waited for, longer
+	// ones are left to the outbox (see DeliveryBudget.MaxDailyRetries).
+	maxRetryAfter = time.Minute
+)
+
+// WithServerErrorRetries retries posting a mention up to retries times, if
+// the endpoint answers with a server error (500 to 504), waiting backoff
+// before the first retry, and twice as long before every further one, or
+// as long as the endpoint asks for with a Retry-After header (up to a minute).
+// Posting a mention again is safe, since receivers identify a mention by
+// its source and target: a mention that arrives twice is verified twice,
+// but not stored twice.
+// The delivery timeout covers all attempts (see WithDeliveryTimeout).
+// By default, a mention is retried twice, with a backoff of one second,
+// retries <= 0 disables retries.
+func WithServerErrorRetries(retries int, backoff time.Duration) SenderOption {
+	return func(s *Sender) {
+		s.serverRetries = max(retries, 0)
+		s.retryBackoff = backoff
+	}
+}
+
+// retryableStatus reports whether a mention answered with code is worth
+// posting again right away.
+func retryableStatus(code int) bool {
+	return code >= http.StatusInternalServerError && code <= http.StatusGatewayTimeout
+}
+
+// retryDelay is how long to wait before attempt (the first retry is attempt
+// 2), false if the endpoint asked to wait longer than maxRetryAfter.
+func (sender *Sender) retryDelay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		var delay time.Duration
+		if seconds, err := strconv.Atoi(after); err == nil {
+			delay = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(after); err == nil {
+			delay = time.Until(date)
+		}
+		if delay > maxRetryAfter {
+			return 0, false
+		}
+		if delay > 0 {
+			return delay, true
+		}
+	}
+	return sender.retryBackoff << (attempt - 2), true
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
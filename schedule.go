@@ -0,0 +1,359 @@
+package webmention
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// SendWindow is a daily period of time (in local time) during which
+	// mentions are posted, see WithSendWindows.
+	// From and To are offsets since midnight, a window with To before From
+	// spans midnight, e.g., 22:00-06:00.
+	SendWindow struct {
+		From, To time.Duration
+	}
+
+	// An Outbox holds the mentions deferred until the next send window.
+	// Deliveries stay in the outbox while they are being posted, and are
+	// only removed once they are done, so that none are lost if the
+	// process dies in between, they are posted again instead.
+	Outbox interface {
+		// Defer adds a delivery, replacing any pending delivery from the
+		// same source to the same target.
+		Defer(delivery PendingDelivery) error
+
+		// Pending returns all pending deliveries, oldest first.
+		Pending() ([]PendingDelivery, error)
+
+		// Done removes a delivery returned by Pending, unless it has been
+		// replaced by Defer in the meantime.
+		Done(delivery PendingDelivery) error
+	}
+
+	// PendingDelivery is a mention waiting in an Outbox.
+	PendingDelivery struct {
+		Source   string    `json:"source"`
+		Target   string    `json:"target"`
+		Endpoint string    `json:"endpoint"`
+		Deferred time.Time `json:"deferred"`
+		// Attempts is the number of times posting the mention failed.
+		Attempts int `json:"attempts,omitempty"`
+		// NextAttempt is when a failed mention is retried (zero: with the
+		// next send window), see RetryBackoff.
+		NextAttempt time.Time `json:"next_attempt"`
+	}
+
+	// MemoryOutbox is an Outbox that is kept in memory.
+	MemoryOutbox struct {
+		m       sync.Mutex
+		pending []PendingDelivery
+	}
+
+	// JSONFileOutbox is an Outbox backed by a JSON file, so that pending
+	// deliveries survive restarts.
+	// The file is read and written on every access, so that the daemon
+	// picks up the deliveries deferred by a one-off run of the same binary.
+	JSONFileOutbox struct {
+		m    sync.Mutex
+		path string
+	}
+)
+
+var (
+	// *MemoryOutbox implements Outbox
+	_ Outbox = (*MemoryOutbox)(nil)
+	// *JSONFileOutbox implements Outbox
+	_ Outbox = (*JSONFileOutbox)(nil)
+)
+
+// errDeferred is returned by send, if the mention was put in the outbox.
+var errDeferred = errors.New("delivery deferred to the next send window")
+
+// WithSendWindows defers posting mentions outside of the windows: they are
+// kept in outbox (in memory, if nil), and posted once a window opens, by
+// ProcessOutbox, e.g., to send at night on a metered connection, or to not
+// hit small hosts with bursts of mentions.
+// Discovering endpoints still happens right away.
+// Without any windows, the outbox only holds the mentions to be retried
+// (see DeliveryBudget).
+func WithSendWindows(outbox Outbox, windows ...SendWindow) SenderOption {
+	return func(s *Sender) {
+		if outbox == nil {
+			outbox = &MemoryOutbox{}
+		}
+		s.outbox = outbox
+		s.sendWindows = windows
+	}
+}
+
+// ParseSendWindows parses comma separated windows, e.g., 22:00-06:00,12:00-13:30.
+func ParseSendWindows(spec string) (windows []SendWindow, err error) {
+	for _, part := range strings.Split(spec, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("send window: expected FROM-TO, got: %s", part)
+		}
+		var window SendWindow
+		if window.From, err = parseClock(from); err != nil {
+			return nil, fmt.Errorf("send window: %s: %w", part, err)
+		}
+		if window.To, err = parseClock(to); err != nil {
+			return nil, fmt.Errorf("send window: %s: %w", part, err)
+		}
+		if window.From == window.To {
+			return nil, fmt.Errorf("send window: %s: empty window", part)
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// parseClock parses a time of day, 15:04, as offset since midnight.
+func parseClock(clock string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, errors.New("expected a time of day, e.g., 22:00")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t is inside the window.
+func (w SendWindow) Contains(t time.Time) bool {
+	offset := t.Sub(midnight(t))
+	if w.From < w.To {
+		return w.From <= offset && offset < w.To
+	}
+	return offset >= w.From || offset < w.To
+}
+
+func (w SendWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(w.From) + "-" + clock(w.To)
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// nextWindow returns when the next window opens, t itself if one is open.
+func nextWindow(windows []SendWindow, t time.Time) time.Time {
+	var next time.Time
+	for _, w := range windows {
+		if w.Contains(t) {
+			return t
+		}
+		start := midnight(t).Add(w.From)
+		if !start.After(t) {
+			start = midnight(t.AddDate(0, 0, 1)).Add(w.From)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// inSendWindow reports whether mentions may be posted now.
+func (sender *Sender) inSendWindow(now time.Time) bool {
+	return len(sender.sendWindows) == 0 || !nextWindow(sender.sendWindows, now).After(now)
+}
+
+// deferDelivery puts the mention in the outbox.
+func (sender *Sender) deferDelivery(source, target, endpoint URL) error {
+	err := sender.outbox.Defer(PendingDelivery{
+		Source:   source.String(),
+		Target:   target.String(),
+		Endpoint: endpoint.String(),
+		Deferred: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("mention: outbox: %w", err)
+	}
+	slog.Info("mention deferred to the next send window", "source", source.String(), "target", target.String())
+	return errDeferred
+}
+
+// ProcessOutbox posts the deferred mentions whenever a send window opens,
+// one after the other, until ctx is cancelled.
+// Mentions that are still pending when the window closes wait for the next one.
+// Failed deliveries are retried, as long as the retry budget of their
+// domain allows (see WithDeliveryBudget), otherwise they are logged and dropped.
+// A delivery is removed from the outbox only once it has been posted,
+// re-deferred to be retried, or dropped. Retries wait in the outbox until
+// their NextAttempt.
+func (sender *Sender) ProcessOutbox(ctx context.Context) {
+	if sender.outbox == nil {
+		return
+	}
+	for {
+		now := time.Now()
+		if wait := nextWindow(sender.sendWindows, now).Sub(now); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		pending, err := sender.outbox.Pending()
+		if err != nil {
+			slog.Error(fmt.Sprintf("outbox: %s", err))
+		}
+		for _, delivery := range pending {
+			if ctx.Err() != nil || !sender.inSendWindow(time.Now()) {
+				break
+			}
+			if delivery.NextAttempt.After(time.Now()) {
+				continue
+			}
+			if err := sender.sendPending(delivery); err != nil && !sender.retryLater(delivery, err) {
+				slog.Error(err.Error(), "source", delivery.Source, "target", delivery.Target)
+			}
+			// removes the delivery, but not a retry that replaced it
+			if err := sender.outbox.Done(delivery); err != nil {
+				slog.Error(fmt.Sprintf("outbox: %s", err), "source", delivery.Source, "target", delivery.Target)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Minute): // pick up mentions deferred in the meantime
+		}
+	}
+}
+
+// sendPending posts a deferred mention, bypassing the retry logic of send.
+func (sender *Sender) sendPending(delivery PendingDelivery) error {
+	var urls [3]URL
+	for i, raw := range []string{delivery.Source, delivery.Target, delivery.Endpoint} {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("outbox: %w", err)
+		}
+		urls[i] = u
+	}
+	return sender.post(urls[0], urls[1], urls[2], "")
+}
+
+// addPending replaces the delivery from the same source to the same target, if any.
+func addPending(pending []PendingDelivery, delivery PendingDelivery) []PendingDelivery {
+	pending = slices.DeleteFunc(pending, func(p PendingDelivery) bool {
+		return p.Source == delivery.Source && p.Target == delivery.Target
+	})
+	pending = append(pending, delivery)
+	slices.SortStableFunc(pending, func(a, b PendingDelivery) int {
+		return a.Deferred.Compare(b.Deferred)
+	})
+	return pending
+}
+
+// removePending removes delivery, but not a delivery that replaced it.
+func removePending(pending []PendingDelivery, delivery PendingDelivery) []PendingDelivery {
+	return slices.DeleteFunc(pending, func(p PendingDelivery) bool {
+		return p.Source == delivery.Source && p.Target == delivery.Target &&
+			p.Deferred.Equal(delivery.Deferred) && p.Attempts == delivery.Attempts
+	})
+}
+
+func (o *MemoryOutbox) Defer(delivery PendingDelivery) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.pending = addPending(o.pending, delivery)
+	return nil
+}
+
+func (o *MemoryOutbox) Pending() ([]PendingDelivery, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return slices.Clone(o.pending), nil
+}
+
+func (o *MemoryOutbox) Done(delivery PendingDelivery) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.pending = removePending(o.pending, delivery)
+	return nil
+}
+
+// NewJSONFileOutbox creates an outbox backed by the file at path.
+// The file is created on first write, if it doesn't exist yet.
+func NewJSONFileOutbox(path string) *JSONFileOutbox {
+	return &JSONFileOutbox{path: path}
+}
+
+func (o *JSONFileOutbox) Defer(delivery PendingDelivery) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	pending, err := o.read()
+	if err != nil {
+		return err
+	}
+	return o.write(addPending(pending, delivery))
+}
+
+func (o *JSONFileOutbox) Pending() ([]PendingDelivery, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.read()
+}
+
+func (o *JSONFileOutbox) Done(delivery PendingDelivery) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	pending, err := o.read()
+	if err != nil {
+		return err
+	}
+	return o.write(removePending(pending, delivery))
+}
+
+func (o *JSONFileOutbox) read() (pending []PendingDelivery, err error) {
+	bs, err := os.ReadFile(o.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &pending); err != nil {
+		return nil, fmt.Errorf("%s: %w", o.path, err)
+	}
+	return pending, nil
+}
+
+// write replaces the file atomically.
+func (o *JSONFileOutbox) write(pending []PendingDelivery) error {
+	if pending == nil {
+		pending = []PendingDelivery{}
+	}
+	bs, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(o.path), filepath.Base(o.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), o.path)
+}
@@ -0,0 +1,191 @@
+package webmention
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// SecretSource looks up sensitive configuration, such as passwords and
+	// access tokens, by the name of its environment variable, e.g., MAIL_PASS.
+	// A missing secret is not an error, ok is false instead.
+	SecretSource interface {
+		Secret(name string) (value string, ok bool, err error)
+	}
+
+	// EnvSecrets reads secrets from the environment, either directly, or
+	// from the file named by NAME_FILE (the convention of Docker secrets).
+	EnvSecrets struct{}
+
+	// CredentialSecrets reads secrets from the files in a directory, one
+	// file per secret, named after it.
+	// This is how systemd passes credentials (LoadCredential=MAIL_PASS:/path
+	// or LoadCredentialEncrypted=), see SystemdCredentials.
+	CredentialSecrets struct {
+		Dir string
+	}
+
+	// SecretsFile reads secrets from a file of NAME=VALUE lines (empty lines
+	// and lines starting with # are ignored).
+	// If Identity is set, the file is encrypted with age, and decrypted by
+	// running the age command with the identity (key) file.
+	SecretsFile struct {
+		Path     string
+		Identity string
+		secrets  map[string]string
+	}
+
+	// SecretSources looks up a secret in each of the sources in turn, the
+	// first one that has it wins.
+	SecretSources []SecretSource
+)
+
+var (
+	// EnvSecrets implements SecretSource
+	_ SecretSource = EnvSecrets{}
+	// CredentialSecrets implements SecretSource
+	_ SecretSource = CredentialSecrets{}
+	// *SecretsFile implements SecretSource
+	_ SecretSource = (*SecretsFile)(nil)
+	// SecretSources implements SecretSource
+	_ SecretSource = SecretSources{}
+)
+
+// SystemdCredentials returns the credentials systemd passed to the service,
+// ok is false if it didn't pass any.
+func SystemdCredentials() (source CredentialSecrets, ok bool) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	return CredentialSecrets{Dir: dir}, dir != ""
+}
+
+// DefaultSecretSources are the sources the binaries read secrets from, in
+// this order: the environment (NAME or NAME_FILE), systemd credentials, and
+// the file named by SECRETS_FILE, encrypted with age if SECRETS_IDENTITY
+// names an identity file.
+func DefaultSecretSources() SecretSources {
+	sources := SecretSources{EnvSecrets{}}
+	if credentials, ok := SystemdCredentials(); ok {
+		sources = append(sources, credentials)
+	}
+	if path := os.Getenv("SECRETS_FILE"); path != "" {
+		sources = append(sources, &SecretsFile{Path: path, Identity: os.Getenv("SECRETS_IDENTITY")})
+	}
+	return sources
+}
+
+// LoadSecrets looks up the named secrets in source, the secrets it doesn't
+// have are left out of the returned map.
+// The values are meant to be put straight into the configuration, rather
+// than exported to the environment, where they would be visible in
+// /proc/<pid>/environ, and be inherited by child processes.
+// Trailing newlines of the values are removed.
+func LoadSecrets(source SecretSource, names ...string) (map[string]string, error) {
+	secrets := map[string]string{}
+	for _, name := range names {
+		value, ok, err := source.Secret(name)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		if ok {
+			secrets[name] = strings.TrimRight(value, "\r\n")
+		}
+	}
+	return secrets, nil
+}
+
+func (EnvSecrets) Secret(name string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok && value != "" {
+		return value, true, nil
+	}
+	if file := os.Getenv(name + "_FILE"); file != "" {
+		bs, err := os.ReadFile(file)
+		if err != nil {
+			return "", false, err
+		}
+		return string(bs), true, nil
+	}
+	return "", false, nil
+}
+
+func (s CredentialSecrets) Secret(name string) (string, bool, error) {
+	if s.Dir == "" || strings.ContainsAny(name, `/\`) {
+		return "", false, nil
+	}
+	bs, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(bs), true, nil
+}
+
+// Secret decrypts and parses the file on first use.
+func (s *SecretsFile) Secret(name string) (string, bool, error) {
+	if s.secrets == nil {
+		secrets, err := s.load()
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %w", s.Path, err)
+		}
+		s.secrets = secrets
+	}
+	value, ok := s.secrets[name]
+	return value, ok, nil
+}
+
+func (s *SecretsFile) load() (map[string]string, error) {
+	var r io.Reader
+	if s.Identity == "" {
+		bs, err := os.ReadFile(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(bs)
+	} else {
+		cmd := exec.Command("age", "--decrypt", "--identity", s.Identity, s.Path)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		bs, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("age: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		r = bytes.NewReader(bs)
+	}
+	return parseSecrets(r)
+}
+
+func parseSecrets(r io.Reader) (map[string]string, error) {
+	secrets := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected NAME=VALUE", line)
+		}
+		secrets[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return secrets, scanner.Err()
+}
+
+func (sources SecretSources) Secret(name string) (string, bool, error) {
+	for _, source := range sources {
+		value, ok, err := source.Secret(name)
+		if err != nil || ok {
+			return value, ok, err
+		}
+	}
+	return "", false, nil
+}
@@ -0,0 +1,65 @@
+package webmention_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestLoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials")
+	if err := os.Mkdir(credentials, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for file, content := range map[string]string{
+		filepath.Join(credentials, "MAIL_PASS"): "from-systemd\n",
+		filepath.Join(dir, "token"):             "from-file\n",
+		filepath.Join(dir, "secrets"):           "# secrets\nMAIL_PASS=shadowed\nREDIS_PASSWORD = from-secrets-file\n",
+	} {
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("CREDENTIALS_DIRECTORY", credentials)
+	t.Setenv("SECRETS_FILE", filepath.Join(dir, "secrets"))
+	t.Setenv("SECRETS_IDENTITY", "")
+	t.Setenv("ISSUE_TOKEN", "")
+	t.Setenv("ISSUE_TOKEN_FILE", filepath.Join(dir, "token"))
+	t.Setenv("MICROPUB_TOKEN", "from-env")
+	t.Setenv("MAIL_PASS", "")
+	t.Setenv("REDIS_PASSWORD", "")
+	t.Setenv("MAIL_OAUTH_CLIENT_SECRET", "")
+
+	names := []string{"ISSUE_TOKEN", "MICROPUB_TOKEN", "MAIL_PASS", "REDIS_PASSWORD", "MAIL_OAUTH_CLIENT_SECRET"}
+	secrets, err := webmention.LoadSecrets(webmention.DefaultSecretSources(), names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"ISSUE_TOKEN":    "from-file",
+		"MICROPUB_TOKEN": "from-env",
+		"MAIL_PASS":      "from-systemd",
+		"REDIS_PASSWORD": "from-secrets-file",
+	} {
+		if actual := secrets[name]; actual != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, actual)
+		}
+	}
+	if value, ok := secrets["MAIL_OAUTH_CLIENT_SECRET"]; ok {
+		t.Errorf("MAIL_OAUTH_CLIENT_SECRET: expected no secret, got %q", value)
+	}
+	for _, name := range []string{"ISSUE_TOKEN", "MAIL_PASS", "REDIS_PASSWORD"} {
+		if value := os.Getenv(name); value != "" {
+			t.Errorf("%s: secret exported to the environment: %q", name, value)
+		}
+	}
+
+	t.Setenv("SECRETS_FILE", filepath.Join(dir, "missing"))
+	if _, err := webmention.LoadSecrets(webmention.DefaultSecretSources(), "MAIL_OAUTH_CLIENT_SECRET"); err == nil {
+		t.Error("missing secrets file not reported")
+	}
+}
@@ -0,0 +1,101 @@
+package webmention
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// maxMessageLength limits how much of an error message is exposed in a
+// response of a hardened receiver.
+const maxMessageLength = 200
+
+// HardenedHeaders are the response headers set by WithHardening and SecurityHeaders.
+// The endpoints never serve content meant to be rendered by a browser, so
+// the policy can be as restrictive as possible.
+func HardenedHeaders() http.Header {
+	return http.Header{
+		"X-Content-Type-Options":  {"nosniff"},
+		"X-Frame-Options":         {"DENY"},
+		"Referrer-Policy":         {"no-referrer"},
+		"Content-Security-Policy": {"default-src 'none'; frame-ancestors 'none'"},
+		"Cache-Control":           {"no-store"},
+	}
+}
+
+// WithSecurityHeaders configures additional headers to be set on every response.
+// X-Content-Type-Options: nosniff is always set.
+func WithSecurityHeaders(headers http.Header) ReceiverOption {
+	return func(r *Receiver) {
+		for k, vs := range headers {
+			r.headers[http.CanonicalHeaderKey(k)] = vs
+		}
+	}
+}
+
+// WithMaxBodySize limits the size of request bodies, larger requests are rejected.
+func WithMaxBodySize(size int64) ReceiverOption {
+	return func(r *Receiver) {
+		r.maxBodySize = size
+	}
+}
+
+// WithTerseErrors configures the receiver to answer rejected requests with a
+// generic "bad request", instead of telling the client why.
+func WithTerseErrors() ReceiverOption {
+	return func(r *Receiver) {
+		r.terseErrors = true
+	}
+}
+
+// WithHardening bundles options suitable for a receiver exposed to the public internet:
+//   - restrictive security headers (see HardenedHeaders)
+//   - request bodies limited to 16 KiB
+//   - error messages stripped of control characters and cut off after 200 characters
+//
+// Pass WithTerseErrors in addition, if you don't even want to tell senders
+// why their request was rejected.
+func WithHardening() ReceiverOption {
+	return func(r *Receiver) {
+		WithSecurityHeaders(HardenedHeaders())(r)
+		WithMaxBodySize(16 << 10)(r)
+		r.sanitizeErrors = true
+	}
+}
+
+// SecurityHeaders wraps next, setting HardenedHeaders on every response.
+// Use it to protect the other routes served alongside the receiver,
+// including the NotFound fallback, so that unknown paths all look the same.
+func SecurityHeaders(next http.Handler) http.Handler {
+	headers := HardenedHeaders()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setHeaders(w, headers)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setHeaders(w http.ResponseWriter, headers http.Header) {
+	h := w.Header()
+	for k, vs := range headers {
+		h[k] = vs
+	}
+}
+
+// sanitizeMessage makes msg safe to be included in a (plain text) response body.
+// Control characters are dropped, and the message is cut off after maxMessageLength runes.
+func sanitizeMessage(msg string) string {
+	var builder strings.Builder
+	n := 0
+	for _, r := range msg {
+		if n >= maxMessageLength {
+			builder.WriteString("...")
+			break
+		}
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			continue
+		}
+		builder.WriteRune(r)
+		n++
+	}
+	return builder.String()
+}
@@ -0,0 +1,106 @@
+package webmention
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/cvanloo/gowebmention/canonical"
+)
+
+// InternalLinks decides whether Update mentions the targets a source links
+// to on its own site.
+type InternalLinks int
+
+const (
+	// SkipSameHost skips targets on the same host as the source (the default).
+	SkipSameHost InternalLinks = iota
+	// SkipSameSite additionally skips targets on other subdomains of the
+	// source's registrable domain, e.g., www.example.com for blog.example.com.
+	SkipSameSite
+	// MentionInternal mentions targets on the source's own site as well,
+	// e.g., to show links between your own posts as mentions.
+	MentionInternal
+)
+
+// WithInternalLinks sets which internal links of a source are mentioned by
+// Update, by default links to the same host as the source are skipped.
+// A source is never mentioned to itself.
+// Mention and MentionMany are not affected, their targets are always mentioned.
+func WithInternalLinks(policy InternalLinks) SenderOption {
+	return func(s *Sender) {
+		s.internalLinks = policy
+	}
+}
+
+// skips reports whether the policy skips mentioning target from source.
+func (policy InternalLinks) skips(source, target URL) bool {
+	if samePage(source, target) {
+		return true
+	}
+	sourceHost, targetHost := strings.ToLower(source.Hostname()), strings.ToLower(target.Hostname())
+	switch policy {
+	case SkipSameHost:
+		return sourceHost == targetHost
+	case SkipSameSite:
+		return registrableDomain(sourceHost) == registrableDomain(targetHost)
+	}
+	return false
+}
+
+// externalTargets removes the targets skipped by the sender's internal link policy.
+func (sender *Sender) externalTargets(source URL, targets []URL) (external []URL) {
+	for _, target := range targets {
+		if sender.internalLinks.skips(source, target) {
+			slog.Debug("update: skipping internal link", "source", source.String(), "target", target.String())
+			continue
+		}
+		external = append(external, target)
+	}
+	return external
+}
+
+// samePage reports whether a and b are the same page, ignoring the case of
+// scheme and host, default ports, trailing slashes, and the fragment.
+// Integrators can apply the same rules with package canonical.
+func samePage(a, b URL) bool {
+	return canonical.Same(a, b)
+}
+
+func pageKey(u URL) string {
+	return canonical.Key(u)
+}
+
+// SelfMentions decides what a receiver does with mentions whose source is
+// the target itself, after normalizing both urls and following the
+// redirects of the source.
+type SelfMentions int
+
+const (
+	// RejectSelfMentions rejects self-mentions (the default), those only
+	// discovered after following the redirects of the source are rejected
+	// during processing (see Reject).
+	RejectSelfMentions SelfMentions = iota
+	// MarkSelfMentions processes self-mentions like any other, but sets
+	// Mention.SelfMention, so that notifiers can treat them differently.
+	MarkSelfMentions
+)
+
+// WithSelfMentions sets how self-mentions are handled, by default they are rejected.
+func WithSelfMentions(policy SelfMentions) ReceiverOption {
+	return func(r *Receiver) {
+		r.selfMentions = policy
+	}
+}
+
+// checkRedirectedSelfMention rejects (or marks) a mention whose source
+// redirected to the target itself.
+func (receiver *Receiver) checkRedirectedSelfMention(mention *Mention, redirected URL) error {
+	if !samePage(redirected, mention.Target) {
+		return nil
+	}
+	if receiver.selfMentions == MarkSelfMentions {
+		mention.SelfMention = true
+		return nil
+	}
+	return Reject("source %s redirects to the target", mention.Source)
+}
@@ -1,6 +1,8 @@
 package webmention
 
 import (
+	"context"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"io"
@@ -8,6 +10,8 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/tomnomnom/linkheader"
 	"golang.org/x/net/html"
@@ -37,8 +41,47 @@ type (
 		Update(source URL, pastTargets, currentTargets []URL) error
 	}
 	Sender struct {
-		UserAgent  string
-		HttpClient *http.Client
+		UserAgent     string
+		HttpClient    *http.Client
+		signingKeyID  string
+		signingKey    ed25519.PrivateKey
+		cache         KeyValueStore
+		cacheTTL      time.Duration
+		persister     Persister
+		resendWindow  time.Duration
+		preflight     bool
+		detectUpdates bool
+		dial          dialConfig
+		// refuseDowngrade refuses http endpoints of https targets
+		refuseDowngrade bool
+		// sameSite only allows endpoints on the site of the target, or allowedEndpoints
+		sameSite         bool
+		allowedEndpoints []string
+		credentials      []EndpointCredential
+		internalLinks    InternalLinks
+		// policies are the well-known policies of target sites, nil if disabled
+		policies   map[string]*sitePolicy
+		policiesMu sync.Mutex
+
+		discoveryTimeout time.Duration
+		deliveryTimeout  time.Duration
+
+		// outbox holds mentions deferred until one of the sendWindows, nil if disabled
+		outbox      Outbox
+		sendWindows []SendWindow
+		// budget limits the deliveries per domain, nil if unlimited
+		budget    *DeliveryBudget
+		budgets   map[string]*domainBudget
+		budgetsMu sync.Mutex
+		// usage accounts the mentions sent per source domain, nil if disabled
+		usage *UsageMeter
+		// serverRetries is how often a mention answered with a server error is retried
+		serverRetries int
+		retryBackoff  time.Duration
+		// rediscoverAfter is how long a recorded endpoint is reused, see WithRediscoveryAfter
+		rediscoverAfter time.Duration
+		// httpCache caches the responses to GET and HEAD requests, nil if disabled
+		httpCache *HTTPCache
 	}
 	SenderOption func(*Sender)
 )
@@ -48,13 +91,18 @@ var _ WebMentionSender = (*Sender)(nil)
 
 func NewSender(opts ...SenderOption) *Sender {
 	sender := &Sender{
-		// @todo: I think I forgot to actually use this...
-		UserAgent:  "Webmention (github.com/cvanloo/gowebmention)",
-		HttpClient: http.DefaultClient,
+		UserAgent:     defaultUserAgent(),
+		HttpClient:    http.DefaultClient,
+		serverRetries: defaultServerRetries,
+		retryBackoff:  defaultRetryBackoff,
 	}
 	for _, opt := range opts {
 		opt(sender)
 	}
+	sender.HttpClient = sender.dial.client(sender.HttpClient)
+	if sender.httpCache != nil {
+		sender.HttpClient = sender.httpCache.Client(sender.HttpClient)
+	}
 	return sender
 }
 
@@ -67,12 +115,63 @@ func WithUserAgent(agent string) SenderOption {
 	}
 }
 
+// WithDiscoveryCache caches discovered endpoints for ttl.
+func WithDiscoveryCache(cache KeyValueStore, ttl time.Duration) SenderOption {
+	return func(s *Sender) {
+		s.cache = cache
+		s.cacheTTL = ttl
+	}
+}
+
+// WithDiscoveryTimeout limits how long discovering the endpoint of a target
+// may take, including all redirects and reading the html.
+// The HttpClient's own Timeout still applies to every single request.
+func WithDiscoveryTimeout(timeout time.Duration) SenderOption {
+	return func(s *Sender) {
+		s.discoveryTimeout = timeout
+	}
+}
+
+// WithDeliveryTimeout limits how long posting a mention to the discovered
+// endpoint may take, independent of how long discovery took.
+// Time spent waiting for the rate limit of a site (see WithWellKnownPolicy)
+// counts towards the delivery timeout.
+// The HttpClient's own Timeout still applies to every single request.
+func WithDeliveryTimeout(timeout time.Duration) SenderOption {
+	return func(s *Sender) {
+		s.deliveryTimeout = timeout
+	}
+}
+
+// timeoutContext returns a context expiring after timeout, or only when
+// cancelled, if timeout is zero.
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// Mention notifies the target url that it is being linked to by the source url.
 func (sender *Sender) Mention(source, target URL) error {
-	endpoint, err := sender.DiscoverEndpoint(target)
-	if err != nil {
-		return fmt.Errorf("mention: %w", err)
+	return sender.NewBatch(source).Mention(target)
+}
+
+// send posts a mention from source to target to the already discovered endpoint.
+// send posts the mention, or defers it, if outside of the send windows.
+// version identifies the version of the source the mention is sent for (see Batch.sourceVersion).
+func (sender *Sender) send(source, target, endpoint URL, version string) error {
+	if !sender.inSendWindow(time.Now()) {
+		return sender.deferDelivery(source, target, endpoint)
+	}
+	err := sender.post(source, target, endpoint, version)
+	if err != nil && sender.retryLater(PendingDelivery{Source: source.String(), Target: target.String(), Endpoint: endpoint.String()}, err) {
+		return errDeferred
 	}
+	return err
+}
 
+func (sender *Sender) post(source, target, endpoint URL, version string) error {
 	log := slog.With(
 		"function", "Mention",
 		slog.Group("request_info",
@@ -82,19 +181,90 @@ func (sender *Sender) Mention(source, target URL) error {
 		),
 	)
 
-	resp, err := sender.HttpClient.PostForm(endpoint.String(), url.Values{
+	body := url.Values{
 		"source": {source.String()},
 		"target": {target.String()},
-	})
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
+	}.Encode()
+	delivery := Delivery{
+		Source:      source.String(),
+		Target:      target.String(),
+		Endpoint:    endpoint.String(),
+		PayloadHash: payloadHash(body, version),
+		TraceParent: NewTraceParent(),
+	}
+	log = log.With("trace_id", TraceID(delivery.TraceParent))
+	if version != "" && sender.recentlyDelivered(source, target, endpoint, delivery.PayloadHash) {
+		log.Info("skipping mention, it was already sent recently")
+		return nil
+	}
+	if sender.usage != nil {
+		if err := sender.usage.allowSend(source.Hostname()); err != nil {
+			return fmt.Errorf("mention: %w", err)
+		}
+	}
+	ctx, cancel := timeoutContext(sender.deliveryTimeout)
+	defer cancel()
+	if err := sender.throttle(ctx, target); err != nil {
+		return fmt.Errorf("mention: %w", err)
+	}
+	release, err := sender.acquire(ctx, endpoint)
+	if err != nil {
+		return fmt.Errorf("mention: %w", err)
+	}
+	defer release()
+	var resp *http.Response
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), strings.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("mention: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", sender.UserAgent)
+		req.Header.Set(TraceParentHeader, delivery.TraceParent)
+		sender.authenticate(req)
+		if sender.signingKey != nil {
+			if err := signRequest(req, []byte(body), sender.signingKeyID, sender.signingKey); err != nil {
+				return fmt.Errorf("mention: %w", err)
+			}
+		}
+		delivery.Time = time.Now()
+		resp, err = sender.HttpClient.Do(req)
+		if err != nil {
+			sender.recordDelivery(delivery)
+			return fmt.Errorf("mention: endpoint: %s: %w", endpoint, err)
+		}
+		defer resp.Body.Close()
+		delivery.Status = resp.StatusCode
+		sender.recordDelivery(delivery)
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			if attempt > 1 {
+				log.Info("mention delivered after retrying", "attempts", attempt)
+			}
+			break
+		}
+		answer, _ := io.ReadAll(resp.Body)
 		log.Error(
 			"post request failed",
 			"status", resp.Status,
-			"body", string(body),
+			"body", string(answer),
+			"attempt", attempt,
 		)
-		return fmt.Errorf("mention: endpoint: %s: post form returned: %s", endpoint, resp.Status)
+		statusErr := fmt.Errorf("mention: endpoint: %s: %w", endpoint, &endpointStatusError{resp.Status, resp.StatusCode})
+		if !retryableStatus(resp.StatusCode) || attempt > sender.serverRetries {
+			if attempt > 1 {
+				return fmt.Errorf("%w (after %d attempts)", statusErr, attempt)
+			}
+			return statusErr
+		}
+		delay, ok := sender.retryDelay(attempt+1, resp)
+		if !ok {
+			return statusErr
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return fmt.Errorf("%w (retry: %w)", statusErr, err)
+		}
 	}
+	sender.usage.add(source.Hostname(), Usage{Sent: 1})
 
 	switch resp.StatusCode {
 	case http.StatusOK:
@@ -110,31 +280,33 @@ func (sender *Sender) Mention(source, target URL) error {
 	return nil
 }
 
-func (sender *Sender) MentionMany(source URL, targets []URL) (err error) {
-	for _, target := range targets {
-		merr := sender.Mention(source, target)
-		err = errors.Join(err, merr)
-	}
-	return err
+func (sender *Sender) MentionMany(source URL, targets []URL) error {
+	return sender.NewBatch(source).MentionMany(targets)
 }
 
+// Update resends mentions to all past and current targets of source.
+// Targets are compared in their canonical form, so that each page is only
+// mentioned once, even if it is linked to by different urls (the case of
+// scheme and host, default ports, trailing slashes, and fragments are ignored).
+// If a Persister is configured, the targets recorded by the last update are
+// included in pastTargets, and the current targets are recorded for the next update.
+// Links to the source's own host are skipped (see WithInternalLinks).
 func (sender *Sender) Update(source URL, pastTargets, currentTargets []URL) error {
-	pastTargetsSet := map[URL]struct{}{}
-	for _, target := range pastTargets {
-		pastTargetsSet[target] = struct{}{}
-	}
+	return sender.NewBatch(source).Update(pastTargets, currentTargets)
+}
 
-	targets := make([]URL, 0, len(pastTargets)+len(currentTargets))
-	for _, target := range pastTargets {
-		targets = append(targets, target)
-	}
-	for _, maybeNewTarget := range currentTargets {
-		if _, isOld := pastTargetsSet[maybeNewTarget]; !isOld {
-			targets = append(targets, maybeNewTarget)
-		}
-	}
+// UpdateResults works like Update, but additionally reports which targets
+// were added, removed, or kept, and whether they were told about it
+// (see Batch.UpdateResults).
+func (sender *Sender) UpdateResults(source URL, pastTargets, currentTargets []URL) (DeliveryResults, error) {
+	return sender.NewBatch(source).UpdateResults(pastTargets, currentTargets)
+}
 
-	return sender.MentionMany(source, targets)
+// Preview works like UpdateResults, but doesn't post any mentions, it only
+// reports what would be sent where (see Batch.PreviewResults).
+// Useful to preview the effect of publishing a post.
+func (sender *Sender) Preview(source URL, pastTargets, currentTargets []URL) (DeliveryResults, error) {
+	return sender.NewBatch(source).PreviewResults(pastTargets, currentTargets)
 }
 
 // DiscoverEndpoint searches the target for a webmention endpoint.
@@ -143,12 +315,108 @@ func (sender *Sender) Update(source URL, pastTargets, currentTargets []URL) erro
 // If no link with a webmention relationship is found, ErrNoEndpointFound is returned.
 // Any other error type indicates that we made a mistake, and not the target.
 func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
+	endpoint, _, err = sender.Discover(target)
+	return endpoint, err
+}
+
+// Discover works like DiscoverEndpoint, but additionally returns the
+// canonical url of the target.
+// The canonical url is the url declared by a rel=canonical link (in the Link
+// header or the html), or else the url the target redirected to.
+// Only canonical urls of the same origin (scheme, host and port) as the
+// target are accepted, since anyone can claim any url as their canonical.
+// If the target does not declare one, canonical is the target itself.
+// The canonical url is used to recognize the same page under different urls,
+// mentions are still sent for the target url, as it appears in the source.
+// On error, canonical is nil.
+// Endpoints with a scheme other than http or https are refused with an
+// EndpointError (see also WithDowngradeProtection and WithSameSiteEndpoints).
+// With WithWellKnownPolicy, a target without an endpoint falls back to the
+// endpoint of its site's policy.
+func (sender *Sender) Discover(target URL) (endpoint, canonical URL, err error) {
+	endpoint, canonical, err = sender.discover(target)
+	if errors.Is(err, ErrNoEndpointFound) {
+		if siteEndpoint, ok := sender.policyEndpoint(target); ok {
+			endpoint, canonical, err = siteEndpoint, target, nil
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := sender.checkEndpoint(target, endpoint); err != nil {
+		return nil, nil, err
+	}
+	return endpoint, canonical, nil
+}
+
+// discover returns the endpoint of target, from the endpoint log or the
+// cache if possible.
+// If the cache fails, the endpoint is discovered without it.
+func (sender *Sender) discover(target URL) (endpoint, canonical URL, err error) {
+	if endpoint, canonical, ok := sender.recordedEndpoint(target); ok {
+		return endpoint, canonical, nil
+	}
+	if sender.cache == nil {
+		return sender.discoverEndpoint(target)
+	}
+	key := "endpoint:" + target.String()
+	cached, ok, err := sender.cache.Get(key)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("endpoint discovery: cache: %s", err), "target", target.String())
+		return sender.discoverEndpoint(target)
+	}
+	if ok {
+		if endpoint, canonical, err := parseCachedEndpoint(target, cached); err == nil {
+			return endpoint, canonical, nil
+		}
+		slog.Warn("endpoint discovery: cache: ignoring invalid entry", "target", target.String(), "entry", cached)
+	}
+	endpoint, canonical, err = sender.discoverEndpoint(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := sender.cache.Set(key, endpoint.String()+" "+canonical.String(), sender.cacheTTL); err != nil {
+		slog.Warn(fmt.Sprintf("endpoint discovery: cache: %s", err), "target", target.String())
+	}
+	return endpoint, canonical, nil
+}
+
+// parseCachedEndpoint parses a discovery cache entry: the endpoint, and
+// optionally the canonical url, separated by a space.
+func parseCachedEndpoint(target URL, cached string) (endpoint, canonical URL, err error) {
+	endpointStr, canonicalStr, _ := strings.Cut(cached, " ")
+	if endpoint, err = url.Parse(endpointStr); err != nil {
+		return nil, nil, err
+	}
+	canonical = target
+	if canonicalStr != "" {
+		if canonical, err = url.Parse(canonicalStr); err != nil {
+			return nil, nil, err
+		}
+	}
+	return endpoint, canonical, nil
+}
+
+func (sender *Sender) discoverEndpoint(target URL) (endpoint, canonical URL, err error) {
+	defer func() {
+		if err == nil && endpoint != nil {
+			sender.recordEndpoint(target, endpoint, canonical)
+		}
+	}()
+	canonical = target
+	ctx, cancel := timeoutContext(sender.discoveryTimeout)
+	defer cancel()
 	{ // First make a HEAD request to look for a Link-Header
 		// @todo: HttpClient needs to follow redirects (the default client follows up to 10)
 		//        Ensure that the client is actually configured correctly?
-		resp, err := sender.HttpClient.Head(target.String())
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, target.String(), nil)
 		if err != nil {
-			return nil, fmt.Errorf("endpoint discovery: cannot head target: %w", err)
+			return nil, nil, fmt.Errorf("endpoint discovery: cannot create request from url: %s: because: %w", target, err)
+		}
+		req.Header.Set("User-Agent", sender.UserAgent)
+		resp, err := sender.HttpClient.Do(req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("endpoint discovery: cannot head target: %w", err)
 		}
 		defer func() {
 			// go doc http.Do: body needs to be read to EOF and closed [:read_eof_and_close_body:]
@@ -160,7 +428,10 @@ func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
 			err = errors.Join(err, rerr, errTooMuch)
 		}()
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return nil, fmt.Errorf("endpoint discovery: head returned %s", resp.Status)
+			return nil, nil, fmt.Errorf("endpoint discovery: head returned %s", resp.Status)
+		}
+		if resp.Request != nil && resp.Request.URL != nil && sameOrigin(target, resp.Request.URL) {
+			canonical = resp.Request.URL // the target may have redirected us
 		}
 
 		linkHeaders := resp.Header.Values("Link")
@@ -168,30 +439,35 @@ func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
 		for _, l := range linkheader.ParseMultiple(linkHeaders) {
 			relVals := strings.Split(l.Rel, " ")
 			for _, relVal := range relVals {
-				if strings.ToLower(relVal) == "webmention" {
-					foundLink = l.URL
-					break
+				switch strings.ToLower(relVal) {
+				case "webmention":
+					if foundLink == "" {
+						foundLink = l.URL
+					}
+				case "canonical":
+					canonical = resolveCanonical(canonical, l.URL)
 				}
 			}
 		}
 		if foundLink != "" { // Link header takes precedence before <link> and <a>
 			endpoint, err := url.Parse(foundLink)
 			if err != nil { // @todo: or continue on trying? [:should_we_continue_trying_or_not:]
-				return nil, fmt.Errorf("endpoint discovery: %w: in link header: %w", ErrInvalidRelWebmention, err)
+				return nil, nil, fmt.Errorf("endpoint discovery: %w: in link header: %w", ErrInvalidRelWebmention, err)
 			}
-			return target.ResolveReference(endpoint), nil
+			return target.ResolveReference(endpoint), canonical, nil
 		}
 	}
 
 	{ // No Link header present, so request HTML content and scan it for <link> and <a> elements
-		req, err := http.NewRequest(http.MethodGet, target.String(), nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
 		if err != nil {
-			return nil, fmt.Errorf("endpoint discovery: cannot create request from url: %s: because: %w", target, err)
+			return nil, nil, fmt.Errorf("endpoint discovery: cannot create request from url: %s: because: %w", target, err)
 		}
 		req.Header.Set("Accept", "text/html")
+		req.Header.Set("User-Agent", sender.UserAgent)
 		resp, err := sender.HttpClient.Do(req)
 		if err != nil {
-			return nil, fmt.Errorf("endpoint discovery: cannot get target: %w", err)
+			return nil, nil, fmt.Errorf("endpoint discovery: cannot get target: %w", err)
 		}
 		defer func() {
 			// go doc http.Do: body needs to be read to EOF and closed [:read_eof_and_close_body:]
@@ -202,13 +478,13 @@ func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
 			}
 		}()
 		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			return nil, fmt.Errorf("endpoint discovery: get returned %s", resp.Status)
+			return nil, nil, fmt.Errorf("endpoint discovery: get returned %s", resp.Status)
 		}
 
 		// @todo: need to ensure resp.Body is valid utf-8
 		doc, err := html.Parse(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("endpoint discovery: cannot parse html: %w", err)
+			return nil, nil, fmt.Errorf("endpoint discovery: cannot parse html: %w", err)
 		}
 		var (
 			traverseHtml            func(*html.Node) bool
@@ -218,6 +494,13 @@ func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
 		traverseHtml = func(node *html.Node) bool {
 			if node.Type == html.ElementNode {
 				if node.Data == "link" {
+					if href, ok := relHref(node, "canonical"); ok {
+						canonical = resolveCanonical(canonical, href)
+					}
+				}
+				if firstLinkRel != nil || firstARel != nil {
+					// endpoint already found, only keep looking for a canonical link
+				} else if node.Data == "link" {
 					url, err := scanForRelLink(node)
 					if err != nil {
 						if !errors.Is(err, ErrNoRelWebmention) {
@@ -226,7 +509,6 @@ func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
 						}
 					} else {
 						firstLinkRel = url
-						return false
 					}
 				} else if node.Data == "a" {
 					url, err := scanForRelLink(node)
@@ -237,7 +519,6 @@ func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
 						}
 					} else {
 						firstARel = url
-						return false
 					}
 				}
 			}
@@ -250,17 +531,17 @@ func (sender *Sender) DiscoverEndpoint(target URL) (endpoint URL, err error) {
 		}
 		traverseHtml(doc)
 		if traverseErr != nil {
-			return nil, fmt.Errorf("endpoint discovery: %w: in <link> or <a> element: %w", ErrInvalidRelWebmention, traverseErr)
+			return nil, nil, fmt.Errorf("endpoint discovery: %w: in <link> or <a> element: %w", ErrInvalidRelWebmention, traverseErr)
 		}
 		if firstLinkRel != nil {
-			return target.ResolveReference(firstLinkRel), nil
+			return target.ResolveReference(firstLinkRel), canonical, nil
 		}
 		if firstARel != nil {
-			return target.ResolveReference(firstARel), nil
+			return target.ResolveReference(firstARel), canonical, nil
 		}
 	}
 
-	return nil, ErrNoEndpointFound
+	return nil, nil, ErrNoEndpointFound
 }
 
 func scanForRelLink(node *html.Node) (URL, error) {
@@ -287,3 +568,41 @@ func scanForRelLink(node *html.Node) (URL, error) {
 	}
 	return nil, ErrNoRelWebmention
 }
+
+// relHref returns the href of node, if it defines the rel relationship.
+func relHref(node *html.Node, rel string) (href string, ok bool) {
+	hasRel, hasHref := false, false
+	for _, a := range node.Attr {
+		switch a.Key {
+		case "rel":
+			for _, relVal := range strings.Fields(a.Val) {
+				if strings.EqualFold(relVal, rel) {
+					hasRel = true
+				}
+			}
+		case "href":
+			hasHref, href = true, a.Val
+		}
+	}
+	return href, hasRel && hasHref
+}
+
+// resolveCanonical resolves the canonical href relative to base.
+// Invalid canonical urls, and those of another origin than base, are
+// ignored, base is returned instead.
+func resolveCanonical(base URL, href string) URL {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return base
+	}
+	canonical := base.ResolveReference(ref)
+	if !sameOrigin(base, canonical) {
+		return base
+	}
+	return canonical
+}
+
+// sameOrigin reports whether a and b share their scheme, host and port.
+func sameOrigin(a, b URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
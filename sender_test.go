@@ -1,12 +1,22 @@
 package webmention_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	webmention "github.com/cvanloo/gowebmention"
 )
@@ -180,11 +190,37 @@ func TestMentioningRocks(t *testing.T) {
 	}
 }
 
-//func TestMentioningUpdatesRocks(t *testing.T) {
-//}
-//
-//func TestMentioningDeletesRocks(t *testing.T) {
-//}
+// rocksSource serves a conformance source for webmention.rocks, on the local
+// address WEBMENTION_ROCKS_LISTEN, which webmention.rocks must reach under
+// WEBMENTION_ROCKS_PUBLIC (e.g., forwarded by a tunnel).
+func rocksSource(t *testing.T) (*url.URL, *webmention.ConformanceSource) {
+	listen, public := os.Getenv("WEBMENTION_ROCKS_LISTEN"), os.Getenv("WEBMENTION_ROCKS_PUBLIC")
+	if testing.Short() || listen == "" || public == "" {
+		t.SkipNow()
+	}
+	page := &webmention.ConformanceSource{}
+	l := must(net.Listen("tcp", listen))
+	server := &http.Server{Handler: page}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return must(url.Parse(public + "/" + t.Name())), page
+}
+
+func TestMentioningUpdatesRocks(t *testing.T) {
+	source, page := rocksSource(t)
+	sender := webmention.NewSender()
+	if _, err := sender.RunConformance(webmention.RocksUpdateSuite, source, page, 10*time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMentioningDeletesRocks(t *testing.T) {
+	source, page := rocksSource(t)
+	sender := webmention.NewSender()
+	if _, err := sender.RunConformance(webmention.RocksDeleteSuite, source, page, 10*time.Second); err != nil {
+		t.Error(err)
+	}
+}
 
 var localTargets = Targets{
 	{
@@ -813,3 +849,1076 @@ func TestMentioningLocal(t *testing.T) {
 
 	// @todo: check that actually the correct endpoint is contacted
 }
+
+func TestUpdateCanonicalTargets(t *testing.T) {
+	var posted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old-page", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><link rel="webmention" href="/webmention"><link rel="canonical" href="/page"></head></html>`)
+	})
+	mux.HandleFunc("/copy", func(w http.ResponseWriter, r *http.Request) {
+		// claims to be another site's page
+		fmt.Fprint(w, `<html><head><link rel="webmention" href="/webmention"><link rel="canonical" href="https://example.org/page"></head></html>`)
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.FormValue("target"))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	persister := webmention.NewMemoryPersister()
+	sender := webmention.NewSender(webmention.WithPersister(persister))
+	source := must(url.Parse("https://example.com/post"))
+	canonical := ts.URL + "/page"
+
+	variants := []*url.URL{
+		must(url.Parse(ts.URL + "/page?utm_source=feed")),
+		must(url.Parse(ts.URL + "/old-page")),
+	}
+	if err := sender.Update(source, nil, variants); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 1 || posted[0] != variants[0].String() {
+		t.Fatalf("variant urls not mentioned once by the url in the source: %v", posted)
+	}
+	if targets := must(persister.Targets(source)); len(targets) != 1 || targets[0].String() != canonical {
+		t.Errorf("canonical target not persisted: %v", targets)
+	}
+
+	// the link got removed, the persisted target still needs to be informed
+	if err := sender.Update(source, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 2 || posted[1] != canonical {
+		t.Errorf("removed target not informed: %v", posted)
+	}
+	if targets := must(persister.Targets(source)); len(targets) != 0 {
+		t.Errorf("removed target still persisted: %v", targets)
+	}
+
+	// canonical urls of another origin are not trusted
+	copied := ts.URL + "/copy"
+	if err := sender.Update(source, nil, []*url.URL{must(url.Parse(copied))}); err != nil {
+		t.Fatal(err)
+	}
+	if len(posted) != 3 || posted[2] != copied {
+		t.Errorf("target not mentioned: %v", posted)
+	}
+	if targets := must(persister.Targets(source)); len(targets) != 1 || targets[0].String() != copied {
+		t.Errorf("foreign canonical url persisted: %v", targets)
+	}
+}
+
+func TestBatchFetchesSourceOnce(t *testing.T) {
+	var sourceFetches, posts int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		sourceFetches++
+		fmt.Fprintf(w, `<html><body><a href="http://%s/target/1">1</a><a href="target/2">2</a></body></html>`, r.Host)
+	})
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	sender := webmention.NewSender(webmention.WithPreflight())
+	batch := sender.NewBatch(must(url.Parse(ts.URL + "/source")))
+	err := batch.MentionMany([]*url.URL{
+		must(url.Parse(ts.URL + "/target/1")),
+		must(url.Parse(ts.URL + "/target/2")),
+		must(url.Parse(ts.URL + "/target/3")),
+	})
+	if !errors.Is(err, webmention.ErrSourceDoesNotLinkToTarget) {
+		t.Errorf("unlinked target passed preflight: %v", err)
+	}
+	if sourceFetches != 1 {
+		t.Errorf("source fetched %d times, want: 1", sourceFetches)
+	}
+	if posts != 2 {
+		t.Errorf("incorrect number of mentions sent, got: %d, want: 2", posts)
+	}
+}
+
+func TestUpdateDetection(t *testing.T) {
+	content := "first version"
+	var (
+		posted  []string
+		fetches int // changes outside the h-entry must not count as an update
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintf(w, `<html><body><aside>%d</aside><article class="post h-entry"><p>%s</p></article></body></html>`, fetches, content)
+	})
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.FormValue("target"))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	sender := webmention.NewSender(
+		webmention.WithPersister(webmention.NewMemoryPersister()),
+		webmention.WithUpdateDetection(),
+		webmention.WithInternalLinks(webmention.MentionInternal),
+	)
+	target1 := must(url.Parse(ts.URL + "/target/1"))
+	target2 := must(url.Parse(ts.URL + "/target/2"))
+	update := func(targets ...*url.URL) []string {
+		posted = nil
+		if err := sender.Update(must(url.Parse(ts.URL+"/source")), nil, targets); err != nil {
+			t.Fatal(err)
+		}
+		return posted
+	}
+
+	if got := update(target1); len(got) != 1 {
+		t.Errorf("first update: incorrect mentions: %v", got)
+	}
+	if got := update(target1); len(got) != 0 {
+		t.Errorf("unchanged content: mentions sent anyway: %v", got)
+	}
+	if got := update(target1, target2); len(got) != 1 || got[0] != target2.String() {
+		t.Errorf("new target: incorrect mentions: %v", got)
+	}
+	content = "second version"
+	if got := update(target1, target2); len(got) != 2 {
+		t.Errorf("changed content: incorrect mentions: %v", got)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	page := &webmention.ConformanceSource{}
+	source := httptest.NewServer(page)
+	defer source.Close()
+	// the receiver verifies every mention right away, and remembers what it saw
+	var (
+		m        sync.Mutex
+		verified = map[string]string{}
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		resp, err := http.Get(r.FormValue("source"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		body := must(io.ReadAll(resp.Body))
+		seen := resp.Status
+		if strings.Contains(string(body), r.FormValue("target")) {
+			seen = "linked"
+		}
+		m.Lock()
+		verified[r.FormValue("target")] = seen
+		m.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	})
+	targets := httptest.NewServer(mux)
+	defer targets.Close()
+
+	suite := webmention.ConformanceSuite{
+		Name: "local",
+		Steps: []webmention.ConformanceStep{
+			{Comment: "first", Links: []string{targets.URL + "/target/a", targets.URL + "/target/b"}},
+			{Comment: "second", Links: []string{targets.URL + "/target/a"}},
+			{Gone: true},
+		},
+	}
+	sender := webmention.NewSender(webmention.WithInternalLinks(webmention.MentionInternal))
+	results, err := sender.RunConformance(suite, must(url.Parse(source.URL+"/post")), page, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("incorrect number of results, got: %d, want: 3", len(results))
+	}
+	if results[1].Sent(webmention.ChangeRemoved) != 1 || results[1].Sent(webmention.ChangeKept) != 1 {
+		t.Errorf("update: incorrect results: %s", results[1])
+	}
+	if results[2].Sent(webmention.ChangeRemoved) != 1 {
+		t.Errorf("delete: incorrect results: %s", results[2])
+	}
+	// only part a was linked to when the source was deleted
+	want := map[string]string{
+		targets.URL + "/target/a": "410 Gone",
+		targets.URL + "/target/b": "200 OK",
+	}
+	if !maps.Equal(verified, want) {
+		t.Errorf("incorrect verifications, got: %v, want: %v", verified, want)
+	}
+}
+
+func TestSenderTimeouts(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/target/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse(ts.URL + "/source"))
+
+	sender := webmention.NewSender(webmention.WithDiscoveryTimeout(50 * time.Millisecond))
+	if _, err := sender.DiscoverEndpoint(must(url.Parse(ts.URL + "/target/slow"))); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow discovery did not time out: %v", err)
+	}
+	// delivery is not limited by the discovery timeout
+	if err := sender.Mention(source, must(url.Parse(ts.URL+"/target/fast"))); err != nil {
+		t.Errorf("delivery failed: %s", err)
+	}
+
+	sender = webmention.NewSender(webmention.WithDeliveryTimeout(50 * time.Millisecond))
+	if err := sender.Mention(source, must(url.Parse(ts.URL+"/target/fast"))); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow delivery did not time out: %v", err)
+	}
+}
+
+func TestEndpointSchemes(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer plain.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target/javascript", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<javascript:alert(1)>; rel=webmention")
+	})
+	mux.HandleFunc("/target/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<file:///etc/passwd>; rel=webmention")
+	})
+	mux.HandleFunc("/target/downgrade", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<"+plain.URL+"/webmention>; rel=webmention")
+	})
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+
+	sender := webmention.NewSender()
+	sender.HttpClient = ts.Client()
+	for _, path := range []string{"/target/javascript", "/target/file"} {
+		_, err := sender.DiscoverEndpoint(must(url.Parse(ts.URL + path)))
+		var endpointErr *webmention.EndpointError
+		if !errors.As(err, &endpointErr) || !errors.Is(err, webmention.ErrEndpointScheme) {
+			t.Errorf("%s: endpoint not refused: %v", path, err)
+		}
+	}
+	downgrade := must(url.Parse(ts.URL + "/target/downgrade"))
+	if endpoint, err := sender.DiscoverEndpoint(downgrade); err != nil || endpoint.String() != plain.URL+"/webmention" {
+		t.Errorf("downgrade refused without protection: %v, %v", endpoint, err)
+	}
+
+	sender = webmention.NewSender(webmention.WithDowngradeProtection())
+	sender.HttpClient = ts.Client()
+	if _, err := sender.DiscoverEndpoint(downgrade); !errors.Is(err, webmention.ErrEndpointDowngrade) {
+		t.Errorf("downgrade not refused: %v", err)
+	}
+	if err := sender.Mention(must(url.Parse(ts.URL+"/source")), downgrade); !errors.Is(err, webmention.ErrEndpointDowngrade) {
+		t.Errorf("mention sent to downgraded endpoint: %v", err)
+	}
+}
+
+func TestSameSiteEndpoints(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer collector.Close()
+	// same server, but a different host name
+	foreignEndpoint := strings.Replace(collector.URL, "127.0.0.1", "localhost", 1) + "/webmention"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target/local", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<"+collector.URL+"/webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/target/foreign", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<"+foreignEndpoint+">; rel=webmention")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse(ts.URL + "/source"))
+	local := must(url.Parse(ts.URL + "/target/local"))
+	foreign := must(url.Parse(ts.URL + "/target/foreign"))
+
+	sender := webmention.NewSender(webmention.WithSameSiteEndpoints())
+	if err := sender.Mention(source, local); err != nil {
+		t.Errorf("same site endpoint refused: %s", err)
+	}
+	if err := sender.Mention(source, foreign); !errors.Is(err, webmention.ErrEndpointForeign) {
+		t.Errorf("foreign endpoint not refused: %v", err)
+	}
+
+	sender = webmention.NewSender(webmention.WithSameSiteEndpoints("localhost"))
+	if err := sender.Mention(source, foreign); err != nil {
+		t.Errorf("allowed endpoint refused: %s", err)
+	}
+}
+
+func TestWellKnownPolicy(t *testing.T) {
+	var posted atomic.Int32
+	mux := http.NewServeMux()
+	mux.Handle(webmention.WellKnownPath, webmention.WellKnownHandler(webmention.WellKnownPolicy{
+		Endpoint:  "/webmention",
+		RateLimit: 600, // one every 100ms
+	}))
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		// no endpoint declared
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posted.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse(ts.URL + "/source"))
+	targets := []*url.URL{
+		must(url.Parse(ts.URL + "/target/1")),
+		must(url.Parse(ts.URL + "/target/2")),
+		must(url.Parse(ts.URL + "/target/3")),
+	}
+
+	sender := webmention.NewSender()
+	if err := sender.Mention(source, targets[0]); !errors.Is(err, webmention.ErrNoEndpointFound) {
+		t.Errorf("policy used without being enabled: %v", err)
+	}
+
+	sender = webmention.NewSender(webmention.WithWellKnownPolicy())
+	start := time.Now()
+	if err := sender.MentionMany(source, targets); err != nil {
+		t.Fatal(err)
+	}
+	if n := posted.Load(); n != 3 {
+		t.Errorf("expected 3 mentions through the site endpoint, got: %d", n)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("rate limit not respected: 3 mentions in %s", elapsed)
+	}
+}
+
+func TestWellKnownRateLimitBounded(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle(webmention.WellKnownPath, webmention.WellKnownHandler(webmention.WellKnownPolicy{
+		Endpoint:  "/webmention",
+		RateLimit: 1,
+	}))
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse(ts.URL + "/source"))
+	target := must(url.Parse(ts.URL + "/target/1"))
+
+	sender := webmention.NewSender(webmention.WithWellKnownPolicy(), webmention.WithDeliveryTimeout(50*time.Millisecond))
+	if err := sender.Mention(source, target); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	// the next mention is allowed in a minute, but the delivery times out before
+	if err := sender.Mention(source, target); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for rate limit not cancelled: %v", err)
+	}
+	// and the one after would have to wait for more than a minute
+	if err := sender.Mention(source, target); !errors.Is(err, webmention.ErrRateLimited) {
+		t.Errorf("expected %v, got: %v", webmention.ErrRateLimited, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("throttled for %s", elapsed)
+	}
+}
+
+func TestResendWindow(t *testing.T) {
+	var posted atomic.Int32
+	var content atomic.Value
+	content.Store("Hello World")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		if content.Load() == "" {
+			http.Error(w, "deleted", http.StatusGone)
+			return
+		}
+		fmt.Fprintf(w, `<p>%s <a href="/target">target</a></p>`, content.Load())
+	})
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posted.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse(ts.URL + "/source"))
+	target := must(url.Parse(ts.URL + "/target"))
+
+	persister := webmention.NewMemoryPersister()
+	sender := webmention.NewSender(webmention.WithPersister(persister))
+	for range 2 {
+		if err := sender.Mention(source, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := posted.Load(); n != 2 {
+		t.Errorf("mention not resent without a resend window, posted: %d", n)
+	}
+	deliveries := must(persister.Deliveries(source, target))
+	if len(deliveries) != 2 || deliveries[0].Status != http.StatusAccepted || deliveries[0].Endpoint != ts.URL+"/webmention" || deliveries[0].PayloadHash == "" {
+		t.Errorf("deliveries not recorded: %+v", deliveries)
+	}
+
+	// deliveries recorded without a resend window don't know the version of the source
+	sender = webmention.NewSender(webmention.WithPersister(persister), webmention.WithResendWindow(time.Hour), webmention.WithInternalLinks(webmention.MentionInternal))
+	for range 2 {
+		if err := sender.Mention(source, target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := posted.Load(); n != 3 {
+		t.Errorf("mention resent within the resend window, posted: %d", n)
+	}
+
+	// updates and deletions of the source are sent within the window
+	content.Store("Hello, updated World")
+	if err := sender.Update(source, []*url.URL{target}, []*url.URL{target}); err != nil {
+		t.Fatal(err)
+	}
+	if n := posted.Load(); n != 4 {
+		t.Errorf("changed source not resent within the resend window, posted: %d", n)
+	}
+	content.Store("")
+	if err := sender.Update(source, []*url.URL{target}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := posted.Load(); n != 5 {
+		t.Errorf("deleted source not resent within the resend window, posted: %d", n)
+	}
+	if err := sender.Update(source, []*url.URL{target}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := posted.Load(); n != 5 {
+		t.Errorf("deletion resent within the resend window, posted: %d", n)
+	}
+}
+
+func TestUpdateResults(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<p>Hello World</p>`)
+	})
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/no-endpoint", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	removed := must(url.Parse(ts.URL + "/target/removed"))
+	kept := must(url.Parse(ts.URL + "/target/kept"))
+	added := must(url.Parse(ts.URL + "/target/added"))
+	broken := must(url.Parse(ts.URL + "/no-endpoint"))
+
+	sender := webmention.NewSender(webmention.WithInternalLinks(webmention.MentionInternal))
+	results, err := sender.UpdateResults(must(url.Parse(ts.URL+"/source")), []*url.URL{removed, kept}, []*url.URL{kept, added, broken})
+	if !errors.Is(err, webmention.ErrNoEndpointFound) {
+		t.Errorf("incorrect error: %v", err)
+	}
+	changes := map[string]webmention.Change{}
+	for _, result := range results {
+		changes[result.Target.String()] = result.Change
+		if result.Sent != (result.Err == nil) {
+			t.Errorf("%s: sent: %t, but error: %v", result.Target, result.Sent, result.Err)
+		}
+	}
+	expected := map[string]webmention.Change{
+		removed.String(): webmention.ChangeRemoved,
+		kept.String():    webmention.ChangeKept,
+		added.String():   webmention.ChangeAdded,
+		broken.String():  webmention.ChangeAdded,
+	}
+	if !maps.Equal(changes, expected) {
+		t.Errorf("incorrect changes, got: %v, want: %v", changes, expected)
+	}
+	if summary := results.String(); summary != "1 new mention sent, 1 update sent, 1 removal notified, 1 failed" {
+		t.Errorf("incorrect summary: %s", summary)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	var posted atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="http://%s/target/linked">linked</a>`, r.Host)
+	})
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posted.Add(1)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse(ts.URL + "/source"))
+	removed := must(url.Parse(ts.URL + "/target/removed"))
+	linked := must(url.Parse(ts.URL + "/target/linked"))
+	unlinked := must(url.Parse(ts.URL + "/target/unlinked"))
+
+	persister := webmention.NewMemoryPersister()
+	sender := webmention.NewSender(webmention.WithPersister(persister), webmention.WithInternalLinks(webmention.MentionInternal))
+	results, err := sender.Preview(source, []*url.URL{removed}, []*url.URL{linked, unlinked})
+	if !errors.Is(err, webmention.ErrSourceDoesNotLinkToTarget) {
+		t.Errorf("incorrect error: %v", err)
+	}
+	if n := posted.Load(); n != 0 {
+		t.Errorf("preview posted %d mentions", n)
+	}
+	for _, result := range results {
+		if !result.DryRun {
+			t.Errorf("%s: not marked as dry run", result.Target)
+		}
+		if result.Endpoint.String() != ts.URL+"/webmention" {
+			t.Errorf("%s: incorrect endpoint: %s", result.Target, result.Endpoint)
+		}
+		if want := result.Target != unlinked; result.Sent != want {
+			t.Errorf("%s: would be sent: %t, want: %t", result.Target, result.Sent, want)
+		}
+	}
+	if summary := results.String(); summary != "1 new mention to send, 1 removal to notify, 1 failed" {
+		t.Errorf("incorrect summary: %s", summary)
+	}
+	if targets := must(persister.Targets(source)); len(targets) != 0 {
+		t.Errorf("preview persisted targets: %v", targets)
+	}
+}
+
+func TestUserAgentVersion(t *testing.T) {
+	userAgent := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case userAgent <- r.UserAgent():
+		default: // discovery sends more than one request
+		}
+	}))
+	defer ts.Close()
+	webmention.NewSender().DiscoverEndpoint(must(url.Parse(ts.URL)))
+	if got := <-userAgent; !strings.Contains(got, webmention.Version()) || webmention.Version() == "" {
+		t.Errorf("version %q missing in user agent: %q", webmention.Version(), got)
+	}
+}
+
+// unavailableStore fails every operation, like an unreachable Redis.
+type unavailableStore struct{}
+
+func (unavailableStore) Get(string) (string, bool, error) {
+	return "", false, errors.New("connection refused")
+}
+
+func (unavailableStore) Set(string, string, time.Duration) error {
+	return errors.New("connection refused")
+}
+
+func (unavailableStore) SetNX(string, string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestUnavailableCache(t *testing.T) {
+	var posted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.FormValue("target"))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	store := unavailableStore{}
+	sender := webmention.NewSender(
+		webmention.WithDiscoveryCache(store, time.Hour),
+		webmention.WithPersister(&webmention.KeyValuePersister{Store: store}),
+	)
+	target := must(url.Parse(ts.URL + "/target"))
+	err := sender.Update(must(url.Parse("https://example.com/post")), nil, []*url.URL{target})
+	if len(posted) != 1 || posted[0] != target.String() {
+		t.Errorf("target not mentioned without cache: %v", posted)
+	}
+	// only failing to remember the targets for the next update is reported
+	if err == nil {
+		t.Error("persister error not reported")
+	}
+}
+
+func TestEndpointCredentials(t *testing.T) {
+	var authorization, apiKey string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		authorization, apiKey = r.Header.Get("Authorization"), r.Header.Get("X-Api-Key")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse(ts.URL + "/source"))
+	target := must(url.Parse(ts.URL + "/target"))
+
+	credentials := must(webmention.ParseEndpointCredentials(strings.NewReader(`
+# host        credentials
+*.example.com bearer=other
+127.0.0.1     bearer=secret header=X-Api-Key:key
+`)))
+	sender := webmention.NewSender(webmention.WithEndpointCredentials(credentials...))
+	if err := sender.Mention(source, target); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "" || apiKey != "" {
+		t.Errorf("credentials sent over an insecure connection: %q, %q", authorization, apiKey)
+	}
+
+	credentials[1].Insecure = true
+	sender = webmention.NewSender(webmention.WithEndpointCredentials(credentials...))
+	if err := sender.Mention(source, target); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer secret" || apiKey != "key" {
+		t.Errorf("credentials not sent, got: %q, %q", authorization, apiKey)
+	}
+
+	if _, err := webmention.ParseEndpointCredentials(strings.NewReader("example.com basic=nopassword")); err == nil {
+		t.Error("invalid credentials parsed without error")
+	}
+}
+
+func TestDiagnoseEndpoint(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/posts/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/posts/new", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><!-- <link rel="webmention" href="/old-endpoint"> --><link rel="webmention" href="webmention"></head><body></body></html>`)
+	})
+	mux.HandleFunc("/good", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", fmt.Sprintf("<%s/webmention>; rel=webmention", "http://"+r.Host))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	sender := webmention.NewSender()
+
+	diagnosis := must(sender.DiagnoseEndpoint(must(url.Parse(ts.URL + "/good"))))
+	if len(diagnosis.Problems) != 0 || diagnosis.Endpoint.String() != ts.URL+"/webmention" {
+		t.Errorf("unexpected diagnosis: %+v", diagnosis)
+	}
+
+	diagnosis = must(sender.DiagnoseEndpoint(must(url.Parse(ts.URL + "/old"))))
+	if diagnosis.URL.String() != ts.URL+"/posts/new" || len(diagnosis.Advertisements) != 1 || diagnosis.Advertisements[0].Where != "<link>" {
+		t.Errorf("unexpected diagnosis: %+v", diagnosis)
+	}
+	var comment, relative bool
+	for _, problem := range diagnosis.Problems {
+		comment = comment || strings.Contains(problem, "comment")
+		relative = relative || strings.Contains(problem, "redirected")
+	}
+	if !comment || !relative {
+		t.Errorf("problems not reported: %q", diagnosis.Problems)
+	}
+}
+
+func TestInternalLinks(t *testing.T) {
+	var posted []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.FormValue("target"))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	// the same server, under another host name
+	other := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	source := must(url.Parse(ts.URL + "/target/source"))
+	internal := must(url.Parse(ts.URL + "/target/internal"))
+	external := must(url.Parse(other + "/target/external"))
+	self := must(url.Parse(ts.URL + "/target/source/#comments"))
+
+	sender := webmention.NewSender()
+	if err := sender.Update(source, nil, []*url.URL{internal, external, self}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(posted, []string{external.String()}) {
+		t.Errorf("internal links mentioned, got: %v", posted)
+	}
+
+	posted = nil
+	sender = webmention.NewSender(webmention.WithInternalLinks(webmention.MentionInternal))
+	if err := sender.Update(source, nil, []*url.URL{internal, external, self}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(posted, []string{internal.String(), external.String()}) {
+		t.Errorf("internal links not mentioned, got: %v", posted)
+	}
+}
+
+func TestSendWindows(t *testing.T) {
+	windows := must(webmention.ParseSendWindows("22:00-06:00, 12:00-13:30"))
+	day := func(clock string) time.Time {
+		return must(time.ParseInLocation("2006-01-02 15:04", "2026-03-10 "+clock, time.Local))
+	}
+	for clock, inside := range map[string]bool{"23:15": true, "05:59": true, "06:00": false, "12:45": true, "13:30": false, "18:00": false} {
+		if actual := windows[0].Contains(day(clock)) || windows[1].Contains(day(clock)); actual != inside {
+			t.Errorf("%s: expected inside a window: %t, got: %t", clock, inside, actual)
+		}
+	}
+	if _, err := webmention.ParseSendWindows("22:00"); err == nil {
+		t.Error("window without end accepted")
+	}
+
+	posted := make(chan string, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		posted <- r.PostForm.Get("target")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse("https://example.com/source"))
+	target := must(url.Parse(ts.URL + "/target"))
+
+	now := time.Now()
+	offset := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())).Truncate(time.Minute)
+	closed := webmention.SendWindow{From: (offset + 2*time.Hour) % (24 * time.Hour), To: (offset + 3*time.Hour) % (24 * time.Hour)}
+	open := webmention.SendWindow{From: offset, To: (offset + 2*time.Hour) % (24 * time.Hour)}
+
+	outbox := webmention.NewJSONFileOutbox(t.TempDir() + "/outbox.json")
+	sender := webmention.NewSender(webmention.WithSendWindows(outbox, closed))
+	results, err := sender.NewBatch(source).UpdateResults(nil, []webmention.URL{target})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !results[0].Deferred || results[0].Sent || results.String() != "1 deferred" {
+		t.Fatalf("mention not deferred: %+v", results)
+	}
+	select {
+	case <-posted:
+		t.Fatal("mention posted outside of the send window")
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go webmention.NewSender(webmention.WithSendWindows(outbox, open)).ProcessOutbox(ctx)
+	select {
+	case got := <-posted:
+		if got != target.String() {
+			t.Errorf("incorrect target posted: %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("deferred mention not posted once the window opened")
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(must(outbox.Pending())) != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("delivered mention still pending: %+v", must(outbox.Pending()))
+		}
+	}
+}
+
+func TestOutboxKeepsDeliveriesUntilDone(t *testing.T) {
+	path := t.TempDir() + "/outbox.json"
+	outbox := webmention.NewJSONFileOutbox(path)
+	delivery := webmention.PendingDelivery{
+		Source:   "https://example.com/source",
+		Target:   "https://example.org/target",
+		Endpoint: "https://example.org/webmention",
+		Deferred: time.Now(),
+	}
+	if err := outbox.Defer(delivery); err != nil {
+		t.Fatal(err)
+	}
+	pending := must(outbox.Pending())
+	if len(pending) != 1 {
+		t.Fatalf("incorrect pending deliveries: %+v", pending)
+	}
+	// the process dies before the delivery is posted
+	restarted := webmention.NewJSONFileOutbox(path)
+	if pending := must(restarted.Pending()); len(pending) != 1 || pending[0].Target != delivery.Target {
+		t.Fatalf("delivery lost after a crash: %+v", pending)
+	}
+
+	retry := pending[0]
+	retry.Attempts++
+	retry.Deferred = time.Now()
+	if err := restarted.Defer(retry); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Done(pending[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pending := must(restarted.Pending()); len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Fatalf("retry removed with the delivery it replaced: %+v", pending)
+	}
+	if err := restarted.Done(must(restarted.Pending())[0]); err != nil {
+		t.Fatal(err)
+	}
+	if pending := must(restarted.Pending()); len(pending) != 0 {
+		t.Errorf("delivery still pending once done: %+v", pending)
+	}
+}
+
+func TestDeliveryBudget(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	var failing atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			current := maxInFlight.Load()
+			if n <= current || maxInFlight.CompareAndSwap(current, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse("https://example.com/source"))
+	target := func(i int) webmention.URL {
+		return must(url.Parse(fmt.Sprintf("%s/target/%d", ts.URL, i)))
+	}
+
+	outbox := &webmention.MemoryOutbox{}
+	sender := webmention.NewSender(
+		webmention.WithDeliveryBudget(webmention.DeliveryBudget{MaxInFlight: 1, MaxDailyRetries: 1}),
+		webmention.WithSendWindows(outbox),
+		webmention.WithServerErrorRetries(0, 0), // only retry through the outbox
+	)
+	errs := make(chan error, 3)
+	for i := range 3 {
+		go func() {
+			errs <- sender.Mention(source, target(i))
+		}()
+	}
+	for range 3 {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := maxInFlight.Load(); n != 1 {
+		t.Errorf("mentions posted at once, got: %d, want: 1", n)
+	}
+
+	failing.Store(true)
+	results := must(sender.NewBatch(source).UpdateResults(nil, []webmention.URL{target(0)}))
+	if !results[0].Deferred || results[0].Err != nil {
+		t.Errorf("failed mention not deferred to be retried: %+v", results)
+	}
+	if err := sender.Mention(source, target(1)); err == nil {
+		t.Error("failed mention deferred beyond the retry budget")
+	}
+	pending := must(outbox.Pending())
+	if len(pending) != 1 || pending[0].Target != target(0).String() || pending[0].Attempts != 1 {
+		t.Errorf("incorrect pending retries: %+v", pending)
+	}
+	if len(pending) == 1 && !pending[0].NextAttempt.Equal(pending[0].Deferred.Add(webmention.RetryBackoff(1))) {
+		t.Errorf("retry not backed off: %+v", pending[0])
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{1: 5 * time.Minute, 2: 10 * time.Minute, 4: 40 * time.Minute, 20: 24 * time.Hour} {
+		if actual := webmention.RetryBackoff(attempts); actual != expected {
+			t.Errorf("%d attempts: expected a backoff of %s, got %s", attempts, expected, actual)
+		}
+	}
+
+	posted := make(chan string, 2)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		posted <- r.PostForm.Get("target")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	outbox := &webmention.MemoryOutbox{}
+	waiting := webmention.PendingDelivery{
+		Source:      "https://example.com/source",
+		Target:      "https://example.org/waiting",
+		Endpoint:    ts.URL + "/webmention",
+		Deferred:    time.Now(),
+		Attempts:    1,
+		NextAttempt: time.Now().Add(time.Hour),
+	}
+	exhausted := webmention.PendingDelivery{
+		Source:   "https://example.com/source",
+		Target:   "https://example.org/exhausted",
+		Endpoint: ts.URL + "/webmention",
+		Deferred: time.Now(),
+		Attempts: 2,
+	}
+	for _, delivery := range []webmention.PendingDelivery{waiting, exhausted} {
+		if err := outbox.Defer(delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sender := webmention.NewSender(
+		webmention.WithDeliveryBudget(webmention.DeliveryBudget{MaxDailyRetries: 10, MaxAttempts: 2}),
+		webmention.WithSendWindows(outbox),
+		webmention.WithServerErrorRetries(0, 0),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sender.ProcessOutbox(ctx)
+	select {
+	case got := <-posted:
+		if got != exhausted.Target {
+			t.Fatalf("mention retried before its next attempt: %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending mention not retried")
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(must(outbox.Pending())) != 1; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("mention not given up on after too many attempts: %+v", must(outbox.Pending()))
+		}
+	}
+	if pending := must(outbox.Pending()); pending[0].Target != waiting.Target {
+		t.Errorf("incorrect pending retries: %+v", pending)
+	}
+}
+
+func TestServerErrorRetries(t *testing.T) {
+	var mu sync.Mutex
+	posts := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		target := must(url.Parse(r.FormValue("target")))
+		mu.Lock()
+		posts[target.Path]++
+		n := posts[target.Path]
+		mu.Unlock()
+		switch {
+		case target.Path == "/target/flaky" && n <= 2:
+			w.WriteHeader(http.StatusBadGateway)
+		case target.Path == "/target/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case target.Path == "/target/invalid":
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse("https://example.com/source"))
+	target := func(path string) webmention.URL {
+		return must(url.Parse(ts.URL + path))
+	}
+
+	sender := webmention.NewSender(webmention.WithServerErrorRetries(2, time.Millisecond))
+	results, _ := sender.UpdateResults(source, nil, []webmention.URL{target("/target/flaky"), target("/target/broken"), target("/target/invalid")})
+	for _, result := range results {
+		var sent bool
+		switch result.Target.Path {
+		case "/target/flaky":
+			sent = true
+		case "/target/broken":
+			if result.Err == nil || !strings.Contains(result.Err.Error(), "after 3 attempts") {
+				t.Errorf("final outcome not reported: %v", result.Err)
+			}
+		}
+		if result.Sent != sent {
+			t.Errorf("%s: sent: %t, want: %t (%v)", result.Target, result.Sent, sent, result.Err)
+		}
+	}
+	expected := map[string]int{"/target/flaky": 3, "/target/broken": 3, "/target/invalid": 1}
+	if !maps.Equal(posts, expected) {
+		t.Errorf("incorrect number of posts, got: %v, want: %v", posts, expected)
+	}
+}
+
+func TestRediscoveryAfter(t *testing.T) {
+	var discoveries atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		discoveries.Add(1)
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	source := must(url.Parse("https://example.com/source"))
+	target := must(url.Parse(ts.URL + "/target"))
+
+	persister := webmention.NewMemoryPersister()
+	sender := webmention.NewSender(webmention.WithPersister(persister))
+	if _, ok, _ := sender.KnownEndpoint(target); ok {
+		t.Fatal("endpoint known before discovery")
+	}
+	for range 2 {
+		if err := sender.Update(source, nil, []webmention.URL{target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := discoveries.Load(); n != 2 {
+		t.Errorf("endpoint not discovered again without a rediscovery age, discoveries: %d", n)
+	}
+	record, ok, err := sender.KnownEndpoint(target)
+	if err != nil || !ok || record.Endpoint != ts.URL+"/webmention" || time.Since(record.Discovered) > time.Minute {
+		t.Errorf("incorrect endpoint record: %+v, %t, %v", record, ok, err)
+	}
+
+	discoveries.Store(0)
+	sender = webmention.NewSender(webmention.WithPersister(persister), webmention.WithRediscoveryAfter(time.Hour))
+	for range 2 {
+		if err := sender.Update(source, nil, []webmention.URL{target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := discoveries.Load(); n != 0 {
+		t.Errorf("recent endpoint discovered again, discoveries: %d", n)
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"errors"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/emersion/go-msgauth/dkim"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/listener"
+)
+
+type (
+	// Config configures a Service.
+	// Zero values disable a feature, or select the defaults of the
+	// webmention package, nil sub-configurations disable what they
+	// configure.
+	// Paths name files (or directories) that are created as needed.
+	Config struct {
+		// AcceptDomain is the site accepting mentions, e.g.,
+		// https://example.com, mentions of its pages are accepted (required).
+		AcceptDomain *url.URL
+		// Endpoint is the path the endpoint is served on, it is described by
+		// an OpenAPI document at Endpoint/openapi.json (default /api/webmention).
+		Endpoint string
+		// AcceptRules is a file with rules restricting which pages accept
+		// mentions, see webmention.AcceptRules.
+		AcceptRules string
+		// TargetSitemap is a sitemap of the pages accepting mentions, see
+		// webmention.Sitemap.
+		TargetSitemap string
+
+		// Hardening enables restrictive security headers and request size limits.
+		Hardening bool
+		// Alternates also looks for the link in the AMP, mobile, or
+		// canonical version of a source.
+		Alternates bool
+		// CanonicalSources stores mentions under the canonical url of their
+		// source, see webmention.WithCanonicalSources.
+		CanonicalSources bool
+		// LinkWithin and LinkExclude restrict where in a source the link
+		// must be, see webmention.LinkPolicy.
+		LinkWithin, LinkExclude []string
+		SelfMentions            webmention.SelfMentions
+		DuplicateArguments      webmention.DuplicateArguments
+		SourceFetch             webmention.FetchStrategy
+		DisableWallDetection    bool
+		SelfDescription         bool
+		// Retries is how often mentions that failed processing are
+		// retried, the first time after RetryDelay, doubling for every
+		// further retry.
+		Retries    int
+		RetryDelay time.Duration
+		// FetchLocalAddr is the local address sources are fetched from.
+		FetchLocalAddr netip.Addr
+		// FetchProxy is a proxy sources are fetched through, e.g.,
+		// socks5://localhost:9050 for Tor.
+		FetchProxy *url.URL
+		// HTTPCache caches fetched sources in memory, if set to "memory", or
+		// else in this directory, see webmention.HTTPCache.
+		HTTPCache string
+		// SourceSnapshots replays sources from this directory, instead of
+		// fetching them, or records them to it, if RecordSnapshots is set.
+		SourceSnapshots string
+		RecordSnapshots bool
+
+		// StorageFile persists processed mentions, see webmention.JSONFileStorage.
+		StorageFile string
+		// QueueFile keeps received mentions until they are processed, see
+		// webmention.FileQueue, it cannot be used with Redis.
+		QueueFile string
+		// DeadLetters keeps mentions that failed even after retrying.
+		DeadLetters string
+		// NotificationLog remembers which notifications were sent, Redis is
+		// used if not set.
+		NotificationLog string
+		// Redis shares the queue, rate limiting, and notification log with
+		// other instances.
+		Redis *RedisConfig
+
+		// SpamFilter holds mentions that look like spam for moderation,
+		// in ModerationQueue, or in memory if not set.
+		SpamFilter      bool
+		ModerationQueue string
+		// DomainBlocklists and IPBlocklists are DNSBL zones, sources whose
+		// domain was rejected MaxRejections times more often than accepted
+		// are rejected, see webmention.ReputationChecker.
+		DomainBlocklists, IPBlocklists []string
+		MaxRejections                  int
+
+		// Mail notifies about mentions by mail.
+		Mail *MailConfig
+		// ExecHook runs this command for every mention, see listener.ExecHook.
+		ExecHook            []string
+		ExecHookTimeout     time.Duration
+		ExecHookConcurrency int
+		// ExecHookEnv names the environment variables passed to ExecHook,
+		// besides PATH and HOME.
+		ExecHookEnv []string
+		// DataDir writes the mentions as data files of a static site
+		// generator, committed with git if DataGit is set, and pushed if
+		// DataGitPush is, see listener.DataFiles.
+		DataDir              string
+		DataGit, DataGitPush bool
+		// Issue comments on an issue for every mention.
+		Issue *listener.IssueCommenter
+		// Micropub creates a post for notable mentions.
+		Micropub *listener.MicropubPoster
+		// Relay forwards verified mentions to this endpoint.
+		Relay *url.URL
+		// PluginsDir starts the plugins in this directory, see webmention.LoadPlugins.
+		PluginsDir string
+
+		// UsageFile accounts the monthly usage, limited by Quota.
+		UsageFile string
+		Quota     webmention.UsageQuota
+		// Tenants hosts the mentions of other sites.
+		Tenants *TenantsConfig
+		// WidgetPath serves an embeddable widget showing the mentions of a
+		// page under this path, e.g., /widget (requires StorageFile).
+		WidgetPath string
+		// AnalyticsPath serves aggregate stats of the mentions, that are safe
+		// to share on a public stats page, under this path, e.g., /stats,
+		// as much as Analytics allows (requires StorageFile).
+		AnalyticsPath string
+		Analytics     webmention.AnalyticsPolicy
+		// WellKnown serves the policy at webmention.WellKnownPath, the
+		// endpoint defaults to Endpoint on AcceptDomain.
+		WellKnown *webmention.WellKnownPolicy
+		// ProbeNotifiers checks on start whether the notifiers work, see
+		// Receiver.ProbeNotifiers.
+		ProbeNotifiers bool
+	}
+
+	// RedisConfig configures the connection to a Redis 6.2+ server.
+	RedisConfig struct {
+		Addr, Password string
+		// Instance is the unique and stable name of this instance, used to
+		// recover unprocessed mentions after a crash (default hostname).
+		Instance string
+	}
+
+	// MailConfig configures notifications by mail.
+	// Exactly one of External and Internal must be set, they are used as a
+	// template, the service sets their SubjectLine and Body.
+	MailConfig struct {
+		External *listener.ExternalMailer
+		Internal *listener.InternalMailer
+		// DKIM signs the mails sent by Internal.
+		DKIM *dkim.SignOptions
+		// Batch decides when collected mentions are sent.
+		Batch listener.BatchPolicy
+		// Queue keeps mails whose delivery failed temporarily, to retry
+		// them for RetryPeriod, even after a restart.
+		Queue       string
+		RetryPeriod time.Duration
+		// ReactionThreshold collapses this many or more reactions to the
+		// same post into a single line, see listener.CollapseReactions.
+		ReactionThreshold int
+		// SummaryReport additionally sends a report every SummaryPeriod
+		// (requires StorageFile).
+		SummaryReport bool
+		SummaryPeriod listener.SummaryPeriod
+		// ModerationSecret additionally mails the mentions held for
+		// moderation (see Config.SpamFilter), in batches like the others,
+		// with links signed by it that approve or reject them, see
+		// webmention.ModerationLinks. The links are served under Endpoint,
+		// and expire after ModerationLinkExpiry.
+		ModerationSecret     string
+		ModerationLinkExpiry time.Duration
+	}
+
+	// TenantsConfig configures the hosting of other sites' mentions, see
+	// webmention.TenantHost.
+	TenantsConfig struct {
+		// Dir keeps the tenants and their mentions.
+		Dir string
+		// Path is where the tenants are served (default /hosted).
+		Path         string
+		Registration webmention.Registration
+		// Quota is how many mentions a tenant may receive per day.
+		Quota int
+		// OperatorToken authorizes the operator (bearer token).
+		OperatorToken string
+	}
+)
+
+const (
+	defaultEndpoint    = "/api/webmention"
+	defaultTenantsPath = "/hosted"
+	// fileQueueSize is how many mentions the QueueFile holds, as many as
+	// the in-memory queue.
+	fileQueueSize = 100
+)
+
+// Mailer creates the mailer sending mails with the given subject line and body.
+func (c MailConfig) Mailer(subjectLine, body func([]webmention.Mention) string) (listener.Sender, error) {
+	switch {
+	case c.External != nil && c.Internal != nil:
+		return nil, errors.New("mail: only one of External and Internal may be set")
+	case c.External != nil:
+		mailer := *c.External
+		mailer.SubjectLine, mailer.Body = subjectLine, body
+		return mailer, nil
+	case c.Internal != nil:
+		mailer := *c.Internal
+		mailer.SubjectLine, mailer.Body = subjectLine, body
+		if c.DKIM != nil {
+			return listener.InternalDKIMMailer{InternalMailer: mailer, DkimSignOpts: c.DKIM}, nil
+		}
+		return mailer, nil
+	}
+	return nil, errors.New("mail: neither External nor Internal set")
+}
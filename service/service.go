@@ -0,0 +1,518 @@
+// Package service assembles a receiver with all the features of mentionee
+// (storage, queues, moderation, notifiers, tenants, ...) from a Config, for
+// Go programs that want to run the same service without running the
+// binary:
+//
+//	svc, err := service.New(service.Config{
+//		AcceptDomain: must(url.Parse("https://example.com")),
+//		StorageFile:  "mentions.jsonl",
+//		Hardening:    true,
+//	})
+//	if err != nil {
+//		...
+//	}
+//	if err := svc.Start(); err != nil {
+//		...
+//	}
+//	defer svc.Shutdown(context.Background())
+//	http.ListenAndServe(":8080", svc.Handler())
+//
+// Mentionee itself is a thin layer mapping its environment variables to a
+// Config.
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/listener"
+	"github.com/cvanloo/gowebmention/redis"
+)
+
+// Service is a receiver together with everything running alongside it.
+type Service struct {
+	// Receiver processes the mentions, it can be used right away, e.g., to
+	// replay or moderate mentions, Start starts processing new ones.
+	Receiver *webmention.Receiver
+	// Storage is nil, unless StorageFile is configured.
+	Storage webmention.Storage
+
+	config     Config
+	shared     []webmention.ReceiverOption // options also applied to tenants
+	tenants    *webmention.TenantHost
+	aggregator *listener.Batcher
+	// moderationMail collects the mentions held for moderation
+	moderationMail  *listener.Batcher
+	moderationLinks *webmention.ModerationLinks
+	mailQueue       *listener.MailQueue
+	summarizer      *listener.Summarizer
+	plugins         []*webmention.Plugin
+	redisQueue      *redis.Queue
+	usage           webmention.UsageStore
+}
+
+// New assembles the service configured by cfg, opts are applied to the
+// receiver after those of the configuration.
+// Configuration errors are reported with the name of the field of Config.
+func New(cfg Config, opts ...webmention.ReceiverOption) (*Service, error) {
+	if cfg.AcceptDomain == nil {
+		return nil, errors.New("AcceptDomain: required")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultEndpoint
+	}
+	s := &Service{config: cfg}
+	options, err := s.options()
+	if err != nil {
+		s.closePlugins()
+		return nil, err
+	}
+	s.Receiver = webmention.NewReceiver(append(options, opts...)...)
+	return s, nil
+}
+
+// options returns the receiver options of the configuration, setting up
+// the parts of the service they need.
+func (s *Service) options() (options []webmention.ReceiverOption, err error) {
+	cfg := s.config
+	acceptDomain := cfg.AcceptDomain
+	accepts := webmention.TargetAcceptsFunc(func(source, target *url.URL) bool {
+		return target.Scheme == acceptDomain.Scheme && target.Host == acceptDomain.Host
+	})
+	if cfg.AcceptRules != "" {
+		f, err := os.Open(cfg.AcceptRules)
+		if err != nil {
+			return nil, fmt.Errorf("AcceptRules: %w", err)
+		}
+		rules, err := webmention.ParseAcceptRules(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("AcceptRules: %s: %w", cfg.AcceptRules, err)
+		}
+		accepts = rules.Compile(accepts)
+	}
+	options = append(options, webmention.WithAcceptsFunc(accepts))
+	if cfg.TargetSitemap != "" {
+		f, err := os.Open(cfg.TargetSitemap)
+		if err != nil {
+			return nil, fmt.Errorf("TargetSitemap: %w", err)
+		}
+		sitemap, err := webmention.ParseSitemap(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("TargetSitemap: %s: %w", cfg.TargetSitemap, err)
+		}
+		options = append(options, webmention.WithTargetResolver(sitemap))
+	}
+	if cfg.Hardening {
+		s.shared = append(s.shared, webmention.WithHardening())
+	}
+	if cfg.Alternates {
+		s.shared = append(s.shared, webmention.WithAlternates())
+	}
+	if cfg.CanonicalSources {
+		s.shared = append(s.shared, webmention.WithCanonicalSources())
+	}
+	if cfg.AnalyticsPath != "" {
+		s.shared = append(s.shared, webmention.WithAnalyticsPolicy(cfg.Analytics))
+	}
+	if len(cfg.LinkWithin) > 0 || len(cfg.LinkExclude) > 0 {
+		policy := webmention.LinkPolicy{Within: cfg.LinkWithin, Exclude: cfg.LinkExclude}
+		s.shared = append(s.shared, webmention.WithMediaHandler("text/html", 1.0, policy.Handler()))
+	}
+	if cfg.SelfMentions != webmention.RejectSelfMentions {
+		s.shared = append(s.shared, webmention.WithSelfMentions(cfg.SelfMentions))
+	}
+	if cfg.DuplicateArguments != webmention.RejectDuplicateArguments {
+		s.shared = append(s.shared, webmention.WithDuplicateArguments(cfg.DuplicateArguments))
+	}
+	switch cfg.SourceFetch {
+	case "", webmention.FetchHeadThenGet:
+	case webmention.FetchGetOnly, webmention.FetchAuto:
+		s.shared = append(s.shared, webmention.WithSourceFetch(cfg.SourceFetch))
+	default:
+		return nil, fmt.Errorf("SourceFetch: expected head-get, get, or auto, got: %s", cfg.SourceFetch)
+	}
+	if cfg.DisableWallDetection {
+		s.shared = append(s.shared, webmention.WithWallDetection(false))
+	}
+	if cfg.SelfDescription {
+		s.shared = append(s.shared, webmention.WithSelfDescription())
+	}
+	if cfg.StorageFile != "" {
+		s.Storage = webmention.NewJSONFileStorage(cfg.StorageFile)
+		options = append(options, webmention.WithStorage(s.Storage))
+	}
+	if cfg.QueueFile != "" {
+		if cfg.Redis != nil {
+			return nil, errors.New("QueueFile: cannot be used with Redis")
+		}
+		queue, err := webmention.NewFileQueue(cfg.QueueFile, fileQueueSize)
+		if err != nil {
+			return nil, fmt.Errorf("QueueFile: %w", err)
+		}
+		options = append(options, webmention.WithQueue(queue))
+	}
+	if cfg.Redis != nil {
+		client := redis.NewClient(cfg.Redis.Addr)
+		client.Password = cfg.Redis.Password
+		instance := cfg.Redis.Instance
+		if instance == "" {
+			instance, err = os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("Redis: Instance not set: %w", err)
+			}
+		}
+		s.redisQueue = redis.NewQueue(client, instance)
+		store := redis.NewStore(client)
+		options = append(options,
+			webmention.WithQueue(s.redisQueue),
+			webmention.WithMentionCache(store),
+			webmention.WithSourceEndpointLog(&webmention.KeyValuePersister{Store: store}),
+		)
+		if cfg.NotificationLog == "" {
+			options = append(options, webmention.WithNotificationLog(&webmention.KeyValueNotificationLog{
+				Store: store,
+				TTL:   30 * 24 * time.Hour,
+			}))
+		}
+	}
+	if cfg.NotificationLog != "" {
+		options = append(options, webmention.WithNotificationLog(webmention.NewFileNotificationLog(cfg.NotificationLog)))
+	}
+	s.shared = append(s.shared, webmention.WithRetries(cfg.Retries, cfg.RetryDelay))
+	if cfg.DeadLetters != "" {
+		options = append(options, webmention.WithDeadLetters(webmention.NewFileDeadLetterStore(cfg.DeadLetters)))
+	}
+	if cfg.FetchLocalAddr.IsValid() {
+		s.shared = append(s.shared, webmention.WithFetchLocalAddr(cfg.FetchLocalAddr))
+	}
+	if cfg.SourceSnapshots != "" {
+		if cfg.RecordSnapshots {
+			options = append(options, webmention.WithSourceRecording(cfg.SourceSnapshots))
+		} else {
+			options = append(options, webmention.WithSourceFetcher(webmention.SnapshotFetcher{Dir: cfg.SourceSnapshots}))
+		}
+	}
+	if cfg.FetchProxy != nil {
+		s.shared = append(s.shared, webmention.WithFetchProxy(cfg.FetchProxy))
+	}
+	switch cfg.HTTPCache {
+	case "":
+	case "memory":
+		s.shared = append(s.shared, webmention.WithFetchCache(webmention.NewHTTPCache(webmention.NewMemoryKeyValueStore())))
+	default:
+		store, err := webmention.NewFileKeyValueStore(cfg.HTTPCache)
+		if err != nil {
+			return nil, fmt.Errorf("HTTPCache: %w", err)
+		}
+		s.shared = append(s.shared, webmention.WithFetchCache(webmention.NewHTTPCache(store)))
+	}
+	if cfg.SpamFilter {
+		var queue webmention.ModerationQueue = &webmention.MemoryModerationQueue{}
+		if cfg.ModerationQueue != "" {
+			queue = webmention.NewFileModerationQueue(cfg.ModerationQueue)
+		}
+		moderators := []webmention.Notifier{webmention.NotifierFunc(func(mention webmention.Mention) {
+			slog.Warn("mention held for moderation",
+				"id", mention.ID,
+				"source", mention.Source.String(),
+				"target", mention.Target.String(),
+				"spam_score", mention.SpamScore,
+			)
+		})}
+		if cfg.Mail != nil && cfg.Mail.ModerationSecret != "" {
+			s.moderationLinks = &webmention.ModerationLinks{
+				BaseURL: cfg.AcceptDomain.JoinPath(cfg.Endpoint).String(),
+				Secret:  []byte(cfg.Mail.ModerationSecret),
+				Expiry:  cfg.Mail.ModerationLinkExpiry,
+			}
+			mailer, err := cfg.Mail.Mailer(listener.ModerationSubjectLine, listener.ModerationLinksBody(*s.moderationLinks, listener.DefaultBody))
+			if err != nil {
+				return nil, err
+			}
+			s.moderationMail = listener.NewBatcher(mailer, cfg.Mail.Batch)
+			moderators = append(moderators, s.moderationMail)
+		}
+		options = append(options,
+			webmention.WithSpamScorer(webmention.NewHeuristicScorer(), 0.5),
+			webmention.WithModeration(queue, moderators...),
+		)
+	} else if cfg.Mail != nil && cfg.Mail.ModerationSecret != "" {
+		return nil, errors.New("Mail: ModerationSecret requires SpamFilter")
+	}
+	if len(cfg.DomainBlocklists) > 0 || len(cfg.IPBlocklists) > 0 || cfg.MaxRejections > 0 {
+		checker := webmention.NewReputationChecker(webmention.NewMemoryReputationStore(), cfg.MaxRejections)
+		checker.DomainBlocklists = cfg.DomainBlocklists
+		checker.IPBlocklists = cfg.IPBlocklists
+		options = append(options, webmention.WithReputation(checker))
+	}
+	notifiers, err := s.notifiers()
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, notifiers...)
+	if cfg.PluginsDir != "" {
+		plugins, err := webmention.LoadPlugins(cfg.PluginsDir)
+		if err != nil && len(plugins) == 0 {
+			return nil, fmt.Errorf("PluginsDir: %w", err)
+		}
+		if err != nil {
+			slog.Error(fmt.Sprintf("PluginsDir: some plugins failed to start: %s", err))
+		}
+		s.plugins = plugins
+		options = append(options, webmention.WithPlugins(plugins...))
+	}
+	if cfg.UsageFile != "" {
+		s.usage = webmention.NewJSONFileUsageStore(cfg.UsageFile)
+		options = append(options, webmention.WithUsageMeter(&webmention.UsageMeter{
+			Store: s.usage,
+			Quota: cfg.Quota,
+		}))
+	} else if cfg.Quota != (webmention.UsageQuota{}) {
+		return nil, errors.New("Quota: requires UsageFile")
+	}
+	return slices.Concat(s.shared, options), nil
+}
+
+// notifiers returns the options registering the configured notifiers.
+func (s *Service) notifiers() (options []webmention.ReceiverOption, err error) {
+	cfg := s.config
+	if cfg.Mail != nil {
+		mail := cfg.Mail
+		mailer, err := mail.Mailer(
+			listener.CollapsedSubjectLine(mail.ReactionThreshold, listener.DefaultSubjectLine),
+			listener.CollapsedBody(mail.ReactionThreshold, listener.DefaultBody),
+		)
+		if err != nil {
+			return nil, err
+		}
+		queue, err := listener.NewMailQueue(mailer, mail.Queue)
+		if err != nil {
+			return nil, fmt.Errorf("Mail: Queue: %w", err)
+		}
+		queue.MaxAge = mail.RetryPeriod
+		s.mailQueue = queue
+		s.aggregator = listener.NewBatcher(queue, mail.Batch)
+		options = append(options, webmention.WithNotifier(listener.Mailer{Sender: s.aggregator}))
+		if mail.SummaryReport {
+			if s.Storage == nil {
+				return nil, errors.New("Mail: SummaryReport requires StorageFile")
+			}
+			mailer, err := mail.Mailer(listener.SummarySubjectLine, listener.SummaryBody)
+			if err != nil {
+				return nil, err
+			}
+			s.summarizer = listener.NewSummarizer(mail.SummaryPeriod, s.Storage, mailer)
+		}
+	}
+	if len(cfg.ExecHook) > 0 {
+		hook := listener.NewExecHook(cfg.ExecHook, cfg.ExecHookTimeout, cfg.ExecHookConcurrency)
+		hook.PassEnv = cfg.ExecHookEnv
+		options = append(options, webmention.WithNotifier(hook))
+	}
+	if cfg.DataDir != "" {
+		files := listener.NewDataFiles(cfg.DataDir)
+		files.Git = cfg.DataGit
+		files.Push = cfg.DataGitPush
+		options = append(options, webmention.WithNotifier(files))
+	}
+	if cfg.Issue != nil {
+		if cfg.Issue.Repo == "" || cfg.Issue.Issue <= 0 || cfg.Issue.Token == "" {
+			return nil, errors.New("Issue: requires Repo, Issue, and Token")
+		}
+		options = append(options, webmention.WithNotifier(cfg.Issue))
+	}
+	if cfg.Micropub != nil {
+		if cfg.Micropub.Endpoint == "" || cfg.Micropub.Token == "" {
+			return nil, errors.New("Micropub: requires Endpoint and Token")
+		}
+		options = append(options, webmention.WithNotifier(cfg.Micropub))
+	}
+	if cfg.Relay != nil {
+		options = append(options, webmention.WithRelay(cfg.Relay))
+	}
+	return options, nil
+}
+
+// Start starts processing mentions, and everything running alongside the
+// receiver: the mail batches and queue, summary reports, and tenants.
+func (s *Service) Start() error {
+	if s.redisQueue != nil {
+		n, err := s.redisQueue.Recover(context.Background())
+		if err != nil {
+			slog.Error("failed to recover unprocessed mentions", "error", err)
+		} else if n > 0 {
+			slog.Info("recovered unprocessed mentions", "count", n)
+		}
+	}
+	if s.aggregator != nil {
+		go s.aggregator.Start()
+	}
+	if s.moderationMail != nil {
+		go s.moderationMail.Start()
+	}
+	if s.mailQueue != nil {
+		go s.mailQueue.Start()
+	}
+	if s.summarizer != nil {
+		go s.summarizer.Start()
+	}
+	if s.config.ProbeNotifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if failures := s.Receiver.ProbeNotifiers(ctx); len(failures) > 0 {
+			slog.Warn("some notifiers failed their probe, see /readyz", "count", len(failures))
+		}
+		cancel()
+	}
+	go s.Receiver.ProcessMentions()
+	if s.config.Tenants != nil {
+		tenants, err := s.startTenants()
+		if err != nil {
+			return err
+		}
+		s.tenants = tenants
+	}
+	return nil
+}
+
+// startTenants starts the tenants configured by Tenants.
+func (s *Service) startTenants() (*webmention.TenantHost, error) {
+	cfg := *s.config.Tenants
+	if cfg.Path == "" {
+		cfg.Path = defaultTenantsPath
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("Tenants: %w", err)
+	}
+	host, err := webmention.NewTenantHost(webmention.NewJSONFileTenantStore(filepath.Join(cfg.Dir, "tenants.json")),
+		webmention.WithTenantMountPoint(cfg.Path),
+		webmention.WithTenantReceiverOptions(s.shared...),
+		webmention.WithTenantQuota(cfg.Quota),
+		webmention.WithRegistration(cfg.Registration),
+		webmention.WithTenantUsage(s.usage, s.config.Quota),
+		webmention.WithTenantStorage(func(id string) (webmention.Storage, error) {
+			return webmention.NewJSONFileStorage(filepath.Join(cfg.Dir, id+".jsonl")), nil
+		}),
+		webmention.WithOperatorAuth(func(r *http.Request) bool {
+			token := cfg.OperatorToken
+			return token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Tenants: %w", err)
+	}
+	return host, nil
+}
+
+// Handler serves the endpoint, its OpenAPI document, /readyz, and the
+// moderation links, widget, analytics, tenants, and well-known policy, if
+// configured.
+// Call it after Start, for the tenants to be served.
+func (s *Service) Handler() http.Handler {
+	cfg := s.config
+	mux := &http.ServeMux{}
+	mux.Handle(cfg.Endpoint, s.Receiver)
+	mux.Handle("GET "+strings.TrimSuffix(cfg.Endpoint, "/")+"/openapi.json", webmention.NewReceiverHandler(s.Receiver,
+		webmention.WithMountPoint(cfg.Endpoint),
+		webmention.WithWebmentionPath(cfg.Endpoint),
+		webmention.WithRoutes(webmention.RouteWebmention|webmention.RouteOpenAPI),
+	))
+	mux.Handle("GET /readyz", webmention.NewReceiverHandler(s.Receiver, webmention.WithRoutes(webmention.RouteReady)))
+	if cfg.WidgetPath != "" {
+		// embed with: <script src="https://.../widget/widget.js" async></script>
+		widgetPath := strings.TrimSuffix(cfg.WidgetPath, "/")
+		mux.Handle(widgetPath+"/", webmention.NewReceiverHandler(s.Receiver,
+			webmention.WithMountPoint(widgetPath),
+			webmention.WithRoutes(webmention.RouteWidget),
+		))
+	}
+	if s.moderationLinks != nil {
+		mux.Handle(strings.TrimSuffix(cfg.Endpoint, "/")+"/moderate/", webmention.NewReceiverHandler(s.Receiver,
+			webmention.WithMountPoint(cfg.Endpoint),
+			webmention.WithRoutes(webmention.RouteModerationLinks),
+			webmention.WithModerationLinks(*s.moderationLinks),
+		))
+	}
+	if cfg.AnalyticsPath != "" {
+		// fetch from a stats page: https://.../stats/analytics?since=2006-01-02
+		analyticsPath := strings.TrimSuffix(cfg.AnalyticsPath, "/")
+		mux.Handle(analyticsPath+"/", webmention.NewReceiverHandler(s.Receiver,
+			webmention.WithMountPoint(analyticsPath),
+			webmention.WithRoutes(webmention.RouteAnalytics),
+		))
+	}
+	if s.tenants != nil {
+		path := cfg.Tenants.Path
+		if path == "" {
+			path = defaultTenantsPath
+		}
+		mux.Handle(strings.TrimSuffix(path, "/")+"/", s.tenants)
+	}
+	if cfg.WellKnown != nil {
+		policy := *cfg.WellKnown
+		if policy.Endpoint == "" {
+			policy.Endpoint = cfg.AcceptDomain.JoinPath(cfg.Endpoint).String()
+		}
+		mux.Handle(webmention.WellKnownPath, webmention.WellKnownHandler(policy))
+	}
+	mux.Handle("/", http.NotFoundHandler())
+	if cfg.Hardening {
+		return webmention.SecurityHeaders(mux)
+	}
+	return mux
+}
+
+// Flush sends the mentions collected for the next mail right away, e.g.,
+// after replaying mentions, without starting the service.
+func (s *Service) Flush() error {
+	if s.aggregator == nil {
+		return nil
+	}
+	return s.aggregator.Flush()
+}
+
+// Shutdown stops processing mentions, waiting for the queued ones until ctx
+// expires, sends the mentions collected for the next mail, and stops
+// everything else.
+// Stop the http server first.
+func (s *Service) Shutdown(ctx context.Context) {
+	s.Receiver.Shutdown(ctx)
+	if s.tenants != nil {
+		s.tenants.Shutdown(ctx)
+	}
+	if s.aggregator != nil {
+		if err := s.aggregator.Stop(); err != nil {
+			slog.Error(fmt.Sprintf("sending collected mentions failed: %s", err))
+		}
+	}
+	if s.moderationMail != nil {
+		if err := s.moderationMail.Stop(); err != nil {
+			slog.Error(fmt.Sprintf("sending mentions held for moderation failed: %s", err))
+		}
+	}
+	if s.mailQueue != nil {
+		s.mailQueue.Stop()
+	}
+	if s.summarizer != nil {
+		s.summarizer.Stop()
+	}
+	s.closePlugins()
+}
+
+func (s *Service) closePlugins() {
+	for _, plugin := range s.plugins {
+		plugin.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+	"github.com/cvanloo/gowebmention/listener"
+	"github.com/cvanloo/gowebmention/service"
+)
+
+func must[T any](t T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestService(t *testing.T) {
+	mux := http.NewServeMux()
+	site := httptest.NewServer(mux)
+	defer site.Close()
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="%s/post">post</a>`, site.URL)
+	})
+
+	dir := t.TempDir()
+	notified := make(chan webmention.Mention, 1)
+	svc := must(service.New(service.Config{
+		AcceptDomain: must(url.Parse(site.URL)),
+		StorageFile:  filepath.Join(dir, "mentions.jsonl"),
+		QueueFile:    filepath.Join(dir, "queue.json"),
+		WidgetPath:   "/widget",
+	}, webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+		notified <- mention
+	}))))
+	if err := svc.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.Shutdown(context.Background())
+	endpoint := httptest.NewServer(svc.Handler())
+	defer endpoint.Close()
+
+	resp := must(http.PostForm(endpoint.URL+"/api/webmention", url.Values{
+		"source": {site.URL + "/source"},
+		"target": {site.URL + "/post"},
+	}))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("mention not accepted, status: %d", resp.StatusCode)
+	}
+	select {
+	case mention := <-notified:
+		if mention.Status != webmention.StatusLink {
+			t.Errorf("incorrect status, got: %s, want: %s", mention.Status, webmention.StatusLink)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	stored := must(svc.Storage.Mentions(webmention.MentionFilter{}))
+	if len(stored) != 1 {
+		t.Errorf("incorrect number of stored mentions, got: %d, want: 1", len(stored))
+	}
+	for path, want := range map[string]int{
+		"/api/webmention/openapi.json": http.StatusOK,
+		"/widget/widget.js":            http.StatusOK,
+		"/elsewhere":                   http.StatusNotFound,
+	} {
+		resp := must(http.Get(endpoint.URL + path))
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: incorrect status, got: %d, want: %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	site := must(url.Parse("https://example.com"))
+	for name, cfg := range map[string]service.Config{
+		"no accept domain": {},
+		"queue file with redis": {
+			AcceptDomain: site,
+			QueueFile:    filepath.Join(t.TempDir(), "queue.json"),
+			Redis:        &service.RedisConfig{Addr: "localhost:6379", Instance: "test"},
+		},
+		"issue without token":    {AcceptDomain: site, Issue: &listener.IssueCommenter{Repo: "a/b", Issue: 1}},
+		"quota without usage":    {AcceptDomain: site, Quota: webmention.UsageQuota{Received: 10}},
+		"mail without a mailer":  {AcceptDomain: site, Mail: &service.MailConfig{}},
+		"unknown fetch strategy": {AcceptDomain: site, SourceFetch: "sometimes"},
+		"moderation secret without spam filter": {
+			AcceptDomain: site,
+			Mail:         &service.MailConfig{External: &listener.ExternalMailer{}, ModerationSecret: "secret"},
+		},
+	} {
+		if _, err := service.New(cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package webmention
+
+// Experimental support for HTTP Message Signatures (RFC 9421).
+//
+// Sites that trust each other (a closed federation) can exchange ed25519 keys.
+// Mentions signed with a trusted key are still verified, but they skip the
+// spam and reputation checks.
+// A key is only trusted for mentions whose source is on the site it belongs to.
+// Only the subset of the RFC required for webmentions is implemented: the
+// signature always covers the method, target uri, content type and content
+// digest of the request, and only ed25519 keys are supported.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signatureLabel = "wm"
+	// maxSignatureAge is how old a signature may get before it is no longer accepted.
+	maxSignatureAge = 5 * time.Minute
+	// maxSignedBodySize limits the body buffered to verify a signature,
+	// the same limit the form parser applies.
+	maxSignedBodySize = 10 << 20
+)
+
+var (
+	signatureInputRegex = regexp.MustCompile(`^` + signatureLabel + `=(\([^)]*\));created=(\d+);keyid="([^"]*)";alg="ed25519"$`)
+	signatureRegex      = regexp.MustCompile(`^` + signatureLabel + `=:([A-Za-z0-9+/=]+):$`)
+	coveredComponents   = `("@method" "@target-uri" "content-type" "content-digest")`
+)
+
+// WithSigningKey signs every outgoing webmention with key.
+// keyID must be the id the receiver knows the corresponding public key under.
+func WithSigningKey(keyID string, key ed25519.PrivateKey) SenderOption {
+	return func(s *Sender) {
+		s.signingKeyID = keyID
+		s.signingKey = key
+	}
+}
+
+// TrustedKey is the public key of a trusted site.
+type TrustedKey struct {
+	// Site is the host name of the site, signatures made with Key are only
+	// accepted for mentions whose source is on Site, or one of its subdomains.
+	Site string
+	Key  ed25519.PublicKey
+}
+
+// WithTrustedKeys configures the public keys of trusted senders, by key id.
+// Mentions signed with one of these keys skip the spam and reputation checks
+// (see Mention.SignedBy).
+func WithTrustedKeys(keys map[string]TrustedKey) ReceiverOption {
+	return func(r *Receiver) {
+		r.trustedKeys = keys
+	}
+}
+
+// WithTrustedProxies configures the addresses of reverse proxies in front of
+// the receiver.
+// The X-Forwarded-Proto header is only honoured on requests from one of them,
+// it is needed to reconstruct the uri a signed request was sent to, if the
+// proxy terminates TLS.
+func WithTrustedProxies(proxies ...netip.Prefix) ReceiverOption {
+	return func(r *Receiver) {
+		r.trustedProxies = proxies
+	}
+}
+
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func signatureParams(created int64, keyID string) string {
+	return fmt.Sprintf(`%s;created=%d;keyid="%s";alg="ed25519"`, coveredComponents, created, keyID)
+}
+
+func signatureBase(method, targetURI, contentType, digest, params string) []byte {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "\"@method\": %s\n", method)
+	fmt.Fprintf(&builder, "\"@target-uri\": %s\n", targetURI)
+	fmt.Fprintf(&builder, "\"content-type\": %s\n", contentType)
+	fmt.Fprintf(&builder, "\"content-digest\": %s\n", digest)
+	fmt.Fprintf(&builder, "\"@signature-params\": %s", params)
+	return []byte(builder.String())
+}
+
+func signRequest(req *http.Request, body []byte, keyID string, key ed25519.PrivateKey) error {
+	if strings.ContainsAny(keyID, "\"\\") {
+		return fmt.Errorf("sign request: invalid key id: %q", keyID)
+	}
+	digest := contentDigest(body)
+	params := signatureParams(time.Now().Unix(), keyID)
+	base := signatureBase(req.Method, req.URL.String(), req.Header.Get("Content-Type"), digest, params)
+	sig := ed25519.Sign(key, base)
+	req.Header.Set("Content-Digest", digest)
+	req.Header.Set("Signature-Input", signatureLabel+"="+params)
+	req.Header.Set("Signature", signatureLabel+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+// verifySignature checks the signature of a request, if present.
+// The id of the key the request was signed with is returned if (and only if)
+// the signature is valid, and the key is trusted.
+// Invalid signatures are not an error, the request is simply treated as
+// unsigned, and verified the usual way.
+// An error is only returned if the request body cannot be read, or is
+// larger than WithMaxBodySize, or maxSignedBodySize if not set.
+func (receiver *Receiver) verifySignature(w http.ResponseWriter, r *http.Request) (keyID string, err error) {
+	if len(receiver.trustedKeys) == 0 || r.Header.Get("Signature") == "" {
+		return "", nil
+	}
+	limit := receiver.maxBodySize
+	if limit <= 0 {
+		limit = maxSignedBodySize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body)) // ParseForm still needs it
+
+	log := slog.With("function", "verifySignature", "remote", r.RemoteAddr)
+
+	input := signatureInputRegex.FindStringSubmatch(r.Header.Get("Signature-Input"))
+	sigMatch := signatureRegex.FindStringSubmatch(r.Header.Get("Signature"))
+	if input == nil || sigMatch == nil || input[1] != coveredComponents {
+		log.Info("unsupported signature")
+		return "", nil
+	}
+	created, err := strconv.ParseInt(input[2], 10, 64)
+	if err != nil {
+		log.Info("invalid signature creation time")
+		return "", nil
+	}
+	if age := time.Since(time.Unix(created, 0)); age > maxSignatureAge || age < -maxSignatureAge {
+		log.Info("signature expired", "age", age)
+		return "", nil
+	}
+	trusted, ok := receiver.trustedKeys[input[3]]
+	if !ok {
+		log.Info("signed with unknown key", "key_id", input[3])
+		return "", nil
+	}
+	digest := contentDigest(body)
+	if r.Header.Get("Content-Digest") != digest {
+		log.Info("content digest mismatch")
+		return "", nil
+	}
+	sig, err := base64.StdEncoding.DecodeString(sigMatch[1])
+	if err != nil {
+		log.Info("malformed signature")
+		return "", nil
+	}
+	base := signatureBase(r.Method, receiver.requestURI(r), r.Header.Get("Content-Type"), digest, signatureParams(created, input[3]))
+	if !ed25519.Verify(trusted.Key, base, sig) {
+		log.Info("invalid signature", "key_id", input[3])
+		return "", nil
+	}
+	return input[3], nil
+}
+
+// signedFor reports whether the key keyID is trusted for mentions from source.
+func (receiver *Receiver) signedFor(keyID string, source URL) bool {
+	trusted, ok := receiver.trustedKeys[keyID]
+	if !ok || trusted.Site == "" {
+		return false
+	}
+	host, site := strings.ToLower(source.Hostname()), strings.ToLower(trusted.Site)
+	return host == site || strings.HasSuffix(host, "."+site)
+}
+
+// requestURI reconstructs the absolute uri the client sent the request to.
+// If the receiver runs behind a reverse proxy, the proxy must pass on the
+// Host header, and the X-Forwarded-Proto header if it terminates TLS (in
+// which case it must be configured with WithTrustedProxies).
+func (receiver *Receiver) requestURI(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && receiver.fromTrustedProxy(r) {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+func (receiver *Receiver) fromTrustedProxy(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, proxy := range receiver.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
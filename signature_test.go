@@ -0,0 +1,218 @@
+package webmention_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestSignedMention(t *testing.T) {
+	pub, priv := must2(ed25519.GenerateKey(nil))
+	_, otherPriv := must2(ed25519.GenerateKey(nil))
+	strangerPub, strangerPriv := must2(ed25519.GenerateKey(nil))
+
+	errs := make(chan error, 1)
+	notified := make(chan webmention.Mention, 1)
+	held := make(chan webmention.Mention, 1)
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithTrustedKeys(map[string]webmention.TrustedKey{
+			"friend":   {Site: "127.0.0.1", Key: pub},
+			"stranger": {Site: "example.com", Key: strangerPub},
+		}),
+		webmention.WithReporter(func(err error, mention webmention.Mention) {
+			if err != nil {
+				errs <- err
+			}
+		}),
+		// everything is spam, unless it's signed
+		webmention.WithSpamScorer(webmention.SpamScorerFunc(func(webmention.Mention, []byte) (float64, error) {
+			return 1, nil
+		}), 0.5),
+		webmention.WithModeration(&webmention.MemoryModerationQueue{}, webmention.NotifierFunc(func(mention webmention.Mention) {
+			held <- mention
+		})),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			notified <- mention
+		})),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	mux.Handle("/webmention", receiver)
+	mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</webmention>; rel=webmention")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/source/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+	})
+	mux.HandleFunc("/nolink", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<p>no link here</p>`)
+	})
+
+	target := must(url.Parse(ts.URL + "/target"))
+	next := func() (notifiedMention, heldMention *webmention.Mention) {
+		t.Helper()
+		select {
+		case mention := <-notified:
+			return &mention, nil
+		case mention := <-held:
+			return nil, &mention
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+		return nil, nil
+	}
+
+	signed := webmention.NewSender(webmention.WithSigningKey("friend", priv))
+	if err := signed.Mention(must(url.Parse(ts.URL+"/source/1")), target); err != nil {
+		t.Fatal(err)
+	}
+	if mention, _ := next(); mention == nil || mention.SignedBy != "friend" || mention.Status != webmention.StatusLink {
+		t.Errorf("signed mention not trusted: %+v", mention)
+	}
+
+	if err := signed.Mention(must(url.Parse(ts.URL+"/nolink")), target); err != nil {
+		t.Fatal(err)
+	}
+	if mention, _ := next(); mention == nil || mention.Status != webmention.StatusNoLink {
+		t.Errorf("signed mention not verified: %+v", mention)
+	}
+
+	forged := webmention.NewSender(webmention.WithSigningKey("friend", otherPriv))
+	if err := forged.Mention(must(url.Parse(ts.URL+"/source/2")), target); err != nil {
+		t.Fatal(err)
+	}
+	if _, mention := next(); mention == nil || mention.SignedBy != "" {
+		t.Errorf("mention with invalid signature was trusted: %+v", mention)
+	}
+
+	stranger := webmention.NewSender(webmention.WithSigningKey("stranger", strangerPriv))
+	if err := stranger.Mention(must(url.Parse(ts.URL+"/source/3")), target); err != nil {
+		t.Fatal(err)
+	}
+	if _, mention := next(); mention == nil || mention.SignedBy != "" {
+		t.Errorf("mention signed for another site was trusted: %+v", mention)
+	}
+}
+
+func TestSignatureBehindProxy(t *testing.T) {
+	pub, priv := must2(ed25519.GenerateKey(nil))
+
+	for _, tc := range []struct {
+		name    string
+		proxies []netip.Prefix
+		trusted bool
+	}{
+		{name: "untrusted proxy", trusted: false},
+		{name: "trusted proxy", proxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, trusted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			notified := make(chan webmention.Mention, 1)
+			receiver := webmention.NewReceiver(
+				webmention.WithAcceptsFunc(accepts),
+				webmention.WithTrustedKeys(map[string]webmention.TrustedKey{
+					"friend": {Site: "127.0.0.1", Key: pub},
+				}),
+				webmention.WithTrustedProxies(tc.proxies...),
+				webmention.WithReporter(func(error, webmention.Mention) {}),
+				webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+					notified <- mention
+				})),
+			)
+			go receiver.ProcessMentions()
+			defer receiver.Shutdown(context.Background())
+
+			mux := http.NewServeMux()
+			ts := httptest.NewServer(mux)
+			defer ts.Close()
+			mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprintf(w, `<a href="%s/target">target</a>`, ts.URL)
+			})
+			// the "proxy" terminates TLS, and tells the receiver about it
+			mux.HandleFunc("/webmention", func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Set("X-Forwarded-Proto", "https")
+				receiver.ServeHTTP(w, r)
+			})
+			mux.HandleFunc("/target", func(w http.ResponseWriter, r *http.Request) {
+				// the sender signs the https uri
+				w.Header().Set("Link", "<"+strings.Replace(ts.URL, "http:", "https:", 1)+"/webmention>; rel=webmention")
+			})
+
+			sender := webmention.NewSender(webmention.WithSigningKey("friend", priv))
+			sender.HttpClient = &http.Client{Transport: httpsToHttp{}}
+			if err := sender.Mention(must(url.Parse(ts.URL+"/source")), must(url.Parse(ts.URL+"/target"))); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case mention := <-notified:
+				if trusted := mention.SignedBy != ""; trusted != tc.trusted {
+					t.Errorf("signature trusted: %t, want: %t", trusted, tc.trusted)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+		})
+	}
+}
+
+// httpsToHttp sends https requests over plain http, as if a proxy terminated TLS.
+type httpsToHttp struct{}
+
+func (httpsToHttp) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func must2[T, U any](t T, u U, e error) (T, U) {
+	if e != nil {
+		panic(e)
+	}
+	return t, u
+}
+
+func TestSignedBodyLimit(t *testing.T) {
+	pub, _ := must2(ed25519.GenerateKey(nil))
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithTrustedKeys(map[string]webmention.TrustedKey{"friend": {Key: pub}}),
+	)
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+	for name, testCase := range map[string]struct {
+		size int
+		want int
+	}{
+		"within the limit": {size: 1 << 10, want: http.StatusBadRequest},
+		"over the limit":   {size: 11 << 20, want: http.StatusRequestEntityTooLarge},
+	} {
+		req := must(http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("source="+strings.Repeat("a", testCase.size))))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Signature", "wm=:AAAA:")
+		resp := must(http.DefaultClient.Do(req))
+		resp.Body.Close()
+		if resp.StatusCode != testCase.want {
+			t.Errorf("%s: incorrect status, got: %d, want: %d", name, resp.StatusCode, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package webmention
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Sitemap is a TargetResolver that only knows the pages listed in a
+	// sitemap (https://www.sitemaps.org/protocol.html).
+	// The id of a page is its path.
+	//
+	// Pages can be marked as closed for new mentions with an annotation:
+	//
+	//	<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"
+	//	        xmlns:wm="https://github.com/cvanloo/gowebmention/sitemap">
+	//	  <url>
+	//	    <loc>https://example.com/2019/old-post</loc>
+	//	    <wm:closed>true</wm:closed>
+	//	  </url>
+	//	</urlset>
+	Sitemap struct {
+		pages map[string]sitemapPage
+	}
+
+	sitemapPage struct {
+		id     string
+		closed bool
+	}
+
+	sitemapXML struct {
+		URLs []struct {
+			Loc    string `xml:"loc"`
+			Closed string `xml:"https://github.com/cvanloo/gowebmention/sitemap closed"`
+		} `xml:"url"`
+	}
+)
+
+// *Sitemap implements TargetResolver
+var _ TargetResolver = (*Sitemap)(nil)
+
+// ParseSitemap reads a sitemap in the XML format.
+func ParseSitemap(r io.Reader) (*Sitemap, error) {
+	var doc sitemapXML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("sitemap: %w", err)
+	}
+	sitemap := &Sitemap{pages: map[string]sitemapPage{}}
+	for _, u := range doc.URLs {
+		loc := strings.TrimSpace(u.Loc)
+		target, err := url.Parse(loc)
+		if err != nil {
+			return nil, fmt.Errorf("sitemap: %w", err)
+		}
+		target.Fragment = ""
+		page := sitemapPage{id: target.Path}
+		if closed := strings.TrimSpace(u.Closed); closed != "" {
+			if page.closed, err = strconv.ParseBool(closed); err != nil {
+				return nil, fmt.Errorf("sitemap: %s: closed: %w", loc, err)
+			}
+		}
+		sitemap.pages[target.String()] = page
+	}
+	return sitemap, nil
+}
+
+// Resolve returns the path of target, ErrUnknownTarget if it isn't listed
+// in the sitemap, or ErrTargetClosed if it is annotated as closed.
+func (s *Sitemap) Resolve(target URL) (string, error) {
+	normalized := *target
+	normalized.Fragment = ""
+	page, ok := s.pages[normalized.String()]
+	if !ok {
+		return "", ErrUnknownTarget
+	}
+	if page.closed {
+		return page.id, ErrTargetClosed
+	}
+	return page.id, nil
+}
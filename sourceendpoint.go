@@ -0,0 +1,92 @@
+package webmention
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tomnomnom/linkheader"
+	"golang.org/x/net/html"
+)
+
+// WithSourceEndpointLog records the endpoint advertised by the source of
+// every verified mention in log, e.g., a KeyValuePersister shared with a
+// Sender, which reuses it to mention the source in turn (see
+// WithRediscoveryAfter), instead of discovering it a second time.
+// The endpoint is part of the mention either way (see Mention.SourceEndpoint).
+func WithSourceEndpointLog(log EndpointLog) ReceiverOption {
+	return func(r *Receiver) {
+		r.sourceEndpoints = log
+	}
+}
+
+// advertisedEndpoint returns the Webmention endpoint the fetched source
+// advertises, in a Link header, or in a <link> or <a> element of html
+// sources, resolved against base. It is nil if there is none.
+func advertisedEndpoint(resp *http.Response, base URL, mime string, sourceData []byte) URL {
+	if resp.Request != nil && resp.Request.URL != nil {
+		base = resp.Request.URL
+	}
+	for _, link := range linkheader.ParseMultiple(resp.Header.Values("Link")) {
+		for _, rel := range strings.Fields(link.Rel) {
+			if strings.EqualFold(rel, "webmention") {
+				if endpoint, err := url.Parse(link.URL); err == nil {
+					return base.ResolveReference(endpoint)
+				}
+			}
+		}
+	}
+	if mime != "text/html" {
+		return nil
+	}
+	doc, err := html.Parse(bytes.NewReader(sourceData))
+	if err != nil {
+		return nil
+	}
+	var linkRel, aRel URL
+	var traverse func(node *html.Node)
+	traverse = func(node *html.Node) {
+		if node.Type == html.ElementNode && (node.Data == "link" && linkRel == nil || node.Data == "a" && aRel == nil) {
+			endpoint, err := scanForRelLink(node)
+			if err == nil && node.Data == "link" {
+				linkRel = endpoint
+			} else if err == nil {
+				aRel = endpoint
+			} else if !errors.Is(err, ErrNoRelWebmention) {
+				slog.Debug("ignoring invalid endpoint of source", "source", base.String(), "error", err)
+			}
+		}
+		for child := node.FirstChild; child != nil && linkRel == nil; child = child.NextSibling {
+			traverse(child)
+		}
+	}
+	traverse(doc)
+	switch {
+	case linkRel != nil:
+		return base.ResolveReference(linkRel)
+	case aRel != nil:
+		return base.ResolveReference(aRel)
+	}
+	return nil
+}
+
+// recordSourceEndpoint adds the endpoint of the source of mention to the
+// log, if one is configured. Errors are only logged.
+func (receiver *Receiver) recordSourceEndpoint(mention Mention) {
+	if receiver.sourceEndpoints == nil || mention.SourceEndpoint == nil {
+		return
+	}
+	record := EndpointRecord{
+		Target:     mention.Source.String(),
+		Endpoint:   mention.SourceEndpoint.String(),
+		Discovered: time.Now(),
+	}
+	if err := receiver.sourceEndpoints.RecordEndpoint(record); err != nil {
+		slog.Error(fmt.Sprintf("endpoint log: %s", err), "source", record.Target)
+	}
+}
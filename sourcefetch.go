@@ -0,0 +1,85 @@
+package webmention
+
+import (
+	"fmt"
+	"log/slog"
+	mimelib "mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FetchStrategy decides how a receiver fetches sources to verify mentions.
+type FetchStrategy string
+
+const (
+	// FetchHeadThenGet asks for the content type with a HEAD request
+	// first, and only then fetches the source with GET, accepting just
+	// that type (the default).
+	FetchHeadThenGet FetchStrategy = "head-get"
+	// FetchGetOnly fetches the source with a single GET, and picks the
+	// media handler by the content type of the response.
+	// It halves the requests to the source, which helps with servers (or
+	// CDNs) that rate-limit HEAD requests aggressively.
+	FetchGetOnly FetchStrategy = "get"
+	// FetchAuto starts out like FetchHeadThenGet, but skips the HEAD
+	// request for hosts that refused it (405, 429, 501) for a day.
+	// It is never recorded as Mention.Fetch, the strategy used is.
+	FetchAuto FetchStrategy = "auto"
+)
+
+// headSkipRetention is how long FetchAuto skips the HEAD request for a host that refused it.
+const headSkipRetention = 24 * time.Hour
+
+// WithSourceFetch sets how sources are fetched, by default with a HEAD
+// request followed by a GET.
+// The strategy used is recorded as Mention.Fetch.
+func WithSourceFetch(strategy FetchStrategy) ReceiverOption {
+	return func(r *Receiver) {
+		r.sourceFetch = strategy
+	}
+}
+
+// fetchStrategy returns the strategy to fetch source with.
+func (receiver *Receiver) fetchStrategy(source URL) FetchStrategy {
+	switch receiver.sourceFetch {
+	case FetchGetOnly:
+		return FetchGetOnly
+	case FetchAuto:
+		if _, skip, err := receiver.mentionCache.Get("head-skip:" + strings.ToLower(source.Host)); err == nil && skip {
+			return FetchGetOnly
+		}
+	}
+	return FetchHeadThenGet
+}
+
+// refusesHead reports whether the answer to a HEAD request for source
+// means that it's better skipped, and remembers the host if so (see FetchAuto).
+func (receiver *Receiver) refusesHead(source URL, resp *http.Response) bool {
+	if receiver.sourceFetch != FetchAuto {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed, http.StatusTooManyRequests, http.StatusNotImplemented:
+	default:
+		return false
+	}
+	host := strings.ToLower(source.Host)
+	if err := receiver.mentionCache.Set("head-skip:"+host, resp.Status, headSkipRetention); err != nil {
+		slog.Error(fmt.Sprintf("remember head skip: %s", err), "host", host)
+	}
+	return true
+}
+
+// sourceMediaType returns the media type of a response for source, empty
+// if it has to be inferred from the url or the content itself.
+func (receiver *Receiver) sourceMediaType(resp *http.Response, source URL) (string, error) {
+	mediaType, _, err := mimelib.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		if !receiver.sniffContent && receiver.extensionHint(source) == "" {
+			return "", err
+		}
+		return "", nil
+	}
+	return mediaType, nil
+}
@@ -0,0 +1,214 @@
+package webmention
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+type (
+	// A SpamScorer rates how likely a (valid) mention is spam.
+	// content is the raw source document.
+	// Scores are expected to be in the range [0, 1], with 1 meaning
+	// "certainly spam", but that's up to you, as long as the score can be
+	// compared against the threshold passed to WithSpamScorer.
+	// An error is treated as an internal error, the mention will not be
+	// dispatched.
+	SpamScorer interface {
+		Score(mention Mention, content []byte) (float64, error)
+	}
+
+	// SpamScorerFunc adapts a function to an object that implements the SpamScorer interface.
+	SpamScorerFunc func(mention Mention, content []byte) (float64, error)
+
+	// HeuristicScorer combines a few cheap signals into a spam score.
+	// Each signal that fires adds its weight to the score, the result is capped at 1.
+	HeuristicScorer struct {
+		// Phrases that are common in spam, matched case insensitively.
+		// Each occurrence adds PhraseWeight.
+		Phrases      []string
+		PhraseWeight float64
+
+		// If the source contains more than MaxOutboundLinks links,
+		// OutboundWeight is added.
+		MaxOutboundLinks int
+		OutboundWeight   float64
+
+		// If more than LinkFarmDomains distinct domains are linked to, and
+		// those links make up most of the text, the source looks like a link
+		// farm and LinkFarmWeight is added.
+		LinkFarmDomains int
+		LinkFarmWeight  float64
+
+		// If the source domain was registered less than MinDomainAge ago,
+		// DomainAgeWeight is added.
+		// The registration date is looked up via RDAP, set RDAPBaseURL to
+		// empty to disable the lookup.
+		// If the lookup fails, the domain age doesn't count either way.
+		MinDomainAge    time.Duration
+		DomainAgeWeight float64
+		RDAPBaseURL     string
+		HttpClient      *http.Client
+	}
+)
+
+// *HeuristicScorer implements SpamScorer
+var _ SpamScorer = (*HeuristicScorer)(nil)
+
+// rdapTimeout limits RDAP lookups, if the scorer has no client of its own.
+const rdapTimeout = 10 * time.Second
+
+var rdapClient = &http.Client{Timeout: rdapTimeout}
+
+func (f SpamScorerFunc) Score(mention Mention, content []byte) (float64, error) {
+	return f(mention, content)
+}
+
+// WithSpamScorer configures a scorer that rates every valid mention.
+// Mentions with a score at or above threshold are held for moderation (see
+// WithModeration) instead of being dispatched to the notifiers.
+func WithSpamScorer(scorer SpamScorer, threshold float64) ReceiverOption {
+	return func(r *Receiver) {
+		r.spamScorer = scorer
+		r.spamThreshold = threshold
+	}
+}
+
+// NewHeuristicScorer returns a scorer with sensible default weights.
+// With these defaults, a threshold of 0.5 is a reasonable starting point.
+func NewHeuristicScorer() *HeuristicScorer {
+	return &HeuristicScorer{
+		Phrases: []string{
+			"buy now", "cheap viagra", "casino bonus", "free money",
+			"work from home", "crypto giveaway", "limited time offer",
+			"click here to claim", "seo services", "backlinks",
+		},
+		PhraseWeight:     0.2,
+		MaxOutboundLinks: 100,
+		OutboundWeight:   0.3,
+		LinkFarmDomains:  30,
+		LinkFarmWeight:   0.4,
+		MinDomainAge:     30 * 24 * time.Hour,
+		DomainAgeWeight:  0.3,
+		RDAPBaseURL:      "https://rdap.org/domain/",
+		HttpClient:       &http.Client{Timeout: rdapTimeout},
+	}
+}
+
+func (s *HeuristicScorer) Score(mention Mention, content []byte) (float64, error) {
+	score := 0.0
+
+	lower := bytes.ToLower(content)
+	for _, phrase := range s.Phrases {
+		score += float64(bytes.Count(lower, []byte(strings.ToLower(phrase)))) * s.PhraseWeight
+	}
+
+	links, domains, linkTextLen, textLen := countLinks(content)
+	if s.MaxOutboundLinks > 0 && links > s.MaxOutboundLinks {
+		score += s.OutboundWeight
+	}
+	if s.LinkFarmDomains > 0 && domains > s.LinkFarmDomains && linkTextLen*2 > textLen {
+		score += s.LinkFarmWeight
+	}
+
+	if s.RDAPBaseURL != "" && s.MinDomainAge > 0 {
+		registered, err := s.registrationDate(mention.Source)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("spam score: %s", err), "source", mention.Source.String())
+		}
+		if !registered.IsZero() && time.Since(registered) < s.MinDomainAge {
+			score += s.DomainAgeWeight
+		}
+	}
+
+	return min(score, 1), nil
+}
+
+// countLinks counts the links in an html document, the number of distinct
+// domains they point to, how much text is inside of links and how much text
+// there is in total.
+func countLinks(content []byte) (links, domains, linkTextLen, textLen int) {
+	seenDomains := map[string]struct{}{}
+	inLink := 0
+	tokenizer := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return links, len(seenDomains), linkTextLen, textLen
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			if token.Data != "a" {
+				continue
+			}
+			inLink++
+			for _, a := range token.Attr {
+				if a.Key == "href" {
+					links++
+					if u, err := url.Parse(a.Val); err == nil && u.Host != "" {
+						seenDomains[strings.ToLower(u.Hostname())] = struct{}{}
+					}
+				}
+			}
+		case html.EndTagToken:
+			if name, _ := tokenizer.TagName(); string(name) == "a" && inLink > 0 {
+				inLink--
+			}
+		case html.TextToken:
+			n := len(bytes.TrimSpace(tokenizer.Text()))
+			textLen += n
+			if inLink > 0 {
+				linkTextLen += n
+			}
+		}
+	}
+}
+
+// registrationDate looks up when the (registrable) domain of u was registered.
+// A zero time is returned if RDAP doesn't know about the domain.
+func (s *HeuristicScorer) registrationDate(u URL) (time.Time, error) {
+	if IsOnion(u) {
+		return time.Time{}, nil // not registered anywhere, and not to be leaked to the RDAP service
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(u.Hostname())
+	if err != nil {
+		return time.Time{}, nil // ip address, localhost, ...
+	}
+	client := s.HttpClient
+	if client == nil {
+		client = rdapClient
+	}
+	resp, err := client.Get(s.RDAPBaseURL + domain)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("rdap: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return time.Time{}, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return time.Time{}, fmt.Errorf("rdap: lookup of %s returned %s", domain, resp.Status)
+	}
+	var rdap struct {
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rdap); err != nil {
+		return time.Time{}, fmt.Errorf("rdap: %w", err)
+	}
+	for _, event := range rdap.Events {
+		if event.Action == "registration" {
+			return event.Date, nil
+		}
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,200 @@
+package webmention
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+type (
+	// ProcessingState tells how far along the processing of a submitted mention is.
+	ProcessingState string
+
+	// MentionStatus is the processing status of a single submission.
+	MentionStatus struct {
+		ID     string          `json:"id"`
+		Source string          `json:"source,omitempty"`
+		Target string          `json:"target,omitempty"`
+		State  ProcessingState `json:"state"`
+		// Status is the result of the verification, only set once the mention is processed.
+		Status Status `json:"status,omitempty"`
+		// Reason tells why the mention was rejected.
+		Reason  string    `json:"reason,omitempty"`
+		Updated time.Time `json:"updated"`
+		// ETA is when the mention is expected to have been processed, an
+		// estimate based on the depth of the queue and how fast mentions
+		// are processed, only set while it is queued or waiting for a retry.
+		ETA *time.Time `json:"eta,omitempty"`
+		// History lists the transitions of all submissions with the same
+		// source and target, oldest first (see Receiver.History).
+		History []StatusTransition `json:"history,omitempty"`
+	}
+
+	// A StatusTransition records a submission entering a new state.
+	// Transitions are more fine-grained than MentionStatus.State: verification
+	// results are told apart into verified, reverified (the same status as
+	// the last time), updated (a different status), deleted, and
+	// unavailable (the source couldn't be verified, the previous result
+	// still stands), and the completion of notifications is recorded as notified.
+	StatusTransition struct {
+		ID     string          `json:"id"`
+		State  ProcessingState `json:"state"`
+		Status Status          `json:"status,omitempty"`
+		Time   time.Time       `json:"time"`
+	}
+)
+
+const (
+	StateQueued       ProcessingState = "queued"
+	StateHeld         ProcessingState = "held for moderation"
+	StateRejected     ProcessingState = "rejected"
+	StateFailed       ProcessingState = "failed"
+	StateRetrying     ProcessingState = "retry scheduled"
+	StateDeadLettered ProcessingState = "dead-lettered"
+	StateVerifying    ProcessingState = "verifying"
+	StateProcessed    ProcessingState = "processed"
+
+	// only used in the history
+	StateVerified    ProcessingState = "verified"
+	StateReverified  ProcessingState = "reverified"
+	StateUpdated     ProcessingState = "updated"
+	StateDeleted     ProcessingState = "deleted"
+	StateUnavailable ProcessingState = "unavailable"
+	StateNotified    ProcessingState = "notified"
+)
+
+const (
+	// statusRetention is how long the status of a submission can be looked up.
+	statusRetention = 24 * time.Hour
+	// maxHistory is how many transitions are kept per source and target.
+	maxHistory = 50
+)
+
+// MentionStatus returns the processing status of the submission with the given id.
+// Statuses are kept in the mention cache for a day, the history of a source
+// and target is kept indefinitely (only the latest transitions).
+func (receiver *Receiver) MentionStatus(id string) (MentionStatus, error) {
+	value, ok, err := receiver.mentionCache.Get("status:" + id)
+	if err != nil {
+		return MentionStatus{}, fmt.Errorf("mention status: %w", err)
+	}
+	if !ok {
+		return MentionStatus{}, ErrUnknownMention
+	}
+	var status MentionStatus
+	if err := json.Unmarshal([]byte(value), &status); err != nil {
+		return MentionStatus{}, fmt.Errorf("mention status: %w", err)
+	}
+	if status.Source != "" && status.Target != "" {
+		if status.History, err = receiver.history(status.Source, status.Target); err != nil {
+			return MentionStatus{}, fmt.Errorf("mention status: %w", err)
+		}
+	}
+	return status, nil
+}
+
+// History returns the recorded transitions of all submissions from source to target, oldest first.
+func (receiver *Receiver) History(source, target URL) ([]StatusTransition, error) {
+	return receiver.history(source.String(), target.String())
+}
+
+func (receiver *Receiver) history(source, target string) ([]StatusTransition, error) {
+	value, ok, err := receiver.mentionCache.Get("history:" + source + " " + target)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var history []StatusTransition
+	if err := json.Unmarshal([]byte(value), &history); err != nil {
+		return nil, fmt.Errorf("history: %w", err)
+	}
+	return history, nil
+}
+
+// setState records the processing state of mention.
+// Failing to do so is not fatal to processing, and is only logged.
+func (receiver *Receiver) setState(mention Mention, state ProcessingState) {
+	receiver.setStateReason(mention, state, "")
+}
+
+func (receiver *Receiver) setStateReason(mention Mention, state ProcessingState, reason string) {
+	receiver.setStatus(mention, MentionStatus{State: state, Reason: reason})
+}
+
+// setStatus records status as the processing state of mention, its id,
+// source, target, and time are filled in.
+func (receiver *Receiver) setStatus(mention Mention, status MentionStatus) {
+	state := status.State
+	receiver.metrics.processed(state)
+	status.ID, status.Source, status.Target = mention.ID, mention.Source.String(), mention.Target.String()
+	status.Updated = time.Now()
+	if state == StateProcessed || mention.Status == StatusInaccessible {
+		status.Status = mention.Status
+	}
+	bs, err := json.Marshal(status)
+	if err != nil {
+		panic(err) // MentionStatus always marshals
+	}
+	if err := receiver.mentionCache.Set("status:"+mention.ID, string(bs), statusRetention); err != nil {
+		slog.Error(fmt.Sprintf("set mention status: %s", err), "id", mention.ID)
+	}
+	receiver.record(mention, state)
+}
+
+// record appends a transition to the history of mention's source and target.
+// StateProcessed is refined according to the previous verification result.
+func (receiver *Receiver) record(mention Mention, state ProcessingState) {
+	receiver.historyMu.Lock()
+	defer receiver.historyMu.Unlock()
+	source, target := mention.Source.String(), mention.Target.String()
+	history, err := receiver.history(source, target)
+	if err != nil {
+		slog.Error(fmt.Sprintf("record history: %s", err), "id", mention.ID)
+		return
+	}
+	transition := StatusTransition{
+		ID:    mention.ID,
+		State: state,
+		Time:  time.Now(),
+	}
+	if state == StateProcessed {
+		transition.State = verificationResult(history, mention.Status)
+		transition.Status = mention.Status
+	}
+	history = append(history, transition)
+	if len(history) > maxHistory {
+		history = history[len(history)-maxHistory:]
+	}
+	bs, err := json.Marshal(history)
+	if err != nil {
+		panic(err) // StatusTransition always marshals
+	}
+	if err := receiver.mentionCache.Set("history:"+source+" "+target, string(bs), 0); err != nil {
+		slog.Error(fmt.Sprintf("record history: %s", err), "id", mention.ID)
+	}
+}
+
+// verificationResult tells a first verification apart from later ones.
+// Statuses other than (no) link and deleted, such as StatusUnavailable,
+// tell nothing about the links of the source, they are recorded as
+// unavailable, and later results are compared to the one before.
+func verificationResult(history []StatusTransition, status Status) ProcessingState {
+	switch status {
+	case StatusDeleted:
+		return StateDeleted
+	case StatusLink, StatusNoLink:
+	default:
+		return StateUnavailable
+	}
+	for _, transition := range slices.Backward(history) {
+		switch transition.State {
+		case StateVerified, StateReverified, StateUpdated, StateDeleted:
+			if transition.Status == status {
+				return StateReverified
+			}
+			return StateUpdated
+		}
+	}
+	return StateVerified
+}
@@ -0,0 +1,376 @@
+package webmention
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Storage persists processed mentions.
+	Storage interface {
+		// Store saves the mention, replacing any previously stored mention
+		// with the same source and target.
+		Store(mention Mention) error
+
+		// Mentions returns all stored mentions matching the filter, ordered by
+		// the time they were received.
+		Mentions(filter MentionFilter) ([]Mention, error)
+	}
+
+	// MentionFilter selects stored mentions.
+	// Zero values match everything.
+	MentionFilter struct {
+		// Only mentions received at or after Since.
+		Since time.Time
+		// Only mentions received before Until.
+		Until time.Time
+		// Only mentions of this target (exact match).
+		Target string
+		// Only mentions of this type (mentions without a type are TypeMention).
+		Type MentionType
+		// Only mentions with this status.
+		Status Status
+		// Only mentions from sources on this domain, or any of its subdomains.
+		SourceDomain string
+	}
+
+	// JSONFileStorage stores mentions in a file, one JSON object per line.
+	// New versions of a mention are appended to the file, when reading, the
+	// last version wins.
+	// It is an OutboxStorage, the notifications sent are appended as lines
+	// of their own.
+	// It is meant for small deployments (a personal blog), every read scans the whole file.
+	// Counts are kept in memory, and updated with every stored mention.
+	JSONFileStorage struct {
+		m      sync.Mutex
+		path   string
+		latest map[mentionCacheEntry]Mention // loaded on first use of Counts
+		counts map[string]*Counts
+	}
+
+	// mentionJSON is the serialized form of a Mention.
+	mentionJSON struct {
+		ID         string            `json:"id,omitempty"`
+		Source     string            `json:"source"`
+		Target     string            `json:"target"`
+		Status     Status            `json:"status"`
+		Reason     string            `json:"reason,omitempty"`
+		TargetID   string            `json:"target_id,omitempty"`
+		Received   time.Time         `json:"received"`
+		SpamScore  float64           `json:"spam_score,omitempty"`
+		SignedBy   string            `json:"signed_by,omitempty"`
+		Type       string            `json:"type,omitempty"`
+		Attempts   int               `json:"attempts,omitempty"`
+		Self       bool              `json:"self,omitempty"`
+		Trace      string            `json:"traceparent,omitempty"`
+		Fetch      string            `json:"fetch,omitempty"`
+		Extensions map[string]string `json:"extensions,omitempty"`
+		// SourceEndpoint is the endpoint advertised by the source.
+		SourceEndpoint  string `json:"source_endpoint,omitempty"`
+		SubmittedSource string `json:"submitted_source,omitempty"`
+		Change          string `json:"change,omitempty"`
+		// Published and Updated are omitted if unknown.
+		Published *time.Time `json:"published,omitempty"`
+		Updated   *time.Time `json:"updated,omitempty"`
+		Content   string     `json:"content,omitempty"`
+		// PreviousContent and ContentDiff are set if the content changed
+		// with an update, the diff is only written, it is computed from both versions.
+		PreviousContent string `json:"previous_content,omitempty"`
+		ContentDiff     string `json:"content_diff,omitempty"`
+	}
+)
+
+// *JSONFileStorage implements Storage
+var _ Storage = (*JSONFileStorage)(nil)
+
+// WithStorage configures where processed mentions are persisted.
+// Per default, mentions are not persisted at all.
+func WithStorage(storage Storage) ReceiverOption {
+	return func(r *Receiver) {
+		r.storage = storage
+	}
+}
+
+// Matches reports whether the mention is selected by the filter.
+func (filter MentionFilter) Matches(mention Mention) bool {
+	if !filter.Since.IsZero() && mention.Received.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && !mention.Received.Before(filter.Until) {
+		return false
+	}
+	if filter.Target != "" && mention.Target.String() != filter.Target {
+		return false
+	}
+	if filter.Type != "" && cmp.Or(mention.Type, TypeMention) != filter.Type {
+		return false
+	}
+	if filter.Status != "" && mention.Status != filter.Status {
+		return false
+	}
+	if filter.SourceDomain != "" {
+		host := strings.ToLower(mention.Source.Hostname())
+		domain := strings.ToLower(filter.SourceDomain)
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+	}
+	return true
+}
+
+func (mention Mention) MarshalJSON() ([]byte, error) {
+	m := mentionJSON{
+		ID:              mention.ID,
+		SpamScore:       mention.SpamScore,
+		SignedBy:        mention.SignedBy,
+		Type:            string(mention.Type),
+		Attempts:        mention.Attempts,
+		Self:            mention.SelfMention,
+		Trace:           mention.TraceParent,
+		Fetch:           string(mention.Fetch),
+		Status:          mention.Status,
+		Reason:          mention.Reason,
+		TargetID:        mention.TargetID,
+		Received:        mention.Received,
+		Extensions:      mention.Extensions,
+		Change:          string(mention.Change),
+		Content:         mention.Content,
+		PreviousContent: mention.PreviousContent,
+		ContentDiff:     mention.ContentDiff(),
+	}
+	if mention.Source != nil {
+		m.Source = mention.Source.String()
+	}
+	if mention.Target != nil {
+		m.Target = mention.Target.String()
+	}
+	if mention.SourceEndpoint != nil {
+		m.SourceEndpoint = mention.SourceEndpoint.String()
+	}
+	if mention.SubmittedSource != nil {
+		m.SubmittedSource = mention.SubmittedSource.String()
+	}
+	if !mention.Published.IsZero() {
+		m.Published = &mention.Published
+	}
+	if !mention.Updated.IsZero() {
+		m.Updated = &mention.Updated
+	}
+	return json.Marshal(m)
+}
+
+func (mention *Mention) UnmarshalJSON(bs []byte) error {
+	var m mentionJSON
+	if err := json.Unmarshal(bs, &m); err != nil {
+		return err
+	}
+	source, err := url.Parse(m.Source)
+	if err != nil {
+		return fmt.Errorf("mention: source: %w", err)
+	}
+	target, err := url.Parse(m.Target)
+	if err != nil {
+		return fmt.Errorf("mention: target: %w", err)
+	}
+	*mention = Mention{
+		ID:              m.ID,
+		SpamScore:       m.SpamScore,
+		SignedBy:        m.SignedBy,
+		Type:            MentionType(m.Type),
+		Attempts:        m.Attempts,
+		SelfMention:     m.Self,
+		TraceParent:     m.Trace,
+		Fetch:           FetchStrategy(m.Fetch),
+		Source:          source,
+		Target:          target,
+		Status:          m.Status,
+		Reason:          m.Reason,
+		TargetID:        m.TargetID,
+		Received:        m.Received,
+		Extensions:      m.Extensions,
+		Change:          MentionChange(m.Change),
+		Content:         m.Content,
+		PreviousContent: m.PreviousContent,
+	}
+	if m.SourceEndpoint != "" {
+		endpoint, err := url.Parse(m.SourceEndpoint)
+		if err != nil {
+			return fmt.Errorf("mention: source endpoint: %w", err)
+		}
+		mention.SourceEndpoint = endpoint
+	}
+	if m.SubmittedSource != "" {
+		submitted, err := url.Parse(m.SubmittedSource)
+		if err != nil {
+			return fmt.Errorf("mention: submitted source: %w", err)
+		}
+		mention.SubmittedSource = submitted
+	}
+	if m.Published != nil {
+		mention.Published = *m.Published
+	}
+	if m.Updated != nil {
+		mention.Updated = *m.Updated
+	}
+	return nil
+}
+
+// NewJSONFileStorage creates a storage backed by the file at path.
+// The file is created on first write, if it doesn't exist yet.
+func NewJSONFileStorage(path string) *JSONFileStorage {
+	return &JSONFileStorage{path: path}
+}
+
+func (s *JSONFileStorage) Store(mention Mention) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	bs, err := json.Marshal(mention)
+	if err != nil {
+		return err
+	}
+	if err := s.appendLine(bs); err != nil {
+		return err
+	}
+	if s.counts != nil {
+		s.count(mention)
+	}
+	return nil
+}
+
+func (s *JSONFileStorage) appendLine(line []byte) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Counts returns the counts of target, they are computed once from the whole
+// file, and then kept up to date.
+func (s *JSONFileStorage) Counts(target string) (Counts, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.load(); err != nil {
+		return Counts{}, err
+	}
+	if counts, ok := s.counts[target]; ok {
+		return *counts, nil
+	}
+	return Counts{}, nil
+}
+
+// Mention returns the stored mention of target by source, like the counts,
+// the latest mentions are read once from the whole file.
+func (s *JSONFileStorage) Mention(source, target string) (Mention, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.load(); err != nil {
+		return Mention{}, false, err
+	}
+	mention, ok := s.latest[mentionCacheEntry{source: source, target: target}]
+	return mention, ok, nil
+}
+
+// load reads the latest mentions and their counts, unless already loaded.
+func (s *JSONFileStorage) load() error {
+	if s.counts != nil {
+		return nil
+	}
+	all, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	s.latest = map[mentionCacheEntry]Mention{}
+	s.counts = map[string]*Counts{}
+	for _, mention := range all {
+		s.count(mention)
+	}
+	return nil
+}
+
+// count replaces the previous version of mention in the counts.
+func (s *JSONFileStorage) count(mention Mention) {
+	key := mentionCacheEntry{source: mention.Source.String(), target: mention.Target.String()}
+	counts, ok := s.counts[key.target]
+	if !ok {
+		counts = &Counts{}
+		s.counts[key.target] = counts
+	}
+	if previous, ok := s.latest[key]; ok {
+		counts.Remove(previous)
+	}
+	counts.Add(mention)
+	s.latest[key] = mention
+}
+
+func (s *JSONFileStorage) Mentions(filter MentionFilter) ([]Mention, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	all, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	var mentions []Mention
+	for _, mention := range all {
+		if filter.Matches(mention) {
+			mentions = append(mentions, mention)
+		}
+	}
+	return mentions, nil
+}
+
+// readAll returns the latest version of every mention in the file.
+func (s *JSONFileStorage) readAll() ([]Mention, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var (
+		mentions []Mention
+		index    = map[mentionCacheEntry]int{}
+	)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if bytes.HasPrefix(scanner.Bytes(), sentPrefix) {
+			continue // see Sent
+		}
+		var mention Mention
+		if err := json.Unmarshal(scanner.Bytes(), &mention); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", s.path, line, err)
+		}
+		key := mentionCacheEntry{source: mention.Source.String(), target: mention.Target.String()}
+		if i, ok := index[key]; ok {
+			mentions[i] = mention
+		} else {
+			index[key] = len(mentions)
+			mentions = append(mentions, mention)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(mentions, func(a, b Mention) int {
+		return a.Received.Compare(b.Received)
+	})
+	return mentions, nil
+}
@@ -0,0 +1,303 @@
+package webmention_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestJSONFileStorage(t *testing.T) {
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+
+	day := time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC)
+	source := must(url.Parse("https://example.com/source"))
+	target1 := must(url.Parse("https://example.org/target/1"))
+	target2 := must(url.Parse("https://example.org/target/2"))
+
+	mentions := []webmention.Mention{
+		{Source: source, Target: target1, Status: webmention.StatusLink, Received: day},
+		{Source: source, Target: target2, Status: webmention.StatusLink, Received: day.Add(24 * time.Hour)},
+		{Source: source, Target: target1, Status: webmention.StatusDeleted, Received: day.Add(48 * time.Hour)},
+	}
+	for _, mention := range mentions {
+		if err := storage.Store(mention); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all := must(storage.Mentions(webmention.MentionFilter{}))
+	if len(all) != 2 {
+		t.Fatalf("incorrect number of mentions, got: %d, want: %d", len(all), 2)
+	}
+	if all[0].Target.String() != target2.String() || all[1].Status != webmention.StatusDeleted {
+		t.Errorf("stored mentions are not up to date: %v", all)
+	}
+
+	filtered := must(storage.Mentions(webmention.MentionFilter{Target: target1.String(), Until: day.Add(72 * time.Hour)}))
+	if len(filtered) != 1 || filtered[0].Target.String() != target1.String() {
+		t.Errorf("incorrect filter result: %v", filtered)
+	}
+	if len(must(storage.Mentions(webmention.MentionFilter{Since: day.Add(72 * time.Hour)}))) != 0 {
+		t.Errorf("since filter does not exclude older mentions")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	for i := range 3 {
+		storage.Store(webmention.Mention{
+			Source:   must(url.Parse("https://example.com/source")),
+			Target:   must(url.Parse("https://example.org/target/" + string(rune('a'+i)))),
+			Status:   webmention.StatusLink,
+			Received: time.Now(),
+		})
+	}
+
+	var (
+		m        sync.Mutex
+		received []webmention.Mention
+	)
+	receiver := webmention.NewReceiver(
+		webmention.WithStorage(storage),
+		webmention.WithNotifier(webmention.NotifierFunc(func(mention webmention.Mention) {
+			m.Lock()
+			defer m.Unlock()
+			received = append(received, mention)
+		})),
+	)
+	n, err := receiver.Replay(webmention.MentionFilter{Target: "https://example.org/target/b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(received) != 1 || received[0].Target.String() != "https://example.org/target/b" {
+		t.Errorf("incorrect replay, got: %d, notified: %v", n, received)
+	}
+}
+
+func TestNotificationLog(t *testing.T) {
+	dir := t.TempDir()
+	storage := webmention.NewJSONFileStorage(filepath.Join(dir, "mentions.jsonl"))
+	storage.Store(webmention.Mention{
+		ID:       "1",
+		Source:   must(url.Parse("https://example.com/source")),
+		Target:   must(url.Parse("https://example.org/target")),
+		Status:   webmention.StatusLink,
+		Received: time.Now(),
+	})
+
+	var named, unnamed int
+	flaky := &flakyNotifier{failures: 1}
+	for range 3 { // later receivers act as if the process was restarted
+		receiver := webmention.NewReceiver(
+			webmention.WithStorage(storage),
+			webmention.WithNotificationLog(webmention.NewFileNotificationLog(filepath.Join(dir, "notified"))),
+			webmention.WithReporter(func(error, webmention.Mention) {}),
+			webmention.WithNotifier(
+				webmention.Named("counter", webmention.NotifierFunc(func(webmention.Mention) { named++ })),
+				webmention.NotifierFunc(func(webmention.Mention) { unnamed++ }),
+				webmention.Named("flaky", flaky),
+			),
+		)
+		if _, err := receiver.Replay(webmention.MentionFilter{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if named != 1 {
+		t.Errorf("named notifier informed %d times, want: 1", named)
+	}
+	if unnamed != 3 {
+		t.Errorf("unnamed notifier informed %d times, want: 3", unnamed)
+	}
+	// a failed notification is not recorded, so it is repeated
+	if flaky.calls != 2 {
+		t.Errorf("failing notifier tried %d times, want: 2", flaky.calls)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	storage := webmention.NewJSONFileStorage(filepath.Join(t.TempDir(), "mentions.jsonl"))
+	moderation := &webmention.MemoryModerationQueue{}
+	moderation.Hold(webmention.Mention{
+		ID:       "1",
+		Source:   must(url.Parse("https://example.com/source")),
+		Target:   must(url.Parse("https://example.org/target")),
+		Status:   webmention.StatusLink,
+		Received: time.Now(),
+	})
+
+	var counter int
+	flaky := &flakyNotifier{failures: 1}
+	newReceiver := func() *webmention.Receiver {
+		return webmention.NewReceiver(
+			webmention.WithStorage(storage),
+			webmention.WithModeration(moderation),
+			webmention.WithReporter(func(error, webmention.Mention) {}),
+			webmention.WithNotifier(
+				webmention.Named("counter", webmention.NotifierFunc(func(webmention.Mention) { counter++ })),
+				webmention.Named("flaky", flaky),
+			),
+		)
+	}
+	if err := newReceiver().Approve("1"); err != nil {
+		t.Fatal(err)
+	}
+	outbox := must(storage.Outbox())
+	if len(outbox) != 1 || outbox[0].Mention.ID != "1" || fmt.Sprint(outbox[0].Notifiers) != "[flaky]" {
+		t.Errorf("incorrect outbox after failed notification: %+v", outbox)
+	}
+
+	for range 2 { // later receivers act as if the process was restarted
+		receiver := newReceiver()
+		done := make(chan struct{})
+		go func() {
+			receiver.ProcessMentions()
+			close(done)
+		}()
+		receiver.Shutdown(context.Background())
+		<-done
+	}
+	if counter != 1 || flaky.calls != 2 {
+		t.Errorf("incorrect notifications, counter: %d, want: 1, flaky: %d, want: 2", counter, flaky.calls)
+	}
+	if outbox := must(storage.Outbox()); len(outbox) != 0 {
+		t.Errorf("outbox not empty: %+v", outbox)
+	}
+	if mentions := must(storage.Mentions(webmention.MentionFilter{})); len(mentions) != 1 || mentions[0].ID != "1" {
+		t.Errorf("incorrect stored mentions: %+v", mentions)
+	}
+}
+
+// flakyNotifier fails the first few times it is asked to notify.
+type flakyNotifier struct {
+	failures, calls int
+}
+
+func (n *flakyNotifier) Receive(mention webmention.Mention) {
+	n.Notify(mention)
+}
+
+func (n *flakyNotifier) Notify(webmention.Mention) error {
+	n.calls++
+	if n.calls <= n.failures {
+		return errors.New("temporarily unavailable")
+	}
+	return nil
+}
+
+func TestCounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mentions.jsonl")
+	storage := webmention.NewJSONFileStorage(path)
+	target := must(url.Parse("https://example.org/post"))
+	mention := func(source string, status webmention.Status, typ webmention.MentionType) webmention.Mention {
+		return webmention.Mention{Source: must(url.Parse(source)), Target: target, Status: status, Type: typ, Received: time.Now()}
+	}
+
+	storage.Store(mention("https://a.example/like", webmention.StatusLink, webmention.TypeLike))
+	if counts := must(storage.Counts(target.String())); counts.Likes != 1 || counts.Total != 1 {
+		t.Errorf("incorrect counts after loading: %+v", counts)
+	}
+	storage.Store(mention("https://b.example/reply", webmention.StatusLink, webmention.TypeReply))
+	storage.Store(mention("https://c.example/post", webmention.StatusLink, webmention.TypeMention))
+	storage.Store(mention("https://a.example/like", webmention.StatusDeleted, ""))
+	want := webmention.Counts{Replies: 1, Mentions: 1, Total: 2}
+	if counts := must(storage.Counts(target.String())); counts != want {
+		t.Errorf("incorrect counts, got: %+v, want: %+v", counts, want)
+	}
+	// counts computed from scratch must agree
+	if counts := must(webmention.NewJSONFileStorage(path).Counts(target.String())); counts != want {
+		t.Errorf("incorrect counts after reloading, got: %+v, want: %+v", counts, want)
+	}
+}
+
+func TestClassifyMention(t *testing.T) {
+	target := must(url.Parse("https://example.org/post"))
+	for content, want := range map[string]webmention.MentionType{
+		`<a class="u-like-of" href="https://example.org/post">liked</a>`:                                            webmention.TypeLike,
+		`<div class="h-entry"><a class="u-in-reply-to h-cite" href="https://example.org/post">re</a></div>`:         webmention.TypeReply,
+		`<a class="u-repost-of" href="https://example.org/other">other</a><a href="https://example.org/post">x</a>`: webmention.TypeMention,
+		`plain text https://example.org/post`:                                                                       webmention.TypeMention,
+	} {
+		if got := webmention.ClassifyMention([]byte(content), target); got != want {
+			t.Errorf("%s: got: %s, want: %s", content, got, want)
+		}
+	}
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storage := webmention.NewMemoryStorage()
+	target := must(url.Parse("https://example.org/target"))
+	day := time.Date(2024, 11, 5, 0, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			storage.Store(webmention.Mention{
+				Source:   must(url.Parse(fmt.Sprintf("https://example.com/source/%d", i%10))),
+				Target:   target,
+				Status:   webmention.StatusLink,
+				Type:     webmention.TypeLike,
+				Received: day.Add(time.Duration(i%10) * time.Hour),
+			})
+			storage.Mentions(webmention.MentionFilter{Target: target.String()})
+		}()
+	}
+	wg.Wait()
+
+	snapshot := storage.Snapshot()
+	if len(snapshot) != 10 {
+		t.Fatalf("incorrect number of mentions, got: %d, want: %d", len(snapshot), 10)
+	}
+	for i := 1; i < len(snapshot); i++ {
+		if snapshot[i].Received.Before(snapshot[i-1].Received) {
+			t.Fatalf("snapshot not ordered by received time: %v", snapshot)
+		}
+	}
+	if counts := must(storage.Counts(target.String())); counts.Likes != 10 || counts.Total != 10 {
+		t.Errorf("incorrect counts: %+v", counts)
+	}
+
+	snapshot[0].Source.Path = "/modified" // snapshots don't share anything with the storage
+	storage.Store(webmention.Mention{Source: snapshot[1].Source, Target: target, Status: webmention.StatusDeleted, Received: day})
+	if mentions := must(storage.Mentions(webmention.MentionFilter{Status: webmention.StatusDeleted})); len(mentions) != 1 || mentions[0].Source.Path == "/modified" {
+		t.Errorf("incorrect mentions after update: %v", mentions)
+	}
+
+	storage.Restore(snapshot[:2])
+	if mentions := storage.Snapshot(); len(mentions) != 2 || mentions[0].Source.Path != "/modified" || mentions[1].Status != webmention.StatusLink {
+		t.Errorf("snapshot not restored: %v", mentions)
+	}
+}
+
+func TestMemoryPersister(t *testing.T) {
+	persister := webmention.NewMemoryPersister()
+	source := must(url.Parse("https://example.com/post"))
+	targets := []*url.URL{must(url.Parse("https://example.org/a")), must(url.Parse("https://example.org/b"))}
+	if err := persister.SetTargets(source, targets); err != nil {
+		t.Fatal(err)
+	}
+	persister.SetContentHash(source, "hash")
+	snapshot := persister.Snapshot()
+
+	targets[0].Path = "/modified" // the persister keeps its own copy
+	persister.SetTargets(source, nil)
+	if got := must(persister.Targets(source)); len(got) != 0 {
+		t.Errorf("targets not replaced: %v", got)
+	}
+
+	persister.Restore(snapshot)
+	if got := must(persister.Targets(source)); len(got) != 2 || got[0].String() != "https://example.org/a" {
+		t.Errorf("snapshot not restored: %v", got)
+	}
+	if hash := must(persister.ContentHash(source)); hash != "hash" {
+		t.Errorf("incorrect content hash: %q", hash)
+	}
+}
@@ -0,0 +1,976 @@
+package webmention
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tomnomnom/linkheader"
+	"golang.org/x/net/html"
+)
+
+type (
+	// TenantHost serves many site owners (tenants) from one deployment.
+	// Every tenant gets a receiver of its own, with its own storage,
+	// mention cache, and metrics, accepting mentions of its domains only.
+	// Tenants register, and manage their configuration, through a JSON API.
+	// All routes are served under the mount point (see WithTenantMountPoint):
+	//   - POST /tenants registers a tenant, see WithRegistration:
+	//     {"id": "alice", "me": "https://alice.example/", "accept_domains": ["alice.example"], "relays": []}
+	//     is answered with the tenant, and its API key, which is shown only once:
+	//     {"id": "alice", ..., "api_key": "..."}
+	//   - GET /tenants/{id} returns, PUT /tenants/{id} replaces (accept_domains
+	//     and relays, the quota only if authorized as operator), and DELETE
+	//     /tenants/{id} removes the tenant (its stored mentions are kept).
+	//   - /{id}/webmention is the webmention endpoint of the tenant,
+	//     /{id}/status/{mention} and /{id}/rejection are public too (see RouteStatus),
+	//     /{id}/mentions, /{id}/counts, /{id}/metrics, /{id}/queue, and
+	//     /{id}/usage require its API key, /{id}/widget.json and /{id}/widget.js are public,
+	//     and so is /{id}/openapi.json, describing these routes (see RouteOpenAPI).
+	//
+	// The API key is passed as bearer token: Authorization: Bearer <key>
+	// The operator (see WithOperatorAuth) may do anything a tenant can.
+	TenantHost struct {
+		store        TenantStore
+		storage      func(tenantID string) (Storage, error)
+		options      []ReceiverOption
+		quota        int
+		registration Registration
+		operator     func(r *http.Request) bool
+		mountPoint   string
+		httpClient   *http.Client
+		usage        UsageStore
+		usageQuota   UsageQuota
+
+		m       sync.Mutex
+		tenants map[string]*tenantReceiver
+		mux     *http.ServeMux
+	}
+
+	TenantOption func(*TenantHost)
+
+	// Tenant is a site owner served by a TenantHost.
+	Tenant struct {
+		// ID names the tenant in urls: lowercase letters, digits, and dashes.
+		ID string `json:"id"`
+		// Me is the IndieAuth profile url of the owner, empty if the tenant
+		// wasn't registered with IndieAuth.
+		Me string `json:"me,omitempty"`
+		// AcceptDomains are the hosts whose pages accept mentions through
+		// the tenant, e.g., alice.example
+		// No two tenants can accept the same host.
+		AcceptDomains []string `json:"accept_domains"`
+		// Relays are webmention endpoints every processed mention is
+		// forwarded to (see Relay), the notifiers of the tenant.
+		// They must be public, loopback and private addresses are refused.
+		Relays []string `json:"relays,omitempty"`
+		// DailyQuota is how many mentions the tenant accepts per day (0:
+		// the default quota of the host, see WithTenantQuota).
+		DailyQuota int `json:"daily_quota,omitempty"`
+		// KeyHash is the (hex encoded) SHA-256 hash of the API key.
+		KeyHash string `json:"key_hash,omitempty"`
+		// Registered is when the tenant registered.
+		Registered time.Time `json:"registered"`
+	}
+
+	// TenantStore persists the tenants of a TenantHost.
+	TenantStore interface {
+		Tenants() ([]Tenant, error)
+		// SaveTenant adds the tenant, or replaces the tenant with the same id.
+		SaveTenant(tenant Tenant) error
+		DeleteTenant(id string) error
+	}
+
+	// MemoryTenantStore is a TenantStore that is kept in memory.
+	MemoryTenantStore struct {
+		m       sync.Mutex
+		tenants []Tenant
+	}
+
+	// JSONFileTenantStore keeps the tenants in a JSON file.
+	JSONFileTenantStore struct {
+		m    sync.Mutex
+		path string
+	}
+
+	// Registration selects who may register new tenants.
+	Registration uint8
+
+	// tenantReceiver is a running tenant.
+	tenantReceiver struct {
+		m        sync.Mutex
+		tenant   Tenant
+		relays   []*Relay
+		day      string // the day mentions are counted for, 2006-01-02
+		accepted int
+
+		receiver *Receiver
+		handler  http.Handler
+	}
+
+	// registeredTenant is the answer to a registration.
+	registeredTenant struct {
+		Tenant
+		APIKey string `json:"api_key"`
+	}
+)
+
+const (
+	// RegisterOpen lets anyone register, with any domain not taken yet.
+	RegisterOpen Registration = 1 << iota
+	// RegisterIndieAuth lets anyone register, who proves to own the
+	// domains through IndieAuth: the registration is authorized with an
+	// access token of the profile url (me), and the domains must be the
+	// host of me, or its subdomains.
+	RegisterIndieAuth
+
+	// Without either, only the operator can register tenants.
+	RegisterClosed Registration = 0
+)
+
+var (
+	// *MemoryTenantStore implements TenantStore
+	_ TenantStore = (*MemoryTenantStore)(nil)
+	// *JSONFileTenantStore implements TenantStore
+	_ TenantStore = (*JSONFileTenantStore)(nil)
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// defaultTenantQuota is the default daily quota of a tenant.
+const defaultTenantQuota = 1000
+
+// NewTenantHost starts the receivers of all tenants in store.
+// Receivers are created with the options shared by all tenants, followed by
+// the acceptance, storage, and relays of the tenant.
+func NewTenantHost(store TenantStore, opts ...TenantOption) (*TenantHost, error) {
+	host := &TenantHost{
+		store: store,
+		storage: func(string) (Storage, error) {
+			return NewMemoryStorage(), nil
+		},
+		quota:        defaultTenantQuota,
+		registration: RegisterIndieAuth,
+		httpClient:   http.DefaultClient,
+		tenants:      map[string]*tenantReceiver{},
+		mux:          http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(host)
+	}
+	tenants, err := store.Tenants()
+	if err != nil {
+		return nil, fmt.Errorf("tenants: %w", err)
+	}
+	for _, tenant := range tenants {
+		t, err := host.start(tenant)
+		if err != nil {
+			host.Shutdown(context.Background())
+			return nil, fmt.Errorf("tenant %s: %w", tenant.ID, err)
+		}
+		host.tenants[tenant.ID] = t
+	}
+	host.mux.HandleFunc("POST "+host.mountPoint+"/tenants", host.register)
+	host.mux.HandleFunc("GET "+host.mountPoint+"/tenants/{id}", host.tenantAPI(host.get))
+	host.mux.HandleFunc("PUT "+host.mountPoint+"/tenants/{id}", host.tenantAPI(host.update))
+	host.mux.HandleFunc("DELETE "+host.mountPoint+"/tenants/{id}", host.tenantAPI(host.delete))
+	host.mux.HandleFunc(host.mountPoint+"/{id}/", host.serveTenant)
+	return host, nil
+}
+
+// WithTenantStorage creates the storage of every tenant, the namespace its
+// mentions are isolated in, e.g., a file per tenant (default in memory).
+func WithTenantStorage(storage func(tenantID string) (Storage, error)) TenantOption {
+	return func(h *TenantHost) {
+		h.storage = storage
+	}
+}
+
+// WithTenantReceiverOptions configures the receivers of all tenants, e.g.,
+// WithHardening, or WithFetchProxy.
+func WithTenantReceiverOptions(opts ...ReceiverOption) TenantOption {
+	return func(h *TenantHost) {
+		h.options = append(h.options, opts...)
+	}
+}
+
+// WithTenantUsage accounts the monthly usage of every domain of every tenant
+// in store (see UsageMeter), limited to quota, the tenants are isolated by
+// namespace.
+func WithTenantUsage(store UsageStore, quota UsageQuota) TenantOption {
+	return func(h *TenantHost) {
+		h.usage = store
+		h.usageQuota = quota
+	}
+}
+
+// WithTenantQuota sets how many mentions a tenant accepts per day, unless
+// the operator configured a quota for the tenant (default 1000).
+// Further mentions are answered with http.StatusTooManyRequests.
+func WithTenantQuota(daily int) TenantOption {
+	return func(h *TenantHost) {
+		h.quota = daily
+	}
+}
+
+// WithRegistration selects who may register (default RegisterIndieAuth).
+func WithRegistration(registration Registration) TenantOption {
+	return func(h *TenantHost) {
+		h.registration = registration
+	}
+}
+
+// WithOperatorAuth identifies requests of the operator, who may register,
+// inspect, and change any tenant, including its quota.
+func WithOperatorAuth(authorize func(r *http.Request) bool) TenantOption {
+	return func(h *TenantHost) {
+		h.operator = authorize
+	}
+}
+
+// WithTenantMountPoint sets the path prefix under which the routes are
+// served, e.g., /hosted (see WithMountPoint).
+func WithTenantMountPoint(prefix string) TenantOption {
+	return func(h *TenantHost) {
+		h.mountPoint = strings.TrimSuffix(prefix, "/")
+	}
+}
+
+func (host *TenantHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host.mux.ServeHTTP(w, r)
+}
+
+// Tenant returns the tenant with the given id.
+func (host *TenantHost) Tenant(id string) (Tenant, error) {
+	t, ok := host.running(id)
+	if !ok {
+		return Tenant{}, ErrUnknownTenant
+	}
+	return t.current(), nil
+}
+
+// Receiver returns the receiver of the tenant with the given id, e.g., to
+// add notifiers that aren't configurable through the API.
+func (host *TenantHost) Receiver(id string) (*Receiver, error) {
+	t, ok := host.running(id)
+	if !ok {
+		return nil, ErrUnknownTenant
+	}
+	return t.receiver, nil
+}
+
+// Shutdown shuts down the receivers of all tenants (see Receiver.Shutdown).
+func (host *TenantHost) Shutdown(ctx context.Context) {
+	host.m.Lock()
+	tenants := host.tenants
+	host.tenants = map[string]*tenantReceiver{}
+	host.m.Unlock()
+	var wg sync.WaitGroup
+	for _, t := range tenants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.receiver.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (host *TenantHost) running(id string) (*tenantReceiver, bool) {
+	host.m.Lock()
+	defer host.m.Unlock()
+	t, ok := host.tenants[id]
+	return t, ok
+}
+
+// start creates and starts the receiver of tenant, the caller adds it to
+// the running tenants.
+func (host *TenantHost) start(tenant Tenant) (*tenantReceiver, error) {
+	storage, err := host.storage(tenant.ID)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	relays, err := tenantRelays(tenant)
+	if err != nil {
+		return nil, err
+	}
+	t := &tenantReceiver{tenant: tenant, relays: relays}
+	options := slices.Concat(host.options, []ReceiverOption{
+		WithAcceptsFunc(t.accepts),
+		WithStorage(storage),
+		WithNotifier(relayNotifiers(t.relays)...),
+	})
+	routes := RouteWebmention | RouteStatus | RouteMentions | RouteWidget | RouteQueue | RouteOpenAPI
+	if host.usage != nil {
+		options = append(options, WithUsageMeter(&UsageMeter{
+			Store:     host.usage,
+			Quota:     host.usageQuota,
+			Namespace: tenant.ID + "/",
+		}))
+		routes |= RouteUsage
+	}
+	t.receiver = NewReceiver(options...)
+	mountPoint := host.mountPoint + "/" + tenant.ID
+	t.handler = NewReceiverHandler(t.receiver,
+		WithMountPoint(mountPoint),
+		WithRoutes(routes),
+		WithAdminAuth(func(r *http.Request) bool {
+			return host.authorized(r, t.current())
+		}),
+	)
+	go t.receiver.ProcessMentions()
+	return t, nil
+}
+
+// tenantRelays validates the relays of tenant, and creates them.
+// Relays are posted to by the host, on behalf of the tenant, so they may
+// only point to public addresses, see relayClient.
+func tenantRelays(tenant Tenant) (relays []*Relay, err error) {
+	for _, relay := range tenant.Relays {
+		endpoint, err := url.Parse(relay)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return nil, fmt.Errorf("relays: expected an http(s) url, got: %s", relay)
+		}
+		host := strings.ToLower(strings.TrimSuffix(endpoint.Hostname(), "."))
+		addr, err := netip.ParseAddr(host)
+		if host == "localhost" || strings.HasSuffix(host, ".localhost") || (err == nil && !isPublicAddr(addr)) {
+			return nil, fmt.Errorf("relays: expected a public address, got: %s", relay)
+		}
+		r := NewRelay(endpoint)
+		r.HttpClient = relayClient
+		relays = append(relays, r)
+	}
+	return relays, nil
+}
+
+// relayClient posts to the relays of tenants. Host names are resolved
+// before the address is checked, so that a name pointing into the network
+// of the host is refused too, on every connection, not only when the relay
+// is configured.
+var relayClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				addrPort, err := netip.ParseAddrPort(address)
+				if err != nil || !isPublicAddr(addrPort.Addr()) {
+					return fmt.Errorf("relay: refusing to connect to non-public address %s", address)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// isPublicAddr reports whether addr is a global unicast address, that isn't
+// private either.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
+
+func relayNotifiers(relays []*Relay) []Notifier {
+	notifiers := make([]Notifier, len(relays))
+	for i, relay := range relays {
+		notifiers[i] = relay
+	}
+	return notifiers
+}
+
+func (t *tenantReceiver) current() Tenant {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.tenant
+}
+
+func (t *tenantReceiver) accepts(source, target URL) bool {
+	host := strings.ToLower(target.Hostname())
+	return slices.Contains(t.current().AcceptDomains, host)
+}
+
+// count reports whether the tenant may accept another mention today.
+func (t *tenantReceiver) count(quota int) bool {
+	today := time.Now().Format(time.DateOnly)
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.day != today {
+		t.day, t.accepted = today, 0
+	}
+	if t.tenant.DailyQuota > 0 {
+		quota = t.tenant.DailyQuota
+	}
+	if quota > 0 && t.accepted >= quota {
+		return false
+	}
+	t.accepted++
+	return true
+}
+
+// uncount gives back a mention that was counted, but not accepted.
+func (t *tenantReceiver) uncount() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.accepted--
+}
+
+func (host *TenantHost) serveTenant(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	t, ok := host.running(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	prefix := host.mountPoint + "/" + id
+	switch r.URL.Path {
+	case prefix + "/metrics":
+		if !host.authorized(r, t.current()) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		host.metrics(w, r, t)
+		return
+	case prefix + "/webmention":
+		if r.Method != http.MethodPost {
+			break
+		}
+		if !t.count(host.quota) {
+			slog.Info(ErrQuotaExceeded.Error(), "tenant", id)
+			http.Error(w, ErrQuotaExceeded.Error(), http.StatusTooManyRequests)
+			return
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		t.handler.ServeHTTP(recorder, r)
+		if recorder.code != http.StatusAccepted {
+			t.uncount()
+		}
+		return
+	}
+	t.handler.ServeHTTP(w, r)
+}
+
+// metrics writes the metrics of the tenant's receiver, and how much of its
+// quota is used up today.
+func (host *TenantHost) metrics(w http.ResponseWriter, r *http.Request, t *tenantReceiver) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := t.receiver.WriteMetrics(w); err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		return
+	}
+	t.m.Lock()
+	accepted, quota := t.accepted, t.tenant.DailyQuota
+	if t.day != time.Now().Format(time.DateOnly) {
+		accepted = 0
+	}
+	t.m.Unlock()
+	if quota == 0 {
+		quota = host.quota
+	}
+	if _, err := fmt.Fprintf(w, "# HELP webmention_tenant_accepted_today Mentions accepted today.\n# TYPE webmention_tenant_accepted_today gauge\nwebmention_tenant_accepted_today %d\n# HELP webmention_tenant_daily_quota Mentions accepted per day at most (0: no limit).\n# TYPE webmention_tenant_daily_quota gauge\nwebmention_tenant_daily_quota %d\n", accepted, quota); err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+	}
+}
+
+// authorized reports whether r carries the API key of tenant, or is from the operator.
+func (host *TenantHost) authorized(r *http.Request, tenant Tenant) bool {
+	if host.isOperator(r) {
+		return true
+	}
+	key, ok := bearerToken(r)
+	if !ok || tenant.KeyHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(tenant.KeyHash)) == 1
+}
+
+func (host *TenantHost) isOperator(r *http.Request) bool {
+	return host.operator != nil && host.operator(r)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	return token, ok && token != ""
+}
+
+func newAPIKey() string {
+	var key [32]byte
+	rand.Read(key[:])
+	return hex.EncodeToString(key[:])
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// tenantRequest is the body of a registration, or update.
+type tenantRequest struct {
+	ID            string   `json:"id"`
+	Me            string   `json:"me"`
+	AcceptDomains []string `json:"accept_domains"`
+	Relays        []string `json:"relays"`
+	DailyQuota    int      `json:"daily_quota"`
+}
+
+func readTenantRequest(r *http.Request) (req tenantRequest, err error) {
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		return req, fmt.Errorf("malformed request: %w", err)
+	}
+	if len(req.AcceptDomains) == 0 {
+		return req, errors.New("accept_domains: required")
+	}
+	for i, domain := range req.AcceptDomains {
+		domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+		if domain == "" || strings.ContainsAny(domain, "/:@ ") {
+			return req, fmt.Errorf("accept_domains: expected host names, got: %s", req.AcceptDomains[i])
+		}
+		req.AcceptDomains[i] = domain
+	}
+	if req.DailyQuota < 0 {
+		return req, errors.New("daily_quota: expected a positive number")
+	}
+	return req, nil
+}
+
+func (host *TenantHost) register(w http.ResponseWriter, r *http.Request) {
+	req, err := readTenantRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !tenantIDPattern.MatchString(req.ID) || req.ID == "tenants" {
+		http.Error(w, "id: expected lowercase letters, digits, and dashes", http.StatusBadRequest)
+		return
+	}
+	tenant := Tenant{
+		ID:            req.ID,
+		AcceptDomains: req.AcceptDomains,
+		Relays:        req.Relays,
+		Registered:    time.Now(),
+	}
+	switch {
+	case host.isOperator(r):
+		tenant.Me, tenant.DailyQuota = req.Me, req.DailyQuota
+	case host.registration&RegisterIndieAuth != 0 && req.Me != "":
+		token, ok := bearerToken(r)
+		if !ok {
+			http.Error(w, "unauthorized: IndieAuth access token of me required", http.StatusUnauthorized)
+			return
+		}
+		me, err := host.verifyIndieAuth(r.Context(), req.Me, token)
+		if err != nil {
+			slog.Info("indieauth registration failed", "me", req.Me, "error", err)
+			http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		for _, domain := range tenant.AcceptDomains {
+			if domain != me.Hostname() && !strings.HasSuffix(domain, "."+me.Hostname()) {
+				http.Error(w, "accept_domains: "+domain+" is not the domain of me", http.StatusForbidden)
+				return
+			}
+		}
+		tenant.Me = me.String()
+	case host.registration&RegisterOpen != 0:
+	default:
+		http.Error(w, "registration is closed", http.StatusForbidden)
+		return
+	}
+	if _, err := tenantRelays(tenant); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := newAPIKey()
+	tenant.KeyHash = hashAPIKey(key)
+	if !host.add(w, r, tenant) {
+		return
+	}
+	slog.Info("tenant registered", "tenant", tenant.ID, "me", tenant.Me)
+	tenant.KeyHash = ""
+	w.Header().Set("Location", host.mountPoint+"/tenants/"+tenant.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(registeredTenant{Tenant: tenant, APIKey: key}); err != nil {
+		slog.Error(err.Error())
+	}
+}
+
+// add persists and starts the new tenant, errors are answered, and
+// reported as false.
+// The checks, saving, and starting happen under one lock, so that two
+// registrations can't claim the same id or domain.
+func (host *TenantHost) add(w http.ResponseWriter, r *http.Request, tenant Tenant) bool {
+	host.m.Lock()
+	defer host.m.Unlock()
+	if _, exists := host.tenants[tenant.ID]; exists {
+		http.Error(w, "id: already taken", http.StatusConflict)
+		return false
+	}
+	if !host.save(w, r, tenant) {
+		return false
+	}
+	t, err := host.start(tenant)
+	if err != nil {
+		slog.Error(fmt.Sprintf("tenant %s: %s", tenant.ID, err), "path", r.URL.EscapedPath())
+		if err := host.store.DeleteTenant(tenant.ID); err != nil {
+			slog.Error(fmt.Sprintf("tenants: %s", err), "path", r.URL.EscapedPath())
+		}
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+	host.tenants[tenant.ID] = t
+	return true
+}
+
+// replace applies change to a copy of the tenant of t, persists it, and
+// swaps it in, together with its relays, errors are answered, and reported
+// as false.
+// Like add, it holds the lock of the host throughout, so that concurrent
+// changes don't overwrite each other, or claim the same domain.
+func (host *TenantHost) replace(w http.ResponseWriter, r *http.Request, t *tenantReceiver, change func(tenant *Tenant)) (Tenant, bool) {
+	host.m.Lock()
+	defer host.m.Unlock()
+	tenant := t.current()
+	if host.tenants[tenant.ID] != t {
+		http.Error(w, ErrUnknownTenant.Error(), http.StatusNotFound)
+		return tenant, false
+	}
+	change(&tenant)
+	relays, err := tenantRelays(tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return tenant, false
+	}
+	if !host.save(w, r, tenant) {
+		return tenant, false
+	}
+	t.m.Lock()
+	previous := t.relays
+	t.tenant, t.relays = tenant, relays
+	t.m.Unlock()
+	for _, relay := range previous {
+		t.receiver.RemoveNotifier(relay)
+	}
+	t.receiver.AddNotifier(relayNotifiers(relays)...)
+	return tenant, true
+}
+
+// save checks that no other tenant accepts the domains of tenant, and
+// persists it, errors are answered, and reported as false.
+// The caller holds the lock of the host.
+func (host *TenantHost) save(w http.ResponseWriter, r *http.Request, tenant Tenant) bool {
+	for id, other := range host.tenants {
+		if id == tenant.ID {
+			continue
+		}
+		for _, domain := range tenant.AcceptDomains {
+			if slices.Contains(other.current().AcceptDomains, domain) {
+				http.Error(w, "accept_domains: "+domain+" is taken by another tenant", http.StatusConflict)
+				return false
+			}
+		}
+	}
+	if err := host.store.SaveTenant(tenant); err != nil {
+		slog.Error(fmt.Sprintf("tenants: %s", err), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// tenantAPI looks up the tenant of the request, and checks its API key.
+func (host *TenantHost) tenantAPI(next func(w http.ResponseWriter, r *http.Request, t *tenantReceiver)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := host.running(r.PathValue("id"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if !host.authorized(r, t.current()) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, t)
+	}
+}
+
+func (host *TenantHost) get(w http.ResponseWriter, r *http.Request, t *tenantReceiver) {
+	tenant := t.current()
+	tenant.KeyHash = ""
+	writeJSON(w, tenant)
+}
+
+func (host *TenantHost) update(w http.ResponseWriter, r *http.Request, t *tenantReceiver) {
+	req, err := readTenantRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	operator := host.isOperator(r)
+	if profile := t.current().Me; profile != "" && !operator {
+		me, _ := url.Parse(profile)
+		for _, domain := range req.AcceptDomains {
+			if domain != me.Hostname() && !strings.HasSuffix(domain, "."+me.Hostname()) {
+				http.Error(w, "accept_domains: "+domain+" is not the domain of me", http.StatusForbidden)
+				return
+			}
+		}
+	}
+	tenant, ok := host.replace(w, r, t, func(tenant *Tenant) {
+		tenant.AcceptDomains, tenant.Relays = req.AcceptDomains, req.Relays
+		if operator {
+			tenant.DailyQuota = req.DailyQuota
+		}
+	})
+	if !ok {
+		return
+	}
+	tenant.KeyHash = ""
+	writeJSON(w, tenant)
+}
+
+func (host *TenantHost) delete(w http.ResponseWriter, r *http.Request, t *tenantReceiver) {
+	id := r.PathValue("id")
+	host.m.Lock()
+	if host.tenants[id] != t {
+		host.m.Unlock()
+		http.Error(w, ErrUnknownTenant.Error(), http.StatusNotFound)
+		return
+	}
+	if err := host.store.DeleteTenant(id); err != nil {
+		host.m.Unlock()
+		slog.Error(fmt.Sprintf("tenants: %s", err), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	delete(host.tenants, id)
+	host.m.Unlock()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		t.receiver.Shutdown(ctx)
+	}()
+	slog.Info("tenant deleted", "tenant", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyIndieAuth checks that token is an access token issued for me, by
+// asking the token endpoint me advertises (IndieAuth token verification).
+// It returns the canonical profile url.
+func (host *TenantHost) verifyIndieAuth(ctx context.Context, me, token string) (URL, error) {
+	profile, err := url.Parse(me)
+	if err != nil || (profile.Scheme != "http" && profile.Scheme != "https") || profile.Host == "" {
+		return nil, errors.New("me: expected a profile url")
+	}
+	if profile.Path == "" {
+		profile.Path = "/"
+	}
+	endpoint, err := host.discoverTokenEndpoint(ctx, profile)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := host.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint: token rejected: %s", resp.Status)
+	}
+	var verification struct {
+		Me string `json:"me"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&verification); err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	verified, err := url.Parse(verification.Me)
+	if err != nil || !sameOrigin(verified, profile) {
+		return nil, errors.New("token endpoint: token was not issued for me")
+	}
+	return profile, nil
+}
+
+// discoverTokenEndpoint finds the token_endpoint of profile, in its Link
+// header, or a <link> element.
+func (host *TenantHost) discoverTokenEndpoint(ctx context.Context, profile URL) (URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, profile.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("me: %w", err)
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := host.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("me: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("me: get returned %s", resp.Status)
+	}
+	href := ""
+	for _, l := range linkheader.ParseMultiple(resp.Header.Values("Link")) {
+		for _, rel := range strings.Fields(l.Rel) {
+			if href == "" && strings.EqualFold(rel, "token_endpoint") {
+				href = l.URL
+			}
+		}
+	}
+	if href == "" {
+		doc, err := html.Parse(io.LimitReader(resp.Body, maxSourceSize))
+		if err != nil {
+			return nil, fmt.Errorf("me: %w", err)
+		}
+		var find func(node *html.Node)
+		find = func(node *html.Node) {
+			if node.Type == html.ElementNode && node.Data == "link" && href == "" {
+				href, _ = relHref(node, "token_endpoint")
+			}
+			for child := node.FirstChild; child != nil && href == ""; child = child.NextSibling {
+				find(child)
+			}
+		}
+		find(doc)
+	}
+	if href == "" {
+		return nil, errors.New("me: no token_endpoint advertised")
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return nil, fmt.Errorf("me: token_endpoint: %w", err)
+	}
+	return resp.Request.URL.ResolveReference(ref), nil
+}
+
+func (s *MemoryTenantStore) Tenants() ([]Tenant, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return slices.Clone(s.tenants), nil
+}
+
+func (s *MemoryTenantStore) SaveTenant(tenant Tenant) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.tenants = saveTenant(s.tenants, tenant)
+	return nil
+}
+
+func (s *MemoryTenantStore) DeleteTenant(id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.tenants = slices.DeleteFunc(s.tenants, func(t Tenant) bool {
+		return t.ID == id
+	})
+	return nil
+}
+
+// saveTenant replaces the tenant with the same id, or appends tenant.
+func saveTenant(tenants []Tenant, tenant Tenant) []Tenant {
+	i := slices.IndexFunc(tenants, func(t Tenant) bool {
+		return t.ID == tenant.ID
+	})
+	if i < 0 {
+		return append(tenants, tenant)
+	}
+	tenants[i] = tenant
+	return tenants
+}
+
+// NewJSONFileTenantStore creates a store backed by the file at path.
+// The file is created on first write, if it doesn't exist yet.
+func NewJSONFileTenantStore(path string) *JSONFileTenantStore {
+	return &JSONFileTenantStore{path: path}
+}
+
+func (s *JSONFileTenantStore) Tenants() ([]Tenant, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.read()
+}
+
+func (s *JSONFileTenantStore) SaveTenant(tenant Tenant) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	tenants, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.write(saveTenant(tenants, tenant))
+}
+
+func (s *JSONFileTenantStore) DeleteTenant(id string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	tenants, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.write(slices.DeleteFunc(tenants, func(t Tenant) bool {
+		return t.ID == id
+	}))
+}
+
+func (s *JSONFileTenantStore) read() (tenants []Tenant, err error) {
+	bs, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return tenants, nil
+}
+
+// write replaces the file atomically, it holds the key hashes, so it is
+// only readable by the owner.
+func (s *JSONFileTenantStore) write(tenants []Tenant) error {
+	if tenants == nil {
+		tenants = []Tenant{}
+	}
+	bs, err := json.MarshalIndent(tenants, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,203 @@
+package webmention_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestTenantHost(t *testing.T) {
+	store := webmention.NewJSONFileTenantStore(filepath.Join(t.TempDir(), "tenants.json"))
+	storages := map[string]*webmention.MemoryStorage{}
+	host := must(webmention.NewTenantHost(store,
+		webmention.WithTenantMountPoint("/hosted"),
+		webmention.WithTenantQuota(1),
+		webmention.WithRegistration(webmention.RegisterIndieAuth),
+		webmention.WithOperatorAuth(func(r *http.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer operator"
+		}),
+		webmention.WithTenantStorage(func(id string) (webmention.Storage, error) {
+			storages[id] = webmention.NewMemoryStorage()
+			return storages[id], nil
+		}),
+	))
+	defer host.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	mux.Handle("/hosted/", host)
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<a href="http://`+r.Host+`/target">target</a>`)
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", `</token>; rel="token_endpoint"`)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer indieauth" {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"me": "http://`+r.Host+`/me", "scope": "create"}`)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	register := func(token, body string) *http.Response {
+		req := must(http.NewRequest(http.MethodPost, ts.URL+"/hosted/tenants", strings.NewReader(body)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := must(http.DefaultClient.Do(req))
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp := register("forged", `{"id": "alice", "me": "`+ts.URL+`/me", "accept_domains": ["127.0.0.1"]}`); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("registered with an invalid token, status: %d", resp.StatusCode)
+	}
+	if resp := register("indieauth", `{"id": "alice", "me": "`+ts.URL+`/me", "accept_domains": ["example.com"]}`); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("registered a domain other than me, status: %d", resp.StatusCode)
+	}
+	if resp := register("", `{"id": "bob", "accept_domains": ["localhost"]}`); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("registered without IndieAuth, status: %d", resp.StatusCode)
+	}
+	resp := register("indieauth", `{"id": "alice", "me": "`+ts.URL+`/me", "accept_domains": ["127.0.0.1"]}`)
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("registration failed: %d: %s", resp.StatusCode, body)
+	}
+	var alice struct {
+		webmention.Tenant
+		APIKey string `json:"api_key"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&alice); err != nil || alice.APIKey == "" || alice.KeyHash != "" {
+		t.Fatalf("malformed registration: %+v, %v", alice, err)
+	}
+	if resp := register("operator", `{"id": "bob", "accept_domains": ["127.0.0.1"]}`); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("registered a domain taken by another tenant, status: %d", resp.StatusCode)
+	}
+	if resp := register("operator", `{"id": "bob", "accept_domains": ["localhost"]}`); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("operator registration failed, status: %d", resp.StatusCode)
+	}
+
+	mention := func(tenant, target string) int {
+		resp := must(http.PostForm(ts.URL+"/hosted/"+tenant+"/webmention", url.Values{
+			"source": {ts.URL + "/source"},
+			"target": {target},
+		}))
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := mention("bob", ts.URL+"/target"); code != http.StatusBadRequest {
+		t.Errorf("tenant accepted a mention of another tenant's domain, status: %d", code)
+	}
+	if code := mention("alice", ts.URL+"/target"); code != http.StatusAccepted {
+		t.Fatalf("mention not accepted, status: %d", code)
+	}
+	if code := mention("alice", ts.URL+"/other"); code != http.StatusTooManyRequests {
+		t.Errorf("quota not enforced, status: %d", code)
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(storages["alice"].Snapshot()) == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("mention not stored in time")
+		}
+	}
+	if n := len(storages["bob"].Snapshot()); n != 0 {
+		t.Errorf("mention stored in the namespace of another tenant: %d", n)
+	}
+
+	get := func(path, key string) *http.Response {
+		req := must(http.NewRequest(http.MethodGet, ts.URL+path, nil))
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp := must(http.DefaultClient.Do(req))
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	if resp := get("/hosted/alice/metrics", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("metrics not protected, status: %d", resp.StatusCode)
+	}
+	metrics := string(must(io.ReadAll(get("/hosted/alice/metrics", alice.APIKey).Body)))
+	if !strings.Contains(metrics, "webmention_tenant_accepted_today 1\n") || !strings.Contains(metrics, "webmention_tenant_daily_quota 1\n") {
+		t.Errorf("quota missing from metrics:\n%s", metrics)
+	}
+
+	req := must(http.NewRequest(http.MethodPut, ts.URL+"/hosted/tenants/alice", strings.NewReader(`{"accept_domains": ["127.0.0.1"], "daily_quota": 100}`)))
+	req.Header.Set("Authorization", "Bearer "+alice.APIKey)
+	resp = must(http.DefaultClient.Do(req))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update failed, status: %d", resp.StatusCode)
+	}
+	if tenant := must(host.Tenant("alice")); tenant.DailyQuota != 0 {
+		t.Errorf("tenant raised its own quota to %d", tenant.DailyQuota)
+	}
+
+	for _, relay := range []string{"http://127.0.0.1:8080/webmention", "http://localhost/webmention", "http://[::1]/", "http://10.0.0.1/", "http://169.254.169.254/"} {
+		req := must(http.NewRequest(http.MethodPut, ts.URL+"/hosted/tenants/alice", strings.NewReader(`{"accept_domains": ["127.0.0.1"], "relays": ["`+relay+`"]}`)))
+		req.Header.Set("Authorization", "Bearer "+alice.APIKey)
+		resp = must(http.DefaultClient.Do(req))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("relay to %s accepted, status: %d", relay, resp.StatusCode)
+		}
+	}
+
+	tenants := must(store.Tenants())
+	if len(tenants) != 2 || tenants[0].KeyHash == "" {
+		t.Errorf("tenants not persisted: %+v", tenants)
+	}
+}
+
+func TestTenantRegistrationRace(t *testing.T) {
+	store := &webmention.MemoryTenantStore{}
+	host := must(webmention.NewTenantHost(store,
+		webmention.WithRegistration(webmention.RegisterOpen),
+		webmention.WithTenantStorage(func(string) (webmention.Storage, error) {
+			time.Sleep(10 * time.Millisecond) // widen the window between the checks and starting the tenant
+			return webmention.NewMemoryStorage(), nil
+		}),
+	))
+	defer host.Shutdown(context.Background())
+	ts := httptest.NewServer(host)
+	defer ts.Close()
+
+	var (
+		wg      sync.WaitGroup
+		created atomic.Int32
+	)
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the first ten race for the same id, the others for the same domain
+			body := fmt.Sprintf(`{"id": "alice", "accept_domains": ["alice%d.example"]}`, i)
+			if i >= 10 {
+				body = fmt.Sprintf(`{"id": "bob%d", "accept_domains": ["bob.example"]}`, i)
+			}
+			resp := must(http.Post(ts.URL+"/tenants", "application/json", strings.NewReader(body)))
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusCreated {
+				created.Add(1)
+			} else if resp.StatusCode != http.StatusConflict {
+				t.Errorf("unexpected status: %d", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := created.Load(); n != 2 {
+		t.Errorf("expected 2 registrations to succeed, got %d", n)
+	}
+	if tenants := must(store.Tenants()); len(tenants) != 2 {
+		t.Errorf("expected 2 tenants stored, got %+v", tenants)
+	}
+}
@@ -0,0 +1,88 @@
+package webmention
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceParentHeader carries the W3C Trace Context of a request, so that the
+// journey of a mention can be correlated across sender and receiver.
+// The Sender starts a trace for every mention it posts, the Receiver
+// records the trace of a submission (see Mention.TraceParent), and
+// continues it when fetching the source.
+//
+// See: https://www.w3.org/TR/trace-context/
+const TraceParentHeader = "traceparent"
+
+// NewTraceParent starts a new (sampled) trace.
+func NewTraceParent() string {
+	var ids [24]byte
+	rand.Read(ids[:])
+	return "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01"
+}
+
+// TraceID returns the trace id of traceparent, empty if it is malformed.
+func TraceID(traceparent string) string {
+	if !validTraceParent(traceparent) {
+		return ""
+	}
+	return traceparent[3:35]
+}
+
+// childTraceParent continues the trace of parent with a new span, or starts
+// a new trace, if parent is malformed.
+func childTraceParent(parent string) string {
+	traceID := TraceID(parent)
+	if traceID == "" {
+		return NewTraceParent()
+	}
+	var spanID [8]byte
+	rand.Read(spanID[:])
+	return "00-" + traceID + "-" + hex.EncodeToString(spanID[:]) + "-" + parent[53:55]
+}
+
+// validTraceParent checks the format version-traceid-parentid-flags, later
+// versions may append further fields.
+func validTraceParent(traceparent string) bool {
+	if len(traceparent) < 55 || (len(traceparent) > 55 && (traceparent[:2] == "00" || traceparent[55] != '-')) {
+		return false
+	}
+	version, traceID, parentID, flags := traceparent[:2], traceparent[3:35], traceparent[36:52], traceparent[53:55]
+	if traceparent[2] != '-' || traceparent[35] != '-' || traceparent[52] != '-' || version == "ff" {
+		return false
+	}
+	for _, field := range []string{version, traceID, parentID, flags} {
+		if !isLowerHex(field) {
+			return false
+		}
+	}
+	return strings.Trim(traceID, "0") != "" && strings.Trim(parentID, "0") != ""
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// setTraceParent continues the trace of mention, if it has one.
+func setTraceParent(req *http.Request, mention Mention) {
+	if mention.TraceParent != "" {
+		req.Header.Set(TraceParentHeader, childTraceParent(mention.TraceParent))
+	}
+}
+
+// requestTraceParent returns the traceparent of r, empty if it has none, or
+// it is malformed.
+func requestTraceParent(r *http.Request) string {
+	traceparent := strings.TrimSpace(r.Header.Get(TraceParentHeader))
+	if !validTraceParent(traceparent) {
+		return ""
+	}
+	return traceparent
+}
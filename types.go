@@ -0,0 +1,140 @@
+package webmention
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+type (
+	// MentionType is the kind of interaction a mention represents, as
+	// declared by the microformats2 class of the source's link to the target.
+	MentionType string
+
+	// Counts are the number of (linking) mentions of a target, by type.
+	Counts struct {
+		Likes     int `json:"likes"`
+		Replies   int `json:"replies"`
+		Reposts   int `json:"reposts"`
+		Bookmarks int `json:"bookmarks"`
+		Mentions  int `json:"mentions"`
+		Total     int `json:"total"`
+	}
+
+	// A CountingStorage keeps Counts up to date as mentions are stored, so
+	// that they don't need to be computed from all stored mentions.
+	CountingStorage interface {
+		Storage
+		Counts(target string) (Counts, error)
+	}
+)
+
+const (
+	TypeMention  MentionType = "mention"
+	TypeLike     MentionType = "like"
+	TypeReply    MentionType = "reply"
+	TypeRepost   MentionType = "repost"
+	TypeBookmark MentionType = "bookmark"
+)
+
+// mentionTypeClasses maps microformats2 properties to mention types.
+var mentionTypeClasses = map[string]MentionType{
+	"u-like-of":      TypeLike,
+	"u-in-reply-to":  TypeReply,
+	"u-repost-of":    TypeRepost,
+	"u-bookmark-of":  TypeBookmark,
+	"u-favorite-of":  TypeLike,
+	"u-comment-of":   TypeReply,
+	"u-reposted-of":  TypeRepost,
+	"u-bookmarks-of": TypeBookmark,
+}
+
+// *JSONFileStorage implements CountingStorage
+var _ CountingStorage = (*JSONFileStorage)(nil)
+
+// ClassifyMention determines the type of a mention from the source content,
+// by looking at the class of the link(s) to target.
+// Content that isn't html, or doesn't declare a type, is a TypeMention.
+func ClassifyMention(content []byte, target URL) MentionType {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return TypeMention
+	}
+	want := strings.ToLower(target.String())
+	var traverseHtml func(*html.Node) MentionType
+	traverseHtml = func(node *html.Node) MentionType {
+		if node.Type == html.ElementNode && (node.Data == "a" || node.Data == "link") && strings.ToLower(findHref(node)) == want {
+			for _, a := range node.Attr {
+				if a.Key != "class" {
+					continue
+				}
+				for _, class := range strings.Fields(a.Val) {
+					if t, ok := mentionTypeClasses[class]; ok {
+						return t
+					}
+				}
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if t := traverseHtml(child); t != "" {
+				return t
+			}
+		}
+		return ""
+	}
+	if t := traverseHtml(doc); t != "" {
+		return t
+	}
+	return TypeMention
+}
+
+// Add counts mention, if it links to its target.
+func (c *Counts) Add(mention Mention) {
+	c.add(mention, 1)
+}
+
+// Remove undoes Add.
+func (c *Counts) Remove(mention Mention) {
+	c.add(mention, -1)
+}
+
+func (c *Counts) add(mention Mention, n int) {
+	if mention.Status != StatusLink {
+		return
+	}
+	switch mention.Type {
+	case TypeLike:
+		c.Likes += n
+	case TypeReply:
+		c.Replies += n
+	case TypeRepost:
+		c.Reposts += n
+	case TypeBookmark:
+		c.Bookmarks += n
+	default:
+		c.Mentions += n
+	}
+	c.Total += n
+}
+
+// Counts returns how often target has been mentioned, by type.
+// If the storage isn't a CountingStorage, all stored mentions of target are
+// scanned instead.
+func (receiver *Receiver) Counts(target string) (Counts, error) {
+	if receiver.storage == nil {
+		return Counts{}, ErrNoStorage
+	}
+	if storage, ok := receiver.storage.(CountingStorage); ok {
+		return storage.Counts(target)
+	}
+	mentions, err := receiver.storage.Mentions(MentionFilter{Target: target})
+	if err != nil {
+		return Counts{}, err
+	}
+	var counts Counts
+	for _, mention := range mentions {
+		counts.Add(mention)
+	}
+	return counts, nil
+}
@@ -0,0 +1,38 @@
+package webmention
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tomnomnom/linkheader"
+)
+
+// unavailableStatus reports whether a source answering with code is
+// unavailable for good, e.g., 451 Unavailable For Legal Reasons, so that
+// the mention is marked StatusUnavailable, instead of failing with
+// ErrSourceNotFound and being retried.
+// 404 Not Found is not, the source may not have been published yet, neither
+// are walls (see WithWallDetection) and temporary failures.
+func unavailableStatus(code int) bool {
+	switch code {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusRequestTimeout, http.StatusTooEarly:
+		return false
+	}
+	return code >= 400 && code < 500 && statusClass(code) == permanentTheirs
+}
+
+// unavailableReason describes why the source is unavailable: its status,
+// and who blocks it, if a 451 response names them (RFC 7725).
+func unavailableReason(resp *http.Response) string {
+	if resp.StatusCode == http.StatusUnavailableForLegalReasons {
+		for _, link := range linkheader.ParseMultiple(resp.Header.Values("Link")) {
+			for _, rel := range strings.Fields(link.Rel) {
+				if strings.EqualFold(rel, "blocked-by") {
+					return fmt.Sprintf("%s (blocked by %s)", resp.Status, link.URL)
+				}
+			}
+		}
+	}
+	return resp.Status
+}
@@ -0,0 +1,112 @@
+package webmention
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// A ContentPersister additionally remembers a hash of the source's content,
+// which is required for update detection (see WithUpdateDetection).
+type ContentPersister interface {
+	Persister
+
+	// ContentHash returns the hash recorded for source, or "" if there is none.
+	ContentHash(source URL) (string, error)
+
+	// SetContentHash replaces the hash recorded for source.
+	SetContentHash(source URL, hash string) error
+}
+
+// *KeyValuePersister implements ContentPersister
+var _ ContentPersister = (*KeyValuePersister)(nil)
+
+// WithUpdateDetection makes Update skip targets that are linked to both in
+// the past and current version of the source, unless the content of the
+// source actually changed.
+// New and removed targets are always mentioned.
+// The content is the first h-entry of the source, or the whole document if
+// it has none, and is compared by its hash, which is recorded by the
+// Persister. The persister must implement ContentPersister, otherwise
+// updates are sent unconditionally.
+func WithUpdateDetection() SenderOption {
+	return func(s *Sender) {
+		s.detectUpdates = true
+	}
+}
+
+func (p *KeyValuePersister) ContentHash(source URL) (string, error) {
+	value, _, err := p.Store.Get("content:" + source.String())
+	return value, err
+}
+
+func (p *KeyValuePersister) SetContentHash(source URL, hash string) error {
+	return p.Store.Set("content:"+source.String(), hash, 0)
+}
+
+// ContentHash returns a hash of the source's h-entry (or the whole document
+// if there is none).
+func (b *Batch) ContentHash() (string, error) {
+	doc, err := b.Document()
+	if err != nil {
+		return "", err
+	}
+	content := findHEntry(doc)
+	if content == nil {
+		content = doc
+	}
+	var buf bytes.Buffer
+	if err := html.Render(&buf, content); err != nil {
+		return "", fmt.Errorf("content hash: %w", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// contentChanged reports whether the source changed since the hash was last recorded.
+// The new hash is returned, so that it can be recorded once all mentions have been sent.
+// If anything goes wrong, the source is assumed to have changed.
+func (b *Batch) contentChanged() (changed bool, hash string) {
+	persister, ok := b.sender.persister.(ContentPersister)
+	if !b.sender.detectUpdates || !ok {
+		return true, ""
+	}
+	hash, err := b.ContentHash()
+	if err != nil {
+		return true, ""
+	}
+	previous, err := persister.ContentHash(b.Source)
+	if err != nil || previous == "" {
+		return true, hash
+	}
+	return previous != hash, hash
+}
+
+func findHEntry(node *html.Node) *html.Node {
+	if node.Type == html.ElementNode {
+		for _, a := range node.Attr {
+			if a.Key == "class" && hasClass(a.Val, "h-entry") {
+				return node
+			}
+		}
+	}
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if entry := findHEntry(child); entry != nil {
+			return entry
+		}
+	}
+	return nil
+}
+
+func hasClass(classes, class string) bool {
+	for _, c := range strings.Fields(classes) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
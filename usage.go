@@ -0,0 +1,324 @@
+package webmention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Usage is what a domain consumed in one month.
+	Usage struct {
+		Domain string `json:"domain"`
+		// Month is formatted as 2006-01.
+		Month string `json:"month"`
+		// Received counts the mentions of the domain's pages accepted by a receiver.
+		Received int `json:"received"`
+		// Sent counts the mentions of the domain's pages posted by a sender.
+		Sent int `json:"sent"`
+		// FetchedBytes is the size of the sources (and alternates) fetched
+		// to verify the received mentions.
+		FetchedBytes int64 `json:"fetched_bytes"`
+	}
+
+	// UsageQuota limits the monthly usage of a domain (0: no limit).
+	UsageQuota struct {
+		Received     int
+		Sent         int
+		FetchedBytes int64
+	}
+
+	// A UsageStore keeps the usage of every domain, by month.
+	UsageStore interface {
+		// AddUsage adds delta to the usage of delta.Domain in delta.Month.
+		AddUsage(delta Usage) error
+		// Usage returns the usage of all domains in month, ordered by domain.
+		Usage(month string) ([]Usage, error)
+	}
+
+	// UsageMeter accounts the mentions received and sent, and the bandwidth
+	// used to fetch sources, per domain (the host of the target, or of the
+	// source when sending), and enforces quotas on them.
+	// A meter may be shared by a receiver and a sender.
+	UsageMeter struct {
+		Store UsageStore
+		// Quota applies to all domains without a quota of their own in Quotas.
+		Quota  UsageQuota
+		Quotas map[string]UsageQuota
+		// Namespace is prepended to the domains in the store, so that
+		// meters can share it, e.g., one per tenant.
+		Namespace string
+	}
+
+	// MemoryUsageStore is a UsageStore that is kept in memory.
+	MemoryUsageStore struct {
+		m     sync.Mutex
+		usage []Usage
+	}
+
+	// JSONFileUsageStore keeps the usage in a JSON file, which is rewritten
+	// on every change, it is meant for small deployments.
+	JSONFileUsageStore struct {
+		m    sync.Mutex
+		path string
+	}
+
+	// quotaError is returned if a domain used up its monthly quota.
+	quotaError struct {
+		domain, what string
+	}
+)
+
+var (
+	// *MemoryUsageStore implements UsageStore
+	_ UsageStore = (*MemoryUsageStore)(nil)
+	// *JSONFileUsageStore implements UsageStore
+	_ UsageStore = (*JSONFileUsageStore)(nil)
+	// quotaError implements ErrorResponder
+	_ ErrorResponder = quotaError{}
+)
+
+// WithUsageMeter accounts the usage of every accept-domain with meter.
+// Mentions of a domain that used up its quota of received mentions, or of
+// fetched bytes, are answered with http.StatusTooManyRequests.
+func WithUsageMeter(meter *UsageMeter) ReceiverOption {
+	return func(r *Receiver) {
+		r.usage = meter
+	}
+}
+
+// WithSendUsageMeter accounts the mentions sent for every source domain with
+// meter, mentions beyond the quota fail with ErrMonthlyQuotaExceeded.
+func WithSendUsageMeter(meter *UsageMeter) SenderOption {
+	return func(s *Sender) {
+		s.usage = meter
+	}
+}
+
+// Usage returns the usage of the accept-domains in month (see UsageMonth).
+func (receiver *Receiver) Usage(month string) ([]Usage, error) {
+	if receiver.usage == nil {
+		return nil, ErrNoUsageMeter
+	}
+	return receiver.usage.Usage(month)
+}
+
+// UsageMonth returns the month t is accounted for.
+func UsageMonth(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// Usage returns the usage of all domains in month (see UsageMonth).
+func (meter *UsageMeter) Usage(month string) ([]Usage, error) {
+	all, err := meter.Store.Usage(month)
+	if err != nil {
+		return nil, fmt.Errorf("usage: %w", err)
+	}
+	usage := []Usage{}
+	for _, u := range all {
+		if domain, ok := strings.CutPrefix(u.Domain, meter.Namespace); ok {
+			u.Domain = domain
+			usage = append(usage, u)
+		}
+	}
+	return usage, nil
+}
+
+// DomainUsage returns the usage of domain in the current month.
+func (meter *UsageMeter) DomainUsage(domain string) (Usage, error) {
+	month := UsageMonth(time.Now())
+	usage, err := meter.Usage(month)
+	if err != nil {
+		return Usage{}, err
+	}
+	domain = strings.ToLower(domain)
+	for _, u := range usage {
+		if u.Domain == domain {
+			return u, nil
+		}
+	}
+	return Usage{Domain: domain, Month: month}, nil
+}
+
+// QuotaOf returns the quota of domain.
+func (meter *UsageMeter) QuotaOf(domain string) UsageQuota {
+	if quota, ok := meter.Quotas[strings.ToLower(domain)]; ok {
+		return quota
+	}
+	return meter.Quota
+}
+
+// allowReceive fails if domain used up its quota of received mentions, or
+// fetched bytes.
+func (meter *UsageMeter) allowReceive(domain string) error {
+	quota := meter.QuotaOf(domain)
+	if quota.Received == 0 && quota.FetchedBytes == 0 {
+		return nil
+	}
+	usage, err := meter.DomainUsage(domain)
+	if err != nil {
+		return err
+	}
+	switch {
+	case quota.Received > 0 && usage.Received >= quota.Received:
+		return quotaError{domain, "received mentions"}
+	case quota.FetchedBytes > 0 && usage.FetchedBytes >= quota.FetchedBytes:
+		return quotaError{domain, "fetched bytes"}
+	}
+	return nil
+}
+
+// allowSend fails if domain used up its quota of sent mentions.
+func (meter *UsageMeter) allowSend(domain string) error {
+	quota := meter.QuotaOf(domain)
+	if quota.Sent == 0 {
+		return nil
+	}
+	usage, err := meter.DomainUsage(domain)
+	if err != nil {
+		return err
+	}
+	if usage.Sent >= quota.Sent {
+		return quotaError{domain, "sent mentions"}
+	}
+	return nil
+}
+
+// add accounts delta to domain in the current month.
+func (meter *UsageMeter) add(domain string, delta Usage) {
+	if meter == nil {
+		return
+	}
+	delta.Domain = meter.Namespace + strings.ToLower(domain)
+	delta.Month = UsageMonth(time.Now())
+	if err := meter.Store.AddUsage(delta); err != nil {
+		slog.Error(fmt.Sprintf("usage: %s", err), "domain", domain)
+	}
+}
+
+func (e quotaError) Error() string {
+	return fmt.Sprintf("%s of %s: %s", ErrMonthlyQuotaExceeded, e.domain, e.what)
+}
+
+func (e quotaError) Unwrap() error {
+	return ErrMonthlyQuotaExceeded
+}
+
+func (e quotaError) RespondError(w http.ResponseWriter, r *http.Request) bool {
+	http.Error(w, e.Error(), http.StatusTooManyRequests)
+	return true
+}
+
+// addUsage adds delta to the usage of its domain and month.
+func addUsage(usage []Usage, delta Usage) []Usage {
+	i := slices.IndexFunc(usage, func(u Usage) bool {
+		return u.Domain == delta.Domain && u.Month == delta.Month
+	})
+	if i < 0 {
+		return append(usage, delta)
+	}
+	usage[i].Received += delta.Received
+	usage[i].Sent += delta.Sent
+	usage[i].FetchedBytes += delta.FetchedBytes
+	return usage
+}
+
+// monthUsage returns the usage in month, ordered by domain.
+func monthUsage(usage []Usage, month string) []Usage {
+	var selected []Usage
+	for _, u := range usage {
+		if u.Month == month {
+			selected = append(selected, u)
+		}
+	}
+	slices.SortFunc(selected, func(a, b Usage) int {
+		return strings.Compare(a.Domain, b.Domain)
+	})
+	return selected
+}
+
+func (s *MemoryUsageStore) AddUsage(delta Usage) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.usage = addUsage(s.usage, delta)
+	return nil
+}
+
+func (s *MemoryUsageStore) Usage(month string) ([]Usage, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return monthUsage(s.usage, month), nil
+}
+
+// NewJSONFileUsageStore creates a store backed by the file at path.
+// The file is created on first write, if it doesn't exist yet.
+func NewJSONFileUsageStore(path string) *JSONFileUsageStore {
+	return &JSONFileUsageStore{path: path}
+}
+
+func (s *JSONFileUsageStore) AddUsage(delta Usage) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	usage, err := s.read()
+	if err != nil {
+		return err
+	}
+	return s.write(addUsage(usage, delta))
+}
+
+func (s *JSONFileUsageStore) Usage(month string) ([]Usage, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	usage, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return monthUsage(usage, month), nil
+}
+
+func (s *JSONFileUsageStore) read() (usage []Usage, err error) {
+	bs, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bs, &usage); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return usage, nil
+}
+
+// write replaces the file atomically.
+func (s *JSONFileUsageStore) write(usage []Usage) error {
+	if usage == nil {
+		usage = []Usage{}
+	}
+	bs, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bs); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,90 @@
+package webmention_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	webmention "github.com/cvanloo/gowebmention"
+)
+
+func TestUsageMeter(t *testing.T) {
+	meter := &webmention.UsageMeter{
+		Store: webmention.NewJSONFileUsageStore(filepath.Join(t.TempDir(), "usage.json")),
+		Quota: webmention.UsageQuota{Received: 1, Sent: 1},
+	}
+	receiver := webmention.NewReceiver(
+		webmention.WithAcceptsFunc(accepts),
+		webmention.WithUsageMeter(meter),
+	)
+	go receiver.ProcessMentions()
+	defer receiver.Shutdown(context.Background())
+
+	mux := http.NewServeMux()
+	mux.Handle("/wm/", webmention.NewReceiverHandler(receiver,
+		webmention.WithMountPoint("/wm"),
+		webmention.WithRoutes(webmention.RouteWebmention|webmention.RouteUsage),
+	))
+	mux.HandleFunc("/source", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<a href="http://`+r.Host+`/target">target</a>`)
+	})
+	mux.HandleFunc("/target/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</endpoint>; rel=webmention")
+	})
+	mux.HandleFunc("/endpoint", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	mention := func(target string) int {
+		resp := must(http.PostForm(ts.URL+"/wm/webmention", url.Values{
+			"source": {ts.URL + "/source"},
+			"target": {ts.URL + target},
+		}))
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := mention("/target"); code != http.StatusAccepted {
+		t.Fatalf("mention not accepted, status: %d", code)
+	}
+	if code := mention("/other"); code != http.StatusTooManyRequests {
+		t.Errorf("quota not enforced, status: %d", code)
+	}
+
+	var usage []webmention.Usage
+	for deadline := time.Now().Add(5 * time.Second); len(usage) == 0 || usage[0].FetchedBytes == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("fetched bytes not accounted in time: %+v", usage)
+		}
+		resp := must(http.Get(ts.URL + "/wm/usage"))
+		err := json.NewDecoder(resp.Body).Decode(&usage)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(usage) != 1 || usage[0].Domain != "127.0.0.1" || usage[0].Received != 1 || usage[0].Month != webmention.UsageMonth(time.Now()) {
+		t.Errorf("incorrect usage: %+v", usage)
+	}
+
+	sender := webmention.NewSender(webmention.WithSendUsageMeter(meter))
+	source := must(url.Parse("https://example.com/post"))
+	if err := sender.Mention(source, must(url.Parse(ts.URL+"/target/1"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.Mention(source, must(url.Parse(ts.URL+"/target/2"))); !errors.Is(err, webmention.ErrMonthlyQuotaExceeded) {
+		t.Errorf("quota of sent mentions not enforced, got: %v", err)
+	}
+	if sent := must(meter.DomainUsage("example.com")); sent.Sent != 1 {
+		t.Errorf("incorrect sent count, got: %d, want: 1", sent.Sent)
+	}
+}
@@ -0,0 +1,84 @@
+package webmention
+
+import (
+	"cmp"
+	"runtime/debug"
+	"sync"
+)
+
+// modulePath is the path of this module, used to find it in the build info.
+const modulePath = "github.com/cvanloo/gowebmention"
+
+// Set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/cvanloo/gowebmention.version=v1.2.3 -X github.com/cvanloo/gowebmention.commit=$(git rev-parse HEAD) -X github.com/cvanloo/gowebmention.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Whatever is not set is taken from the build info embedded by the Go toolchain, if available.
+var version, commit, date string
+
+// BuildInfo describes the build of this package.
+type BuildInfo struct {
+	// Version is the module version, e.g., v1.2.3, or "(devel)".
+	Version string `json:"version"`
+	// Commit is the vcs revision, and Date the time of the commit (or the build).
+	Commit string `json:"commit,omitempty"`
+	Date   string `json:"date,omitempty"`
+}
+
+var buildInfo = sync.OnceValue(func() BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, Date: date}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if bi.Main.Path == modulePath {
+			info.Version = cmp.Or(info.Version, bi.Main.Version)
+			for _, setting := range bi.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					info.Commit = cmp.Or(info.Commit, setting.Value)
+				case "vcs.time":
+					info.Date = cmp.Or(info.Date, setting.Value)
+				}
+			}
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				if dep.Replace != nil {
+					dep = dep.Replace
+				}
+				info.Version = cmp.Or(info.Version, dep.Version)
+			}
+		}
+	}
+	info.Version = cmp.Or(info.Version, "(devel)")
+	return info
+})
+
+// Version returns the version of this package, e.g., v1.2.3.
+func Version() string {
+	return buildInfo().Version
+}
+
+// Build returns the version, commit, and date of this package's build.
+func Build() BuildInfo {
+	return buildInfo()
+}
+
+// String formats the build info for --version output, e.g., "v1.2.3 (commit abc1234, 2025-01-02T03:04:05Z)".
+func (info BuildInfo) String() string {
+	s := info.Version
+	if info.Commit == "" && info.Date == "" {
+		return s
+	}
+	s += " ("
+	if info.Commit != "" {
+		s += "commit " + info.Commit[:min(len(info.Commit), 12)]
+		if info.Date != "" {
+			s += ", "
+		}
+	}
+	return s + info.Date + ")"
+}
+
+// defaultUserAgent is the User-Agent of senders and receivers, unless configured otherwise.
+func defaultUserAgent() string {
+	return "Webmention (github.com/cvanloo/gowebmention " + Version() + ")"
+}
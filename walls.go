@@ -0,0 +1,126 @@
+package webmention
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// wallPageSize is the size up to which a page is checked for the phrases of
+// a login or consent wall, larger pages are assumed to have real content.
+const wallPageSize = 16 << 10
+
+// wallMaxLinks is the number of links up to which a page is checked for the
+// phrases of a login or consent wall, real pages tend to link more.
+const wallMaxLinks = 5
+
+var (
+	// wallPhrases are typical for pages that hide the content behind a
+	// login, or until cookies are accepted.
+	wallPhrases = []string{
+		"please sign in", "please log in", "please login",
+		"sign in to continue", "log in to continue", "login to continue",
+		"you must be logged in", "you need to be logged in", "you need to sign in",
+		"login required", "members only",
+		"before you continue", "accept all cookies", "we value your privacy",
+		"cookie consent", "consent to the use of cookies",
+	}
+
+	// wallSegments are path segments (and host labels) of login and consent pages.
+	wallSegments = map[string]bool{
+		"login": true, "log-in": true, "logon": true, "signin": true, "sign-in": true, "sign_in": true,
+		"sso": true, "auth": true, "oauth": true, "authorize": true, "session": true,
+		"consent": true, "cookie-consent": true, "accounts": true,
+	}
+
+	metaRefresh   = regexp.MustCompile(`(?i)<meta[^>]+http-equiv=["']?refresh["']?[^>]*content=["']?\s*\d*\s*;\s*url\s*=\s*["']?([^"'>\s]+)`)
+	passwordInput = regexp.MustCompile(`(?i)<input[^>]+type=["']?password`)
+	anchorTag     = regexp.MustCompile(`(?i)<a\s`)
+)
+
+// WithWallDetection enables or disables the heuristics detecting sources
+// behind a login or consent wall (enabled by default).
+// Instead of StatusNoLink, such sources are classified as
+// StatusInaccessible, and retried later, in case the wall is temporary.
+// The heuristics apply only to sources that don't link to the target:
+//   - the source redirects to a login or consent page
+//   - the source refreshes to a login or consent page (<meta http-equiv="refresh">)
+//   - the source is a small page with a password field, or with few links
+//     and a phrase like "please sign in" or "accept all cookies"
+//
+// Sources answering with http.StatusUnauthorized or http.StatusForbidden
+// are always classified as StatusInaccessible.
+func WithWallDetection(enabled bool) ReceiverOption {
+	return func(r *Receiver) {
+		r.ignoreWalls = !enabled
+	}
+}
+
+// inaccessibleError is returned if the source is hidden behind a wall.
+type inaccessibleError struct {
+	reason string
+}
+
+func (e inaccessibleError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSourceInaccessible, e.reason)
+}
+
+func (e inaccessibleError) Unwrap() error {
+	return ErrSourceInaccessible
+}
+
+// sourceStatusError returns the error for a source answering with an
+// unsuccessful status code.
+func sourceStatusError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return inaccessibleError{fmt.Sprintf("source answered with %s", resp.Status)}
+	}
+	return reclassifiedError{ErrSourceNotFound, statusClass(resp.StatusCode)}
+}
+
+// detectWall reports why the content of source, fetched from final (after
+// following redirects), looks like a login or consent wall, empty if it doesn't.
+func detectWall(source, final URL, content []byte) string {
+	if final != nil && !samePage(source, final) && isWallURL(final) {
+		return "redirected to " + final.String()
+	}
+	if match := metaRefresh.FindSubmatch(content); match != nil {
+		if refresh, err := source.Parse(string(match[1])); err == nil && isWallURL(refresh) {
+			return "refreshes to " + refresh.String()
+		}
+	}
+	if len(content) > wallPageSize {
+		return ""
+	}
+	if passwordInput.Match(content) {
+		return "asks for a password"
+	}
+	if len(anchorTag.FindAllIndex(content, wallMaxLinks+1)) > wallMaxLinks {
+		return ""
+	}
+	lower := bytes.ToLower(content)
+	for _, phrase := range wallPhrases {
+		if bytes.Contains(lower, []byte(phrase)) {
+			return fmt.Sprintf("asks to %q", phrase)
+		}
+	}
+	return ""
+}
+
+// isWallURL reports whether u looks like a login or consent page, e.g.,
+// https://example.com/login?next=/post or https://consent.example.com/
+func isWallURL(u *url.URL) bool {
+	if label, _, _ := strings.Cut(strings.ToLower(u.Hostname()), "."); wallSegments[label] {
+		return true
+	}
+	for _, segment := range strings.Split(strings.ToLower(u.Path), "/") {
+		segment, _, _ = strings.Cut(segment, ".") // login.php
+		if wallSegments[segment] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package webmention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// WellKnownPath is where a site publishes its WellKnownPolicy.
+const WellKnownPath = "/.well-known/webmention"
+
+type (
+	// WellKnownPolicy describes how a site would like to be mentioned.
+	// It is published as JSON at WellKnownPath.
+	// This is an interop experiment, and not part of the Webmention
+	// specification, so other implementations will neither publish nor
+	// respect it.
+	WellKnownPolicy struct {
+		// Endpoint is used for pages of the site that don't declare an
+		// endpoint themselves.
+		// An endpoint declared by a page always takes precedence.
+		Endpoint string `json:"endpoint,omitempty"`
+		// RateLimit is the number of mentions per minute that a sender
+		// should post at most (0: no limit).
+		RateLimit int `json:"rate_limit,omitempty"`
+	}
+
+	// sitePolicy is the policy of a site, as known to the sender.
+	sitePolicy struct {
+		policy  WellKnownPolicy
+		fetched time.Time
+		next    time.Time // earliest time the next mention may be posted
+	}
+)
+
+const (
+	// wellKnownTTL is how long the policy of a site is remembered.
+	wellKnownTTL = 24 * time.Hour
+	// maxThrottleWait is how long a mention is delayed at most to respect
+	// the rate limit of a site, mentions that would have to wait longer fail
+	// with ErrRateLimited.
+	maxThrottleWait = time.Minute
+)
+
+// WellKnownHandler publishes policy, mount it at WellKnownPath.
+func WellKnownHandler(policy WellKnownPolicy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(wellKnownTTL.Seconds())))
+		writeJSON(w, policy)
+	})
+}
+
+// WithWellKnownPolicy reads the WellKnownPolicy of every target's site, and
+// follows its hints: pages without an endpoint are mentioned through the
+// site's endpoint, and mentions are delayed to respect the rate limit.
+// A mention is delayed for at most a minute (and never beyond the delivery
+// timeout), mentions beyond that fail with ErrRateLimited, to be retried later.
+// Policies are fetched once a day, sites without one are not affected.
+func WithWellKnownPolicy() SenderOption {
+	return func(s *Sender) {
+		s.policies = map[string]*sitePolicy{}
+	}
+}
+
+// sitePolicy returns the policy of target's site, fetching it if necessary.
+// Errors are only logged, a site without a (valid) policy has an empty one.
+// The returned policy must only be accessed while holding policiesMu.
+func (sender *Sender) sitePolicy(target URL) *sitePolicy {
+	site := target.Scheme + "://" + target.Host
+	sender.policiesMu.Lock()
+	cached, ok := sender.policies[site]
+	fresh := ok && time.Since(cached.fetched) < wellKnownTTL
+	sender.policiesMu.Unlock()
+	if fresh {
+		return cached
+	}
+	policy, err := sender.fetchPolicy(site)
+	if err != nil {
+		slog.Warn("well-known policy", "site", site, "error", err)
+	}
+	sender.policiesMu.Lock()
+	defer sender.policiesMu.Unlock()
+	if cached, ok := sender.policies[site]; ok {
+		cached.policy, cached.fetched = policy, time.Now()
+		return cached
+	}
+	fetched := &sitePolicy{policy: policy, fetched: time.Now()}
+	sender.policies[site] = fetched
+	return fetched
+}
+
+func (sender *Sender) fetchPolicy(site string) (policy WellKnownPolicy, err error) {
+	ctx, cancel := timeoutContext(sender.discoveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site+WellKnownPath, nil)
+	if err != nil {
+		return policy, err
+	}
+	req.Header.Set("User-Agent", sender.UserAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := sender.HttpClient.Do(req)
+	if err != nil {
+		return policy, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return policy, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return policy, fmt.Errorf("get returned: %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&policy); err != nil {
+		return WellKnownPolicy{}, fmt.Errorf("invalid policy: %w", err)
+	}
+	return policy, nil
+}
+
+// policyEndpoint returns the endpoint the site of target prefers, if any.
+func (sender *Sender) policyEndpoint(target URL) (URL, bool) {
+	if sender.policies == nil {
+		return nil, false
+	}
+	site := sender.sitePolicy(target)
+	sender.policiesMu.Lock()
+	endpoint := site.policy.Endpoint
+	sender.policiesMu.Unlock()
+	if endpoint == "" {
+		return nil, false
+	}
+	u, err := target.Parse(endpoint)
+	if err != nil {
+		slog.Warn("well-known policy: invalid endpoint", "target", target.String(), "endpoint", endpoint, "error", err)
+		return nil, false
+	}
+	return u, true
+}
+
+// throttle waits until the rate limit of target's site allows another mention.
+// It fails with ErrRateLimited instead of waiting longer than maxThrottleWait,
+// and stops waiting once ctx is done.
+func (sender *Sender) throttle(ctx context.Context, target URL) error {
+	if sender.policies == nil {
+		return nil
+	}
+	site := sender.sitePolicy(target)
+	sender.policiesMu.Lock()
+	if site.policy.RateLimit <= 0 {
+		sender.policiesMu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	wait := site.next.Sub(now)
+	if wait > maxThrottleWait {
+		sender.policiesMu.Unlock()
+		return fmt.Errorf("%w: %s allows %d mentions per minute", ErrRateLimited, target.Host, site.policy.RateLimit)
+	}
+	if site.next.Before(now) {
+		site.next = now
+	}
+	site.next = site.next.Add(time.Minute / time.Duration(site.policy.RateLimit))
+	sender.policiesMu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
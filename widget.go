@@ -0,0 +1,124 @@
+package webmention
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+type (
+	// WidgetData is what the widget route serves for a target.
+	WidgetData struct {
+		Target   string          `json:"target"`
+		Counts   Counts          `json:"counts"`
+		Mentions []WidgetMention `json:"mentions"`
+	}
+
+	// WidgetMention is the public part of a mention.
+	WidgetMention struct {
+		Source   string      `json:"source"`
+		Type     MentionType `json:"type"`
+		Received time.Time   `json:"received"`
+		// Written is when the source was written, see Mention.Written.
+		Written time.Time `json:"written"`
+	}
+)
+
+//go:embed widget.js
+var widgetScript []byte
+
+// jsonpCallback restricts callback names to plain (dotted) identifiers.
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]{0,63}$`)
+
+const (
+	defaultWidgetMentions = 50
+	widgetCacheControl    = "public, max-age=300"
+)
+
+// Widget returns the counts and the latest mentions linking to target.
+func (receiver *Receiver) Widget(target string, limit int) (WidgetData, error) {
+	counts, err := receiver.Counts(target)
+	if err != nil {
+		return WidgetData{}, err
+	}
+	page, err := receiver.QueryMentions(MentionQuery{
+		Filter: MentionFilter{Target: target, Status: StatusLink},
+		Newest: true,
+		Limit:  limit,
+	})
+	if err != nil {
+		return WidgetData{}, err
+	}
+	data := WidgetData{Target: target, Counts: counts, Mentions: make([]WidgetMention, len(page.Mentions))}
+	for i, mention := range page.Mentions {
+		typ := mention.Type
+		if typ == "" {
+			typ = TypeMention
+		}
+		data.Mentions[i] = WidgetMention{Source: mention.Source.String(), Type: typ, Received: mention.Received, Written: mention.Written()}
+	}
+	return data, nil
+}
+
+func (h *receiverHandler) widgetJSON(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target: required", http.StatusBadRequest)
+		return
+	}
+	limit := defaultWidgetMentions
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit: expected a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	callback := params.Get("callback")
+	if callback != "" && !jsonpCallback.MatchString(callback) {
+		http.Error(w, "callback: invalid name", http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.receiver.Widget(target, limit)
+	if err != nil {
+		if errors.Is(err, ErrNoStorage) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", widgetCacheControl)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if callback == "" {
+		writeJSON(w, data)
+		return
+	}
+	bs, err := json.Marshal(data)
+	if err != nil {
+		slog.Error(err.Error(), "path", r.URL.EscapedPath())
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Write([]byte("/**/" + callback + "("))
+	w.Write(bs)
+	w.Write([]byte(");"))
+}
+
+func (h *receiverHandler) widgetScript(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", widgetCacheControl)
+	w.Header().Set("Cross-Origin-Resource-Policy", "cross-origin")
+	w.Write(widgetScript)
+}
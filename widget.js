@@ -0,0 +1,48 @@
+// Renders webmentions of the current page.
+// Include with:
+//   <script src="https://mentionee.example.com/wm/widget.js" async></script>
+// Optional attributes on the script tag:
+//   data-target: the page to show mentions for (default: the current page)
+//   data-container: id of the element to render into (default: right after the script tag)
+(function () {
+  "use strict";
+  var script = document.currentScript;
+  if (!script) {
+    return;
+  }
+  var target = script.getAttribute("data-target") || location.href.split("#")[0];
+  var endpoint = script.src.replace(/widget\.js(\?.*)?$/, "widget.json");
+  var labels = { like: "liked", reply: "replied", repost: "reposted", bookmark: "bookmarked", mention: "mentioned" };
+
+  function render(data) {
+    var container = document.getElementById(script.getAttribute("data-container") || "");
+    if (!container) {
+      container = document.createElement("div");
+      script.parentNode.insertBefore(container, script.nextSibling);
+    }
+    container.className += " webmentions";
+    var summary = document.createElement("p");
+    summary.className = "webmentions-counts";
+    summary.textContent = data.counts.total + " mentions (" +
+      data.counts.likes + " likes, " + data.counts.replies + " replies, " + data.counts.reposts + " reposts)";
+    container.appendChild(summary);
+    var list = document.createElement("ul");
+    data.mentions.forEach(function (mention) {
+      var item = document.createElement("li");
+      item.className = "webmention webmention-" + mention.type;
+      var link = document.createElement("a");
+      link.href = mention.source;
+      link.rel = "nofollow ugc";
+      link.textContent = new URL(mention.source).hostname;
+      item.appendChild(link);
+      item.appendChild(document.createTextNode(" " + (labels[mention.type] || labels.mention) + " on " + mention.received.slice(0, 10)));
+      list.appendChild(item);
+    });
+    container.appendChild(list);
+  }
+
+  fetch(endpoint + "?target=" + encodeURIComponent(target))
+    .then(function (resp) { return resp.ok ? resp.json() : Promise.reject(resp.status); })
+    .then(render)
+    .catch(function (err) { console.warn("webmentions: " + err); });
+})();